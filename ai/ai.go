@@ -0,0 +1,214 @@
+// Package ai reviews CloudPact source with a language model. A Provider
+// sends a prompt built from the parsed file and its why clauses; the reply
+// is a list of suggestions in the shape of the ai-* annotations.
+package ai
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// CacheDir is where reviews are written, relative to the project root
+var CacheDir = filepath.Join("cmd", "ai-integration", "cache")
+
+// Annotation types a suggestion can have, matching ai-<type> annotations
+var AnnotationTypes = []string{"feedback", "suggests", "security", "performance"}
+
+// Provider completes a prompt with a language model
+type Provider interface {
+	Name() string
+	Complete(ctx context.Context, prompt *Prompt) (string, error)
+}
+
+// Review is the outcome of reviewing one .cp file
+type Review struct {
+	File        string        `json:"file"`
+	Provider    string        `json:"provider"`
+	Created     time.Time     `json:"created"`
+	Suggestions []*Suggestion `json:"suggestions"`
+}
+
+// Suggestion statuses
+const (
+	StatusPending  = "pending"
+	StatusAccepted = "accepted"
+)
+
+// Suggestion is one review comment, attached to a declaration
+type Suggestion struct {
+	ID          string `json:"id"`
+	File        string `json:"file"`
+	Span        Span   `json:"span"`   // the lines Replacement replaces
+	Type        string `json:"type"`   // one of AnnotationTypes
+	Target      string `json:"target"` // the record, model or function it is about
+	Content     string `json:"content"`
+	Replacement string `json:"replacement,omitempty"` // proposed CloudPact code, if any
+	Status      string `json:"status"`
+}
+
+// Span is a range of whole lines, 1-based and inclusive
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// ReviewFile asks provider to review source, read from path
+func ReviewFile(ctx context.Context, provider Provider, path string, source []byte) (*Review, error) {
+	file, err := grammar.ParseWithFilename(bytes.NewReader(source), path)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := BuildPrompt(path, file)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := provider.Complete(ctx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", provider.Name(), err)
+	}
+	suggestions, err := parseSuggestions(reply)
+	if err != nil {
+		return nil, fmt.Errorf("%s returned an unreadable review: %w", provider.Name(), err)
+	}
+	spans := declarationSpans(file, source)
+	for _, s := range suggestions {
+		s.File = filepath.ToSlash(path)
+		if span, ok := spans[s.Target]; ok {
+			s.Span = span
+		}
+		s.Status = StatusPending
+		s.ID = suggestionID(path, s)
+	}
+	return &Review{
+		File:        filepath.ToSlash(path),
+		Provider:    provider.Name(),
+		Created:     time.Now().UTC(),
+		Suggestions: suggestions,
+	}, nil
+}
+
+// replySuggestion is a suggestion as the model writes it
+type replySuggestion struct {
+	Type        string `json:"type"`
+	Target      string `json:"target"`
+	Line        int    `json:"line"`
+	Content     string `json:"content"`
+	Replacement string `json:"replacement"`
+}
+
+// parseSuggestions reads the JSON object of a reply, ignoring any text or
+// code fences around it, and drops suggestions of unknown types
+func parseSuggestions(reply string) ([]*Suggestion, error) {
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object in %q", reply)
+	}
+	var parsed struct {
+		Suggestions []*replySuggestion `json:"suggestions"`
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &parsed); err != nil {
+		return nil, err
+	}
+
+	suggestions := []*Suggestion{}
+	for _, r := range parsed.Suggestions {
+		if r == nil {
+			continue
+		}
+		s := &Suggestion{
+			Type:        strings.TrimPrefix(strings.ToLower(r.Type), "ai-"),
+			Target:      r.Target,
+			Span:        Span{Start: r.Line, End: r.Line},
+			Content:     r.Content,
+			Replacement: r.Replacement,
+		}
+		if knownType(s.Type) && strings.TrimSpace(s.Content) != "" {
+			suggestions = append(suggestions, s)
+		}
+	}
+	return suggestions, nil
+}
+
+// declarationSpans finds the lines of each top-level declaration: from its
+// first line to the last non-blank line before the next one
+func declarationSpans(file *grammar.File, source []byte) map[string]Span {
+	type start struct {
+		name string
+		line int
+	}
+	var starts []start
+	add := func(name string, pos *grammar.Position) {
+		if pos != nil {
+			starts = append(starts, start{name, pos.Line})
+		}
+	}
+	for _, record := range file.Records {
+		add(record.Name, record.Position)
+	}
+	for _, model := range file.Models {
+		add(model.Name, model.Position)
+	}
+	for _, typeDef := range file.TypeDefs {
+		add(typeDef.Name, typeDef.Position)
+	}
+	for _, function := range file.Functions {
+		add(function.Name, function.Position)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].line < starts[j].line })
+
+	lines := strings.Split(string(source), "\n")
+	spans := make(map[string]Span)
+	for i, s := range starts {
+		end := len(lines)
+		if i+1 < len(starts) {
+			end = starts[i+1].line - 1
+		}
+		for end > s.line && strings.TrimSpace(lines[end-1]) == "" {
+			end--
+		}
+		spans[s.name] = Span{Start: s.line, End: end}
+	}
+	return spans
+}
+
+func knownType(t string) bool {
+	for _, known := range AnnotationTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestionID derives a short stable ID, so reviewing an unchanged file
+// again gives the same suggestions the same IDs
+func suggestionID(path string, s *Suggestion) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{filepath.ToSlash(path), s.Type, s.Target, s.Content, s.Replacement}, "\x00")))
+	return hex.EncodeToString(sum[:4])
+}
+
+// WriteReview stores review in dir as <source base name>.review.json and
+// returns the path written
+func WriteReview(dir string, review *Review) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(review, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	base := strings.TrimSuffix(filepath.Base(review.File), ".cp")
+	path := filepath.Join(dir, base+".review.json")
+	return path, os.WriteFile(path, append(data, '\n'), 0644)
+}
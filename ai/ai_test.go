@@ -0,0 +1,244 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+const source = `function shippingCost(weight: number) returns number
+    why: "Charges by weight"
+    do:
+        if weight < 0
+            then fail "weight must not be negative"
+        return weight * 2
+
+function discount(total: number) returns number
+    why: "Takes 10% off"
+    do:
+        return total * 0.9
+`
+
+func TestBuildPrompt(t *testing.T) {
+	file, err := grammar.ParseString(source)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	prompt, err := BuildPrompt("services/shipping.cp", file)
+	if err != nil {
+		t.Fatalf("BuildPrompt: %v", err)
+	}
+	for _, want := range []string{"File: services/shipping.cp", "- shippingCost: Charges by weight", `"name": "discount"`} {
+		if !strings.Contains(prompt.User, want) {
+			t.Fatalf("expected %q in prompt:\n%s", want, prompt.User)
+		}
+	}
+	if !strings.Contains(prompt.System, "feedback, suggests, security, performance") {
+		t.Fatalf("system prompt should list annotation types:\n%s", prompt.System)
+	}
+}
+
+func TestReviewWithMock(t *testing.T) {
+	review, err := ReviewFile(context.Background(), &Mock{}, "shipping.cp", []byte(source))
+	if err != nil {
+		t.Fatalf("ReviewFile: %v", err)
+	}
+	if len(review.Suggestions) != 1 || review.Suggestions[0].Target != "discount" ||
+		review.Suggestions[0].Span != (Span{Start: 8, End: 11}) || review.Suggestions[0].Status != StatusPending {
+		t.Fatalf("expected a suggestion for discount, got %+v", review.Suggestions[0])
+	}
+	again, _ := ReviewFile(context.Background(), &Mock{}, "shipping.cp", []byte(source))
+	if again.Suggestions[0].ID != review.Suggestions[0].ID {
+		t.Fatal("expected stable suggestion IDs")
+	}
+
+	dir := t.TempDir()
+	path, err := WriteReview(dir, review)
+	if err != nil {
+		t.Fatalf("WriteReview: %v", err)
+	}
+	if path != filepath.Join(dir, "shipping.review.json") {
+		t.Fatalf("unexpected review path %s", path)
+	}
+	data, _ := os.ReadFile(path)
+	var stored Review
+	if err := json.Unmarshal(data, &stored); err != nil || len(stored.Suggestions) != 1 {
+		t.Fatalf("unreadable review %s: %v", data, err)
+	}
+}
+
+func TestParseSuggestions(t *testing.T) {
+	reply := "Here you go:\n```json\n" + `{"suggestions": [
+  {"type": "ai-security", "target": "login", "line": 3, "content": "Rate limit attempts"},
+  {"type": "praise", "target": "login", "content": "Nice"},
+  {"type": "suggests", "target": "login", "content": ""}
+]}` + "\n```"
+	suggestions, err := parseSuggestions(reply)
+	if err != nil {
+		t.Fatalf("parseSuggestions: %v", err)
+	}
+	if len(suggestions) != 1 || suggestions[0].Type != "security" || suggestions[0].Span.Start != 3 {
+		t.Fatalf("expected only the security suggestion, got %+v", suggestions)
+	}
+	if _, err := parseSuggestions("no idea"); err == nil {
+		t.Fatal("expected an error for a reply without JSON")
+	}
+}
+
+func TestAnthropicProvider(t *testing.T) {
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "key" || r.Header.Get("anthropic-version") == "" {
+			t.Errorf("missing auth headers: %v", r.Header)
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"content": [{"type": "text", "text": "{\"suggestions\": []}"}]}`))
+	}))
+	defer server.Close()
+
+	provider := &Anthropic{APIKey: "key", Model: "test-model", Endpoint: server.URL}
+	reply, err := provider.Complete(context.Background(), &Prompt{System: "sys", User: "user"})
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if reply != `{"suggestions": []}` {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	if request["model"] != "test-model" || request["system"] != "sys" {
+		t.Fatalf("unexpected request %v", request)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	if _, err := NewProvider(Config{Provider: "openai"}); err == nil {
+		t.Fatal("expected an error without OPENAI_API_KEY")
+	}
+	if _, err := NewProvider(Config{Provider: "gemini"}); err == nil {
+		t.Fatal("expected an error for an unknown provider")
+	}
+	if _, err := NewProvider(Config{}); err == nil || !strings.Contains(err.Error(), "no AI provider is configured") {
+		t.Fatalf("expected an error without a provider, got %v", err)
+	}
+	if provider, err := NewProvider(Config{Provider: "mock"}); err != nil || provider.Name() != "mock" {
+		t.Fatalf("expected the mock when named, got %v, %v", provider, err)
+	}
+	provider, err := NewProvider(Config{Provider: "ollama"})
+	if err != nil || provider.(*Ollama).Model != DefaultOllamaModel {
+		t.Fatalf("expected the default Ollama model, got %v, %v", provider, err)
+	}
+}
+
+func TestStoreAndApply(t *testing.T) {
+	reply := `{"suggestions": [
+  {"type": "suggests", "target": "discount", "content": "Reject negative \"totals\"", "replacement": "function discount(total: number) returns number\n    why: \"Takes 10% off\"\n    do:\n        if total < 0\n            then fail \"negative total\"\n        return total * 0.9"},
+  {"type": "feedback", "target": "shippingCost", "content": "Looks right"}
+]}`
+	review, err := ReviewFile(context.Background(), &Mock{Reply: reply}, "shipping.cp", []byte(source))
+	if err != nil {
+		t.Fatalf("ReviewFile: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "suggestions.json")
+	store, _ := LoadStore(path)
+	store.Add(review)
+	if err := store.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	store, err = LoadStore(path)
+	if err != nil || len(store.Pending()) != 2 {
+		t.Fatalf("expected 2 pending suggestions, got %v, %v", store.Pending(), err)
+	}
+
+	discount := store.Find(review.Suggestions[0].ID)
+	patched, err := Apply(discount, []byte(source))
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	for _, want := range []string{
+		"    why: \"Takes 10% off\"\n    ai-decision-accepted: \"Reject negative 'totals'\"\n    do:",
+		"then fail \"negative total\"",
+		"function shippingCost(weight: number) returns number\n    why: \"Charges by weight\"\n    do:",
+	} {
+		if !strings.Contains(string(patched), want) {
+			t.Fatalf("expected %q in patched source:\n%s", want, patched)
+		}
+	}
+
+	feedback := store.Find(review.Suggestions[1].ID)
+	patched, err = Apply(feedback, []byte(source))
+	if err != nil || !strings.Contains(string(patched), "ai-decision-accepted: \"Looks right\"") {
+		t.Fatalf("expected an annotation-only patch, got %v:\n%s", err, patched)
+	}
+
+	// A new review replaces pending suggestions but keeps decided ones
+	discount.Status = StatusAccepted
+	store.Add(&Review{File: "shipping.cp"})
+	if len(store.Suggestions) != 1 || store.Suggestions[0] != discount {
+		t.Fatalf("expected only the accepted suggestion to remain, got %+v", store.Suggestions)
+	}
+
+	broken := &Suggestion{ID: "x", Target: "discount", Span: Span{Start: 8, End: 11}, Replacement: "function"}
+	if _, err := Apply(broken, []byte(source)); err == nil {
+		t.Fatal("expected a replacement that does not parse to be rejected")
+	}
+}
+
+func TestOfflineReview(t *testing.T) {
+	const lintSource = `define record User
+    email: email
+    createdAt: datetime
+
+define record Order
+    total: number
+
+function login(email: string, password: string) returns boolean
+    why: "Authenticates"
+    do:
+        return true
+
+function refund(order: Order) returns Order
+    why: "Customers may return unused orders within thirty days"
+    do:
+        if order.total < 0
+            then fail "invalid"
+        return order
+`
+	review, err := ReviewFile(context.Background(), Offline{}, "shop.cp", []byte(lintSource))
+	if err != nil {
+		t.Fatalf("ReviewFile: %v", err)
+	}
+	if review.Provider != "offline" {
+		t.Fatalf("expected the offline provider, got %s", review.Provider)
+	}
+	got := make(map[string]bool)
+	for _, s := range review.Suggestions {
+		got[s.Type+" "+s.Target] = true
+		if s.ID == "" || s.Span.Start == 0 {
+			t.Fatalf("suggestion missing ID or span: %+v", s)
+		}
+		if s.Type == "feedback" && s.Target == "login" && !strings.Contains(s.Content, "is 1 word;") {
+			t.Errorf("expected the one-word why counted in the singular, got %q", s.Content)
+		}
+	}
+	want := []string{"suggests Order", "feedback login", "security login", "feedback refund"}
+	for _, w := range want {
+		if !got[w] {
+			t.Errorf("expected a %s suggestion, got %+v", w, got)
+		}
+	}
+	if len(review.Suggestions) != len(want) {
+		t.Fatalf("expected %d suggestions, got %d", len(want), len(review.Suggestions))
+	}
+
+	if _, err := NewProvider(Config{Provider: "offline"}); err != nil {
+		t.Fatalf("NewProvider(offline): %v", err)
+	}
+}
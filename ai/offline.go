@@ -0,0 +1,117 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Offline reviews with built-in rules instead of a model, for teams that
+// cannot call one. Its suggestions have the same format as a model's.
+type Offline struct{}
+
+func (Offline) Name() string { return "offline" }
+
+// minWhyWords and minFailWords are the lengths below which a why clause or
+// fail message is too brief to explain anything
+const (
+	minWhyWords  = 4
+	minFailWords = 3
+)
+
+// sensitiveParams are the parameter names and types that should be
+// checked before use
+var sensitiveParams = map[string]bool{"email": true, "password": true}
+
+var timestampFields = map[string]bool{"createdat": true, "created_at": true, "updatedat": true, "updated_at": true}
+
+func (Offline) Complete(ctx context.Context, prompt *Prompt) (string, error) {
+	suggestions := []*replySuggestion{}
+	if prompt.File != nil {
+		suggestions = lint(prompt.File)
+	}
+	data, err := json.Marshal(map[string]interface{}{"suggestions": suggestions})
+	return string(data), err
+}
+
+// lint applies the offline rules to file
+func lint(file *grammar.File) []*replySuggestion {
+	var suggestions []*replySuggestion
+	add := func(typ, target string, pos *grammar.Position, format string, args ...interface{}) {
+		s := &replySuggestion{Type: typ, Target: target, Content: fmt.Sprintf(format, args...)}
+		if pos != nil {
+			s.Line = pos.Line
+		}
+		suggestions = append(suggestions, s)
+	}
+
+	for _, record := range file.Records {
+		hasTimestamps := false
+		for _, field := range record.Fields {
+			hasTimestamps = hasTimestamps || timestampFields[strings.ToLower(field.Name)]
+		}
+		if !hasTimestamps {
+			add("suggests", record.Name, record.Position,
+				"%s has no timestamps; add createdAt and updatedAt fields of type datetime so changes can be audited", record.Name)
+		}
+	}
+
+	for _, function := range file.Functions {
+		if words := len(strings.Fields(function.Why)); words < minWhyWords {
+			unit := "words"
+			if words == 1 {
+				unit = "word"
+			}
+			add("feedback", function.Name, function.Position,
+				"The why clause of %s is %d %s; say which business rule it implements and for whom", function.Name, words, unit)
+		}
+
+		var sensitive []string
+		for _, param := range function.Parameters {
+			if sensitiveParams[strings.ToLower(param.Name)] || (param.Type != nil && sensitiveParams[strings.ToLower(param.Type.Name)]) {
+				sensitive = append(sensitive, param.Name)
+			}
+		}
+		if len(sensitive) > 0 && !validates(function) {
+			add("security", function.Name, function.Position,
+				"%s takes %s but never fails; reject malformed or weak values before using them", function.Name, strings.Join(sensitive, ", "))
+		}
+
+		for _, fail := range failStatements(function) {
+			if len(strings.Fields(fail.Message)) < minFailWords {
+				add("feedback", function.Name, fail.Position,
+					"fail %q in %s gives no context; say what was wrong and with which value", fail.Message, function.Name)
+			}
+		}
+	}
+	return suggestions
+}
+
+// failStatements lists the fail statements of a function body
+func failStatements(function *grammar.Function) []*grammar.FailStatement {
+	if function.Body == nil {
+		return nil
+	}
+	var fails []*grammar.FailStatement
+	var walk func(stmt grammar.Statement)
+	walk = func(stmt grammar.Statement) {
+		switch s := stmt.(type) {
+		case *grammar.FailStatement:
+			fails = append(fails, s)
+		case *grammar.IfStatement:
+			if s.ThenStmt != nil {
+				walk(s.ThenStmt)
+			}
+			if s.ElseStmt != nil {
+				walk(s.ElseStmt)
+			}
+		}
+	}
+	for _, stmt := range function.Body.Statements {
+		walk(stmt)
+	}
+	return fails
+}
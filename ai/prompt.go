@@ -0,0 +1,94 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Prompt is a review request. File is the parsed source, for providers
+// such as Mock that do not need the serialized form.
+type Prompt struct {
+	System string
+	User   string
+	File   *grammar.File
+}
+
+const systemPrompt = `You review CloudPact source files. CloudPact declares records and
+functions; every function states its intent in a why clause. Check that the
+code does what its why clause says, and look for missing validation,
+security problems and performance problems.
+
+Reply with a single JSON object and nothing else:
+{"suggestions": [{"type": "...", "target": "...", "line": 0, "content": "...", "replacement": "..."}]}
+
+- type is one of: %s
+- target is the name of the record or function the suggestion is about
+- line is the line the suggestion refers to
+- content explains the suggestion in one or two sentences
+- replacement is optional CloudPact code to replace the declaration with
+
+Reply with {"suggestions": []} when there is nothing to suggest.`
+
+// BuildPrompt serializes file, parsed from path, and the intent its why
+// clauses state into a review prompt
+func BuildPrompt(path string, file *grammar.File) (*Prompt, error) {
+	ast, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize %s: %w", path, err)
+	}
+
+	var user strings.Builder
+	fmt.Fprintf(&user, "File: %s\n", path)
+	if file.Module != nil {
+		fmt.Fprintf(&user, "Module: %s\n", file.Module.Name)
+	}
+
+	if whys := whyClauses(file); len(whys) > 0 {
+		user.WriteString("\nIntent (why clauses):\n")
+		for _, why := range whys {
+			fmt.Fprintf(&user, "- %s\n", why)
+		}
+	}
+
+	fmt.Fprintf(&user, "\nSyntax tree:\n%s\n", ast)
+
+	return &Prompt{
+		System: fmt.Sprintf(systemPrompt, strings.Join(AnnotationTypes, ", ")),
+		User:   user.String(),
+		File:   file,
+	}, nil
+}
+
+// whyClauses lists "<declaration>: <why>" for every declaration with one
+func whyClauses(file *grammar.File) []string {
+	var whys []string
+	add := func(name, why string, translations map[string]string) {
+		if why != "" {
+			whys = append(whys, fmt.Sprintf("%s: %s", name, why))
+		}
+		locales := make([]string, 0, len(translations))
+		for locale := range translations {
+			locales = append(locales, locale)
+		}
+		sort.Strings(locales)
+		for _, locale := range locales {
+			if translations[locale] != why {
+				whys = append(whys, fmt.Sprintf("%s (%s): %s", name, locale, translations[locale]))
+			}
+		}
+	}
+	for _, typeDef := range file.TypeDefs {
+		add(typeDef.Name, typeDef.Why, typeDef.Whys)
+	}
+	for _, function := range file.Functions {
+		add(function.Name, function.Why, function.Whys)
+	}
+	for _, assignment := range file.Assignments {
+		add(assignment.TypeName, assignment.Why, assignment.Whys)
+	}
+	return whys
+}
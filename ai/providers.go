@@ -0,0 +1,248 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Config selects and configures a provider; it is the ai section of
+// cloudpact.yaml
+type Config struct {
+	Provider string `yaml:"provider"` // openai, anthropic, ollama, offline or mock
+	Model    string `yaml:"model"`
+	Endpoint string `yaml:"endpoint"` // overrides the provider's default URL
+}
+
+// Default models, used when Config.Model is empty
+const (
+	DefaultOpenAIModel    = "gpt-4o-mini"
+	DefaultAnthropicModel = "claude-3-5-sonnet-latest"
+	DefaultOllamaModel    = "llama3.1"
+)
+
+// NewProvider creates the provider cfg names; naming none is an error, so
+// a review never silently comes from the mock. OpenAI and Anthropic read
+// their API keys from OPENAI_API_KEY and ANTHROPIC_API_KEY.
+func NewProvider(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case "openai":
+		key := os.Getenv("OPENAI_API_KEY")
+		if key == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY is not set")
+		}
+		return &OpenAI{APIKey: key, Model: orDefault(cfg.Model, DefaultOpenAIModel), Endpoint: cfg.Endpoint}, nil
+	case "anthropic":
+		key := os.Getenv("ANTHROPIC_API_KEY")
+		if key == "" {
+			return nil, fmt.Errorf("ANTHROPIC_API_KEY is not set")
+		}
+		return &Anthropic{APIKey: key, Model: orDefault(cfg.Model, DefaultAnthropicModel), Endpoint: cfg.Endpoint}, nil
+	case "ollama", "local":
+		return &Ollama{Model: orDefault(cfg.Model, DefaultOllamaModel), Endpoint: cfg.Endpoint}, nil
+	case "offline":
+		return Offline{}, nil
+	case "mock":
+		return &Mock{}, nil
+	case "":
+		return nil, fmt.Errorf("no AI provider is configured; set ai.provider in cloudpact.yaml, or review with --offline")
+	}
+	return nil, fmt.Errorf("unknown AI provider %q (expected openai, anthropic, ollama, offline or mock)", cfg.Provider)
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// httpClient bounds how long a review may take
+var httpClient = &http.Client{Timeout: 2 * time.Minute}
+
+// postJSON sends body to url and decodes the JSON response into out
+func postJSON(ctx context.Context, url string, headers map[string]string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// OpenAI completes prompts with the chat completions API
+type OpenAI struct {
+	APIKey   string
+	Model    string
+	Endpoint string // defaults to https://api.openai.com/v1/chat/completions
+}
+
+func (p *OpenAI) Name() string { return "openai" }
+
+func (p *OpenAI) Complete(ctx context.Context, prompt *Prompt) (string, error) {
+	body := map[string]interface{}{
+		"model": p.Model,
+		"messages": []map[string]string{
+			{"role": "system", "content": prompt.System},
+			{"role": "user", "content": prompt.User},
+		},
+		"response_format": map[string]string{"type": "json_object"},
+	}
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	url := orDefault(p.Endpoint, "https://api.openai.com/v1/chat/completions")
+	if err := postJSON(ctx, url, map[string]string{"Authorization": "Bearer " + p.APIKey}, body, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("empty response")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// Anthropic completes prompts with the Messages API
+type Anthropic struct {
+	APIKey   string
+	Model    string
+	Endpoint string // defaults to https://api.anthropic.com/v1/messages
+}
+
+func (p *Anthropic) Name() string { return "anthropic" }
+
+func (p *Anthropic) Complete(ctx context.Context, prompt *Prompt) (string, error) {
+	body := map[string]interface{}{
+		"model":      p.Model,
+		"max_tokens": 4096,
+		"system":     prompt.System,
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt.User},
+		},
+	}
+	var resp struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	headers := map[string]string{
+		"x-api-key":         p.APIKey,
+		"anthropic-version": "2023-06-01",
+	}
+	url := orDefault(p.Endpoint, "https://api.anthropic.com/v1/messages")
+	if err := postJSON(ctx, url, headers, body, &resp); err != nil {
+		return "", err
+	}
+	var text strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return text.String(), nil
+}
+
+// Ollama completes prompts with a model served locally by Ollama
+type Ollama struct {
+	Model    string
+	Endpoint string // defaults to http://localhost:11434/api/chat
+}
+
+func (p *Ollama) Name() string { return "ollama" }
+
+func (p *Ollama) Complete(ctx context.Context, prompt *Prompt) (string, error) {
+	body := map[string]interface{}{
+		"model":  p.Model,
+		"stream": false,
+		"format": "json",
+		"messages": []map[string]string{
+			{"role": "system", "content": prompt.System},
+			{"role": "user", "content": prompt.User},
+		},
+	}
+	var resp struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	url := orDefault(p.Endpoint, "http://localhost:11434/api/chat")
+	if err := postJSON(ctx, url, nil, body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Message.Content, nil
+}
+
+// Mock answers without a model, for tests and offline use. It replies with
+// Reply when set; otherwise it suggests validating the parameters of every
+// function that never fails.
+type Mock struct {
+	Reply string
+}
+
+func (p *Mock) Name() string { return "mock" }
+
+func (p *Mock) Complete(ctx context.Context, prompt *Prompt) (string, error) {
+	if p.Reply != "" {
+		return p.Reply, nil
+	}
+	suggestions := []*replySuggestion{}
+	if prompt.File != nil {
+		for _, function := range prompt.File.Functions {
+			if len(function.Parameters) == 0 || validates(function) {
+				continue
+			}
+			var names []string
+			for _, param := range function.Parameters {
+				names = append(names, param.Name)
+			}
+			s := &replySuggestion{
+				Type:    "suggests",
+				Target:  function.Name,
+				Content: fmt.Sprintf("%s never fails; check %s and fail on invalid input", function.Name, strings.Join(names, ", ")),
+			}
+			if function.Position != nil {
+				s.Line = function.Position.Line
+			}
+			suggestions = append(suggestions, s)
+		}
+	}
+	data, err := json.Marshal(map[string]interface{}{"suggestions": suggestions})
+	return string(data), err
+}
+
+// validates reports whether function has a fail statement
+func validates(function *grammar.Function) bool {
+	return len(failStatements(function)) > 0
+}
@@ -0,0 +1,118 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// StorePath holds every suggestion made for the project and whether it
+// was accepted, relative to the project root
+var StorePath = filepath.Join("cmd", "ai-integration", "suggestions.json")
+
+// Store is the persisted list of suggestions
+type Store struct {
+	Suggestions []*Suggestion `json:"suggestions"`
+}
+
+// LoadStore reads the store at path; a missing file is an empty store
+func LoadStore(path string) (*Store, error) {
+	store := &Store{Suggestions: []*Suggestion{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return store, nil
+}
+
+// Save writes the store to path
+func (s *Store) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Add records the suggestions of a review. Pending suggestions from an
+// earlier review of the same file are replaced; decided ones are kept.
+func (s *Store) Add(review *Review) {
+	kept := s.Suggestions[:0]
+	decided := make(map[string]bool)
+	for _, suggestion := range s.Suggestions {
+		if suggestion.File == review.File && suggestion.Status == StatusPending {
+			continue
+		}
+		kept = append(kept, suggestion)
+		decided[suggestion.ID] = true
+	}
+	s.Suggestions = kept
+	for _, suggestion := range review.Suggestions {
+		if !decided[suggestion.ID] {
+			s.Suggestions = append(s.Suggestions, suggestion)
+		}
+	}
+}
+
+// Pending lists the suggestions not yet decided on
+func (s *Store) Pending() []*Suggestion {
+	var pending []*Suggestion
+	for _, suggestion := range s.Suggestions {
+		if suggestion.Status == StatusPending {
+			pending = append(pending, suggestion)
+		}
+	}
+	return pending
+}
+
+// Find returns the suggestion with id, or nil
+func (s *Store) Find(id string) *Suggestion {
+	for _, suggestion := range s.Suggestions {
+		if suggestion.ID == id {
+			return suggestion
+		}
+	}
+	return nil
+}
+
+// Apply patches source with suggestion: its replacement, if any, takes the
+// place of its span, and a function target gets an ai-decision-accepted
+// annotation. The result must still parse.
+func Apply(suggestion *Suggestion, source []byte) ([]byte, error) {
+	lines := strings.Split(string(source), "\n")
+
+	if suggestion.Replacement != "" {
+		start, end := suggestion.Span.Start, suggestion.Span.End
+		if start < 1 || end < start || end > len(lines) {
+			return nil, fmt.Errorf("suggestion %s spans lines %d-%d, outside %s", suggestion.ID, start, end, suggestion.File)
+		}
+		replacement := strings.Split(strings.TrimRight(suggestion.Replacement, "\n"), "\n")
+		lines = append(lines[:start-1], append(replacement, lines[end:]...)...)
+	}
+
+	patched := []byte(strings.Join(lines, "\n"))
+	file, err := grammar.Parse(bytes.NewReader(patched))
+	if err != nil {
+		return nil, fmt.Errorf("suggestion %s does not parse: %w", suggestion.ID, err)
+	}
+	for _, function := range file.Functions {
+		if function.Name == suggestion.Target {
+			return grammar.AddAnnotation(patched, function.Name, "decision-accepted", suggestion.Content)
+		}
+	}
+	return patched, nil
+}
@@ -0,0 +1,112 @@
+// Package cloudpact runs CloudPact builds from Go programs, such as CI
+// plugins and editors, without shelling out to the cloudpact command.
+package cloudpact
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/project"
+)
+
+// BuildOptions configure BuildProject. The zero value builds the project in
+// the current directory as cloudpact build does, without printing anything.
+type BuildOptions struct {
+	// Dir is the project root holding cloudpact.yaml; empty means the
+	// current directory
+	Dir string
+	// Targets replaces the targets of cloudpact.yaml when not empty
+	Targets []string
+	// Force rebuilds sources the build cache considers unchanged
+	Force bool
+	// KeepGoing builds the remaining files after one fails to parse or
+	// check, so Diagnostics lists the problems of every file
+	KeepGoing bool
+	// VerifyGo compiles and vets the generated Go afterwards, adding what
+	// the go command reports to Diagnostics
+	VerifyGo bool
+	// Strict validates the generated OpenAPI specs afterwards, failing the
+	// build when one breaks the OpenAPI meta-schema
+	Strict bool
+	// Log receives the progress lines cloudpact build prints; nil discards
+	// them
+	Log io.Writer
+}
+
+// BuildResult is what a build did. Paths are relative to the project root.
+type BuildResult struct {
+	// Files are the sources built, in build order
+	Files []FileResult
+	// Unchanged are the sources skipped because the build cache had them
+	Unchanged []string
+	// Diagnostics are the parse and check problems of sources that failed,
+	// followed by what verifying the generated Go found
+	Diagnostics []*grammar.Diagnostic
+	Duration    time.Duration
+}
+
+// FileResult is the build of one source
+type FileResult struct {
+	Source string
+	// Outputs are the files the targets wrote for the source
+	Outputs  []string
+	Duration time.Duration
+}
+
+// Written lists every file the build wrote
+func (r BuildResult) Written() []string {
+	var written []string
+	for _, file := range r.Files {
+		written = append(written, file.Outputs...)
+	}
+	return written
+}
+
+// buildMu serializes builds, which run in the project root as the working
+// directory of the process
+var buildMu sync.Mutex
+
+// BuildProject parses, checks and generates code for every .cp file of the
+// project in opts.Dir, like cloudpact build. It stops between files when
+// ctx is done. The result is returned even when the build fails, and covers
+// the files handled before it stopped.
+//
+// Builds change the working directory of the process to opts.Dir while
+// they run, so concurrent calls wait for each other.
+func BuildProject(ctx context.Context, opts BuildOptions) (BuildResult, error) {
+	buildMu.Lock()
+	defer buildMu.Unlock()
+
+	if opts.Dir != "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return BuildResult{}, err
+		}
+		if err := os.Chdir(opts.Dir); err != nil {
+			return BuildResult{}, err
+		}
+		defer os.Chdir(cwd)
+	}
+
+	report, err := project.BuildWith(ctx, project.BuildSettings{
+		Targets:   opts.Targets,
+		Force:     opts.Force,
+		KeepGoing: opts.KeepGoing,
+		VerifyGo:  opts.VerifyGo,
+		Strict:    opts.Strict,
+		Log:       opts.Log,
+	})
+	result := BuildResult{
+		Unchanged:   report.Unchanged,
+		Diagnostics: report.Diagnostics,
+		Duration:    report.Duration,
+	}
+	for _, file := range report.Files {
+		result.Files = append(result.Files, FileResult{Source: file.Source, Outputs: file.Outputs, Duration: file.Duration})
+	}
+	return result, err
+}
@@ -0,0 +1,71 @@
+package cloudpact
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildProject(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "models"), 0755)
+	os.WriteFile(filepath.Join(dir, "cloudpact.yaml"), []byte("targets: [go]\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "models", "user.cp"), []byte("module Users\n\ndefine record User\n    name: text\n"), 0644)
+	cwd, _ := os.Getwd()
+
+	var log bytes.Buffer
+	result, err := BuildProject(context.Background(), BuildOptions{Dir: dir, Log: &log})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if wd, _ := os.Getwd(); wd != cwd {
+		t.Fatalf("working directory left at %s", wd)
+	}
+	if len(result.Files) != 1 || result.Files[0].Source != filepath.Join("models", "user.cp") {
+		t.Fatalf("unexpected files: %+v", result.Files)
+	}
+	written := result.Written()
+	if len(written) != 1 || written[0] != filepath.Join("generated", "go", "users", "user.go") {
+		t.Fatalf("unexpected outputs: %v", written)
+	}
+	if _, err := os.Stat(filepath.Join(dir, written[0])); err != nil {
+		t.Fatalf("output not written: %v", err)
+	}
+	if !strings.Contains(log.String(), "Built 1 CloudPact files") {
+		t.Fatalf("unexpected log:\n%s", log.String())
+	}
+
+	// The cache skips the unchanged source unless the build is forced
+	result, err = BuildProject(context.Background(), BuildOptions{Dir: dir})
+	if err != nil || len(result.Files) != 0 || len(result.Unchanged) != 1 {
+		t.Fatalf("expected a cached build, got %+v, %v", result, err)
+	}
+	result, err = BuildProject(context.Background(), BuildOptions{Dir: dir, Force: true, Targets: []string{"ts"}})
+	if err != nil || len(result.Written()) != 1 || result.Written()[0] != filepath.Join("generated", "ts", "user.ts") {
+		t.Fatalf("expected a forced TypeScript build, got %+v, %v", result, err)
+	}
+
+	// KeepGoing reports the problems of every failing file
+	os.WriteFile(filepath.Join(dir, "models", "a.cp"), []byte("define record A\n    : text\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "models", "b.cp"), []byte("define record B\n    name text\n"), 0644)
+	result, err = BuildProject(context.Background(), BuildOptions{Dir: dir, KeepGoing: true})
+	if err == nil || !strings.Contains(err.Error(), "2 CloudPact files have errors") {
+		t.Fatalf("expected both files to fail, got %v", err)
+	}
+	if len(result.Diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", result.Diagnostics)
+	}
+	result, err = BuildProject(context.Background(), BuildOptions{Dir: dir})
+	if err == nil || len(result.Diagnostics) != 1 {
+		t.Fatalf("expected the build to stop at the first error, got %v, %v", result.Diagnostics, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := BuildProject(ctx, BuildOptions{Dir: dir, Force: true}); err != context.Canceled {
+		t.Fatalf("expected a canceled build, got %v", err)
+	}
+}
@@ -2,16 +2,84 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/dap"
 	"github.com/daveroberts0321/cloudpact/generator"
+	"github.com/daveroberts0321/cloudpact/interp"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
 	"github.com/daveroberts0321/cloudpact/project"
+	"github.com/daveroberts0321/cloudpact/spec/openapi"
 	"github.com/daveroberts0321/cloudpact/watch"
 )
 
 
+// workDir is the directory the command was run from, relative to the
+// project root, which becomes the working directory
+var workDir = "."
+
+// fromWorkDir resolves a path given on the command line against the
+// directory the command was run from
+func fromWorkDir(path string) string {
+	if filepath.IsAbs(path) || workDir == "." {
+		return path
+	}
+	return filepath.Join(workDir, path)
+}
+
+// wrote prints the files command wrote and lists them in the manifest, so
+// clean removes them
+func wrote(command string, outputs ...string) {
+	for _, output := range outputs {
+		fmt.Printf("Wrote %s\n", output)
+	}
+	if err := project.RecordGenerated(command, outputs); err != nil {
+		fmt.Printf("   Warning: failed to record the files in %s: %v\n", project.ManifestPath, err)
+	}
+}
+
+// stripOut removes an --out <dir> or --out=<dir> flag from args and
+// returns the rest with the directory, resolved from the working directory
+func stripOut(args []string) ([]string, string) {
+	var rest []string
+	out := ""
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--out" && i+1 < len(args):
+			i++
+			out = fromWorkDir(args[i])
+		case strings.HasPrefix(arg, "--out="):
+			out = fromWorkDir(strings.TrimPrefix(arg, "--out="))
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest, out
+}
+
 func main() {
+	projectDir := ""
+	args := os.Args[:1]
+	for i := 1; i < len(os.Args); i++ {
+		switch arg := os.Args[i]; {
+		case arg == "--project" && i+1 < len(os.Args):
+			i++
+			projectDir = os.Args[i]
+		case strings.HasPrefix(arg, "--project="):
+			projectDir = strings.TrimPrefix(arg, "--project=")
+		default:
+			args = append(args, arg)
+		}
+	}
+	os.Args = args
+
 	if len(os.Args) < 2 {
 		printUsage()
 		return
@@ -19,14 +87,57 @@ func main() {
 
 	cmd := os.Args[1]
 
+	// Commands run from the project root found above the working directory
+	switch cmd {
+	case "init", "version", "help", "--help", "-h":
+	default:
+		dir, err := project.EnterRoot(projectDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		workDir = dir
+	}
+
 	switch cmd {
 	case "init":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: cloudpact init <project-name>")
+		usage := "Usage: cloudpact init <project-name> [--template minimal|api|fullstack | --from dir-or-repo] [--module path] [--author name]"
+		var projectName string
+		var opts project.InitOptions
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case arg == "--template" && i+1 < len(os.Args):
+				i++
+				opts.Template = os.Args[i]
+			case strings.HasPrefix(arg, "--template="):
+				opts.Template = strings.TrimPrefix(arg, "--template=")
+			case arg == "--module" && i+1 < len(os.Args):
+				i++
+				opts.Module = os.Args[i]
+			case strings.HasPrefix(arg, "--module="):
+				opts.Module = strings.TrimPrefix(arg, "--module=")
+			case arg == "--author" && i+1 < len(os.Args):
+				i++
+				opts.Author = os.Args[i]
+			case strings.HasPrefix(arg, "--author="):
+				opts.Author = strings.TrimPrefix(arg, "--author=")
+			case arg == "--from" && i+1 < len(os.Args):
+				i++
+				opts.From = os.Args[i]
+			case strings.HasPrefix(arg, "--from="):
+				opts.From = strings.TrimPrefix(arg, "--from=")
+			case projectName == "" && !strings.HasPrefix(arg, "-"):
+				projectName = arg
+			default:
+				fmt.Println(usage)
+				return
+			}
+		}
+		if projectName == "" {
+			fmt.Println(usage)
 			return
 		}
-		projectName := os.Args[2]
-		if err := project.Init(projectName); err != nil {
+		if err := project.Init(projectName, opts); err != nil {
 			fmt.Printf("Error initializing project: %v\n", err)
 			return
 		}
@@ -36,7 +147,7 @@ func main() {
 
 	case "start":
 		if len(os.Args) < 3 {
-			fmt.Println("Usage: cloudpact start <http|build>")
+			fmt.Println("Usage: cloudpact start <http|build|mock>")
 			return
 		}
 		subCmd := os.Args[2]
@@ -46,20 +157,35 @@ func main() {
 				fmt.Printf("Error starting dev server: %v\n", err)
 			}
 		case "build":
-			if err := project.Build(); err != nil {
+			settings := project.BuildSettings{Log: os.Stdout}
+			for _, arg := range os.Args[3:] {
+				switch arg {
+				case "--verify":
+					settings.VerifyGo = true
+				case "--strict":
+					settings.Strict = true
+				}
+			}
+			if _, err := project.BuildWith(context.Background(), settings); err != nil {
 				fmt.Printf("Error building project: %v\n", err)
 			} else {
 				fmt.Println("Project built successfully!")
 			}
+		case "mock":
+			if err := project.StartMockServer(); err != nil {
+				fmt.Printf("Error starting mock server: %v\n", err)
+			}
 		default:
 			fmt.Printf("Unknown start command: %s\n", subCmd)
 		}
 
 	case "gen":
 		if len(os.Args) < 3 {
-			fmt.Println("Usage: cloudpact gen <record|function|openapi> [args...]")
+			fmt.Println("Usage: cloudpact gen <record|function|openapi|asyncapi|jsonschema|rust|csharp|forms|db|deploy|terraform|lambda|config|policy|postman|datadict|docs|mocks|events|queues|server> [args...] [--out dir]")
 			return
 		}
+		var out string
+		os.Args, out = stripOut(os.Args)
 		subCmd := os.Args[2]
 		switch subCmd {
 		case "record":
@@ -79,19 +205,316 @@ func main() {
 				fmt.Println("Usage: cloudpact gen model <ModelName>")
 				return
 			}
-			generator.GenerateModel(os.Args[3])
+			if err := generator.GenerateModel(os.Args[3]); err != nil {
+				fmt.Printf("Error generating model: %v\n", err)
+			}
 		case "openapi":
 			if len(os.Args) < 4 {
-				fmt.Println("Usage: cloudpact gen openapi <file.cp>")
+				fmt.Println("Usage: cloudpact gen openapi <file.cp> [--out dir]")
 				return
 			}
-			if err := generator.GenerateOpenAPI(os.Args[3]); err != nil {
+			if err := generator.GenerateOpenAPI(fromWorkDir(os.Args[3]), out); err != nil {
 				fmt.Printf("Error generating OpenAPI: %v\n", err)
 			}
+		case "asyncapi":
+			outputs, err := project.GenerateAsyncAPI(out)
+			if err != nil {
+				fmt.Printf("Error generating AsyncAPI: %v\n", err)
+				return
+			}
+			wrote("gen asyncapi", outputs...)
+		case "events":
+			outputs, err := project.GenerateEvents(out)
+			if err != nil {
+				fmt.Printf("Error generating events: %v\n", err)
+				return
+			}
+			wrote("gen events", outputs...)
+		case "queues":
+			outputs, err := project.GenerateQueues(out)
+			if err != nil {
+				fmt.Printf("Error generating queues: %v\n", err)
+				return
+			}
+			wrote("gen queues", outputs...)
+		case "jsonschema":
+			outputs, err := project.GenerateJSONSchema(out)
+			if err != nil {
+				fmt.Printf("Error generating JSON Schema: %v\n", err)
+				return
+			}
+			wrote("gen jsonschema", outputs...)
+		case "rust":
+			outputs, err := project.GenerateRust(out)
+			if err != nil {
+				fmt.Printf("Error generating Rust: %v\n", err)
+				return
+			}
+			wrote("gen rust", outputs...)
+		case "csharp":
+			outputs, err := project.GenerateCSharp(out)
+			if err != nil {
+				fmt.Printf("Error generating C#: %v\n", err)
+				return
+			}
+			wrote("gen csharp", outputs...)
+		case "forms":
+			outputs, err := project.GenerateForms(out)
+			if err != nil {
+				fmt.Printf("Error generating forms: %v\n", err)
+				return
+			}
+			wrote("gen forms", outputs...)
+		case "db":
+			outputs, err := project.GenerateDatabase(out)
+			if err != nil {
+				fmt.Printf("Error generating database code: %v\n", err)
+				return
+			}
+			wrote("gen db", outputs...)
+		case "deploy":
+			outputs, err := project.GenerateDeploy(out)
+			if err != nil {
+				fmt.Printf("Error generating deployment: %v\n", err)
+				return
+			}
+			wrote("gen deploy", outputs...)
+		case "terraform":
+			provider := "aws"
+			if len(os.Args) > 3 {
+				provider = os.Args[3]
+			}
+			outputs, err := project.GenerateTerraform(provider, out)
+			if err != nil {
+				fmt.Printf("Error generating Terraform module: %v\n", err)
+				return
+			}
+			wrote("gen terraform "+provider, outputs...)
+		case "lambda":
+			outputs, err := project.GenerateLambda(out)
+			if err != nil {
+				fmt.Printf("Error generating Lambda handlers: %v\n", err)
+				return
+			}
+			wrote("gen lambda", outputs...)
+		case "config":
+			outputs, err := project.GenerateConfig()
+			if err != nil {
+				fmt.Printf("Error generating the settings loader: %v\n", err)
+				return
+			}
+			wrote("gen config", outputs...)
+		case "policy":
+			output, err := project.GeneratePolicy()
+			if err != nil {
+				fmt.Printf("Error generating the authorization checks: %v\n", err)
+				return
+			}
+			wrote("gen policy", output)
+		case "datadict":
+			format := "csv"
+			if len(os.Args) > 3 {
+				format = os.Args[3]
+			}
+			output, err := project.GenerateDataDictionary(format, out)
+			if err != nil {
+				fmt.Printf("Error generating data dictionary: %v\n", err)
+				return
+			}
+			wrote("gen datadict "+format, output)
+		case "docs":
+			format := "md"
+			if len(os.Args) > 3 {
+				format = os.Args[3]
+			}
+			outputs, err := project.GenerateDocs(format, out)
+			if err != nil {
+				fmt.Printf("Error generating documentation: %v\n", err)
+				return
+			}
+			wrote("gen docs "+format, outputs...)
+		case "postman":
+			outputs, err := project.GeneratePostman(out)
+			if err != nil {
+				fmt.Printf("Error generating Postman collection: %v\n", err)
+				return
+			}
+			wrote("gen postman", outputs...)
+		case "mocks":
+			outputs, err := project.GenerateMocks(out)
+			if err != nil {
+				fmt.Printf("Error generating mocks: %v\n", err)
+				return
+			}
+			wrote("gen mocks", outputs...)
+		case "server":
+			output, err := project.GenerateServer(out)
+			if err != nil {
+				fmt.Printf("Error generating server: %v\n", err)
+				return
+			}
+			wrote("gen server", output)
 		default:
 			fmt.Printf("Unknown gen command: %s\n", subCmd)
 		}
 
+	case "openapi":
+		if len(os.Args) >= 3 && os.Args[2] == "validate" {
+			var specs []string
+			for _, arg := range os.Args[3:] {
+				specs = append(specs, fromWorkDir(arg))
+			}
+			violations, err := project.ValidateOpenAPI(specs)
+			if err != nil {
+				fmt.Printf("Error validating OpenAPI: %v\n", err)
+				os.Exit(1)
+			}
+			for _, v := range violations {
+				fmt.Println(v)
+			}
+			fmt.Println(problemsFound(len(violations)))
+			if len(violations) > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		if len(os.Args) < 3 || os.Args[2] != "lint" {
+			fmt.Println("Usage: cloudpact openapi <lint [file.cp...] | validate [spec...]>")
+			return
+		}
+		var sources []string
+		for _, arg := range os.Args[3:] {
+			sources = append(sources, fromWorkDir(arg))
+		}
+		violations, err := project.LintOpenAPI(sources)
+		if err != nil {
+			fmt.Printf("Error linting OpenAPI: %v\n", err)
+			os.Exit(1)
+		}
+		failed := false
+		for _, v := range violations {
+			fmt.Println(v)
+			failed = failed || v.Severity == openapi.SeverityError
+		}
+		fmt.Println(problemsFound(len(violations)))
+		if failed {
+			os.Exit(1)
+		}
+
+	case "import":
+		usage := "Usage: cloudpact import <openapi spec | go package-dir> [-o file.cp] [--module Name]"
+		var source, output, module string
+		for i := 3; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case (arg == "-o" || arg == "--output") && i+1 < len(os.Args):
+				i++
+				output = fromWorkDir(os.Args[i])
+			case arg == "--module" && i+1 < len(os.Args):
+				i++
+				module = os.Args[i]
+			case strings.HasPrefix(arg, "--module="):
+				module = strings.TrimPrefix(arg, "--module=")
+			case source == "" && !strings.HasPrefix(arg, "-"):
+				source = fromWorkDir(arg)
+			default:
+				fmt.Println(usage)
+				return
+			}
+		}
+		if len(os.Args) < 3 || source == "" {
+			fmt.Println(usage)
+			return
+		}
+		var written string
+		var err error
+		switch os.Args[2] {
+		case "openapi":
+			written, err = project.ImportOpenAPI(source, output, module)
+		case "go":
+			written, err = project.ImportGo(source, output, module)
+		default:
+			fmt.Println(usage)
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error importing %s: %v\n", source, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s\n", written)
+
+	case "check":
+		jsonOutput := false
+		var files []string
+		for _, arg := range os.Args[2:] {
+			if arg == "--json" {
+				jsonOutput = true
+			} else {
+				files = append(files, fromWorkDir(arg))
+			}
+		}
+		diagnostics, err := project.Check(files)
+		if err != nil {
+			fmt.Printf("Error checking files: %v\n", err)
+			os.Exit(1)
+		}
+		if jsonOutput {
+			data, err := json.MarshalIndent(diagnostics, "", "  ")
+			if err != nil {
+				fmt.Printf("Error encoding diagnostics: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+		} else {
+			for _, d := range diagnostics {
+				fmt.Println(d.Format())
+			}
+			fmt.Println(problemsFound(len(diagnostics)))
+		}
+		if len(diagnostics) > 0 {
+			os.Exit(1)
+		}
+
+	case "parse":
+		var source, output string
+		jsonOutput := false
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case arg == "--json":
+				jsonOutput = true
+			case (arg == "-o" || arg == "--output") && i+1 < len(os.Args):
+				i++
+				output = fromWorkDir(os.Args[i])
+			default:
+				source = fromWorkDir(arg)
+			}
+		}
+		if source == "" || !jsonOutput {
+			fmt.Println("Usage: cloudpact parse <file.cp> --json [-o output.json]")
+			return
+		}
+		file, err := project.ParseCloudPactFile(source)
+		if err != nil {
+			var d *grammar.Diagnostic
+			if errors.As(err, &d) {
+				fmt.Fprintln(os.Stderr, d.Format())
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			os.Exit(1)
+		}
+		data, err := json.MarshalIndent(file, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding AST: %v\n", err)
+			os.Exit(1)
+		}
+		data = append(data, '\n')
+		if output == "" {
+			os.Stdout.Write(data)
+		} else if err := os.WriteFile(output, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", output, err)
+			os.Exit(1)
+		}
+
 	case "ai":
 		if len(os.Args) < 3 {
 			fmt.Println("Usage: cloudpact ai <review|feedback|status|accept> [args...]")
@@ -100,29 +523,254 @@ func main() {
 		subCmd := os.Args[2]
 		switch subCmd {
 		case "review":
-			if len(os.Args) < 4 {
-				fmt.Println("Usage: cloudpact ai review <file.cp>")
+			var file string
+			offline := false
+			for _, arg := range os.Args[3:] {
+				if arg == "--offline" {
+					offline = true
+				} else {
+					file = fromWorkDir(arg)
+				}
+			}
+			if file == "" {
+				fmt.Println("Usage: cloudpact ai review [--offline] <file.cp>")
+				return
+			}
+			review, output, err := project.ReviewFile(file, offline)
+			if err != nil {
+				fmt.Printf("Error reviewing %s: %v\n", file, err)
 				return
 			}
-			fileName := os.Args[3]
-			fmt.Printf("AI review for %s (not yet implemented)\n", fileName)
+			for _, s := range review.Suggestions {
+				fmt.Printf("%s:%d: ai-%s [%s] %s\n", s.File, s.Span.Start, s.Type, s.ID, s.Content)
+			}
+			fmt.Printf("%d suggestions from %s written to %s\n", len(review.Suggestions), review.Provider, output)
 		case "feedback":
 			fmt.Println("AI feedback session (not yet implemented)")
 		case "status":
-			fmt.Println("AI suggestions status (not yet implemented)")
+			pending, err := project.PendingSuggestions()
+			if err != nil {
+				fmt.Printf("Error reading suggestions: %v\n", err)
+				return
+			}
+			for _, s := range pending {
+				fmt.Printf("%s  %s:%d-%d  ai-%s on %s: %s\n", s.ID, s.File, s.Span.Start, s.Span.End, s.Type, s.Target, s.Content)
+				if s.Replacement != "" {
+					fmt.Println("        (proposes replacement code)")
+				}
+			}
+			fmt.Printf("%d pending suggestions\n", len(pending))
 		case "accept":
-			fmt.Println("Accept AI suggestion (not yet implemented)")
+			if len(os.Args) < 4 {
+				fmt.Println("Usage: cloudpact ai accept <id>")
+				return
+			}
+			s, err := project.AcceptSuggestion(os.Args[3])
+			if err != nil {
+				fmt.Printf("Error accepting suggestion: %v\n", err)
+				return
+			}
+			fmt.Printf("Applied %s to %s\n", s.ID, s.File)
 		default:
 			fmt.Printf("Unknown ai command: %s\n", subCmd)
 		}
 
+	case "package":
+		version := ""
+		if len(os.Args) > 2 {
+			version = os.Args[2]
+		}
+		outputs, err := project.Package(version)
+		if err != nil {
+			fmt.Printf("Error packaging project: %v\n", err)
+			return
+		}
+		for _, output := range outputs {
+			fmt.Printf("Wrote %s\n", output)
+		}
+
+	case "release":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: cloudpact release <vX.Y.Z>")
+			return
+		}
+		outputs, err := project.Release(os.Args[2])
+		if err != nil {
+			fmt.Printf("Error releasing %s: %v\n", os.Args[2], err)
+			os.Exit(1)
+		}
+		for _, output := range outputs {
+			fmt.Printf("Wrote %s\n", output)
+		}
+		fmt.Printf("Released %s; push it with git push --follow-tags\n", os.Args[2])
+
+	case "clean":
+		removed, err := project.Clean()
+		if err != nil {
+			fmt.Printf("Error cleaning project: %v\n", err)
+			return
+		}
+		fmt.Printf("Removed %d generated files\n", len(removed))
+
+	case "run":
+		var positional []string
+		var opts project.RunOptions
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case arg == "--debug":
+				opts.Debug = true
+			case arg == "--trace":
+				opts.Trace = true
+			case arg == "--break" && i+1 < len(os.Args):
+				i++
+				line, err := strconv.Atoi(os.Args[i])
+				if err != nil {
+					fmt.Printf("Invalid breakpoint line %q\n", os.Args[i])
+					return
+				}
+				opts.Breakpoints = append(opts.Breakpoints, line)
+			case arg == "--arg" && i+1 < len(os.Args):
+				i++
+				name, value, ok := strings.Cut(os.Args[i], "=")
+				if !ok {
+					fmt.Printf("Invalid argument %q; expected name=value\n", os.Args[i])
+					return
+				}
+				if opts.Args == nil {
+					opts.Args = make(map[string]string)
+				}
+				opts.Args[name] = value
+			default:
+				positional = append(positional, arg)
+			}
+		}
+		if len(positional) != 2 {
+			fmt.Println("Usage: cloudpact run <file.cp> <function> [--arg name=value] [--trace] [--debug] [--break LINE]")
+			return
+		}
+		result, err := project.RunFunction(fromWorkDir(positional[0]), positional[1], opts)
+		var failure *interp.Failure
+		switch {
+		case errors.As(err, &failure):
+			fmt.Printf("fail: %s\n", failure.Message)
+			os.Exit(1)
+		case errors.Is(err, interp.ErrTerminated):
+			os.Exit(1)
+		case err != nil:
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(interp.Format(result))
+
+	case "test":
+		var files []string
+		for _, arg := range os.Args[2:] {
+			files = append(files, fromWorkDir(arg))
+		}
+		results, err := project.RunTests(files)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		failed := 0
+		for _, result := range results {
+			if result.Err == nil {
+				fmt.Printf("ok    %s: %s\n", result.File, result.Name)
+				continue
+			}
+			failed++
+			fmt.Printf("FAIL  %s: %s\n      %v\n", result.File, result.Name, result.Err)
+		}
+		fmt.Printf("%d passed, %d failed\n", len(results)-failed, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+
+	case "dap":
+		if err := dap.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "db":
+		var out string
+		os.Args, out = stripOut(os.Args)
+		if len(os.Args) < 3 || os.Args[2] != "seed" {
+			fmt.Println("Usage: cloudpact db seed [--out dir]")
+			return
+		}
+		outputs, err := project.GenerateSeed(out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating seed data: %v\n", err)
+			os.Exit(1)
+		}
+		wrote("db seed", outputs...)
+
+	case "migrate":
+		if len(os.Args) < 4 || os.Args[2] != "syntax" {
+			fmt.Println("Usage: cloudpact migrate syntax <file.cp|dir>")
+			return
+		}
+		changed, err := project.MigrateSyntax(fromWorkDir(os.Args[3]))
+		for _, file := range changed {
+			fmt.Printf("Migrated %s\n", file)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(changed) == 0 {
+			fmt.Println("No legacy declarations found")
+		}
+
+	case "graph":
+		var output string
+		mermaid := false
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case arg == "--mermaid":
+				mermaid = true
+			case arg == "--dot":
+				mermaid = false
+			case (arg == "-o" || arg == "--output") && i+1 < len(os.Args):
+				i++
+				output = fromWorkDir(os.Args[i])
+			default:
+				fmt.Println("Usage: cloudpact graph [--dot|--mermaid] [-o output]")
+				return
+			}
+		}
+		g, err := project.Graph()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		text := g.DOT()
+		if mermaid {
+			text = g.Mermaid()
+		}
+		if output == "" {
+			fmt.Print(text)
+		} else if err := os.WriteFile(output, []byte(text), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", output, err)
+			os.Exit(1)
+		}
+		for _, cycle := range g.Cycles() {
+			fmt.Fprintf(os.Stderr, "Relationship cycle: %s\n", strings.Join(cycle, ", "))
+		}
+
+	case "repl":
+		if err := project.REPL(os.Stdin, os.Stdout); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+
 	case "watch":
-		if err := watch.Watch(context.Background(), project.Build); err != nil {
+		if err := watch.Watch(context.Background(), project.BuildFiles); err != nil {
 			fmt.Printf("Error watching files: %v\n", err)
 		}
 
 	case "version":
-		fmt.Println("CloudPact v0.2.0 - Human/AI collaborative programming language")
+		fmt.Printf("CloudPact v%s - Human/AI collaborative programming language\n", codegen.Version)
 
 	case "help", "--help", "-h":
 		printUsage()
@@ -133,34 +781,84 @@ func main() {
 	}
 }
 
+// problemsFound reports how many problems a check found
+func problemsFound(n int) string {
+	if n == 1 {
+		return "1 problem found"
+	}
+	return fmt.Sprintf("%d problems found", n)
+}
+
 func printUsage() {
 	fmt.Println(`CloudPact - Human/AI collaborative programming language
 
 USAGE:
-    cloudpact <command> [arguments]
+    cloudpact [--project <dir>] <command> [arguments]
+
+Commands run in the project root, the nearest directory at or above the
+current one that holds cloudpact.yaml, or the directory given by --project.
+The gen openapi, asyncapi, jsonschema, rust, csharp, forms, db, deploy,
+terraform, lambda, postman, datadict, docs, mocks, events, queues and
+server commands, and db seed, take --out <dir> to write somewhere other
+than the directory set in cloudpact.yaml.
 
 COMMANDS:
-    init <name>           Initialize a new CloudPact project
+    init <name>           Initialize a new CloudPact project (--template minimal|api|fullstack or --from a directory or git repo, --module for the Go module path)
     start http            Start development server with hot reload
-    start build           Build the project once
+    start build           Build the project once (--verify compiles and vets the generated Go, --strict validates the OpenAPI)
+    start mock            Serve example API responses from the OpenAPI spec
     gen record <name>     Generate a record template
     gen function <name>   Generate a function template
     gen model <name>      Generate a model template (legacy)
     gen openapi <file>    Generate OpenAPI spec from .cp file
-    ai review <file>      AI reviews a specific file
+    gen asyncapi          Describe each file's events as an AsyncAPI document
+    gen jsonschema        Export each record as a JSON Schema (draft 2020-12) document
+    gen rust              Generate Rust structs with serde derives for each file's records
+    gen csharp            Generate C# records with System.Text.Json and DataAnnotations attributes
+    gen forms             Generate accessible HTML forms per record, bound to the API client in TypeScript
+    gen db                Generate GORM models or sqlc schema and queries for records, per persistence.orm
+    gen deploy            Generate Kubernetes manifests (or a Helm chart) for the generated server, per deploy.format
+    gen terraform [aws]   Generate a Terraform module with a table or bucket per record marked persist
+    gen lambda            Generate a Lambda handler per function, with a SAM template or serverless.yml
+    gen config            Generate a Go settings loader, .env.example and env.d.ts from config declarations
+    gen policy            Generate a Go policy package with Can and middleware from roles and allow rules
+    gen postman           Export the API as a Postman/Insomnia collection
+    gen datadict [xlsx]   Export records and fields as CSV (or XLSX)
+    gen docs [html]       Document records, types and functions as Markdown (or HTML)
+    gen mocks             Generate Go interfaces and mocks for each module's functions
+    gen events            Generate Go publishers and subscribers for each module's events
+    gen queues            Generate Go producers and consumers for each module's queues, and TS task types
+    gen server            Generate a Go main serving every module's functions
+    import openapi <spec> Write records and function stubs for an OpenAPI spec to a .cp file (-o to choose it, --module to name the module)
+    import go <pkg>       Write records for the exported structs of a Go package directory to a .cp file (-o, --module)
+    parse <file> --json   Print the parsed AST of a .cp file as JSON (-o to write a file)
+    check [files]         Parse and check .cp files, reporting problems (--json for editors)
+    openapi lint [files]  Check the generated OpenAPI specs against CloudPact conventions
+    openapi validate [spec] Check OpenAPI specs against the OpenAPI 3.0/3.1 schema (default: the generated ones)
+    ai review <file>      AI reviews a specific file (--offline uses built-in rules)
     ai feedback           Interactive AI feedback session
     ai status             Show pending AI suggestions
     ai accept <id>        Accept a specific AI suggestion
+    package [version]     Package the generated TypeScript as an npm tarball and Go as a module zip
+    release <vX.Y.Z>      Stamp the version, update CHANGELOG.md, tag and package a release
+    clean                 Remove the files listed in generated/manifest.json
+    run <file> <function> Run a function (--arg name=value, --trace, --debug, --break LINE)
+    test [files]          Run the test blocks of .cp files with the interpreter
+    dap                   Serve the Debug Adapter Protocol on stdio for editors
+    db seed               Generate the sample records seed declarations ask for, as a Go Seed function or seed.sql
+    migrate syntax <path> Rewrite legacy model and assign-use declarations as define record and define type
+    graph                 Draw records, relationships and calls as DOT (--mermaid for Mermaid, -o to write a file)
+    repl                  Evaluate expressions interactively against sample data
     watch                 Watch files and rebuild on changes
     version               Show version information
     help                  Show this help message
 
 EXAMPLES:
     cloudpact init myapp
+    cloudpact init myapp --from github.com/acme/cp-template
     cloudpact start http
     cloudpact gen record User
     cloudpact gen function validateUser
     cloudpact ai review models/user.cp
     cloudpact gen openapi models/user.cp`)
 }
-
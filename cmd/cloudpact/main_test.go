@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// run runs the CLI with args in the working directory and returns what it
+// printed
+func run(t *testing.T, args ...string) string {
+	t.Helper()
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	os.Args = append([]string{"cloudpact"}, args...)
+	workDir = "."
+	main()
+	w.Close()
+	os.Stdout = stdout
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+func TestGenMocks(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\n"), 0644)
+	os.WriteFile("greetings.cp", []byte(`module Greetings
+
+function greet(name: text) returns text
+    why: "Greets a customer"
+    do:
+        return name
+`), 0644)
+
+	if out := run(t, "gen"); !strings.Contains(out, "|mocks|") {
+		t.Fatalf("expected mocks in the gen usage:\n%s", out)
+	}
+	want := filepath.Join("generated", "go", "greetings", "greetings_mock.go")
+	if out := run(t, "gen", "mocks"); !strings.Contains(out, "Wrote "+want) {
+		t.Fatalf("expected gen mocks to write %s:\n%s", want, out)
+	}
+	data, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatalf("expected %s: %v", want, err)
+	}
+	if !strings.Contains(string(data), "type MockGreetings struct {") {
+		t.Fatalf("expected a mock of the module:\n%s", data)
+	}
+
+	want = filepath.Join("mocks", "greetings", "greetings_mock.go")
+	if out := run(t, "gen", "mocks", "--out", "mocks"); !strings.Contains(out, "Wrote "+want) {
+		t.Fatalf("expected gen mocks --out to write %s:\n%s", want, out)
+	}
+}
@@ -0,0 +1,152 @@
+// Package codegen holds what the Go and TypeScript generators share: the
+// templates laying out generated files, the data they are executed with,
+// and what CloudPact's semantic types mean to both languages.
+package codegen
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// The layout of generated Go and TypeScript files lives in templates, one
+// per construct: file.tmpl, record.tmpl, model.tmpl and function.tmpl.
+// Expressions and statements are translated in Go and reach the templates
+// as ready-made function bodies.
+//
+//go:embed go ts
+var templates embed.FS
+
+// Funcs are the functions templates can call
+var Funcs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"title": func(s string) string {
+		if s == "" {
+			return s
+		}
+		return strings.ToUpper(s[:1]) + s[1:]
+	},
+	"snake": func(s string) string {
+		var b strings.Builder
+		for i, r := range s {
+			if r >= 'A' && r <= 'Z' {
+				if i > 0 {
+					b.WriteByte('_')
+				}
+				r += 'a' - 'A'
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	},
+}
+
+// Load parses the built-in templates for lang ("go" or "ts"), then any
+// templates in overrideDir, which replace built-in ones of the same name
+func Load(lang, overrideDir string) (*template.Template, error) {
+	tmpl, err := template.New("file.tmpl").Funcs(Funcs).ParseFS(templates, path.Join(lang, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	if overrideDir == "" {
+		return tmpl, nil
+	}
+
+	overrides, err := filepath.Glob(filepath.Join(overrideDir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(overrides) > 0 {
+		if tmpl, err = tmpl.ParseFiles(overrides...); err != nil {
+			return nil, fmt.Errorf("failed to parse template overrides: %w", err)
+		}
+	}
+	return tmpl, nil
+}
+
+// Render executes the named template into a string
+func Render(tmpl *template.Template, name string, data interface{}) (string, error) {
+	var b strings.Builder
+	if err := tmpl.ExecuteTemplate(&b, name, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Template data. Extra carries code generated alongside a record, model or
+// function, such as change tracking, PATCH handlers or HTTP handlers.
+
+// File is the data of file.tmpl
+type File struct {
+	Package         string // Go only
+	Imports         []string
+	Module          string
+	Helpers         string // TS helpers needed by records
+	FunctionHelpers string // TS helpers needed by functions
+	Records         []Record
+	Models          []Model
+	Functions       []Function
+	Extra           string // Go code after the functions, such as workflows
+}
+
+// Record is the data of record.tmpl
+type Record struct {
+	Name         string
+	Extends      string   // the record it extends, embedded in Go and extended in TS
+	Doc          []string // the record's comments, one line each
+	Fields       []Field
+	TrackChanges bool
+	Versioned    bool
+	ImplicitID   bool // the record gets the generated id field
+	Extra        string
+}
+
+// Model is the data of model.tmpl
+type Model struct {
+	Name   string
+	Doc    []string
+	Fields []Field
+	Extra  string
+}
+
+// Field is a field of a record or model
+type Field struct {
+	Name     string // as declared in CloudPact
+	JSON     string // key in JSON, following json_names in cloudpact.yaml
+	Type     string // target language type
+	Optional bool
+	Validate string // Go validate tag
+	Comment  string // TS comment describing semantic types
+	Doc      []string
+}
+
+// Function is the data of function.tmpl
+type Function struct {
+	Name        string
+	Doc         []string
+	Why         string
+	Annotations []*grammar.AIAnnotation
+	Params      []Param
+	Returns     string
+	Body        string
+	Extra       string
+}
+
+// DocLines renders a declaration's comments, those above it first, as
+// documentation for the generated code
+func DocLines(leading, trailing []*grammar.Comment) []string {
+	comments := append(append([]*grammar.Comment{}, leading...), trailing...)
+	return grammar.CommentLines(comments)
+}
+
+// Param is a parameter of a function
+type Param struct {
+	Name string
+	Type string
+}
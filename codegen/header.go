@@ -0,0 +1,30 @@
+package codegen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+)
+
+// Version is the cloudpact release stamped into generated files
+const Version = "0.2.0"
+
+// Header is the provenance line generated files start with, without a
+// comment marker. It names the cloudpact release, the source and the
+// SHA-256 of the source's contents, and ends the way Go tools recognize
+// generated code.
+func Header(source string, content []byte) string {
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf("Code generated by cloudpact v%s from %s (sha256 %s); DO NOT EDIT.",
+		Version, filepath.ToSlash(source), hex.EncodeToString(sum[:]))
+}
+
+// Stamp puts header above code as a comment starting with marker, such as
+// "//" or "#"; an empty header leaves code as it is
+func Stamp(marker, header string, code []byte) []byte {
+	if header == "" {
+		return code
+	}
+	return append([]byte(marker+" "+header+"\n\n"), code...)
+}
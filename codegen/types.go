@@ -0,0 +1,253 @@
+package codegen
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// IsRecordTypeName reports whether a type name refers to a record rather than
+// a built-in semantic type; records are capitalized by convention
+func IsRecordTypeName(cpType string) bool {
+	return cpType != "" && cpType[0] >= 'A' && cpType[0] <= 'Z'
+}
+
+// IsSecret reports whether values of a semantic type are credentials,
+// which generated code keeps out of logs and responses
+func IsSecret(cpType string) bool {
+	switch strings.ToLower(cpType) {
+	case "password", "token", "api_key":
+		return true
+	}
+	return false
+}
+
+// ValidationTag returns the Go validate tag of a semantic type, which the
+// zod schemas and the data dictionary also follow
+func ValidationTag(cpType string) string {
+	switch strings.ToLower(cpType) {
+	case "email":
+		return "required,email"
+	case "url":
+		return "required,url"
+	case "uuid":
+		return "required,uuid"
+	case "phone":
+		return "required,e164" // E.164 phone format
+	case "zip_code":
+		return "required,len=5"
+	case "country_code":
+		return "required,len=2,alpha"
+	case "state_code":
+		return "required,len=2,alpha"
+	case "percentage":
+		return "required,min=0,max=100"
+	case "usd_currency", "eur_currency":
+		return "required,min=0"
+	case "password":
+		return "required,min=8"
+	default:
+		return "required"
+	}
+}
+
+// FieldConstraints lists the constraints a field type may carry, in the
+// order generators emit them
+var FieldConstraints = []string{grammar.ConstraintMin, grammar.ConstraintMax, grammar.ConstraintMinLength, grammar.ConstraintMaxLength, grammar.ConstraintDomain}
+
+// TypeComment describes a semantic type, for TypeScript comments and the
+// data dictionary
+func TypeComment(cpType string) string {
+	switch strings.ToLower(cpType) {
+	case "email":
+		return "Email address format"
+	case "url":
+		return "URL format"
+	case "uuid":
+		return "UUID format"
+	case "phone":
+		return "Phone number format"
+	case "zip_code":
+		return "ZIP/Postal code"
+	case "country_code":
+		return "ISO country code (US, CA, etc.)"
+	case "state_code":
+		return "State/province code"
+	case "usd_currency":
+		return "USD currency amount"
+	case "eur_currency":
+		return "EUR currency amount"
+	case "percentage":
+		return "Percentage (0-100)"
+	case "date":
+		return "Date in YYYY-MM-DD format"
+	case "datetime", "timestamp":
+		return "ISO 8601 datetime"
+	case "password":
+		return "Password (minimum 8 characters)"
+	default:
+		return ""
+	}
+}
+
+// AggregateParts splits an aggregate operand into the collection to loop over,
+// an optional filter, and the value read from each item (nil for the item itself)
+func AggregateParts(e *grammar.AggregateExpression) (collection, filter, value grammar.Expression) {
+	if query, ok := e.Source.(*grammar.QueryExpression); ok {
+		return query.Source, query.Filter, query.Select
+	}
+	return e.Source, nil, nil
+}
+
+// IsNone reports whether e is the none literal
+func IsNone(e grammar.Expression) bool {
+	literal, ok := e.(*grammar.LiteralExpression)
+	return ok && literal.Kind == grammar.LiteralNone
+}
+
+// FloatLiteral keeps a decimal point so 2.0 stays a float in Go
+func FloatLiteral(v float64) string {
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// DefaultedFields returns the fields that declare a default
+func DefaultedFields(all []*grammar.FieldDef) []*grammar.FieldDef {
+	var fields []*grammar.FieldDef
+	for _, field := range all {
+		if field.Default != nil {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// ExampleField is a value a record's example gives, with the JSON key of
+// the field it is for
+type ExampleField struct {
+	Key   string
+	Value *grammar.LiteralExpression
+}
+
+// ExampleFields returns the values the example of record, and those of the
+// records it extends, give, in the order of its fields: the implicit id
+// first and the version last. Durations, given like 90m, are in ISO 8601
+// as the API exchanges them.
+func ExampleFields(record *grammar.Record) []ExampleField {
+	var fields []ExampleField
+	add := func(name, key string, t *grammar.Type) {
+		value := record.ExampleValue(name)
+		if value == nil {
+			return
+		}
+		if text, ok := value.Value.(string); ok && strings.EqualFold(t.Name, "duration") {
+			if d, err := time.ParseDuration(text); err == nil {
+				iso := *value
+				iso.Value = ISODuration(d)
+				value = &iso
+			}
+		}
+		fields = append(fields, ExampleField{Key: key, Value: value})
+	}
+	if record.HasImplicitID() {
+		add("id", "id", &grammar.Type{Name: "uuid"})
+	}
+	for _, field := range record.AllFields() {
+		add(field.Name, field.JSONKey(), field.Type)
+	}
+	if record.IsVersioned() {
+		add("version", "version", &grammar.Type{Name: "int"})
+	}
+	return fields
+}
+
+// ISODuration writes d as an ISO 8601 duration such as PT1H30M
+func ISODuration(d time.Duration) string {
+	if d == 0 {
+		return "PT0S"
+	}
+	var b strings.Builder
+	if d < 0 {
+		b.WriteString("-")
+		d = -d
+	}
+	b.WriteString("PT")
+	if h := d / time.Hour; h > 0 {
+		b.WriteString(strconv.FormatInt(int64(h), 10) + "H")
+		d -= h * time.Hour
+	}
+	if m := d / time.Minute; m > 0 {
+		b.WriteString(strconv.FormatInt(int64(m), 10) + "M")
+		d -= m * time.Minute
+	}
+	if d > 0 {
+		b.WriteString(strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S")
+	}
+	return b.String()
+}
+
+// RequestHeaders returns the headers a function reads from the request
+func RequestHeaders(function *grammar.Function) []*grammar.HeaderDecl {
+	var headers []*grammar.HeaderDecl
+	for _, header := range function.Headers {
+		if header.Direction != grammar.HeaderEmitted {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+// EmittedHeaderNames lists the headers a function's responses carry
+func EmittedHeaderNames(function *grammar.Function) []string {
+	var names []string
+	for _, header := range function.Headers {
+		if header.Direction == grammar.HeaderEmitted {
+			names = append(names, header.Name)
+		}
+	}
+	return names
+}
+
+// FailStatus is the HTTP status generated handlers answer a fail with, by
+// error code
+var FailStatus = map[string]int{
+	grammar.FailBadRequest:   400,
+	grammar.FailUnauthorized: 401,
+	grammar.FailForbidden:    403,
+	grammar.FailNotFound:     404,
+	grammar.FailConflict:     409,
+	grammar.FailInvalid:      422,
+	grammar.FailInternal:     500,
+}
+
+// FailStatements lists the fail statements of a function body in order,
+// including those in the branches of if statements
+func FailStatements(function *grammar.Function) []*grammar.FailStatement {
+	if function.Body == nil {
+		return nil
+	}
+	var fails []*grammar.FailStatement
+	var walk func(stmt grammar.Statement)
+	walk = func(stmt grammar.Statement) {
+		switch s := stmt.(type) {
+		case *grammar.FailStatement:
+			fails = append(fails, s)
+		case *grammar.IfStatement:
+			if s.ThenStmt != nil {
+				walk(s.ThenStmt)
+			}
+			if s.ElseStmt != nil {
+				walk(s.ElseStmt)
+			}
+		}
+	}
+	for _, stmt := range function.Body.Statements {
+		walk(stmt)
+	}
+	return fails
+}
@@ -0,0 +1,409 @@
+// Package config reads cloudpact.yaml, the project settings shared by the
+// build, the dev server, the watcher and the generators.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FileName is the project settings file, found in the project root
+const FileName = "cloudpact.yaml"
+
+// DefaultPort is where the dev and mock servers listen unless port is set
+const DefaultPort = 8080
+
+// DefaultWatchPaths are watched when watch_paths is not set
+var DefaultWatchPaths = []string{"models", "services"}
+
+// Config holds every setting of cloudpact.yaml. Unset settings keep the
+// zero value, except those Load fills with defaults.
+type Config struct {
+	Name     string `yaml:"name"`
+	Version  string `yaml:"version"`
+	GoModule string `yaml:"go_module"` // module path of the packaged Go code
+
+	// Port is where the dev and mock servers listen
+	Port int `yaml:"port"`
+	// WatchPaths are the directories the dev server rebuilds on changes to
+	WatchPaths []string `yaml:"watch_paths"`
+
+	// Targets names the generators to run; empty means all registered
+	Targets []string `yaml:"targets"`
+	// TrackChanges adds dirty-field tracking and Patch types to records
+	TrackChanges bool `yaml:"track_changes"`
+	// Locale picks the why.<locale> translations emitted into comments and
+	// API descriptions; empty means the untranslated why clauses
+	Locale string `yaml:"locale"`
+	// JSONNames is how field names become JSON keys in every target: lower
+	// (the default), camel, snake or as-written
+	JSONNames string `yaml:"json_names"`
+	// VerifyGo compiles and vets the generated Go after each build,
+	// reporting problems against the .cp declarations they came from
+	VerifyGo bool `yaml:"verify_go"`
+	// Outputs moves the files of a generator, such as go or docs, out of
+	// generated/<name> into another directory
+	Outputs map[string]string `yaml:"outputs"`
+
+	API           API           `yaml:"api"`
+	AI            AI            `yaml:"ai"`
+	Package       Package       `yaml:"package"`
+	Server        Server        `yaml:"server"`
+	Persistence   Persistence   `yaml:"persistence"`
+	Deploy        Deploy        `yaml:"deploy"`
+	Serverless    Serverless    `yaml:"serverless"`
+	Observability Observability `yaml:"observability"`
+}
+
+// API describes the generated OpenAPI documents
+type API struct {
+	Title       string `yaml:"title"`
+	Version     string `yaml:"version"`
+	Description string `yaml:"description"`
+	ServerURL   string `yaml:"server_url"`
+	Auth        Auth   `yaml:"auth"`
+}
+
+// Auth declares how callers authenticate with the API, emitted as the
+// security schemes of the OpenAPI documents
+type Auth struct {
+	// Schemes are the security schemes by the name the spec gives them
+	Schemes map[string]AuthScheme `yaml:"schemes"`
+	// Apply names the schemes every operation accepts, any one of them
+	// being enough
+	Apply []string `yaml:"apply"`
+	// Tags names, by operation tag, the schemes those operations accept
+	// instead of Apply; an empty list makes them public
+	Tags map[string][]string `yaml:"tags"`
+}
+
+// AuthScheme is one way of authenticating: a bearer token, an API key or
+// OAuth2
+type AuthScheme struct {
+	Type         string `yaml:"type"`          // bearer, apiKey or oauth2
+	BearerFormat string `yaml:"bearer_format"` // bearer: a hint such as JWT
+	In           string `yaml:"in"`            // apiKey: header, query or cookie
+	Name         string `yaml:"name"`          // apiKey: the header, parameter or cookie holding the key
+	// Flow is the OAuth2 flow: authorization_code, client_credentials,
+	// password or implicit
+	Flow             string            `yaml:"flow"`
+	AuthorizationURL string            `yaml:"authorization_url"`
+	TokenURL         string            `yaml:"token_url"`
+	Scopes           map[string]string `yaml:"scopes"` // scope name to description
+}
+
+// AI picks the provider of cloudpact ai review
+type AI struct {
+	Provider string `yaml:"provider"` // openai, anthropic, ollama, offline or mock
+	Model    string `yaml:"model"`
+	Endpoint string `yaml:"endpoint"` // overrides the provider's default URL
+}
+
+// Package names the packages cloudpact package builds
+type Package struct {
+	// NPMName overrides the npm package name, e.g. "@acme/orders-sdk"
+	NPMName string `yaml:"npm_name"`
+	// GoProxy is a module proxy accepting uploads, such as Athens or
+	// Artifactory; Package pushes the Go module there when set
+	GoProxy string `yaml:"go_proxy"`
+}
+
+// Server shapes the Go main cloudpact gen server writes
+type Server struct {
+	// Framework is the router the generated handlers are registered with:
+	// net/http (the default), chi, echo or fiber
+	Framework string `yaml:"framework"`
+}
+
+// Persistence picks the database layer cloudpact gen db writes
+type Persistence struct {
+	// ORM is gorm (the default), for GORM models of the Go records, or sqlc,
+	// for the SQL schema and queries sqlc compiles
+	ORM string `yaml:"orm"`
+}
+
+// Deploy shapes the Kubernetes manifests or Helm chart cloudpact gen
+// deploy writes for the server cloudpact gen server generates
+type Deploy struct {
+	// Format is kubernetes (the default), for manifests kubectl applies, or
+	// helm, for a chart
+	Format string `yaml:"format"`
+	// Image is the server's container image; <name>:<version> when unset
+	Image    string `yaml:"image"`
+	Replicas int    `yaml:"replicas"` // 1 when unset
+	// Namespace is the namespace of the manifests; a chart is installed
+	// into the namespace helm is given
+	Namespace string `yaml:"namespace"`
+	// Host adds an Ingress routing requests for the host to the server;
+	// without it the server is only reachable inside the cluster
+	Host         string `yaml:"host"`
+	IngressClass string `yaml:"ingress_class"`
+	TLSSecret    string `yaml:"tls_secret"` // the secret holding the host's certificate
+}
+
+// Serverless shapes how cloudpact gen lambda deploys each function as a
+// Lambda
+type Serverless struct {
+	// Framework is sam (the default), for an AWS SAM template, or
+	// serverless, for a serverless.yml of the Serverless Framework
+	Framework string `yaml:"framework"`
+}
+
+// Observability instruments the generated Go
+type Observability struct {
+	// Tracing has the generated handlers and scheduled jobs start an
+	// OpenTelemetry span for each call of a function, and counts the calls
+	// and requests
+	Tracing bool `yaml:"tracing"`
+	// Logging has the generated server log each request with log/slog, and
+	// the generated functions log their calls and returns at debug level.
+	// Values of secret types, such as password, are redacted.
+	Logging bool `yaml:"logging"`
+	// LogLevel is the lowest level the server logs: debug, info (the
+	// default), warn or error
+	LogLevel string `yaml:"log_level"`
+}
+
+// logLevels are the values observability.log_level accepts
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// jsonNamings are the values json_names accepts
+var jsonNamings = []string{"lower", "camel", "snake", "as-written"}
+
+// serverFrameworks are the values server.framework accepts
+var serverFrameworks = []string{"net/http", "chi", "echo", "fiber"}
+
+// persistenceORMs are the values persistence.orm accepts
+var persistenceORMs = []string{"gorm", "sqlc"}
+
+// serverlessFrameworks are the values serverless.framework accepts
+var serverlessFrameworks = []string{"sam", "serverless"}
+
+// deployFormats are the values deploy.format accepts
+var deployFormats = []string{"kubernetes", "helm"}
+
+// authTypes are the values api.auth.schemes.<name>.type accepts
+var authTypes = []string{"bearer", "apiKey", "oauth2"}
+
+// apiKeyLocations are where an apiKey scheme may carry its key
+var apiKeyLocations = []string{"header", "query", "cookie"}
+
+// oauthFlows are the values of an oauth2 scheme's flow
+var oauthFlows = []string{"authorization_code", "client_credentials", "password", "implicit"}
+
+// aiProviders are the values ai.provider accepts
+var aiProviders = []string{"openai", "anthropic", "ollama", "offline", "mock"}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}([-_][A-Za-z0-9]+)*$`)
+
+// dnsLabel is a Kubernetes namespace name
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// dnsName is a Kubernetes secret name
+var dnsName = regexp.MustCompile(`^[a-z0-9]([-.a-z0-9]*[a-z0-9])?$`)
+
+// hostPattern is a host name an Ingress routes, possibly with a leading
+// wildcard
+var hostPattern = regexp.MustCompile(`^(\*\.)?[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// Load reads and validates the settings at path. A missing file means
+// defaults, so commands also work outside a configured project.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %s", path, describeYAMLError(err))
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = DefaultPort
+	}
+	if len(cfg.WatchPaths) == 0 {
+		cfg.WatchPaths = DefaultWatchPaths
+	}
+	return cfg, nil
+}
+
+// Validate reports every setting that holds an impossible value
+func (c *Config) Validate() error {
+	var problems []string
+	if c.Port < 0 || c.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("port must be between 1 and 65535, got %d", c.Port))
+	}
+	for _, dir := range c.WatchPaths {
+		if strings.TrimSpace(dir) == "" {
+			problems = append(problems, "watch_paths cannot hold an empty path")
+		} else if filepath.IsAbs(dir) {
+			problems = append(problems, fmt.Sprintf("watch_paths must be relative to the project root, got %s", dir))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, target := range c.Targets {
+		switch {
+		case strings.TrimSpace(target) == "":
+			problems = append(problems, "targets cannot hold an empty name")
+		case seen[target]:
+			problems = append(problems, fmt.Sprintf("target %s is listed twice", target))
+		}
+		seen[target] = true
+	}
+	names := make([]string, 0, len(c.Outputs))
+	for name := range c.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.TrimSpace(c.Outputs[name]) == "" {
+			problems = append(problems, fmt.Sprintf("outputs.%s cannot be empty", name))
+		}
+	}
+	if c.Locale != "" && !localePattern.MatchString(c.Locale) {
+		problems = append(problems, fmt.Sprintf("locale must be a language tag such as es or pt-BR, got %q", c.Locale))
+	}
+	if c.JSONNames != "" && !slices.Contains(jsonNamings, c.JSONNames) {
+		problems = append(problems, fmt.Sprintf("json_names must be one of %s, got %q", strings.Join(jsonNamings, ", "), c.JSONNames))
+	}
+	if c.API.ServerURL != "" && !absoluteURL(c.API.ServerURL) {
+		problems = append(problems, fmt.Sprintf("api.server_url must be an absolute URL such as http://localhost:8080, got %q", c.API.ServerURL))
+	}
+	problems = append(problems, c.API.Auth.problems()...)
+	if c.AI.Provider != "" && !slices.Contains(aiProviders, c.AI.Provider) {
+		problems = append(problems, fmt.Sprintf("ai.provider must be one of %s, got %q", strings.Join(aiProviders, ", "), c.AI.Provider))
+	}
+	if c.Server.Framework != "" && !slices.Contains(serverFrameworks, c.Server.Framework) {
+		problems = append(problems, fmt.Sprintf("server.framework must be one of %s, got %q", strings.Join(serverFrameworks, ", "), c.Server.Framework))
+	}
+	if c.Persistence.ORM != "" && !slices.Contains(persistenceORMs, c.Persistence.ORM) {
+		problems = append(problems, fmt.Sprintf("persistence.orm must be one of %s, got %q", strings.Join(persistenceORMs, ", "), c.Persistence.ORM))
+	}
+	problems = append(problems, c.Deploy.problems()...)
+	if c.Serverless.Framework != "" && !slices.Contains(serverlessFrameworks, c.Serverless.Framework) {
+		problems = append(problems, fmt.Sprintf("serverless.framework must be one of %s, got %q", strings.Join(serverlessFrameworks, ", "), c.Serverless.Framework))
+	}
+	if c.Observability.LogLevel != "" && !slices.Contains(logLevels, c.Observability.LogLevel) {
+		problems = append(problems, fmt.Sprintf("observability.log_level must be one of %s, got %q", strings.Join(logLevels, ", "), c.Observability.LogLevel))
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// problems reports the impossible values of the deploy settings
+func (d Deploy) problems() []string {
+	var problems []string
+	if d.Format != "" && !slices.Contains(deployFormats, d.Format) {
+		problems = append(problems, fmt.Sprintf("deploy.format must be one of %s, got %q", strings.Join(deployFormats, ", "), d.Format))
+	}
+	if strings.ContainsAny(d.Image, " \t") {
+		problems = append(problems, fmt.Sprintf("deploy.image must be an image reference such as ghcr.io/acme/shop:1.0.0, got %q", d.Image))
+	}
+	if d.Replicas < 0 {
+		problems = append(problems, fmt.Sprintf("deploy.replicas cannot be negative, got %d", d.Replicas))
+	}
+	if d.Namespace != "" && !dnsLabel.MatchString(d.Namespace) {
+		problems = append(problems, fmt.Sprintf("deploy.namespace must be lowercase letters, digits and dashes, got %q", d.Namespace))
+	}
+	if d.Host != "" && !hostPattern.MatchString(d.Host) {
+		problems = append(problems, fmt.Sprintf("deploy.host must be a host name such as shop.example.com, without a scheme or path, got %q", d.Host))
+	}
+	if d.TLSSecret != "" && !dnsName.MatchString(d.TLSSecret) {
+		problems = append(problems, fmt.Sprintf("deploy.tls_secret must be lowercase letters, digits, dashes and dots, got %q", d.TLSSecret))
+	}
+	if d.Host == "" && (d.IngressClass != "" || d.TLSSecret != "") {
+		problems = append(problems, "deploy.ingress_class and deploy.tls_secret need deploy.host, which adds the Ingress they configure")
+	}
+	return problems
+}
+
+// problems reports the impossible values of the auth settings
+func (a Auth) problems() []string {
+	var problems []string
+	names := make([]string, 0, len(a.Schemes))
+	for name := range a.Schemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		scheme := a.Schemes[name]
+		prefix := "api.auth.schemes." + name
+		switch scheme.Type {
+		case "bearer":
+		case "apiKey":
+			if !slices.Contains(apiKeyLocations, scheme.In) {
+				problems = append(problems, fmt.Sprintf("%s.in must be one of %s, got %q", prefix, strings.Join(apiKeyLocations, ", "), scheme.In))
+			}
+			if scheme.Name == "" {
+				problems = append(problems, prefix+".name must name the header, query parameter or cookie holding the key")
+			}
+		case "oauth2":
+			if !slices.Contains(oauthFlows, scheme.Flow) {
+				problems = append(problems, fmt.Sprintf("%s.flow must be one of %s, got %q", prefix, strings.Join(oauthFlows, ", "), scheme.Flow))
+			}
+			if (scheme.Flow == "authorization_code" || scheme.Flow == "implicit") && !absoluteURL(scheme.AuthorizationURL) {
+				problems = append(problems, fmt.Sprintf("%s.authorization_url must be an absolute URL for the %s flow, got %q", prefix, scheme.Flow, scheme.AuthorizationURL))
+			}
+			if scheme.Flow != "implicit" && slices.Contains(oauthFlows, scheme.Flow) && !absoluteURL(scheme.TokenURL) {
+				problems = append(problems, fmt.Sprintf("%s.token_url must be an absolute URL for the %s flow, got %q", prefix, scheme.Flow, scheme.TokenURL))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s.type must be one of %s, got %q", prefix, strings.Join(authTypes, ", "), scheme.Type))
+		}
+	}
+
+	unknown := func(setting string, schemes []string) {
+		for _, name := range schemes {
+			if _, ok := a.Schemes[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s names %s, which is not in api.auth.schemes", setting, name))
+			}
+		}
+	}
+	unknown("api.auth.apply", a.Apply)
+	tags := make([]string, 0, len(a.Tags))
+	for tag := range a.Tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		unknown("api.auth.tags."+tag, a.Tags[tag])
+	}
+	return problems
+}
+
+// absoluteURL reports whether s is a URL with a scheme and host
+func absoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+var unknownField = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+// describeYAMLError rewords the errors of strict decoding, which name Go
+// types, in terms of settings
+func describeYAMLError(err error) string {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err.Error()
+	}
+	lines := make([]string, len(typeErr.Errors))
+	for i, line := range typeErr.Errors {
+		lines[i] = unknownField.ReplaceAllString(line, "unknown setting $1")
+	}
+	return strings.Join(lines, "; ")
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func write(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), FileName))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Port != DefaultPort || !reflect.DeepEqual(cfg.WatchPaths, DefaultWatchPaths) {
+		t.Errorf("expected defaults, got port %d and watch paths %v", cfg.Port, cfg.WatchPaths)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := write(t, `name: shop
+version: 0.1.0
+go_module: example.com/shop
+port: 9090
+watch_paths: [domain]
+targets: [go, ts]
+track_changes: true
+locale: pt-BR
+outputs:
+  go: internal/gen
+api:
+  title: Shop API
+  server_url: https://api.example.com
+ai:
+  provider: anthropic
+package:
+  npm_name: "@acme/shop-sdk"
+server:
+  framework: chi
+persistence:
+  orm: sqlc
+deploy:
+  format: helm
+  image: ghcr.io/acme/shop:0.1.0
+  replicas: 3
+  host: shop.example.com
+  ingress_class: nginx
+serverless:
+  framework: serverless
+observability:
+  tracing: true
+  logging: true
+  log_level: debug
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := &Config{
+		Name:          "shop",
+		Version:       "0.1.0",
+		GoModule:      "example.com/shop",
+		Port:          9090,
+		WatchPaths:    []string{"domain"},
+		Targets:       []string{"go", "ts"},
+		TrackChanges:  true,
+		Locale:        "pt-BR",
+		Outputs:       map[string]string{"go": "internal/gen"},
+		API:           API{Title: "Shop API", ServerURL: "https://api.example.com"},
+		AI:            AI{Provider: "anthropic"},
+		Package:       Package{NPMName: "@acme/shop-sdk"},
+		Server:        Server{Framework: "chi"},
+		Persistence:   Persistence{ORM: "sqlc"},
+		Deploy:        Deploy{Format: "helm", Image: "ghcr.io/acme/shop:0.1.0", Replicas: 3, Host: "shop.example.com", IngressClass: "nginx"},
+		Serverless:    Serverless{Framework: "serverless"},
+		Observability: Observability{Tracing: true, Logging: true, LogLevel: "debug"},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Load = %+v\nwant %+v", cfg, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for content, want := range map[string]string{
+		"prot: 9090\n":                                            "line 1: unknown setting prot",
+		"port: eighty\n":                                          "failed to parse",
+		"port: 70000\n":                                           "port must be between 1 and 65535, got 70000",
+		"targets: [go, go]\n":                                     "target go is listed twice",
+		"watch_paths: [/srv/models]\n":                            "watch_paths must be relative to the project root",
+		"locale: Spanish\n":                                       `locale must be a language tag such as es or pt-BR, got "Spanish"`,
+		"json_names: kebab\n":                                     `json_names must be one of lower, camel, snake, as-written, got "kebab"`,
+		"api:\n  server_url: localhost\n":                         "api.server_url must be an absolute URL",
+		"outputs:\n  ts: ''\n":                                    "outputs.ts cannot be empty",
+		"ai:\n  provider: gpt\n":                                  `ai.provider must be one of openai, anthropic, ollama, offline, mock, got "gpt"`,
+		"server:\n  framework: gin\n":                             `server.framework must be one of net/http, chi, echo, fiber, got "gin"`,
+		"persistence:\n  orm: ent\n":                              `persistence.orm must be one of gorm, sqlc, got "ent"`,
+		"deploy:\n  format: compose\n":                            `deploy.format must be one of kubernetes, helm, got "compose"`,
+		"serverless:\n  framework: cdk\n":                         `serverless.framework must be one of sam, serverless, got "cdk"`,
+		"observability:\n  log_level: trace\n":                    `observability.log_level must be one of debug, info, warn, error, got "trace"`,
+		"deploy:\n  replicas: -2\n":                               "deploy.replicas cannot be negative, got -2",
+		"deploy:\n  namespace: Shop\n":                            `deploy.namespace must be lowercase letters, digits and dashes, got "Shop"`,
+		"deploy:\n  host: https://shop.example.com\n":             "deploy.host must be a host name such as shop.example.com, without a scheme or path",
+		"deploy:\n  ingress_class: nginx\n":                       "deploy.ingress_class and deploy.tls_secret need deploy.host",
+		"api:\n  auth:\n    schemes:\n      key: {type: basic}\n": `api.auth.schemes.key.type must be one of bearer, apiKey, oauth2, got "basic"`,
+		"api:\n  auth:\n    schemes:\n      key: {type: apiKey, in: body}\n":       "api.auth.schemes.key.name must name the header",
+		"api:\n  auth:\n    schemes:\n      sso: {type: oauth2, flow: password}\n": "api.auth.schemes.sso.token_url must be an absolute URL for the password flow",
+		"api:\n  auth:\n    apply: [jwt]\n":                                        "api.auth.apply names jwt, which is not in api.auth.schemes",
+		"port: -1\ntargets: [go, '']\n":                                            "port must be between 1 and 65535, got -1; targets cannot hold an empty name",
+	} {
+		_, err := Load(write(t, content))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, content, err)
+		}
+	}
+}
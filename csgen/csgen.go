@@ -0,0 +1,175 @@
+// Package csgen translates checked CloudPact records and models into C#
+// record types for .NET consumers of the API. Properties carry their JSON
+// keys through System.Text.Json attributes and are validated by the
+// DataAnnotations matching the Go validate tags.
+package csgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Options tunes the generated code
+type Options struct {
+	// Header is the provenance comment the file starts with, usually
+	// codegen.Header; empty means none
+	Header string
+	// Namespace holds the types of a file without a module; empty means
+	// CloudPact
+	Namespace string
+	// Usings are the namespaces of the project's other files, whose
+	// records this file's may name
+	Usings []string
+}
+
+// Namespace is where the types of file go: the module, else fallback,
+// else CloudPact
+func Namespace(file *grammar.File, fallback string) string {
+	if file.Module != nil {
+		return file.Module.Name
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return "CloudPact"
+}
+
+// property is a record or model field as C# declares it
+type property struct {
+	name     string
+	json     string
+	t        *grammar.Type
+	optional bool // may be absent, as opposed to null
+	value    grammar.Expression
+	doc      []string
+}
+
+// GenerateFile translates a checked file into C#: a record type per record
+// and model in a file-scoped namespace. Fields that must be sent are
+// required members, so System.Text.Json rejects payloads without them;
+// optional fields are nullable and left out when null. A record extending
+// another derives from it.
+func GenerateFile(file *grammar.File, opts Options) ([]byte, error) {
+	var code strings.Builder
+	code.WriteString("// Generated C# records from CloudPact\n")
+	code.WriteString("#nullable enable\n\n")
+	code.WriteString("using System;\nusing System.Collections.Generic;\nusing System.ComponentModel.DataAnnotations;\nusing System.Text.Json.Serialization;\n")
+	namespace := Namespace(file, opts.Namespace)
+	for _, using := range opts.Usings {
+		if using != namespace {
+			code.WriteString(fmt.Sprintf("using %s;\n", using))
+		}
+	}
+	code.WriteString(fmt.Sprintf("\nnamespace %s;\n", namespace))
+
+	for _, record := range file.Records {
+		var properties []property
+		for _, field := range record.Fields {
+			properties = append(properties, property{
+				name:     field.Name,
+				json:     field.JSONKey(),
+				t:        field.Type,
+				optional: field.Type.Optional && !field.Type.Nullable, // "maybe" fields are present, if only as null
+				value:    field.Default,
+				doc:      codegen.DocLines(field.Leading, field.Trailing),
+			})
+		}
+		implicitID := record.Extends == "" && record.HasImplicitID()
+		writeRecord(&code, record.Name, record.Extends, codegen.DocLines(record.Leading, record.Trailing), implicitID, record.Versioned, properties)
+	}
+	for _, model := range file.Models {
+		var properties []property
+		for _, field := range model.Fields {
+			properties = append(properties, property{
+				name:     field.Name,
+				json:     field.JSONKey(),
+				t:        field.Type,
+				optional: field.Type.Optional,
+				doc:      codegen.DocLines(field.Leading, field.Trailing),
+			})
+		}
+		writeRecord(&code, model.Name, "", codegen.DocLines(model.Leading, model.Trailing), false, false, properties)
+	}
+	return codegen.Stamp("//", opts.Header, []byte(code.String())), nil
+}
+
+// writeRecord writes a record type with the implicit id and the version of
+// a versioned record first
+func writeRecord(code *strings.Builder, name, extends string, doc []string, implicitID, versioned bool, properties []property) {
+	code.WriteString("\n")
+	writeSummary(code, doc, "")
+	if extends != "" {
+		code.WriteString(fmt.Sprintf("public record %s : %s\n{\n", name, extends))
+	} else {
+		code.WriteString(fmt.Sprintf("public record %s\n{\n", name))
+	}
+
+	var members []string
+	if implicitID {
+		members = append(members, "    [JsonPropertyName(\"id\")]\n    public Guid Id { get; init; } = Guid.NewGuid();\n")
+	}
+	if versioned {
+		members = append(members, "    /// <summary>Increases with every update; sent as the ETag</summary>\n    [JsonPropertyName(\"version\")]\n    public long Version { get; init; }\n")
+	}
+	for _, p := range properties {
+		var member strings.Builder
+		writeSummary(&member, p.doc, "    ")
+		member.WriteString(fmt.Sprintf("    [JsonPropertyName(%q)]\n", p.json))
+
+		csType := PropertyType(p.t)
+		if p.optional && !strings.HasSuffix(csType, "?") {
+			csType += "?"
+		}
+		if p.optional {
+			member.WriteString("    [JsonIgnore(Condition = JsonIgnoreCondition.WhenWritingNull)]\n")
+		}
+		if attrs := annotations(p.t, csType, !p.t.Optional); len(attrs) > 0 {
+			member.WriteString(fmt.Sprintf("    [%s]\n", strings.Join(attrs, ", ")))
+		}
+
+		propertyName := PascalCase(p.name)
+		if propertyName == name {
+			// A member cannot share its type's name
+			propertyName += "Value"
+		}
+		switch value := p.value.(type) {
+		case *grammar.LiteralExpression:
+			member.WriteString(fmt.Sprintf("    public %s %s { get; init; } = %s;\n", csType, propertyName, csLiteral(value, csType)))
+		case *grammar.IdentifierExpression:
+			// "now", which only date and time fields take
+			now := "DateTimeOffset.UtcNow"
+			if strings.TrimSuffix(csType, "?") == "DateOnly" {
+				now = "DateOnly.FromDateTime(DateTime.UtcNow)"
+			}
+			member.WriteString(fmt.Sprintf("    public %s %s { get; init; } = %s;\n", csType, propertyName, now))
+		default:
+			if p.optional {
+				member.WriteString(fmt.Sprintf("    public %s %s { get; init; }\n", csType, propertyName))
+			} else {
+				member.WriteString(fmt.Sprintf("    public required %s %s { get; init; }\n", csType, propertyName))
+			}
+		}
+		members = append(members, member.String())
+	}
+	code.WriteString(strings.Join(members, "\n"))
+	code.WriteString("}\n")
+}
+
+// writeSummary writes lines as an XML documentation summary at indent
+func writeSummary(code *strings.Builder, lines []string, indent string) {
+	switch len(lines) {
+	case 0:
+		return
+	case 1:
+		code.WriteString(fmt.Sprintf("%s/// <summary>%s</summary>\n", indent, xmlEscape(lines[0])))
+		return
+	}
+	code.WriteString(indent + "/// <summary>\n")
+	for _, line := range lines {
+		code.WriteString(strings.TrimRight(indent+"/// "+xmlEscape(line), " ") + "\n")
+	}
+	code.WriteString(indent + "/// </summary>\n")
+}
@@ -0,0 +1,84 @@
+package csgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/internal/testutil"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+func TestGenerateFile(t *testing.T) {
+	src := `module Library
+
+// A library loan & more
+define record Loan versioned
+    memberEmail: email domain "example.com"
+    fee: usd_currency default 10.5
+    discount: percentage
+    renewals: int min 1 max 99
+    note: text optional maxlength 200
+    tags: list of text
+    borrowedAt: datetime default now
+    returnTo: maybe Branch
+    loan: text
+
+define record Renewal extends Loan
+    reason: text
+
+define record Branch no id
+    street: text
+    zip: zip_code
+    phone: phone optional`
+	f := testutil.CheckedFile(t, src)
+	f.NameJSON("camel")
+
+	out, err := GenerateFile(f, Options{Header: "Code generated by cloudpact. DO NOT EDIT.", Usings: []string{"Library", "Users"}})
+	if err != nil {
+		t.Fatalf("GenerateFile error: %v", err)
+	}
+	code := string(out)
+	for _, want := range []string{
+		"// Code generated by cloudpact. DO NOT EDIT.",
+		"#nullable enable",
+		"using System.Text.Json.Serialization;\nusing Users;\n\nnamespace Library;\n",
+		"/// <summary>A library loan &amp; more</summary>\npublic record Loan\n{\n    [JsonPropertyName(\"id\")]\n    public Guid Id { get; init; } = Guid.NewGuid();\n",
+		"    [JsonPropertyName(\"version\")]\n    public long Version { get; init; }\n",
+		"    [JsonPropertyName(\"memberEmail\")]\n    [Required, EmailAddress, RegularExpression(@\"^[^@\\s]+@example\\.com$\")]\n    public required string MemberEmail { get; init; }\n",
+		"    [Range(0.0, double.MaxValue)]\n    public decimal Fee { get; init; } = 10.5m;\n",
+		"    [Range(0.0, 100.0)]\n    public required double Discount { get; init; }\n",
+		"    [Range(1.0, 99.0)]\n    public required long Renewals { get; init; }\n",
+		"    [JsonIgnore(Condition = JsonIgnoreCondition.WhenWritingNull)]\n    [MaxLength(200)]\n    public string? Note { get; init; }\n",
+		"    [Required]\n    public required List<string> Tags { get; init; }\n",
+		"    public DateTimeOffset BorrowedAt { get; init; } = DateTimeOffset.UtcNow;\n",
+		"    [JsonPropertyName(\"returnTo\")]\n    public required Branch? ReturnTo { get; init; }\n",
+		"    [JsonPropertyName(\"loan\")]\n    [Required]\n    public required string LoanValue { get; init; }\n",
+		"public record Renewal : Loan\n{\n    [JsonPropertyName(\"reason\")]\n    [Required]\n    public required string Reason { get; init; }\n}",
+		"public record Branch\n{\n    [JsonPropertyName(\"street\")]",
+		"    [Required, StringLength(5, MinimumLength = 5)]\n    public required string Zip { get; init; }\n",
+		"    [RegularExpression(@\"^\\+[1-9]\\d{1,14}$\")]\n    public string? Phone { get; init; }\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in output:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "using Library;") {
+		t.Error("a file should not use its own namespace")
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	f, err := grammar.ParseString("define record Note\n    body: text")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if got := Namespace(f, ""); got != "CloudPact" {
+		t.Errorf("expected the default namespace, got %s", got)
+	}
+	if got := Namespace(f, "MyShop"); got != "MyShop" {
+		t.Errorf("expected the fallback namespace, got %s", got)
+	}
+	if got := PascalCase("my-shop"); got != "MyShop" {
+		t.Errorf("PascalCase(my-shop) = %s", got)
+	}
+}
@@ -0,0 +1,199 @@
+package csgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// PropertyType maps a CloudPact type to C#. Currencies are decimal, dates
+// DateOnly and datetimes DateTimeOffset; "maybe" types are nullable.
+func PropertyType(t *grammar.Type) string {
+	if t.Nullable {
+		inner := *t
+		inner.Nullable = false
+		return PropertyType(&inner) + "?"
+	}
+	if t.Name == "list" && t.Element != nil {
+		return "List<" + PropertyType(t.Element) + ">"
+	}
+	if t.Name == "map" && t.Key != nil {
+		return fmt.Sprintf("Dictionary<%s, %s>", PropertyType(t.Key), PropertyType(t.Value))
+	}
+
+	switch strings.ToLower(t.Name) {
+	case "int", "integer":
+		return "long"
+	case "float", "number", "percentage":
+		return "double"
+	case "usd_currency", "eur_currency":
+		return "decimal"
+	case "bool", "boolean":
+		return "bool"
+	case "uuid":
+		return "Guid"
+	case "date":
+		return "DateOnly"
+	case "datetime", "timestamp":
+		return "DateTimeOffset"
+	}
+	if codegen.IsRecordTypeName(t.Name) {
+		return t.Name
+	}
+	// Text, times of day, ISO durations and the text semantic types
+	return "string"
+}
+
+// isValueType reports whether a C# type is a struct, which [Required]
+// cannot find missing
+func isValueType(csType string) bool {
+	switch csType {
+	case "long", "double", "decimal", "bool", "Guid", "DateOnly", "DateTimeOffset":
+		return true
+	}
+	return false
+}
+
+// annotations are the DataAnnotations validating a property of type t, as
+// the Go validate tags do: a required value, the semantic type's format and
+// bounds, then the declared constraints, which replace the bounds
+func annotations(t *grammar.Type, csType string, required bool) []string {
+	var attrs []string
+	if required && !isValueType(csType) {
+		attrs = append(attrs, "Required")
+	}
+
+	var rangeMin, rangeMax string
+	var minLength, maxLength string
+	var pattern string
+	switch strings.ToLower(t.Name) {
+	case "email":
+		attrs = append(attrs, "EmailAddress")
+	case "url":
+		attrs = append(attrs, "Url")
+	case "phone":
+		pattern = `^\+[1-9]\d{1,14}$` // E.164, like the e164 tag
+	case "zip_code":
+		attrs = append(attrs, "StringLength(5, MinimumLength = 5)")
+	case "country_code", "state_code":
+		pattern = `^[A-Za-z]{2}$`
+	case "percentage":
+		rangeMin, rangeMax = "0.0", "100.0"
+	case "usd_currency", "eur_currency":
+		rangeMin, rangeMax = "0.0", "double.MaxValue"
+	case "password":
+		minLength = "8"
+	}
+
+	for _, name := range codegen.FieldConstraints {
+		value, ok := t.Constraints[name]
+		if !ok {
+			continue
+		}
+		switch name {
+		case grammar.ConstraintMin:
+			rangeMin = bound(value)
+		case grammar.ConstraintMax:
+			rangeMax = bound(value)
+		case grammar.ConstraintMinLength:
+			minLength = fmt.Sprint(value)
+		case grammar.ConstraintMaxLength:
+			maxLength = fmt.Sprint(value)
+		case grammar.ConstraintDomain:
+			// A property takes one pattern; the domain is the narrower
+			pattern = `^[^@\s]+@` + regexp.QuoteMeta(fmt.Sprint(value)) + `$`
+		}
+	}
+
+	if rangeMin != "" || rangeMax != "" {
+		if rangeMin == "" {
+			rangeMin = "double.MinValue"
+		}
+		if rangeMax == "" {
+			rangeMax = "double.MaxValue"
+		}
+		attrs = append(attrs, fmt.Sprintf("Range(%s, %s)", rangeMin, rangeMax))
+	}
+	if minLength != "" {
+		attrs = append(attrs, fmt.Sprintf("MinLength(%s)", minLength))
+	}
+	if maxLength != "" {
+		attrs = append(attrs, fmt.Sprintf("MaxLength(%s)", maxLength))
+	}
+	if pattern != "" {
+		attrs = append(attrs, fmt.Sprintf("RegularExpression(%s)", verbatim(pattern)))
+	}
+	return attrs
+}
+
+// bound writes a numeric constraint as a double, so Range compares in
+// floating point whatever the property's type
+func bound(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return codegen.FloatLiteral(v)
+	case int:
+		return codegen.FloatLiteral(float64(v))
+	case int64:
+		return codegen.FloatLiteral(float64(v))
+	}
+	return fmt.Sprint(value)
+}
+
+// verbatim writes s as a C# verbatim string, for regular expressions
+func verbatim(s string) string {
+	return `@"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// csLiteral writes a constant as C# source for a property of type csType
+func csLiteral(e *grammar.LiteralExpression, csType string) string {
+	switch v := e.Value.(type) {
+	case string:
+		// A JSON string is a valid C# string
+		var b bytes.Buffer
+		encoder := json.NewEncoder(&b)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(v)
+		return strings.TrimSuffix(b.String(), "\n")
+	case float64:
+		if strings.TrimSuffix(csType, "?") == "decimal" {
+			return codegen.FloatLiteral(v) + "m"
+		}
+		return codegen.FloatLiteral(v)
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// PascalCase writes a CloudPact name as a C# member name: customerEmail
+// and customer_email both give CustomerEmail
+func PascalCase(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' || r == '-' || r == ' ' {
+			upper = true
+			continue
+		}
+		if upper {
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// xmlEscape escapes text for a documentation comment
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,452 @@
+// Package dap serves the Debug Adapter Protocol, so editors such as VS Code
+// can debug CloudPact functions run by the interpreter. It supports one
+// thread, line breakpoints, stepping, variable inspection and evaluation.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/daveroberts0321/cloudpact/interp"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// threadID is the only thread a CloudPact run has
+const threadID = 1
+
+// message is a DAP request, response or event
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command,omitempty"`
+	Event      string          `json:"event,omitempty"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	RequestSeq int             `json:"request_seq,omitempty"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message,omitempty"`
+	Body       interface{}     `json:"body,omitempty"`
+}
+
+// LaunchArguments are the launch settings of a VS Code debug configuration
+type LaunchArguments struct {
+	Program     string                 `json:"program"`  // the .cp file
+	Function    string                 `json:"function"` // the function to run
+	Args        map[string]interface{} `json:"args"`     // by parameter name; missing ones get sample values
+	StopOnEntry bool                   `json:"stopOnEntry"`
+}
+
+type server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	writeMu sync.Mutex
+	seq     int
+
+	debugger   *interp.Debugger
+	interp     *interp.Interpreter
+	launch     *LaunchArguments
+	configured bool
+	started    bool
+	resume     chan interp.Action
+	resuming   *interp.Action // sent to the run once the request is answered
+	done       chan struct{}
+
+	mu         sync.Mutex
+	stop       *interp.Stop
+	refs       []interface{} // values with children, by variablesReference - 1
+	terminated bool
+}
+
+// Serve answers DAP requests read from r, writing responses and events to
+// w, until the client disconnects or r ends
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{
+		in:     bufio.NewReader(r),
+		out:    w,
+		resume: make(chan interp.Action),
+		done:   make(chan struct{}),
+	}
+	s.debugger = interp.NewDebugger(s.pause)
+
+	for {
+		req, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		body, err := s.handle(req)
+		if err != nil {
+			s.send(&message{Type: "response", Command: req.Command, RequestSeq: req.Seq, Message: err.Error()})
+			continue
+		}
+		s.send(&message{Type: "response", Command: req.Command, RequestSeq: req.Seq, Success: true, Body: body})
+
+		switch req.Command {
+		case "initialize":
+			s.event("initialized", nil)
+		case "launch", "configurationDone":
+			s.start()
+		case "continue", "next", "stepIn", "stepOut":
+			action := *s.resuming
+			s.resuming = nil
+			s.resume <- action
+		case "disconnect", "terminate":
+			return nil
+		}
+	}
+}
+
+// read reads one message framed by a Content-Length header
+func (s *server) read() (*message, error) {
+	headers, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length header: %w", err)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.in, data); err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *server) send(msg *message) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.seq++
+	msg.Seq = s.seq
+	data, _ := json.Marshal(msg)
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (s *server) event(name string, body interface{}) {
+	s.send(&message{Type: "event", Event: name, Body: body})
+}
+
+func (s *server) handle(req *message) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+
+	case "launch":
+		var args LaunchArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		file, err := load(args.Program)
+		if err != nil {
+			return nil, err
+		}
+		s.interp = interp.New(file)
+		if _, ok := s.interp.Functions[args.Function]; !ok {
+			return nil, fmt.Errorf("%s has no function %q", args.Program, args.Function)
+		}
+		s.interp.Debugger = s.debugger
+		s.debugger.StopOnEntry = args.StopOnEntry
+		s.launch = &args
+		return nil, nil
+
+	case "setBreakpoints":
+		var args struct {
+			Source      struct{ Path string } `json:"source"`
+			Breakpoints []struct{ Line int }  `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		var lines []int
+		var verified []map[string]interface{}
+		for _, bp := range args.Breakpoints {
+			lines = append(lines, bp.Line)
+			verified = append(verified, map[string]interface{}{"verified": true, "line": bp.Line})
+		}
+		s.debugger.SetBreakpoints(args.Source.Path, lines)
+		return map[string]interface{}{"breakpoints": verified}, nil
+
+	case "configurationDone":
+		s.configured = true
+		return nil, nil
+
+	case "disconnect", "terminate":
+		s.end()
+		return nil, nil
+
+	case "threads":
+		return map[string]interface{}{"threads": []map[string]interface{}{{"id": threadID, "name": "main"}}}, nil
+
+	case "stackTrace":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		frames := []map[string]interface{}{}
+		if s.stop != nil {
+			for i, frame := range s.stop.Stack {
+				entry := map[string]interface{}{"id": i + 1, "name": frame.Function, "line": 0, "column": 0}
+				if frame.Position != nil {
+					entry["line"], entry["column"] = frame.Position.Line, frame.Position.Column
+					entry["source"] = source(frame.Position.File)
+				}
+				frames = append(frames, entry)
+			}
+		}
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+
+	case "scopes":
+		var args struct{ FrameID int }
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		frame, err := s.frame(args.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		scope := map[string]interface{}{
+			"name":               "Locals",
+			"variablesReference": s.reference(map[string]interface{}(frame.Variables)),
+			"expensive":          false,
+		}
+		return map[string]interface{}{"scopes": []interface{}{scope}}, nil
+
+	case "variables":
+		var args struct{ VariablesReference int }
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"variables": s.children(args.VariablesReference)}, nil
+
+	case "evaluate":
+		var args struct {
+			Expression string
+			FrameID    int
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		frame, err := s.frame(args.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		expr, err := grammar.ParseExpression(args.Expression)
+		if err != nil {
+			return nil, err
+		}
+		value, err := s.interp.Inspect(expr, frame.Variables)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": summary(value), "variablesReference": s.reference(value)}, nil
+
+	case "continue":
+		return map[string]interface{}{"allThreadsContinued": true}, s.proceed(interp.Continue)
+	case "next":
+		return nil, s.proceed(interp.StepOver)
+	case "stepIn":
+		return nil, s.proceed(interp.StepIn)
+	case "stepOut":
+		return nil, s.proceed(interp.StepOut)
+	}
+	return nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+// load parses and checks the program to debug
+func load(path string) (*grammar.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file, err := grammar.ParseWithFilename(f, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := analyzer.Check(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func source(path string) map[string]interface{} {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return map[string]interface{}{"name": filepath.Base(path), "path": path}
+}
+
+// start runs the launched function once the client has configured its
+// breakpoints
+func (s *server) start() {
+	if s.launch == nil || !s.configured || s.started {
+		return
+	}
+	s.started = true
+
+	function := s.interp.Functions[s.launch.Function]
+	var args []interface{}
+	for _, param := range function.Parameters {
+		value, ok := s.launch.Args[param.Name]
+		if !ok {
+			value = s.interp.Sample(param.Type)
+		}
+		args = append(args, value)
+	}
+
+	go func() {
+		defer close(s.done)
+		result, err := s.interp.Call(function.Name, args)
+		exitCode := 0
+		var failure *interp.Failure
+		switch {
+		case errors.Is(err, interp.ErrTerminated):
+			exitCode = 1
+		case errors.As(err, &failure):
+			s.event("output", map[string]interface{}{"category": "stderr", "output": "fail: " + failure.Message + "\n"})
+			exitCode = 1
+		case err != nil:
+			s.event("output", map[string]interface{}{"category": "stderr", "output": "error: " + err.Error() + "\n"})
+			exitCode = 1
+		default:
+			s.event("output", map[string]interface{}{"category": "console", "output": fmt.Sprintf("%s returned %s\n", function.Name, interp.Format(result))})
+		}
+		s.event("exited", map[string]interface{}{"exitCode": exitCode})
+		s.event("terminated", nil)
+	}()
+}
+
+// pause runs on the interpreter goroutine at every stop, and waits for the
+// client to resume
+func (s *server) pause(stop *interp.Stop) interp.Action {
+	s.mu.Lock()
+	if s.terminated {
+		s.mu.Unlock()
+		return interp.Terminate
+	}
+	s.stop, s.refs = stop, nil
+	s.mu.Unlock()
+	s.event("stopped", map[string]interface{}{"reason": stop.Reason, "threadId": threadID, "allThreadsStopped": true})
+	return <-s.resume
+}
+
+// proceed readies a paused run to resume with action; the run resumes
+// after the request is answered, so the response precedes the next stop
+func (s *server) proceed(action interp.Action) error {
+	s.mu.Lock()
+	paused := s.stop != nil
+	s.stop, s.refs = nil, nil
+	s.mu.Unlock()
+	if !paused {
+		return fmt.Errorf("not paused")
+	}
+	s.resuming = &action
+	return nil
+}
+
+// end stops a run in progress and waits for it to finish
+func (s *server) end() {
+	if !s.started {
+		return
+	}
+	s.mu.Lock()
+	s.terminated = true
+	paused := s.stop != nil
+	s.stop, s.refs = nil, nil
+	s.mu.Unlock()
+	if paused {
+		s.resume <- interp.Terminate
+	}
+	<-s.done
+}
+
+func (s *server) frame(id int) (interp.StackFrame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop == nil || id < 1 || id > len(s.stop.Stack) {
+		return interp.StackFrame{}, fmt.Errorf("no frame %d", id)
+	}
+	return s.stop.Stack[id-1], nil
+}
+
+// reference registers a record or list so its children can be listed;
+// other values have no children and get 0
+func (s *server) reference(value interface{}) int {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs = append(s.refs, value)
+	return len(s.refs)
+}
+
+func (s *server) children(ref int) []map[string]interface{} {
+	s.mu.Lock()
+	var value interface{}
+	if ref >= 1 && ref <= len(s.refs) {
+		value = s.refs[ref-1]
+	}
+	s.mu.Unlock()
+
+	variables := []map[string]interface{}{}
+	add := func(name string, child interface{}) {
+		variables = append(variables, map[string]interface{}{
+			"name":               name,
+			"value":              summary(child),
+			"variablesReference": s.reference(child),
+		})
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(name, v[name])
+		}
+	case []interface{}:
+		for i, item := range v {
+			add(strconv.Itoa(i), item)
+		}
+	}
+	return variables
+}
+
+// summary renders a value for the variables view; records and lists are
+// expanded on demand instead
+func summary(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return "{…}"
+	case []interface{}:
+		return fmt.Sprintf("list of %d", len(v))
+	}
+	return strings.TrimSpace(interp.Format(value))
+}
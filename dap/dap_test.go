@@ -0,0 +1,164 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const program = `define record Order
+    total: number
+
+function discount(order: Order) returns number
+    why: "Large orders earn a loyalty discount"
+    do:
+        set big = order.total > 100
+        return 10 if big else 0
+`
+
+// client drives a server over pipes
+type client struct {
+	t      *testing.T
+	w      io.Writer
+	r      *bufio.Reader
+	seq    int
+	events []string
+}
+
+func (c *client) request(command string, args interface{}) map[string]interface{} {
+	c.t.Helper()
+	c.seq++
+	data, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	// Written in the background, as the server may be busy sending events
+	go fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	for {
+		msg := c.read()
+		if msg["type"] == "event" {
+			c.events = append(c.events, msg["event"].(string))
+			continue
+		}
+		if msg["command"] != command {
+			c.t.Fatalf("expected a %s response, got %v", command, msg)
+		}
+		if msg["success"] != true {
+			c.t.Fatalf("%s failed: %v", command, msg["message"])
+		}
+		body, _ := msg["body"].(map[string]interface{})
+		return body
+	}
+}
+
+// waitFor reads until the named event arrives and returns its body
+func (c *client) waitFor(event string) map[string]interface{} {
+	c.t.Helper()
+	for {
+		msg := c.read()
+		if msg["type"] == "event" {
+			c.events = append(c.events, msg["event"].(string))
+			if msg["event"] == event {
+				body, _ := msg["body"].(map[string]interface{})
+				return body
+			}
+		}
+	}
+}
+
+func (c *client) read() map[string]interface{} {
+	c.t.Helper()
+	done := make(chan map[string]interface{})
+	go func() {
+		headers, err := textproto.NewReader(c.r).ReadMIMEHeader()
+		if err != nil {
+			done <- nil
+			return
+		}
+		length, _ := strconv.Atoi(headers.Get("Content-Length"))
+		data := make([]byte, length)
+		io.ReadFull(c.r, data)
+		var msg map[string]interface{}
+		json.Unmarshal(data, &msg)
+		done <- msg
+	}()
+	select {
+	case msg := <-done:
+		if msg == nil {
+			c.t.Fatal("connection closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the server")
+	}
+	return nil
+}
+
+func TestDebugSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.cp")
+	if err := os.WriteFile(path, []byte(program), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	served := make(chan error)
+	go func() { served <- Serve(serverIn, serverOut) }()
+	c := &client{t: t, w: clientOut, r: bufio.NewReader(clientIn)}
+
+	if caps := c.request("initialize", map[string]interface{}{"adapterID": "cloudpact"}); caps["supportsConfigurationDoneRequest"] != true {
+		t.Fatalf("unexpected capabilities %v", caps)
+	}
+	c.request("launch", map[string]interface{}{
+		"program":  path,
+		"function": "discount",
+		"args":     map[string]interface{}{"order": map[string]interface{}{"total": 150}},
+	})
+	bps := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []map[string]interface{}{{"line": 8}},
+	})
+	if len(bps["breakpoints"].([]interface{})) != 1 {
+		t.Fatalf("unexpected breakpoints %v", bps)
+	}
+	c.request("configurationDone", nil)
+
+	if stopped := c.waitFor("stopped"); stopped["reason"] != "breakpoint" {
+		t.Fatalf("unexpected stop %v", stopped)
+	}
+	trace := c.request("stackTrace", map[string]interface{}{"threadId": 1})
+	frame := trace["stackFrames"].([]interface{})[0].(map[string]interface{})
+	if frame["name"] != "discount" || frame["line"] != float64(8) {
+		t.Fatalf("unexpected frame %v", frame)
+	}
+
+	scopes := c.request("scopes", map[string]interface{}{"frameId": 1})
+	ref := scopes["scopes"].([]interface{})[0].(map[string]interface{})["variablesReference"]
+	vars := c.request("variables", map[string]interface{}{"variablesReference": ref})
+	values := make(map[string]interface{})
+	for _, v := range vars["variables"].([]interface{}) {
+		v := v.(map[string]interface{})
+		values[v["name"].(string)] = v["value"]
+	}
+	if values["big"] != "true" || values["order"] != "{…}" {
+		t.Fatalf("unexpected variables %v", values)
+	}
+
+	if result := c.request("evaluate", map[string]interface{}{"expression": "order.total", "frameId": 1}); result["result"] != "150" {
+		t.Fatalf("unexpected evaluation %v", result)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": 1})
+	if exited := c.waitFor("exited"); exited["exitCode"] != float64(0) {
+		t.Fatalf("unexpected exit %v", exited)
+	}
+	c.waitFor("terminated")
+	c.request("disconnect", nil)
+	if err := <-served; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+// Package dbgen writes the persistence layer of CloudPact records: GORM
+// models that convert to and from the generated Go structs, or the
+// PostgreSQL schema and queries sqlc compiles into Go. Each record with an
+// id, implicit or a key field, is stored in a table of its own; nested
+// records, lists and maps are stored as JSON.
+package dbgen
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Options tunes the generated code
+type Options struct {
+	// Header is the provenance comment the file starts with; empty means
+	// none
+	Header string
+	// Packages maps records to the import path of their Go package, which
+	// the GORM models import
+	Packages map[string]string
+}
+
+// Package is the Go package of the GORM models and seeds. It goes in a
+// directory of the package of the records they store, so rebuilding the
+// records never leaves stale models beside them.
+const Package = "db"
+
+// Stored reports whether record has a table: it must be identified, by the
+// implicit id or a key field, to be loaded and saved
+func Stored(record *grammar.Record) bool {
+	return record.HasImplicitID() || record.KeyField() != nil
+}
+
+// TableName is where a record is stored: its name in snake case, plural,
+// so OrderLine is in order_lines
+func TableName(record string) string {
+	name := snakeCase(record)
+	switch {
+	case strings.HasSuffix(name, "s"), strings.HasSuffix(name, "x"), strings.HasSuffix(name, "z"),
+		strings.HasSuffix(name, "ch"), strings.HasSuffix(name, "sh"):
+		return name + "es"
+	case strings.HasSuffix(name, "y") && len(name) > 1 && !strings.ContainsRune("aeiou", rune(name[len(name)-2])):
+		return name[:len(name)-1] + "ies"
+	}
+	return name + "s"
+}
+
+// column is a record field, or the implicit id or version, as a table
+// column
+type column struct {
+	name  string
+	field *grammar.FieldDef // nil for the implicit id and the version
+	key   bool
+}
+
+// columns lists the columns of record's table: the implicit id, the
+// version of a versioned record, then every field, inherited ones first
+func columns(record *grammar.Record) []column {
+	var list []column
+	if record.HasImplicitID() {
+		list = append(list, column{name: "id", key: true})
+	}
+	if record.IsVersioned() {
+		list = append(list, column{name: "version"})
+	}
+	key := record.KeyField()
+	for _, field := range record.AllFields() {
+		list = append(list, column{name: snakeCase(field.Name), field: field, key: field == key && !record.HasImplicitID()})
+	}
+	return list
+}
+
+// isJSON reports whether values of type t are stored as JSON
+func isJSON(t *grammar.Type) bool {
+	return t.Name == "list" || t.Name == "map" || codegen.IsRecordTypeName(t.Name)
+}
+
+// SQLType is the PostgreSQL column type of a field of type t. Currencies
+// are exact decimals and durations nanoseconds, like time.Duration.
+func SQLType(t *grammar.Type) string {
+	if isJSON(t) {
+		return "jsonb"
+	}
+	switch strings.ToLower(t.Name) {
+	case "int", "integer", "duration":
+		return "bigint"
+	case "float", "number", "percentage":
+		return "double precision"
+	case "usd_currency", "eur_currency":
+		return "numeric(19,4)"
+	case "bool", "boolean":
+		return "boolean"
+	case "uuid":
+		return "uuid"
+	case "date":
+		return "date"
+	case "datetime", "timestamp":
+		return "timestamptz"
+	case "time":
+		return "time"
+	}
+	if n, ok := t.Constraints[grammar.ConstraintMaxLength]; ok {
+		return fmt.Sprintf("varchar(%v)", n)
+	}
+	return "text"
+}
+
+// checks are the SQL conditions a column of type t must meet: the bounds
+// of its semantic type, replaced by declared ones, as in the validate tags
+func checks(name string, t *grammar.Type) []string {
+	var min, max string
+	switch strings.ToLower(t.Name) {
+	case "usd_currency", "eur_currency":
+		min = "0"
+	case "percentage":
+		min, max = "0", "100"
+	}
+	if value, ok := t.Constraints[grammar.ConstraintMin]; ok {
+		min = fmt.Sprint(value)
+	}
+	if value, ok := t.Constraints[grammar.ConstraintMax]; ok {
+		max = fmt.Sprint(value)
+	}
+	if isJSON(t) || SQLType(t) == "text" || strings.HasPrefix(SQLType(t), "varchar") {
+		// min and max bound lengths there, which the validate tags check
+		return nil
+	}
+	var conditions []string
+	if min != "" {
+		conditions = append(conditions, fmt.Sprintf("%s >= %s", name, min))
+	}
+	if max != "" {
+		conditions = append(conditions, fmt.Sprintf("%s <= %s", name, max))
+	}
+	return conditions
+}
+
+// sqlDefault is a field's default as SQL, or "" when it has none
+func sqlDefault(field *grammar.FieldDef) string {
+	switch value := field.Default.(type) {
+	case *grammar.LiteralExpression:
+		switch v := value.Value.(type) {
+		case string:
+			return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		case float64:
+			return codegen.FloatLiteral(v)
+		case nil:
+			return "NULL"
+		default:
+			return fmt.Sprint(v)
+		}
+	case *grammar.IdentifierExpression:
+		// "now", which only date and time fields take
+		if strings.EqualFold(field.Type.Name, "date") {
+			return "CURRENT_DATE"
+		}
+		return "CURRENT_TIMESTAMP"
+	}
+	return ""
+}
+
+// snakeCase lowercases name, separating its words with underscores
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// pascalCase writes a name as an exported Go identifier: customerEmail and
+// customer_email both give CustomerEmail
+func pascalCase(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' || r == '-' || r == ' ' {
+			upper = true
+			continue
+		}
+		if upper {
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,240 @@
+package dbgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/internal/testutil"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+const shop = `module Shop
+
+// A customer of the shop
+define record Customer versioned
+    emailAddress: email
+    nickname: text optional
+    active: bool default true
+    discount: percentage
+    tags: list of text
+    name: text maxlength 40
+
+define record Product
+    sku: text key
+    price: usd_currency
+    stock: int min 0
+
+define record VipCustomer extends Customer
+    tier: int
+
+define record Line no id
+    sku: text`
+
+// shopPackages puts the records of f in the Go package example.com/app/shop
+func shopPackages(f *grammar.File) map[string]string {
+	packages := make(map[string]string)
+	for _, record := range f.Records {
+		packages[record.Name] = "example.com/app/shop"
+	}
+	return packages
+}
+
+func TestGenerateGORM(t *testing.T) {
+	f := testutil.CheckedFile(t, shop)
+	out, err := GenerateGORM([]*grammar.File{f}, Options{Header: "Code generated by cloudpact. DO NOT EDIT.", Packages: shopPackages(f)})
+	if err != nil {
+		t.Fatalf("GenerateGORM error: %v\n%s", err, out)
+	}
+	code := string(out)
+	for _, want := range []string{
+		"// Code generated by cloudpact. DO NOT EDIT.",
+		"package db",
+		"\"example.com/app/shop\"",
+		"\"gorm.io/gorm\"",
+		"func NewCustomerRow(r *shop.Customer) *CustomerRow {",
+		"ID           string   `gorm:\"column:id;primaryKey;size:36\"`",
+		"Version      int64    `gorm:\"column:version;not null\"`",
+		"Nickname     *string  `gorm:\"column:nickname\"`",
+		"Active       bool     `gorm:\"column:active;not null;default:true\"`",
+		"`gorm:\"column:discount;not null;check:discount >= 0 AND discount <= 100\"`",
+		"`gorm:\"column:tags;serializer:json;not null\"`",
+		"`gorm:\"column:name;size:40;not null\"`",
+		"Sku   string  `gorm:\"column:sku;primaryKey;size:255;not null\"`",
+		"`gorm:\"column:price;type:numeric(19,4);not null;check:price >= 0\"`",
+		"func (CustomerRow) TableName() string {\n\treturn \"customers\"\n}",
+		"Version:      r.Version,",
+		"return &shop.VipCustomer{\n\t\tCustomer: shop.Customer{\n\t\t\tID:           row.ID,\n\t\t\tVersion:      row.Version,",
+		"err := db.First(&row, \"sku = ?\", id).Error",
+		"Where(\"id = ? AND version = ?\", row.ID, row.Version-1).Select(\"*\").Updates(row)",
+		"return shop.ErrCustomerConflict",
+		"return db.Save(NewProductRow(r)).Error",
+		"return db.AutoMigrate(&CustomerRow{}, &ProductRow{}, &VipCustomerRow{})",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in output:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "LineRow") {
+		t.Error("a record without an id has no table")
+	}
+	if strings.Contains(code, "\"time\"") {
+		t.Error("time is imported only when a field needs it")
+	}
+}
+
+func TestGenerateGORMWithoutStoredRecords(t *testing.T) {
+	f := testutil.CheckedFile(t, "define record Line no id\n    sku: text")
+	out, err := GenerateGORM([]*grammar.File{f}, Options{})
+	if out != nil || err != nil {
+		t.Fatalf("expected no file, got %v:\n%s", err, out)
+	}
+}
+
+func TestGenerateSQLC(t *testing.T) {
+	f := testutil.CheckedFile(t, shop)
+	schema := string(GenerateSchema(f.Records, Options{}))
+	for _, want := range []string{
+		"-- A customer of the shop\nCREATE TABLE customers (\n  id text NOT NULL,\n  version bigint NOT NULL DEFAULT 0,",
+		"  nickname text,\n",
+		"  active boolean NOT NULL DEFAULT true,\n",
+		"  discount double precision NOT NULL CHECK (discount >= 0) CHECK (discount <= 100),\n",
+		"  tags jsonb NOT NULL,\n",
+		"  name varchar(40) NOT NULL,\n  PRIMARY KEY (id)\n);",
+		"  price numeric(19,4) NOT NULL CHECK (price >= 0),\n",
+		"  stock bigint NOT NULL CHECK (stock >= 0),\n  PRIMARY KEY (sku)\n);",
+		"CREATE TABLE vip_customers (",
+	} {
+		if !strings.Contains(schema, want) {
+			t.Errorf("expected %q in schema:\n%s", want, schema)
+		}
+	}
+	if strings.Contains(schema, "lines") {
+		t.Error("a record without an id has no table")
+	}
+
+	queries := string(GenerateQueries(f.Records[0], Options{}))
+	for _, want := range []string{
+		"-- name: GetCustomer :one\nSELECT * FROM customers\nWHERE id = $1 LIMIT 1;",
+		"-- name: ListCustomers :many",
+		"INSERT INTO customers (\n  id, version, email_address, nickname, active, discount, tags, name\n) VALUES (\n  $1, $2, $3, $4, $5, $6, $7, $8\n)\nRETURNING *;",
+		"UPDATE customers SET\n  version = $2,\n  email_address = $3,",
+		"WHERE id = $1 AND version = $2 - 1\nRETURNING *;",
+		"-- name: DeleteCustomer :exec\nDELETE FROM customers\nWHERE id = $1;",
+	} {
+		if !strings.Contains(queries, want) {
+			t.Errorf("expected %q in queries:\n%s", want, queries)
+		}
+	}
+	if products := string(GenerateQueries(f.Records[1], Options{})); !strings.Contains(products, "UPDATE products SET\n  price = $2,\n  stock = $3\nWHERE sku = $1\nRETURNING *;") {
+		t.Errorf("expected a Product to be updated by its sku:\n%s", products)
+	}
+}
+
+func TestTableName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Order":      "orders",
+		"OrderLine":  "order_lines",
+		"Address":    "addresses",
+		"Category":   "categories",
+		"Day":        "days",
+		"HTTPLog":    "http_logs",
+		"Box":        "boxes",
+		"BranchInfo": "branch_infos",
+	} {
+		if got := TableName(name); got != want {
+			t.Errorf("TableName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+const seededShop = `module Shop
+
+define record Place no id
+    city: text
+
+define record Customer versioned
+    email: email
+    nickname: text optional
+    joined: datetime
+    home: Place
+
+define record Product
+    sku: text key
+    price: usd_currency min 1 max 5
+    stock: int
+
+seed Customer: 3 records
+    row:
+        email: "ada@example.com"
+        joined: "2024-03-04T15:30:00Z"
+
+seed Product: 2 records`
+
+func TestGenerateSeedSQL(t *testing.T) {
+	f := testutil.CheckedFile(t, seededShop)
+	f.NameJSON("camel")
+	sql := string(GenerateSeedSQL([]*grammar.File{f}, Options{}))
+	for _, want := range []string{
+		"BEGIN;\n\nINSERT INTO customers (id, version, email, nickname, joined, home) VALUES\n",
+		", 1, 'ada@example.com', ",
+		"'2024-03-04T15:30:00Z', '{\"city\":",
+		"INSERT INTO products (sku, price, stock) VALUES\n  ('SKU-0001', ",
+		"  ('SKU-0002', ",
+		";\n\nCOMMIT;\n",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("expected %q in seed:\n%s", want, sql)
+		}
+	}
+	if again := string(GenerateSeedSQL([]*grammar.File{f}, Options{})); again != sql {
+		t.Error("seeding twice gave different data")
+	}
+	if strings.Count(sql, "@example.com'") != 3 {
+		t.Errorf("expected three emails:\n%s", sql)
+	}
+}
+
+func TestGenerateSeedGORM(t *testing.T) {
+	f := testutil.CheckedFile(t, seededShop)
+	out, err := GenerateSeedGORM([]*grammar.File{f}, Options{Header: "Code generated by cloudpact. DO NOT EDIT.", Packages: shopPackages(f)})
+	if err != nil {
+		t.Fatalf("GenerateSeedGORM error: %v\n%s", err, out)
+	}
+	code := string(out)
+	for _, want := range []string{
+		"// Code generated by cloudpact. DO NOT EDIT.",
+		"\"time\"",
+		"// inserts 3 Customer and 2 Product.\nfunc Seed(db *gorm.DB) error {\n\treturn db.Transaction(func(tx *gorm.DB) error {",
+		"if err := tx.Create([]*CustomerRow{",
+		"Version: 1, Email: \"ada@example.com\"",
+		"Joined: time.Date(2024, 3, 4, 15, 30, 0, 0, time.UTC), Home: shop.Place{City: ",
+		"{Sku: \"SKU-0001\", Price: ",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in output:\n%s", want, code)
+		}
+	}
+
+	f = testutil.CheckedFile(t, "define record Line\n    sku: text")
+	if out, err := GenerateSeedGORM([]*grammar.File{f}, Options{}); out != nil || err != nil {
+		t.Fatalf("expected no file without seeds, got %v:\n%s", err, out)
+	}
+}
+
+func TestFakeRespectsTypes(t *testing.T) {
+	f := testutil.CheckedFile(t, seededShop)
+	records := map[string]*grammar.Record{}
+	for _, record := range f.Records {
+		records[record.Name] = record
+	}
+	product := records["Product"]
+	for i := 0; i < 50; i++ {
+		row := fakeRecord(product, i, nil, records, 0)
+		if price := row.values["price"].(float64); price < 1 || price > 5 {
+			t.Errorf("row %d: price %v is outside 1 to 5", i, price)
+		}
+	}
+	if id := fakeUUID("Customer.id", 0); len(id) != 36 || id[14] != '4' {
+		t.Errorf("expected a version 4 UUID, got %s", id)
+	}
+}
@@ -0,0 +1,315 @@
+package dbgen
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Seeded values are nil, bool, int64, float64, string, time.Time,
+// time.Duration, []interface{}, map[string]interface{} or *recordValue.
+// They are derived from the record, field and row number alone, so seeding
+// gives the same data on every run.
+
+// recordValue is a seeded record: the value of each field by name, with
+// "id" and "version" for the implicit id and the version
+type recordValue struct {
+	record *grammar.Record
+	values map[string]interface{}
+}
+
+// seedEpoch is the earliest seeded date and time
+var seedEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	firstNames = []string{"Ada", "Grace", "Alan", "Katherine", "Linus", "Margaret", "Dennis", "Barbara", "Ken", "Frances", "Edsger", "Radia"}
+	lastNames  = []string{"Lovelace", "Hopper", "Turing", "Johnson", "Torvalds", "Hamilton", "Ritchie", "Liskov", "Thompson", "Allen", "Dijkstra", "Perlman"}
+	streets    = []string{"Maple", "Oak", "Cedar", "Elm", "Pine", "Birch", "Willow", "Spruce"}
+	suffixes   = []string{"St", "Ave", "Rd", "Ln", "Way", "Blvd"}
+	cities     = []string{"Springfield", "Riverside", "Fairview", "Franklin", "Greenville", "Bristol", "Clinton", "Madison"}
+	companies  = []string{"Acme Corp", "Globex", "Initech", "Umbrella Labs", "Stark Industries", "Wayne Enterprises", "Hooli", "Vandelay Industries"}
+	countries  = []string{"US", "CA", "GB", "DE", "FR", "JP", "AU", "BR"}
+	states     = []string{"CA", "NY", "TX", "WA", "IL", "MA", "CO", "OR"}
+	sentences  = []string{
+		"Lorem ipsum dolor sit amet.",
+		"Consectetur adipiscing elit, sed do eiusmod tempor.",
+		"Ut enim ad minim veniam, quis nostrud exercitation.",
+		"Duis aute irure dolor in reprehenderit in voluptate.",
+	}
+)
+
+// seedRows returns the values of each row seed puts in record's table:
+// its literal rows, then generated ones up to its count. Fields a literal
+// row leaves out are generated. records resolves nested record types.
+func seedRows(seed *grammar.Seed, record *grammar.Record, records map[string]*grammar.Record) []*recordValue {
+	var rows []*recordValue
+	for i := 0; i < seed.Count; i++ {
+		var row *grammar.Example
+		if i < len(seed.Rows) {
+			row = seed.Rows[i]
+		}
+		rows = append(rows, fakeRecord(record, i, row, records, 0))
+	}
+	return rows
+}
+
+// fakeRecord generates row i of record, taking the values literal gives
+func fakeRecord(record *grammar.Record, i int, literal *grammar.Example, records map[string]*grammar.Record, depth int) *recordValue {
+	value := &recordValue{record: record, values: make(map[string]interface{})}
+	if record.HasImplicitID() {
+		value.values["id"] = fakeUUID(record.Name+".id", i)
+		if given := literal.Value("id"); given != nil {
+			value.values["id"] = given.Value
+		}
+	}
+	if record.IsVersioned() {
+		value.values["version"] = int64(1)
+		if given := literal.Value("version"); given != nil {
+			value.values["version"] = given.Value
+		}
+	}
+	key := record.KeyField()
+	for _, field := range record.AllFields() {
+		if given := literal.Value(field.Name); given != nil {
+			value.values[field.Name] = literalValue(field.Type, given)
+			continue
+		}
+		if defaulted, ok := field.Default.(*grammar.LiteralExpression); ok {
+			value.values[field.Name] = literalValue(field.Type, defaulted)
+			continue
+		}
+		if field == key && depth == 0 {
+			value.values[field.Name] = fakeKey(field, i)
+			continue
+		}
+		value.values[field.Name] = fake(record.Name+"."+field.Name, field.Name, field.Type, i, records, depth)
+	}
+	return value
+}
+
+// literalValue is a constant given for a field of type t as a seeded value:
+// temporal text becomes a time or duration, and a whole number for a
+// decimal field a float
+func literalValue(t *grammar.Type, literal *grammar.LiteralExpression) interface{} {
+	switch v := literal.Value.(type) {
+	case string:
+		switch strings.ToLower(t.Name) {
+		case "duration":
+			if d, err := time.ParseDuration(v); err == nil {
+				return d
+			}
+		case "date", "datetime", "timestamp":
+			if at, err := grammar.ParseTime(v); err == nil {
+				return at
+			}
+		}
+	case int64:
+		if !isWhole(t) {
+			return float64(v)
+		}
+	}
+	return literal.Value
+}
+
+// fakeKey is the key of row i: a number, a UUID or text such as SKU-0001
+func fakeKey(field *grammar.FieldDef, i int) interface{} {
+	switch {
+	case isWhole(field.Type):
+		return int64(i + 1)
+	case strings.EqualFold(field.Type.Name, "uuid"):
+		return fakeUUID(field.Name, i)
+	}
+	return fitLength(fmt.Sprintf("%s-%04d", strings.ToUpper(snakeCase(field.Name)), i+1), field.Type)
+}
+
+// fake generates the value of a field called name of type t for row i;
+// path names the field within the record, for hashing
+func fake(path, name string, t *grammar.Type, i int, records map[string]*grammar.Record, depth int) interface{} {
+	h := seedHash(path, i)
+	// Every fourth row leaves an optional field out, at random
+	if t.Optional && h%4 == 0 {
+		return nil
+	}
+	h /= 4
+	lower := strings.ToLower(name)
+
+	// A record named like a semantic type, such as Address, is still a record
+	if record, ok := records[t.Name]; ok {
+		if depth >= 2 {
+			return nil
+		}
+		return fakeRecord(record, int(h%1000), nil, records, depth+1)
+	}
+
+	switch strings.ToLower(t.Name) {
+	case "list":
+		items := []interface{}{}
+		if t.Element != nil && depth < 2 {
+			for k := 0; k < 1+int(h%2); k++ {
+				items = append(items, fake(path, name, t.Element, i*3+k, records, depth+1))
+			}
+		}
+		return items
+	case "map":
+		entries := map[string]interface{}{}
+		if t.Key != nil && t.Value != nil && depth < 2 {
+			entries[fmt.Sprint(fake(path+".key", name, t.Key, i, records, depth+1))] = fake(path+".value", name, t.Value, i, records, depth+1)
+		}
+		return entries
+	case "int", "integer":
+		lo, hi := bounds(t, 1, 100)
+		return int64(lo) + int64(h%uint64(hi-lo+1))
+	case "float", "number":
+		lo, hi := bounds(t, 0, 100)
+		return lo + float64(h%uint64((hi-lo)*10+1))/10
+	case "usd_currency", "eur_currency":
+		lo, hi := bounds(t, 0, 1000)
+		return lo + float64(h%uint64((hi-lo)*100+1))/100
+	case "percentage":
+		lo, hi := bounds(t, 0, 100)
+		return lo + float64(h%uint64((hi-lo)*10+1))/10
+	case "bool", "boolean":
+		return h%2 == 0
+	case "date":
+		return seedEpoch.AddDate(0, 0, int(h%365))
+	case "datetime", "timestamp":
+		return seedEpoch.Add(time.Duration(h%(365*24*60)) * time.Minute)
+	case "duration":
+		return time.Duration(1+h%96) * 15 * time.Minute
+	case "time":
+		return fmt.Sprintf("%02d:%02d:00", 8+h%10, (h/10%4)*15)
+	case "uuid":
+		return fakeUUID(path, i)
+	case "email":
+		first, last := firstNames[h%uint64(len(firstNames))], lastNames[h/16%uint64(len(lastNames))]
+		domain := "example.com"
+		if value, ok := t.Constraints[grammar.ConstraintDomain]; ok {
+			domain = fmt.Sprint(value)
+		}
+		return fitLength(fmt.Sprintf("%s.%s%d@%s", strings.ToLower(first), strings.ToLower(last), i+1, domain), t)
+	case "url":
+		return fmt.Sprintf("https://example.com/%s/%d", strings.ReplaceAll(snakeCase(name), "_", "-"), i+1)
+	case "phone":
+		return fmt.Sprintf("+1555%07d", h%10000000)
+	case "zip_code":
+		return fmt.Sprintf("%05d", 10000+h%90000)
+	case "country_code":
+		return countries[h%uint64(len(countries))]
+	case "state_code":
+		return states[h%uint64(len(states))]
+	case "address":
+		return fakeAddress(h)
+	case "password":
+		return fitLength(fmt.Sprintf("seed-password-%d", i+1), t)
+	case "token", "api_key":
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%s/%d", path, i))))[:32]
+	case "json":
+		return "{}"
+	case "html":
+		return "<p>" + sentences[h%uint64(len(sentences))] + "</p>"
+	case "markdown":
+		return sentences[h%uint64(len(sentences))]
+	}
+
+	// Text: guess from the field's name
+	var text string
+	switch {
+	case strings.Contains(lower, "first"):
+		text = firstNames[h%uint64(len(firstNames))]
+	case strings.Contains(lower, "last") || strings.Contains(lower, "surname"):
+		text = lastNames[h%uint64(len(lastNames))]
+	case strings.Contains(lower, "company") || strings.Contains(lower, "organization"):
+		text = companies[h%uint64(len(companies))]
+	case strings.Contains(lower, "city"):
+		text = cities[h%uint64(len(cities))]
+	case strings.Contains(lower, "street") || strings.Contains(lower, "address"):
+		text = fakeAddress(h)
+	case strings.Contains(lower, "name"):
+		text = firstNames[h%uint64(len(firstNames))] + " " + lastNames[h/16%uint64(len(lastNames))]
+	case strings.Contains(lower, "description") || strings.Contains(lower, "note") || strings.Contains(lower, "comment") ||
+		strings.Contains(lower, "summary") || strings.Contains(lower, "bio"):
+		text = sentences[h%uint64(len(sentences))]
+	default:
+		text = fmt.Sprintf("%s %d", strings.ReplaceAll(snakeCase(name), "_", " "), i+1)
+	}
+	return fitLength(text, t)
+}
+
+// fakeAddress is a street address such as 42 Maple Ave
+func fakeAddress(h uint64) string {
+	return fmt.Sprintf("%d %s %s", 1+h%999, streets[h/1000%uint64(len(streets))], suffixes[h/8000%uint64(len(suffixes))])
+}
+
+// fitLength shortens or pads text to the lengths t allows
+func fitLength(text string, t *grammar.Type) string {
+	if n, ok := wholeConstraint(t, grammar.ConstraintMaxLength); ok && len(text) > n {
+		text = text[:n]
+	}
+	if n, ok := wholeConstraint(t, grammar.ConstraintMinLength); ok && len(text) < n {
+		text += strings.Repeat("x", n-len(text))
+	}
+	return text
+}
+
+// bounds is the range of generated numbers of type t: its min and max,
+// else lo and hi, keeping the range as wide when only one is given
+func bounds(t *grammar.Type, lo, hi float64) (float64, float64) {
+	min, hasMin := numberConstraint(t, grammar.ConstraintMin)
+	max, hasMax := numberConstraint(t, grammar.ConstraintMax)
+	switch {
+	case hasMin && hasMax:
+		return min, max
+	case hasMin:
+		return min, min + hi - lo
+	case hasMax:
+		if max < hi {
+			return max - (hi - lo), max
+		}
+	}
+	return lo, hi
+}
+
+// numberConstraint is the value of a numeric constraint of t
+func numberConstraint(t *grammar.Type, name string) (float64, bool) {
+	switch v := t.Constraints[name].(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// wholeConstraint is the value of a length constraint of t
+func wholeConstraint(t *grammar.Type, name string) (int, bool) {
+	n, ok := numberConstraint(t, name)
+	return int(n), ok
+}
+
+// isWhole reports whether values of type t are integers
+func isWhole(t *grammar.Type) bool {
+	switch strings.ToLower(t.Name) {
+	case "int", "integer":
+		return true
+	}
+	return false
+}
+
+// seedHash mixes path and row number into the number generated values are
+// picked by
+func seedHash(path string, i int) uint64 {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", path, i)))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// fakeUUID is a version 4 UUID derived from path and row number
+func fakeUUID(path string, i int) string {
+	b := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/uuid", path, i)))
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
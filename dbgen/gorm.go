@@ -0,0 +1,205 @@
+package dbgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// GenerateGORM writes the GORM models of the stored records of files, which
+// share a Go package, in package db: a <Record>Row struct per record with
+// the conversions to and from the generated struct, which opts.Packages
+// imports, Load<Record> and Save<Record> for the record handlers, and
+// AutoMigrate creating every table. It returns nil when no record is
+// stored. When the code is not valid Go, it is returned unformatted along
+// with the error.
+func GenerateGORM(files []*grammar.File, opts Options) ([]byte, error) {
+	var records []*grammar.Record
+	for _, file := range files {
+		for _, record := range file.Records {
+			if Stored(record) {
+				records = append(records, record)
+			}
+		}
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	var code strings.Builder
+	for _, record := range records {
+		writeGORMRow(&code, record)
+		writeGORMAccess(&code, record)
+	}
+	var rows []string
+	for _, record := range records {
+		rows = append(rows, fmt.Sprintf("&%sRow{}", record.Name))
+	}
+	code.WriteString("\n// AutoMigrate creates the tables of the package's records, or adds the\n// columns, indexes and constraints they are missing\n")
+	code.WriteString("func AutoMigrate(db *gorm.DB) error {\n")
+	code.WriteString(fmt.Sprintf("\treturn db.AutoMigrate(%s)\n}\n", strings.Join(rows, ", ")))
+
+	// Versioned records are saved with the conflict error of their package
+	packages := make(map[string]string, len(opts.Packages))
+	for name, importPath := range opts.Packages {
+		packages[name] = importPath
+	}
+	for _, record := range records {
+		if record.IsVersioned() {
+			base := baseVersioned(record).Name
+			packages["Err"+base+"Conflict"] = packages[base]
+		}
+	}
+	formatted, err := gogen.RenderFile(Package, []string{"errors", "time", "gorm.io/gorm"}, code.String(), packages)
+	if err != nil {
+		return formatted, err
+	}
+	return codegen.Stamp("//", opts.Header, append([]byte("// Generated GORM models from CloudPact\n\n"), formatted...)), nil
+}
+
+// writeGORMRow writes the row struct of record, its table name and the
+// conversions
+func writeGORMRow(code *strings.Builder, record *grammar.Record) {
+	name := record.Name
+	table := TableName(name)
+	code.WriteString(fmt.Sprintf("\n// %sRow is a %s as GORM stores it in the %s table\n", name, name, table))
+	code.WriteString(fmt.Sprintf("type %sRow struct {\n", name))
+	var toRow []string
+	for _, c := range columns(record) {
+		switch {
+		case c.field == nil && c.name == "id":
+			code.WriteString("\tID string `gorm:\"column:id;primaryKey;size:36\"`\n")
+			toRow = append(toRow, "ID: r.ID")
+		case c.field == nil:
+			code.WriteString("\tVersion int64 `gorm:\"column:version;not null\"`\n")
+			toRow = append(toRow, "Version: r.Version")
+		default:
+			goName := pascalCase(c.field.Name)
+			code.WriteString(fmt.Sprintf("\t%s %s `gorm:\"%s\"`\n", goName, gogen.FieldType(c.field.Type), gormTag(c)))
+			toRow = append(toRow, fmt.Sprintf("%s: r.%s", goName, gogen.FieldName(c.field.Name)))
+		}
+	}
+	code.WriteString("}\n")
+
+	code.WriteString(fmt.Sprintf("\n// TableName is where GORM stores %sRow\n", name))
+	code.WriteString(fmt.Sprintf("func (%sRow) TableName() string {\n\treturn %q\n}\n", name, table))
+
+	code.WriteString(fmt.Sprintf("\n// New%sRow is r as GORM stores it\n", name))
+	code.WriteString(fmt.Sprintf("func New%sRow(r *%s) *%sRow {\n", name, name, name))
+	code.WriteString(fmt.Sprintf("\treturn &%sRow{\n\t\t%s,\n\t}\n}\n", name, strings.Join(toRow, ",\n\t\t")))
+
+	code.WriteString(fmt.Sprintf("\n// Record is the %s stored in row\n", name))
+	code.WriteString(fmt.Sprintf("func (row *%sRow) Record() *%s {\n", name, name))
+	code.WriteString(fmt.Sprintf("\treturn &%s\n}\n", recordLiteral(record)))
+}
+
+// recordLiteral writes the composite literal of record from a row's
+// fields, nesting the literal of the record it extends
+func recordLiteral(record *grammar.Record) string {
+	var parts []string
+	if record.Base != nil {
+		parts = append(parts, fmt.Sprintf("%s: %s", record.Base.Name, recordLiteral(record.Base)))
+	} else if record.HasImplicitID() {
+		parts = append(parts, "ID: row.ID")
+	}
+	if record.Versioned {
+		parts = append(parts, "Version: row.Version")
+	}
+	for _, field := range record.Fields {
+		parts = append(parts, fmt.Sprintf("%s: row.%s", gogen.FieldName(field.Name), pascalCase(field.Name)))
+	}
+	return fmt.Sprintf("%s{\n%s,\n}", record.Name, strings.Join(parts, ",\n"))
+}
+
+// gormTag is the gorm struct tag of a field's column
+func gormTag(c column) string {
+	t := c.field.Type
+	options := []string{"column:" + c.name}
+	if c.key {
+		options = append(options, "primaryKey")
+	}
+	switch {
+	case isJSON(t):
+		options = append(options, "serializer:json")
+	case strings.EqualFold(t.Name, "date"):
+		options = append(options, "type:date")
+	case SQLType(t) == "numeric(19,4)":
+		options = append(options, "type:numeric(19,4)")
+	case strings.HasPrefix(SQLType(t), "varchar"):
+		options = append(options, fmt.Sprintf("size:%v", t.Constraints[grammar.ConstraintMaxLength]))
+	case c.key && SQLType(t) == "text":
+		// Databases index bounded strings
+		options = append(options, "size:255")
+	}
+	if !t.Optional {
+		options = append(options, "not null")
+	}
+	if value := sqlDefault(c.field); value != "" && value != "CURRENT_DATE" && value != "CURRENT_TIMESTAMP" {
+		// The generated constructors set "now"; GORM would not send a zero time
+		options = append(options, "default:"+value)
+	}
+	// GORM keeps one check per field
+	if conditions := checks(c.name, t); len(conditions) > 0 {
+		options = append(options, "check:"+strings.Join(conditions, " AND "))
+	}
+	return strings.Join(options, ";")
+}
+
+// writeGORMAccess writes Load<Record> and Save<Record>, which the record
+// handlers take for load and save
+func writeGORMAccess(code *strings.Builder, record *grammar.Record) {
+	name := record.Name
+	key := "id"
+	if !record.HasImplicitID() {
+		key = snakeCase(record.KeyField().Name)
+	}
+
+	code.WriteString(fmt.Sprintf("\n// Load%s loads a %s from db by %s; it returns nil when none has it\n", name, name, key))
+	code.WriteString(fmt.Sprintf("func Load%s(db *gorm.DB) func(id string) (*%s, error) {\n", name, name))
+	code.WriteString(fmt.Sprintf("\treturn func(id string) (*%s, error) {\n", name))
+	code.WriteString(fmt.Sprintf("\t\tvar row %sRow\n", name))
+	code.WriteString(fmt.Sprintf("\t\terr := db.First(&row, %q, id).Error\n", key+" = ?"))
+	code.WriteString("\t\tif errors.Is(err, gorm.ErrRecordNotFound) {\n\t\t\treturn nil, nil\n\t\t}\n")
+	code.WriteString("\t\tif err != nil {\n\t\t\treturn nil, err\n\t\t}\n")
+	code.WriteString("\t\treturn row.Record(), nil\n\t}\n}\n")
+
+	if record.IsVersioned() {
+		code.WriteString(fmt.Sprintf("\n// Save%s replaces a %s in db when the stored one is a version behind,\n", name, name))
+		code.WriteString(fmt.Sprintf("// and returns Err%sConflict when another save came first. New ones are\n", baseVersioned(record).Name))
+		code.WriteString(fmt.Sprintf("// inserted with db.Create(New%sRow(r)).\n", name))
+		code.WriteString(fmt.Sprintf("func Save%s(db *gorm.DB) func(*%s) error {\n", name, name))
+		code.WriteString(fmt.Sprintf("\treturn func(r *%s) error {\n", name))
+		code.WriteString(fmt.Sprintf("\t\trow := New%sRow(r)\n", name))
+		code.WriteString(fmt.Sprintf("\t\tresult := db.Model(&%sRow{}).Where(%q, row.%s, row.Version-1).Select(\"*\").Updates(row)\n", name, key+" = ? AND version = ?", rowKey(record)))
+		code.WriteString("\t\tif result.Error != nil {\n\t\t\treturn result.Error\n\t\t}\n")
+		code.WriteString(fmt.Sprintf("\t\tif result.RowsAffected == 0 {\n\t\t\treturn Err%sConflict\n\t\t}\n", baseVersioned(record).Name))
+		code.WriteString("\t\treturn nil\n\t}\n}\n")
+		return
+	}
+	code.WriteString(fmt.Sprintf("\n// Save%s inserts a %s into db, or replaces the one with its %s\n", name, name, key))
+	code.WriteString(fmt.Sprintf("func Save%s(db *gorm.DB) func(*%s) error {\n", name, name))
+	code.WriteString(fmt.Sprintf("\treturn func(r *%s) error {\n", name))
+	code.WriteString(fmt.Sprintf("\t\treturn db.Save(New%sRow(r)).Error\n\t}\n}\n", name))
+}
+
+// rowKey is the row field holding record's identity
+func rowKey(record *grammar.Record) string {
+	if record.HasImplicitID() {
+		return "ID"
+	}
+	return pascalCase(record.KeyField().Name)
+}
+
+// baseVersioned is the record declaring the conflict error of a versioned
+// record: the nearest one in its chain that says versioned
+func baseVersioned(record *grammar.Record) *grammar.Record {
+	for r := record; r != nil; r = r.Base {
+		if r.Versioned {
+			return r
+		}
+	}
+	return record
+}
@@ -0,0 +1,306 @@
+package dbgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// seeded is a seed with the record it names and the rows it gives
+type seeded struct {
+	record *grammar.Record
+	rows   []*recordValue
+}
+
+// collectSeeds returns the seeds of files in the order they are declared,
+// with their rows generated
+func collectSeeds(files []*grammar.File) []seeded {
+	var list []seeded
+	for _, file := range files {
+		records := make(map[string]*grammar.Record)
+		for _, record := range file.Records {
+			records[record.Name] = record
+		}
+		for _, seed := range file.Seeds {
+			record, ok := records[seed.Record]
+			if !ok || !Stored(record) {
+				continue
+			}
+			list = append(list, seeded{record: record, rows: seedRows(seed, record, records)})
+		}
+	}
+	return list
+}
+
+// GenerateSeedSQL writes the INSERT statements of the seeds of files, in
+// one transaction. It returns nil when they seed nothing.
+func GenerateSeedSQL(files []*grammar.File, opts Options) []byte {
+	seeds := collectSeeds(files)
+	var code strings.Builder
+	for _, seed := range seeds {
+		if len(seed.rows) == 0 {
+			continue
+		}
+		cols := columns(seed.record)
+		var names []string
+		for _, c := range cols {
+			names = append(names, c.name)
+		}
+		code.WriteString(fmt.Sprintf("\nINSERT INTO %s (%s) VALUES\n", TableName(seed.record.Name), strings.Join(names, ", ")))
+		for i, row := range seed.rows {
+			var values []string
+			for _, c := range cols {
+				values = append(values, sqlValue(columnType(c), row.values[columnKey(c)]))
+			}
+			end := ","
+			if i == len(seed.rows)-1 {
+				end = ";"
+			}
+			code.WriteString(fmt.Sprintf("  (%s)%s\n", strings.Join(values, ", "), end))
+		}
+	}
+	if code.Len() == 0 {
+		return nil
+	}
+	sql := "BEGIN;\n" + code.String() + "\nCOMMIT;\n"
+	return codegen.Stamp("--", opts.Header, []byte(sql))
+}
+
+// GenerateSeedGORM writes Seed, which inserts the seeds of files through
+// the <Record>Row models of GenerateGORM, in the package db beside them. It
+// returns nil when they seed nothing. When the code is not valid Go, it is
+// returned unformatted along with the error.
+func GenerateSeedGORM(files []*grammar.File, opts Options) ([]byte, error) {
+	seeds := collectSeeds(files)
+	var body strings.Builder
+	var counts []string
+	for _, seed := range seeds {
+		if len(seed.rows) == 0 {
+			continue
+		}
+		name := seed.record.Name
+		counts = append(counts, fmt.Sprintf("%d %s", len(seed.rows), name))
+		body.WriteString(fmt.Sprintf("\t\tif err := tx.Create([]*%sRow{\n", name))
+		for _, row := range seed.rows {
+			var fields []string
+			for _, c := range columns(seed.record) {
+				value := row.values[columnKey(c)]
+				if value == nil {
+					continue
+				}
+				fields = append(fields, fmt.Sprintf("%s: %s", rowField(c), goValue(columnType(c), value)))
+			}
+			body.WriteString(fmt.Sprintf("\t\t\t{%s},\n", strings.Join(fields, ", ")))
+		}
+		body.WriteString("\t\t}).Error; err != nil {\n\t\t\treturn err\n\t\t}\n")
+	}
+	if body.Len() == 0 {
+		return nil, nil
+	}
+
+	var code strings.Builder
+	code.WriteString("// Seed inserts the records the seed declarations ask for in one\n")
+	code.WriteString("// transaction, so a failed insert leaves the tables as they were. It\n")
+	code.WriteString(fmt.Sprintf("// inserts %s.\n", joinCounts(counts)))
+	code.WriteString("func Seed(db *gorm.DB) error {\n\treturn db.Transaction(func(tx *gorm.DB) error {\n")
+	code.WriteString(body.String())
+	code.WriteString("\t\treturn nil\n\t})\n}\n")
+	if strings.Contains(body.String(), "seedPointer(") {
+		code.WriteString("\n// seedPointer points at v, for optional fields\nfunc seedPointer[T any](v T) *T {\n\treturn &v\n}\n")
+	}
+
+	formatted, err := gogen.RenderFile(Package, []string{"time", "gorm.io/gorm"}, code.String(), opts.Packages)
+	if err != nil {
+		return formatted, err
+	}
+	return codegen.Stamp("//", opts.Header, append([]byte("// Generated seed data from CloudPact\n\n"), formatted...)), nil
+}
+
+// joinCounts lists counts such as "20 Customer" as "20 Customer, 5 Product
+// and 3 Order"
+func joinCounts(counts []string) string {
+	if len(counts) == 1 {
+		return counts[0]
+	}
+	return strings.Join(counts[:len(counts)-1], ", ") + " and " + counts[len(counts)-1]
+}
+
+// columnKey is the name a column's value has in a seeded record
+func columnKey(c column) string {
+	if c.field == nil {
+		return c.name
+	}
+	return c.field.Name
+}
+
+// columnType is the type of a column's values
+func columnType(c column) *grammar.Type {
+	switch {
+	case c.field != nil:
+		return c.field.Type
+	case c.name == "version":
+		return &grammar.Type{Name: "int"}
+	}
+	return &grammar.Type{Name: "uuid"}
+}
+
+// rowField is the <Record>Row field of a column
+func rowField(c column) string {
+	switch {
+	case c.field != nil:
+		return pascalCase(c.field.Name)
+	case c.name == "version":
+		return "Version"
+	}
+	return "ID"
+}
+
+// sqlValue writes a seeded value of type t as a PostgreSQL literal
+func sqlValue(t *grammar.Type, value interface{}) string {
+	if value == nil {
+		return "NULL"
+	}
+	if isJSON(t) {
+		data, _ := json.Marshal(jsonValue(value))
+		return sqlString(string(data))
+	}
+	switch v := value.(type) {
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return codegen.FloatLiteral(v)
+	case time.Time:
+		if strings.EqualFold(t.Name, "date") {
+			return sqlString(v.Format(time.DateOnly))
+		}
+		return sqlString(v.Format(time.RFC3339))
+	case time.Duration:
+		// A bigint of nanoseconds, like time.Duration
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return sqlString(fmt.Sprint(value))
+}
+
+// sqlString quotes text for SQL
+func sqlString(text string) string {
+	return "'" + strings.ReplaceAll(text, "'", "''") + "'"
+}
+
+// jsonValue is a seeded value as encoding/json writes it: records keyed by
+// their fields' JSON keys, and times in RFC 3339
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *recordValue:
+		object := make(map[string]interface{})
+		for key, field := range v.values {
+			object[jsonKey(v.record, key)] = jsonValue(field)
+		}
+		return object
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = jsonValue(item)
+		}
+		return items
+	case map[string]interface{}:
+		entries := make(map[string]interface{}, len(v))
+		for key, entry := range v {
+			entries[key] = jsonValue(entry)
+		}
+		return entries
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return value
+}
+
+// jsonKey is the JSON key of the field of record called name
+func jsonKey(record *grammar.Record, name string) string {
+	for _, field := range record.AllFields() {
+		if field.Name == name {
+			return field.JSONKey()
+		}
+	}
+	return name
+}
+
+// goValue writes a seeded value of type t as Go source
+func goValue(t *grammar.Type, value interface{}) string {
+	if value == nil {
+		return "nil"
+	}
+	if t.Optional {
+		required := *t
+		required.Optional = false
+		if record, ok := value.(*recordValue); ok {
+			return "&" + goRecordValue(record.record, record)
+		}
+		return fmt.Sprintf("seedPointer(%s)", goValue(&required, value))
+	}
+	switch v := value.(type) {
+	case *recordValue:
+		return goRecordValue(v.record, v)
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			items = append(items, goValue(t.Element, item))
+		}
+		return fmt.Sprintf("%s{%s}", gogen.FieldType(t), strings.Join(items, ", "))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var entries []string
+		for _, key := range keys {
+			k := strconv.Quote(key)
+			if isWhole(t.Key) {
+				k = key
+			}
+			entries = append(entries, fmt.Sprintf("%s: %s", k, goValue(t.Value, v[key])))
+		}
+		return fmt.Sprintf("%s{%s}", gogen.FieldType(t), strings.Join(entries, ", "))
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return codegen.FloatLiteral(v)
+	case time.Time:
+		return fmt.Sprintf("time.Date(%d, %d, %d, %d, %d, %d, 0, time.UTC)", v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second())
+	case time.Duration:
+		if v%time.Minute == 0 {
+			return fmt.Sprintf("%d * time.Minute", v/time.Minute)
+		}
+		return fmt.Sprintf("time.Duration(%d)", int64(v))
+	}
+	return fmt.Sprint(value)
+}
+
+// goRecordValue writes a seeded record as a composite literal of record,
+// nesting the record it extends
+func goRecordValue(record *grammar.Record, value *recordValue) string {
+	var parts []string
+	if record.Base != nil {
+		parts = append(parts, fmt.Sprintf("%s: %s", record.Base.Name, goRecordValue(record.Base, value)))
+	} else if id, ok := value.values["id"]; ok && record.HasImplicitID() {
+		parts = append(parts, fmt.Sprintf("ID: %q", id))
+	}
+	if version, ok := value.values["version"]; ok && record.Versioned {
+		parts = append(parts, fmt.Sprintf("Version: %v", version))
+	}
+	for _, field := range record.Fields {
+		if v := value.values[field.Name]; v != nil {
+			parts = append(parts, fmt.Sprintf("%s: %s", gogen.FieldName(field.Name), goValue(field.Type, v)))
+		}
+	}
+	return fmt.Sprintf("%s{%s}", record.Name, strings.Join(parts, ", "))
+}
@@ -0,0 +1,129 @@
+package dbgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// GenerateSchema writes the PostgreSQL tables of records for sqlc to read
+// as its schema. Records that are not stored are skipped.
+func GenerateSchema(records []*grammar.Record, opts Options) []byte {
+	var code strings.Builder
+	for _, record := range records {
+		if !Stored(record) {
+			continue
+		}
+		if code.Len() > 0 {
+			code.WriteString("\n")
+		}
+		for _, line := range codegen.DocLines(record.Leading, record.Trailing) {
+			code.WriteString("-- " + line + "\n")
+		}
+		code.WriteString(fmt.Sprintf("CREATE TABLE %s (\n", TableName(record.Name)))
+		var lines []string
+		for _, c := range columns(record) {
+			lines = append(lines, "  "+columnDefinition(c))
+		}
+		lines = append(lines, fmt.Sprintf("  PRIMARY KEY (%s)", keyColumn(record)))
+		code.WriteString(strings.Join(lines, ",\n"))
+		code.WriteString("\n);\n")
+	}
+	return codegen.Stamp("--", opts.Header, []byte(code.String()))
+}
+
+// columnDefinition is a column as CREATE TABLE declares it
+func columnDefinition(c column) string {
+	if c.field == nil && c.name == "id" {
+		return "id text NOT NULL"
+	}
+	if c.field == nil {
+		return "version bigint NOT NULL DEFAULT 0"
+	}
+	definition := c.name + " " + SQLType(c.field.Type)
+	if !c.field.Type.Optional {
+		definition += " NOT NULL"
+	}
+	if value := sqlDefault(c.field); value != "" {
+		definition += " DEFAULT " + value
+	}
+	for _, condition := range checks(c.name, c.field.Type) {
+		definition += " CHECK (" + condition + ")"
+	}
+	return definition
+}
+
+// keyColumn is the column identifying record's rows
+func keyColumn(record *grammar.Record) string {
+	if record.HasImplicitID() {
+		return "id"
+	}
+	return snakeCase(record.KeyField().Name)
+}
+
+// GenerateQueries writes the sqlc queries of a stored record: Get<Record>,
+// List<Record>s, Create<Record>, Update<Record> unless the key is its only
+// column, and Delete<Record>. Updating a versioned record takes the new
+// version and only matches the row that is one version behind, so a
+// concurrent save finds no row.
+func GenerateQueries(record *grammar.Record, opts Options) []byte {
+	name := record.Name
+	table := TableName(name)
+	key := keyColumn(record)
+	var names []string
+	for _, c := range columns(record) {
+		names = append(names, c.name)
+	}
+
+	var code strings.Builder
+	code.WriteString(fmt.Sprintf("-- name: Get%s :one\nSELECT * FROM %s\nWHERE %s = $1 LIMIT 1;\n\n", name, table, key))
+	code.WriteString(fmt.Sprintf("-- name: List%ss :many\nSELECT * FROM %s\nORDER BY %s;\n\n", name, table, key))
+
+	var params []string
+	for i := range names {
+		params = append(params, fmt.Sprintf("$%d", i+1))
+	}
+	code.WriteString(fmt.Sprintf("-- name: Create%s :one\nINSERT INTO %s (\n  %s\n) VALUES (\n  %s\n)\nRETURNING *;\n\n",
+		name, table, strings.Join(names, ", "), strings.Join(params, ", ")))
+
+	// The key is $1; the other columns follow in table order
+	var sets []string
+	n := 2
+	for _, column := range names {
+		if column == key {
+			continue
+		}
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, n))
+		n++
+	}
+	if len(sets) > 0 {
+		where := fmt.Sprintf("%s = $1", key)
+		if record.IsVersioned() {
+			// version is the first column besides the key, so $2
+			where += " AND version = $2 - 1"
+		}
+		code.WriteString(fmt.Sprintf("-- name: Update%s :one\nUPDATE %s SET\n  %s\nWHERE %s\nRETURNING *;\n\n",
+			name, table, strings.Join(sets, ",\n  "), where))
+	}
+
+	code.WriteString(fmt.Sprintf("-- name: Delete%s :exec\nDELETE FROM %s\nWHERE %s = $1;\n", name, table, key))
+	return codegen.Stamp("--", opts.Header, []byte(code.String()))
+}
+
+// GenerateSQLCConfig writes the sqlc.yaml that compiles schema.sql and the
+// queries directory into the Go package db
+func GenerateSQLCConfig(opts Options) []byte {
+	config := `version: "2"
+sql:
+  - engine: postgresql
+    schema: schema.sql
+    queries: queries
+    gen:
+      go:
+        package: db
+        out: .
+`
+	return codegen.Stamp("#", opts.Header, []byte(config))
+}
@@ -0,0 +1,74 @@
+// Package deploygen writes what Kubernetes needs to run the server cloudpact
+// gen server generates: a Deployment probing its health endpoints, a
+// Service in front of it and, for a public host, an Ingress. They come as
+// plain manifests for kubectl apply or as a Helm chart whose values.yaml
+// holds the settings.
+package deploygen
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Port is the container port the server listens on, passed to it as $PORT
+const Port = 8080
+
+// Options tunes the generated files
+type Options struct {
+	// Header is the provenance comment each file starts with; empty means
+	// none
+	Header string
+}
+
+// App is the server being deployed and how it is exposed
+type App struct {
+	// Name names its resources; ResourceName makes a project name fit
+	Name     string
+	Version  string // the project version, if any
+	Image    string
+	Replicas int
+	// Namespace is where the manifests go; empty leaves it to kubectl
+	Namespace string
+	// Host adds an Ingress routing requests for the host to the Service
+	Host         string
+	IngressClass string
+	TLSSecret    string
+}
+
+// File is a generated file and its path relative to the output directory
+type File struct {
+	Path    string
+	Content []byte
+}
+
+// ResourceName turns a project name such as "Shop API" into a name
+// Kubernetes accepts for resources and labels, "shop-api": lowercase
+// letters, digits and dashes, at most 63 long
+func ResourceName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	resource := b.String()
+	if len(resource) > 63 {
+		resource = strings.TrimRight(resource[:63], "-")
+	}
+	if resource == "" {
+		return "app"
+	}
+	return resource
+}
+
+// quote writes s as a double-quoted YAML string
+func quote(s string) string {
+	return strconv.Quote(s)
+}
@@ -0,0 +1,145 @@
+package deploygen
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+var shop = App{
+	Name:         "shop",
+	Version:      "1.2.0",
+	Image:        "ghcr.io/acme/shop:1.2.0",
+	Replicas:     2,
+	Namespace:    "store",
+	Host:         "shop.example.com",
+	IngressClass: "nginx",
+	TLSSecret:    "shop-tls",
+}
+
+// lookup follows keys through a decoded YAML document
+func lookup(t *testing.T, doc interface{}, keys ...interface{}) interface{} {
+	t.Helper()
+	for _, key := range keys {
+		switch node := doc.(type) {
+		case map[interface{}]interface{}:
+			doc = node[key]
+		case []interface{}:
+			doc = node[key.(int)]
+		default:
+			t.Fatalf("cannot look up %v in %v", key, doc)
+		}
+	}
+	return doc
+}
+
+func TestManifests(t *testing.T) {
+	files := Manifests(shop, Options{Header: "Code generated by cloudpact. DO NOT EDIT."})
+	var paths []string
+	docs := make(map[string]interface{})
+	for _, file := range files {
+		paths = append(paths, file.Path)
+		if !strings.HasPrefix(string(file.Content), "# Code generated by cloudpact. DO NOT EDIT.\n\n") {
+			t.Errorf("expected %s to start with the header:\n%s", file.Path, file.Content)
+		}
+		var doc interface{}
+		if err := yaml.Unmarshal(file.Content, &doc); err != nil {
+			t.Fatalf("%s is not YAML: %v\n%s", file.Path, err, file.Content)
+		}
+		docs[file.Path] = doc
+	}
+	if got := strings.Join(paths, " "); got != "deployment.yaml service.yaml ingress.yaml" {
+		t.Fatalf("unexpected files %s", got)
+	}
+
+	deployment := docs["deployment.yaml"]
+	container := lookup(t, deployment, "spec", "template", "spec", "containers", 0)
+	for _, check := range []struct {
+		got  interface{}
+		want interface{}
+	}{
+		{lookup(t, deployment, "metadata", "namespace"), "store"},
+		{lookup(t, deployment, "metadata", "labels", "app.kubernetes.io/version"), "1.2.0"},
+		{lookup(t, deployment, "spec", "replicas"), 2},
+		{lookup(t, deployment, "spec", "selector", "matchLabels", "app.kubernetes.io/name"), "shop"},
+		{lookup(t, container, "image"), "ghcr.io/acme/shop:1.2.0"},
+		{lookup(t, container, "ports", 0, "containerPort"), 8080},
+		{lookup(t, container, "env", 0, "value"), "8080"},
+		{lookup(t, container, "livenessProbe", "httpGet", "path"), "/healthz"},
+		{lookup(t, container, "readinessProbe", "httpGet", "path"), "/readyz"},
+		{lookup(t, docs["service.yaml"], "spec", "ports", 0, "targetPort"), "http"},
+		{lookup(t, docs["ingress.yaml"], "spec", "ingressClassName"), "nginx"},
+		{lookup(t, docs["ingress.yaml"], "spec", "tls", 0, "secretName"), "shop-tls"},
+		{lookup(t, docs["ingress.yaml"], "spec", "rules", 0, "host"), "shop.example.com"},
+		{lookup(t, docs["ingress.yaml"], "spec", "rules", 0, "http", "paths", 0, "backend", "service", "name"), "shop"},
+	} {
+		if check.got != check.want {
+			t.Errorf("expected %v, got %v", check.want, check.got)
+		}
+	}
+
+	internal := shop
+	internal.Host, internal.Namespace = "", ""
+	files = Manifests(internal, Options{})
+	if len(files) != 2 {
+		t.Fatalf("expected no Ingress without a host, got %d files", len(files))
+	}
+	if strings.Contains(string(files[0].Content), "namespace:") {
+		t.Errorf("expected no namespace:\n%s", files[0].Content)
+	}
+}
+
+func TestChart(t *testing.T) {
+	files := Chart(shop, Options{})
+	byPath := make(map[string]string)
+	for _, file := range files {
+		byPath[file.Path] = string(file.Content)
+	}
+
+	var chart, values interface{}
+	if err := yaml.Unmarshal([]byte(byPath["Chart.yaml"]), &chart); err != nil {
+		t.Fatalf("Chart.yaml is not YAML: %v", err)
+	}
+	if err := yaml.Unmarshal([]byte(byPath["values.yaml"]), &values); err != nil {
+		t.Fatalf("values.yaml is not YAML: %v", err)
+	}
+	if lookup(t, chart, "name") != "shop" || lookup(t, chart, "version") != "1.2.0" || lookup(t, chart, "appVersion") != "1.2.0" {
+		t.Errorf("unexpected Chart.yaml:\n%s", byPath["Chart.yaml"])
+	}
+	if lookup(t, values, "image") != "ghcr.io/acme/shop:1.2.0" || lookup(t, values, "replicas") != 2 || lookup(t, values, "ingress", "host") != "shop.example.com" {
+		t.Errorf("unexpected values.yaml:\n%s", byPath["values.yaml"])
+	}
+	for path, want := range map[string]string{
+		"templates/deployment.yaml": "  replicas: {{ .Values.replicas }}\n",
+		"templates/service.yaml":    "  selector:\n    app.kubernetes.io/name: {{ .Chart.Name }}\n    app.kubernetes.io/instance: {{ .Release.Name }}\n",
+		"templates/ingress.yaml":    "{{- if .Values.ingress.host }}\n",
+	} {
+		if !strings.Contains(byPath[path], want) {
+			t.Errorf("expected %q in %s:\n%s", want, path, byPath[path])
+		}
+	}
+	if !strings.Contains(byPath["templates/deployment.yaml"], "          readinessProbe:\n            httpGet:\n              path: /readyz\n") {
+		t.Errorf("expected the readiness probe:\n%s", byPath["templates/deployment.yaml"])
+	}
+
+	unversioned := shop
+	unversioned.Version = "nightly"
+	if chart := string(Chart(unversioned, Options{})[0].Content); !strings.Contains(chart, "version: 0.1.0\nappVersion: \"nightly\"\n") {
+		t.Errorf("expected a default chart version:\n%s", chart)
+	}
+}
+
+func TestResourceName(t *testing.T) {
+	for name, want := range map[string]string{
+		"shop":                  "shop",
+		"Shop API":              "shop-api",
+		"acme_orders!":          "acme-orders",
+		"--":                    "app",
+		strings.Repeat("a", 70): strings.Repeat("a", 63),
+	} {
+		if got := ResourceName(name); got != want {
+			t.Errorf("ResourceName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
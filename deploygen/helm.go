@@ -0,0 +1,142 @@
+package deploygen
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+)
+
+// semver is a version Chart.yaml accepts as the chart's version
+var semver = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// Chart writes a Helm chart for app: Chart.yaml, values.yaml holding the
+// image, replicas and ingress settings, and the templates of the
+// Deployment, Service and Ingress, which is only rendered with a host.
+// Releases are named by helm install, and installed into its namespace.
+func Chart(app App, opts Options) []File {
+	stamp := func(content string) []byte {
+		return codegen.Stamp("#", opts.Header, []byte(content))
+	}
+	return []File{
+		{Path: "Chart.yaml", Content: stamp(chartYAML(app))},
+		{Path: "values.yaml", Content: stamp(valuesYAML(app))},
+		{Path: "templates/deployment.yaml", Content: stamp(helmDeployment)},
+		{Path: "templates/service.yaml", Content: stamp(helmService)},
+		{Path: "templates/ingress.yaml", Content: stamp(helmIngress)},
+	}
+}
+
+// chartYAML describes the chart, versioned like the project when its
+// version is semantic
+func chartYAML(app App) string {
+	version := "0.1.0"
+	if semver.MatchString(app.Version) {
+		version = strings.TrimPrefix(app.Version, "v")
+	}
+	var b strings.Builder
+	b.WriteString("apiVersion: v2\n")
+	b.WriteString(fmt.Sprintf("name: %s\n", app.Name))
+	b.WriteString("description: The API server generated from the CloudPact sources\n")
+	b.WriteString("type: application\n")
+	b.WriteString(fmt.Sprintf("version: %s\n", version))
+	if app.Version != "" {
+		b.WriteString(fmt.Sprintf("appVersion: %s\n", quote(app.Version)))
+	}
+	return b.String()
+}
+
+// valuesYAML holds the settings of app that differ between releases
+func valuesYAML(app App) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("image: %s\n", quote(app.Image)))
+	b.WriteString(fmt.Sprintf("replicas: %d\n", app.Replicas))
+	b.WriteString("\n# An Ingress routes the host to the server; leave host empty for none\n")
+	b.WriteString("ingress:\n")
+	b.WriteString(fmt.Sprintf("  host: %s\n", quote(app.Host)))
+	b.WriteString(fmt.Sprintf("  className: %s\n", quote(app.IngressClass)))
+	b.WriteString(fmt.Sprintf("  tlsSecret: %s\n", quote(app.TLSSecret)))
+	return b.String()
+}
+
+// helmLabels label every resource of a release
+const helmLabels = `    app.kubernetes.io/name: {{ .Chart.Name }}
+    app.kubernetes.io/instance: {{ .Release.Name }}
+    app.kubernetes.io/version: {{ .Chart.AppVersion | default .Chart.Version | quote }}
+    app.kubernetes.io/managed-by: {{ .Release.Service }}
+`
+
+// helmSelector picks the pods of a release, indented for the key it is
+// under
+func helmSelector(indent int) string {
+	pad := strings.Repeat(" ", indent)
+	return pad + "app.kubernetes.io/name: {{ .Chart.Name }}\n" + pad + "app.kubernetes.io/instance: {{ .Release.Name }}\n"
+}
+
+var helmDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+  labels:
+` + helmLabels + `spec:
+  replicas: {{ .Values.replicas }}
+  selector:
+    matchLabels:
+` + helmSelector(6) + `  template:
+    metadata:
+      labels:
+` + helmSelector(8) + `    spec:
+      containers:
+        - name: server
+          image: {{ .Values.image | quote }}
+          ports:
+            - name: http
+              containerPort: ` + fmt.Sprint(Port) + `
+          env:
+            - name: PORT
+              value: "` + fmt.Sprint(Port) + `"
+` + probes("http")
+
+var helmService = `apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Release.Name }}
+  labels:
+` + helmLabels + `spec:
+  selector:
+` + helmSelector(4) + `  ports:
+    - name: http
+      port: 80
+      targetPort: http
+`
+
+var helmIngress = `{{- if .Values.ingress.host }}
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: {{ .Release.Name }}
+  labels:
+` + helmLabels + `spec:
+  {{- with .Values.ingress.className }}
+  ingressClassName: {{ . | quote }}
+  {{- end }}
+  {{- with .Values.ingress.tlsSecret }}
+  tls:
+    - hosts:
+        - {{ $.Values.ingress.host | quote }}
+      secretName: {{ . }}
+  {{- end }}
+  rules:
+    - host: {{ .Values.ingress.host | quote }}
+      http:
+        paths:
+          - path: /
+            pathType: Prefix
+            backend:
+              service:
+                name: {{ .Release.Name }}
+                port:
+                  name: http
+{{- end }}
+`
@@ -0,0 +1,102 @@
+package deploygen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/gogen"
+)
+
+// Manifests writes deployment.yaml and service.yaml for app, and
+// ingress.yaml when it has a host, ready for kubectl apply -f
+func Manifests(app App, opts Options) []File {
+	files := []File{
+		{Path: "deployment.yaml", Content: codegen.Stamp("#", opts.Header, []byte(deployment(app)))},
+		{Path: "service.yaml", Content: codegen.Stamp("#", opts.Header, []byte(service(app)))},
+	}
+	if app.Host != "" {
+		files = append(files, File{Path: "ingress.yaml", Content: codegen.Stamp("#", opts.Header, []byte(ingress(app)))})
+	}
+	return files
+}
+
+// metadata names a resource of app and labels it as part of app
+func metadata(app App) string {
+	var b strings.Builder
+	b.WriteString("metadata:\n")
+	b.WriteString(fmt.Sprintf("  name: %s\n", app.Name))
+	if app.Namespace != "" {
+		b.WriteString(fmt.Sprintf("  namespace: %s\n", app.Namespace))
+	}
+	b.WriteString("  labels:\n")
+	b.WriteString(fmt.Sprintf("    app.kubernetes.io/name: %s\n", app.Name))
+	if app.Version != "" {
+		b.WriteString(fmt.Sprintf("    app.kubernetes.io/version: %s\n", quote(app.Version)))
+	}
+	b.WriteString("    app.kubernetes.io/managed-by: cloudpact\n")
+	return b.String()
+}
+
+// deployment runs app's image, probing the health endpoints of the
+// generated server
+func deployment(app App) string {
+	var b strings.Builder
+	b.WriteString("apiVersion: apps/v1\nkind: Deployment\n")
+	b.WriteString(metadata(app))
+	b.WriteString("spec:\n")
+	b.WriteString(fmt.Sprintf("  replicas: %d\n", app.Replicas))
+	b.WriteString("  selector:\n    matchLabels:\n")
+	b.WriteString(fmt.Sprintf("      app.kubernetes.io/name: %s\n", app.Name))
+	b.WriteString("  template:\n    metadata:\n      labels:\n")
+	b.WriteString(fmt.Sprintf("        app.kubernetes.io/name: %s\n", app.Name))
+	b.WriteString("    spec:\n      containers:\n        - name: server\n")
+	b.WriteString(fmt.Sprintf("          image: %s\n", quote(app.Image)))
+	b.WriteString(fmt.Sprintf("          ports:\n            - name: http\n              containerPort: %d\n", Port))
+	b.WriteString(fmt.Sprintf("          env:\n            - name: PORT\n              value: \"%d\"\n", Port))
+	b.WriteString(probes("http"))
+	return b.String()
+}
+
+// probes writes the liveness and readiness probes of the server container,
+// against the named port
+func probes(port string) string {
+	var b strings.Builder
+	b.WriteString("          livenessProbe:\n            httpGet:\n")
+	b.WriteString(fmt.Sprintf("              path: %s\n              port: %s\n", gogen.LivenessPath, port))
+	b.WriteString("          readinessProbe:\n            httpGet:\n")
+	b.WriteString(fmt.Sprintf("              path: %s\n              port: %s\n", gogen.ReadinessPath, port))
+	return b.String()
+}
+
+// service gives app's pods one address inside the cluster, on port 80
+func service(app App) string {
+	var b strings.Builder
+	b.WriteString("apiVersion: v1\nkind: Service\n")
+	b.WriteString(metadata(app))
+	b.WriteString("spec:\n  selector:\n")
+	b.WriteString(fmt.Sprintf("    app.kubernetes.io/name: %s\n", app.Name))
+	b.WriteString("  ports:\n    - name: http\n      port: 80\n      targetPort: http\n")
+	return b.String()
+}
+
+// ingress routes every path of app's host to its service, over TLS when it
+// names a certificate
+func ingress(app App) string {
+	var b strings.Builder
+	b.WriteString("apiVersion: networking.k8s.io/v1\nkind: Ingress\n")
+	b.WriteString(metadata(app))
+	b.WriteString("spec:\n")
+	if app.IngressClass != "" {
+		b.WriteString(fmt.Sprintf("  ingressClassName: %s\n", quote(app.IngressClass)))
+	}
+	if app.TLSSecret != "" {
+		b.WriteString(fmt.Sprintf("  tls:\n    - hosts:\n        - %s\n      secretName: %s\n", quote(app.Host), app.TLSSecret))
+	}
+	b.WriteString(fmt.Sprintf("  rules:\n    - host: %s\n", quote(app.Host)))
+	b.WriteString("      http:\n        paths:\n          - path: /\n            pathType: Prefix\n")
+	b.WriteString("            backend:\n              service:\n")
+	b.WriteString(fmt.Sprintf("                name: %s\n", app.Name))
+	b.WriteString("                port:\n                  name: http\n")
+	return b.String()
+}
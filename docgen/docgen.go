@@ -0,0 +1,463 @@
+// Package docgen renders the types, records and functions of a CloudPact
+// module as Markdown or HTML, so the business context written in .cp files
+// can be read by people who never open the source.
+package docgen
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// semanticTypes explains the built-in types in plain words
+var semanticTypes = map[string]string{
+	"text":         "Free text",
+	"string":       "Free text",
+	"int":          "A whole number",
+	"integer":      "A whole number",
+	"long":         "A large whole number",
+	"bigint":       "A large whole number",
+	"float":        "A number that may have a fractional part",
+	"double":       "A number that may have a fractional part",
+	"number":       "A number that may have a fractional part",
+	"bool":         "Yes or no",
+	"boolean":      "Yes or no",
+	"email":        "An email address, such as user@example.com",
+	"url":          "A web address, such as https://example.com",
+	"uuid":         "A unique identifier, such as 123e4567-e89b-12d3-a456-426614174000",
+	"phone":        "A phone number in international format, such as +15550123",
+	"zip_code":     "A US ZIP code, such as 12345 or 12345-6789",
+	"country_code": "A two-letter ISO country code, such as US or CA",
+	"state_code":   "A two-letter state or province code, such as CA",
+	"usd_currency": "An amount of US dollars, to the cent",
+	"eur_currency": "An amount of euros, to the cent",
+	"percentage":   "A percentage from 0 to 100",
+	"date":         "A calendar date, such as 2024-12-25",
+	"datetime":     "A date and time of day, such as 2024-12-25T10:30:00Z",
+	"timestamp":    "A date and time of day, such as 2024-12-25T10:30:00Z",
+	"time":         "A time of day, such as 14:30",
+	"duration":     "A length of time, such as P1DT2H (one day and two hours)",
+	"password":     "A secret of at least 8 characters, never shown",
+}
+
+// Markdown documents file as a Markdown page. The page is titled after the
+// module, or after name when the file declares none.
+func Markdown(file *grammar.File, name string) string {
+	return render(newPage(file, name), markdown{})
+}
+
+// HTML documents file as a standalone HTML page
+func HTML(file *grammar.File, name string) string {
+	return render(newPage(file, name), htmlFormat{})
+}
+
+// page is what a document shows, independent of its format
+type page struct {
+	title     string
+	intro     []string
+	types     []*grammar.TypeDef
+	records   []*entity
+	functions []*grammar.Function
+	schedules []*grammar.Schedule
+	custom    map[string]*grammar.TypeDef
+	local     map[string]bool // records described on the page, which types link to
+}
+
+// entity is a record or a legacy model, which are documented alike
+type entity struct {
+	name     string
+	extends  string
+	comments []*grammar.Comment
+	fields   []row
+}
+
+type row struct {
+	name     string
+	t        *grammar.Type
+	comments []*grammar.Comment
+	def      grammar.Expression
+}
+
+func newPage(file *grammar.File, name string) *page {
+	p := &page{
+		title:     name,
+		types:     file.TypeDefs,
+		functions: file.Functions,
+		schedules: file.Schedules,
+		custom:    make(map[string]*grammar.TypeDef),
+		local:     make(map[string]bool),
+	}
+	if file.Module != nil {
+		p.title = file.Module.Name
+		p.intro = grammar.CommentLines(file.Module.Leading)
+	}
+	for _, td := range file.TypeDefs {
+		p.custom[td.Name] = td
+	}
+	for _, record := range file.Records {
+		e := &entity{name: record.Name, extends: record.Extends, comments: record.Leading}
+		for _, field := range record.Fields {
+			e.fields = append(e.fields, row{field.Name, field.Type, field.Leading, field.Default})
+		}
+		p.records = append(p.records, e)
+		p.local[record.Name] = true
+	}
+	for _, model := range file.Models {
+		e := &entity{name: model.Name, comments: model.Leading}
+		for _, field := range model.Fields {
+			e.fields = append(e.fields, row{field.Name, field.Type, field.Leading, nil})
+		}
+		p.records = append(p.records, e)
+		p.local[model.Name] = true
+	}
+	return p
+}
+
+// format writes the building blocks of a page in one markup language
+type format interface {
+	begin(b *strings.Builder, title string)
+	heading(b *strings.Builder, level int, text, anchor string)
+	paragraph(b *strings.Builder, text string)
+	list(b *strings.Builder, items []string)
+	table(b *strings.Builder, header []string, rows [][]string)
+	end(b *strings.Builder)
+
+	// escape makes text safe to pass to the methods above; link and code
+	// produce markup that is already escaped
+	escape(text string) string
+	link(text, anchor string) string
+	code(text string) string
+	strong(text string) string
+}
+
+func render(p *page, f format) string {
+	var b strings.Builder
+	f.begin(&b, p.title)
+	f.heading(&b, 1, f.escape(p.title), "")
+	if len(p.intro) > 0 {
+		f.paragraph(&b, f.escape(strings.Join(p.intro, " ")))
+	}
+
+	if len(p.types) > 0 {
+		f.heading(&b, 2, "Types", "")
+		for _, td := range p.types {
+			f.heading(&b, 3, f.escape(td.Name), anchor(td.Name))
+			if td.Why != "" {
+				f.paragraph(&b, f.escape(td.Why))
+			}
+			text := "Based on " + p.typeName(f, td.BaseType)
+			if rules := validationRules(td.Validation); rules != "" {
+				text += "; " + f.escape(rules)
+			}
+			f.paragraph(&b, text+".")
+		}
+	}
+
+	if len(p.records) > 0 {
+		f.heading(&b, 2, "Records", "")
+		for _, e := range p.records {
+			f.heading(&b, 3, f.escape(e.name), anchor(e.name))
+			if lines := grammar.CommentLines(e.comments); len(lines) > 0 {
+				f.paragraph(&b, f.escape(strings.Join(lines, " ")))
+			}
+			if e.extends != "" {
+				f.paragraph(&b, "Has every field of "+p.reference(f, e.extends)+", and also:")
+			}
+			var rows [][]string
+			for _, field := range e.fields {
+				rows = append(rows, []string{
+					f.code(field.name),
+					p.typeName(f, field.t),
+					required(field.t),
+					p.describe(f, field),
+				})
+			}
+			f.table(&b, []string{"Field", "Type", "Required", "Description"}, rows)
+		}
+	}
+
+	if len(p.functions) > 0 {
+		f.heading(&b, 2, "Functions", "")
+		for _, fn := range p.functions {
+			f.heading(&b, 3, f.escape(fn.Name), anchor(fn.Name))
+			if fn.Why != "" {
+				f.paragraph(&b, f.escape(fn.Why))
+			}
+			if lines := grammar.CommentLines(fn.Leading); len(lines) > 0 {
+				f.paragraph(&b, f.escape(strings.Join(lines, " ")))
+			}
+			if len(fn.Parameters) > 0 {
+				var rows [][]string
+				for _, param := range fn.Parameters {
+					rows = append(rows, []string{f.code(param.Name), p.typeName(f, param.Type), f.escape(p.explain(param.Type))})
+				}
+				f.table(&b, []string{"Parameter", "Type", "Description"}, rows)
+			}
+			if fn.ReturnType != nil {
+				f.paragraph(&b, f.strong("Returns")+" "+p.typeName(f, fn.ReturnType)+".")
+			}
+			if len(fn.AIAnnotations) > 0 {
+				f.paragraph(&b, f.strong("Reviewer notes"))
+				var items []string
+				for _, note := range fn.AIAnnotations {
+					items = append(items, f.strong(f.escape(note.Type)+":")+" "+f.escape(note.Content))
+				}
+				f.list(&b, items)
+			}
+		}
+	}
+
+	if len(p.schedules) > 0 {
+		f.heading(&b, 2, "Schedules", "")
+		var rows [][]string
+		for _, schedule := range p.schedules {
+			rows = append(rows, []string{
+				f.link(schedule.Function, anchor(schedule.Function)),
+				f.escape(schedule.Describe()),
+				f.escape(schedule.Why),
+			})
+		}
+		f.table(&b, []string{"Function", "Runs", "Why"}, rows)
+	}
+	f.end(&b)
+	return b.String()
+}
+
+// typeName spells a type as it is written in CloudPact, linking to the
+// records and custom types on the page
+func (p *page) typeName(f format, t *grammar.Type) string {
+	if t == nil {
+		return ""
+	}
+	var name string
+	switch {
+	case t.Name == "list" && t.Element != nil:
+		name = f.escape("list of ") + p.typeName(f, t.Element)
+	case t.Name == "map" && t.Key != nil && t.Value != nil:
+		name = f.escape("map of ") + p.typeName(f, t.Key) + f.escape(" to ") + p.typeName(f, t.Value)
+	default:
+		name = p.reference(f, t.Name)
+	}
+	if t.Nullable {
+		return f.escape("maybe ") + name
+	}
+	return name
+}
+
+// reference links a type name to its section, when the page has one
+func (p *page) reference(f format, name string) string {
+	if p.local[name] || p.custom[name] != nil {
+		return f.link(name, anchor(name))
+	}
+	return f.code(name)
+}
+
+// describe is the description column of a field: its comments, what its
+// type means and its default
+func (p *page) describe(f format, field row) string {
+	var parts []string
+	if lines := grammar.CommentLines(field.comments); len(lines) > 0 {
+		parts = append(parts, sentence(strings.Join(lines, " ")))
+	}
+	if explanation := p.explain(field.t); explanation != "" {
+		parts = append(parts, sentence(explanation))
+	}
+	text := f.escape(strings.Join(parts, " "))
+	if field.def != nil {
+		if text != "" {
+			text += " "
+		}
+		text += f.escape("Defaults to ") + f.code(grammar.FormatExpression(field.def)) + "."
+	}
+	return text
+}
+
+// explain says what values of t hold, for built-in semantic types and
+// custom types with a why
+func (p *page) explain(t *grammar.Type) string {
+	if t == nil {
+		return ""
+	}
+	if t.Name == "list" && t.Element != nil {
+		if inner := p.explain(t.Element); inner != "" {
+			return "Each item: " + inner
+		}
+		return ""
+	}
+	if td := p.custom[t.Name]; td != nil {
+		return td.Why
+	}
+	return semanticTypes[strings.ToLower(t.Name)]
+}
+
+// required says whether a field must be sent, and whether it may be none
+func required(t *grammar.Type) string {
+	switch {
+	case t == nil:
+		return ""
+	case t.Nullable:
+		return "yes, may be none"
+	case t.Optional:
+		return "no"
+	}
+	return "yes"
+}
+
+// validationRules lists the rules of a custom type in a stable order. The
+// parser stores a validate clause as "rule", which reads well on its own.
+func validationRules(rules map[string]interface{}) string {
+	var parts []string
+	for name, value := range rules {
+		if name == "rule" {
+			parts = append(parts, fmt.Sprint(value))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s %v", name, value))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// anchor is the fragment identifier of a section
+func anchor(name string) string {
+	return strings.ToLower(name)
+}
+
+func sentence(text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.HasSuffix(text, ".") || strings.HasSuffix(text, "?") || strings.HasSuffix(text, "!") {
+		return text
+	}
+	return text + "."
+}
+
+// markdown writes GitHub-flavoured Markdown
+type markdown struct{}
+
+func (markdown) begin(b *strings.Builder, title string) {}
+
+func (markdown) heading(b *strings.Builder, level int, text, anchor string) {
+	b.WriteString(strings.Repeat("#", level) + " " + text + "\n\n")
+}
+
+func (markdown) paragraph(b *strings.Builder, text string) {
+	b.WriteString(text + "\n\n")
+}
+
+func (markdown) list(b *strings.Builder, items []string) {
+	for _, item := range items {
+		b.WriteString("- " + item + "\n")
+	}
+	b.WriteString("\n")
+}
+
+func (markdown) table(b *strings.Builder, header []string, rows [][]string) {
+	b.WriteString("| " + strings.Join(header, " | ") + " |\n")
+	b.WriteString("|" + strings.Repeat(" --- |", len(header)) + "\n")
+	for _, cells := range rows {
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+	b.WriteString("\n")
+}
+
+func (markdown) end(b *strings.Builder) {}
+
+// escape keeps text from being read as Markdown, including the '|' that
+// would end a table cell
+func (markdown) escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune("\\`*_[]<>|#", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ReplaceAll(b.String(), "\n", " ")
+}
+
+// link relies on GitHub deriving heading anchors from their lowercased text
+func (m markdown) link(text, anchor string) string {
+	return "[" + m.escape(text) + "](#" + anchor + ")"
+}
+
+func (markdown) code(text string) string {
+	return "`" + strings.ReplaceAll(text, "`", "'") + "`"
+}
+
+func (markdown) strong(text string) string {
+	return "**" + text + "**"
+}
+
+// htmlFormat writes a standalone HTML page with a small inline stylesheet
+type htmlFormat struct{}
+
+const stylesheet = `body { font-family: system-ui, sans-serif; max-width: 60rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1rem; }
+th, td { border: 1px solid #ccc; padding: 0.3rem 0.6rem; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+code { background: #f4f4f4; padding: 0 0.2rem; }`
+
+func (h htmlFormat) begin(b *strings.Builder, title string) {
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString("<title>" + h.escape(title) + "</title>\n")
+	b.WriteString("<style>\n" + stylesheet + "\n</style>\n</head>\n<body>\n")
+}
+
+func (htmlFormat) heading(b *strings.Builder, level int, text, anchor string) {
+	id := ""
+	if anchor != "" {
+		id = fmt.Sprintf(` id="%s"`, html.EscapeString(anchor))
+	}
+	fmt.Fprintf(b, "<h%d%s>%s</h%d>\n", level, id, text, level)
+}
+
+func (htmlFormat) paragraph(b *strings.Builder, text string) {
+	b.WriteString("<p>" + text + "</p>\n")
+}
+
+func (htmlFormat) list(b *strings.Builder, items []string) {
+	b.WriteString("<ul>\n")
+	for _, item := range items {
+		b.WriteString("<li>" + item + "</li>\n")
+	}
+	b.WriteString("</ul>\n")
+}
+
+func (htmlFormat) table(b *strings.Builder, header []string, rows [][]string) {
+	b.WriteString("<table>\n<tr>")
+	for _, cell := range header {
+		b.WriteString("<th>" + cell + "</th>")
+	}
+	b.WriteString("</tr>\n")
+	for _, cells := range rows {
+		b.WriteString("<tr>")
+		for _, cell := range cells {
+			b.WriteString("<td>" + cell + "</td>")
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("</table>\n")
+}
+
+func (htmlFormat) end(b *strings.Builder) {
+	b.WriteString("</body>\n</html>\n")
+}
+
+func (htmlFormat) escape(text string) string {
+	return html.EscapeString(text)
+}
+
+func (htmlFormat) link(text, anchor string) string {
+	return `<a href="#` + html.EscapeString(anchor) + `">` + html.EscapeString(text) + "</a>"
+}
+
+func (htmlFormat) code(text string) string {
+	return "<code>" + html.EscapeString(text) + "</code>"
+}
+
+func (htmlFormat) strong(text string) string {
+	return "<strong>" + text + "</strong>"
+}
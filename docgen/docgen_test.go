@@ -0,0 +1,102 @@
+package docgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+const source = `// Orders placed through the web shop
+module Shop
+
+define type Sku as text
+    validate: length between 3 and 12
+    why: "Stock keeping unit printed on the shelf label"
+
+define record Customer
+    // Where receipts are sent
+    email: email
+    nickname: maybe text
+
+define record Order
+    customer: Customer
+    items: list of Sku
+    note: text optional
+    status: text default "open"
+
+function discount(order: Order) returns usd_currency
+    why: "Loyal customers get 10% off | big orders <b>"
+    ai-security: "Check the total is positive"
+    do:
+        return 0
+
+function closeStaleOrders()
+    why: "Abandoned carts should not hold stock"
+    do:
+        return
+
+schedule closeStaleOrders every 6 hours
+    why: "Carts are abandoned within hours"
+`
+
+func parse(t *testing.T) *grammar.File {
+	t.Helper()
+	file, err := grammar.ParseString(source)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return file
+}
+
+func TestMarkdown(t *testing.T) {
+	doc := Markdown(parse(t), "shop")
+	for _, want := range []string{
+		"# Shop\n\nOrders placed through the web shop\n",
+		"### Sku\n\nStock keeping unit printed on the shelf label\n\nBased on `text`; length between 3 and 12.\n",
+		"| Field | Type | Required | Description |\n| --- | --- | --- | --- |\n",
+		"| `email` | `email` | yes | Where receipts are sent. An email address, such as user@example.com. |\n",
+		"| `nickname` | maybe `text` | yes, may be none | Free text. |\n",
+		"| `customer` | [Customer](#customer) | yes |  |\n",
+		"| `items` | list of [Sku](#sku) | yes | Each item: Stock keeping unit printed on the shelf label. |\n",
+		"| `note` | `text` | no | Free text. |\n",
+		"Defaults to `\"open\"`.",
+		"Loyal customers get 10% off \\| big orders \\<b\\>\n",
+		"| `order` | [Order](#order) |  |\n",
+		"**Returns** `usd_currency`.",
+		"- **security:** Check the total is positive\n",
+		"## Schedules\n\n| Function | Runs | Why |\n| --- | --- | --- |\n| [closeStaleOrders](#closestaleorders) | every 6 hours | Carts are abandoned within hours |\n",
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("expected %q in:\n%s", want, doc)
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	doc := HTML(parse(t), "shop")
+	for _, want := range []string{
+		"<title>Shop</title>",
+		`<h3 id="order">Order</h3>`,
+		`<td>list of <a href="#sku">Sku</a></td>`,
+		"<p>Loyal customers get 10% off | big orders &lt;b&gt;</p>",
+		"<li><strong>security:</strong> Check the total is positive</li>",
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("expected %q in:\n%s", want, doc)
+		}
+	}
+	if !strings.HasSuffix(doc, "</body>\n</html>\n") {
+		t.Errorf("page is not closed:\n%s", doc)
+	}
+}
+
+func TestUntitledModule(t *testing.T) {
+	file, err := grammar.ParseString("define record User\n    name: text\n")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if doc := Markdown(file, "users"); !strings.HasPrefix(doc, "# users\n") {
+		t.Errorf("expected the page to be titled after the file:\n%s", doc)
+	}
+}
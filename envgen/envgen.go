@@ -0,0 +1,256 @@
+// Package envgen writes what a program needs to read the settings the
+// config declarations of a project describe: a Go package loading them
+// from the environment into a typed Config, a .env.example listing every
+// variable, and TypeScript typings of process.env.
+package envgen
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Options tunes the generated files
+type Options struct {
+	// Header is the provenance comment each file starts with; empty means
+	// none
+	Header string
+}
+
+// fieldName is the exported Go name of a setting in Config
+func fieldName(config *grammar.Config) string {
+	return strings.ToUpper(config.Name[:1]) + config.Name[1:]
+}
+
+// goType is the Go type a setting is read into, without the pointer of an
+// optional one
+func goType(config *grammar.Config) string {
+	required := *config.Type
+	required.Optional = false
+	return gogen.FieldType(&required)
+}
+
+// goCheck is a condition a variable's value must meet, checked once the
+// value is parsed; cond holds when it does not, after init has run
+type goCheck struct {
+	init    string
+	cond    string
+	message string
+}
+
+// parse is how a variable's text v becomes a value of a setting's type:
+// the check doing it, which leaves the value in the variable it names
+func parse(config *grammar.Config) (check *goCheck, value string) {
+	switch goType(config) {
+	case "int":
+		return &goCheck{"n, err := strconv.Atoi(v)", "err != nil", "must be a whole number"}, "n"
+	case "float64":
+		return &goCheck{"f, err := strconv.ParseFloat(v, 64)", "err != nil", "must be a number"}, "f"
+	case "bool":
+		return &goCheck{"b, err := strconv.ParseBool(v)", "err != nil", "must be true or false"}, "b"
+	case "time.Duration":
+		return &goCheck{"d, err := time.ParseDuration(v)", "err != nil", "must be a duration such as 90m"}, "d"
+	}
+	return nil, "v"
+}
+
+// checks are the conditions the value of a setting must meet: its
+// semantic type's and its constraints
+func checks(config *grammar.Config, value string) []goCheck {
+	t := config.Type
+	var list []goCheck
+	switch strings.ToLower(t.Name) {
+	case "url":
+		list = append(list, goCheck{"u, err := url.Parse(v)", `err != nil || u.Scheme == ""`, "must be a URL"})
+	case "email":
+		list = append(list, goCheck{"_, err := mail.ParseAddress(v)", "err != nil", "must be an email address"})
+	}
+	numeric := goType(config) == "int" || goType(config) == "float64"
+	for _, name := range codegen.FieldConstraints {
+		limit, ok := t.Constraints[name]
+		if !ok {
+			continue
+		}
+		switch name {
+		case grammar.ConstraintMin, grammar.ConstraintMax:
+			if !numeric {
+				continue
+			}
+			operator, bound := "<", "at least"
+			if name == grammar.ConstraintMax {
+				operator, bound = ">", "at most"
+			}
+			measure := value
+			if _, whole := limit.(int64); !whole && goType(config) == "int" {
+				measure = "float64(" + value + ")"
+			}
+			list = append(list, goCheck{"", fmt.Sprintf("%s %s %v", measure, operator, limit), fmt.Sprintf("must be %s %v", bound, limit)})
+		case grammar.ConstraintMinLength, grammar.ConstraintMaxLength:
+			operator, bound := "<", "at least"
+			if name == grammar.ConstraintMaxLength {
+				operator, bound = ">", "at most"
+			}
+			list = append(list, goCheck{"", fmt.Sprintf("utf8.RuneCountInString(v) %s %v", operator, limit), fmt.Sprintf("must be %s %v characters", bound, limit)})
+		case grammar.ConstraintDomain:
+			list = append(list, goCheck{"", fmt.Sprintf("!strings.HasSuffix(v, %q)", "@"+fmt.Sprint(limit)), fmt.Sprintf("must end with @%v", limit)})
+		}
+	}
+	return list
+}
+
+// goDefault is the default of a setting as Go source, or "" when it has
+// none
+func goDefault(config *grammar.Config) string {
+	literal, ok := config.Default.(*grammar.LiteralExpression)
+	if !ok {
+		return ""
+	}
+	switch v := literal.Value.(type) {
+	case string:
+		if goType(config) == "time.Duration" {
+			d, _ := time.ParseDuration(v)
+			return durationLiteral(d)
+		}
+		return strconv.Quote(v)
+	case float64:
+		return codegen.FloatLiteral(v)
+	}
+	return fmt.Sprint(literal.Value)
+}
+
+// durationLiteral writes d as Go source in the largest unit that divides
+// it, such as 90 * time.Second
+func durationLiteral(d time.Duration) string {
+	for _, unit := range []struct {
+		size time.Duration
+		name string
+	}{{time.Hour, "time.Hour"}, {time.Minute, "time.Minute"}, {time.Second, "time.Second"}, {time.Millisecond, "time.Millisecond"}} {
+		if d != 0 && d%unit.size == 0 {
+			return fmt.Sprintf("%d * %s", d/unit.size, unit.name)
+		}
+	}
+	return fmt.Sprintf("time.Duration(%d)", int64(d))
+}
+
+// GenerateGo writes the Go package pkg, with Config holding configs and
+// Load reading them from the environment. Load reports every variable that
+// is missing or invalid, not only the first. When the code is not valid
+// Go, it is returned unformatted along with the error.
+func GenerateGo(pkg string, configs []*grammar.Config, opts Options) ([]byte, error) {
+	var fields, defaults, body strings.Builder
+	for _, config := range configs {
+		name, env := fieldName(config), config.EnvName()
+		for _, line := range codegen.DocLines(config.Leading, config.Trailing) {
+			fields.WriteString(fmt.Sprintf("\t// %s\n", line))
+		}
+		doc := fmt.Sprintf("%s is read from %s", name, env)
+		switch value := goDefault(config); {
+		case value != "":
+			doc += ", defaulting to " + defaultText(config)
+			defaults.WriteString(fmt.Sprintf("\t\t%s: %s,\n", name, value))
+		case config.Type.Optional:
+			doc += ", and is nil when it is unset"
+		}
+		if config.Why != "" {
+			doc += ". " + config.Why
+		}
+		fields.WriteString(fmt.Sprintf("\t// %s\n\t%s %s\n", doc, name, gogen.FieldType(config.Type)))
+
+		assign := fmt.Sprintf("c.%s = %%s", name)
+		if config.Type.Optional {
+			assign = fmt.Sprintf("c.%s = &%%s", name)
+		}
+		parsed, value := parse(config)
+		var chain []goCheck
+		if parsed != nil {
+			chain = append(chain, *parsed)
+		}
+		chain = append(chain, checks(config, value)...)
+
+		body.WriteString(fmt.Sprintf("\tif v, ok := lookup(%q); ok && v != \"\" {\n", env))
+		if len(chain) == 0 {
+			body.WriteString(fmt.Sprintf("\t\t"+assign+"\n", value))
+		} else {
+			for i, check := range chain {
+				keyword := "if "
+				if i > 0 {
+					keyword = "} else if "
+				}
+				cond := check.cond
+				if check.init != "" {
+					cond = check.init + "; " + cond
+				}
+				body.WriteString(fmt.Sprintf("\t\t%s%s {\n", keyword, cond))
+				body.WriteString(fmt.Sprintf("\t\t\tproblems = append(problems, errors.New(%q))\n", env+" "+check.message))
+			}
+			body.WriteString(fmt.Sprintf("\t\t} else {\n\t\t\t"+assign+"\n\t\t}\n", value))
+		}
+		if config.Required() {
+			body.WriteString(fmt.Sprintf("\t} else {\n\t\tproblems = append(problems, errors.New(%q))\n", env+" is required"))
+		}
+		body.WriteString("\t}\n")
+	}
+
+	var code strings.Builder
+	code.WriteString("// Package " + pkg + " reads the settings the program needs from its\n")
+	code.WriteString("// environment, as the config declarations of its CloudPact files\n// describe them.\n")
+	code.WriteString(fmt.Sprintf("package %s\n\n", pkg))
+	code.WriteString("import (\n")
+	imports := []string{"errors", "os"}
+	for _, pkg := range []string{"net/mail", "net/url", "strconv", "strings", "time", "unicode/utf8"} {
+		use := pkg[strings.LastIndex(pkg, "/")+1:] + "."
+		if strings.Contains(fields.String(), use) || strings.Contains(body.String(), use) || strings.Contains(defaults.String(), use) {
+			imports = append(imports, pkg)
+		}
+	}
+	sort.Strings(imports)
+	for _, pkg := range imports {
+		code.WriteString(fmt.Sprintf("\t%q\n", pkg))
+	}
+	code.WriteString(")\n\n")
+	code.WriteString("// Config holds the settings, as Load reads them\n")
+	code.WriteString("type Config struct {\n")
+	code.WriteString(fields.String())
+	code.WriteString("}\n\n")
+	code.WriteString("// Load reads Config from the environment, reporting every variable that\n")
+	code.WriteString("// is missing or invalid, one per line\n")
+	code.WriteString("func Load() (*Config, error) {\n\treturn LoadFrom(os.LookupEnv)\n}\n\n")
+	code.WriteString("// LoadFrom is Load with lookup finding the variables in place of\n")
+	code.WriteString("// os.LookupEnv, such as in tests. An empty variable counts as unset.\n")
+	code.WriteString("func LoadFrom(lookup func(string) (string, bool)) (*Config, error) {\n")
+	if defaults.Len() > 0 {
+		code.WriteString("\tc := &Config{\n" + defaults.String() + "\t}\n")
+	} else {
+		code.WriteString("\tc := &Config{}\n")
+	}
+	code.WriteString("\tvar problems []error\n")
+	code.WriteString(body.String())
+	code.WriteString("\tif err := errors.Join(problems...); err != nil {\n\t\treturn nil, err\n\t}\n")
+	code.WriteString("\treturn c, nil\n}\n")
+
+	formatted, err := format.Source([]byte(code.String()))
+	if err != nil {
+		return []byte(code.String()), err
+	}
+	return codegen.Stamp("//", opts.Header, formatted), nil
+}
+
+// defaultText is the default of a setting as it is written in the
+// environment, or "" when it has none
+func defaultText(config *grammar.Config) string {
+	literal, ok := config.Default.(*grammar.LiteralExpression)
+	if !ok {
+		return ""
+	}
+	if f, ok := literal.Value.(float64); ok {
+		return codegen.FloatLiteral(f)
+	}
+	return fmt.Sprint(literal.Value)
+}
@@ -0,0 +1,81 @@
+package envgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/internal/testutil"
+)
+
+const settings = `// The primary database
+config databaseUrl: url from env DATABASE_URL
+    why: "Orders are kept in PostgreSQL"
+
+config port: int min 1 max 65535 default 8080
+    why: "The port to listen on"
+
+config sentryDsn: url optional
+    why: "Errors are reported here"
+
+config timeout: duration default "90s"
+    why: "How long a request may take"
+
+config greeting: text maxlength 20 default "hello there"
+    why: "What the server says"`
+
+func TestGenerateGo(t *testing.T) {
+	out, err := GenerateGo("config", testutil.CheckedFile(t, settings).Configs, Options{Header: "Code generated by cloudpact. DO NOT EDIT."})
+	if err != nil {
+		t.Fatalf("GenerateGo error: %v\n%s", err, out)
+	}
+	code := string(out)
+	for _, want := range []string{
+		"// Code generated by cloudpact. DO NOT EDIT.",
+		"package config",
+		"\t// The primary database\n\t// DatabaseUrl is read from DATABASE_URL. Orders are kept in PostgreSQL\n\tDatabaseUrl string\n",
+		"\t// SentryDsn is read from SENTRY_DSN, and is nil when it is unset. Errors are reported here\n\tSentryDsn *string\n",
+		"\tc := &Config{\n\t\tPort:     8080,\n\t\tTimeout:  90 * time.Second,\n\t\tGreeting: \"hello there\",\n\t}\n",
+		"\t\tif u, err := url.Parse(v); err != nil || u.Scheme == \"\" {\n\t\t\tproblems = append(problems, errors.New(\"DATABASE_URL must be a URL\"))\n\t\t} else {\n\t\t\tc.DatabaseUrl = v\n\t\t}\n\t} else {\n\t\tproblems = append(problems, errors.New(\"DATABASE_URL is required\"))\n\t}\n",
+		"} else if n > 65535 {\n\t\t\tproblems = append(problems, errors.New(\"PORT must be at most 65535\"))\n\t\t} else {\n\t\t\tc.Port = n\n\t\t}\n\t}\n",
+		"c.SentryDsn = &v",
+		"if d, err := time.ParseDuration(v); err != nil {",
+		"if utf8.RuneCountInString(v) > 20 {",
+		"if err := errors.Join(problems...); err != nil {",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in output:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "\"net/mail\"") {
+		t.Error("net/mail is imported only for email settings")
+	}
+}
+
+func TestGenerateExample(t *testing.T) {
+	example := string(GenerateExample(testutil.CheckedFile(t, settings).Configs, Options{Header: "Code generated by cloudpact. DO NOT EDIT."}))
+	for _, want := range []string{
+		"# Code generated by cloudpact. DO NOT EDIT.\n\n# The primary database\n# Orders are kept in PostgreSQL (url, required)\nDATABASE_URL=\n",
+		"# The port to listen on (int, default 8080)\nPORT=8080\n",
+		"# Errors are reported here (url, optional)\nSENTRY_DSN=\n",
+		"TIMEOUT=90s\n",
+		"GREETING=\"hello there\"\n",
+	} {
+		if !strings.Contains(example, want) {
+			t.Errorf("expected %q in .env.example:\n%s", want, example)
+		}
+	}
+}
+
+func TestGenerateTS(t *testing.T) {
+	typings := string(GenerateTS(testutil.CheckedFile(t, settings).Configs, Options{}))
+	for _, want := range []string{
+		"declare namespace NodeJS {\n  interface ProcessEnv {\n",
+		"    /** Orders are kept in PostgreSQL (url, required) */\n    readonly DATABASE_URL: string;\n",
+		"    readonly PORT?: string;\n",
+		"    readonly SENTRY_DSN?: string;\n",
+	} {
+		if !strings.Contains(typings, want) {
+			t.Errorf("expected %q in env.d.ts:\n%s", want, typings)
+		}
+	}
+}
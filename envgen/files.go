@@ -0,0 +1,78 @@
+package envgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// describe is what a variable holds and whether it must be set, such as
+// "url, required" or "int, default 8080"
+func describe(config *grammar.Config) string {
+	t := config.Type.Name
+	if config.Type.Alias != "" {
+		t = config.Type.Alias
+	}
+	switch {
+	case config.Default != nil:
+		return fmt.Sprintf("%s, default %s", t, defaultText(config))
+	case config.Type.Optional:
+		return t + ", optional"
+	}
+	return t + ", required"
+}
+
+// GenerateExample writes .env.example: each variable configs read, after a
+// comment saying what it is for, set to its default or left empty
+func GenerateExample(configs []*grammar.Config, opts Options) []byte {
+	var b strings.Builder
+	for i, config := range configs {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		for _, line := range codegen.DocLines(config.Leading, config.Trailing) {
+			b.WriteString("# " + line + "\n")
+		}
+		if config.Why != "" {
+			b.WriteString(fmt.Sprintf("# %s (%s)\n", config.Why, describe(config)))
+		} else {
+			b.WriteString(fmt.Sprintf("# (%s)\n", describe(config)))
+		}
+		b.WriteString(fmt.Sprintf("%s=%s\n", config.EnvName(), envValue(defaultText(config))))
+	}
+	return codegen.Stamp("#", opts.Header, []byte(b.String()))
+}
+
+// envValue quotes value for a .env file when it holds spaces or quotes
+func envValue(value string) string {
+	if strings.ContainsAny(value, " \t\"'#$\\") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`).Replace(value) + `"`
+	}
+	return value
+}
+
+// GenerateTS writes env.d.ts, typing the variables configs read in
+// process.env. Those the program cannot start without are declared as
+// always set.
+func GenerateTS(configs []*grammar.Config, opts Options) []byte {
+	var b strings.Builder
+	b.WriteString("// The variables the program reads from its environment, as the config\n")
+	b.WriteString("// declarations of its CloudPact files describe them\n")
+	b.WriteString("declare namespace NodeJS {\n  interface ProcessEnv {\n")
+	for _, config := range configs {
+		doc := describe(config)
+		if config.Why != "" {
+			doc = fmt.Sprintf("%s (%s)", config.Why, doc)
+		}
+		b.WriteString(fmt.Sprintf("    /** %s */\n", strings.ReplaceAll(doc, "*/", "*\\/")))
+		optional := "?"
+		if config.Required() {
+			optional = ""
+		}
+		b.WriteString(fmt.Sprintf("    readonly %s%s: string;\n", config.EnvName(), optional))
+	}
+	b.WriteString("  }\n}\n")
+	return codegen.Stamp("//", opts.Header, []byte(b.String()))
+}
@@ -0,0 +1,227 @@
+package formgen
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// RuntimeName is the module, beside the bindings, that they share
+const RuntimeName = "forms.ts"
+
+// runtime reads and fills forms and submits them through a save function;
+// the bindings describe each record's inputs to it
+const runtime = `/** How a form field's value is read from its input */
+export type FieldKind = "text" | "number" | "checkbox" | "lines" | "datetime";
+
+/** A record field the form has an input for, named by its JSON key */
+export interface FormField {
+  name: string;
+  kind: FieldKind;
+  /** The field may be left out */
+  optional?: boolean;
+  /** The field may be null */
+  nullable?: boolean;
+}
+
+export interface FormHandlers<T> {
+  /** Loads the record to edit; without it the form creates one */
+  load?: () => Promise<T>;
+  /** Saves the values read from the form, given the record loaded or last saved */
+  save: (values: Partial<T>, current?: T) => Promise<T>;
+  onSaved?: (saved: T) => void;
+}
+
+type FieldElement = HTMLInputElement | HTMLTextAreaElement;
+
+function element(form: HTMLFormElement, name: string): FieldElement | null {
+  const item = form.elements.namedItem(name);
+  return item instanceof HTMLInputElement || item instanceof HTMLTextAreaElement ? item : null;
+}
+
+/** Reads the inputs of form as record fields */
+export function readForm<T>(form: HTMLFormElement, fields: FormField[]): Partial<T> {
+  const values: Record<string, unknown> = {};
+  for (const field of fields) {
+    const input = element(form, field.name);
+    if (!input) continue;
+    if (field.kind === "checkbox") {
+      values[field.name] = (input as HTMLInputElement).checked;
+      continue;
+    }
+    const raw = input.value.trim();
+    if (field.kind === "lines") {
+      values[field.name] = raw.split("\n").map((line) => line.trim()).filter((line) => line !== "");
+      continue;
+    }
+    if (raw === "") {
+      if (field.nullable) values[field.name] = null;
+      else if (!field.optional && field.kind === "text") values[field.name] = "";
+      continue;
+    }
+    if (field.kind === "number") values[field.name] = Number(raw);
+    else if (field.kind === "datetime") values[field.name] = new Date(raw).toISOString();
+    else values[field.name] = raw;
+  }
+  return values as Partial<T>;
+}
+
+/** Fills the inputs of form from record */
+export function fillForm<T>(form: HTMLFormElement, fields: FormField[], record: T): void {
+  const values = record as unknown as Record<string, unknown>;
+  for (const field of fields) {
+    const input = element(form, field.name);
+    if (!input) continue;
+    const value = values[field.name];
+    if (field.kind === "checkbox") {
+      (input as HTMLInputElement).checked = value === true;
+    } else if (value === undefined || value === null) {
+      input.value = "";
+    } else if (field.kind === "lines") {
+      input.value = (value as unknown[]).join("\n");
+    } else if (field.kind === "datetime") {
+      input.value = localDateTime(String(value));
+    } else {
+      input.value = String(value);
+    }
+  }
+}
+
+/** Writes an ISO 8601 time as a datetime-local input shows it */
+function localDateTime(iso: string): string {
+  const time = new Date(iso);
+  if (Number.isNaN(time.getTime())) return "";
+  const local = new Date(time.getTime() - time.getTimezoneOffset() * 60000);
+  return local.toISOString().slice(0, 16);
+}
+
+/**
+ * Submits form through handlers once the browser has checked its inputs.
+ * Failures are shown in the form's [data-form-error] element, which is an
+ * alert for screen readers. Returns a function that unbinds the form.
+ */
+export function bindForm<T>(form: HTMLFormElement, fields: FormField[], handlers: FormHandlers<T>): () => void {
+  const error = form.querySelector<HTMLElement>("[data-form-error]");
+  const showError = (message: string) => {
+    if (!error) return;
+    error.textContent = message;
+    error.hidden = message === "";
+  };
+  let current: T | undefined;
+  if (handlers.load) {
+    handlers.load().then(
+      (record) => {
+        current = record;
+        fillForm(form, fields, record);
+      },
+      (err) => showError(err instanceof Error ? err.message : String(err)),
+    );
+  }
+
+  const submit = async (event: SubmitEvent) => {
+    event.preventDefault();
+    if (!form.reportValidity()) return;
+    showError("");
+    form.setAttribute("aria-busy", "true");
+    try {
+      current = await handlers.save(readForm<T>(form, fields), current);
+      handlers.onSaved?.(current);
+    } catch (err) {
+      showError(err instanceof Error ? err.message : String(err));
+    } finally {
+      form.removeAttribute("aria-busy");
+    }
+  };
+  form.addEventListener("submit", submit);
+  return () => form.removeEventListener("submit", submit);
+}
+`
+
+// Runtime writes the module the bindings share. header is a provenance
+// comment; empty means none.
+func Runtime(header string) []byte {
+	return codegen.Stamp("//", header, []byte(runtime))
+}
+
+// TSOptions tunes a binding
+type TSOptions struct {
+	// Header is the provenance comment the file starts with, usually
+	// codegen.Header; empty means none
+	Header string
+	// TypesImport is the module declaring the record's interface, relative
+	// to the binding, such as "../ts/shop"
+	TypesImport string
+}
+
+// GenerateTS writes the binding of record's form: the fields the form
+// edits and bind<Record>Form, which submits them through the onSubmit
+// option. The API serves functions rather than CRUD routes, so the caller
+// picks what saves a record, such as an APIClient method.
+func GenerateTS(record *grammar.Record, opts TSOptions) []byte {
+	name := record.Name
+	variable := lowerFirst(name)
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("import { bindForm, type FormField } from \"./%s\";\n", strings.TrimSuffix(RuntimeName, ".ts")))
+	b.WriteString(fmt.Sprintf("import type { %s } from %q;\n", name, opts.TypesImport))
+
+	b.WriteString(fmt.Sprintf("\n/** The fields of %s the form has inputs for */\n", name))
+	b.WriteString(fmt.Sprintf("export const %sFields: FormField[] = [\n", variable))
+	for _, in := range Inputs(record) {
+		field := fmt.Sprintf("{ name: %q, kind: %q", in.Field.JSONKey(), in.Kind)
+		if in.Field.Type.Nullable {
+			field += ", nullable: true"
+		} else if in.Field.Type.Optional {
+			field += ", optional: true"
+		}
+		b.WriteString("  " + field + " },\n")
+	}
+	b.WriteString("];\n")
+
+	b.WriteString(fmt.Sprintf("\nexport interface %sFormOptions {\n", name))
+	b.WriteString(fmt.Sprintf("  /** Loads the %s to edit; without it the form starts from its inputs */\n", name))
+	b.WriteString(fmt.Sprintf("  load?: () => Promise<%s>;\n", name))
+	b.WriteString(fmt.Sprintf("  /** Saves the values read from the form, given the %s loaded or last saved */\n", name))
+	b.WriteString(fmt.Sprintf("  onSubmit: (values: Partial<%s>, current?: %s) => Promise<%s>;\n", name, name, name))
+	b.WriteString(fmt.Sprintf("  /** Called with the %s onSubmit resolves to */\n", name))
+	b.WriteString(fmt.Sprintf("  onSaved?: (saved: %s) => void;\n", name))
+	b.WriteString("}\n")
+
+	b.WriteString("\n/**\n")
+	b.WriteString(fmt.Sprintf(" * Submits the %s form through options.onSubmit. Fields without an\n", name))
+	b.WriteString(" * input keep their loaded values. Returns a function that unbinds the\n")
+	b.WriteString(" * form.\n")
+	b.WriteString(" */\n")
+	b.WriteString(fmt.Sprintf("export function bind%sForm(form: HTMLFormElement, options: %sFormOptions): () => void {\n", name, name))
+	b.WriteString(fmt.Sprintf("  return bindForm<%s>(form, %sFields, { load: options.load, save: options.onSubmit, onSaved: options.onSaved });\n", name, variable))
+	b.WriteString("}\n")
+	return codegen.Stamp("//", opts.Header, []byte(b.String()))
+}
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// tsProperty writes access to the property key
+func tsProperty(key string) string {
+	if tsIdentifier.MatchString(key) {
+		return "." + key
+	}
+	return fmt.Sprintf("[%q]", key)
+}
+
+// lowerFirst lowercases the first letter of a name, for variables
+func lowerFirst(name string) string {
+	runes := []rune(name)
+	if len(runes) > 0 {
+		runes[0] = unicode.ToLower(runes[0])
+	}
+	return string(runes)
+}
+
+// FileName is the name the form and binding of record are written under,
+// without extension: OrderLine gives order-line.form
+func FileName(record *grammar.Record) string {
+	return kebabCase(record.Name) + ".form"
+}
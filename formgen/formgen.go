@@ -0,0 +1,369 @@
+// Package formgen writes HTML forms for CloudPact records, with TypeScript
+// binding them to a submit callback. Inputs follow the semantic
+// types, so browsers check what the Go validate tags would before a
+// request is sent, and each is labelled and described for assistive
+// technology.
+package formgen
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Editable reports whether record gets a form: it must be identified, by
+// the implicit id or a key field, for a form to load and update it
+func Editable(record *grammar.Record) bool {
+	return record.HasImplicitID() || record.KeyField() != nil
+}
+
+// Input is a record field as a form edits it
+type Input struct {
+	Field *grammar.FieldDef
+	// ID names the element, unique in a page with one form per record
+	ID string
+	// Kind is how the value is read from the input: text, number,
+	// checkbox, lines or datetime
+	Kind string
+	// Label names the input for people, saying when it is optional
+	Label string
+	// Hint describes the input: the field's comments, or what its type
+	// means; empty means none
+	Hint string
+	// Textarea is set for lists, a line each, and long text
+	Textarea bool
+	// Attributes are those of the element besides its id, name and
+	// description: its type, what the browser checks and its default
+	Attributes []Attribute
+}
+
+// Attribute is an HTML attribute; a boolean attribute has no value
+type Attribute struct {
+	Name, Value string
+	Boolean     bool
+}
+
+// Inputs lists the fields of record a form edits. Records, maps and lists
+// of anything but text have no input; bindings keep the values loaded for
+// them.
+func Inputs(record *grammar.Record) []Input {
+	var list []Input
+	for _, field := range record.AllFields() {
+		kind := inputKind(field.Type)
+		if kind == "" {
+			continue
+		}
+		t := field.Type
+		in := Input{
+			Field:    field,
+			ID:       kebabCase(record.Name) + "-" + kebabCase(field.Name),
+			Kind:     kind,
+			Label:    label(field.Name),
+			Textarea: isTextarea(t),
+		}
+		if t.Optional && kind != "checkbox" {
+			in.Label += " (optional)"
+		}
+
+		if doc := codegen.DocLines(field.Leading, field.Trailing); len(doc) > 0 {
+			in.Hint = strings.Join(doc, " ")
+		} else if kind == "lines" {
+			in.Hint = "One per line"
+		} else if (kind == "text" || kind == "number") && !strings.EqualFold(t.Name, "date") {
+			// The date picker shows the format
+			in.Hint = codegen.TypeComment(t.Name)
+		}
+
+		in.Attributes = inputAttributes(t)
+		if !t.Optional && kind != "checkbox" && kind != "lines" {
+			in.Attributes = append(in.Attributes, Attribute{Name: "required", Boolean: true})
+		}
+		in.Attributes = append(in.Attributes, defaultValue(field, kind)...)
+		list = append(list, in)
+	}
+	return list
+}
+
+// inputKind is how a field of type t is read from its input, or "" when it
+// has none
+func inputKind(t *grammar.Type) string {
+	if t.Name == "list" && t.Element != nil {
+		if !t.Element.Nullable && inputKind(t.Element) == "text" {
+			return "lines"
+		}
+		return ""
+	}
+	if t.Name == "map" || codegen.IsRecordTypeName(t.Name) {
+		return ""
+	}
+	switch strings.ToLower(t.Name) {
+	case "int", "integer", "float", "number", "usd_currency", "eur_currency", "percentage":
+		return "number"
+	case "bool", "boolean":
+		return "checkbox"
+	case "datetime", "timestamp":
+		return "datetime"
+	}
+	return "text"
+}
+
+// GenerateHTML writes the form of record as an HTML fragment to place in a
+// page. header is a provenance comment, usually codegen.Header; empty
+// means none.
+func GenerateHTML(record *grammar.Record, header string) []byte {
+	var b strings.Builder
+	if header != "" {
+		b.WriteString(fmt.Sprintf("<!-- %s -->\n", html.EscapeString(header)))
+	}
+	name := kebabCase(record.Name)
+	b.WriteString(fmt.Sprintf("<form id=\"%s-form\" class=\"cloudpact-form\" data-record=\"%s\" aria-labelledby=\"%s-form-title\">\n", name, record.Name, name))
+	b.WriteString(fmt.Sprintf("  <h2 id=\"%s-form-title\">%s</h2>\n", name, html.EscapeString(label(record.Name))))
+	if doc := codegen.DocLines(record.Leading, record.Trailing); len(doc) > 0 {
+		b.WriteString(fmt.Sprintf("  <p class=\"form-description\">%s</p>\n", html.EscapeString(strings.Join(doc, " "))))
+	}
+	for _, in := range Inputs(record) {
+		writeInput(&b, in)
+	}
+	b.WriteString("  <p class=\"form-error\" data-form-error role=\"alert\" hidden></p>\n")
+	b.WriteString("  <button type=\"submit\">Save</button>\n")
+	b.WriteString("</form>\n")
+	return []byte(b.String())
+}
+
+// writeInput writes a field's label, input and hint
+func writeInput(b *strings.Builder, in Input) {
+	attrs := []Attribute{{Name: "id", Value: in.ID}, {Name: "name", Value: in.Field.JSONKey()}}
+	attrs = append(attrs, in.Attributes...)
+	if in.Hint != "" {
+		attrs = append(attrs, Attribute{Name: "aria-describedby", Value: in.ID + "-hint"})
+	}
+
+	b.WriteString("  <div class=\"field\">\n")
+	labelTag := fmt.Sprintf("    <label for=\"%s\">%s</label>\n", in.ID, html.EscapeString(in.Label))
+	switch {
+	case in.Kind == "checkbox":
+		b.WriteString(fmt.Sprintf("    <input%s>\n", writeAttributes(attrs)))
+		b.WriteString(labelTag)
+	case in.Textarea:
+		b.WriteString(labelTag)
+		b.WriteString(fmt.Sprintf("    <textarea%s></textarea>\n", writeAttributes(attrs)))
+	default:
+		b.WriteString(labelTag)
+		b.WriteString(fmt.Sprintf("    <input%s>\n", writeAttributes(attrs)))
+	}
+	if in.Hint != "" {
+		b.WriteString(fmt.Sprintf("    <p id=\"%s-hint\" class=\"hint\">%s</p>\n", in.ID, html.EscapeString(in.Hint)))
+	}
+	b.WriteString("  </div>\n")
+}
+
+// isTextarea reports whether a field of type t is edited in a textarea
+// rather than an input: lists, a line each, and long text
+func isTextarea(t *grammar.Type) bool {
+	switch strings.ToLower(t.Name) {
+	case "list", "html", "markdown", "json":
+		return true
+	}
+	return false
+}
+
+// inputAttributes describe the input for a field of type t: its type, or
+// rows for a textarea, first, then what the browser checks. Declared
+// constraints replace the semantic type's bounds, as in the validate tags.
+func inputAttributes(t *grammar.Type) []Attribute {
+	var attrs []Attribute
+	add := func(name, value string) {
+		attrs = append(attrs, Attribute{Name: name, Value: value})
+	}
+
+	var min, max, minLength, maxLength, pattern string
+	number := false
+	if t.Name == "list" {
+		add("rows", "4")
+	} else {
+		switch strings.ToLower(t.Name) {
+		case "int", "integer":
+			add("type", "number")
+			add("step", "1")
+			add("inputmode", "numeric")
+			number = true
+		case "float", "number":
+			add("type", "number")
+			add("step", "any")
+			add("inputmode", "decimal")
+			number = true
+		case "usd_currency", "eur_currency":
+			add("type", "number")
+			add("step", "0.01")
+			add("inputmode", "decimal")
+			min = "0"
+			number = true
+		case "percentage":
+			add("type", "number")
+			add("step", "any")
+			add("inputmode", "decimal")
+			min, max = "0", "100"
+			number = true
+		case "bool", "boolean":
+			add("type", "checkbox")
+		case "date":
+			add("type", "date")
+		case "datetime", "timestamp":
+			add("type", "datetime-local")
+		case "time":
+			add("type", "time")
+			add("step", "1")
+		case "email":
+			add("type", "email")
+			add("autocomplete", "email")
+		case "url":
+			add("type", "url")
+			add("autocomplete", "url")
+		case "phone":
+			add("type", "tel")
+			add("autocomplete", "tel")
+			pattern = `\+[1-9]\d{1,14}` // E.164, like the e164 tag
+		case "password":
+			add("type", "password")
+			add("autocomplete", "new-password")
+			minLength = "8"
+		case "zip_code":
+			add("type", "text")
+			add("inputmode", "numeric")
+			add("autocomplete", "postal-code")
+			minLength, maxLength = "5", "5"
+		case "country_code":
+			add("type", "text")
+			add("autocomplete", "country")
+			pattern = `[A-Za-z]{2}`
+		case "state_code":
+			add("type", "text")
+			add("autocomplete", "address-level1")
+			pattern = `[A-Za-z]{2}`
+		case "uuid":
+			add("type", "text")
+			pattern = `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`
+		case "html", "markdown", "json":
+			add("rows", "8")
+		default:
+			add("type", "text")
+		}
+	}
+
+	for _, name := range codegen.FieldConstraints {
+		value, ok := t.Constraints[name]
+		if !ok {
+			continue
+		}
+		switch name {
+		case grammar.ConstraintMin:
+			min = fmt.Sprint(value)
+		case grammar.ConstraintMax:
+			max = fmt.Sprint(value)
+		case grammar.ConstraintMinLength:
+			minLength = fmt.Sprint(value)
+		case grammar.ConstraintMaxLength:
+			maxLength = fmt.Sprint(value)
+		case grammar.ConstraintDomain:
+			// An input takes one pattern; the domain is the narrower
+			pattern = `[^@\s]+@` + regexp.QuoteMeta(fmt.Sprint(value))
+		}
+	}
+	if number {
+		if min != "" {
+			add("min", min)
+		}
+		if max != "" {
+			add("max", max)
+		}
+		return attrs
+	}
+	if minLength != "" {
+		add("minlength", minLength)
+	}
+	if maxLength != "" {
+		add("maxlength", maxLength)
+	}
+	if pattern != "" {
+		add("pattern", pattern)
+	}
+	return attrs
+}
+
+// defaultValue prefills the input of a field declaring a constant default
+func defaultValue(field *grammar.FieldDef, kind string) []Attribute {
+	literal, ok := field.Default.(*grammar.LiteralExpression)
+	if !ok || literal.Value == nil {
+		return nil
+	}
+	switch v := literal.Value.(type) {
+	case bool:
+		if kind == "checkbox" && v {
+			return []Attribute{{Name: "checked", Boolean: true}}
+		}
+		return nil
+	case float64:
+		return []Attribute{{Name: "value", Value: codegen.FloatLiteral(v)}}
+	default:
+		if kind == "lines" || kind == "datetime" {
+			return nil
+		}
+		return []Attribute{{Name: "value", Value: fmt.Sprint(v)}}
+	}
+}
+
+// writeAttributes writes attrs as they follow an element name
+func writeAttributes(attrs []Attribute) string {
+	var b strings.Builder
+	for _, attr := range attrs {
+		if attr.Boolean {
+			b.WriteString(" " + attr.Name)
+			continue
+		}
+		b.WriteString(fmt.Sprintf(" %s=\"%s\"", attr.Name, html.EscapeString(attr.Value)))
+	}
+	return b.String()
+}
+
+// label writes a name for people: customerEmail and customer_email both
+// give "Customer email"
+func label(name string) string {
+	words := strings.Split(kebabCase(name), "-")
+	text := strings.Join(words, " ")
+	if text == "" {
+		return text
+	}
+	runes := []rune(text)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// kebabCase writes a name as lowercase words joined by hyphens, for element
+// ids and file names: OrderLine gives order-line
+func kebabCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == ' ':
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), "-") {
+				b.WriteRune('-')
+			}
+		case unicode.IsUpper(r):
+			// A capital starts a word, unless it continues an acronym
+			if i > 0 && b.Len() > 0 && !strings.HasSuffix(b.String(), "-") &&
+				(!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteRune('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,103 @@
+package formgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/internal/testutil"
+)
+
+func TestGenerateHTML(t *testing.T) {
+	f := testutil.CheckedFile(t, `// A member of the club
+define record Member
+    emailAddress: email
+    // What we call them
+    nickname: text optional
+    birthday: date
+    phone: phone
+    active: bool default true
+    discount: percentage
+    balance: usd_currency
+    joined: datetime
+    age: int min 18
+    tags: list of text
+    sponsor: maybe Member
+    notes: markdown optional`)
+	f.NameJSON("camel")
+
+	form := string(GenerateHTML(f.Records[0], "Code generated by cloudpact. DO NOT EDIT."))
+	for _, want := range []string{
+		"<!-- Code generated by cloudpact. DO NOT EDIT. -->\n<form id=\"member-form\"",
+		`aria-labelledby="member-form-title">`,
+		`<p class="form-description">A member of the club</p>`,
+		"<label for=\"member-email-address\">Email address</label>\n    <input id=\"member-email-address\" name=\"emailAddress\" type=\"email\" autocomplete=\"email\" required aria-describedby=\"member-email-address-hint\">",
+		`<label for="member-nickname">Nickname (optional)</label>`,
+		`<p id="member-nickname-hint" class="hint">What we call them</p>`,
+		`name="birthday" type="date" required>`,
+		`type="tel" autocomplete="tel" pattern="\+[1-9]\d{1,14}" required`,
+		`<input id="member-active" name="active" type="checkbox" checked>`,
+		`type="number" step="any" inputmode="decimal" min="0" max="100" required`,
+		`type="number" step="0.01" inputmode="decimal" min="0" required`,
+		`type="datetime-local" required>`,
+		`type="number" step="1" inputmode="numeric" min="18" required>`,
+		`<textarea id="member-tags" name="tags" rows="4" aria-describedby="member-tags-hint"></textarea>`,
+		`<textarea id="member-notes" name="notes" rows="8"></textarea>`,
+		`<p class="form-error" data-form-error role="alert" hidden></p>`,
+	} {
+		if !strings.Contains(form, want) {
+			t.Errorf("expected %q in form:\n%s", want, form)
+		}
+	}
+	if strings.Contains(form, "sponsor") {
+		t.Error("a record field has no input")
+	}
+}
+
+func TestGenerateTS(t *testing.T) {
+	f := testutil.CheckedFile(t, `define record Course
+    code: text key
+    fee: usd_currency
+    note: maybe text
+
+define record Enrollment versioned
+    started: datetime
+
+define record Session no id
+    code: text`)
+	f.NameJSON("camel")
+
+	binding := string(GenerateTS(f.Records[0], TSOptions{TypesImport: "../ts/club"}))
+	for _, want := range []string{
+		"import { bindForm, type FormField } from \"./forms\";\nimport type { Course } from \"../ts/club\";",
+		`{ name: "code", kind: "text" },`,
+		`{ name: "fee", kind: "number" },`,
+		`{ name: "note", kind: "text", nullable: true },`,
+		"  onSubmit: (values: Partial<Course>, current?: Course) => Promise<Course>;",
+		"export function bindCourseForm(form: HTMLFormElement, options: CourseFormOptions): () => void {",
+		"return bindForm<Course>(form, courseFields, { load: options.load, save: options.onSubmit, onSaved: options.onSaved });",
+	} {
+		if !strings.Contains(binding, want) {
+			t.Errorf("expected %q in binding:\n%s", want, binding)
+		}
+	}
+	// The API has no CRUD routes for records, so the binding calls none
+	if strings.Contains(binding, "client") || strings.Contains(binding, "createCourse") {
+		t.Errorf("expected the binding to save through onSubmit only:\n%s", binding)
+	}
+	if Editable(f.Records[2]) {
+		t.Error("a record without an id cannot be loaded and updated")
+	}
+}
+
+func TestKebabCase(t *testing.T) {
+	for name, want := range map[string]string{
+		"OrderLine":      "order-line",
+		"emailAddress":   "email-address",
+		"email_address":  "email-address",
+		"HTTPRequestLog": "http-request-log",
+	} {
+		if got := kebabCase(name); got != want {
+			t.Errorf("kebabCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -3,10 +3,15 @@ package generator
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
 	"github.com/daveroberts0321/cloudpact/project"
 	"github.com/daveroberts0321/cloudpact/spec/openapi"
+	"github.com/daveroberts0321/cloudpact/tsgen"
 )
 
 func GenerateRecord(name string) {
@@ -22,7 +27,7 @@ function validate%s(record: %s) returns boolean
     do:
         if record.name is empty
             then return false
-        if not record.email contains "@"
+        if record.email not contains "@"
             then return false
         return true
 `, name, name, name, name, strings.ToLower(name))
@@ -68,35 +73,75 @@ func GenerateFunction(name string) {
 	fmt.Printf("Function %s generated at %s\n", name, filename)
 }
 
-func GenerateModel(name string) {
+// GenerateModel writes Go and TypeScript for a legacy model with id and name
+// fields, rendered by the same generators as cloudpact build. The Go goes in
+// the directory of the Models module's package, as a build would put it.
+func GenerateModel(name string) error {
 	model := strings.Title(name)
-	goCode := "package models\n\n" +
-		fmt.Sprintf("type %s struct {\n", model) +
-		"\tID   string `json:\"id\"`\n" +
-		"\tName string `json:\"name\"`\n" +
-		"}\n"
-
-	tsCode := fmt.Sprintf("export interface %s {\n\tid: string;\n\tname: string;\n}\n", model)
+	source := fmt.Sprintf("module Models\n\nmodel %s {\n    id: text\n    name: text\n}\n", model)
+	file, err := grammar.ParseString(source)
+	if err != nil {
+		return err
+	}
+	if err := analyzer.Check(file); err != nil {
+		return err
+	}
 
-	os.MkdirAll("generated/go", 0755)
-	os.MkdirAll("generated/ts", 0755)
+	goCode, err := gogen.GenerateFile(file, gogen.Options{})
+	if err != nil {
+		return err
+	}
+	tsCode, err := tsgen.GenerateFile(file, tsgen.Options{})
+	if err != nil {
+		return err
+	}
 
-	_ = os.WriteFile(fmt.Sprintf("generated/go/%s.go", strings.ToLower(name)), []byte(goCode), 0644)
-	_ = os.WriteFile(fmt.Sprintf("generated/ts/%s.ts", strings.ToLower(name)), []byte(tsCode), 0644)
+	goDir, err := project.OutputDir("go")
+	if err != nil {
+		return err
+	}
+	goDir = filepath.Join(goDir, gogen.PackageName(file))
+	tsDir, err := project.OutputDir("ts")
+	if err != nil {
+		return err
+	}
+	for _, out := range []struct {
+		dir, ext string
+		code     []byte
+	}{{goDir, ".go", goCode}, {tsDir, ".ts", tsCode}} {
+		if err := os.MkdirAll(out.dir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(out.dir, strings.ToLower(name)+out.ext), out.code, 0644); err != nil {
+			return err
+		}
+	}
 
 	fmt.Printf("Legacy model %s generated in Go and TypeScript.\n", model)
+	return nil
 }
 
-func GenerateOpenAPI(path string) error {
+// GenerateOpenAPI writes the OpenAPI spec of the .cp file at path to
+// spec.yaml in out, or in the configured openapi directory when out is empty
+func GenerateOpenAPI(path, out string) error {
 	parsedFile, err := project.ParseCloudPactFile(path)
 	if err != nil {
 		return err
 	}
+	if err := analyzer.Check(parsedFile); err != nil {
+		return err
+	}
 
-	if err := openapi.WriteFile(parsedFile, "generated/openapi/spec.yaml"); err != nil {
+	if out == "" {
+		if out, err = project.OutputDir("openapi"); err != nil {
+			return err
+		}
+	}
+	output := filepath.Join(out, "spec.yaml")
+	if err := openapi.WriteFile(parsedFile, output); err != nil {
 		return err
 	}
 
-	fmt.Println("OpenAPI spec written to generated/openapi/spec.yaml")
+	fmt.Printf("OpenAPI spec written to %s\n", output)
 	return nil
 }
@@ -5,6 +5,9 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
 )
 
 func TestGenerateRecord(t *testing.T) {
@@ -22,4 +25,42 @@ func TestGenerateRecord(t *testing.T) {
 	if !strings.Contains(string(content), "record User") {
 		t.Fatalf("unexpected content: %s", string(content))
 	}
+
+	// The scaffolds are valid CloudPact
+	GenerateFunction("Check")
+	for _, path := range []string{filepath.Join("models", "user.cp"), filepath.Join("services", "check_service.cp")} {
+		source, _ := os.ReadFile(path)
+		file, err := grammar.ParseString(string(source))
+		if err != nil {
+			t.Fatalf("parse %s: %v", path, err)
+		}
+		if err := analyzer.Check(file); err != nil {
+			t.Fatalf("check %s: %v", path, err)
+		}
+	}
+}
+
+func TestGenerateModel(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	if err := GenerateModel("widget"); err != nil {
+		t.Fatalf("generate model: %v", err)
+	}
+	goCode, err := os.ReadFile(filepath.Join("generated", "go", "models", "widget.go"))
+	if err != nil {
+		t.Fatalf("expected Go output: %v", err)
+	}
+	if !strings.Contains(string(goCode), "package models") || !strings.Contains(string(goCode), "type Widget struct") {
+		t.Fatalf("unexpected Go output:\n%s", goCode)
+	}
+	tsCode, err := os.ReadFile(filepath.Join("generated", "ts", "widget.ts"))
+	if err != nil {
+		t.Fatalf("expected TypeScript output: %v", err)
+	}
+	if !strings.Contains(string(tsCode), "export interface Widget {") {
+		t.Fatalf("unexpected TypeScript output:\n%s", tsCode)
+	}
 }
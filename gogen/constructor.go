@@ -0,0 +1,160 @@
+package gogen
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// goCreationTimes are the names, lowercased without underscores, of time
+// fields that record when a record was made and so start at time.Now
+var goCreationTimes = map[string]bool{
+	"createdat": true, "createdon": true, "insertedat": true,
+	"updatedat": true, "updatedon": true, "modifiedat": true,
+}
+
+// goConstructorParams are the fields of record its constructor takes:
+// the required ones nothing else sets
+func goConstructorParams(record *grammar.Record) []*grammar.FieldDef {
+	key := goGeneratedKey(record)
+	var params []*grammar.FieldDef
+	for _, field := range record.AllFields() {
+		if !field.Type.Optional && field.Default == nil && !goCreationTime(field) && field != key {
+			params = append(params, field)
+		}
+	}
+	return params
+}
+
+// goGeneratedKey returns the key field of record its constructor sets to a
+// new UUID, which is one of type uuid without a default
+func goGeneratedKey(record *grammar.Record) *grammar.FieldDef {
+	if key := record.KeyField(); key != nil && key.Type.Name == "uuid" && key.Default == nil {
+		return key
+	}
+	return nil
+}
+
+// goGeneratedID is the field of record its constructor sets to a new UUID:
+// ID, a uuid key field, or none
+func goGeneratedID(record *grammar.Record) string {
+	if record.HasImplicitID() {
+		return "ID"
+	}
+	if key := goGeneratedKey(record); key != nil {
+		return FieldName(key.Name)
+	}
+	return ""
+}
+
+// goCreationTime reports whether field is a creation timestamp without a
+// declared default, such as createdAt: datetime
+func goCreationTime(field *grammar.FieldDef) bool {
+	name := strings.ToLower(strings.ReplaceAll(field.Name, "_", ""))
+	return field.Default == nil && FieldType(field.Type) == "time.Time" && goCreationTimes[name]
+}
+
+// generateGoConstructor adds New<Record>, which takes the required fields
+// nothing else sets and returns a validated record with a new ID, its
+// declared defaults and its creation timestamps set
+func generateGoConstructor(record *grammar.Record) string {
+	var code strings.Builder
+	name := record.Name
+	id := goGeneratedID(record)
+
+	var params []string
+	for _, field := range goConstructorParams(record) {
+		params = append(params, field.Name+" "+FieldType(field.Type))
+	}
+
+	doc := fmt.Sprintf("New%s returns a new %s with ", name, strings.ToLower(name))
+	if id != "" {
+		doc += fmt.Sprintf("a random UUID as its %s, its fields set to the given values and their declared defaults", id)
+	} else {
+		doc += "its fields set to the given values and their declared defaults"
+	}
+	if slices.ContainsFunc(record.AllFields(), goCreationTime) {
+		doc += ", and its creation time set to now"
+	}
+	doc += ". It returns an error when the result does not pass Validate."
+	code.WriteString(goComment(doc))
+	code.WriteString(fmt.Sprintf("func New%s(%s) (*%s, error) {\n", name, strings.Join(params, ", "), name))
+	if id != "" {
+		code.WriteString("\tvar newID [16]byte\n")
+		code.WriteString("\tif _, err := rand.Read(newID[:]); err != nil {\n")
+		code.WriteString("\t\treturn nil, err\n")
+		code.WriteString("\t}\n")
+		code.WriteString("\tnewID[6] = newID[6]&0x0f | 0x40 // version 4\n")
+		code.WriteString("\tnewID[8] = newID[8]&0x3f | 0x80 // RFC 4122 variant\n")
+	}
+	code.WriteString(fmt.Sprintf("\tr := &%s\n", goConstructorLiteral(record, goGeneratedKey(record), "\t")))
+	code.WriteString("\tif err := r.Validate(); err != nil {\n")
+	code.WriteString("\t\treturn nil, err\n")
+	code.WriteString("\t}\n")
+	code.WriteString("\treturn r, nil\n")
+	code.WriteString("}\n\n")
+
+	return code.String()
+}
+
+// goNewUUID formats the random bytes of a constructor as a UUID
+const goNewUUID = `fmt.Sprintf("%x-%x-%x-%x-%x", newID[0:4], newID[4:6], newID[6:8], newID[8:10], newID[10:])`
+
+// goArticle prefixes phrase with "a" or "an" by how its first word begins
+func goArticle(phrase string) string {
+	if phrase != "" && strings.ContainsRune("aeiouAEIOU", rune(phrase[0])) {
+		return "an " + phrase
+	}
+	return "a " + phrase
+}
+
+// goComment wraps text into // lines of at most 80 columns
+func goComment(text string) string {
+	var code strings.Builder
+	line := "//"
+	for _, word := range strings.Fields(text) {
+		if len(line)+1+len(word) > 80 && line != "//" {
+			code.WriteString(line + "\n")
+			line = "//"
+		}
+		line += " " + word
+	}
+	code.WriteString(line + "\n")
+	return code.String()
+}
+
+// goConstructorLiteral is the composite literal of record in its
+// constructor, with the records it extends nested inside it. key is the
+// field set to a new UUID, if any.
+func goConstructorLiteral(record *grammar.Record, key *grammar.FieldDef, indent string) string {
+	var code strings.Builder
+	code.WriteString(record.Name + "{\n")
+	if record.Base != nil {
+		code.WriteString(fmt.Sprintf("%s\t%s: %s,\n", indent, record.Base.Name, goConstructorLiteral(record.Base, key, indent+"\t")))
+	} else if record.HasImplicitID() {
+		code.WriteString(fmt.Sprintf("%s\tID: %s,\n", indent, goNewUUID))
+	}
+	for _, field := range record.Fields {
+		var value string
+		switch {
+		case field == key:
+			value = goNewUUID
+		case field.Type.Optional:
+			continue
+		case goCreationTime(field):
+			value = "time.Now()"
+		case field.Default != nil:
+			value = "time.Now()"
+			if literal, ok := field.Default.(*grammar.LiteralExpression); ok {
+				value = goLiteral(literal)
+			}
+		default:
+			value = field.Name
+		}
+		code.WriteString(fmt.Sprintf("%s\t%s: %s,\n", indent, FieldName(field.Name), value))
+	}
+	code.WriteString(indent + "}")
+	return code.String()
+}
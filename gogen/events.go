@@ -0,0 +1,151 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// EventsPackage is the package GenerateEvents writes, in a directory of
+// the package of the files' records, so it is regenerated on its own
+const EventsPackage = "events"
+
+// GenerateEvents translates the events of the checked files of one Go
+// package into publisher and subscriber stubs, in package events. Payloads
+// are the records of the package GenerateFile writes, imported through
+// opts.Packages. Events travel as JSON through a Broker, an interface
+// adapters for NATS, Kafka or the like implement; MemoryBroker delivers
+// them within one process. Each event gets a channel constant,
+// Publish<Event> and Subscribe<Event>, which hands decoded and validated
+// payloads to a Go channel. Files without events yield nil.
+func GenerateEvents(files []*grammar.File, opts Options) ([]byte, error) {
+	var events []*grammar.Event
+	for _, file := range files {
+		events = append(events, file.Events...)
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	var code strings.Builder
+	code.WriteString(goBrokerSource)
+	for _, event := range events {
+		writeGoEvent(&code, event)
+	}
+
+	formatted, err := RenderFile(EventsPackage, []string{"context", "encoding/json", "sync"}, code.String(), opts.Packages)
+	if err != nil {
+		return formatted, err
+	}
+	return codegen.Stamp("//", opts.Header, formatted), nil
+}
+
+// writeGoEvent writes the channel constant and the publish and subscribe
+// functions of event
+func writeGoEvent(code *strings.Builder, event *grammar.Event) {
+	name, payload := event.Name, event.Payload.Name
+	channel := name + "Channel"
+
+	code.WriteString(fmt.Sprintf("\n// %s is the channel %s events are sent on\n", channel, name))
+	code.WriteString(fmt.Sprintf("const %s = %q\n\n", channel, event.ChannelName()))
+
+	code.WriteString(fmt.Sprintf("// Publish%s sends event to the subscribers of %s\n", name, channel))
+	doc := codegen.DocLines(event.Leading, event.Trailing)
+	if event.Why != "" {
+		doc = append(doc, "Why: "+event.Why)
+	}
+	if len(doc) > 0 {
+		code.WriteString("//\n")
+	}
+	for _, line := range doc {
+		code.WriteString(strings.TrimRight("// "+line, " ") + "\n")
+	}
+	code.WriteString(fmt.Sprintf("func Publish%s(ctx context.Context, broker Broker, event *%s) error {\n", name, payload))
+	code.WriteString("\tif err := event.Validate(); err != nil {\n\t\treturn err\n\t}\n")
+	code.WriteString("\tdata, err := json.Marshal(event)\n")
+	code.WriteString("\tif err != nil {\n\t\treturn err\n\t}\n")
+	code.WriteString(fmt.Sprintf("\treturn broker.Publish(ctx, %s, data)\n}\n\n", channel))
+
+	code.WriteString(fmt.Sprintf("// Subscribe%s delivers the events sent on %s until ctx is\n", name, channel))
+	code.WriteString("// done, then closes the channel. Messages that do not decode or validate\n// are dropped.\n")
+	code.WriteString(fmt.Sprintf("func Subscribe%s(ctx context.Context, broker Broker) (<-chan *%s, error) {\n", name, payload))
+	code.WriteString(fmt.Sprintf("\tmessages, err := broker.Subscribe(ctx, %s)\n", channel))
+	code.WriteString("\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+	code.WriteString(fmt.Sprintf("\tevents := make(chan *%s)\n", payload))
+	code.WriteString("\tgo func() {\n\t\tdefer close(events)\n\t\tfor data := range messages {\n")
+	code.WriteString(fmt.Sprintf("\t\t\tevent := new(%s)\n", payload))
+	code.WriteString("\t\t\tif json.Unmarshal(data, event) != nil || event.Validate() != nil {\n\t\t\t\tcontinue\n\t\t\t}\n")
+	code.WriteString("\t\t\tselect {\n\t\t\tcase events <- event:\n\t\t\tcase <-ctx.Done():\n\t\t\t\treturn\n\t\t\t}\n")
+	code.WriteString("\t\t}\n\t}()\n\treturn events, nil\n}\n")
+}
+
+// goBrokerSource declares the Broker the event functions use and
+// MemoryBroker, its in-process implementation
+const goBrokerSource = `// Broker carries events between services as JSON messages. Implement it
+// over NATS, Kafka or another message broker; MemoryBroker delivers within
+// one process, for tests and single binaries.
+type Broker interface {
+	// Publish sends data to every current subscriber of channel
+	Publish(ctx context.Context, channel string, data []byte) error
+	// Subscribe delivers the messages sent on channel until ctx is done,
+	// then closes the returned channel
+	Subscribe(ctx context.Context, channel string) (<-chan []byte, error)
+}
+
+// MemoryBroker is a Broker within one process. Publish waits until every
+// subscriber has taken the message or gone.
+type MemoryBroker struct {
+	mu          sync.Mutex
+	subscribers map[string][]*memorySubscriber
+}
+
+type memorySubscriber struct {
+	messages chan []byte
+	done     <-chan struct{}
+}
+
+// NewMemoryBroker returns a MemoryBroker without subscribers
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subscribers: make(map[string][]*memorySubscriber)}
+}
+
+// Publish implements Broker
+func (b *MemoryBroker) Publish(ctx context.Context, channel string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.subscribers[channel] {
+		select {
+		case s.messages <- data:
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Subscribe implements Broker
+func (b *MemoryBroker) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	s := &memorySubscriber{messages: make(chan []byte, 16), done: ctx.Done()}
+	b.mu.Lock()
+	b.subscribers[channel] = append(b.subscribers[channel], s)
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		subscribers := b.subscribers[channel]
+		for i, other := range subscribers {
+			if other == s {
+				b.subscribers[channel] = append(subscribers[:i:i], subscribers[i+1:]...)
+				break
+			}
+		}
+		close(s.messages)
+	}()
+	return s.messages, nil
+}
+`
@@ -0,0 +1,122 @@
+package gogen
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// goImportCandidates are the packages generated Go code may use. Every file
+// is rendered with all of them first; usedGoImports then keeps only those
+// the code refers to.
+var goImportCandidates = []string{"crypto/rand", "encoding/json", "errors", "fmt", "io", "math", "net/http", "net/mail", "net/url", "path", "regexp", "slices", "strings", "time", "unicode/utf8"}
+
+// usedGoImports returns the imports of src whose package name is referenced,
+// e.g. "time" for a time.Time field. Names bound in the file, such as a
+// parameter called path, do not count.
+func usedGoImports(src []byte) ([]string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			// Identifiers the parser could not resolve locally are packages
+			if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil {
+				referenced[ident.Name] = true
+			}
+		}
+		return true
+	})
+
+	var used []string
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		if referenced[path.Base(importPath)] {
+			used = append(used, importPath)
+		}
+	}
+	return used, nil
+}
+
+// goPackageImports are the import paths of packages, other than pkg's own,
+// for rendering with the candidates usedGoImports chooses from
+func goPackageImports(pkg string, packages map[string]string) []string {
+	seen := make(map[string]bool)
+	var imports []string
+	for _, importPath := range packages {
+		if path.Base(importPath) != pkg && !seen[importPath] {
+			seen[importPath] = true
+			imports = append(imports, importPath)
+		}
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+// qualifyGoTypes prefixes the names src uses but does not declare with the
+// package packages maps them to, e.g. User as shop.User, so records of
+// other modules resolve. Names of pkg's own package are left alone.
+func qualifyGoTypes(src []byte, pkg string, packages map[string]string) ([]byte, error) {
+	if len(packages) == 0 {
+		return src, nil
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	qualified := false
+	for _, ident := range file.Unresolved {
+		if importPath, ok := packages[ident.Name]; ok && path.Base(importPath) != pkg {
+			ident.Name = path.Base(importPath) + "." + ident.Name
+			qualified = true
+		}
+	}
+	if !qualified {
+		return src, nil
+	}
+	var out bytes.Buffer
+	if err := format.Node(&out, fset, file); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// RenderFile writes body as a formatted Go file of package pkg, importing
+// those of imports it uses. Records and models body uses but pkg does not
+// declare are qualified with the package packages maps them to, e.g. Order
+// as shop.Order, and imported, so generated code can live in a package of
+// its own. When the code is not valid Go, it is returned unformatted along
+// with the error.
+func RenderFile(pkg string, imports []string, body string, packages map[string]string) ([]byte, error) {
+	candidates := append(append([]string{}, imports...), goPackageImports(pkg, packages)...)
+	src, err := qualifyGoTypes(goFileSource(pkg, candidates, body), pkg, packages)
+	if err != nil {
+		return goFileSource(pkg, imports, body), err
+	}
+	used, err := usedGoImports(src)
+	if err != nil {
+		return src, err
+	}
+	src, err = qualifyGoTypes(goFileSource(pkg, used, body), pkg, packages)
+	if err != nil {
+		return goFileSource(pkg, used, body), err
+	}
+	formatted, err := format.Source(src)
+	if err != nil {
+		return src, err
+	}
+	return formatted, nil
+}
@@ -0,0 +1,794 @@
+// Package gogen translates checked CloudPact files into Go: records and
+// models become structs with validate tags, functions become Go functions,
+// and records and functions get the HTTP handlers their declarations ask for.
+package gogen
+
+import (
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Options tunes the generated code
+type Options struct {
+	// TrackChanges adds dirty-field tracking and Patch types to records
+	TrackChanges bool
+	// TemplateDir holds templates replacing built-in ones of the same name,
+	// e.g. record.tmpl; empty means the built-in templates only
+	TemplateDir string
+	// Header is the provenance comment the file starts with, usually
+	// codegen.Header; empty means none
+	Header string
+	// Framework is the router GenerateServer registers handlers with: chi,
+	// echo, fiber, or net/http when empty
+	Framework string
+	// Tracing instruments functions, handlers and scheduled jobs with
+	// OpenTelemetry spans and counters
+	Tracing bool
+	// Logging has functions log their calls and returns with log/slog,
+	// and GenerateServer log each request, with secrets redacted
+	Logging bool
+	// LogLevel is the lowest level the server logs: debug, info, warn or
+	// error; empty means info
+	LogLevel string
+	// Packages maps the records of other modules to the import path of
+	// their Go package, which files using them import; empty means every
+	// record is in the file's own package
+	Packages map[string]string
+}
+
+// PackageName is the Go package of the code generated for file: its
+// module name in lower case, or main when it declares no module
+func PackageName(file *grammar.File) string {
+	if file.Module == nil {
+		return "main"
+	}
+	return strings.ToLower(file.Module.Name)
+}
+
+// GenerateFile translates a checked file into the source of a Go file. When
+// the generated code is not valid Go, it is returned unformatted along with
+// the error, so it can be inspected.
+func GenerateFile(file *grammar.File, opts Options) ([]byte, error) {
+	code, _, err := generateGoFile(file, opts)
+	return code, err
+}
+
+// generateGoFile returns the Go of file with the source positions
+// SourceLines describes, which the code carries as marker lines until
+// formatted
+func generateGoFile(file *grammar.File, opts Options) ([]byte, []*grammar.Position, error) {
+	code, err := generateGoCode(file, opts)
+	code, lines := stripGoLineMarkers(code)
+	if err != nil {
+		return code, nil, err
+	}
+	return code, lines, nil
+}
+
+// generateGoCode renders and formats the Go of file, with a marker line
+// before each statement of a function body
+func generateGoCode(file *grammar.File, opts Options) ([]byte, error) {
+	tmpl, err := codegen.Load("go", opts.TemplateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Package and imports
+	data := codegen.File{Package: PackageName(file)}
+	if file.Module != nil {
+		data.Module = file.Module.Name
+	}
+
+	schedules := make(map[string]*grammar.Schedule)
+	for _, schedule := range file.Schedules {
+		schedules[schedule.Function] = schedule
+	}
+	telemetry := newGoTelemetry(data.Package, opts.Tracing)
+	for _, function := range file.Functions {
+		fn := goFunctionData(function)
+		if opts.Logging {
+			fn.Body = generateGoFunctionLogs(function) + fn.Body
+		}
+		fn.Body = telemetry.functionEntry(function) + fn.Body
+		fn.Extra = telemetry.counters(function) + generateGoFunctionHandler(function, telemetry)
+		if results := goFunctionResults(function); results.fails {
+			fn.Extra = generateGoFailureType(function) + fn.Extra
+		}
+		if schedule, ok := schedules[function.Name]; ok {
+			fn.Extra += generateGoScheduledJob(function, schedule, telemetry)
+		}
+		data.Functions = append(data.Functions, fn)
+	}
+	functions := make(map[string]*grammar.Function)
+	for _, function := range file.Functions {
+		functions[function.Name] = function
+	}
+	for _, workflow := range file.Workflows {
+		data.Extra += generateGoWorkflow(workflow, functions)
+	}
+
+	// Records (new syntax)
+	for _, record := range file.Records {
+		rec := goRecordData(record, opts.TrackChanges)
+		if opts.TrackChanges {
+			rec.Extra = generateGoChangeTracking(record)
+		}
+		if record.Versioned {
+			rec.Extra += generateGoVersioning(record)
+		}
+		// A base record of another file is not known here
+		if record.Extends == "" || record.Base != nil {
+			rec.Extra += generateGoConstructor(record)
+		}
+		rec.Extra += generateGoValidation(record)
+		rec.Extra += generateGoMarshalJSON(record)
+		if opts.Logging {
+			rec.Extra += generateGoLogValue(record)
+		}
+		data.Records = append(data.Records, rec)
+	}
+
+	// Models (legacy syntax - for backward compatibility)
+	for _, model := range file.Models {
+		m := goModelData(model)
+		m.Extra = generateGoModelPatch(model)
+		data.Models = append(data.Models, m)
+	}
+
+	// Render with every import the generator might need, then again with
+	// only those the code uses
+	data.Imports = goImportCandidates
+	if opts.Logging {
+		data.Imports = append(append([]string(nil), data.Imports...), "log/slog")
+	}
+	if opts.Tracing {
+		data.Imports = append(append([]string(nil), data.Imports...), goTelemetryImports...)
+	}
+	data.Imports = append(append([]string(nil), data.Imports...), goPackageImports(data.Package, opts.Packages)...)
+	goCode, err := renderGoFile(tmpl, data, opts.Packages)
+	if err != nil {
+		return goCode, err
+	}
+	if data.Imports, err = usedGoImports(goCode); err != nil {
+		return goCode, err
+	}
+	if goCode, err = renderGoFile(tmpl, data, opts.Packages); err != nil {
+		return goCode, err
+	}
+
+	formatted, err := format.Source(goCode)
+	if err != nil {
+		return goCode, err
+	}
+	return codegen.Stamp("//", opts.Header, formatted), nil
+}
+
+// renderGoFile renders file.tmpl with data, qualifying the records of
+// other modules with their package
+func renderGoFile(tmpl *template.Template, data codegen.File, packages map[string]string) ([]byte, error) {
+	goCode, err := codegen.Render(tmpl, "file.tmpl", data)
+	if err != nil {
+		return nil, err
+	}
+	qualified, err := qualifyGoTypes([]byte(goCode), data.Package, packages)
+	if err != nil {
+		return []byte(goCode), err
+	}
+	return qualified, nil
+}
+
+// goRecordData describes a record's Go struct for record.tmpl
+func goRecordData(record *grammar.Record, trackChanges bool) codegen.Record {
+	data := codegen.Record{Name: record.Name, Extends: record.Extends, Doc: codegen.DocLines(record.Leading, record.Trailing), TrackChanges: trackChanges, Versioned: record.Versioned, ImplicitID: record.Extends == "" && record.HasImplicitID()}
+	for _, field := range record.Fields {
+		data.Fields = append(data.Fields, codegen.Field{
+			Name:     FieldName(field.Name),
+			JSON:     field.JSONKey(),
+			Type:     FieldType(field.Type),
+			Optional: field.Type.Optional && !field.Type.Nullable, // "maybe" fields are sent as null
+			Validate: goValidateTag(field.Type),
+			Doc:      codegen.DocLines(field.Leading, field.Trailing),
+		})
+	}
+	return data
+}
+
+// goModelData describes a legacy model's Go struct for model.tmpl
+func goModelData(model *grammar.Model) codegen.Model {
+	data := codegen.Model{Name: model.Name, Doc: codegen.DocLines(model.Leading, model.Trailing)}
+	for _, field := range model.Fields {
+		data.Fields = append(data.Fields, codegen.Field{
+			Name:     FieldName(field.Name),
+			JSON:     field.JSONKey(),
+			Type:     FieldType(field.Type),
+			Optional: field.Type.Optional,
+			Doc:      codegen.DocLines(field.Leading, field.Trailing),
+		})
+	}
+	return data
+}
+
+// goFunctionData translates a CloudPact function for function.tmpl
+func goFunctionData(function *grammar.Function) codegen.Function {
+	data := codegen.Function{
+		Name:        function.Name,
+		Doc:         codegen.DocLines(function.Leading, function.Trailing),
+		Why:         function.Why,
+		Annotations: function.AIAnnotations,
+	}
+	for _, param := range function.Parameters {
+		data.Params = append(data.Params, codegen.Param{Name: param.Name, Type: FieldType(param.Type)})
+	}
+	for _, header := range codegen.RequestHeaders(function) {
+		goType := "string"
+		if header.Direction == grammar.HeaderOptional {
+			goType = "*string"
+		}
+		data.Params = append(data.Params, codegen.Param{Name: header.Variable(), Type: goType})
+	}
+	results := goFunctionResults(function)
+	data.Returns = results.signature()
+
+	// Function body - convert CloudPact statements to Go
+	if function.Body != nil {
+		data.Body = generateGoFunctionBody(function.Body, results)
+	}
+	return data
+}
+
+// goResults describes what a generated Go function returns, so return and
+// fail statements produce values matching its signature
+type goResults struct {
+	typ     string // Go type of the declared return value, "" without one
+	fails   bool   // the body contains fail, so the signature ends in error
+	failure string // the error type fail returns, see goFailureType
+}
+
+// goFunctionResults derives a function's Go results from its declaration
+func goFunctionResults(function *grammar.Function) goResults {
+	var results goResults
+	if function.ReturnType != nil {
+		results.typ = FieldType(function.ReturnType)
+	}
+	if function.Body != nil {
+		for _, stmt := range function.Body.Statements {
+			results.fails = results.fails || containsFail(stmt)
+		}
+	}
+	if results.fails {
+		results.failure = goFailureType(function)
+	}
+	return results
+}
+
+// containsFail reports whether stmt is or contains a fail statement
+func containsFail(stmt grammar.Statement) bool {
+	switch s := stmt.(type) {
+	case *grammar.FailStatement:
+		return true
+	case *grammar.IfStatement:
+		return (s.ThenStmt != nil && containsFail(s.ThenStmt)) || (s.ElseStmt != nil && containsFail(s.ElseStmt))
+	}
+	return false
+}
+
+// signature returns the results as written after a Go parameter list
+func (r goResults) signature() string {
+	switch {
+	case r.fails && r.typ != "":
+		return fmt.Sprintf("(%s, error)", r.typ)
+	case r.fails:
+		return "error"
+	}
+	return r.typ
+}
+
+// values returns the operands of a return statement: value and, when the
+// function fails, err
+func (r goResults) values(value, err string) string {
+	if value == "" && r.typ != "" {
+		value = goZeroValue(r.typ)
+	}
+	switch {
+	case !r.fails:
+		return value
+	case r.typ == "":
+		return err
+	}
+	return value + ", " + err
+}
+
+// generateGoFunctionBody converts CloudPact function body to Go code
+func generateGoFunctionBody(body *grammar.FunctionBody, results goResults) string {
+	var code strings.Builder
+
+	for _, stmt := range body.Statements {
+		code.WriteString(goStatementMarker("\t", stmt.GetPosition()))
+		switch s := stmt.(type) {
+		case *grammar.IfStatement:
+			code.WriteString(generateGoIfStatement(s, results))
+		case *grammar.ReturnStatement:
+			code.WriteString(generateGoReturnStatement(s, results))
+		case *grammar.AssignStatement:
+			code.WriteString(generateGoAssignStatement(s))
+		case *grammar.CreateStatement:
+			code.WriteString(generateGoCreateStatement(s))
+		case *grammar.FailStatement:
+			code.WriteString(generateGoFailStatement(s, results))
+		}
+	}
+
+	// A function without a return value still has to report success
+	if results.fails && results.typ == "" && len(body.NativeBlocks) == 0 && !endsInReturn(body) {
+		code.WriteString("\treturn nil\n")
+	}
+
+	// Add native Go blocks
+	for _, nativeBlock := range body.NativeBlocks {
+		if nativeBlock.Language == "go" {
+			code.WriteString("\t// Native Go code block\n")
+			code.WriteString(goStatementMarker("\t", nativeBlock.Position))
+			// Split code by lines and indent each line
+			lines := strings.Split(nativeBlock.Code, "\n")
+			for _, line := range lines {
+				if strings.TrimSpace(line) != "" {
+					code.WriteString(fmt.Sprintf("\t%s\n", line))
+				}
+			}
+		}
+	}
+
+	return code.String()
+}
+
+// endsInReturn reports whether the body's last statement leaves the function
+func endsInReturn(body *grammar.FunctionBody) bool {
+	if len(body.Statements) == 0 {
+		return false
+	}
+	switch body.Statements[len(body.Statements)-1].(type) {
+	case *grammar.ReturnStatement, *grammar.FailStatement:
+		return true
+	}
+	return false
+}
+
+// generateGoIfStatement converts CloudPact if statement to Go
+func generateGoIfStatement(stmt *grammar.IfStatement, results goResults) string {
+	var code strings.Builder
+
+	condition := generateGoExpression(stmt.Condition)
+	code.WriteString(fmt.Sprintf("\tif %s {\n", condition))
+
+	// Then body
+	if stmt.ThenStmt != nil {
+		thenCode := generateGoStatement(stmt.ThenStmt, results)
+		code.WriteString(goStatementMarker("\t\t", stmt.ThenStmt.GetPosition()))
+		code.WriteString(fmt.Sprintf("\t\t%s\n", thenCode))
+	}
+
+	code.WriteString("\t}")
+
+	// Else body
+	if stmt.ElseStmt != nil {
+		code.WriteString(" else {\n")
+		elseCode := generateGoStatement(stmt.ElseStmt, results)
+		code.WriteString(goStatementMarker("\t\t", stmt.ElseStmt.GetPosition()))
+		code.WriteString(fmt.Sprintf("\t\t%s\n", elseCode))
+		code.WriteString("\t}")
+	}
+
+	code.WriteString("\n")
+	return code.String()
+}
+
+// generateGoReturnStatement converts CloudPact return to Go
+func generateGoReturnStatement(stmt *grammar.ReturnStatement, results goResults) string {
+	// "return a if c else b" becomes an if statement with two returns
+	if conditional, ok := stmt.Value.(*grammar.ConditionalExpression); ok {
+		return fmt.Sprintf("\tif %s {\n\t\treturn %s\n\t}\n\treturn %s\n",
+			generateGoExpression(conditional.Condition),
+			results.values(goConverted(conditional.Then, results.typ), "nil"),
+			results.values(goConverted(conditional.Else, results.typ), "nil"))
+	}
+	if stmt.Value != nil {
+		value := goConverted(stmt.Value, results.typ)
+		return fmt.Sprintf("\treturn %s\n", results.values(value, "nil"))
+	}
+	if results.fails {
+		return fmt.Sprintf("\treturn %s\n", results.values("", "nil"))
+	}
+	return "\treturn\n"
+}
+
+// goConverted converts expr to Go, as goType when the analyzer resolved it
+// to a number of another Go type: an int local returned from a function of
+// numbers is a float64 to Go. Literals are untyped constants and need none.
+func goConverted(expr grammar.Expression, goType string) string {
+	value := generateGoExpression(expr)
+	var t *grammar.Type
+	switch e := expr.(type) {
+	case *grammar.IdentifierExpression:
+		t = e.Type
+	case *grammar.MemberExpression:
+		t = e.Type
+	case *grammar.DefaultExpression:
+		t = e.Type
+	case *grammar.ConditionalExpression:
+		t = e.Type
+	case *grammar.AggregateExpression:
+		t = e.Type
+	}
+	if t == nil {
+		return value
+	}
+	from := goResolvedType(t)
+	if from != goType && (from == "int" || from == "float64") && (goType == "int" || goType == "float64") {
+		return fmt.Sprintf("%s(%s)", goType, value)
+	}
+	return value
+}
+
+// generateGoAssignStatement converts CloudPact assignment to Go
+func generateGoAssignStatement(stmt *grammar.AssignStatement) string {
+	// "set x = a if c else b" becomes a declaration assigned in if/else branches
+	if conditional, ok := stmt.Value.(*grammar.ConditionalExpression); ok {
+		goType := goResolvedType(conditional.Type)
+		return fmt.Sprintf("\tvar %s %s\n\tif %s {\n\t\t%s = %s\n\t} else {\n\t\t%s = %s\n\t}\n",
+			stmt.Variable, goType,
+			generateGoExpression(conditional.Condition),
+			stmt.Variable, goConverted(conditional.Then, goType),
+			stmt.Variable, goConverted(conditional.Else, goType))
+	}
+	// "set adults = users where age >= 18" becomes a loop appending to adults
+	if query, ok := stmt.Value.(*grammar.QueryExpression); ok {
+		return generateGoQueryLoop(stmt.Variable, query)
+	}
+	value := generateGoExpression(stmt.Value)
+	return fmt.Sprintf("\t%s := %s\n", stmt.Variable, value)
+}
+
+// generateGoCreateStatement converts CloudPact create statement to Go
+func generateGoCreateStatement(stmt *grammar.CreateStatement) string {
+	var code strings.Builder
+
+	code.WriteString(fmt.Sprintf("\t%s := &%s{\n", strings.ToLower(stmt.TypeName), stmt.TypeName))
+
+	for _, assignment := range stmt.Assignments {
+		value := generateGoExpression(assignment.Value)
+		code.WriteString(fmt.Sprintf("\t\t%s: %s,\n", FieldName(assignment.Field), value))
+	}
+
+	code.WriteString("\t}\n")
+	return code.String()
+}
+
+// generateGoFailStatement converts CloudPact fail to a Go error of the
+// function's failure type, carrying the error code and HTTP status
+func generateGoFailStatement(stmt *grammar.FailStatement, results goResults) string {
+	failure := fmt.Sprintf("&%s{Code: %q, Status: %s, Message: %s}", results.failure, stmt.ErrorCode(), goFailStatus[stmt.ErrorCode()], strconv.Quote(stmt.Message))
+	return fmt.Sprintf("\treturn %s\n", results.values("", failure))
+}
+
+// generateGoStatement converts any CloudPact statement to Go
+func generateGoStatement(stmt grammar.Statement, results goResults) string {
+	switch s := stmt.(type) {
+	case *grammar.IfStatement:
+		return strings.TrimSpace(generateGoIfStatement(s, results))
+	case *grammar.ReturnStatement:
+		return strings.TrimSpace(generateGoReturnStatement(s, results))
+	case *grammar.AssignStatement:
+		return strings.TrimSpace(generateGoAssignStatement(s))
+	case *grammar.CreateStatement:
+		return strings.TrimSpace(generateGoCreateStatement(s))
+	case *grammar.FailStatement:
+		return strings.TrimSpace(generateGoFailStatement(s, results))
+	default:
+		return "// Unknown statement type"
+	}
+}
+
+// generateGoExpression converts CloudPact expressions to Go
+func generateGoExpression(expr grammar.Expression) string {
+	switch e := expr.(type) {
+	case *grammar.IdentifierExpression:
+		if e.Element {
+			return "item." + FieldName(e.Name)
+		}
+		return e.Name
+	case *grammar.LiteralExpression:
+		return goLiteral(e)
+	case *grammar.BinaryExpression:
+		if comparison, ok := generateGoSafeComparison(e); ok {
+			return comparison
+		}
+		left := generateGoExpression(e.Left)
+		right := generateGoExpression(e.Right)
+
+		// Map CloudPact operators to Go
+		switch e.Operator {
+		case "contains":
+			return fmt.Sprintf("strings.Contains(%s, %s)", left, right)
+		case "not contains":
+			return fmt.Sprintf("!strings.Contains(%s, %s)", left, right)
+		case "in":
+			return fmt.Sprintf("slices.Contains(%s, %s)", right, left)
+		case "not in":
+			return fmt.Sprintf("!slices.Contains(%s, %s)", right, left)
+		case "=", "is":
+			return fmt.Sprintf("%s == %s", left, right)
+		case "not", "is not":
+			return fmt.Sprintf("%s != %s", left, right)
+		default:
+			return fmt.Sprintf("%s %s %s", left, e.Operator, right)
+		}
+	case *grammar.MemberExpression:
+		checks := goNilChecks(e)
+		if len(checks) == 0 {
+			return goMemberPath(e)
+		}
+		// A safe chain without a fallback is a pointer, nil when it breaks
+		goType := goResolvedType(e.Type)
+		if strings.HasPrefix(goType, "*") {
+			return fmt.Sprintf("func() %s { if %s { return nil }; return %s }()",
+				goType, strings.Join(checks, " || "), goMemberPath(e))
+		}
+		return fmt.Sprintf("func() *%s { if %s { return nil }; v := %s; return &v }()",
+			goType, strings.Join(checks, " || "), goMemberPath(e))
+	case *grammar.EmptyExpression:
+		return generateGoEmptyCheck(e)
+	case *grammar.DefaultExpression:
+		return generateGoDefaultExpression(e)
+	case *grammar.ConditionalExpression:
+		return fmt.Sprintf("func() %s { if %s { return %s }; return %s }()",
+			goResolvedType(e.Type), generateGoExpression(e.Condition),
+			generateGoExpression(e.Then), generateGoExpression(e.Else))
+	case *grammar.QueryExpression:
+		elementType := goQueryElementType(e)
+		body := fmt.Sprintf("result = append(result, %s)", goQueryValue(e))
+		if e.Filter != nil {
+			body = fmt.Sprintf("if %s { %s }", generateGoExpression(e.Filter), body)
+		}
+		return fmt.Sprintf("func() []%s { var result []%s; for _, item := range %s { %s }; return result }()",
+			elementType, elementType, generateGoExpression(e.Source), body)
+	case *grammar.AggregateExpression:
+		return generateGoAggregate(e)
+	case *grammar.CallExpression:
+		var args []string
+		for _, arg := range e.Arguments {
+			args = append(args, generateGoExpression(arg))
+		}
+		return fmt.Sprintf("%s(%s)", e.Function, strings.Join(args, ", "))
+	default:
+		return "/* unknown expression */"
+	}
+}
+
+// goMemberPath renders a member chain as plain Go field access
+func goMemberPath(expr grammar.Expression) string {
+	if member, ok := expr.(*grammar.MemberExpression); ok {
+		return fmt.Sprintf("%s.%s", goMemberPath(member.Object), FieldName(member.Property))
+	}
+	return generateGoExpression(expr)
+}
+
+// goNilChecks lists the nil comparisons needed before following a safe
+// member chain; only optional fields and variables are generated as pointers
+func goNilChecks(expr grammar.Expression) []string {
+	member, ok := expr.(*grammar.MemberExpression)
+	if !ok {
+		return nil
+	}
+	checks := goNilChecks(member.Object)
+	if !member.Safe {
+		return checks
+	}
+	switch object := member.Object.(type) {
+	case *grammar.MemberExpression:
+		if object.Type != nil && object.Type.Optional {
+			checks = append(checks, goMemberPath(object)+" == nil")
+		}
+	case *grammar.IdentifierExpression:
+		if object.Type != nil && object.Type.Optional {
+			checks = append(checks, generateGoExpression(object)+" == nil")
+		}
+	}
+	return checks
+}
+
+// goSafeChain reports whether expr is a safe member chain that may break
+// before reaching a field of a non-optional type, which Go reads as a pointer
+func goSafeChain(expr grammar.Expression) bool {
+	member, ok := expr.(*grammar.MemberExpression)
+	return ok && member.Type != nil && !member.Type.Optional && len(goNilChecks(member)) > 0
+}
+
+// goComparisons maps the comparison operators of CloudPact to Go
+var goComparisons = map[string]string{"=": "==", "is": "==", "not": "!=", "is not": "!=", "<": "<", "<=": "<=", ">": ">", ">=": ">="}
+
+// generateGoSafeComparison compares a safe chain on the left with a value
+// without taking its pointer: a broken chain equals no value and is
+// neither less nor greater than one. ok is false for other comparisons.
+func generateGoSafeComparison(e *grammar.BinaryExpression) (code string, ok bool) {
+	operator, ok := goComparisons[e.Operator]
+	if !ok || !goSafeChain(e.Left) {
+		return "", false
+	}
+	if literal, isLiteral := e.Right.(*grammar.LiteralExpression); isLiteral && literal.Kind == grammar.LiteralNone {
+		return "", false
+	}
+	checks := strings.Join(goNilChecks(e.Left), " || ")
+	right := generateGoExpression(e.Right)
+	if operator == "!=" {
+		return fmt.Sprintf("(%s || %s != %s)", checks, goMemberPath(e.Left), right), true
+	}
+	return fmt.Sprintf("(!(%s) && %s %s %s)", checks, goMemberPath(e.Left), operator, right), true
+}
+
+// generateGoEmptyCheck compares text with "" and counts the entries of lists
+// and maps; optional values, generated as pointers, are also empty when nil
+func generateGoEmptyCheck(e *grammar.EmptyExpression) string {
+	value := generateGoExpression(e.Value)
+	optional := e.Type != nil && e.Type.Optional
+	if optional {
+		value = "*" + value
+	}
+
+	empty, notEmpty := value+` == ""`, value+` != ""`
+	if e.Type == nil || analyzer.KindOf(e.Type) != analyzer.KindText {
+		empty, notEmpty = fmt.Sprintf("len(%s) == 0", value), fmt.Sprintf("len(%s) > 0", value)
+	}
+	if optional {
+		pointer := strings.TrimPrefix(value, "*")
+		empty, notEmpty = fmt.Sprintf("(%s == nil || %s)", pointer, empty), fmt.Sprintf("(%s != nil && %s)", pointer, notEmpty)
+	}
+	if e.Negated {
+		return notEmpty
+	}
+	return empty
+}
+
+// generateGoDefaultExpression converts "value or fallback" into an inline nil-check
+func generateGoDefaultExpression(e *grammar.DefaultExpression) string {
+	fallback := generateGoExpression(e.Fallback)
+
+	checks := goNilChecks(e.Value)
+	value := goMemberPath(e.Value)
+	if member, ok := e.Value.(*grammar.MemberExpression); ok && member.Type != nil && member.Type.Optional {
+		checks = append(checks, value+" == nil")
+		value = "*" + value
+	}
+	if len(checks) == 0 {
+		return generateGoExpression(e.Value)
+	}
+
+	return fmt.Sprintf("func() %s { if %s { return %s }; return %s }()",
+		goResolvedType(e.Type), strings.Join(checks, " || "), fallback, value)
+}
+
+// generateGoQueryLoop converts a where/select query into a loop that appends
+// matching items to target
+func generateGoQueryLoop(target string, q *grammar.QueryExpression) string {
+	var code strings.Builder
+
+	value := goQueryValue(q)
+	code.WriteString(fmt.Sprintf("\tvar %s []%s\n", target, goQueryElementType(q)))
+	code.WriteString(fmt.Sprintf("\tfor _, item := range %s {\n", generateGoExpression(q.Source)))
+	if q.Filter != nil {
+		code.WriteString(fmt.Sprintf("\t\tif %s {\n", generateGoExpression(q.Filter)))
+		code.WriteString(fmt.Sprintf("\t\t\t%s = append(%s, %s)\n", target, target, value))
+		code.WriteString("\t\t}\n")
+	} else {
+		code.WriteString(fmt.Sprintf("\t\t%s = append(%s, %s)\n", target, target, value))
+	}
+	code.WriteString("\t}\n")
+
+	return code.String()
+}
+
+// generateGoAggregate converts count, sum and average into an inline loop
+func generateGoAggregate(e *grammar.AggregateExpression) string {
+	collection, filter, value := codegen.AggregateParts(e)
+	items := generateGoExpression(collection)
+	if e.Function == "length" {
+		return fmt.Sprintf("len([]rune(%s))", items) // characters, not bytes
+	}
+	if e.Function == "count" && filter == nil {
+		return fmt.Sprintf("len(%s)", items)
+	}
+
+	itemValue := "item"
+	if value != nil {
+		itemValue = generateGoExpression(value)
+	}
+
+	// first and last return a pointer to the item, nil when none matches
+	if e.Function == "first" || e.Function == "last" {
+		step := fmt.Sprintf("found := %s; return &found", itemValue)
+		if filter != nil {
+			step = fmt.Sprintf("if %s { %s }", generateGoExpression(filter), step)
+		}
+		loop := fmt.Sprintf("for _, item := range %s { %s }", items, step)
+		if e.Function == "last" {
+			loop = fmt.Sprintf("for i := len(%s) - 1; i >= 0; i-- { item := %s[i]; %s }", items, items, step)
+		}
+		return fmt.Sprintf("func() %s { %s; return nil }()", goResolvedType(e.Type), loop)
+	}
+
+	if e.Rounding != "" {
+		return generateGoRoundedAggregate(e, items, filter, itemValue)
+	}
+
+	var step string
+	switch e.Function {
+	case "count":
+		step = "count++"
+	case "sum":
+		step = "total += " + itemValue
+	default:
+		step = fmt.Sprintf("total += float64(%s); count++", itemValue)
+	}
+	if filter != nil {
+		step = fmt.Sprintf("if %s { %s }", generateGoExpression(filter), step)
+	}
+	loop := fmt.Sprintf("for _, item := range %s { %s }", items, step)
+
+	switch e.Function {
+	case "count":
+		return fmt.Sprintf("func() int { count := 0; %s; return count }()", loop)
+	case "sum":
+		goType := "float64"
+		if e.Type != nil {
+			goType = FieldType(e.Type)
+		}
+		return fmt.Sprintf("func() %s { var total %s; %s; return total }()", goType, goType, loop)
+	default:
+		return fmt.Sprintf("func() float64 { var total float64; count := 0; %s; if count == 0 { return 0 }; return total / float64(count) }()", loop)
+	}
+}
+
+// generateGoRoundedAggregate adds money up in whole cents, rounding each value
+// with the declared mode, so totals cannot drift and match the TypeScript output
+func generateGoRoundedAggregate(e *grammar.AggregateExpression, items string, filter grammar.Expression, itemValue string) string {
+	round := "math.Round" // half away from zero
+	if e.Rounding == grammar.RoundBanker {
+		round = "math.RoundToEven"
+	}
+
+	step := fmt.Sprintf("cents += int64(%s(%s * 100))", round, itemValue)
+	if e.Function == "average" {
+		step += "; count++"
+	}
+	if filter != nil {
+		step = fmt.Sprintf("if %s { %s }", generateGoExpression(filter), step)
+	}
+	loop := fmt.Sprintf("for _, item := range %s { %s }", items, step)
+
+	if e.Function == "sum" {
+		return fmt.Sprintf("func() float64 { var cents int64; %s; return float64(cents) / 100 }()", loop)
+	}
+	return fmt.Sprintf("func() float64 { var cents int64; count := 0; %s; if count == 0 { return 0 }; return %s(float64(cents) / float64(count)) / 100 }()", loop, round)
+}
+
+// goQueryValue returns the Go expression appended for each matching item
+func goQueryValue(q *grammar.QueryExpression) string {
+	if q.Select != nil {
+		return generateGoExpression(q.Select)
+	}
+	return "item"
+}
+
+// goQueryElementType returns the Go type of the items a query produces
+func goQueryElementType(q *grammar.QueryExpression) string {
+	if q.Type == nil {
+		return "interface{}"
+	}
+	return goResolvedType(q.Type.Element)
+}
@@ -0,0 +1,1553 @@
+package gogen
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// render executes one of the Go templates with data
+func render(t *testing.T, name string, data interface{}) string {
+	t.Helper()
+	tmpl, err := codegen.Load("go", "")
+	if err != nil {
+		t.Fatalf("load templates: %v", err)
+	}
+	code, err := codegen.Render(tmpl, name, data)
+	if err != nil {
+		t.Fatalf("render %s: %v", name, err)
+	}
+	stripped, _ := stripGoLineMarkers([]byte(code))
+	return string(stripped)
+}
+
+func TestGenerateNullSafeAccess(t *testing.T) {
+	file, err := grammar.ParseString(`define record Location
+    zip: text
+
+define record User
+    address: Location optional
+
+function zipOf(user: User, fallback: text) returns text
+    why: "Reads the zip code when an address is known"
+    do:
+        return user?.address?.zip or fallback`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	record := render(t, "record.tmpl", goRecordData(file.Records[1], false))
+	if !strings.Contains(record, "Address *Location `json:\"address,omitempty\"") {
+		t.Fatalf("expected optional field to be a pointer: %s", record)
+	}
+
+	goCode := render(t, "function.tmpl", goFunctionData(file.Functions[0]))
+	if !strings.Contains(goCode, `if user.Address == nil { return fallback }; return user.Address.Zip`) {
+		t.Fatalf("expected nil check in Go output: %s", goCode)
+	}
+}
+
+func TestGenerateNullSafeAccessWithoutFallback(t *testing.T) {
+	file, err := grammar.ParseString(`define record Location
+    zip: text
+    label: text optional
+
+define record User
+    address: Location optional
+
+function zipOf(user: User) returns maybe text
+    why: "Reads the zip code when an address is known"
+    do:
+        return user?.address?.zip
+
+function labelOf(user: User) returns maybe text
+    why: "Reads the label of a known address"
+    do:
+        return user?.address?.label
+
+function usersIn(users: list[User], zip: text) returns list[User]
+    why: "Finds the users with an address in a zip code"
+    do:
+        return users where address?.zip = zip`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"return func() *string {\n\t\tif user.Address == nil {\n\t\t\treturn nil\n\t\t}\n\t\tv := user.Address.Zip\n\t\treturn &v\n\t}()",
+		"if !(item.Address == nil) && item.Address.Zip == zip {",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, code)
+		}
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		return
+	}
+	goTestGenerated(t, code, `package main
+
+import "testing"
+
+func TestZipOf(t *testing.T) {
+	if got := zipOf(User{}); got != nil {
+		t.Errorf("zipOf without an address = %q", *got)
+	}
+	label := "home"
+	home := User{Address: &Location{Zip: "12345", Label: &label}}
+	if got := zipOf(home); got == nil || *got != "12345" {
+		t.Errorf("zipOf = %v", got)
+	}
+	if got := labelOf(home); got == nil || *got != "home" {
+		t.Errorf("labelOf = %v", got)
+	}
+	if got := usersIn([]User{{}, home}, "12345"); len(got) != 1 {
+		t.Errorf("usersIn = %v", got)
+	}
+}
+`)
+}
+
+func TestGenerateConditionalExpression(t *testing.T) {
+	file, err := grammar.ParseString(`function shippingFee(premium: boolean, fee: number, waived: number) returns number
+    why: "Premium members ship free"
+    do:
+        set total = waived if premium else fee
+        return total`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	goCode := render(t, "function.tmpl", goFunctionData(file.Functions[0]))
+	if !strings.Contains(goCode, "var total float64\n\tif premium {\n\t\ttotal = waived\n\t} else {\n\t\ttotal = fee\n\t}") {
+		t.Fatalf("expected if/else assignment in Go output: %s", goCode)
+	}
+}
+
+func TestSourceLines(t *testing.T) {
+	file, err := grammar.ParseString(`function shippingFee(premium: boolean, fee: number, waived: number) returns number
+    why: "Premium members ship free"
+    do:
+        set total = waived if premium else fee
+        return total`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	lines, err := SourceLines(file, Options{})
+	if err != nil {
+		t.Fatalf("source lines error: %v", err)
+	}
+	goLines := strings.Split(string(code), "\n")
+	if len(lines) != len(goLines)-1 {
+		t.Fatalf("expected a position per line of %d, got %d", len(goLines)-1, len(lines))
+	}
+	for i, line := range goLines[:len(lines)] {
+		var want int
+		switch line {
+		case "\tvar total float64", "\t\ttotal = waived":
+			want = 4
+		case "\treturn total":
+			want = 5
+		case "func shippingFee(premium bool, fee float64, waived float64) float64 {", "}":
+			want = 0
+		default:
+			continue
+		}
+		if got := lines[i]; (want == 0) != (got == nil) || got != nil && got.Line != want {
+			t.Errorf("line %d %q: expected source line %d, got %v", i+1, line, want, got)
+		}
+	}
+}
+
+func TestGenerateWholeNumberLocals(t *testing.T) {
+	file, err := grammar.ParseString(`function baseFee() returns number
+    why: "Every order pays a base fee"
+    do:
+        set fee = 5
+        return fee
+
+function shippingFee(big: boolean, extra: int) returns number
+    why: "Big orders ship free"
+    do:
+        set fee = 0 if big else 5
+        set total = extra if big else 2.5
+        return fee if big else total`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"\tfee := 5\n\treturn float64(fee)",
+		"var fee int\n",
+		"var total float64\n\tif big {\n\t\ttotal = float64(extra)\n\t} else {\n\t\ttotal = 2.5\n\t}",
+		"if big {\n\t\treturn float64(fee)\n\t}\n\treturn total",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, code)
+		}
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		return
+	}
+	goTestGenerated(t, code, `package main
+
+import "testing"
+
+func TestFees(t *testing.T) {
+	if got := baseFee(); got != 5 {
+		t.Errorf("baseFee() = %v", got)
+	}
+	if got := shippingFee(false, 3); got != 2.5 {
+		t.Errorf("shippingFee(false, 3) = %v", got)
+	}
+	if got := shippingFee(true, 3); got != 0 {
+		t.Errorf("shippingFee(true, 3) = %v", got)
+	}
+}
+`)
+}
+
+func TestGenerateQueryExpression(t *testing.T) {
+	file, err := grammar.ParseString(`define record User
+    age: int
+    email: email
+
+function adultEmails(users: list[User]) returns list[email]
+    why: "Collects addresses of users old enough to sign contracts"
+    do:
+        set adults = users where age >= 18
+        return adults select email`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	goCode := render(t, "function.tmpl", goFunctionData(file.Functions[0]))
+	for _, want := range []string{
+		"func adultEmails(users []User) []string {",
+		"\tvar adults []User\n\tfor _, item := range users {\n\t\tif item.Age >= 18 {\n\t\t\tadults = append(adults, item)\n\t\t}\n\t}\n",
+		"return func() []string { var result []string; for _, item := range adults { result = append(result, item.Email) }; return result }()",
+	} {
+		if !strings.Contains(goCode, want) {
+			t.Fatalf("expected %q in Go output: %s", want, goCode)
+		}
+	}
+}
+
+func TestGenerateAggregates(t *testing.T) {
+	file, err := grammar.ParseString(`define record Item
+    price: usd_currency
+    shipped: boolean
+
+function orderSummary(items: list[Item]) returns boolean
+    why: "Checks an order is worth shipping"
+    do:
+        set total = sum of items.price
+        set pending = count of items where shipped = false
+        set mean = average of items select price
+        return total > 100`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	goCode := render(t, "function.tmpl", goFunctionData(file.Functions[0]))
+	for _, want := range []string{
+		"total := func() float64 { var total float64; for _, item := range items { total += item.Price }; return total }()",
+		"pending := func() int { count := 0; for _, item := range items { if item.Shipped == false { count++ } }; return count }()",
+		"mean := func() float64 { var total float64; count := 0; for _, item := range items { total += float64(item.Price); count++ }; if count == 0 { return 0 }; return total / float64(count) }()",
+	} {
+		if !strings.Contains(goCode, want) {
+			t.Fatalf("expected %q in Go output: %s", want, goCode)
+		}
+	}
+}
+
+func TestGenerateRoundedMoneyAggregates(t *testing.T) {
+	file, err := grammar.ParseString(`define record Item
+    price: usd_currency round: banker
+
+function invoiceTotal(items: list[Item]) returns usd_currency
+    why: "Totals must match to the cent on client and server"
+    do:
+        set mean = average of items.price round: half-up
+        return sum of items.price`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	goCode := render(t, "function.tmpl", goFunctionData(file.Functions[0]))
+	for _, want := range []string{
+		"mean := func() float64 { var cents int64; count := 0; for _, item := range items { cents += int64(math.Round(item.Price * 100)); count++ }; if count == 0 { return 0 }; return math.Round(float64(cents) / float64(count)) / 100 }()",
+		"return func() float64 { var cents int64; for _, item := range items { cents += int64(math.RoundToEven(item.Price * 100)) }; return float64(cents) / 100 }()",
+	} {
+		if !strings.Contains(goCode, want) {
+			t.Fatalf("expected %q in Go output: %s", want, goCode)
+		}
+	}
+}
+
+func TestGenerateLiterals(t *testing.T) {
+	file, err := grammar.ParseString(`function label(score: number) returns text
+    why: "Labels a score"
+    do:
+        set threshold = 2.0
+        set strict = false
+        if score > threshold
+            then return "high \"risk\""
+        return "low"`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	goCode := render(t, "function.tmpl", goFunctionData(file.Functions[0]))
+	for _, want := range []string{"threshold := 2.0", "strict := false", `return "high \"risk\""`, `return "low"`} {
+		if !strings.Contains(goCode, want) {
+			t.Fatalf("expected %q in Go output: %s", want, goCode)
+		}
+	}
+}
+
+func TestGenerateFailReturnsError(t *testing.T) {
+	file, err := grammar.ParseString(`function checkTotal(total: number) returns number
+    why: "Totals must be positive"
+    do:
+        if total < 0
+            then fail "total must not be negative"
+        return total
+
+function validate(total: number)
+    why: "Rejects empty orders"
+    do:
+        if total = 0
+            then fail "empty order"
+
+function named(name: text) returns text
+    why: "Messages may quote values"
+    do:
+        if name = ""
+            then fail "name \"missing\""
+        return name`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	goCode := render(t, "function.tmpl", goFunctionData(file.Functions[0]))
+	for _, want := range []string{
+		"func checkTotal(total float64) (float64, error) {",
+		"return 0, &CheckTotalError{Code: \"invalid\", Status: http.StatusUnprocessableEntity, Message: \"total must not be negative\"}",
+		"return total, nil",
+	} {
+		if !strings.Contains(goCode, want) {
+			t.Fatalf("expected %q in Go output: %s", want, goCode)
+		}
+	}
+
+	goCode = render(t, "function.tmpl", goFunctionData(file.Functions[1]))
+	for _, want := range []string{
+		"func validate(total float64) error {",
+		"return &ValidateError{Code: \"invalid\", Status: http.StatusUnprocessableEntity, Message: \"empty order\"}",
+		"}\n\treturn nil\n}",
+	} {
+		if !strings.Contains(goCode, want) {
+			t.Fatalf("expected %q in Go output: %s", want, goCode)
+		}
+	}
+
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"type NamedError struct {\n\tCode    string\n\tStatus  int\n\tMessage string\n}",
+		"func (e *NamedError) Error() string {\n\treturn e.Message\n}",
+		`return "", &NamedError{Code: "invalid", Status: http.StatusUnprocessableEntity, Message: "name \"missing\""}`,
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in Go output: %s", want, code)
+		}
+	}
+}
+
+func TestGenerateFailHandler(t *testing.T) {
+	file, err := grammar.ParseString(`function findOrder(id: number) returns number
+    why: "Orders are looked up by number"
+    do:
+        if id < 0
+            then fail "id must not be negative"
+        if id > 1000
+            then fail not_found "no such order"
+        return id`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"return 0, &FindOrderError{Code: \"not_found\", Status: http.StatusNotFound, Message: \"no such order\"}",
+		"var failure *FindOrderError\n\t\t\tif errors.As(err, &failure) {\n\t\t\t\tstatus, code = failure.Status, failure.Code\n\t\t\t}",
+		"body := map[string]string{\"code\": code, \"message\": message}",
+		"body[\"requestId\"] = id",
+		"w.WriteHeader(status)\n\t\tjson.NewEncoder(w).Encode(body)",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, code)
+		}
+	}
+	if strings.Contains(string(code), "http.Error(") || strings.Contains(string(code), "switch err.Error()") {
+		t.Fatalf("expected every error to be answered with the Error object:\n%s", code)
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		return
+	}
+	goTestGenerated(t, code, `package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFindOrderHandler(t *testing.T) {
+	for _, tt := range []struct {
+		method, body string
+		status       int
+		code         string
+	}{
+		{http.MethodPost, `+"`"+`{"id": -1}`+"`"+`, http.StatusUnprocessableEntity, "invalid"},
+		{http.MethodPost, `+"`"+`{"id": 2000}`+"`"+`, http.StatusNotFound, "not_found"},
+		{http.MethodPost, `+"`"+`{"id":`+"`"+`, http.StatusBadRequest, "bad_request"},
+		{http.MethodGet, "", http.StatusMethodNotAllowed, "method_not_allowed"},
+	} {
+		r := httptest.NewRequest(tt.method, "/find-order", strings.NewReader(tt.body))
+		r.Header.Set("X-Request-ID", "req-1")
+		w := httptest.NewRecorder()
+		FindOrderHandler(nil)(w, r)
+		var body map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.body, err)
+		}
+		if w.Code != tt.status || body["code"] != tt.code || body["requestId"] != "req-1" || body["message"] == "" {
+			t.Errorf("%s %s: got %d %v", tt.method, tt.body, w.Code, body)
+		}
+	}
+}
+`)
+}
+
+func TestGenerateHandlerDecodesRecord(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("needs the go command")
+	}
+	file, err := grammar.ParseString(`define record User
+    name: text
+    age: number
+
+function greet(user: User) returns text
+    why: "Greets a user by name"
+    do:
+        return user.name`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+
+	// The handler must see the fields of the record sent to it
+	goTestGenerated(t, code, `package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGreetHandler(t *testing.T) {
+	body := `+"`"+`{"user": {"id": "123e4567-e89b-12d3-a456-426614174000", "name": "Ada", "age": 36}}`+"`"+`
+	w := httptest.NewRecorder()
+	GreetHandler(nil)(w, httptest.NewRequest(http.MethodPost, "/greet", strings.NewReader(body)))
+	if w.Code != http.StatusOK || w.Body.String() != "\"Ada\"\n" {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+}
+`)
+}
+
+// goTestGenerated runs test, the source of a _test.go file, against
+// generated Go code in a module of their own
+func goTestGenerated(t *testing.T, code []byte, test string) {
+	t.Helper()
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module generatedtest\n\ngo 1.22\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "generated.go"), code, 0644)
+	os.WriteFile(filepath.Join(dir, "generated_test.go"), []byte(test), 0644)
+	cmd := exec.Command("go", "test", "./...")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go test: %v\n%s\n%s", err, out, code)
+	}
+}
+
+func TestGenerateOtherModuleRecords(t *testing.T) {
+	file, err := grammar.ParseString(`module Billing
+
+define record Invoice
+    customer: Customer
+
+function isAdult(customer: Customer) returns boolean
+    why: "Only adults are invoiced"
+    do:
+        return customer.age >= 18`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	opts := Options{Packages: map[string]string{"Customer": "example.com/shop/generated/go/crm", "Invoice": "example.com/shop/generated/go/billing"}}
+	code, err := GenerateFile(file, opts)
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"\t\"example.com/shop/generated/go/crm\"\n",
+		"Customer crm.Customer `json:\"customer\"",
+		"func isAdult(customer crm.Customer) bool {\n\treturn customer.Age >= 18",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, code)
+		}
+	}
+	if strings.Contains(string(code), "generated/go/billing") || strings.Contains(string(code), "billing.Invoice") {
+		t.Fatalf("expected the file's own records to stay unqualified:\n%s", code)
+	}
+
+	tests, err := GenerateTestFile(file, opts)
+	if err != nil {
+		t.Fatalf("generate tests error: %v\n%s", err, tests)
+	}
+	if !strings.Contains(string(tests), "isAdult(crm.Customer{})") {
+		t.Fatalf("expected the test to qualify Customer:\n%s", tests)
+	}
+}
+
+func TestUsedGoImports(t *testing.T) {
+	src := `package shop
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+)
+
+type Item struct{ created time.Time }
+
+func name(path Item) string {
+	return fmt.Sprint(path.created)
+}
+`
+	used, err := usedGoImports([]byte(src))
+	if err != nil {
+		t.Fatalf("usedGoImports: %v", err)
+	}
+	// The path parameter shadows the package, so the import is unused
+	if strings.Join(used, ",") != "fmt,time" {
+		t.Fatalf("unexpected imports: %v", used)
+	}
+}
+
+func TestGenerateModelMergePatch(t *testing.T) {
+	file, err := grammar.ParseString("model Account {\n    id: text\n    owner: text\n    note: text optional\n}\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	code := generateGoModelPatch(file.Models[0])
+	for _, want := range []string{
+		"func (m *Account) MergePatch(patch []byte) error {",
+		"case \"owner\":\n\t\t\tif isNull {\n\t\t\t\treturn errors.New(\"owner is required and cannot be null\")",
+		"case \"note\":\n\t\t\tif isNull {\n\t\t\t\tpatched.Note = nil\n\t\t\t\tcontinue",
+		"case \"id\":\n\t\t\treturn errors.New(\"id cannot be changed\")",
+		"// PatchAccountHandler serves PATCH requests for an account. load returns nil when\n// no record has the id in the last path segment; save persists the result.\nfunc PatchAccountHandler(load func(id string) (*Account, error), save func(*Account) error) http.HandlerFunc {",
+		"http.StatusUnprocessableEntity",
+	} {
+		if !strings.Contains(code, want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, code)
+		}
+	}
+	if strings.Count(code, "case \"id\":") != 1 {
+		t.Fatalf("declared id field should not get its own case:\n%s", code)
+	}
+}
+
+func TestGenerateTestFile(t *testing.T) {
+	file, err := grammar.ParseString(`module Shop
+
+define record Customer
+    email: email domain "acme.com"
+    age: int min 18
+    phone: phone optional
+    joined: date
+    tags: list[text]
+    scores: map<text, int>
+
+function greet(name: text) returns text
+    why: "Greets a customer"
+    do:
+        return name
+
+function register(customer: Customer) returns Customer
+    why: "Signs up a tagged customer"
+    do:
+        if customer.tags is empty
+            then fail "a customer needs a tag"
+        return customer
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	for _, want := range []string{
+		"func (r *Customer) Validate() error {",
+		"if r.Email == \"\" {\n\t\tproblems = append(problems, errors.New(\"email is required\"))\n\t} else if _, err := mail.ParseAddress(r.Email); err != nil {",
+		"} else if !strings.HasSuffix(r.Email, \"@acme.com\") {",
+		"if r.Age < 18 {",
+		"if r.Phone != nil {\n\t\tif !customerPhonePattern.MatchString(*r.Phone) {",
+		"if r.Joined.IsZero() {",
+		"if len(r.Tags) == 0 {\n\t\tproblems = append(problems, errors.New(\"tags is required\"))",
+		"if len(r.Scores) == 0 {\n\t\tproblems = append(problems, errors.New(\"scores is required\"))",
+		"return errors.Join(problems...)",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, code)
+		}
+	}
+
+	tests, err := GenerateTestFile(file, Options{Header: "Code generated by test; DO NOT EDIT."})
+	if err != nil {
+		t.Fatalf("generate tests: %v\n%s", err, tests)
+	}
+	for _, want := range []string{
+		"// Code generated by test; DO NOT EDIT.\n\npackage shop\n\nimport (\n\t\"fmt\"\n\t\"reflect\"\n\t\"testing\"\n\t\"time\"\n)",
+		"func TestCustomerValidate(t *testing.T) {",
+		`return Customer{ID: "123e4567-e89b-12d3-a456-426614174000", Email: "user@acme.com", Age: 18, Joined: time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC), Tags: []string{""}, Scores: map[string]int{"": 0}}`,
+		`{"tags empty", func(r *Customer) { r.Tags = nil }, false},`,
+		`{"email wrong domain", func(r *Customer) { r.Email = "user@acme.com.invalid" }, false},`,
+		`{"age below min", func(r *Customer) { r.Age = 17 }, false},`,
+		`{"phone unset", func(r *Customer) { r.Phone = nil }, true},`,
+		`{"phone invalid", func(r *Customer) { r.Phone = func() *string { v := "555-0100"; return &v }() }, false},`,
+		"func TestGreet(t *testing.T) {\n\t// why: Greets a customer\n\tgot := greet(\"sample\")\n\tif again := greet(\"sample\"); !reflect.DeepEqual(got, again) {\n\t\tt.Errorf(\"greet returned %v, then %v\", got, again)\n\t}\n}",
+		"\tgot, err := register(Customer{ID: \"123e4567-e89b-12d3-a456-426614174000\", Email: \"user@acme.com\", Age: 18, Joined: time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC), Tags: []string{\"\"}, Scores: map[string]int{\"\": 0}})\n\tagain, againErr := register(Customer{ID: \"123e4567-e89b-12d3-a456-426614174000\", Email: \"user@acme.com\", Age: 18, Joined: time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC), Tags: []string{\"\"}, Scores: map[string]int{\"\": 0}})\n\tif !reflect.DeepEqual(got, again) || fmt.Sprint(err) != fmt.Sprint(againErr) {",
+		"\tif err == nil {\n\t\tif err := got.Validate(); err != nil {\n\t\t\tt.Errorf(\"register returned an invalid customer: %v\", err)\n\t\t}\n\t}",
+	} {
+		if !strings.Contains(string(tests), want) {
+			t.Fatalf("expected %q in test output:\n%s", want, tests)
+		}
+	}
+
+	// The generated tests must pass against the generated code
+	if _, err := exec.LookPath("go"); err != nil {
+		return
+	}
+	goTestGenerated(t, code, string(tests))
+}
+
+func TestGenerateMocks(t *testing.T) {
+	file, err := grammar.ParseString(`module Shop
+
+function greet(name: text, times: int) returns text
+    why: "Greets a customer"
+    do:
+        return name
+
+function reset()
+    why: "Forgets every customer"
+    do:
+        return
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	code, err := GenerateMocks(file, Options{})
+	if err != nil {
+		t.Fatalf("generate mocks: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"package shop\n\nimport (\n\t\"sync\"\n)",
+		"type MockShopResetCall struct{}",
+		"type Shop interface {\n\tGreet(name string, times int) string\n\tReset()\n}",
+		"func NewShop() Shop {\n\treturn shopFuncs{}\n}",
+		"func (shopFuncs) Greet(name string, times int) string {\n\treturn greet(name, times)\n}",
+		"func (shopFuncs) Reset() {\n\treset()\n}",
+		"type MockShop struct {\n\tGreetFunc func(name string, times int) string",
+		"type MockShopGreetCall struct {\n\tName  string\n\tTimes int\n}",
+		"m.calls.Greet = append(m.calls.Greet, MockShopGreetCall{Name: name, Times: times})",
+		"if fn == nil {\n\t\treturn \"\"\n\t}\n\treturn fn(name, times)",
+		"func (m *MockShop) GreetCalls() []MockShopGreetCall {",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in mock output:\n%s", want, code)
+		}
+	}
+
+	empty, _ := grammar.ParseString("module Shop\n\ndefine record Customer\n    name: text\n")
+	if code, err := GenerateMocks(empty, Options{}); code != nil || err != nil {
+		t.Fatalf("expected no mocks without functions, got %q, %v", code, err)
+	}
+}
+
+func TestGenerateEvents(t *testing.T) {
+	parse := func(src string) *grammar.File {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		if err := analyzer.Check(file); err != nil {
+			t.Fatalf("check error: %v", err)
+		}
+		return file
+	}
+	users := parse("module Users\n\ndefine record User\n    Name: text\n\n// Sent once a user has signed up\ndefine event UserCreated\n    payload: User\n    why: \"Lets other services greet new users\"\n")
+	renames := parse("module Users\n\ndefine record Rename\n    From: text\n\ndefine event UserRenamed\n    payload: Rename\n    channel: \"users.renamed\"\n")
+
+	packages := map[string]string{"User": "example.com/app/users", "Rename": "example.com/app/users"}
+	code, err := GenerateEvents([]*grammar.File{users, renames}, Options{Packages: packages})
+	if err != nil {
+		t.Fatalf("generate events: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"package events\n\nimport (\n\t\"context\"\n\t\"encoding/json\"\n\t\"example.com/app/users\"\n\t\"sync\"\n)",
+		"type Broker interface {",
+		"func NewMemoryBroker() *MemoryBroker {",
+		"const UserCreatedChannel = \"user.created\"",
+		"const UserRenamedChannel = \"users.renamed\"",
+		"// PublishUserCreated sends event to the subscribers of UserCreatedChannel\n//\n// Sent once a user has signed up\n// Why: Lets other services greet new users\n",
+		"func PublishUserCreated(ctx context.Context, broker Broker, event *users.User) error {\n\tif err := event.Validate(); err != nil {",
+		"return broker.Publish(ctx, UserCreatedChannel, data)",
+		"func SubscribeUserRenamed(ctx context.Context, broker Broker) (<-chan *users.Rename, error) {",
+		"event := new(users.Rename)",
+		"if json.Unmarshal(data, event) != nil || event.Validate() != nil {\n\t\t\t\tcontinue",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in events output:\n%s", want, code)
+		}
+	}
+	if strings.Count(string(code), "type Broker interface") != 1 {
+		t.Errorf("expected one Broker per package:\n%s", code)
+	}
+
+	empty := parse("module Users\n\ndefine record User\n    Name: text\n")
+	if code, err := GenerateEvents([]*grammar.File{empty}, Options{}); code != nil || err != nil {
+		t.Fatalf("expected no output without events, got %q, %v", code, err)
+	}
+}
+
+func TestGenerateQueues(t *testing.T) {
+	parse := func(src string) *grammar.File {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		if err := analyzer.Check(file); err != nil {
+			t.Fatalf("check error: %v", err)
+		}
+		return file
+	}
+	mail := parse("module Mail\n\ndefine record SendEmailTask\n    To: email\n\n// Mail leaves outside the request\nqueue emailQueue handles SendEmailTask\n    why: \"Sending mail is slow\"\n")
+	reports := parse("module Mail\n\ndefine record Report\n    Month: text\n\nqueue reportQueue handles Report\n")
+
+	code, err := GenerateQueues([]*grammar.File{mail, reports}, Options{})
+	if err != nil {
+		t.Fatalf("generate queues: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"package mail\n\nimport (\n\t\"context\"\n\t\"encoding/json\"\n\t\"sync\"\n)",
+		"type Queue interface {",
+		"func NewMemoryQueue() *MemoryQueue {",
+		"// EmailQueueName is the queue SendEmailTask tasks wait in\n//\n// Mail leaves outside the request\n// Why: Sending mail is slow\nconst EmailQueueName = \"emailQueue\"",
+		"type EmailQueueProducer interface {\n\tEnqueue(ctx context.Context, task *SendEmailTask) error\n}",
+		"type ReportQueueConsumer interface {\n\tHandle(ctx context.Context, task *Report) error\n}",
+		"func (p emailQueueProducer) Enqueue(ctx context.Context, task *SendEmailTask) error {\n\tif err := task.Validate(); err != nil {",
+		"return p.queue.Send(ctx, EmailQueueName, data)",
+		"func ConsumeReportQueue(ctx context.Context, queue Queue, consumer ReportQueueConsumer) error {",
+		"} else if consumer.Handle(ctx, task) != nil {\n\t\t\terr = delivery.Nack()",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in queues output:\n%s", want, code)
+		}
+	}
+	if strings.Count(string(code), "type Queue interface") != 1 {
+		t.Errorf("expected one Queue per package:\n%s", code)
+	}
+
+	empty := parse("module Mail\n\ndefine record Report\n    Month: text\n")
+	if code, err := GenerateQueues([]*grammar.File{empty}, Options{}); code != nil || err != nil {
+		t.Fatalf("expected no output without queues, got %q, %v", code, err)
+	}
+}
+
+func TestGeneratePolicy(t *testing.T) {
+	parse := func(src string) *grammar.File {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		if err := analyzer.Check(file); err != nil {
+			t.Fatalf("check error: %v", err)
+		}
+		return file
+	}
+	roles := parse("define role admin\n    why: \"Staff who run the shop\"\ndefine role support\n")
+	orders := parse("define record Order\n    total: money\n\nallow support to read, update Order\nallow admin to read, delete Order\n")
+	archive := parse("define record Order\n    total: money\n\nallow support to read Order\n")
+
+	code, err := GeneratePolicy([]*grammar.File{roles, orders, archive}, Options{Header: "Code generated; DO NOT EDIT."})
+	if err != nil {
+		t.Fatalf("generate policy: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"// Code generated; DO NOT EDIT.\n\n// Package policy decides",
+		"package policy\n\nimport (\n\t\"context\"\n\t\"encoding/json\"\n\t\"net/http\"\n)",
+		"\t// Why: Staff who run the shop\n\tRoleAdmin   = \"admin\"\n\tRoleSupport = \"support\"",
+		"var roles = []string{RoleAdmin, RoleSupport}",
+		"\tRoleAdmin: {\n\t\t\"Order\": {\"read\", \"delete\"},\n\t},\n\tRoleSupport: {\n\t\t\"Order\": {\"read\", \"update\"},\n\t},",
+		"func Can(actor Actor, action, resource string) bool {",
+		"func Require(action, resource string, next http.Handler) http.Handler {",
+		"func Auth(next http.Handler, roles []string) http.Handler {",
+		"body := map[string]string{\"code\": code, \"message\": message}",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in policy output:\n%s", want, code)
+		}
+	}
+
+	if code, err := GeneratePolicy([]*grammar.File{orders}, Options{}); code != nil || err != nil {
+		t.Fatalf("expected no output without roles, got %q, %v", code, err)
+	}
+}
+
+func TestGenerateServer(t *testing.T) {
+	parse := func(src string) *grammar.File {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		if err := analyzer.Check(file); err != nil {
+			t.Fatalf("check error: %v", err)
+		}
+		return file
+	}
+	shop := parse("module Shop\n\nfunction greet(name: text) returns text\n    why: \"Greets a customer\"\n    do:\n        return name\n")
+	billing := parse("module Billing\n\nfunction refund(amount: number)\n    why: \"Returns a payment\"\n    do:\n        return\n")
+
+	code, err := GenerateServer([]ServerPackage{
+		{Path: "example.com/app/generated/go/shop", Files: []*grammar.File{shop}},
+		{Path: "example.com/app/generated/go/billing", Files: []*grammar.File{billing}},
+	}, Options{})
+	if err != nil {
+		t.Fatalf("generate server: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"package main",
+		"\t\"os\"\n\n\t\"example.com/app/generated/go/billing\"\n\t\"example.com/app/generated/go/shop\"\n)",
+		"mux.Handle(\"/healthz\", http.HandlerFunc(healthz))\n\tmux.Handle(\"/readyz\", http.HandlerFunc(readyz))\n\tmux.Handle(\"/refund\", billing.RefundHandler(nil))\n\tmux.Handle(\"/greet\", shop.GreetHandler(nil))",
+		"log.Fatal(http.ListenAndServe(addr, mux))",
+		"var ready func() error",
+		"\tif ready != nil {\n\t\tif err := ready(); err != nil {\n\t\t\thttp.Error(w, err.Error(), http.StatusServiceUnavailable)",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in server output:\n%s", want, code)
+		}
+	}
+
+	// Other frameworks wrap the same handlers
+	for framework, want := range map[string][]string{
+		"chi":   {"\t\"github.com/go-chi/chi/v5\"", "r.Get(\"/healthz\", http.HandlerFunc(healthz))", "r.Post(\"/greet\", shop.GreetHandler(nil))", "http.ListenAndServe(addr, r)"},
+		"echo":  {"\t\"github.com/labstack/echo/v4\"", "e.GET(\"/readyz\", echo.WrapHandler(http.HandlerFunc(readyz)))", "e.POST(\"/greet\", echo.WrapHandler(shop.GreetHandler(nil)))", "e.Start(addr)"},
+		"fiber": {"\t\"github.com/gofiber/fiber/v2/middleware/adaptor\"", "app.Get(\"/healthz\", adaptor.HTTPHandler(http.HandlerFunc(healthz)))", "app.Post(\"/greet\", adaptor.HTTPHandler(shop.GreetHandler(nil)))", "app.Listen(addr)"},
+	} {
+		code, err := GenerateServer([]ServerPackage{{Path: "example.com/app/generated/go/shop", Files: []*grammar.File{shop}}}, Options{Framework: framework})
+		if err != nil {
+			t.Fatalf("generate %s server: %v\n%s", framework, err, code)
+		}
+		for _, want := range want {
+			if !strings.Contains(string(code), want) {
+				t.Fatalf("expected %q in %s server:\n%s", want, framework, code)
+			}
+		}
+	}
+	if _, err := GenerateServer(nil, Options{Framework: "gin"}); err == nil || !strings.Contains(err.Error(), `unknown server framework "gin"`) {
+		t.Fatalf("expected an unknown framework error, got %v", err)
+	}
+
+	// Every function gets a handler, not only those with headers
+	if goCode, _ := GenerateFile(shop, Options{}); !strings.Contains(string(goCode), "func GreetHandler(respond func(r *http.Request, header http.Header)) http.HandlerFunc {") {
+		t.Fatalf("expected a handler for greet:\n%s", goCode)
+	}
+
+	other := parse("module Store\n\nfunction greet(name: text) returns text\n    why: \"Greets a shopper\"\n    do:\n        return name\n")
+	_, err = GenerateServer([]ServerPackage{
+		{Path: "example.com/app/shop", Files: []*grammar.File{shop}},
+		{Path: "example.com/app/store", Files: []*grammar.File{other}},
+	}, Options{})
+	if err == nil || !strings.Contains(err.Error(), "both served at /greet") {
+		t.Fatalf("expected a route conflict, got %v", err)
+	}
+
+	probe := parse("module Ops\n\nfunction healthz() returns text\n    why: \"Reports the version\"\n    do:\n        return \"1\"\n")
+	_, err = GenerateServer([]ServerPackage{{Path: "example.com/app/ops", Files: []*grammar.File{probe}}}, Options{})
+	if err == nil || !strings.Contains(err.Error(), "the liveness probe and ops.Healthz are both served at /healthz") {
+		t.Fatalf("expected a conflict with the liveness probe, got %v", err)
+	}
+
+	jobs := parse(`module Jobs
+
+function purgeSessions()
+    why: "Sessions expire"
+    do:
+        return
+
+function countOrders() returns int
+    why: "Reports load"
+    do:
+        return 1
+
+schedule purgeSessions every 15 minutes
+    why: "Expired sessions are removed"
+schedule countOrders every 1 day
+    why: "Load is reported daily"
+`)
+	code, err = GenerateServer([]ServerPackage{{Path: "example.com/app/jobs", Files: []*grammar.File{jobs}}}, Options{})
+	if err != nil {
+		t.Fatalf("generate server: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"\t\"os\"\n\t\"time\"\n",
+		"\tgo every(15*time.Minute, \"purgeSessions\", jobs.PurgeSessionsJob)\n\tgo every(1*24*time.Hour, \"countOrders\", jobs.CountOrdersJob)\n",
+		"func every(interval time.Duration, name string, job func() error) {",
+		"log.Printf(\"schedule %s failed: %v\", name, err)",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in server output:\n%s", want, code)
+		}
+	}
+	if code, _ := GenerateServer([]ServerPackage{{Path: "example.com/app/shop", Files: []*grammar.File{shop}}}, Options{}); strings.Contains(string(code), "time") {
+		t.Fatalf("expected no scheduler without schedules:\n%s", code)
+	}
+	goCode, err := GenerateFile(jobs, Options{})
+	if err != nil {
+		t.Fatalf("generate file: %v\n%s", err, goCode)
+	}
+	for _, want := range []string{
+		"// PurgeSessionsJob runs purgeSessions for its schedule, every 15 minutes\nfunc PurgeSessionsJob() error {\n\tpurgeSessions()\n\treturn nil\n}",
+		"func CountOrdersJob() error {\n\tcountOrders()\n\treturn nil\n}",
+	} {
+		if !strings.Contains(string(goCode), want) {
+			t.Fatalf("expected %q in:\n%s", want, goCode)
+		}
+	}
+}
+
+func TestGenerateWorkflow(t *testing.T) {
+	src := `module Users
+
+define record Account
+    email: email
+
+function createAccount(account: Account) returns Account
+    why: "c"
+    do:
+        if account.email is ""
+            then fail "email is required"
+        return account
+
+function sendWelcome(account: Account)
+    why: "s"
+    do:
+        if account.email is "x@example.com"
+            then fail "mail bounced"
+        return
+
+function deleteAccount(account: Account)
+    why: "d"
+    do:
+        return
+
+function audit() returns int
+    why: "a"
+    do:
+        return 1
+
+// Signs a user up
+workflow onboardUser: step createAccount then step sendWelcome on failure compensate deleteAccount then step audit
+    why: "New users get an account and a greeting"
+`
+	file, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"// OnboardUser runs the workflow onboardUser: createAccount, then sendWelcome, then audit.\n// A failure from sendWelcome on is compensated by deleteAccount.\n",
+		"//\n// Signs a user up\n// Why: New users get an account and a greeting\nfunc OnboardUser(account Account) (int, error) {",
+		"\tresult1, err1 := createAccount(account)\n\tif err1 != nil {\n\t\treturn 0, err1\n\t}",
+		"\tif err := sendWelcome(result1); err != nil {\n\t\treturn 0, errors.Join(err, func() error {\n\t\t\tdeleteAccount(result1)\n\t\t\treturn nil\n\t\t}())\n\t}",
+		"\tresult3 := audit()\n\treturn result3, nil\n}",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in:\n%s", want, code)
+		}
+	}
+}
+
+func TestGenerateTracing(t *testing.T) {
+	file, err := grammar.ParseString(`module Shop
+
+function purgeCarts() returns number
+    why: "Abandoned carts are removed"
+    do:
+        if 1 > 2
+            then fail "nothing to purge"
+        return 1
+
+schedule purgeCarts every 1 hour
+    why: "Carts expire"
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	code, err := GenerateFile(file, Options{Tracing: true})
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"\t\"go.opentelemetry.io/otel/trace\"\n",
+		"func purgeCarts() (float64, error) {\n\tpurgeCartsCalls.Add(context.Background(), 1, metric.WithAttributes(attribute.String(\"cloudpact.function\", \"purgeCarts\")))",
+		"var purgeCartsCalls, _ = otel.Meter(\"cloudpact/shop\").Int64Counter(\"cloudpact.function.calls\"",
+		"var purgeCartsRequests, _ = otel.Meter(\"cloudpact/shop\").Int64Counter(\"cloudpact.http.requests\"",
+		"\t\tctx, span := otel.Tracer(\"cloudpact/shop\").Start(r.Context(), \"purgeCarts\", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attribute.String(\"cloudpact.why\", \"Abandoned carts are removed\")))\n\t\tdefer span.End()\n\t\tr = r.WithContext(ctx)\n\t\tpurgeCartsRequests.Add(ctx, 1,",
+		"\t\tif err != nil {\n\t\t\tspan.RecordError(err)\n\t\t\tspan.SetStatus(codes.Error, err.Error())\n\t\t\tstatus, code :=",
+		"func PurgeCartsJob() error {\n\t_, span := otel.Tracer(\"cloudpact/shop\").Start(context.Background(), \"purgeCarts\", trace.WithAttributes(attribute.String(\"cloudpact.why\", \"Abandoned carts are removed\")))\n\tdefer span.End()\n\t_, err := purgeCarts()\n\tif err != nil {\n\t\tspan.RecordError(err)",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, code)
+		}
+	}
+
+	code, err = GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+	if strings.Contains(string(code), "otel") {
+		t.Fatalf("expected no instrumentation without tracing:\n%s", code)
+	}
+}
+
+func TestGenerateLogging(t *testing.T) {
+	file, err := grammar.ParseString(`module Accounts
+
+define record Account
+    email: email
+    password: password
+    nickname: text optional
+
+function login(email: email, password: password) returns number
+    why: "Users sign in"
+    do:
+        return 1
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	code, err := GenerateFile(file, Options{Logging: true})
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"\t\"log/slog\"\n",
+		"func login(email string, password string) float64 {\n\tslog.Debug(\"function called\", \"function\", \"login\", \"email\", email, \"password\", \"[REDACTED]\")\n\tdefer func(start time.Time) {\n\t\tslog.Debug(\"function returned\", \"function\", \"login\", \"duration\", time.Since(start))\n\t}(time.Now())",
+		"func (r Account) LogValue() slog.Value {\n\tattrs := []slog.Attr{slog.String(\"id\", r.ID), slog.Any(\"email\", r.Email), slog.String(\"password\", \"[REDACTED]\")}\n\tif r.Nickname != nil {\n\t\tattrs = append(attrs, slog.Any(\"nickname\", *r.Nickname))",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, code)
+		}
+	}
+
+	server, err := GenerateServer([]ServerPackage{{Path: "example.com/app/accounts", Files: []*grammar.File{file}}}, Options{Framework: "chi", Logging: true, LogLevel: "warn"})
+	if err != nil {
+		t.Fatalf("generate server: %v\n%s", err, server)
+	}
+	for _, want := range []string{
+		"\t\"log/slog\"\n",
+		"\tslog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelWarn})))\n\tr := chi.NewRouter()",
+		"r.Post(\"/login\", logRequests(\"login\", accounts.LoginHandler(nil)))",
+		"func logRequests(function string, next http.Handler) http.HandlerFunc {",
+		"func (s *statusRecorder) WriteHeader(status int) {",
+	} {
+		if !strings.Contains(string(server), want) {
+			t.Fatalf("expected %q in server output:\n%s", want, server)
+		}
+	}
+
+	code, err = GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+	if strings.Contains(string(code), "slog") {
+		t.Fatalf("expected no logging without it:\n%s", code)
+	}
+}
+
+func TestGenerateFunctionAccess(t *testing.T) {
+	file, err := grammar.ParseString(`module Shop
+
+function deleteOrder(id: uuid)
+    requires role admin
+    requires role support
+    why: "Only staff remove orders"
+    do:
+        return
+
+function listOrders() returns number
+    requires auth
+    why: "Customers see their orders"
+    do:
+        return 1
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"func DeleteOrderHandler(auth func(next http.Handler, roles []string) http.Handler, respond func(r *http.Request, header http.Header)) http.HandlerFunc {\n\twriteError := func(w http.ResponseWriter, r *http.Request, status int, code, message string) {",
+		"\thandler := func(w http.ResponseWriter, r *http.Request) {",
+		"\tif auth == nil {\n\t\treturn func(w http.ResponseWriter, r *http.Request) {\n\t\t\twriteError(w, r, http.StatusUnauthorized, \"unauthorized\", \"authentication required\")",
+		"\treturn auth(http.HandlerFunc(handler), []string{\"admin\", \"support\"}).ServeHTTP",
+		"\treturn auth(http.HandlerFunc(handler), nil).ServeHTTP",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, code)
+		}
+	}
+
+	server, err := GenerateServer([]ServerPackage{{Path: "example.com/app/shop", Files: []*grammar.File{file}}}, Options{Framework: "echo"})
+	if err != nil {
+		t.Fatalf("generate server: %v\n%s", err, server)
+	}
+	for _, want := range []string{
+		"\t\"net/http\"\n",
+		"var auth func(next http.Handler, roles []string) http.Handler",
+		"e.POST(\"/deleteorder\", echo.WrapHandler(shop.DeleteOrderHandler(auth, nil)))",
+	} {
+		if !strings.Contains(string(server), want) {
+			t.Fatalf("expected %q in server output:\n%s", want, server)
+		}
+	}
+}
+
+func TestGenerateConstructor(t *testing.T) {
+	file, err := grammar.ParseString(`module Shop
+
+define record Party
+    name: text
+    createdAt: datetime
+
+define record Customer extends Party
+    status: text default "active"
+    nickname: text optional
+    updated_at: timestamp
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"// NewParty returns a new party with a random UUID as its ID, its fields set to\n// the given values and their declared defaults, and its creation time set to\n// now. It returns an error when the result does not pass Validate.\nfunc NewParty(name string) (*Party, error) {",
+		"func NewCustomer(name string) (*Customer, error) {",
+		"if _, err := rand.Read(newID[:]); err != nil {",
+		"\tr := &Customer{\n\t\tParty: Party{\n\t\t\tID:        fmt.Sprintf(\"%x-%x-%x-%x-%x\", newID[0:4], newID[4:6], newID[6:8], newID[8:10], newID[10:]),\n\t\t\tName:      name,\n\t\t\tCreatedAt: time.Now(),\n\t\t},\n\t\tStatus:     \"active\",\n\t\tUpdated_at: time.Now(),\n\t}",
+		"\tif err := r.Validate(); err != nil {\n\t\treturn nil, err\n\t}\n\treturn r, nil",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, code)
+		}
+	}
+
+	tests, err := GenerateTestFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate tests: %v\n%s", err, tests)
+	}
+	if !strings.Contains(string(tests), "func TestNewCustomer(t *testing.T) {\n\tfirst, err := NewCustomer(\"sample\")") {
+		t.Fatalf("expected a constructor test:\n%s", tests)
+	}
+}
+
+func TestGenerateRecordIdentity(t *testing.T) {
+	file, err := grammar.ParseString(`module Shop
+
+define record Login
+    id: uuid
+    name: text
+
+define record Money no id
+    amount: number
+
+define record Country versioned
+    code: text key
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate: %v\n%s", err, code)
+	}
+	src := string(code)
+	for _, want := range []string{
+		"type Login struct {\n\tId   string `json:\"id\" validate:\"required,uuid\"`\n\tName string",
+		"func NewLogin(name string) (*Login, error) {",
+		"\tr := &Login{\n\t\tId:   fmt.Sprintf(",
+		"type Money struct {\n\tAmount float64",
+		"// NewMoney returns a new money with its fields set to the given values and\n// their declared defaults. It returns an error when the result does not pass\n// Validate.\nfunc NewMoney(amount float64) (*Money, error) {\n\tr := &Money{",
+		"func NewCountry(code string) (*Country, error) {\n\tr := &Country{",
+		"\t\tupdated.Code = record.Code\n",
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, src)
+		}
+	}
+	if strings.Contains(src, "\tID string") || strings.Contains(src, "r.ID") {
+		t.Fatalf("expected no implicit ID:\n%s", src)
+	}
+
+	tests, err := GenerateTestFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate tests: %v\n%s", err, tests)
+	}
+	for _, want := range []string{
+		"if first.Id == second.Id {",
+		"func TestNewMoney(t *testing.T) {\n\tif _, err := NewMoney(1); err != nil {",
+	} {
+		if !strings.Contains(string(tests), want) {
+			t.Fatalf("expected %q in test output:\n%s", want, tests)
+		}
+	}
+}
+
+func TestGenerateTestFileExample(t *testing.T) {
+	file, err := grammar.ParseString(`define record Booking
+    guest: text
+    nights: int min 1
+    arrives: datetime
+    hold: duration
+    note: text optional
+    example:
+        guest: "Ada"
+        nights: 3
+        arrives: "2024-03-04T15:30:00Z"
+        hold: "90m"
+        note: "late"
+
+define record Suite extends Booking
+    floor: int
+    example:
+        guest: "Grace"
+        floor: 12
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	tests, err := GenerateTestFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate tests: %v\n%s", err, tests)
+	}
+	for _, want := range []string{
+		`first, err := NewBooking("Ada", 3, time.Date(2024, time.March, 4, 15, 30, 0, 0, time.UTC), 90*time.Minute)`,
+		`return Booking{ID: "123e4567-e89b-12d3-a456-426614174000", Guest: "Ada", Nights: 3, Arrives: time.Date(2024, time.March, 4, 15, 30, 0, 0, time.UTC), Hold: 90 * time.Minute, Note: func() *string { v := "late"; return &v }()}`,
+		`return Suite{Booking: Booking{ID: "123e4567-e89b-12d3-a456-426614174000", Guest: "Grace", Nights: 3,`,
+		`}, Floor: 12}`,
+	} {
+		if !strings.Contains(string(tests), want) {
+			t.Fatalf("expected %q in test output:\n%s", want, tests)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	src := `package shop
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Status is where an order is
+type Status string
+
+const (
+	StatusOpen   Status = "open"
+	StatusClosed Status = "closed"
+)
+
+// Base holds the fields every stored value has
+type Base struct {
+	ID        string    ` + "`json:\"id\"`" + `
+	CreatedAt time.Time ` + "`json:\"createdAt\"`" + `
+}
+
+// Customer is a buyer of the shop
+type Customer struct {
+	Base
+	Name      string         ` + "`json:\"name\" validate:\"required,min=2,max=80\"`" + `
+	WorkEmail string         ` + "`json:\"workEmail\"`" + `
+	HomePage  *string        ` + "`json:\"homePage,omitempty\"`" + `
+	Nickname  *string
+	BirthDate time.Time      ` + "`json:\"birthDate\"`" + `
+	Phone     sql.NullString ` + "`json:\"phone\"`" + `
+	Address   struct {
+		Street string ` + "`json:\"street\"`" + `
+	} ` + "`json:\"address\"`" + `
+	secret string
+	Skip   string ` + "`json:\"-\"`" + `
+	Module string ` + "`json:\"module\"`" + `
+}
+
+type Order struct {
+	Customer Customer       ` + "`json:\"customer\"`" + `
+	Status   Status         ` + "`json:\"status\"`" + `
+	Lines    map[string]int ` + "`json:\"lines\"`" + `
+	Updates  chan int
+	Children []*Order       ` + "`json:\"children\"`" + `
+}
+
+type NewOrder struct {
+	Lines map[string]int ` + "`json:\"lines\"`" + `
+}
+`
+	file, err := parser.ParseFile(token.NewFileSet(), "shop.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	out, err := Import([]*ast.File{file}, "")
+	if err != nil {
+		t.Fatalf("Import error: %v", err)
+	}
+	for _, want := range []string{
+		"module Shop\n",
+		"// Base holds the fields every stored value has\ndefine record Base\n    id: text\n    createdAt: datetime\n",
+		"// A buyer of the shop\ndefine record Customer extends Base\n",
+		"    name: text minlength 2 maxlength 80\n",
+		"    workEmail: email\n",
+		"    homePage: url optional\n",
+		"    nickname: maybe text\n",
+		"    birthDate: date\n",
+		"    phone: maybe phone\n",
+		"    address: CustomerAddress\n",
+		"define record CustomerAddress no id\n    street: text\n",
+		"    moduleValue: text\n",
+		"define record Order no id\n    customer: Customer\n",
+		"    // one of: open, closed\n    // Go type Status\n    status: text\n",
+		"    lines: map of text to int\n",
+		"    children: list of Order\n",
+		"define record NewOrderInput no id\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in imported source:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"secret", "skip", "updates"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("unexpected %s in imported source:\n%s", unwanted, out)
+		}
+	}
+
+	f, err := grammar.ParseString(out)
+	if err != nil {
+		t.Fatalf("imported source does not parse: %v\n%s", err, out)
+	}
+	if err := analyzer.Check(f); err != nil {
+		t.Fatalf("imported source does not check: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateSecrets(t *testing.T) {
+	file, err := grammar.ParseString(`define record Account
+    email: email
+    password: password
+    nickname: text optional
+
+define record Admin extends Account
+    apiKey: api_key
+    level: int
+
+define record Note
+    body: text
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate error: %v\n%s", err, code)
+	}
+	for _, want := range []string{
+		"// MarshalJSON encodes Account without its secrets, which are only read\n// from requests: password\nfunc (r Account) MarshalJSON() ([]byte, error) {\n\treturn json.Marshal(struct {\n\t\tID       string  `json:\"id\"`\n\t\tEmail    string  `json:\"email\"`\n\t\tNickname *string `json:\"nickname,omitempty\"`\n\t}{r.ID, r.Email, r.Nickname})\n}",
+		// The base's method would be promoted and drop Level
+		"func (r Admin) MarshalJSON() ([]byte, error) {\n\treturn json.Marshal(struct {\n\t\tID       string  `json:\"id\"`\n\t\tEmail    string  `json:\"email\"`\n\t\tNickname *string `json:\"nickname,omitempty\"`\n\t\tLevel    int     `json:\"level\"`\n\t}{r.ID, r.Email, r.Nickname, r.Level})\n}",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, code)
+		}
+	}
+	if strings.Contains(string(code), "func (r Note) MarshalJSON") {
+		t.Fatalf("records without secrets should use the default encoding:\n%s", code)
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		return
+	}
+	goTestGenerated(t, code, `package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	nickname := "ada"
+	admin := Admin{Account: Account{ID: "1", Email: "ada@example.com", Password: "hunter2", Nickname: &nickname}, ApiKey: "key", Level: 3}
+	data, err := json.Marshal(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	json.Unmarshal(data, &got)
+	for _, key := range []string{"id", "email", "nickname", "level"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("expected %s in %s", key, data)
+		}
+	}
+	for _, key := range []string{"password", "apiKey"} {
+		if _, ok := got[key]; ok {
+			t.Errorf("expected no %s in %s", key, data)
+		}
+	}
+}
+`)
+}
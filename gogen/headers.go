@@ -0,0 +1,209 @@
+package gogen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// generateGoFunctionHandler serves a function at POST /<name>: its
+// parameters arrive as a JSON object, any request headers it declares are
+// passed after them, and respond may set the emitted headers. A function
+// with requires clauses is served through the auth middleware.
+func generateGoFunctionHandler(function *grammar.Function, telemetry goTelemetry) string {
+	var code strings.Builder
+	name := strings.ToUpper(function.Name[:1]) + function.Name[1:]
+	access := function.Access
+
+	code.WriteString(fmt.Sprintf("// %sHandler serves %s at POST /%s.", name, function.Name, strings.ToLower(function.Name)))
+	if access != nil {
+		code.WriteString("\n// auth checks the caller: it wraps the handler, given the roles the\n")
+		code.WriteString("// function requires, any of which admits a caller, and answers 401 or\n")
+		code.WriteString("// 403 itself. A nil auth answers every request with 401.\n")
+		code.WriteString("//")
+	}
+	if emitted := codegen.EmittedHeaderNames(function); len(emitted) > 0 {
+		code.WriteString(fmt.Sprintf(" respond sets the response\n// headers %s; it may be nil.\n", strings.Join(emitted, ", ")))
+	} else {
+		code.WriteString(" respond may add response\n// headers; it may be nil.\n")
+	}
+	if access != nil {
+		code.WriteString(fmt.Sprintf("func %sHandler(auth func(next http.Handler, roles []string) http.Handler, respond func(r *http.Request, header http.Header)) http.HandlerFunc {\n", name))
+		writeGoErrorWriter(&code)
+		code.WriteString("\thandler := func(w http.ResponseWriter, r *http.Request) {\n")
+	} else {
+		code.WriteString(fmt.Sprintf("func %sHandler(respond func(r *http.Request, header http.Header)) http.HandlerFunc {\n", name))
+		writeGoErrorWriter(&code)
+		code.WriteString("\treturn func(w http.ResponseWriter, r *http.Request) {\n")
+	}
+	code.WriteString(telemetry.handlerEntry(function))
+	code.WriteString("\t\tif r.Method != http.MethodPost {\n")
+	code.WriteString(goErrorReply("\t\t\t", "http.StatusMethodNotAllowed", "method_not_allowed", `"method not allowed"`))
+	code.WriteString("\t\t}\n")
+
+	var args []string
+	for _, header := range codegen.RequestHeaders(function) {
+		variable := header.Variable()
+		if header.Direction == grammar.HeaderRequired {
+			code.WriteString(fmt.Sprintf("\t\t%s := r.Header.Get(%q)\n", variable, header.Name))
+			code.WriteString(fmt.Sprintf("\t\tif %s == \"\" {\n", variable))
+			code.WriteString(goErrorReply("\t\t\t", goFailStatus[grammar.FailBadRequest], grammar.FailBadRequest, strconv.Quote(header.Name+" header required")))
+			code.WriteString("\t\t}\n")
+		} else {
+			code.WriteString(fmt.Sprintf("\t\tvar %s *string\n", variable))
+			code.WriteString(fmt.Sprintf("\t\tif value := r.Header.Get(%q); value != \"\" {\n", header.Name))
+			code.WriteString(fmt.Sprintf("\t\t\t%s = &value\n", variable))
+			code.WriteString("\t\t}\n")
+		}
+		args = append(args, variable)
+	}
+
+	if len(function.Parameters) > 0 {
+		code.WriteString("\t\tvar params struct {\n")
+		var params []string
+		for _, param := range function.Parameters {
+			field := strings.ToUpper(param.Name[:1]) + param.Name[1:]
+			code.WriteString(fmt.Sprintf("\t\t\t%s %s `json:\"%s\"`\n", field, FieldType(param.Type), param.Name))
+			params = append(params, "params."+field)
+		}
+		code.WriteString("\t\t}\n")
+		code.WriteString("\t\tif err := json.NewDecoder(r.Body).Decode(&params); err != nil {\n")
+		code.WriteString(goErrorReply("\t\t\t", goFailStatus[grammar.FailBadRequest], grammar.FailBadRequest, "err.Error()"))
+		code.WriteString("\t\t}\n")
+		args = append(params, args...)
+	}
+
+	// fail answers with the status of its error code, 422 by default
+	call := fmt.Sprintf("%s(%s)", function.Name, strings.Join(args, ", "))
+	results := goFunctionResults(function)
+	switch {
+	case results.fails && results.typ != "":
+		code.WriteString(fmt.Sprintf("\t\tresult, err := %s\n", call))
+		writeGoFailure(&code, function, telemetry)
+	case results.fails:
+		code.WriteString(fmt.Sprintf("\t\terr := %s\n", call))
+		writeGoFailure(&code, function, telemetry)
+	case results.typ != "":
+		code.WriteString(fmt.Sprintf("\t\tresult := %s\n", call))
+	default:
+		code.WriteString(fmt.Sprintf("\t\t%s\n", call))
+	}
+	code.WriteString("\t\tif respond != nil {\n")
+	code.WriteString("\t\t\trespond(r, w.Header())\n")
+	code.WriteString("\t\t}\n")
+	if results.typ != "" {
+		code.WriteString("\t\tw.Header().Set(\"Content-Type\", \"application/json\")\n")
+		code.WriteString("\t\tjson.NewEncoder(w).Encode(result)\n")
+	} else {
+		code.WriteString("\t\tw.WriteHeader(http.StatusNoContent)\n")
+	}
+	code.WriteString("\t}\n")
+	if access != nil {
+		roles := "nil"
+		if len(access.Roles) > 0 {
+			quoted := make([]string, len(access.Roles))
+			for i, role := range access.Roles {
+				quoted[i] = fmt.Sprintf("%q", role)
+			}
+			roles = "[]string{" + strings.Join(quoted, ", ") + "}"
+		}
+		code.WriteString("\tif auth == nil {\n")
+		code.WriteString("\t\treturn func(w http.ResponseWriter, r *http.Request) {\n")
+		code.WriteString(fmt.Sprintf("\t\t\twriteError(w, r, %s, %q, \"authentication required\")\n", goFailStatus[grammar.FailUnauthorized], grammar.FailUnauthorized))
+		code.WriteString("\t\t}\n")
+		code.WriteString("\t}\n")
+		code.WriteString(fmt.Sprintf("\treturn auth(http.HandlerFunc(handler), %s).ServeHTTP\n", roles))
+	}
+	code.WriteString("}\n\n")
+
+	return code.String()
+}
+
+// goFailStatus names the net/http status of each fail error code
+var goFailStatus = map[string]string{
+	grammar.FailBadRequest:   "http.StatusBadRequest",
+	grammar.FailUnauthorized: "http.StatusUnauthorized",
+	grammar.FailForbidden:    "http.StatusForbidden",
+	grammar.FailNotFound:     "http.StatusNotFound",
+	grammar.FailConflict:     "http.StatusConflict",
+	grammar.FailInvalid:      "http.StatusUnprocessableEntity",
+	grammar.FailInternal:     "http.StatusInternalServerError",
+}
+
+// writeGoErrorWriter emits writeError, with which a handler answers with
+// the Error object of the OpenAPI spec: the error code, the message and the
+// X-Request-ID of the request when it carried one
+func writeGoErrorWriter(code *strings.Builder) {
+	code.WriteString("\twriteError := func(w http.ResponseWriter, r *http.Request, status int, code, message string) {\n")
+	code.WriteString("\t\tbody := map[string]string{\"code\": code, \"message\": message}\n")
+	code.WriteString("\t\tif id := r.Header.Get(\"X-Request-ID\"); id != \"\" {\n")
+	code.WriteString("\t\t\tbody[\"requestId\"] = id\n")
+	code.WriteString("\t\t}\n")
+	code.WriteString("\t\tw.Header().Set(\"Content-Type\", \"application/json\")\n")
+	code.WriteString("\t\tw.WriteHeader(status)\n")
+	code.WriteString("\t\tjson.NewEncoder(w).Encode(body)\n")
+	code.WriteString("\t}\n")
+}
+
+// goErrorReply is the handler code answering with status, a net/http
+// constant, the error code and message, a Go string expression, then
+// returning
+func goErrorReply(indent, status, code, message string) string {
+	return fmt.Sprintf("%swriteError(w, r, %s, %q, %s)\n%sreturn\n", indent, status, code, message, indent)
+}
+
+// writeGoRecordLoad emits the handler code loading record by the id in the
+// last path segment
+func writeGoRecordLoad(code *strings.Builder) {
+	code.WriteString("\t\trecord, err := load(path.Base(r.URL.Path))\n")
+	code.WriteString("\t\tif err != nil {\n")
+	code.WriteString(goErrorReply("\t\t\t", goFailStatus[grammar.FailInternal], grammar.FailInternal, "err.Error()"))
+	code.WriteString("\t\t}\n")
+	code.WriteString("\t\tif record == nil {\n")
+	code.WriteString(goErrorReply("\t\t\t", goFailStatus[grammar.FailNotFound], grammar.FailNotFound, `"not found"`))
+	code.WriteString("\t\t}\n")
+}
+
+// goFailureType is the name of the error type a function's fail
+// statements return, such as FindOrderError for findOrder
+func goFailureType(function *grammar.Function) string {
+	return strings.ToUpper(function.Name[:1]) + function.Name[1:] + "Error"
+}
+
+// generateGoFailureType declares the error type of a function that fails,
+// which carries the error code and HTTP status of each fail statement to
+// its handler
+func generateGoFailureType(function *grammar.Function) string {
+	var code strings.Builder
+	name := goFailureType(function)
+	code.WriteString(fmt.Sprintf("// %s is the error %s fails with: the message of a fail\n", name, function.Name))
+	code.WriteString("// statement, its error code and the HTTP status its handler answers with\n")
+	code.WriteString(fmt.Sprintf("type %s struct {\n", name))
+	code.WriteString("\tCode    string\n")
+	code.WriteString("\tStatus  int\n")
+	code.WriteString("\tMessage string\n")
+	code.WriteString("}\n\n")
+	code.WriteString(fmt.Sprintf("func (e *%s) Error() string {\n", name))
+	code.WriteString("\treturn e.Message\n")
+	code.WriteString("}\n\n")
+	return code.String()
+}
+
+// writeGoFailure emits the handler code answering a failed call with the
+// Error object of the OpenAPI spec, carrying the code and status of the
+// fail statement. Any other error, from native code, is internal.
+func writeGoFailure(code *strings.Builder, function *grammar.Function, telemetry goTelemetry) {
+	code.WriteString("\t\tif err != nil {\n")
+	code.WriteString(telemetry.spanError("\t\t\t"))
+	code.WriteString(fmt.Sprintf("\t\t\tstatus, code := %s, %q\n", goFailStatus[grammar.FailInternal], grammar.FailInternal))
+	code.WriteString(fmt.Sprintf("\t\t\tvar failure *%s\n", goFailureType(function)))
+	code.WriteString("\t\t\tif errors.As(err, &failure) {\n")
+	code.WriteString("\t\t\t\tstatus, code = failure.Status, failure.Code\n")
+	code.WriteString("\t\t\t}\n")
+	code.WriteString("\t\t\twriteError(w, r, status, code, err.Error())\n")
+	code.WriteString("\t\t\treturn\n")
+	code.WriteString("\t\t}\n")
+}
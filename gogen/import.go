@@ -0,0 +1,571 @@
+package gogen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// importReserved start declarations, so record fields cannot take them as
+// names
+var importReserved = map[string]bool{"module": true, "define": true, "function": true, "model": true, "test": true}
+
+// importNameTypes are the semantic types inferred from the name of a text
+// field, by the name lowered without separators or by its last word
+var importNameTypes = []struct {
+	names  []string
+	suffix string
+	cpType string
+}{
+	{[]string{"email"}, "email", "email"},
+	{[]string{"url", "uri", "website", "homepage", "link"}, "url", "url"},
+	{[]string{"phone", "mobile", "phonenumber"}, "phone", "phone"},
+	{[]string{"password"}, "password", "password"},
+	{[]string{"zip", "zipcode", "postcode", "postalcode"}, "zipcode", "zip_code"},
+	{[]string{"countrycode"}, "countrycode", "country_code"},
+	{[]string{"statecode"}, "statecode", "state_code"},
+	{[]string{"apikey"}, "apikey", "api_key"},
+	{[]string{"token"}, "token", "token"},
+	{[]string{"uuid"}, "uuid", "uuid"},
+}
+
+// importValidateTypes are the semantic types the validate tags gogen
+// writes, and go-playground/validator reads, stand for
+var importValidateTypes = map[string]string{
+	"email":                   "email",
+	"url":                     "url",
+	"uri":                     "url",
+	"http_url":                "url",
+	"uuid":                    "uuid",
+	"uuid4":                   "uuid",
+	"uuid_rfc4122":            "uuid",
+	"e164":                    "phone",
+	"iso3166_1_alpha2":        "country_code",
+	"postcode_iso3166_alpha2": "zip_code",
+}
+
+// Import translates the exported struct types of a Go package's files into
+// the source of a CloudPact file declaring module, one record per struct in
+// the order the files declare them. Field names come from json tags, else
+// the Go name in camel case, and fields tagged json:"-" are left out. A
+// pointer is maybe, and omitempty optional. Text fields take the semantic
+// type their validate tag or name suggests, such as email for Email; time
+// fields named like dates are dates. The first embedded struct of the
+// package is extended, and the fields of later ones are merged in. Doc
+// comments become comments, and what CloudPact cannot express, such as the
+// values of a Go enum, is noted in them.
+func Import(files []*ast.File, module string) (string, error) {
+	if len(files) == 0 {
+		return "", fmt.Errorf("no Go files")
+	}
+	imp := &goImporter{
+		types:     make(map[string]*ast.TypeSpec),
+		docs:      make(map[string]*ast.CommentGroup),
+		enums:     make(map[string][]string),
+		records:   make(map[string]string),
+		taken:     make(map[string]bool),
+		resolving: make(map[string]bool),
+	}
+	if module == "" {
+		module = strings.ToUpper(files[0].Name.Name[:1]) + files[0].Name.Name[1:]
+	}
+
+	var structs []*ast.TypeSpec
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			switch gen.Tok {
+			case token.TYPE:
+				for _, spec := range gen.Specs {
+					spec := spec.(*ast.TypeSpec)
+					imp.types[spec.Name.Name] = spec
+					imp.docs[spec.Name.Name] = spec.Doc
+					if spec.Doc == nil && len(gen.Specs) == 1 {
+						imp.docs[spec.Name.Name] = gen.Doc
+					}
+					if _, ok := spec.Type.(*ast.StructType); ok && spec.Name.IsExported() && spec.TypeParams == nil {
+						structs = append(structs, spec)
+					}
+				}
+			case token.CONST:
+				imp.collectEnum(gen)
+			}
+		}
+	}
+	if len(structs) == 0 {
+		return "", fmt.Errorf("package %s declares no exported struct types", files[0].Name.Name)
+	}
+
+	// Names are reserved first, so structs may refer to ones declared later
+	for _, spec := range structs {
+		imp.records[spec.Name.Name] = imp.recordName(spec.Name.Name)
+	}
+	// The Go of a record declares a New<Record> constructor, so a record
+	// named like one, such as NewPet beside Pet, takes another name
+	for _, spec := range structs {
+		name := imp.records[spec.Name.Name]
+		if strings.HasPrefix(name, "New") && imp.taken[name[3:]] {
+			delete(imp.taken, name)
+			imp.records[spec.Name.Name] = imp.recordName(name + "Input")
+		}
+	}
+	for _, spec := range structs {
+		imp.addRecord(imp.records[spec.Name.Name], spec.Name.Name, spec.Type.(*ast.StructType), imp.docs[spec.Name.Name])
+	}
+
+	var b strings.Builder
+	b.WriteString("// Imported from the Go package " + files[0].Name.Name + "\n")
+	b.WriteString("module " + module + "\n")
+	for _, record := range imp.output {
+		b.WriteString("\n" + record)
+	}
+	return b.String(), nil
+}
+
+// goImporter collects the records of a Go package
+type goImporter struct {
+	types     map[string]*ast.TypeSpec     // every type the package declares
+	docs      map[string]*ast.CommentGroup // their doc comments
+	enums     map[string][]string          // constant values by type name
+	records   map[string]string            // struct name to record name
+	taken     map[string]bool              // record names in use
+	output    []string                     // the records written, in order
+	resolving map[string]bool              // named types being mapped, for recursive ones
+}
+
+// collectEnum records the values of typed constants, the enums of Go
+func (imp *goImporter) collectEnum(gen *ast.GenDecl) {
+	for _, spec := range gen.Specs {
+		spec := spec.(*ast.ValueSpec)
+		typeName, ok := spec.Type.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		for _, value := range spec.Values {
+			lit, ok := value.(*ast.BasicLit)
+			if !ok {
+				continue
+			}
+			text := lit.Value
+			if lit.Kind == token.STRING {
+				text, _ = strconv.Unquote(lit.Value)
+			}
+			imp.enums[typeName.Name] = append(imp.enums[typeName.Name], text)
+		}
+	}
+}
+
+// recordName reserves a free record name based on name
+func (imp *goImporter) recordName(name string) string {
+	candidate := name
+	for i := 2; imp.taken[candidate]; i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	imp.taken[candidate] = true
+	return candidate
+}
+
+// goImportField is a record field about to be written
+type goImportField struct {
+	name  string
+	lines []string
+}
+
+// addRecord writes the record declared by a struct
+func (imp *goImporter) addRecord(name, goName string, st *ast.StructType, doc *ast.CommentGroup) {
+	extends := ""
+	declared := make(map[string]bool)
+	var fields []goImportField
+	var notes []string
+
+	var walk func(st *ast.StructType)
+	walk = func(st *ast.StructType) {
+		for _, field := range st.Fields.List {
+			tag := reflect.StructTag("")
+			if field.Tag != nil {
+				value, _ := strconv.Unquote(field.Tag.Value)
+				tag = reflect.StructTag(value)
+			}
+			jsonName, jsonOptions, _ := strings.Cut(tag.Get("json"), ",")
+			if jsonName == "-" && jsonOptions == "" {
+				continue
+			}
+
+			// Embedded structs of the package without a JSON name are
+			// flattened by encoding/json: the first is extended
+			if len(field.Names) == 0 && jsonName == "" {
+				embedded := field.Type
+				if star, ok := embedded.(*ast.StarExpr); ok {
+					embedded = star.X
+				}
+				ident, ok := embedded.(*ast.Ident)
+				if ok && imp.records[ident.Name] != "" && extends == "" && len(fields) == 0 {
+					extends = imp.records[ident.Name]
+					continue
+				}
+				if ok && imp.types[ident.Name] != nil {
+					if inner, ok := imp.types[ident.Name].Type.(*ast.StructType); ok {
+						walk(inner)
+						continue
+					}
+				}
+				notes = append(notes, "embeds "+goExprString(field.Type)+" in Go")
+				continue
+			}
+
+			switch field.Type.(type) {
+			case *ast.ChanType, *ast.FuncType:
+				// encoding/json cannot encode them
+				continue
+			}
+			names := field.Names
+			if len(names) == 0 {
+				// An embedded struct with a JSON name is a field named
+				// after its type
+				embedded := goExprString(field.Type)
+				names = []*ast.Ident{ast.NewIdent(embedded[strings.LastIndexAny(embedded, "*.")+1:])}
+			}
+			for _, ident := range names {
+				if !ident.IsExported() {
+					continue
+				}
+				fieldName := jsonName
+				if fieldName == "" || len(names) > 1 {
+					fieldName = ident.Name
+				}
+				fieldName = importFieldName(fieldName)
+				if declared[fieldName] {
+					continue
+				}
+				declared[fieldName] = true
+				lines := imp.field(name+ident.Name, fieldName, field, tag, strings.Contains(jsonOptions, "omitempty"))
+				fields = append(fields, goImportField{fieldName, lines})
+			}
+		}
+	}
+	walk(st)
+
+	var b strings.Builder
+	for _, line := range commentLines(doc, goName) {
+		b.WriteString("// " + line + "\n")
+	}
+	for _, note := range notes {
+		b.WriteString("// " + note + "\n")
+	}
+	b.WriteString("define record " + name)
+	if extends != "" {
+		b.WriteString(" extends " + extends)
+	} else if !declared["id"] {
+		// Go structs carry their identity as a field, if at all
+		b.WriteString(" no id")
+	}
+	b.WriteString("\n")
+	for _, field := range fields {
+		for _, line := range field.lines {
+			b.WriteString("    " + line + "\n")
+		}
+	}
+	imp.output = append(imp.output, b.String())
+}
+
+// field writes the lines declaring a record field: its comments and the
+// field itself. Inline structs become records named context.
+func (imp *goImporter) field(context, name string, field *ast.Field, tag reflect.StructTag, omitempty bool) []string {
+	cpType, nullable, notes := imp.typeOf(field.Type, context, name)
+
+	// validate tags name semantic types and bounds
+	var bounds []string
+	for _, option := range strings.Split(tag.Get("validate"), ",") {
+		key, value, _ := strings.Cut(option, "=")
+		if semantic, ok := importValidateTypes[key]; ok && cpType == "text" {
+			cpType = semantic
+		}
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			continue
+		}
+		switch key {
+		case "min", "gte":
+			bounds = append(bounds, "min "+value)
+		case "max", "lte":
+			bounds = append(bounds, "max "+value)
+		case "len":
+			bounds = append(bounds, "min "+value, "max "+value)
+		}
+	}
+	if cpType == "text" {
+		cpType = inferTextType(name)
+	}
+
+	var lines []string
+	for _, line := range append(commentLines(field.Doc, ""), commentLines(field.Comment, "")...) {
+		lines = append(lines, "// "+line)
+	}
+	for _, note := range notes {
+		lines = append(lines, "// "+note)
+	}
+
+	decl := name + ": "
+	switch {
+	case nullable && !omitempty:
+		decl += "maybe " + cpType
+	case nullable || omitempty:
+		decl += cpType + " optional"
+	default:
+		decl += cpType
+	}
+	if isText, isNumber := importKinds(cpType); isText || isNumber {
+		for _, bound := range bounds {
+			// The validator reads min and max of text as a length
+			if isText {
+				bound = strings.Replace(bound, "min ", "minlength ", 1)
+				bound = strings.Replace(bound, "max ", "maxlength ", 1)
+			}
+			decl += " " + bound
+		}
+	}
+	return append(lines, decl)
+}
+
+// importKinds reports whether cpType holds text or a number, the kinds
+// that take bounds
+func importKinds(cpType string) (isText, isNumber bool) {
+	switch cpType {
+	case "int", "number", "percentage":
+		return false, true
+	case "text", "email", "url", "phone", "password", "zip_code", "country_code", "state_code", "api_key", "token":
+		return true, false
+	}
+	return false, false
+}
+
+// typeOf maps a Go type to a CloudPact type. Pointers are nullable, and
+// the notes say what the type leaves out.
+func (imp *goImporter) typeOf(expr ast.Expr, context, fieldName string) (cpType string, nullable bool, notes []string) {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		cpType, _, notes = imp.typeOf(t.X, context, fieldName)
+		return cpType, true, notes
+	case *ast.ArrayType:
+		if ident, ok := t.Elt.(*ast.Ident); ok && ident.Name == "byte" {
+			return "text", false, []string{"[]byte in Go, base64 in JSON"}
+		}
+		element, _, elementNotes := imp.typeOf(t.Elt, context+"Item", fieldName)
+		return "list of " + element, false, elementNotes
+	case *ast.MapType:
+		key, _, _ := imp.typeOf(t.Key, context+"Key", "")
+		if key != "int" {
+			key = "text"
+		}
+		value, _, valueNotes := imp.typeOf(t.Value, context+"Value", "")
+		return "map of " + key + " to " + value, false, valueNotes
+	case *ast.StructType:
+		record := imp.recordName(context)
+		imp.addRecord(record, "", t, nil)
+		return record, false, nil
+	case *ast.InterfaceType:
+		return "text", false, []string{"any value in Go"}
+	case *ast.SelectorExpr:
+		return importQualified(goExprString(t), fieldName)
+	case *ast.Ident:
+		if cpType, ok := importBasic(t.Name, fieldName); ok {
+			return cpType, false, nil
+		}
+		if record, ok := imp.records[t.Name]; ok {
+			return record, false, nil
+		}
+		if spec, ok := imp.types[t.Name]; ok && !imp.resolving[t.Name] {
+			// A named type takes its underlying type, noting the values
+			// its constants give
+			imp.resolving[t.Name] = true
+			cpType, nullable, notes = imp.typeOf(spec.Type, context, fieldName)
+			delete(imp.resolving, t.Name)
+			if values := imp.enums[t.Name]; len(values) > 0 {
+				notes = append(notes, "one of: "+strings.Join(values, ", "))
+			}
+			return cpType, nullable, append(notes, "Go type "+t.Name)
+		}
+		if t.Name == "any" {
+			return "text", false, []string{"any value in Go"}
+		}
+	}
+	return "text", false, []string{"Go type " + goExprString(expr)}
+}
+
+// importBasic maps a predeclared Go type
+func importBasic(name, fieldName string) (string, bool) {
+	switch name {
+	case "string":
+		return "text", true
+	case "bool":
+		return "boolean", true
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+		return "int", true
+	case "float32", "float64":
+		lower := strings.ToLower(fieldName)
+		if strings.HasSuffix(lower, "percent") || strings.HasSuffix(lower, "percentage") {
+			return "percentage", true
+		}
+		return "number", true
+	}
+	return "", false
+}
+
+// importQualified maps a type of another package: the standard library's
+// times and nullable SQL values, and UUID types
+func importQualified(name, fieldName string) (string, bool, []string) {
+	switch name {
+	case "time.Time":
+		lower := strings.ToLower(fieldName)
+		if strings.HasSuffix(lower, "date") || lower == "birthday" || lower == "dob" {
+			return "date", false, nil
+		}
+		return "datetime", false, nil
+	case "time.Duration":
+		return "duration", false, nil
+	case "sql.NullString":
+		return inferTextType(fieldName), true, nil
+	case "sql.NullInt64", "sql.NullInt32", "sql.NullInt16", "sql.NullByte":
+		return "int", true, nil
+	case "sql.NullFloat64":
+		return "number", true, nil
+	case "sql.NullBool":
+		return "boolean", true, nil
+	case "sql.NullTime":
+		return "datetime", true, nil
+	}
+	if strings.HasSuffix(name, ".UUID") {
+		return "uuid", false, nil
+	}
+	return "text", false, []string{"Go type " + name}
+}
+
+// inferTextType is the semantic type a text field's name suggests, or text
+func inferTextType(fieldName string) string {
+	lower := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(fieldName))
+	for _, candidate := range importNameTypes {
+		for _, name := range candidate.names {
+			if lower == name {
+				return candidate.cpType
+			}
+		}
+		if strings.HasSuffix(lower, candidate.suffix) && len(lower) > len(candidate.suffix) {
+			// The last word must be the whole suffix: workEmail, not
+			// nonemail
+			words := importWords(fieldName)
+			last := strings.ToLower(words[len(words)-1])
+			if strings.HasSuffix(candidate.suffix, last) {
+				return candidate.cpType
+			}
+		}
+	}
+	return "text"
+}
+
+// importFieldName makes name a CloudPact field name: a JSON name that is an
+// identifier is kept, and a Go name is lowered to camel case
+func importFieldName(name string) string {
+	words := importWords(name)
+	if len(words) == 0 {
+		return "value"
+	}
+	if !isImportIdentifier(name) || unicode.IsUpper(rune(name[0])) {
+		// The first word is lowered whole: ID gives id and HTTPServer
+		// httpServer
+		name = strings.ToLower(words[0])
+		for _, word := range words[1:] {
+			name += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	if unicode.IsDigit(rune(name[0])) {
+		name = "n" + name
+	}
+	if importReserved[name] {
+		name += "Value"
+	}
+	return name
+}
+
+func isImportIdentifier(name string) bool {
+	for i, r := range name {
+		if !(unicode.IsLetter(r) || r == '_' || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// importWords splits a name at anything but letters and digits, and at case
+// changes, keeping acronyms whole
+func importWords(name string) []string {
+	var words []string
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		runes := []rune(part)
+		start := 0
+		for i := 1; i <= len(runes); i++ {
+			if i == len(runes) || (unicode.IsUpper(runes[i]) &&
+				(!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1])))) {
+				words = append(words, string(runes[start:i]))
+				start = i
+			}
+		}
+	}
+	return words
+}
+
+// commentLines are the lines of a Go comment. A doc comment of goName
+// loses the name it starts with.
+func commentLines(group *ast.CommentGroup, goName string) []string {
+	if group == nil {
+		return nil
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(group.Text()), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	// "Customer is a buyer" reads as "A buyer" above the record
+	if goName != "" && len(lines) > 0 {
+		for _, verb := range []string{" is ", " are "} {
+			if rest := strings.TrimPrefix(lines[0], goName+verb); rest != lines[0] && rest != "" {
+				lines[0] = strings.ToUpper(rest[:1]) + rest[1:]
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// goExprString writes a type expression as Go source
+func goExprString(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return goExprString(t.X) + "." + t.Sel.Name
+	case *ast.StarExpr:
+		return "*" + goExprString(t.X)
+	case *ast.ArrayType:
+		return "[]" + goExprString(t.Elt)
+	case *ast.MapType:
+		return "map[" + goExprString(t.Key) + "]" + goExprString(t.Value)
+	case *ast.ChanType:
+		return "chan " + goExprString(t.Value)
+	case *ast.FuncType:
+		return "func"
+	case *ast.InterfaceType:
+		return "interface{}"
+	case *ast.StructType:
+		return "struct{...}"
+	}
+	return fmt.Sprintf("%T", expr)
+}
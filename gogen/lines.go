@@ -0,0 +1,61 @@
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// goLineMarker starts a comment line placed before the Go of each statement
+// of a function body, naming the statement's line and column in the .cp
+// file. GenerateFile removes these lines after formatting and SourceLines
+// reads them.
+const goLineMarker = "//cloudpact:line "
+
+// goStatementMarker returns the marker line for a statement at pos, or ""
+// when its position is unknown
+func goStatementMarker(indent string, pos *grammar.Position) string {
+	if pos == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s%s%d:%d\n", indent, goLineMarker, pos.Line, pos.Column)
+}
+
+// stripGoLineMarkers removes the marker lines from code and returns, for
+// each remaining line, the position of the statement it was generated
+// from. A line comes from the statement of the last marker before it, until
+// a line at the start of a line closes the function; other lines have none.
+func stripGoLineMarkers(code []byte) ([]byte, []*grammar.Position) {
+	var out bytes.Buffer
+	var lines []*grammar.Position
+	var current *grammar.Position
+	for _, line := range strings.SplitAfter(string(code), "\n") {
+		if line == "" {
+			continue
+		}
+		if marker, ok := strings.CutPrefix(strings.TrimSpace(line), goLineMarker); ok {
+			current = &grammar.Position{}
+			if _, err := fmt.Sscanf(marker, "%d:%d", &current.Line, &current.Column); err != nil {
+				current = nil
+			}
+			continue
+		}
+		if line[0] != '\t' && line[0] != ' ' && line[0] != '\n' {
+			current = nil
+		}
+		out.WriteString(line)
+		lines = append(lines, current)
+	}
+	return out.Bytes(), lines
+}
+
+// SourceLines maps the lines of the Go GenerateFile writes for file to the
+// statements of function bodies they were generated from: the result holds
+// the line and column in file of the statement behind Go line n at index
+// n-1, or nil for lines that come from a declaration instead
+func SourceLines(file *grammar.File, opts Options) ([]*grammar.Position, error) {
+	_, lines, err := generateGoFile(file, opts)
+	return lines, err
+}
@@ -0,0 +1,85 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// goRedacted replaces the values of secret types, such as password, in
+// generated logs
+const goRedacted = "[REDACTED]"
+
+// goLogArg is the key and value a function's entry log gives param, its
+// value redacted when its type is a secret
+func goLogArg(param *grammar.Parameter) string {
+	if codegen.IsSecret(param.Type.Name) {
+		return fmt.Sprintf("%q, %q", param.Name, goRedacted)
+	}
+	return fmt.Sprintf("%q, %s", param.Name, param.Name)
+}
+
+// generateGoFunctionLogs writes the statements a function's body starts
+// with when logging is on: a debug log of the call and its parameters, and
+// a deferred one of its return and how long it took. Request headers are
+// left out, as they may carry credentials.
+func generateGoFunctionLogs(function *grammar.Function) string {
+	var code strings.Builder
+	args := []string{fmt.Sprintf("%q, %q", "function", function.Name)}
+	for _, param := range function.Parameters {
+		args = append(args, goLogArg(param))
+	}
+	code.WriteString(fmt.Sprintf("\tslog.Debug(\"function called\", %s)\n", strings.Join(args, ", ")))
+	code.WriteString("\tdefer func(start time.Time) {\n")
+	code.WriteString(fmt.Sprintf("\t\tslog.Debug(\"function returned\", \"function\", %q, \"duration\", time.Since(start))\n", function.Name))
+	code.WriteString("\t}(time.Now())\n")
+	return code.String()
+}
+
+// generateGoLogValue writes the LogValue method of a record, which logs it
+// field by field under their JSON keys with the values of secret types
+// redacted. Unset optional fields are left out. A record extending one of
+// another file gets none, as its base's fields are not known here.
+func generateGoLogValue(record *grammar.Record) string {
+	if record.Extends != "" && record.Base == nil {
+		return ""
+	}
+	root := record
+	for root.Base != nil {
+		root = root.Base
+	}
+
+	var code strings.Builder
+	code.WriteString(fmt.Sprintf("// LogValue implements slog.LogValuer, logging the fields of %s with\n", record.Name))
+	code.WriteString("// secrets redacted\n")
+	code.WriteString(fmt.Sprintf("func (r %s) LogValue() slog.Value {\n", record.Name))
+	var attrs []string
+	if root.Extends == "" && root.HasImplicitID() {
+		attrs = append(attrs, "slog.String(\"id\", r.ID)")
+	}
+	var optional []*grammar.FieldDef
+	for _, field := range record.AllFields() {
+		switch {
+		case codegen.IsSecret(field.Type.Name):
+			attrs = append(attrs, fmt.Sprintf("slog.String(%q, %q)", field.JSONKey(), goRedacted))
+		case field.Type.Optional:
+			optional = append(optional, field)
+		default:
+			attrs = append(attrs, fmt.Sprintf("slog.Any(%q, r.%s)", field.JSONKey(), FieldName(field.Name)))
+		}
+	}
+	if len(optional) == 0 {
+		code.WriteString(fmt.Sprintf("\treturn slog.GroupValue(%s)\n}\n\n", strings.Join(attrs, ", ")))
+		return code.String()
+	}
+	code.WriteString(fmt.Sprintf("\tattrs := []slog.Attr{%s}\n", strings.Join(attrs, ", ")))
+	for _, field := range optional {
+		code.WriteString(fmt.Sprintf("\tif r.%s != nil {\n", FieldName(field.Name)))
+		code.WriteString(fmt.Sprintf("\t\tattrs = append(attrs, slog.Any(%q, *r.%s))\n", field.JSONKey(), FieldName(field.Name)))
+		code.WriteString("\t}\n")
+	}
+	code.WriteString("\treturn slog.GroupValue(attrs...)\n}\n\n")
+	return code.String()
+}
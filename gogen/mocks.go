@@ -0,0 +1,168 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// GenerateMocks translates a checked file's functions into Go for the
+// package GenerateFile writes: an interface named after the module with a
+// method per function, New<Module> returning the interface backed by the
+// generated functions, and Mock<Module>, a mock for tests with no
+// dependencies. A file without functions has nothing to mock and yields nil.
+func GenerateMocks(file *grammar.File, opts Options) ([]byte, error) {
+	if len(file.Functions) == 0 {
+		return nil, nil
+	}
+
+	pkg, iface := PackageName(file), "Service"
+	if file.Module != nil {
+		iface = exportedName(file.Module.Name)
+	}
+
+	var code strings.Builder
+	writeGoInterface(&code, iface, file.Functions)
+	writeGoMock(&code, iface, file.Functions)
+
+	// Records of other modules in the signatures are qualified as in
+	// GenerateFile
+	imports := append([]string{"sync"}, goImportCandidates...)
+	formatted, err := RenderFile(pkg, imports, code.String(), opts.Packages)
+	if err != nil {
+		return formatted, err
+	}
+	return codegen.Stamp("//", opts.Header, formatted), nil
+}
+
+// exportedName capitalizes name for use outside its package
+func exportedName(name string) string {
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// goMethod is a function as a method of the module interface
+type goMethod struct {
+	name     string // exported method name
+	function string // the generated function
+	params   []codegen.Param
+	results  goResults
+}
+
+func newGoMethod(function *grammar.Function) goMethod {
+	return goMethod{
+		name:     exportedName(function.Name),
+		function: function.Name,
+		params:   goFunctionData(function).Params,
+		results:  goFunctionResults(function),
+	}
+}
+
+// signature is the method's parameter list and results
+func (m goMethod) signature() string {
+	var params []string
+	for _, param := range m.params {
+		params = append(params, param.Name+" "+param.Type)
+	}
+	return strings.TrimSpace(fmt.Sprintf("(%s) %s", strings.Join(params, ", "), m.results.signature()))
+}
+
+// call is the method's parameters passed on to fn
+func (m goMethod) call(fn string) string {
+	var args []string
+	for _, param := range m.params {
+		args = append(args, param.Name)
+	}
+	call := fmt.Sprintf("%s(%s)", fn, strings.Join(args, ", "))
+	if m.results.signature() == "" {
+		return call
+	}
+	return "return " + call
+}
+
+// writeGoInterface writes the module interface and its implementation by
+// the generated functions
+func writeGoInterface(code *strings.Builder, iface string, functions []*grammar.Function) {
+	impl := strings.ToLower(iface[:1]) + iface[1:] + "Funcs"
+
+	code.WriteString(fmt.Sprintf("// %s is the function set of the %s module, for code that calls\n", iface, iface))
+	code.WriteString("// it to depend on instead of the functions themselves\n")
+	code.WriteString(fmt.Sprintf("type %s interface {\n", iface))
+	for _, function := range functions {
+		m := newGoMethod(function)
+		code.WriteString(fmt.Sprintf("\t%s%s\n", m.name, m.signature()))
+	}
+	code.WriteString("}\n\n")
+
+	code.WriteString(fmt.Sprintf("// New%s returns the %s backed by the generated functions\n", iface, iface))
+	code.WriteString(fmt.Sprintf("func New%s() %s {\n", iface, iface))
+	code.WriteString(fmt.Sprintf("\treturn %s{}\n", impl))
+	code.WriteString("}\n\n")
+	code.WriteString(fmt.Sprintf("type %s struct{}\n\n", impl))
+	for _, function := range functions {
+		m := newGoMethod(function)
+		code.WriteString(fmt.Sprintf("func (%s) %s%s {\n", impl, m.name, m.signature()))
+		code.WriteString(fmt.Sprintf("\t%s\n", m.call(m.function)))
+		code.WriteString("}\n\n")
+	}
+}
+
+// writeGoMock writes Mock<iface>, whose methods record their arguments and
+// return what a settable function returns
+func writeGoMock(code *strings.Builder, iface string, functions []*grammar.Function) {
+	mock := "Mock" + iface
+
+	code.WriteString(fmt.Sprintf("// %s is %s for tests. Each method records its arguments and\n", mock, goArticle(iface)))
+	code.WriteString("// returns what its Func field returns, or zero values while that is nil.\n")
+	code.WriteString("// It is safe for concurrent use.\n")
+	code.WriteString(fmt.Sprintf("type %s struct {\n", mock))
+	for _, function := range functions {
+		m := newGoMethod(function)
+		code.WriteString(fmt.Sprintf("\t%sFunc func%s\n", m.name, m.signature()))
+	}
+	code.WriteString("\n\tmu    sync.Mutex\n")
+	code.WriteString("\tcalls struct {\n")
+	for _, function := range functions {
+		m := newGoMethod(function)
+		code.WriteString(fmt.Sprintf("\t\t%s []%s%sCall\n", m.name, mock, m.name))
+	}
+	code.WriteString("\t}\n")
+	code.WriteString("}\n\n")
+	code.WriteString(fmt.Sprintf("var _ %s = (*%s)(nil)\n\n", iface, mock))
+
+	for _, function := range functions {
+		m := newGoMethod(function)
+		call := mock + m.name + "Call"
+
+		code.WriteString(fmt.Sprintf("// %s holds the arguments of a call to %s.%s\n", call, mock, m.name))
+		var fields, values []string
+		for _, param := range m.params {
+			fields = append(fields, fmt.Sprintf("\t%s %s\n", exportedName(param.Name), param.Type))
+			values = append(values, fmt.Sprintf("%s: %s", exportedName(param.Name), param.Name))
+		}
+		if len(fields) == 0 {
+			code.WriteString(fmt.Sprintf("type %s struct{}\n\n", call))
+		} else {
+			code.WriteString(fmt.Sprintf("type %s struct {\n%s}\n\n", call, strings.Join(fields, "")))
+		}
+
+		code.WriteString(fmt.Sprintf("func (m *%s) %s%s {\n", mock, m.name, m.signature()))
+		code.WriteString("\tm.mu.Lock()\n")
+		code.WriteString(fmt.Sprintf("\tm.calls.%s = append(m.calls.%s, %s{%s})\n", m.name, m.name, call, strings.Join(values, ", ")))
+		code.WriteString(fmt.Sprintf("\tfn := m.%sFunc\n", m.name))
+		code.WriteString("\tm.mu.Unlock()\n")
+		code.WriteString("\tif fn == nil {\n")
+		code.WriteString(fmt.Sprintf("\t\treturn %s\n", m.results.values("", "nil")))
+		code.WriteString("\t}\n")
+		code.WriteString(fmt.Sprintf("\t%s\n", m.call("fn")))
+		code.WriteString("}\n\n")
+
+		code.WriteString(fmt.Sprintf("// %sCalls returns the calls made to %s so far\n", m.name, m.name))
+		code.WriteString(fmt.Sprintf("func (m *%s) %sCalls() []%s {\n", mock, m.name, call))
+		code.WriteString("\tm.mu.Lock()\n")
+		code.WriteString("\tdefer m.mu.Unlock()\n")
+		code.WriteString(fmt.Sprintf("\treturn append([]%s(nil), m.calls.%s...)\n", call, m.name))
+		code.WriteString("}\n\n")
+	}
+}
@@ -0,0 +1,86 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// generateGoModelPatch emits a MergePatch method applying a JSON Merge Patch
+// (RFC 7386) to a model, and an HTTP handler serving PATCH /<models>/{id}
+func generateGoModelPatch(model *grammar.Model) string {
+	var code strings.Builder
+	name := model.Name
+
+	code.WriteString(fmt.Sprintf("// MergePatch applies a JSON Merge Patch to %s. Omitted fields are left\n", name))
+	code.WriteString("// unchanged; null clears optional fields and is rejected for required ones.\n")
+	code.WriteString(fmt.Sprintf("func (m *%s) MergePatch(patch []byte) error {\n", name))
+	code.WriteString("\tvar fields map[string]json.RawMessage\n")
+	code.WriteString("\tif err := json.Unmarshal(patch, &fields); err != nil {\n")
+	code.WriteString("\t\treturn fmt.Errorf(\"patch must be a JSON object: %w\", err)\n")
+	code.WriteString("\t}\n\n")
+	code.WriteString("\t// Validate the whole patch before changing anything\n")
+	code.WriteString("\tpatched := *m\n")
+	code.WriteString("\tfor key, value := range fields {\n")
+	code.WriteString("\t\tisNull := string(value) == \"null\"\n")
+	code.WriteString("\t\tswitch key {\n")
+	for _, field := range model.Fields {
+		if strings.ToLower(field.Name) == "id" {
+			continue // handled below: ids cannot be patched
+		}
+		code.WriteString(fmt.Sprintf("\t\tcase %q:\n", field.JSONKey()))
+		if field.Type.Optional {
+			code.WriteString("\t\t\tif isNull {\n")
+			code.WriteString(fmt.Sprintf("\t\t\t\tpatched.%s = nil\n", FieldName(field.Name)))
+			code.WriteString("\t\t\t\tcontinue\n")
+			code.WriteString("\t\t\t}\n")
+		} else {
+			code.WriteString("\t\t\tif isNull {\n")
+			code.WriteString(fmt.Sprintf("\t\t\t\treturn errors.New(\"%s is required and cannot be null\")\n", field.JSONKey()))
+			code.WriteString("\t\t\t}\n")
+		}
+		code.WriteString(fmt.Sprintf("\t\t\tif err := json.Unmarshal(value, &patched.%s); err != nil {\n", FieldName(field.Name)))
+		code.WriteString(fmt.Sprintf("\t\t\t\treturn fmt.Errorf(\"invalid value for %s: %%w\", err)\n", field.JSONKey()))
+		code.WriteString("\t\t\t}\n")
+	}
+	code.WriteString("\t\tcase \"id\":\n")
+	code.WriteString("\t\t\treturn errors.New(\"id cannot be changed\")\n")
+	code.WriteString("\t\tdefault:\n")
+	code.WriteString(fmt.Sprintf("\t\t\treturn fmt.Errorf(\"unknown %s field %%q\", key)\n", name))
+	code.WriteString("\t\t}\n")
+	code.WriteString("\t}\n\n")
+	code.WriteString("\t*m = patched\n")
+	code.WriteString("\treturn nil\n")
+	code.WriteString("}\n\n")
+
+	code.WriteString(fmt.Sprintf("// Patch%sHandler serves PATCH requests for %s. load returns nil when\n", name, goArticle(strings.ToLower(name))))
+	code.WriteString("// no record has the id in the last path segment; save persists the result.\n")
+	code.WriteString(fmt.Sprintf("func Patch%sHandler(load func(id string) (*%s, error), save func(*%s) error) http.HandlerFunc {\n", name, name, name))
+	writeGoErrorWriter(&code)
+	code.WriteString("\treturn func(w http.ResponseWriter, r *http.Request) {\n")
+	code.WriteString("\t\tif r.Method != http.MethodPatch {\n")
+	code.WriteString(goErrorReply("\t\t\t", "http.StatusMethodNotAllowed", "method_not_allowed", `"method not allowed"`))
+	code.WriteString("\t\t}\n")
+	code.WriteString("\t\tif contentType := r.Header.Get(\"Content-Type\"); contentType != \"application/merge-patch+json\" && contentType != \"application/json\" {\n")
+	code.WriteString(goErrorReply("\t\t\t", "http.StatusUnsupportedMediaType", "unsupported_media_type", `"expected application/merge-patch+json"`))
+	code.WriteString("\t\t}\n\n")
+	writeGoRecordLoad(&code)
+	code.WriteString("\n")
+	code.WriteString("\t\tbody, err := io.ReadAll(r.Body)\n")
+	code.WriteString("\t\tif err != nil {\n")
+	code.WriteString(goErrorReply("\t\t\t", goFailStatus[grammar.FailBadRequest], grammar.FailBadRequest, "err.Error()"))
+	code.WriteString("\t\t}\n")
+	code.WriteString("\t\tif err := record.MergePatch(body); err != nil {\n")
+	code.WriteString(goErrorReply("\t\t\t", goFailStatus[grammar.FailInvalid], grammar.FailInvalid, "err.Error()"))
+	code.WriteString("\t\t}\n")
+	code.WriteString("\t\tif err := save(record); err != nil {\n")
+	code.WriteString(goErrorReply("\t\t\t", goFailStatus[grammar.FailInternal], grammar.FailInternal, "err.Error()"))
+	code.WriteString("\t\t}\n\n")
+	code.WriteString("\t\tw.Header().Set(\"Content-Type\", \"application/json\")\n")
+	code.WriteString("\t\tjson.NewEncoder(w).Encode(record)\n")
+	code.WriteString("\t}\n")
+	code.WriteString("}\n\n")
+
+	return code.String()
+}
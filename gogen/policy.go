@@ -0,0 +1,227 @@
+package gogen
+
+import (
+	"fmt"
+	"go/format"
+	"slices"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// GeneratePolicy translates the roles and allow rules of the checked files
+// of a project into the Go package policy. Can answers whether an Actor may
+// perform an action on a record; Authenticate, Require and Auth put it in
+// front of handlers, answering 401 and 403 with the Error object the
+// handlers write. Auth fits the auth hook of handlers of functions declared
+// with requires. Files without roles yield nil.
+func GeneratePolicy(files []*grammar.File, opts Options) ([]byte, error) {
+	var roles []*grammar.Role
+	for _, file := range files {
+		roles = append(roles, file.Roles...)
+	}
+	if len(roles) == 0 {
+		return nil, nil
+	}
+
+	var code strings.Builder
+	code.WriteString("// Package policy decides what callers may do, as the roles and allow\n")
+	code.WriteString("// rules of the CloudPact files declare it.\n")
+	code.WriteString("package policy\n\n")
+	code.WriteString("import (\n\t\"context\"\n\t\"encoding/json\"\n\t\"net/http\"\n)\n\n")
+
+	code.WriteString("// The roles an Actor may have\nconst (\n")
+	for i, role := range roles {
+		doc := codegen.DocLines(role.Leading, role.Trailing)
+		if role.Why != "" {
+			doc = append(doc, "Why: "+role.Why)
+		}
+		if i > 0 && len(doc) > 0 {
+			code.WriteString("\n")
+		}
+		for _, line := range doc {
+			code.WriteString(strings.TrimRight("\t// "+line, " ") + "\n")
+		}
+		code.WriteString(fmt.Sprintf("\tRole%s = %q\n", exportedName(role.Name), role.Name))
+	}
+	code.WriteString(")\n\n")
+
+	code.WriteString("// roles lists every role, in the order they are declared\n")
+	code.WriteString("var roles = []string{")
+	for i, role := range roles {
+		if i > 0 {
+			code.WriteString(", ")
+		}
+		code.WriteString("Role" + exportedName(role.Name))
+	}
+	code.WriteString("}\n\n")
+
+	code.WriteString("// rules holds the actions each role is allowed, by record\n")
+	code.WriteString("var rules = map[string]map[string][]string{\n")
+	for _, role := range roles {
+		var resources []string
+		actions := make(map[string][]string)
+		for _, file := range files {
+			for _, allow := range file.Allows {
+				if allow.Role != role.Name {
+					continue
+				}
+				if _, ok := actions[allow.Resource]; !ok {
+					resources = append(resources, allow.Resource)
+				}
+				for _, action := range allow.Actions {
+					if !slices.Contains(actions[allow.Resource], action) {
+						actions[allow.Resource] = append(actions[allow.Resource], action)
+					}
+				}
+			}
+		}
+		if len(resources) == 0 {
+			continue
+		}
+		code.WriteString(fmt.Sprintf("\tRole%s: {\n", exportedName(role.Name)))
+		for _, resource := range resources {
+			quoted := make([]string, len(actions[resource]))
+			for i, action := range actions[resource] {
+				quoted[i] = fmt.Sprintf("%q", action)
+			}
+			code.WriteString(fmt.Sprintf("\t\t%q: {%s},\n", resource, strings.Join(quoted, ", ")))
+		}
+		code.WriteString("\t},\n")
+	}
+	code.WriteString("}\n\n")
+	code.WriteString(goPolicySource)
+
+	formatted, err := format.Source([]byte(code.String()))
+	if err != nil {
+		return []byte(code.String()), err
+	}
+	return codegen.Stamp("//", opts.Header, formatted), nil
+}
+
+// goPolicySource is the part of the policy package that does not depend on
+// the rules
+const goPolicySource = `// Actor is who makes a request: an ID, such as a user's, and the roles
+// they have
+type Actor struct {
+	ID    string
+	Roles []string
+}
+
+// HasRole reports whether the actor has role
+func (a Actor) HasRole(role string) bool {
+	for _, r := range a.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether actor may perform action on resource, the name of a
+// record such as "Order": whether a rule allows it to one of their roles
+func Can(actor Actor, action, resource string) bool {
+	for _, role := range actor.Roles {
+		for _, allowed := range rules[role][resource] {
+			if allowed == action {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Allowed lists the roles that may perform action on resource
+func Allowed(action, resource string) []string {
+	var allowed []string
+	for _, role := range roles {
+		for _, a := range rules[role][resource] {
+			if a == action {
+				allowed = append(allowed, role)
+				break
+			}
+		}
+	}
+	return allowed
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying actor
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor ctx carries, if any
+func ActorFrom(ctx context.Context) (Actor, bool) {
+	actor, ok := ctx.Value(actorKey{}).(Actor)
+	return actor, ok
+}
+
+// Authenticate puts the actor identify finds for each request in its
+// context, for Require and Auth to check. A request identify does not
+// recognize carries no actor, and they answer it with 401.
+func Authenticate(identify func(r *http.Request) (Actor, bool), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if actor, ok := identify(r); ok {
+			r = r.WithContext(WithActor(r.Context(), actor))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Require admits the requests whose actor Can perform action on resource.
+// It answers 401 when a request carries no actor and 403 when the actor may
+// not.
+func Require(action, resource string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := ActorFrom(r.Context())
+		if !ok {
+			refuse(w, r, http.StatusUnauthorized, "unauthorized", "authentication required")
+			return
+		}
+		if !Can(actor, action, resource) {
+			refuse(w, r, http.StatusForbidden, "forbidden", "not allowed to "+action+" "+resource)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Auth is the auth middleware of handlers of functions declared with
+// requires: it admits the requests whose actor has one of roles, or any
+// actor when roles is nil. Set the server's auth to it and serve the
+// handlers behind Authenticate.
+func Auth(next http.Handler, roles []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := ActorFrom(r.Context())
+		if !ok {
+			refuse(w, r, http.StatusUnauthorized, "unauthorized", "authentication required")
+			return
+		}
+		if roles != nil {
+			admitted := false
+			for _, role := range roles {
+				admitted = admitted || actor.HasRole(role)
+			}
+			if !admitted {
+				refuse(w, r, http.StatusForbidden, "forbidden", "a role this function requires is missing")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refuse answers with the Error object the handlers write
+func refuse(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	body := map[string]string{"code": code, "message": message}
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		body["requestId"] = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+`
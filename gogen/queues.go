@@ -0,0 +1,191 @@
+package gogen
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// GenerateQueues translates the queues of the checked files of one Go
+// package into producers and consumers for the package GenerateFile
+// writes. Tasks travel as JSON through a Queue, an interface adapters for
+// SQS, RabbitMQ or the like implement; MemoryQueue holds them within one
+// process. Each queue gets a name constant, a Producer and a Consumer
+// interface, New<Queue>Producer and Consume<Queue>, which hands decoded and
+// validated tasks to a Consumer. Files without queues yield nil.
+func GenerateQueues(files []*grammar.File, opts Options) ([]byte, error) {
+	var queues []*grammar.Queue
+	for _, file := range files {
+		queues = append(queues, file.Queues...)
+	}
+	if len(queues) == 0 {
+		return nil, nil
+	}
+
+	var code strings.Builder
+	code.WriteString(goQueueSource)
+	for _, queue := range queues {
+		writeGoQueue(&code, queue)
+	}
+
+	src := goFileSource(PackageName(files[0]), []string{"context", "encoding/json", "sync"}, code.String())
+	formatted, err := format.Source(src)
+	if err != nil {
+		return src, err
+	}
+	return codegen.Stamp("//", opts.Header, formatted), nil
+}
+
+// writeGoQueue writes the name constant, the interfaces and the functions
+// of queue
+func writeGoQueue(code *strings.Builder, queue *grammar.Queue) {
+	name, payload := exportedName(queue.Name), queue.Payload
+	constant := name + "Name"
+
+	code.WriteString(fmt.Sprintf("\n// %s is the queue %s tasks wait in\n", constant, payload))
+	doc := codegen.DocLines(queue.Leading, queue.Trailing)
+	if queue.Why != "" {
+		doc = append(doc, "Why: "+queue.Why)
+	}
+	if len(doc) > 0 {
+		code.WriteString("//\n")
+	}
+	for _, line := range doc {
+		code.WriteString(strings.TrimRight("// "+line, " ") + "\n")
+	}
+	code.WriteString(fmt.Sprintf("const %s = %q\n\n", constant, queue.Name))
+
+	code.WriteString(fmt.Sprintf("// %sProducer adds %s tasks to %s\n", name, payload, constant))
+	code.WriteString(fmt.Sprintf("type %sProducer interface {\n", name))
+	code.WriteString(fmt.Sprintf("\tEnqueue(ctx context.Context, task *%s) error\n}\n\n", payload))
+
+	code.WriteString(fmt.Sprintf("// %sConsumer handles the tasks of %s. A task it fails\n", name, constant))
+	code.WriteString("// on is returned to the queue to be tried again.\n")
+	code.WriteString(fmt.Sprintf("type %sConsumer interface {\n", name))
+	code.WriteString(fmt.Sprintf("\tHandle(ctx context.Context, task *%s) error\n}\n\n", payload))
+
+	producer := strings.ToLower(name[:1]) + name[1:] + "Producer"
+	code.WriteString(fmt.Sprintf("// New%sProducer returns a producer sending to queue\n", name))
+	code.WriteString(fmt.Sprintf("func New%sProducer(queue Queue) %sProducer {\n", name, name))
+	code.WriteString(fmt.Sprintf("\treturn %s{queue}\n}\n\n", producer))
+	code.WriteString(fmt.Sprintf("type %s struct {\n\tqueue Queue\n}\n\n", producer))
+	code.WriteString(fmt.Sprintf("// Enqueue implements %sProducer\n", name))
+	code.WriteString(fmt.Sprintf("func (p %s) Enqueue(ctx context.Context, task *%s) error {\n", producer, payload))
+	code.WriteString("\tif err := task.Validate(); err != nil {\n\t\treturn err\n\t}\n")
+	code.WriteString("\tdata, err := json.Marshal(task)\n")
+	code.WriteString("\tif err != nil {\n\t\treturn err\n\t}\n")
+	code.WriteString(fmt.Sprintf("\treturn p.queue.Send(ctx, %s, data)\n}\n\n", constant))
+
+	code.WriteString(fmt.Sprintf("// Consume%s hands the tasks of %s to consumer until ctx\n", name, constant))
+	code.WriteString("// is done. Tasks it handles are acknowledged and those it fails on are\n")
+	code.WriteString("// returned to the queue. Tasks that do not decode or validate could never\n")
+	code.WriteString("// succeed, so they are acknowledged and dropped.\n")
+	code.WriteString(fmt.Sprintf("func Consume%s(ctx context.Context, queue Queue, consumer %sConsumer) error {\n", name, name))
+	code.WriteString(fmt.Sprintf("\tdeliveries, err := queue.Receive(ctx, %s)\n", constant))
+	code.WriteString("\tif err != nil {\n\t\treturn err\n\t}\n")
+	code.WriteString("\tfor delivery := range deliveries {\n")
+	code.WriteString(fmt.Sprintf("\t\ttask := new(%s)\n", payload))
+	code.WriteString("\t\tif json.Unmarshal(delivery.Data, task) != nil || task.Validate() != nil {\n")
+	code.WriteString("\t\t\terr = delivery.Ack()\n")
+	code.WriteString("\t\t} else if consumer.Handle(ctx, task) != nil {\n")
+	code.WriteString("\t\t\terr = delivery.Nack()\n")
+	code.WriteString("\t\t} else {\n")
+	code.WriteString("\t\t\terr = delivery.Ack()\n")
+	code.WriteString("\t\t}\n")
+	code.WriteString("\t\tif err != nil {\n\t\t\treturn err\n\t\t}\n")
+	code.WriteString("\t}\n")
+	code.WriteString("\treturn ctx.Err()\n}\n")
+}
+
+// goQueueSource is the part of queues.go shared by every queue of a package
+const goQueueSource = `// Queue carries tasks from producers to workers as JSON messages, each
+// delivered to one worker. Implement it over SQS, RabbitMQ or another
+// queue; MemoryQueue holds tasks within one process, for tests and single
+// binaries.
+type Queue interface {
+	// Send adds data to the tasks of queue
+	Send(ctx context.Context, queue string, data []byte) error
+	// Receive delivers the tasks of queue until ctx is done, then closes
+	// the returned channel
+	Receive(ctx context.Context, queue string) (<-chan Delivery, error)
+}
+
+// Delivery is a task taken from a queue. Ack removes it once it is
+// handled, such as by deleting an SQS message; Nack returns it to be
+// delivered again.
+type Delivery struct {
+	Data []byte
+	Ack  func() error
+	Nack func() error
+}
+
+// MemoryQueue is a Queue within one process. Send waits while a queue holds
+// memoryQueueSize tasks.
+type MemoryQueue struct {
+	mu     sync.Mutex
+	queues map[string]chan []byte
+}
+
+// memoryQueueSize is how many tasks each queue of a MemoryQueue holds
+const memoryQueueSize = 1024
+
+// NewMemoryQueue returns an empty MemoryQueue
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{queues: make(map[string]chan []byte)}
+}
+
+// tasks returns the tasks of queue, creating it when it is first used
+func (q *MemoryQueue) tasks(queue string) chan []byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	tasks, ok := q.queues[queue]
+	if !ok {
+		tasks = make(chan []byte, memoryQueueSize)
+		q.queues[queue] = tasks
+	}
+	return tasks
+}
+
+// Send implements Queue
+func (q *MemoryQueue) Send(ctx context.Context, queue string, data []byte) error {
+	select {
+	case q.tasks(queue) <- data:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Receive implements Queue. A task that is not acknowledged when ctx is
+// done goes back to the queue.
+func (q *MemoryQueue) Receive(ctx context.Context, queue string) (<-chan Delivery, error) {
+	tasks := q.tasks(queue)
+	requeue := func(data []byte) func() error {
+		return func() error {
+			go func() { tasks <- data }()
+			return nil
+		}
+	}
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			select {
+			case data := <-tasks:
+				select {
+				case deliveries <- Delivery{Data: data, Ack: func() error { return nil }, Nack: requeue(data)}:
+				case <-ctx.Done():
+					requeue(data)()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return deliveries, nil
+}
+`
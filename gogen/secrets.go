@@ -0,0 +1,61 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// generateGoMarshalJSON writes the MarshalJSON method of a record with
+// fields of secret types, such as password, which encodes the record as
+// encoding/json would without them: they are read from requests but never
+// sent back. Records extending one with secrets get their own, as the
+// base's would be promoted and drop their fields. A record extending one of
+// another file gets none, as its base's fields are not known here.
+func generateGoMarshalJSON(record *grammar.Record) string {
+	if record.Extends != "" && record.Base == nil {
+		return ""
+	}
+	var secrets []string
+	for _, field := range record.AllFields() {
+		if codegen.IsSecret(field.Type.Name) {
+			secrets = append(secrets, field.JSONKey())
+		}
+	}
+	if len(secrets) == 0 {
+		return ""
+	}
+	root := record
+	for root.Base != nil {
+		root = root.Base
+	}
+
+	var fields, values []string
+	if root.Extends == "" && root.HasImplicitID() {
+		fields = append(fields, "\t\tID string `json:\"id\"`\n")
+		values = append(values, "r.ID")
+	}
+	for _, field := range record.AllFields() {
+		if codegen.IsSecret(field.Type.Name) {
+			continue
+		}
+		omitEmpty := ""
+		if field.Type.Optional && !field.Type.Nullable {
+			omitEmpty = ",omitempty"
+		}
+		goName := FieldName(field.Name)
+		fields = append(fields, fmt.Sprintf("\t\t%s %s `json:\"%s%s\"`\n", goName, FieldType(field.Type), field.JSONKey(), omitEmpty))
+		values = append(values, "r."+goName)
+	}
+
+	var code strings.Builder
+	code.WriteString(fmt.Sprintf("// MarshalJSON encodes %s without its secrets, which are only read\n", record.Name))
+	code.WriteString(fmt.Sprintf("// from requests: %s\n", strings.Join(secrets, ", ")))
+	code.WriteString(fmt.Sprintf("func (r %s) MarshalJSON() ([]byte, error) {\n", record.Name))
+	code.WriteString("\treturn json.Marshal(struct {\n")
+	code.WriteString(strings.Join(fields, ""))
+	code.WriteString(fmt.Sprintf("\t}{%s})\n}\n\n", strings.Join(values, ", ")))
+	return code.String()
+}
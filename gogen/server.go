@@ -0,0 +1,310 @@
+package gogen
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// ServerPackage is a generated package the server routes requests to: its
+// import path and the checked files it was generated from
+type ServerPackage struct {
+	Path  string
+	Files []*grammar.File
+}
+
+// LivenessPath and ReadinessPath are where the generated server answers
+// the liveness and readiness probes of Kubernetes and load balancers
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// goFramework is how the server main routes requests with one web
+// framework: the packages it imports besides log, net/http and os, the
+// statements creating its router and serving it on addr, and the formats of
+// a POST and a GET route given its path and handler
+type goFramework struct {
+	imports []string
+	router  string
+	route   string
+	probe   string
+	serve   string
+}
+
+// goFrameworks are the routers GenerateServer supports. The handlers are
+// net/http ones, which chi takes as they are and echo and fiber wrap.
+var goFrameworks = map[string]goFramework{
+	"net/http": {
+		router: "mux := http.NewServeMux()",
+		route:  "mux.Handle(%q, %s)",
+		probe:  "mux.Handle(%q, %s)",
+		serve:  "log.Fatal(http.ListenAndServe(addr, mux))",
+	},
+	"chi": {
+		imports: []string{"github.com/go-chi/chi/v5"},
+		router:  "r := chi.NewRouter()",
+		route:   "r.Post(%q, %s)",
+		probe:   "r.Get(%q, %s)",
+		serve:   "log.Fatal(http.ListenAndServe(addr, r))",
+	},
+	"echo": {
+		imports: []string{"github.com/labstack/echo/v4"},
+		router:  "e := echo.New()",
+		route:   "e.POST(%q, echo.WrapHandler(%s))",
+		probe:   "e.GET(%q, echo.WrapHandler(%s))",
+		serve:   "log.Fatal(e.Start(addr))",
+	},
+	"fiber": {
+		imports: []string{"github.com/gofiber/fiber/v2", "github.com/gofiber/fiber/v2/middleware/adaptor"},
+		router:  "app := fiber.New()",
+		route:   "app.Post(%q, adaptor.HTTPHandler(%s))",
+		probe:   "app.Get(%q, adaptor.HTTPHandler(%s))",
+		serve:   "log.Fatal(app.Listen(addr))",
+	},
+}
+
+// GenerateServer writes the main package of a server that imports every
+// package and serves each function's handler at POST /<name>, the path the
+// OpenAPI spec gives it, on the router of opts.Framework. It listens on
+// $PORT, or 8080 when that is unset. Functions with requires clauses are
+// served through auth, a variable of package main that another file sets.
+// The server answers GET LivenessPath while it runs, and GET ReadinessPath
+// once ready, another such variable, reports no error. Scheduled functions
+// run at their interval from the start, their errors and panics logged.
+// With opts.Logging, the server logs as JSON with log/slog at
+// opts.LogLevel, one line per request to a function. Two functions served
+// at the same path, or a function served at a probe's, are an error.
+func GenerateServer(packages []ServerPackage, opts Options) ([]byte, error) {
+	name := opts.Framework
+	if name == "" {
+		name = "net/http"
+	}
+	framework, ok := goFrameworks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown server framework %q (available: net/http, chi, echo, fiber)", name)
+	}
+
+	sorted := append([]ServerPackage(nil), packages...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	var routes, schedules strings.Builder
+	var packagePaths []string
+	needsAuth := false
+	served := map[string]string{LivenessPath: "the liveness probe", ReadinessPath: "the readiness probe"}
+	for _, pkg := range sorted {
+		used := false
+		for _, file := range pkg.Files {
+			for _, function := range file.Functions {
+				path := "/" + strings.ToLower(function.Name)
+				name := PackageName(file) + "." + exportedName(function.Name)
+				if other, ok := served[path]; ok {
+					return nil, fmt.Errorf("%s and %s are both served at %s; rename one of them", other, name, path)
+				}
+				served[path] = name
+				handler := name + "Handler(nil)"
+				if function.Access != nil {
+					handler = name + "Handler(auth, nil)"
+					needsAuth = true
+				}
+				if opts.Logging {
+					handler = fmt.Sprintf("logRequests(%q, %s)", function.Name, handler)
+				}
+				routes.WriteString("\t" + fmt.Sprintf(framework.route, path, handler) + "\n")
+				used = true
+			}
+			for _, schedule := range file.Schedules {
+				job := PackageName(file) + "." + exportedName(schedule.Function) + "Job"
+				schedules.WriteString(fmt.Sprintf("\tgo every(%s, %q, %s)\n", goInterval(schedule), schedule.Function, job))
+			}
+		}
+		if used {
+			packagePaths = append(packagePaths, pkg.Path)
+		}
+	}
+
+	std := []string{"log", "net/http", "os"}
+	if opts.Logging {
+		std = []string{"log", "log/slog", "net/http", "os"}
+	}
+	if schedules.Len() > 0 || opts.Logging {
+		std = append(std, "time")
+	}
+	external := append(append([]string(nil), framework.imports...), packagePaths...)
+
+	var code strings.Builder
+	code.WriteString("package main\n\n")
+	code.WriteString("import (\n")
+	for _, path := range std {
+		code.WriteString(fmt.Sprintf("\t%q\n", path))
+	}
+	if len(external) > 0 {
+		code.WriteString("\n")
+		for _, path := range external {
+			code.WriteString(fmt.Sprintf("\t%q\n", path))
+		}
+	}
+	code.WriteString(")\n\n")
+	if needsAuth {
+		code.WriteString("// auth checks the callers of functions declared with requires. Set it from\n")
+		code.WriteString("// another file of this package, such as in an init function; while it is\n")
+		code.WriteString("// nil they are answered with 401.\n")
+		code.WriteString("var auth func(next http.Handler, roles []string) http.Handler\n\n")
+	}
+	code.WriteString("// ready reports whether the server can take requests, such as whether its\n")
+	code.WriteString("// database answers. Set it from another file of this package; while it is\n")
+	code.WriteString("// nil the server is ready once it listens.\n")
+	code.WriteString("var ready func() error\n\n")
+	code.WriteString("func main() {\n")
+	if opts.Logging {
+		code.WriteString(fmt.Sprintf("\tslog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: %s})))\n", goLogLevel(opts.LogLevel)))
+	}
+	code.WriteString("\t" + framework.router + "\n")
+	code.WriteString("\t" + fmt.Sprintf(framework.probe, LivenessPath, "http.HandlerFunc(healthz)") + "\n")
+	code.WriteString("\t" + fmt.Sprintf(framework.probe, ReadinessPath, "http.HandlerFunc(readyz)") + "\n")
+	code.WriteString(routes.String())
+	code.WriteString("\n")
+	if schedules.Len() > 0 {
+		code.WriteString(schedules.String())
+		code.WriteString("\n")
+	}
+	code.WriteString("\taddr := \":8080\"\n")
+	code.WriteString("\tif port := os.Getenv(\"PORT\"); port != \"\" {\n")
+	code.WriteString("\t\taddr = \":\" + port\n")
+	code.WriteString("\t}\n")
+	code.WriteString("\tlog.Printf(\"listening on %s\", addr)\n")
+	code.WriteString("\t" + framework.serve + "\n")
+	code.WriteString("}\n\n")
+	code.WriteString("// healthz answers the liveness probe: the server is running\n")
+	code.WriteString("func healthz(w http.ResponseWriter, r *http.Request) {\n")
+	code.WriteString("\tw.Write([]byte(\"ok\\n\"))\n")
+	code.WriteString("}\n\n")
+	code.WriteString("// readyz answers the readiness probe, with 503 while ready fails\n")
+	code.WriteString("func readyz(w http.ResponseWriter, r *http.Request) {\n")
+	code.WriteString("\tif ready != nil {\n")
+	code.WriteString("\t\tif err := ready(); err != nil {\n")
+	code.WriteString("\t\t\thttp.Error(w, err.Error(), http.StatusServiceUnavailable)\n")
+	code.WriteString("\t\t\treturn\n")
+	code.WriteString("\t\t}\n")
+	code.WriteString("\t}\n")
+	code.WriteString("\tw.Write([]byte(\"ok\\n\"))\n")
+	code.WriteString("}\n")
+	if schedules.Len() > 0 {
+		code.WriteString(goEverySource)
+	}
+	if opts.Logging {
+		code.WriteString(goLogRequestsSource)
+	}
+
+	src := []byte(code.String())
+	formatted, err := format.Source(src)
+	if err != nil {
+		return src, err
+	}
+	return codegen.Stamp("//", opts.Header, formatted), nil
+}
+
+// goEverySource runs the scheduled functions of the server main
+const goEverySource = `
+// every runs job each interval until the server stops. A run that fails
+// or panics is logged, and the next one runs as planned.
+func every(interval time.Duration, name string, job func() error) {
+	for range time.Tick(interval) {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("schedule %s panicked: %v", name, r)
+				}
+			}()
+			if err := job(); err != nil {
+				log.Printf("schedule %s failed: %v", name, err)
+			}
+		}()
+	}
+}
+`
+
+// goLogRequestsSource logs the requests the server main serves
+const goLogRequestsSource = `
+// logRequests logs each request to the handler of function once it is
+// answered: its method, path, status, how long it took and its request ID
+func logRequests(function string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		slog.InfoContext(r.Context(), "request",
+			"function", function,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration", time.Since(start),
+			"request_id", r.Header.Get("X-Request-ID"))
+	}
+}
+
+// statusRecorder remembers the status a handler answers with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+`
+
+// goLogLevel is the slog level of an observability.log_level setting
+func goLogLevel(level string) string {
+	switch level {
+	case "debug":
+		return "slog.LevelDebug"
+	case "warn":
+		return "slog.LevelWarn"
+	case "error":
+		return "slog.LevelError"
+	}
+	return "slog.LevelInfo"
+}
+
+// goInterval is the interval of schedule as a Go duration
+func goInterval(schedule *grammar.Schedule) string {
+	if schedule.Unit == "day" {
+		return fmt.Sprintf("%d * 24 * time.Hour", schedule.Every)
+	}
+	return fmt.Sprintf("%d * time.%s", schedule.Every, exportedName(schedule.Unit))
+}
+
+// generateGoScheduledJob writes <Name>Job, which runs a scheduled function
+// for the server main, dropping any value it returns
+func generateGoScheduledJob(function *grammar.Function, schedule *grammar.Schedule, telemetry goTelemetry) string {
+	var code strings.Builder
+	name := exportedName(function.Name)
+	code.WriteString(fmt.Sprintf("// %sJob runs %s for its schedule, %s\n", name, function.Name, schedule.Describe()))
+	code.WriteString(fmt.Sprintf("func %sJob() error {\n", name))
+	code.WriteString(telemetry.jobEntry(function))
+	switch results := goFunctionResults(function); {
+	case results.fails && telemetry.scope != "":
+		if results.typ != "" {
+			code.WriteString(fmt.Sprintf("\t_, err := %s()\n", function.Name))
+		} else {
+			code.WriteString(fmt.Sprintf("\terr := %s()\n", function.Name))
+		}
+		code.WriteString("\tif err != nil {\n")
+		code.WriteString(telemetry.spanError("\t\t"))
+		code.WriteString("\t}\n\treturn err\n")
+	case results.fails && results.typ != "":
+		code.WriteString(fmt.Sprintf("\t_, err := %s()\n\treturn err\n", function.Name))
+	case results.fails:
+		code.WriteString(fmt.Sprintf("\treturn %s()\n", function.Name))
+	default:
+		code.WriteString(fmt.Sprintf("\t%s()\n\treturn nil\n", function.Name))
+	}
+	code.WriteString("}\n\n")
+	return code.String()
+}
@@ -0,0 +1,109 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// goTelemetryImports are the packages instrumented code uses, added to the
+// import candidates when tracing is on
+var goTelemetryImports = []string{
+	"context",
+	"go.opentelemetry.io/otel",
+	"go.opentelemetry.io/otel/attribute",
+	"go.opentelemetry.io/otel/codes",
+	"go.opentelemetry.io/otel/metric",
+	"go.opentelemetry.io/otel/trace",
+}
+
+// goTelemetry instruments the generated code of a package with
+// OpenTelemetry through the global tracer and meter providers, so nothing
+// is recorded until the program installs them. Generated functions take no
+// context, so spans are started by the handlers and scheduled jobs calling
+// them; the functions only count their calls. The zero value, for tracing
+// off, writes nothing.
+type goTelemetry struct {
+	scope string // instrumentation scope, cloudpact/<package>
+}
+
+// newGoTelemetry returns the instrumentation of package pkg, or the zero
+// value unless tracing is on
+func newGoTelemetry(pkg string, tracing bool) goTelemetry {
+	if !tracing {
+		return goTelemetry{}
+	}
+	return goTelemetry{scope: "cloudpact/" + pkg}
+}
+
+// counters declares the counters of function: its calls and, when it has a
+// handler, the requests it serves
+func (t goTelemetry) counters(function *grammar.Function) string {
+	if t.scope == "" {
+		return ""
+	}
+	var code strings.Builder
+	code.WriteString(fmt.Sprintf("// %sCalls counts the calls of %s\n", function.Name, function.Name))
+	code.WriteString(fmt.Sprintf("var %sCalls, _ = otel.Meter(%q).Int64Counter(\"cloudpact.function.calls\", metric.WithDescription(\"Calls of CloudPact functions\"))\n\n", function.Name, t.scope))
+	code.WriteString(fmt.Sprintf("// %sRequests counts the requests %sHandler serves\n", function.Name, exportedName(function.Name)))
+	code.WriteString(fmt.Sprintf("var %sRequests, _ = otel.Meter(%q).Int64Counter(\"cloudpact.http.requests\", metric.WithDescription(\"Requests served by the handlers of CloudPact functions\"))\n\n", function.Name, t.scope))
+	return code.String()
+}
+
+// functionEntry is the statement a function's body starts with, counting
+// the call
+func (t goTelemetry) functionEntry(function *grammar.Function) string {
+	if t.scope == "" {
+		return ""
+	}
+	return fmt.Sprintf("\t%sCalls.Add(context.Background(), 1, %s)\n", function.Name, goFunctionAttribute(function))
+}
+
+// handlerEntry starts the span of a request to function's handler, carrying
+// the request's context on to the rest of the handler, and counts the
+// request
+func (t goTelemetry) handlerEntry(function *grammar.Function) string {
+	if t.scope == "" {
+		return ""
+	}
+	var code strings.Builder
+	code.WriteString(fmt.Sprintf("\t\tctx, span := otel.Tracer(%q).Start(r.Context(), %q, trace.WithSpanKind(trace.SpanKindServer)%s)\n", t.scope, function.Name, goWhyAttribute(function)))
+	code.WriteString("\t\tdefer span.End()\n")
+	code.WriteString("\t\tr = r.WithContext(ctx)\n")
+	code.WriteString(fmt.Sprintf("\t\t%sRequests.Add(ctx, 1, %s)\n", function.Name, goFunctionAttribute(function)))
+	return code.String()
+}
+
+// jobEntry starts the span of a scheduled run of function
+func (t goTelemetry) jobEntry(function *grammar.Function) string {
+	if t.scope == "" {
+		return ""
+	}
+	var code strings.Builder
+	code.WriteString(fmt.Sprintf("\t_, span := otel.Tracer(%q).Start(context.Background(), %q%s)\n", t.scope, function.Name, goWhyAttribute(function)))
+	code.WriteString("\tdefer span.End()\n")
+	return code.String()
+}
+
+// spanError marks the span failed with err, at indent
+func (t goTelemetry) spanError(indent string) string {
+	if t.scope == "" {
+		return ""
+	}
+	return indent + "span.RecordError(err)\n" + indent + "span.SetStatus(codes.Error, err.Error())\n"
+}
+
+// goFunctionAttribute is the metric option naming the function counted
+func goFunctionAttribute(function *grammar.Function) string {
+	return fmt.Sprintf("metric.WithAttributes(attribute.String(\"cloudpact.function\", %q))", function.Name)
+}
+
+// goWhyAttribute is the span start option carrying function's why, with a
+// leading comma, or nothing without one
+func goWhyAttribute(function *grammar.Function) string {
+	if function.Why == "" {
+		return ""
+	}
+	return fmt.Sprintf(", trace.WithAttributes(attribute.String(\"cloudpact.why\", %q))", function.Why)
+}
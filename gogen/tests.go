@@ -0,0 +1,553 @@
+package gogen
+
+import (
+	"fmt"
+	"go/format"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// goSampleUUID is the ID of sample records in generated tests
+const goSampleUUID = "123e4567-e89b-12d3-a456-426614174000"
+
+// goTestImports are the packages generated tests may use
+var goTestImports = []string{"fmt", "reflect", "testing", "time"}
+
+// GenerateTestFile translates a checked file into a Go test file for the
+// package GenerateFile writes. Each record gets a table-driven test of its
+// Validate method, with a valid and an invalid value per validate tag
+// option, and each function a test calling it with sample arguments and
+// checking what it returns.
+func GenerateTestFile(file *grammar.File, opts Options) ([]byte, error) {
+	records := make(map[string]*grammar.Record)
+	for _, record := range file.Records {
+		records[record.Name] = record
+	}
+
+	pkg := PackageName(file)
+	var tests strings.Builder
+	for _, record := range file.Records {
+		// A base record of another file is not known here
+		if record.Extends != "" && record.Base == nil {
+			continue
+		}
+		tests.WriteString(generateGoConstructorTest(record, records))
+		tests.WriteString(generateGoValidationTest(record))
+	}
+	for _, function := range file.Functions {
+		tests.WriteString(generateGoFunctionTest(function, records))
+	}
+
+	// Like GenerateFile, render with every import the tests might need,
+	// then again with only those they use
+	candidates := append(append([]string(nil), goTestImports...), goPackageImports(pkg, opts.Packages)...)
+	src, err := qualifyGoTypes(goFileSource(pkg, candidates, tests.String()), pkg, opts.Packages)
+	if err != nil {
+		return goFileSource(pkg, candidates, tests.String()), err
+	}
+	imports, err := usedGoImports(src)
+	if err != nil {
+		return src, err
+	}
+	src = goFileSource(pkg, imports, tests.String())
+	if src, err = qualifyGoTypes(src, pkg, opts.Packages); err != nil {
+		return goFileSource(pkg, imports, tests.String()), err
+	}
+	formatted, err := format.Source(src)
+	if err != nil {
+		return src, err
+	}
+	return codegen.Stamp("//", opts.Header, formatted), nil
+}
+
+// goFileSource puts body in a file of package pkg importing imports
+func goFileSource(pkg string, imports []string, body string) []byte {
+	var code strings.Builder
+	code.WriteString(fmt.Sprintf("package %s\n\n", pkg))
+	if len(imports) > 0 {
+		code.WriteString("import (\n")
+		for _, path := range imports {
+			code.WriteString(fmt.Sprintf("\t%q\n", path))
+		}
+		code.WriteString(")\n\n")
+	}
+	code.WriteString(body)
+	return []byte(code.String())
+}
+
+// generateGoConstructorTest writes a test calling a record's constructor
+// with sample arguments
+func generateGoConstructorTest(record *grammar.Record, records map[string]*grammar.Record) string {
+	var code strings.Builder
+	name := record.Name
+
+	var args []string
+	for _, field := range goConstructorParams(record) {
+		if value := record.ExampleValue(field.Name); value != nil {
+			args = append(args, goExampleValue(field.Type, value))
+			continue
+		}
+		args = append(args, goSampleArgument(field.Type, records))
+	}
+	call := fmt.Sprintf("New%s(%s)", name, strings.Join(args, ", "))
+
+	id := goGeneratedID(record)
+	if id == "" {
+		code.WriteString(fmt.Sprintf("// TestNew%s checks that New%s returns a valid %s\n", name, name, strings.ToLower(name)))
+		code.WriteString(fmt.Sprintf("func TestNew%s(t *testing.T) {\n", name))
+		code.WriteString(fmt.Sprintf("\tif _, err := %s; err != nil {\n", call))
+		code.WriteString(fmt.Sprintf("\t\tt.Fatalf(\"New%s: %%v\", err)\n", name))
+		code.WriteString("\t}\n")
+		code.WriteString("}\n\n")
+		return code.String()
+	}
+
+	code.WriteString(fmt.Sprintf("// TestNew%s checks that New%s returns valid %ss with distinct IDs\n", name, name, strings.ToLower(name)))
+	code.WriteString(fmt.Sprintf("func TestNew%s(t *testing.T) {\n", name))
+	for _, v := range []string{"first", "second"} {
+		code.WriteString(fmt.Sprintf("\t%s, err := %s\n", v, call))
+		code.WriteString("\tif err != nil {\n")
+		code.WriteString(fmt.Sprintf("\t\tt.Fatalf(\"New%s: %%v\", err)\n", name))
+		code.WriteString("\t}\n")
+	}
+	code.WriteString(fmt.Sprintf("\tif first.%s == second.%s {\n", id, id))
+	code.WriteString(fmt.Sprintf("\t\tt.Fatalf(\"expected distinct IDs, got %%s twice\", first.%s)\n", id))
+	code.WriteString("\t}\n")
+	code.WriteString("}\n\n")
+	return code.String()
+}
+
+// goTestCase is a row of a generated validation test
+type goTestCase struct {
+	name  string // what the row changes, e.g. "email invalid"
+	field string
+	value string // Go expression assigned to the field
+	valid bool
+}
+
+// generateGoValidationTest writes a table-driven test of a record's
+// Validate method. Every row starts from a valid sample record and sets one
+// field.
+func generateGoValidationTest(record *grammar.Record) string {
+	var code strings.Builder
+	name := record.Name
+
+	var cases []goTestCase
+	for _, field := range record.AllFields() {
+		goType := strings.TrimPrefix(FieldType(field.Type), "*")
+		tag := goValidateTag(field.Type)
+		valid, invalid := goSamples(goType, tag)
+		if valid == "" {
+			continue
+		}
+		label := strings.ToLower(field.Name)
+		value := valid
+		if field.Type.Optional {
+			value = goPointerTo(goType, valid)
+			cases = append(cases, goTestCase{name: label + " unset", field: FieldName(field.Name), value: "nil", valid: true})
+		}
+		cases = append(cases, goTestCase{name: label + " valid", field: FieldName(field.Name), value: value, valid: true})
+		for _, bad := range invalid {
+			value := bad.value
+			if field.Type.Optional {
+				value = goPointerTo(goType, bad.value)
+			}
+			cases = append(cases, goTestCase{name: label + " " + bad.name, field: FieldName(field.Name), value: value})
+		}
+	}
+
+	code.WriteString(fmt.Sprintf("// Test%sValidate checks that Validate accepts a sample %s and\n", name, strings.ToLower(name)))
+	code.WriteString("// rejects it once a field breaks its validate tag\n")
+	code.WriteString(fmt.Sprintf("func Test%sValidate(t *testing.T) {\n", name))
+	code.WriteString(fmt.Sprintf("\tsample := func() %s {\n", name))
+	code.WriteString(fmt.Sprintf("\t\treturn %s\n", goSampleRecord(record)))
+	code.WriteString("\t}\n")
+	code.WriteString("\tvalid := sample()\n")
+	code.WriteString("\tif err := valid.Validate(); err != nil {\n")
+	code.WriteString(fmt.Sprintf("\t\tt.Fatalf(\"sample %s is invalid: %%v\", err)\n", strings.ToLower(name)))
+	code.WriteString("\t}\n\n")
+	if len(cases) == 0 {
+		code.WriteString("}\n\n")
+		return code.String()
+	}
+
+	code.WriteString("\ttests := []struct {\n")
+	code.WriteString("\t\tname   string\n")
+	code.WriteString(fmt.Sprintf("\t\tmodify func(r *%s)\n", name))
+	code.WriteString("\t\tvalid  bool\n")
+	code.WriteString("\t}{\n")
+	for _, c := range cases {
+		code.WriteString(fmt.Sprintf("\t\t{%q, func(r *%s) { r.%s = %s }, %t},\n", c.name, name, c.field, c.value, c.valid))
+	}
+	code.WriteString("\t}\n")
+	code.WriteString("\tfor _, tt := range tests {\n")
+	code.WriteString("\t\tt.Run(tt.name, func(t *testing.T) {\n")
+	code.WriteString("\t\t\tr := sample()\n")
+	code.WriteString("\t\t\ttt.modify(&r)\n")
+	code.WriteString("\t\t\terr := r.Validate()\n")
+	code.WriteString("\t\t\tif tt.valid && err != nil {\n")
+	code.WriteString("\t\t\t\tt.Errorf(\"expected a valid record, got %v\", err)\n")
+	code.WriteString("\t\t\t}\n")
+	code.WriteString("\t\t\tif !tt.valid && err == nil {\n")
+	code.WriteString("\t\t\t\tt.Error(\"expected Validate to fail\")\n")
+	code.WriteString("\t\t\t}\n")
+	code.WriteString("\t\t})\n")
+	code.WriteString("\t}\n")
+	code.WriteString("}\n\n")
+	return code.String()
+}
+
+// generateGoFunctionTest writes a test calling a function twice with sample
+// arguments. It fails when the function panics, returns something else the
+// second time or returns a record Validate rejects; the behavior its why
+// clause documents is checked by the file's test blocks.
+func generateGoFunctionTest(function *grammar.Function, records map[string]*grammar.Record) string {
+	var code strings.Builder
+	data := goFunctionData(function)
+	name := strings.ToUpper(function.Name[:1]) + function.Name[1:]
+
+	var args []string
+	for _, param := range function.Parameters {
+		args = append(args, goSampleArgument(param.Type, records))
+	}
+	for _, param := range data.Params[len(function.Parameters):] {
+		args = append(args, goZeroValue(param.Type))
+	}
+	call := fmt.Sprintf("%s(%s)", function.Name, strings.Join(args, ", "))
+
+	code.WriteString(fmt.Sprintf("// Test%s calls %s twice with sample arguments and checks it\n", name, function.Name))
+	code.WriteString("// returns the same both times")
+	record := goReturnedRecord(function, records)
+	if record != nil {
+		code.WriteString(fmt.Sprintf(", and %s that passes Validate", goArticle(record.Name)))
+	}
+	code.WriteString("\n")
+	code.WriteString(fmt.Sprintf("func Test%s(t *testing.T) {\n", name))
+	if function.Why != "" {
+		code.WriteString(fmt.Sprintf("\t// why: %s\n", function.Why))
+	}
+	results := goFunctionResults(function)
+	switch {
+	case results.fails && results.typ != "":
+		code.WriteString(fmt.Sprintf("\tgot, err := %s\n", call))
+		code.WriteString(fmt.Sprintf("\tagain, againErr := %s\n", call))
+		code.WriteString("\tif !reflect.DeepEqual(got, again) || fmt.Sprint(err) != fmt.Sprint(againErr) {\n")
+		code.WriteString(fmt.Sprintf("\t\tt.Errorf(\"%s returned %%v, %%v, then %%v, %%v\", got, err, again, againErr)\n", function.Name))
+		code.WriteString("\t}\n")
+	case results.fails:
+		code.WriteString(fmt.Sprintf("\terr := %s\n", call))
+		code.WriteString(fmt.Sprintf("\tagain := %s\n", call))
+		code.WriteString("\tif fmt.Sprint(err) != fmt.Sprint(again) {\n")
+		code.WriteString(fmt.Sprintf("\t\tt.Errorf(\"%s returned %%v, then %%v\", err, again)\n", function.Name))
+		code.WriteString("\t}\n")
+	case results.typ != "":
+		code.WriteString(fmt.Sprintf("\tgot := %s\n", call))
+		code.WriteString(fmt.Sprintf("\tif again := %s; !reflect.DeepEqual(got, again) {\n", call))
+		code.WriteString(fmt.Sprintf("\t\tt.Errorf(\"%s returned %%v, then %%v\", got, again)\n", function.Name))
+		code.WriteString("\t}\n")
+	default:
+		code.WriteString(fmt.Sprintf("\t%s\n", call))
+	}
+	if record != nil {
+		var conds []string
+		if results.fails {
+			conds = append(conds, "err == nil")
+		}
+		if function.ReturnType.Optional {
+			conds = append(conds, "got != nil")
+		}
+		indent := "\t"
+		if len(conds) > 0 {
+			code.WriteString(fmt.Sprintf("\tif %s {\n", strings.Join(conds, " && ")))
+			indent = "\t\t"
+		}
+		code.WriteString(fmt.Sprintf("%sif err := got.Validate(); err != nil {\n", indent))
+		code.WriteString(fmt.Sprintf("%s\tt.Errorf(\"%s returned an invalid %s: %%v\", err)\n", indent, function.Name, strings.ToLower(record.Name)))
+		code.WriteString(fmt.Sprintf("%s}\n", indent))
+		if len(conds) > 0 {
+			code.WriteString("\t}\n")
+		}
+	}
+	code.WriteString("}\n\n")
+	return code.String()
+}
+
+// goReturnedRecord is the record of the file function returns, or nil when
+// it returns something else, such as a list of records
+func goReturnedRecord(function *grammar.Function, records map[string]*grammar.Record) *grammar.Record {
+	if function.ReturnType == nil {
+		return nil
+	}
+	record := records[function.ReturnType.Name]
+	if record == nil || record.Extends != "" && record.Base == nil {
+		return nil
+	}
+	return record
+}
+
+// goSampleArgument is a sample value of a parameter of type t: a sample
+// record for the records of the file, otherwise a value that passes the
+// checks of t's validate tag
+func goSampleArgument(t *grammar.Type, records map[string]*grammar.Record) string {
+	goType := FieldType(t)
+	if t.Optional {
+		return "nil"
+	}
+	if record, ok := records[t.Name]; ok && (record.Extends == "" || record.Base != nil) {
+		return goSampleRecord(record)
+	}
+	if valid, _ := goSamples(goType, goValidateTag(t)); valid != "" {
+		return valid
+	}
+	return goZeroValue(goType)
+}
+
+// goSampleRecord is a composite literal of record that Validate accepts,
+// holding the values its example gives. Other optional fields and fields
+// of other types are left unset.
+func goSampleRecord(record *grammar.Record) string {
+	return goSampleRecordOf(record, record)
+}
+
+// goSampleRecordOf is goSampleRecord for record, or a record it extends,
+// taking the values the example of sample gives
+func goSampleRecordOf(record, sample *grammar.Record) string {
+	var fields []string
+	if record.Base != nil {
+		fields = append(fields, fmt.Sprintf("%s: %s", record.Base.Name, goSampleRecordOf(record.Base, sample)))
+	} else if record.HasImplicitID() {
+		id := strconv.Quote(goSampleUUID)
+		if value := sample.ExampleValue("id"); value != nil {
+			id = goLiteral(value)
+		}
+		fields = append(fields, "ID: "+id)
+	}
+	if value := sample.ExampleValue("version"); value != nil && record.Versioned {
+		fields = append(fields, "Version: "+goLiteral(value))
+	}
+	for _, field := range record.Fields {
+		if value := sample.ExampleValue(field.Name); value != nil {
+			if value.Value != nil {
+				fields = append(fields, fmt.Sprintf("%s: %s", FieldName(field.Name), goExampleValue(field.Type, value)))
+			}
+			continue
+		}
+		if field.Type.Optional {
+			continue
+		}
+		if valid, _ := goSamples(FieldType(field.Type), goValidateTag(field.Type)); valid != "" {
+			fields = append(fields, fmt.Sprintf("%s: %s", FieldName(field.Name), valid))
+		}
+	}
+	return fmt.Sprintf("%s{%s}", record.Name, strings.Join(fields, ", "))
+}
+
+// goExampleValue writes the value an example gives a field of type t as Go.
+// Temporal fields are given as text, which becomes a time.Time or
+// time.Duration; the analyzer checked it parses.
+func goExampleValue(t *grammar.Type, value *grammar.LiteralExpression) string {
+	goType := strings.TrimPrefix(FieldType(t), "*")
+	code := goLiteral(value)
+	if text, ok := value.Value.(string); ok {
+		switch goType {
+		case "time.Time":
+			if at, err := grammar.ParseTime(text); err == nil {
+				code = goTimeLiteral(at)
+			}
+		case "time.Duration":
+			if d, err := time.ParseDuration(text); err == nil {
+				code = goDurationLiteral(d)
+			}
+		}
+	}
+	if t.Optional && value.Value != nil {
+		return goPointerTo(goType, code)
+	}
+	return code
+}
+
+// goTimeLiteral writes at as a call to time.Date in UTC
+func goTimeLiteral(at time.Time) string {
+	at = at.UTC()
+	return fmt.Sprintf("time.Date(%d, time.%s, %d, %d, %d, %d, %d, time.UTC)",
+		at.Year(), at.Month(), at.Day(), at.Hour(), at.Minute(), at.Second(), at.Nanosecond())
+}
+
+// goDurationLiteral writes d in the largest whole unit it is a multiple of
+func goDurationLiteral(d time.Duration) string {
+	for _, unit := range []struct {
+		name string
+		size time.Duration
+	}{{"time.Hour", time.Hour}, {"time.Minute", time.Minute}, {"time.Second", time.Second}, {"time.Millisecond", time.Millisecond}} {
+		if d != 0 && d%unit.size == 0 {
+			return fmt.Sprintf("%d * %s", d/unit.size, unit.name)
+		}
+	}
+	return fmt.Sprintf("time.Duration(%d)", int64(d))
+}
+
+// goPointerTo takes the address of a sample value of goType
+func goPointerTo(goType, value string) string {
+	return fmt.Sprintf("func() *%s { v := %s; return &v }()", goType, value)
+}
+
+// goInvalidSample is a value breaking one validate tag option
+type goInvalidSample struct {
+	name  string
+	value string
+}
+
+// goSamples returns a Go expression of type goType that passes every check
+// of tag, and values breaking each check in turn. valid is empty for types
+// Validate does not check, such as records. A list or map gets one element
+// of its zero value, since Validate only checks it has one.
+func goSamples(goType, tag string) (valid string, invalid []goInvalidSample) {
+	options := make(map[string]string)
+	for _, option := range strings.Split(tag, ",") {
+		key, arg, _ := strings.Cut(option, "=")
+		options[key] = arg
+	}
+
+	switch goType {
+	case "int", "float64":
+		valid = "1"
+		if min, ok := options["min"]; ok {
+			valid = goBound(goType, min, math.Ceil)
+		} else if max, ok := options["max"]; ok {
+			valid = goBound(goType, max, math.Floor)
+		}
+		if min, ok := options["min"]; ok {
+			invalid = append(invalid, goInvalidSample{"below min", goBeyond(goType, min, -1)})
+		}
+		if max, ok := options["max"]; ok {
+			invalid = append(invalid, goInvalidSample{"above max", goBeyond(goType, max, 1)})
+		}
+		return valid, invalid
+	case "bool":
+		return "true", nil
+	case "time.Time":
+		return "time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC)", []goInvalidSample{{"empty", "time.Time{}"}}
+	case "time.Duration":
+		return "time.Minute", nil
+	case "string":
+	default:
+		if elem, ok := strings.CutPrefix(goType, "[]"); ok {
+			valid = fmt.Sprintf("%s{%s}", goType, goZeroValue(elem))
+		} else if strings.HasPrefix(goType, "map[") {
+			key, elem, _ := strings.Cut(strings.TrimPrefix(goType, "map["), "]")
+			valid = fmt.Sprintf("%s{%s: %s}", goType, goZeroValue(key), goZeroValue(elem))
+		} else {
+			return "", nil
+		}
+		if hasOption(options, "required") {
+			invalid = append(invalid, goInvalidSample{"empty", "nil"})
+		}
+		return valid, invalid
+	}
+
+	// Strings start from a value of their semantic type
+	sample := "sample"
+	switch {
+	case hasOption(options, "email"):
+		sample = "user@example.com"
+		if domain, ok := options["endswith"]; ok {
+			sample = "user" + domain
+		}
+		invalid = append(invalid, goInvalidSample{"invalid", `"not-an-email"`})
+	case hasOption(options, "url"):
+		sample = "https://example.com"
+		invalid = append(invalid, goInvalidSample{"invalid", `"not a url"`})
+	case hasOption(options, "uuid"):
+		sample = goSampleUUID
+		invalid = append(invalid, goInvalidSample{"invalid", `"not-a-uuid"`})
+	case hasOption(options, "e164"):
+		sample = "+15555550100"
+		invalid = append(invalid, goInvalidSample{"invalid", `"555-0100"`})
+	case hasOption(options, "alpha"):
+		sample = "US"
+		invalid = append(invalid, goInvalidSample{"not letters", `"1A"`})
+	}
+	if n, ok := goIntOption(options, "len"); ok {
+		if len(sample) != n {
+			sample = strings.Repeat("1", n)
+			if hasOption(options, "alpha") {
+				sample = strings.Repeat("A", n)
+			}
+		}
+		invalid = append(invalid, goInvalidSample{"wrong length", strconv.Quote(sample + sample[:1])})
+	}
+	if n, ok := goIntOption(options, "min"); ok {
+		if len(sample) < n {
+			sample = strings.Repeat("a", n-len(sample)) + sample
+		}
+		if n > 0 {
+			invalid = append(invalid, goInvalidSample{"too short", strconv.Quote(sample[:n-1])})
+		}
+	}
+	if n, ok := goIntOption(options, "max"); ok {
+		if len(sample) > n {
+			sample = sample[len(sample)-n:]
+		}
+		invalid = append(invalid, goInvalidSample{"too long", strconv.Quote(strings.Repeat("a", n+1))})
+	}
+	if domain, ok := options["endswith"]; ok && !hasOption(options, "email") {
+		sample += domain
+	}
+	if domain, ok := options["endswith"]; ok {
+		invalid = append(invalid, goInvalidSample{"wrong domain", strconv.Quote("user@" + strings.TrimPrefix(domain, "@") + ".invalid")})
+	}
+	if hasOption(options, "required") {
+		invalid = append([]goInvalidSample{{"empty", `""`}}, invalid...)
+	}
+	return strconv.Quote(sample), invalid
+}
+
+func hasOption(options map[string]string, key string) bool {
+	_, ok := options[key]
+	return ok
+}
+
+// goIntOption reads the whole-number argument of a tag option
+func goIntOption(options map[string]string, key string) (int, bool) {
+	arg, ok := options[key]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(arg)
+	return n, err == nil
+}
+
+// goBound is the bound arg as a goType value, rounded inward for whole
+// numbers by round
+func goBound(goType, arg string, round func(float64) float64) string {
+	f, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return arg
+	}
+	if goType == "int" {
+		return strconv.FormatFloat(round(f), 'f', -1, 64)
+	}
+	return codegen.FloatLiteral(f)
+}
+
+// goBeyond is a goType value just past the bound arg, in direction
+func goBeyond(goType, arg string, direction float64) string {
+	f, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return arg
+	}
+	if goType == "int" {
+		if direction < 0 {
+			f = math.Ceil(f)
+		} else {
+			f = math.Floor(f)
+		}
+		return strconv.FormatFloat(f+direction, 'f', -1, 64)
+	}
+	return codegen.FloatLiteral(f + direction)
+}
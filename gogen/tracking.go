@@ -0,0 +1,109 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// generateGoChangeTracking adds setters that record modified fields, and a
+// <Record>Patch type so PATCH requests carry only what changed
+func generateGoChangeTracking(record *grammar.Record) string {
+	var code strings.Builder
+	name := record.Name
+	patch := name + "Patch"
+
+	// Patch DTO: nil fields are left unchanged
+	code.WriteString(fmt.Sprintf("// %s holds a partial %s update; nil fields are left unchanged\n", patch, name))
+	code.WriteString(fmt.Sprintf("type %s struct {\n", patch))
+	for _, field := range record.Fields {
+		code.WriteString(fmt.Sprintf("\t%s %s `json:\"%s,omitempty\"`\n", FieldName(field.Name), goPatchFieldType(field.Type), field.JSONKey()))
+	}
+	code.WriteString("}\n\n")
+
+	// Setters
+	for _, field := range record.Fields {
+		goName := FieldName(field.Name)
+		setter := "Set" + goName
+		code.WriteString(fmt.Sprintf("// %s updates %s and records it as changed\n", setter, goName))
+		code.WriteString(fmt.Sprintf("func (r *%s) %s(value %s) {\n", name, setter, FieldType(field.Type)))
+		code.WriteString(fmt.Sprintf("\tr.%s = value\n", goName))
+		code.WriteString(fmt.Sprintf("\tr.markChanged(%q)\n", field.JSONKey()))
+		code.WriteString("}\n\n")
+	}
+
+	code.WriteString(fmt.Sprintf("func (r *%s) markChanged(field string) {\n", name))
+	code.WriteString("\tif r.changedFields == nil {\n")
+	code.WriteString("\t\tr.changedFields = make(map[string]bool)\n")
+	code.WriteString("\t}\n")
+	code.WriteString("\tr.changedFields[field] = true\n")
+	code.WriteString("}\n\n")
+
+	// Changed lists JSON names in declaration order
+	var fieldNames []string
+	for _, field := range record.Fields {
+		fieldNames = append(fieldNames, fmt.Sprintf("%q", field.JSONKey()))
+	}
+	code.WriteString("// Changed lists the JSON names of fields modified since the last ClearChanges\n")
+	code.WriteString(fmt.Sprintf("func (r *%s) Changed() []string {\n", name))
+	code.WriteString("\tvar changed []string\n")
+	code.WriteString(fmt.Sprintf("\tfor _, field := range []string{%s} {\n", strings.Join(fieldNames, ", ")))
+	code.WriteString("\t\tif r.changedFields[field] {\n")
+	code.WriteString("\t\t\tchanged = append(changed, field)\n")
+	code.WriteString("\t\t}\n")
+	code.WriteString("\t}\n")
+	code.WriteString("\treturn changed\n")
+	code.WriteString("}\n\n")
+
+	code.WriteString("// ClearChanges forgets recorded changes, e.g. once they have been saved\n")
+	code.WriteString(fmt.Sprintf("func (r *%s) ClearChanges() {\n", name))
+	code.WriteString("\tr.changedFields = nil\n")
+	code.WriteString("}\n\n")
+
+	// Patch builds the DTO from the changed fields
+	code.WriteString(fmt.Sprintf("// Patch returns the changed fields as %s\n", goArticle(patch)))
+	code.WriteString(fmt.Sprintf("func (r *%s) Patch() %s {\n", name, patch))
+	code.WriteString(fmt.Sprintf("\tvar p %s\n", patch))
+	for _, field := range record.Fields {
+		goName := FieldName(field.Name)
+		code.WriteString(fmt.Sprintf("\tif r.changedFields[%q] {\n", field.JSONKey()))
+		if field.Type.Optional {
+			code.WriteString(fmt.Sprintf("\t\tp.%s = r.%s\n", goName, goName))
+		} else {
+			code.WriteString(fmt.Sprintf("\t\tvalue := r.%s\n", goName))
+			code.WriteString(fmt.Sprintf("\t\tp.%s = &value\n", goName))
+		}
+		code.WriteString("\t}\n")
+	}
+	code.WriteString("\treturn p\n")
+	code.WriteString("}\n\n")
+
+	// Apply copies the fields present in the patch onto a record
+	code.WriteString("// Apply copies the fields set in p onto r and records them as changed\n")
+	code.WriteString(fmt.Sprintf("func (p %s) Apply(r *%s) {\n", patch, name))
+	for _, field := range record.Fields {
+		goName := FieldName(field.Name)
+		code.WriteString(fmt.Sprintf("\tif p.%s != nil {\n", goName))
+		if field.Type.Optional {
+			code.WriteString(fmt.Sprintf("\t\tr.%s = p.%s\n", goName, goName))
+		} else {
+			code.WriteString(fmt.Sprintf("\t\tr.%s = *p.%s\n", goName, goName))
+		}
+		code.WriteString(fmt.Sprintf("\t\tr.markChanged(%q)\n", field.JSONKey()))
+		code.WriteString("\t}\n")
+	}
+	code.WriteString("}\n\n")
+
+	return code.String()
+}
+
+// goPatchFieldType is the pointer type a patch uses for a field; optional
+// fields are already pointers
+func goPatchFieldType(t *grammar.Type) string {
+	goType := FieldType(t)
+	if t.Optional {
+		return goType
+	}
+	return "*" + goType
+}
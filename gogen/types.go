@@ -0,0 +1,153 @@
+package gogen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// FieldType maps a CloudPact type to Go, using pointers for optional fields
+func FieldType(t *grammar.Type) string {
+	goType := mapCloudPactTypeToGo(t.Name)
+	if t.Name == "list" && t.Element != nil {
+		goType = "[]" + FieldType(t.Element)
+	}
+	if t.Name == "map" && t.Key != nil {
+		goType = fmt.Sprintf("map[%s]%s", FieldType(t.Key), FieldType(t.Value))
+	}
+	if t.Optional {
+		return "*" + goType
+	}
+	return goType
+}
+
+// FieldName is the Go name of a record or model field: its CloudPact name
+// with the first letter upper-cased, so encoding/json and other packages
+// see it. The json tag keeps the CloudPact name on the wire.
+func FieldName(name string) string {
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// goResolvedType maps an analyzer-resolved type to Go
+func goResolvedType(t *grammar.Type) string {
+	if t == nil {
+		return "interface{}"
+	}
+	return FieldType(t)
+}
+
+// goZeroValue returns the zero value literal for a generated Go type
+func goZeroValue(goType string) string {
+	switch {
+	case strings.HasPrefix(goType, "*"), goType == "interface{}":
+		return "nil"
+	case goType == "string":
+		return `""`
+	case goType == "bool":
+		return "false"
+	case goType == "int", goType == "float64", goType == "time.Duration":
+		return "0"
+	default:
+		return goType + "{}"
+	}
+}
+
+// Enhanced type mapping functions with semantic types
+func mapCloudPactTypeToGo(cpType string) string {
+	switch strings.ToLower(cpType) {
+	// Basic types
+	case "int", "integer":
+		return "int"
+	case "float", "number":
+		return "float64"
+	case "bool", "boolean":
+		return "bool"
+	case "text", "string":
+		return "string"
+
+	// Semantic types - all map to string but with validation
+	case "email", "url", "uuid", "phone":
+		return "string"
+	case "address", "zip_code", "country_code", "state_code":
+		return "string"
+	case "password", "token", "api_key":
+		return "string"
+	case "html", "markdown", "json":
+		return "string"
+
+	// Currency types
+	case "usd_currency", "eur_currency", "percentage":
+		return "float64"
+
+	// Date/time types
+	case "date", "datetime", "timestamp":
+		return "time.Time"
+	case "time":
+		return "string" // Store as string for simplicity
+	case "duration":
+		return "time.Duration"
+
+	// Default
+	default:
+		if codegen.IsRecordTypeName(cpType) {
+			return cpType
+		}
+		return "string"
+	}
+}
+
+// goValidateTag is the validate tag of a record field of type t
+func goValidateTag(t *grammar.Type) string {
+	tag := codegen.ValidationTag(t.Name)
+
+	// Optional fields may be absent from the payload
+	if t.Optional {
+		tag = "omitempty" + strings.TrimPrefix(tag, "required")
+	}
+	return withConstraintTags(tag, t)
+}
+
+// withConstraintTags adds a type's constraints to a validate tag, replacing
+// the semantic type's own min or max. The validator reads min and max as a
+// length for strings.
+func withConstraintTags(tag string, t *grammar.Type) string {
+	options := strings.Split(tag, ",")
+	for _, name := range codegen.FieldConstraints {
+		value, ok := t.Constraints[name]
+		if !ok {
+			continue
+		}
+		key := strings.TrimSuffix(name, "length")
+		option := fmt.Sprintf("%s=%v", key, value)
+		if name == grammar.ConstraintDomain {
+			key, option = "endswith", fmt.Sprintf("endswith=@%v", value)
+		}
+		replaced := false
+		for i, existing := range options {
+			if strings.HasPrefix(existing, key+"=") {
+				options[i], replaced = option, true
+			}
+		}
+		if !replaced {
+			options = append(options, option)
+		}
+	}
+	return strings.Join(options, ",")
+}
+
+// goLiteral writes a constant as Go source
+func goLiteral(e *grammar.LiteralExpression) string {
+	switch v := e.Value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return codegen.FloatLiteral(v)
+	case nil:
+		return "nil"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
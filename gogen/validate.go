@@ -0,0 +1,158 @@
+package gogen
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// goPatterns are the regular expressions of the validate tag options that
+// need one, keyed by option
+var goPatterns = map[string]struct{ suffix, expr string }{
+	"uuid":  {"UUIDPattern", `^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`},
+	"e164":  {"PhonePattern", `^\+[1-9]\d{1,14}$`},
+	"alpha": {"AlphaPattern", `^[a-zA-Z]+$`},
+}
+
+// goCheck is an option of a validate tag as Go code. cond holds when the
+// value breaks the option, after init has run when there is one.
+type goCheck struct {
+	init    string
+	cond    string
+	message string
+}
+
+// goChecks translates the options of a validate tag into checks of value, a
+// Go expression of type goType. Options that do not apply to the type, such
+// as required on a number, where zero is a valid amount, are left out; a
+// required list or map must have an element.
+// Patterns are named with prefix and added to patterns.
+func goChecks(value, goType, tag, prefix string, patterns map[string]string) []goCheck {
+	numeric := goType == "int" || goType == "float64"
+	collection := strings.HasPrefix(goType, "[]") || strings.HasPrefix(goType, "map[")
+	var checks []goCheck
+	for _, option := range strings.Split(tag, ",") {
+		key, arg, _ := strings.Cut(option, "=")
+		if goType != "string" && !(numeric && (key == "min" || key == "max")) && !((goType == "time.Time" || collection) && key == "required") {
+			continue
+		}
+
+		switch key {
+		case "required":
+			switch {
+			case goType == "time.Time":
+				checks = append(checks, goCheck{cond: value + ".IsZero()", message: "is required"})
+			case collection:
+				checks = append(checks, goCheck{cond: fmt.Sprintf("len(%s) == 0", value), message: "is required"})
+			default:
+				checks = append(checks, goCheck{cond: value + ` == ""`, message: "is required"})
+			}
+		case "email":
+			checks = append(checks, goCheck{init: fmt.Sprintf("_, err := mail.ParseAddress(%s)", value), cond: "err != nil", message: "must be an email address"})
+		case "url":
+			checks = append(checks, goCheck{init: fmt.Sprintf("u, err := url.Parse(%s)", value), cond: `err != nil || u.Scheme == "" || u.Host == ""`, message: "must be an absolute URL"})
+		case "uuid", "e164", "alpha":
+			name := prefix + goPatterns[key].suffix
+			patterns[name] = goPatterns[key].expr
+			message := map[string]string{"uuid": "must be a UUID", "e164": "must be an E.164 phone number", "alpha": "must contain only letters"}[key]
+			checks = append(checks, goCheck{cond: fmt.Sprintf("!%s.MatchString(%s)", name, value), message: message})
+		case "len":
+			checks = append(checks, goCheck{cond: fmt.Sprintf("utf8.RuneCountInString(%s) != %s", value, arg), message: fmt.Sprintf("must be %s characters", arg)})
+		case "min", "max":
+			operator, bound := "<", "at least"
+			if key == "max" {
+				operator, bound = ">", "at most"
+			}
+			switch {
+			case !numeric:
+				checks = append(checks, goCheck{cond: fmt.Sprintf("utf8.RuneCountInString(%s) %s %s", value, operator, arg), message: fmt.Sprintf("must be %s %s characters", bound, arg)})
+			case goType == "int" && strings.ContainsAny(arg, ".eE"):
+				checks = append(checks, goCheck{cond: fmt.Sprintf("float64(%s) %s %s", value, operator, arg), message: fmt.Sprintf("must be %s %s", bound, arg)})
+			default:
+				checks = append(checks, goCheck{cond: fmt.Sprintf("%s %s %s", value, operator, arg), message: fmt.Sprintf("must be %s %s", bound, arg)})
+			}
+		case "endswith":
+			checks = append(checks, goCheck{cond: fmt.Sprintf("!strings.HasSuffix(%s, %s)", value, strconv.Quote(arg)), message: "must end with " + arg})
+		}
+	}
+	return checks
+}
+
+// generateGoValidation adds a Validate method checking each field against
+// its validate tag, so records can be checked without a validator library
+func generateGoValidation(record *grammar.Record) string {
+	var body strings.Builder
+	name := record.Name
+	prefix := strings.ToLower(name[:1]) + name[1:]
+	patterns := make(map[string]string)
+
+	if record.Extends != "" {
+		body.WriteString(fmt.Sprintf("\tif err := r.%s.Validate(); err != nil {\n", record.Extends))
+		body.WriteString("\t\tproblems = append(problems, err)\n")
+		body.WriteString("\t}\n")
+	} else if record.HasImplicitID() {
+		writeGoChecks(&body, "\t", "id", goChecks("r.ID", "string", "required,uuid", prefix, patterns))
+	}
+	for _, field := range record.Fields {
+		goType := strings.TrimPrefix(FieldType(field.Type), "*")
+		value := "r." + FieldName(field.Name)
+		if field.Type.Optional {
+			value = "*" + value
+		}
+		checks := goChecks(value, goType, goValidateTag(field.Type), prefix, patterns)
+		if len(checks) == 0 {
+			continue
+		}
+		if field.Type.Optional {
+			body.WriteString(fmt.Sprintf("\tif r.%s != nil {\n", FieldName(field.Name)))
+			writeGoChecks(&body, "\t\t", field.JSONKey(), checks)
+			body.WriteString("\t}\n")
+			continue
+		}
+		writeGoChecks(&body, "\t", field.JSONKey(), checks)
+	}
+
+	var code strings.Builder
+	if len(patterns) > 0 {
+		names := make([]string, 0, len(patterns))
+		for pattern := range patterns {
+			names = append(names, pattern)
+		}
+		sort.Strings(names)
+		code.WriteString("var (\n")
+		for _, pattern := range names {
+			code.WriteString(fmt.Sprintf("\t%s = regexp.MustCompile(`%s`)\n", pattern, patterns[pattern]))
+		}
+		code.WriteString(")\n\n")
+	}
+	code.WriteString(fmt.Sprintf("// Validate checks the fields of %s against their validate tags and\n", name))
+	code.WriteString("// reports every problem found\n")
+	code.WriteString(fmt.Sprintf("func (r *%s) Validate() error {\n", name))
+	code.WriteString("\tvar problems []error\n")
+	code.WriteString(body.String())
+	code.WriteString("\treturn errors.Join(problems...)\n")
+	code.WriteString("}\n\n")
+	return code.String()
+}
+
+// writeGoChecks writes the checks of field at indent as one if-else chain,
+// so each field reports its first problem only
+func writeGoChecks(code *strings.Builder, indent, field string, checks []goCheck) {
+	for i, check := range checks {
+		if i == 0 {
+			code.WriteString(indent + "if ")
+		} else {
+			code.WriteString(" else if ")
+		}
+		if check.init != "" {
+			code.WriteString(check.init + "; ")
+		}
+		code.WriteString(check.cond + " {\n")
+		code.WriteString(fmt.Sprintf("%s\tproblems = append(problems, errors.New(%q))\n", indent, field+" "+check.message))
+		code.WriteString(indent + "}")
+	}
+	code.WriteString("\n")
+}
@@ -0,0 +1,87 @@
+package gogen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// generateGoVersioning adds optimistic concurrency to a versioned record:
+// an ETag derived from its version, a GET handler that sends it, and a PUT
+// handler that requires a matching If-Match header
+func generateGoVersioning(record *grammar.Record) string {
+	var code strings.Builder
+	name := record.Name
+	lower := strings.ToLower(name)
+	conflict := fmt.Sprintf("Err%sConflict", name)
+	// The analyzer requires an identity of versioned records
+	id := "ID"
+	if key := record.KeyField(); key != nil {
+		id = FieldName(key.Name)
+	}
+
+	code.WriteString(fmt.Sprintf("// %s is returned by save functions when the stored %s has a newer version\n", conflict, name))
+	code.WriteString(fmt.Sprintf("var %s = errors.New(\"%s was modified concurrently\")\n\n", conflict, lower))
+
+	code.WriteString(fmt.Sprintf("// ETag identifies this version of the %s for If-Match requests\n", name))
+	code.WriteString(fmt.Sprintf("func (r *%s) ETag() string {\n", name))
+	code.WriteString("\treturn fmt.Sprintf(\"\\\"%d\\\"\", r.Version)\n")
+	code.WriteString("}\n\n")
+
+	// GET sends the ETag clients echo back in If-Match
+	code.WriteString(fmt.Sprintf("// Get%sHandler serves %s with its ETag. load returns nil when no\n", name, goArticle(lower)))
+	code.WriteString("// record has the id in the last path segment.\n")
+	code.WriteString(fmt.Sprintf("func Get%sHandler(load func(id string) (*%s, error)) http.HandlerFunc {\n", name, name))
+	writeGoErrorWriter(&code)
+	code.WriteString("\treturn func(w http.ResponseWriter, r *http.Request) {\n")
+	code.WriteString("\t\tif r.Method != http.MethodGet {\n")
+	code.WriteString(goErrorReply("\t\t\t", "http.StatusMethodNotAllowed", "method_not_allowed", `"method not allowed"`))
+	code.WriteString("\t\t}\n")
+	writeGoRecordLoad(&code)
+	code.WriteString("\t\tw.Header().Set(\"ETag\", record.ETag())\n")
+	code.WriteString("\t\tw.Header().Set(\"Content-Type\", \"application/json\")\n")
+	code.WriteString("\t\tjson.NewEncoder(w).Encode(record)\n")
+	code.WriteString("\t}\n")
+	code.WriteString("}\n\n")
+
+	// PUT only replaces the version the client last saw
+	code.WriteString(fmt.Sprintf("// Put%sHandler replaces %s when If-Match carries its current ETag.\n", name, goArticle(lower)))
+	code.WriteString(fmt.Sprintf("// save must only store the record if the stored version is still one less,\n// and return %s otherwise.\n", conflict))
+	code.WriteString(fmt.Sprintf("func Put%sHandler(load func(id string) (*%s, error), save func(*%s) error) http.HandlerFunc {\n", name, name, name))
+	writeGoErrorWriter(&code)
+	code.WriteString("\treturn func(w http.ResponseWriter, r *http.Request) {\n")
+	code.WriteString("\t\tif r.Method != http.MethodPut {\n")
+	code.WriteString(goErrorReply("\t\t\t", "http.StatusMethodNotAllowed", "method_not_allowed", `"method not allowed"`))
+	code.WriteString("\t\t}\n")
+	code.WriteString("\t\tifMatch := r.Header.Get(\"If-Match\")\n")
+	code.WriteString("\t\tif ifMatch == \"\" {\n")
+	code.WriteString(goErrorReply("\t\t\t", "http.StatusPreconditionRequired", "precondition_required", `"If-Match header required"`))
+	code.WriteString("\t\t}\n")
+	writeGoRecordLoad(&code)
+	code.WriteString("\t\tif ifMatch != record.ETag() && ifMatch != \"*\" {\n")
+	code.WriteString(goErrorReply("\t\t\t", "http.StatusPreconditionFailed", "precondition_failed", strconv.Quote(lower+" has changed; reload it and retry")))
+	code.WriteString("\t\t}\n\n")
+	code.WriteString(fmt.Sprintf("\t\tvar updated %s\n", name))
+	code.WriteString("\t\tif err := json.NewDecoder(r.Body).Decode(&updated); err != nil {\n")
+	code.WriteString(goErrorReply("\t\t\t", goFailStatus[grammar.FailBadRequest], grammar.FailBadRequest, "err.Error()"))
+	code.WriteString("\t\t}\n")
+	code.WriteString(fmt.Sprintf("\t\tupdated.%s = record.%s\n", id, id))
+	code.WriteString("\t\tupdated.Version = record.Version + 1\n")
+	code.WriteString("\t\tif err := save(&updated); err != nil {\n")
+	code.WriteString(fmt.Sprintf("\t\t\tstatus, code := %s, %q\n", goFailStatus[grammar.FailInternal], grammar.FailInternal))
+	code.WriteString(fmt.Sprintf("\t\t\tif errors.Is(err, %s) {\n", conflict))
+	code.WriteString(fmt.Sprintf("\t\t\t\tstatus, code = %s, %q\n", goFailStatus[grammar.FailConflict], grammar.FailConflict))
+	code.WriteString("\t\t\t}\n")
+	code.WriteString("\t\t\twriteError(w, r, status, code, err.Error())\n")
+	code.WriteString("\t\t\treturn\n")
+	code.WriteString("\t\t}\n\n")
+	code.WriteString("\t\tw.Header().Set(\"ETag\", updated.ETag())\n")
+	code.WriteString("\t\tw.Header().Set(\"Content-Type\", \"application/json\")\n")
+	code.WriteString("\t\tjson.NewEncoder(w).Encode(&updated)\n")
+	code.WriteString("\t}\n")
+	code.WriteString("}\n\n")
+
+	return code.String()
+}
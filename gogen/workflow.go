@@ -0,0 +1,136 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// generateGoWorkflow writes the function running a checked workflow: it
+// takes the parameters of the first step, passes each step's result to the
+// next one that takes it and returns the last step's result. When a step
+// fails, the compensations in effect run in reverse order and the step's
+// error is returned, joined with theirs.
+func generateGoWorkflow(workflow *grammar.Workflow, functions map[string]*grammar.Function) string {
+	steps := make([]*grammar.Function, len(workflow.Steps))
+	for i, step := range workflow.Steps {
+		steps[i] = functions[step.Function]
+	}
+	last := goFunctionResults(steps[len(steps)-1])
+
+	// results[i] is the variable holding the result of step i, "_" when
+	// nothing takes it
+	results := make([]string, len(steps))
+	for i := range steps {
+		results[i] = "_"
+		used := i == len(steps)-1 && last.typ != ""
+		if next := i + 1; next < len(steps) {
+			used = used || len(steps[next].Parameters) > 0
+			if compensate := workflow.Steps[next].Compensate; compensate != "" {
+				used = used || len(functions[compensate].Parameters) > 0
+			}
+		}
+		if used {
+			results[i] = fmt.Sprintf("result%d", i+1)
+		}
+	}
+
+	var code strings.Builder
+	name := exportedName(workflow.Name)
+	called := make([]string, len(steps))
+	for i, step := range workflow.Steps {
+		called[i] = step.Function
+	}
+	code.WriteString(fmt.Sprintf("// %s runs the workflow %s: %s.\n", name, workflow.Name, strings.Join(called, ", then ")))
+	for _, step := range workflow.Steps {
+		if step.Compensate != "" {
+			code.WriteString(fmt.Sprintf("// A failure from %s on is compensated by %s.\n", step.Function, step.Compensate))
+		}
+	}
+	code.WriteString("// A failed step's error is returned, joined with those of the\n// compensations run for it.\n")
+	doc := codegen.DocLines(workflow.Leading, workflow.Trailing)
+	if workflow.Why != "" {
+		doc = append(doc, "Why: "+workflow.Why)
+	}
+	if len(doc) > 0 {
+		code.WriteString("//\n")
+	}
+	for _, line := range doc {
+		code.WriteString(strings.TrimRight("// "+line, " ") + "\n")
+	}
+
+	var params, args []string
+	for _, param := range steps[0].Parameters {
+		params = append(params, param.Name+" "+FieldType(param.Type))
+		args = append(args, param.Name)
+	}
+	returns, zero := "error", ""
+	if last.typ != "" {
+		returns, zero = fmt.Sprintf("(%s, error)", last.typ), goZeroValue(last.typ)+", "
+	}
+	code.WriteString(fmt.Sprintf("func %s(%s) %s {\n", name, strings.Join(params, ", "), returns))
+
+	var compensations []string // in effect, latest first
+	for i, step := range workflow.Steps {
+		function := steps[i]
+		if i > 0 {
+			args = nil
+			if len(function.Parameters) > 0 {
+				args = []string{results[i-1]}
+			}
+		}
+		if step.Compensate != "" {
+			var arg string
+			if len(functions[step.Compensate].Parameters) > 0 {
+				arg = results[i-1]
+			}
+			compensations = append([]string{goCompensation(functions[step.Compensate], arg)}, compensations...)
+		}
+
+		call := fmt.Sprintf("%s(%s)", function.Name, strings.Join(args, ", "))
+		failure := func(err string) string {
+			if len(compensations) == 0 {
+				return fmt.Sprintf("\t\treturn %s%s\n", zero, err)
+			}
+			return fmt.Sprintf("\t\treturn %serrors.Join(%s, %s)\n", zero, err, strings.Join(compensations, ", "))
+		}
+		switch r := goFunctionResults(function); {
+		case r.fails && r.typ != "":
+			err := fmt.Sprintf("err%d", i+1)
+			code.WriteString(fmt.Sprintf("\t%s, %s := %s\n", results[i], err, call))
+			code.WriteString(fmt.Sprintf("\tif %s != nil {\n", err))
+			code.WriteString(failure(err))
+			code.WriteString("\t}\n")
+		case r.fails:
+			code.WriteString(fmt.Sprintf("\tif err := %s; err != nil {\n", call))
+			code.WriteString(failure("err"))
+			code.WriteString("\t}\n")
+		case r.typ != "" && results[i] != "_":
+			code.WriteString(fmt.Sprintf("\t%s := %s\n", results[i], call))
+		default:
+			code.WriteString(fmt.Sprintf("\t%s\n", call))
+		}
+	}
+	if last.typ != "" {
+		code.WriteString(fmt.Sprintf("\treturn %s, nil\n", results[len(results)-1]))
+	} else {
+		code.WriteString("\treturn nil\n")
+	}
+	code.WriteString("}\n\n")
+	return code.String()
+}
+
+// goCompensation is a call of a compensating function as an error value,
+// passing arg when it is not empty
+func goCompensation(function *grammar.Function, arg string) string {
+	call := fmt.Sprintf("%s(%s)", function.Name, arg)
+	switch r := goFunctionResults(function); {
+	case r.fails && r.typ != "":
+		return fmt.Sprintf("func() error {\n\t\t\t_, err := %s\n\t\t\treturn err\n\t\t}()", call)
+	case r.fails:
+		return call
+	}
+	return fmt.Sprintf("func() error {\n\t\t\t%s\n\t\t\treturn nil\n\t\t}()", call)
+}
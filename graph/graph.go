@@ -0,0 +1,415 @@
+// Package graph maps how the modules, records and functions of a CloudPact
+// project refer to each other, and draws the result as Graphviz DOT or
+// Mermaid.
+package graph
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// NodeKind says what a node stands for
+type NodeKind string
+
+const (
+	NodeRecord   NodeKind = "record" // a record or legacy model
+	NodeFunction NodeKind = "function"
+)
+
+// EdgeKind says how one node refers to another
+type EdgeKind string
+
+const (
+	EdgeField   EdgeKind = "field"   // a record field holds another record
+	EdgeExtends EdgeKind = "extends" // a record shares the fields of another
+	EdgeCalls   EdgeKind = "calls"   // a function calls another
+	EdgeUses    EdgeKind = "uses"    // a function takes, returns or creates a record
+)
+
+type Node struct {
+	ID     string // unique across the project: <module>.<name>
+	Name   string
+	Kind   NodeKind
+	Module string
+}
+
+type Edge struct {
+	From  string
+	To    string
+	Kind  EdgeKind
+	Label string // the field of an EdgeField, or the relationship of a model field
+}
+
+// Graph holds the nodes of a project, grouped by module, and the references
+// between them
+type Graph struct {
+	Modules []string
+	Nodes   []*Node
+	Edges   []*Edge
+}
+
+// Build maps files, keyed by source path. A file's module is the one it
+// declares, or its base name. Records and functions are looked up across
+// the whole project, as CloudPact names are.
+func Build(files map[string]*grammar.File) *Graph {
+	sources := make([]string, 0, len(files))
+	for source := range files {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	g := &Graph{}
+	records := make(map[string]*Node)
+	functions := make(map[string]*Node)
+	seenModules := make(map[string]bool)
+	add := func(module, name string, kind NodeKind, index map[string]*Node) {
+		if index[name] != nil {
+			return
+		}
+		node := &Node{ID: module + "." + name, Name: name, Kind: kind, Module: module}
+		index[name] = node
+		g.Nodes = append(g.Nodes, node)
+	}
+	for _, source := range sources {
+		file := files[source]
+		module := moduleName(source, file)
+		if !seenModules[module] {
+			seenModules[module] = true
+			g.Modules = append(g.Modules, module)
+		}
+		for _, record := range file.Records {
+			add(module, record.Name, NodeRecord, records)
+		}
+		for _, model := range file.Models {
+			add(module, model.Name, NodeRecord, records)
+		}
+		for _, fn := range file.Functions {
+			add(module, fn.Name, NodeFunction, functions)
+		}
+	}
+
+	seenEdges := make(map[Edge]bool)
+	link := func(from *Node, to string, kind EdgeKind, label string, index map[string]*Node) {
+		target := index[to]
+		if target == nil {
+			return
+		}
+		edge := Edge{From: from.ID, To: target.ID, Kind: kind, Label: label}
+		if !seenEdges[edge] {
+			seenEdges[edge] = true
+			g.Edges = append(g.Edges, &edge)
+		}
+	}
+	for _, source := range sources {
+		file := files[source]
+		for _, record := range file.Records {
+			from := records[record.Name]
+			link(from, record.Extends, EdgeExtends, "", records)
+			for _, field := range record.Fields {
+				for _, name := range typeNames(field.Type) {
+					link(from, name, EdgeField, field.Name, records)
+				}
+			}
+		}
+		for _, model := range file.Models {
+			from := records[model.Name]
+			for _, field := range model.Fields {
+				if field.Relationship != nil {
+					link(from, field.Relationship.Target, EdgeField, field.Name+" ("+field.Relationship.Kind+")", records)
+					continue
+				}
+				for _, name := range typeNames(field.Type) {
+					link(from, name, EdgeField, field.Name, records)
+				}
+			}
+		}
+		for _, fn := range file.Functions {
+			from := functions[fn.Name]
+			for _, param := range fn.Parameters {
+				for _, name := range typeNames(param.Type) {
+					link(from, name, EdgeUses, "", records)
+				}
+			}
+			for _, name := range typeNames(fn.ReturnType) {
+				link(from, name, EdgeUses, "", records)
+			}
+			if fn.Body == nil {
+				continue
+			}
+			walkStatements(fn.Body.Statements, func(stmt grammar.Statement) {
+				if create, ok := stmt.(*grammar.CreateStatement); ok {
+					link(from, create.TypeName, EdgeUses, "", records)
+				}
+			}, func(expr grammar.Expression) {
+				if call, ok := expr.(*grammar.CallExpression); ok {
+					link(from, call.Function, EdgeCalls, "", functions)
+				}
+			})
+		}
+	}
+	return g
+}
+
+// moduleName is the module a file declares, or its base name
+func moduleName(source string, file *grammar.File) string {
+	if file.Module != nil {
+		return file.Module.Name
+	}
+	return strings.TrimSuffix(filepath.Base(source), ".cp")
+}
+
+// typeNames lists the names a type refers to, looking inside lists and maps
+func typeNames(t *grammar.Type) []string {
+	switch {
+	case t == nil:
+		return nil
+	case t.Element != nil:
+		return typeNames(t.Element)
+	case t.Key != nil || t.Value != nil:
+		return append(typeNames(t.Key), typeNames(t.Value)...)
+	}
+	return []string{t.Name}
+}
+
+// walkStatements calls visitStmt for every statement and visitExpr for
+// every expression nested in stmts
+func walkStatements(stmts []grammar.Statement, visitStmt func(grammar.Statement), visitExpr func(grammar.Expression)) {
+	for _, stmt := range stmts {
+		if stmt == nil {
+			continue
+		}
+		visitStmt(stmt)
+		switch s := stmt.(type) {
+		case *grammar.IfStatement:
+			walkExpression(s.Condition, visitExpr)
+			walkStatements([]grammar.Statement{s.ThenStmt, s.ElseStmt}, visitStmt, visitExpr)
+		case *grammar.ReturnStatement:
+			walkExpression(s.Value, visitExpr)
+		case *grammar.AssignStatement:
+			walkExpression(s.Value, visitExpr)
+		case *grammar.CreateStatement:
+			for _, a := range s.Assignments {
+				walkExpression(a.Value, visitExpr)
+			}
+		case *grammar.ExpectStatement:
+			walkExpression(s.Value, visitExpr)
+			walkExpression(s.Expected, visitExpr)
+		}
+	}
+}
+
+func walkExpression(expr grammar.Expression, visit func(grammar.Expression)) {
+	if expr == nil {
+		return
+	}
+	visit(expr)
+	switch e := expr.(type) {
+	case *grammar.BinaryExpression:
+		walkExpression(e.Left, visit)
+		walkExpression(e.Right, visit)
+	case *grammar.EmptyExpression:
+		walkExpression(e.Value, visit)
+	case *grammar.CallExpression:
+		for _, arg := range e.Arguments {
+			walkExpression(arg, visit)
+		}
+	case *grammar.MemberExpression:
+		walkExpression(e.Object, visit)
+	case *grammar.DefaultExpression:
+		walkExpression(e.Value, visit)
+		walkExpression(e.Fallback, visit)
+	case *grammar.ConditionalExpression:
+		walkExpression(e.Condition, visit)
+		walkExpression(e.Then, visit)
+		walkExpression(e.Else, visit)
+	case *grammar.QueryExpression:
+		walkExpression(e.Source, visit)
+		walkExpression(e.Filter, visit)
+		walkExpression(e.Select, visit)
+	case *grammar.AggregateExpression:
+		walkExpression(e.Source, visit)
+	}
+}
+
+// Cycles finds the groups of records that refer to each other through
+// fields or extends, directly or indirectly. Each cycle lists node IDs in
+// sorted order; a record holding itself is a cycle of one.
+func (g *Graph) Cycles() [][]string {
+	next := make(map[string][]string)
+	self := make(map[string]bool)
+	for _, e := range g.Edges {
+		if e.Kind != EdgeField && e.Kind != EdgeExtends {
+			continue
+		}
+		next[e.From] = append(next[e.From], e.To)
+		if e.From == e.To {
+			self[e.From] = true
+		}
+	}
+
+	// Tarjan's strongly connected components
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var cycles [][]string
+	var visit func(id string)
+	visit = func(id string) {
+		index[id] = len(index)
+		low[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+		for _, to := range next[id] {
+			if _, seen := index[to]; !seen {
+				visit(to)
+				low[id] = min(low[id], low[to])
+			} else if onStack[to] {
+				low[id] = min(low[id], index[to])
+			}
+		}
+		if low[id] != index[id] {
+			return
+		}
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == id {
+				break
+			}
+		}
+		if len(component) > 1 || self[id] {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+	for _, n := range g.Nodes {
+		if _, seen := index[n.ID]; !seen && n.Kind == NodeRecord {
+			visit(n.ID)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// inCycle reports the edges that close a relationship cycle, which both
+// formats draw in red
+func (g *Graph) inCycle() map[*Edge]bool {
+	group := make(map[string]int)
+	for i, cycle := range g.Cycles() {
+		for _, id := range cycle {
+			group[id] = i + 1
+		}
+	}
+	result := make(map[*Edge]bool)
+	for _, e := range g.Edges {
+		if (e.Kind == EdgeField || e.Kind == EdgeExtends) && group[e.From] != 0 && group[e.From] == group[e.To] {
+			result[e] = true
+		}
+	}
+	return result
+}
+
+func (g *Graph) nodesOf(module string) []*Node {
+	var nodes []*Node
+	for _, n := range g.Nodes {
+		if n.Module == module {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// DOT draws the graph for Graphviz, one cluster per module. Records are
+// boxes and functions ellipses; calls and uses are dashed.
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph cloudpact {\n")
+	b.WriteString("    rankdir=LR;\n")
+	for i, module := range g.Modules {
+		fmt.Fprintf(&b, "    subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "        label=%q;\n", module)
+		for _, n := range g.nodesOf(module) {
+			shape := "box"
+			if n.Kind == NodeFunction {
+				shape = "ellipse"
+			}
+			fmt.Fprintf(&b, "        %q [label=%q, shape=%s];\n", n.ID, n.Name, shape)
+		}
+		b.WriteString("    }\n")
+	}
+	cycle := g.inCycle()
+	for _, e := range g.Edges {
+		var attrs []string
+		if label := edgeLabel(e); label != "" {
+			attrs = append(attrs, fmt.Sprintf("label=%q", label))
+		}
+		if e.Kind == EdgeCalls || e.Kind == EdgeUses {
+			attrs = append(attrs, "style=dashed")
+		}
+		if cycle[e] {
+			attrs = append(attrs, "color=red")
+		}
+		fmt.Fprintf(&b, "    %q -> %q", e.From, e.To)
+		if len(attrs) > 0 {
+			b.WriteString(" [" + strings.Join(attrs, ", ") + "]")
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid draws the graph as a Mermaid flowchart, one subgraph per module
+func (g *Graph) Mermaid() string {
+	ids := make(map[string]string)
+	for i, n := range g.Nodes {
+		ids[n.ID] = fmt.Sprintf("n%d", i)
+	}
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for i, module := range g.Modules {
+		fmt.Fprintf(&b, "    subgraph m%d [%q]\n", i, module)
+		for _, n := range g.nodesOf(module) {
+			if n.Kind == NodeFunction {
+				fmt.Fprintf(&b, "        %s([%q])\n", ids[n.ID], n.Name)
+			} else {
+				fmt.Fprintf(&b, "        %s[%q]\n", ids[n.ID], n.Name)
+			}
+		}
+		b.WriteString("    end\n")
+	}
+	cycle := g.inCycle()
+	var red []string
+	for i, e := range g.Edges {
+		arrow := "-->"
+		if e.Kind == EdgeCalls || e.Kind == EdgeUses {
+			arrow = "-.->"
+		}
+		if label := edgeLabel(e); label != "" {
+			arrow += `|"` + strings.ReplaceAll(label, `"`, "'") + `"|`
+		}
+		fmt.Fprintf(&b, "    %s %s %s\n", ids[e.From], arrow, ids[e.To])
+		if cycle[e] {
+			red = append(red, fmt.Sprint(i))
+		}
+	}
+	if len(red) > 0 {
+		fmt.Fprintf(&b, "    linkStyle %s stroke:red\n", strings.Join(red, ","))
+	}
+	return b.String()
+}
+
+func edgeLabel(e *Edge) string {
+	if e.Kind == EdgeExtends {
+		return "extends"
+	}
+	return e.Label
+}
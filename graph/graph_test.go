@@ -0,0 +1,125 @@
+package graph
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+func build(t *testing.T, sources map[string]string) *Graph {
+	t.Helper()
+	files := make(map[string]*grammar.File)
+	for name, src := range sources {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse %s: %v", name, err)
+		}
+		files[name] = file
+	}
+	return Build(files)
+}
+
+var project = map[string]string{
+	"models/people.cp": `define record Employee
+    manager: Employee optional
+    team: Team
+
+define record Team
+    members: list of Employee
+`,
+	"models/shop.cp": `module Shop
+
+define record Customer
+    email: email
+
+define record Order
+    customer: Customer
+    buyer: Employee
+
+function total(order: Order) returns usd_currency
+    why: "Sum of the order lines"
+    do:
+        return 0
+
+function invoice(order: Order) returns Customer
+    why: "Invoices go to the customer"
+    do:
+        if total(order) > 0
+            then return order.customer
+        create Customer with: email = "none@example.com"
+`,
+}
+
+func TestBuild(t *testing.T) {
+	g := build(t, project)
+	if !reflect.DeepEqual(g.Modules, []string{"people", "Shop"}) {
+		t.Errorf("unexpected modules %v", g.Modules)
+	}
+	var edges []string
+	for _, e := range g.Edges {
+		edges = append(edges, e.From+" -"+string(e.Kind)+"-> "+e.To+" "+e.Label)
+	}
+	for _, want := range []string{
+		"people.Employee -field-> people.Employee manager",
+		"people.Team -field-> people.Employee members",
+		"Shop.Order -field-> people.Employee buyer",
+		"Shop.invoice -calls-> Shop.total ",
+		"Shop.invoice -uses-> Shop.Customer ",
+		"Shop.total -uses-> Shop.Order ",
+	} {
+		found := false
+		for _, edge := range edges {
+			found = found || edge == want
+		}
+		if !found {
+			t.Errorf("expected edge %q in %v", want, edges)
+		}
+	}
+}
+
+func TestCycles(t *testing.T) {
+	cycles := build(t, project).Cycles()
+	want := [][]string{{"people.Employee", "people.Team"}}
+	if !reflect.DeepEqual(cycles, want) {
+		t.Errorf("Cycles() = %v, want %v", cycles, want)
+	}
+
+	single := build(t, map[string]string{"a.cp": "define record Node\n    next: Node optional\n"})
+	if cycles := single.Cycles(); !reflect.DeepEqual(cycles, [][]string{{"a.Node"}}) {
+		t.Errorf("expected a record holding itself to be a cycle, got %v", cycles)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	g := build(t, project)
+	dot := g.DOT()
+	for _, want := range []string{
+		"digraph cloudpact {",
+		"label=\"Shop\";",
+		"\"Shop.Order\" [label=\"Order\", shape=box];",
+		"\"Shop.total\" [label=\"total\", shape=ellipse];",
+		"\"people.Team\" -> \"people.Employee\" [label=\"members\", color=red];",
+		"\"Shop.Order\" -> \"Shop.Customer\" [label=\"customer\"];",
+		"\"Shop.invoice\" -> \"Shop.total\" [style=dashed];",
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("expected %q in DOT:\n%s", want, dot)
+		}
+	}
+
+	mermaid := g.Mermaid()
+	for _, want := range []string{
+		"flowchart LR\n",
+		"subgraph m1 [\"Shop\"]",
+		"n2[\"Customer\"]",
+		"n4([\"total\"])",
+		"n1 -->|\"members\"| n0",
+		"linkStyle 0,1,2 stroke:red",
+	} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("expected %q in Mermaid:\n%s", want, mermaid)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Package testutil holds helpers shared by the tests of the code generators
+package testutil
+
+import (
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// CheckedFile parses src and runs the analyzer on it, failing t on any
+// error, so a test starts from a file as the build would see it
+func CheckedFile(t testing.TB, src string) *grammar.File {
+	t.Helper()
+	f, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(f); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	return f
+}
@@ -0,0 +1,138 @@
+package interp
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// ErrTerminated is returned by a run the debugger was told to end
+var ErrTerminated = errors.New("terminated by the debugger")
+
+// Action tells a paused debugger how to resume
+type Action int
+
+const (
+	Continue  Action = iota // run to the next breakpoint
+	StepIn                  // stop at the next statement, entering calls
+	StepOver                // stop at the next statement of this call or its callers
+	StepOut                 // stop once the current call returns
+	Terminate               // abandon the run
+)
+
+// StackFrame is one active function call
+type StackFrame struct {
+	Function  string
+	Position  *grammar.Position // statement about to run
+	Variables Env
+}
+
+// Stop describes where execution paused
+type Stop struct {
+	Reason string       // entry, breakpoint or step
+	Stack  []StackFrame // innermost call first
+}
+
+// Debugger pauses an interpreter at breakpoints and after steps. Pause is
+// called at each stop with the state of the run, and blocks until the user
+// decides how to resume.
+type Debugger struct {
+	Pause       func(*Stop) Action
+	StopOnEntry bool
+
+	breakpoints map[string]map[int]bool // by absolute file path; "" matches any file
+	action      Action
+	depth       int // stack depth when the action was chosen
+	started     bool
+}
+
+// NewDebugger returns a debugger that reports stops to pause
+func NewDebugger(pause func(*Stop) Action) *Debugger {
+	return &Debugger{Pause: pause, breakpoints: make(map[string]map[int]bool)}
+}
+
+// SetBreakpoints replaces the breakpoints of file with lines. An empty file
+// sets breakpoints that apply to every file.
+func (d *Debugger) SetBreakpoints(file string, lines []int) {
+	key := absPath(file)
+	d.breakpoints[key] = make(map[int]bool)
+	for _, line := range lines {
+		d.breakpoints[key][line] = true
+	}
+}
+
+// Breakpoints lists the breakpoint lines set for file
+func (d *Debugger) Breakpoints(file string) []int {
+	var lines []int
+	for line := range d.breakpoints[absPath(file)] {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func absPath(file string) string {
+	if file == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return filepath.Clean(file)
+}
+
+func (d *Debugger) hits(pos *grammar.Position) bool {
+	if pos == nil {
+		return false
+	}
+	return d.breakpoints[""][pos.Line] || d.breakpoints[absPath(pos.File)][pos.Line]
+}
+
+// before runs ahead of each statement and pauses when a breakpoint or the
+// pending step says to
+func (d *Debugger) before(in *Interpreter) error {
+	if len(in.stack) == 0 {
+		return nil
+	}
+	depth := len(in.stack)
+	pos := in.stack[depth-1].pos
+
+	reason := ""
+	switch {
+	case !d.started && d.StopOnEntry:
+		reason = "entry"
+	case d.hits(pos):
+		reason = "breakpoint"
+	case d.action == StepIn,
+		d.action == StepOver && depth <= d.depth,
+		d.action == StepOut && depth < d.depth:
+		reason = "step"
+	}
+	d.started = true
+	if reason == "" {
+		return nil
+	}
+
+	stop := &Stop{Reason: reason}
+	for i := depth - 1; i >= 0; i-- {
+		f := in.stack[i]
+		vars := make(Env, len(f.vars))
+		for name, value := range f.vars {
+			vars[name] = value
+		}
+		stop.Stack = append(stop.Stack, StackFrame{Function: f.function, Position: f.pos, Variables: vars})
+	}
+
+	d.action, d.depth = d.Pause(stop), depth
+	if d.action == Terminate {
+		return ErrTerminated
+	}
+	return nil
+}
+
+// Inspect evaluates expr with env, such as the variables of a paused frame.
+// It runs apart from the call being debugged, so calls it makes never stop.
+func (in *Interpreter) Inspect(expr grammar.Expression, env Env) (interface{}, error) {
+	detached := &Interpreter{Functions: in.Functions, Records: in.Records}
+	return detached.Eval(expr, env)
+}
@@ -0,0 +1,77 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// AssertionError is an expect statement that did not hold
+type AssertionError struct {
+	Message  string
+	Position *grammar.Position
+}
+
+func (e *AssertionError) Error() string {
+	if e.Position == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s at %s", e.Message, e.Position)
+}
+
+// Samples binds every record to a sample value named after it, user for
+// User
+func (in *Interpreter) Samples() Env {
+	env := make(Env)
+	for name := range in.Records {
+		env[strings.ToLower(name[:1])+name[1:]] = in.Sample(&grammar.Type{Name: name})
+	}
+	return env
+}
+
+// RunTest runs the statements of a test block with the records bound to
+// sample values. It returns an *AssertionError for the first expectation
+// that does not hold, or the error that stopped the test.
+func (in *Interpreter) RunTest(test *grammar.Test) error {
+	f := &frame{function: fmt.Sprintf("test %q", test.Name), vars: in.Samples(), pos: test.Position}
+	_, returned, err := in.execAll(test.Statements, f)
+	if err == nil && returned {
+		return errorf(f.pos, "tests cannot return")
+	}
+	return err
+}
+
+func (in *Interpreter) expect(s *grammar.ExpectStatement, f *frame) error {
+	value, err := in.eval(s.Value, f)
+	source := grammar.FormatExpression(s.Value)
+
+	if s.Fails {
+		var failure *Failure
+		switch {
+		case errors.As(err, &failure):
+			if s.Message != "" && failure.Message != s.Message {
+				return &AssertionError{Position: s.Position, Message: fmt.Sprintf("expected %s to fail with %q, but it failed with %q", source, s.Message, failure.Message)}
+			}
+			return nil
+		case err != nil:
+			return err
+		}
+		return &AssertionError{Position: s.Position, Message: fmt.Sprintf("expected %s to fail, but it gave %s", source, Format(value))}
+	}
+	if err != nil {
+		return err
+	}
+
+	var expected interface{} = true
+	if s.Expected != nil {
+		if expected, err = in.eval(s.Expected, f); err != nil {
+			return err
+		}
+	}
+	if !equal(value, expected) {
+		return &AssertionError{Position: s.Position, Message: fmt.Sprintf("expected %s to be %s, but it was %s", source, Format(expected), Format(value))}
+	}
+	return nil
+}
@@ -0,0 +1,740 @@
+// Package interp evaluates CloudPact function bodies directly, without
+// generating code. Values are Go values: text is string, whole numbers are
+// int64, other numbers float64, booleans bool, lists []interface{} and
+// records map[string]interface{}. A missing value is nil.
+package interp
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// maxDepth bounds nested function calls, so runaway recursion is reported
+// instead of exhausting the stack
+const maxDepth = 1000
+
+// Failure is the error a fail statement raises
+type Failure struct {
+	Message  string
+	Position *grammar.Position
+}
+
+func (f *Failure) Error() string { return f.Message }
+
+// Error is a runtime error, such as following a member of a missing value
+type Error struct {
+	Pos *grammar.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	if e.Pos == nil {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s at %s", e.Msg, e.Pos)
+}
+
+func errorf(pos *grammar.Position, format string, args ...interface{}) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Env holds variables by name
+type Env map[string]interface{}
+
+// Interpreter evaluates the functions and records of one or more files
+type Interpreter struct {
+	Functions map[string]*grammar.Function
+	Records   map[string]*grammar.Record
+	// Debugger, when set, is consulted before every statement
+	Debugger *Debugger
+	// Trace, when set, records the decisions made
+	Trace *Trace
+	stack []*frame // active function calls, outermost first
+}
+
+// New returns an interpreter for the declarations of files
+func New(files ...*grammar.File) *Interpreter {
+	in := &Interpreter{
+		Functions: make(map[string]*grammar.Function),
+		Records:   make(map[string]*grammar.Record),
+	}
+	for _, file := range files {
+		for _, function := range file.Functions {
+			in.Functions[function.Name] = function
+		}
+		for _, record := range file.Records {
+			in.Records[record.Name] = record
+		}
+	}
+	return in
+}
+
+// frame is the variables of one function call, plus the item bound by the
+// innermost query being evaluated
+type frame struct {
+	function string
+	vars     Env
+	item     interface{}
+	outer    *frame
+	pos      *grammar.Position // statement being run
+	// decisions are the conditions evaluated so far, "big is true", when
+	// tracing
+	decisions []string
+}
+
+// lookup finds a variable, or a field of a query item. The analyzer marks
+// names it resolved to item fields as elements; those are tried first.
+func (f *frame) lookup(name string, element bool) (interface{}, bool) {
+	if value, ok := f.vars[name]; ok && !element {
+		return value, true
+	}
+	for q := f; q != nil; q = q.outer {
+		if fields, ok := q.item.(map[string]interface{}); ok {
+			if value, ok := fields[name]; ok {
+				return value, true
+			}
+		}
+	}
+	value, ok := f.vars[name]
+	return value, ok
+}
+
+// Call runs the named function with args in parameter order
+func (in *Interpreter) Call(name string, args []interface{}) (interface{}, error) {
+	function, ok := in.Functions[name]
+	if !ok {
+		return nil, fmt.Errorf("no function %s", name)
+	}
+	if len(args) != len(function.Parameters) {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", name, len(function.Parameters), len(args))
+	}
+	if len(in.stack) >= maxDepth {
+		return nil, errorf(function.Position, "%s: calls nested more than %d deep", name, maxDepth)
+	}
+
+	vars := make(Env)
+	for i, param := range function.Parameters {
+		vars[param.Name] = args[i]
+	}
+	f := &frame{function: name, vars: vars, pos: function.Position}
+	in.stack = append(in.stack, f)
+	defer func() { in.stack = in.stack[:len(in.stack)-1] }()
+
+	if in.Trace != nil {
+		in.traceCall(function, args)
+	}
+	var value interface{}
+	var err error
+	if function.Body != nil {
+		value, _, err = in.execAll(function.Body.Statements, f)
+	}
+	if in.Trace != nil {
+		in.traceReturn(function, f, value, err)
+	}
+	return value, err
+}
+
+// Exec runs a statement with the variables of env, which set and create
+// statements update. It reports whether the statement returned, and with
+// what value.
+func (in *Interpreter) Exec(stmt grammar.Statement, env Env) (interface{}, bool, error) {
+	return in.exec(stmt, &frame{vars: env})
+}
+
+// Eval evaluates an expression with the variables of env
+func (in *Interpreter) Eval(expr grammar.Expression, env Env) (interface{}, error) {
+	return in.eval(expr, &frame{vars: env})
+}
+
+func (in *Interpreter) execAll(stmts []grammar.Statement, f *frame) (interface{}, bool, error) {
+	for _, stmt := range stmts {
+		value, returned, err := in.exec(stmt, f)
+		if err != nil || returned {
+			return value, returned, err
+		}
+	}
+	return nil, false, nil
+}
+
+func (in *Interpreter) exec(stmt grammar.Statement, f *frame) (interface{}, bool, error) {
+	if stmt != nil {
+		f.pos = stmt.GetPosition()
+		if in.Debugger != nil {
+			if err := in.Debugger.before(in); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	switch s := stmt.(type) {
+	case *grammar.IfStatement:
+		condition, err := in.condition(s.Condition, f)
+		if err != nil {
+			return nil, false, err
+		}
+		if in.Trace != nil {
+			in.decide(f, s.Condition, condition)
+			branch := "then"
+			if !condition {
+				branch = "else"
+			}
+			if (condition && s.ThenStmt == nil) || (!condition && s.ElseStmt == nil) {
+				branch = "nothing"
+			}
+			in.record("condition", s.Position, "if %s is %t, so %s runs", grammar.FormatExpression(s.Condition), condition, branch)
+		}
+		if condition && s.ThenStmt != nil {
+			return in.exec(s.ThenStmt, f)
+		}
+		if !condition && s.ElseStmt != nil {
+			return in.exec(s.ElseStmt, f)
+		}
+		return nil, false, nil
+	case *grammar.ReturnStatement:
+		if s.Value == nil {
+			return nil, true, nil
+		}
+		value, err := in.eval(s.Value, f)
+		return value, err == nil, err
+	case *grammar.AssignStatement:
+		// "use SHA256 algorithm" names an implementation choice for the
+		// generators and does nothing here
+		if s.Variable == "__use__" {
+			return nil, false, nil
+		}
+		value, err := in.eval(s.Value, f)
+		if err != nil {
+			return nil, false, err
+		}
+		f.vars[s.Variable] = value
+		if in.Trace != nil {
+			in.record("set", s.Position, "set %s = %s gives %s", s.Variable, grammar.FormatExpression(s.Value), Format(value))
+		}
+		return nil, false, nil
+	case *grammar.CreateStatement:
+		record := make(map[string]interface{})
+		if declared, ok := in.Records[s.TypeName]; ok {
+			for _, field := range declared.AllFields() {
+				if field.Default != nil {
+					record[field.Name] = defaultValue(field)
+				}
+			}
+		}
+		for _, assignment := range s.Assignments {
+			value, err := in.eval(assignment.Value, f)
+			if err != nil {
+				return nil, false, err
+			}
+			record[assignment.Field] = value
+		}
+		// Bound as the generated code does: create User binds user
+		f.vars[strings.ToLower(s.TypeName)] = record
+		if in.Trace != nil {
+			in.record("create", s.Position, "created %s %s", s.TypeName, Format(record))
+		}
+		return nil, false, nil
+	case *grammar.FailStatement:
+		if in.Trace != nil {
+			in.record("fail", s.Position, "failed with %q %s", s.Message, because(rootFrame(f)))
+		}
+		return nil, false, &Failure{Message: s.Message, Position: s.Position}
+	case *grammar.ExpectStatement:
+		return nil, false, in.expect(s, f)
+	case nil:
+		return nil, false, nil
+	}
+	return nil, false, errorf(stmt.GetPosition(), "cannot run %s statements", stmt.StatementType())
+}
+
+func (in *Interpreter) condition(expr grammar.Expression, f *frame) (bool, error) {
+	value, err := in.eval(expr, f)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, errorf(expr.GetPosition(), "condition is %s, not true or false", Format(value))
+	}
+	return b, nil
+}
+
+func (in *Interpreter) eval(expr grammar.Expression, f *frame) (interface{}, error) {
+	switch e := expr.(type) {
+	case *grammar.LiteralExpression:
+		return e.Value, nil
+	case *grammar.IdentifierExpression:
+		value, ok := f.lookup(e.Name, e.Element)
+		if !ok {
+			return nil, errorf(e.Position, "%s is not defined", e.Name)
+		}
+		return value, nil
+	case *grammar.MemberExpression:
+		object, err := in.eval(e.Object, f)
+		if err != nil {
+			return nil, err
+		}
+		if object == nil {
+			if e.Safe {
+				return nil, nil
+			}
+			return nil, errorf(e.Position, "cannot read %s of a missing value; use ?. or or", e.Property)
+		}
+		fields, ok := object.(map[string]interface{})
+		if !ok {
+			return nil, errorf(e.Position, "cannot read %s of %s", e.Property, Format(object))
+		}
+		return fields[e.Property], nil
+	case *grammar.BinaryExpression:
+		return in.binary(e, f)
+	case *grammar.DefaultExpression:
+		value, err := in.eval(e.Value, f)
+		if err != nil || value != nil {
+			return value, err
+		}
+		return in.eval(e.Fallback, f)
+	case *grammar.ConditionalExpression:
+		condition, err := in.condition(e.Condition, f)
+		if err != nil {
+			return nil, err
+		}
+		if in.Trace != nil {
+			in.decide(f, e.Condition, condition)
+			chosen := e.Then
+			if !condition {
+				chosen = e.Else
+			}
+			in.record("condition", e.Position, "%s is %t, so %s is chosen", grammar.FormatExpression(e.Condition), condition, grammar.FormatExpression(chosen))
+		}
+		if condition {
+			return in.eval(e.Then, f)
+		}
+		return in.eval(e.Else, f)
+	case *grammar.QueryExpression:
+		return in.query(e.Source, e.Filter, e.Select, f)
+	case *grammar.AggregateExpression:
+		return in.aggregate(e, f)
+	case *grammar.EmptyExpression:
+		value, err := in.eval(e.Value, f)
+		if err != nil {
+			return nil, err
+		}
+		empty, err := isEmpty(value)
+		if err != nil {
+			return nil, errorf(e.Position, "%v", err)
+		}
+		return empty != e.Negated, nil
+	case *grammar.CallExpression:
+		var args []interface{}
+		for _, arg := range e.Arguments {
+			value, err := in.eval(arg, f)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, value)
+		}
+		if _, ok := in.Functions[e.Function]; !ok {
+			return nil, errorf(e.Position, "no function %s", e.Function)
+		}
+		return in.Call(e.Function, args)
+	}
+	return nil, errorf(expr.GetPosition(), "cannot evaluate %s expressions", expr.ExpressionType())
+}
+
+func (in *Interpreter) binary(e *grammar.BinaryExpression, f *frame) (interface{}, error) {
+	left, err := in.eval(e.Left, f)
+	if err != nil {
+		return nil, err
+	}
+	right, err := in.eval(e.Right, f)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Operator {
+	case "=", "is":
+		return equal(left, right), nil
+	case "not", "is not":
+		return !equal(left, right), nil
+	case "contains", "not contains":
+		found, err := contains(left, right)
+		if err != nil {
+			return nil, errorf(e.Position, "%v", err)
+		}
+		return found == (e.Operator == "contains"), nil
+	case "in", "not in":
+		if _, ok := right.([]interface{}); !ok && right != nil {
+			return nil, errorf(e.Position, "%s is not a list", Format(right))
+		}
+		found, err := contains(right, left)
+		if err != nil {
+			return nil, errorf(e.Position, "%v", err)
+		}
+		return found == (e.Operator == "in"), nil
+	case "<", ">", "<=", ">=":
+		order, err := compare(left, right)
+		if err != nil {
+			return nil, errorf(e.Position, "%v", err)
+		}
+		switch e.Operator {
+		case "<":
+			return order < 0, nil
+		case ">":
+			return order > 0, nil
+		case "<=":
+			return order <= 0, nil
+		default:
+			return order >= 0, nil
+		}
+	}
+	return nil, errorf(e.Position, "unknown operator %s", e.Operator)
+}
+
+// query applies where and select to each item of source
+func (in *Interpreter) query(source, filter, selection grammar.Expression, f *frame) ([]interface{}, error) {
+	value, err := in.eval(source, f)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := value.([]interface{})
+	if value != nil && !ok {
+		return nil, errorf(source.GetPosition(), "%s is not a list", Format(value))
+	}
+
+	result := []interface{}{}
+	for _, item := range items {
+		scope := &frame{vars: f.vars, item: item, outer: f}
+		if filter != nil {
+			keep, err := in.condition(filter, scope)
+			if err != nil {
+				return nil, err
+			}
+			if !keep {
+				continue
+			}
+		}
+		if selection != nil {
+			if item, err = in.eval(selection, scope); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func (in *Interpreter) aggregate(e *grammar.AggregateExpression, f *frame) (interface{}, error) {
+	source, filter, selection := e.Source, grammar.Expression(nil), grammar.Expression(nil)
+	if query, ok := e.Source.(*grammar.QueryExpression); ok {
+		source, filter, selection = query.Source, query.Filter, query.Select
+	}
+	if e.Function == "length" {
+		// The length of text is its number of characters
+		value, err := in.eval(source, f)
+		if err != nil {
+			return nil, err
+		}
+		if text, ok := value.(string); ok && filter == nil && selection == nil {
+			return int64(utf8.RuneCountInString(text)), nil
+		}
+	}
+	items, err := in.query(source, filter, selection, f)
+	if err != nil {
+		return nil, err
+	}
+	switch e.Function {
+	case "count", "length":
+		return int64(len(items)), nil
+	case "first", "last":
+		if len(items) == 0 {
+			return nil, nil
+		}
+		if e.Function == "first" {
+			return items[0], nil
+		}
+		return items[len(items)-1], nil
+	}
+
+	// Money is added up in whole cents, rounding each value as the
+	// generated code does
+	round := func(x float64) float64 { return x }
+	switch e.Rounding {
+	case grammar.RoundBanker:
+		round = func(x float64) float64 { return math.RoundToEven(x*100) / 100 }
+	case grammar.RoundHalfUp:
+		round = func(x float64) float64 { return math.Round(x*100) / 100 }
+	}
+
+	var total float64
+	whole := true
+	for _, item := range items {
+		x, ok := number(item)
+		if !ok {
+			return nil, errorf(e.Position, "cannot %s %s", e.Function, Format(item))
+		}
+		_, isInt := item.(int64)
+		whole = whole && isInt
+		total += round(x)
+	}
+	if e.Function == "sum" {
+		if whole && e.Rounding == "" {
+			return int64(total), nil
+		}
+		return round(total), nil
+	}
+	if len(items) == 0 {
+		return float64(0), nil
+	}
+	return round(total / float64(len(items))), nil
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func compare(a, b interface{}) (int, error) {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", Format(a), Format(b))
+}
+
+// isEmpty reports whether value is none, text without characters or a list
+// or map without entries
+func isEmpty(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return true, nil
+	case string:
+		return v == "", nil
+	case []interface{}:
+		return len(v) == 0, nil
+	case map[string]interface{}:
+		return len(v) == 0, nil
+	}
+	return false, fmt.Errorf("%s cannot be empty", Format(value))
+}
+
+func contains(collection, item interface{}) (bool, error) {
+	switch c := collection.(type) {
+	case string:
+		s, ok := item.(string)
+		if !ok {
+			return false, fmt.Errorf("text cannot contain %s", Format(item))
+		}
+		return strings.Contains(c, s), nil
+	case []interface{}:
+		for _, element := range c {
+			if equal(element, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case nil:
+		return false, nil
+	}
+	return false, fmt.Errorf("%s cannot contain anything", Format(collection))
+}
+
+// Sample returns example data for a value of type t, for trying out
+// functions without real data. Records are filled in field by field.
+func (in *Interpreter) Sample(t *grammar.Type) interface{} {
+	return in.sample(t, 0)
+}
+
+func (in *Interpreter) sample(t *grammar.Type, depth int) interface{} {
+	if t == nil || depth > 4 {
+		return nil
+	}
+	if record, ok := in.Records[t.Name]; ok {
+		fields := make(map[string]interface{})
+		for _, field := range record.AllFields() {
+			if field.Default != nil {
+				fields[field.Name] = defaultValue(field)
+				continue
+			}
+			fields[field.Name] = in.sample(field.Type, depth+1)
+		}
+		if record.IsVersioned() {
+			fields["version"] = int64(1)
+		}
+		return fields
+	}
+
+	switch strings.ToLower(t.Name) {
+	case "email":
+		return "user@example.com"
+	case "int", "integer", "long", "bigint":
+		return int64(1)
+	case "uuid", "id":
+		return "123e4567-e89b-42d3-a456-426614174000"
+	}
+	switch analyzer.KindOf(t) {
+	case analyzer.KindNumber:
+		return 1.5
+	case analyzer.KindBoolean:
+		return true
+	case analyzer.KindTemporal:
+		return "2024-01-01T00:00:00Z"
+	case analyzer.KindList:
+		return []interface{}{in.sample(t.Element, depth+1)}
+	case analyzer.KindMap:
+		return map[string]interface{}{fmt.Sprint(in.sample(t.Key, depth+1)): in.sample(t.Value, depth+1)}
+	case analyzer.KindRecord:
+		return nil
+	}
+	return "text"
+}
+
+// defaultValue is the declared default of a field; "now" is the current
+// time in the form temporal values take
+func defaultValue(field *grammar.FieldDef) interface{} {
+	if literal, ok := field.Default.(*grammar.LiteralExpression); ok {
+		return literal.Value
+	}
+	if strings.EqualFold(field.Type.Name, "date") {
+		return time.Now().UTC().Format("2006-01-02")
+	}
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// ParseValue converts text, such as a command-line argument, to a value of
+// type t. Lists are comma separated, as are the key=value entries of maps;
+// records are built field by field instead.
+func (in *Interpreter) ParseValue(t *grammar.Type, text string) (interface{}, error) {
+	if t == nil {
+		return text, nil
+	}
+	if _, ok := in.Records[t.Name]; ok {
+		return nil, fmt.Errorf("%s is a record; set its fields one at a time", t.Name)
+	}
+	if text == "none" && t.Optional {
+		return nil, nil
+	}
+
+	switch strings.ToLower(t.Name) {
+	case "int", "integer", "long", "bigint":
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a whole number for %s, got %q", t.Name, text)
+		}
+		return n, nil
+	}
+	switch analyzer.KindOf(t) {
+	case analyzer.KindNumber:
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number for %s, got %q", t.Name, text)
+		}
+		return n, nil
+	case analyzer.KindBoolean:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("expected true or false for %s, got %q", t.Name, text)
+		}
+		return b, nil
+	case analyzer.KindList:
+		items := []interface{}{}
+		if text == "" {
+			return items, nil
+		}
+		for _, part := range strings.Split(text, ",") {
+			item, err := in.ParseValue(t.Element, strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case analyzer.KindMap:
+		entries := map[string]interface{}{}
+		if text == "" {
+			return entries, nil
+		}
+		for _, part := range strings.Split(text, ",") {
+			key, value, ok := strings.Cut(part, "=")
+			if !ok {
+				return nil, fmt.Errorf("expected key=value for an entry of %s, got %q", t.Name, strings.TrimSpace(part))
+			}
+			parsedKey, err := in.ParseValue(t.Key, strings.TrimSpace(key))
+			if err != nil {
+				return nil, err
+			}
+			entry, err := in.ParseValue(t.Value, strings.TrimSpace(value))
+			if err != nil {
+				return nil, err
+			}
+			entries[fmt.Sprint(parsedKey)] = entry
+		}
+		return entries, nil
+	}
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		return unquoted, nil
+	}
+	return text, nil
+}
+
+// Format renders a value as CloudPact source would write it
+func Format(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "none"
+	case string:
+		return fmt.Sprintf("%q", x)
+	case []interface{}:
+		parts := make([]string, len(x))
+		for i, item := range x {
+			parts[i] = Format(item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case map[string]interface{}:
+		names := make([]string, 0, len(x))
+		for name := range x {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts := make([]string, len(names))
+		for i, name := range names {
+			parts[i] = name + ": " + Format(x[name])
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	}
+	return fmt.Sprint(v)
+}
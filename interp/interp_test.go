@@ -0,0 +1,322 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+const source = `define record Order
+    id: uuid
+    total: usd_currency
+    coupon: text optional
+
+define record Customer
+    email: email
+    orders: list[Order]
+
+function discount(order: Order) returns usd_currency
+    why: "Large orders earn a loyalty discount"
+    do:
+        if order.total < 0
+            then fail "order total must not be negative"
+        return 10 if order.total > 100 else 0
+
+function bigSpender(customer: Customer) returns boolean
+    why: "Customers with three large orders get early access"
+    do:
+        set big = count of customer.orders where total > 100
+        return big >= 3
+
+function couponCode(order: Order) returns text
+    why: "Orders without a coupon show NONE on invoices"
+    do:
+        return order.coupon or "NONE"
+`
+
+func load(t *testing.T) *Interpreter {
+	t.Helper()
+	file, err := grammar.ParseString(source)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	return New(file)
+}
+
+func order(total float64) map[string]interface{} {
+	return map[string]interface{}{"id": "o1", "total": total}
+}
+
+func TestCall(t *testing.T) {
+	in := load(t)
+
+	tests := []struct {
+		function string
+		arg      interface{}
+		want     interface{}
+	}{
+		{"discount", order(150), int64(10)},
+		{"discount", order(50), int64(0)},
+		{"couponCode", order(50), "NONE"},
+		{"couponCode", map[string]interface{}{"coupon": "SPRING"}, "SPRING"},
+		{"bigSpender", map[string]interface{}{"orders": []interface{}{order(101), order(200), order(300), order(5)}}, true},
+		{"bigSpender", map[string]interface{}{"orders": []interface{}{order(101)}}, false},
+	}
+	for _, tt := range tests {
+		got, err := in.Call(tt.function, []interface{}{tt.arg})
+		if err != nil {
+			t.Fatalf("%s(%v): %v", tt.function, tt.arg, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s(%v) = %v, want %v", tt.function, tt.arg, got, tt.want)
+		}
+	}
+
+	_, err := in.Call("discount", []interface{}{order(-1)})
+	var failure *Failure
+	if !errors.As(err, &failure) || failure.Message != "order total must not be negative" {
+		t.Fatalf("expected the fail message, got %v", err)
+	}
+	if _, err := in.Call("discount", nil); err == nil {
+		t.Fatal("expected an argument count error")
+	}
+}
+
+func TestEvalAndExec(t *testing.T) {
+	in := load(t)
+	env := Env{"orders": []interface{}{order(10), order(20.5)}, "tags": []interface{}{"rush", "gift"}}
+
+	eval := func(s string) interface{} {
+		t.Helper()
+		expr, err := grammar.ParseExpression(s)
+		if err != nil {
+			t.Fatalf("parse %q: %v", s, err)
+		}
+		value, err := in.Eval(expr, env)
+		if err != nil {
+			t.Fatalf("eval %q: %v", s, err)
+		}
+		return value
+	}
+
+	if got := eval("sum of orders select total"); got != 30.5 {
+		t.Errorf("sum = %v", got)
+	}
+	if got := Format(eval("orders where total > 15 select id")); got != `["o1"]` {
+		t.Errorf("query = %s", got)
+	}
+	if got := eval(`"hello world" contains "world"`); got != true {
+		t.Errorf("contains = %v", got)
+	}
+	for s, want := range map[string]interface{}{
+		"first of orders where total > 15 select total": 20.5,
+		"last of orders select total":                   20.5,
+		"first of orders where total > 100":             nil,
+		"length of orders":                              int64(2),
+		`length of "héllo"`:                             int64(5),
+		`"rush" in tags`:                                true,
+		`"sale" not in tags`:                            true,
+		"tags is not empty":                             true,
+		`"" is empty`:                                   true,
+		`"rush" is not "gift"`:                          true,
+	} {
+		if got := eval(s); got != want {
+			t.Errorf("%s = %v, want %v", s, got, want)
+		}
+	}
+
+	stmt, err := grammar.ParseStatement(`create Order with: id = "o2" total = 5`)
+	if err != nil {
+		t.Fatalf("parse create: %v", err)
+	}
+	if _, returned, err := in.Exec(stmt, env); err != nil || returned {
+		t.Fatalf("exec create: %v", err)
+	}
+	if got := eval("discount(order)"); got != int64(0) {
+		t.Errorf("discount(order) = %v", got)
+	}
+
+	expr, _ := grammar.ParseExpression("missing.total")
+	if _, err := in.Eval(expr, env); err == nil {
+		t.Fatal("expected an undefined variable error")
+	}
+}
+
+func TestSample(t *testing.T) {
+	in := load(t)
+	customer, ok := in.Sample(&grammar.Type{Name: "Customer"}).(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected a record sample")
+	}
+	if customer["email"] != "user@example.com" {
+		t.Errorf("email sample = %v", customer["email"])
+	}
+	orders, ok := customer["orders"].([]interface{})
+	if !ok || len(orders) != 1 {
+		t.Fatalf("orders sample = %v", customer["orders"])
+	}
+	if got, err := in.Call("discount", []interface{}{orders[0]}); err != nil || got != int64(0) {
+		t.Errorf("discount(sample) = %v, %v", got, err)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	in := load(t)
+	cases := []struct {
+		typ  *grammar.Type
+		text string
+		want interface{}
+	}{
+		{&grammar.Type{Name: "text"}, "Ada", "Ada"},
+		{&grammar.Type{Name: "text"}, `"02134"`, "02134"},
+		{&grammar.Type{Name: "int"}, "42", int64(42)},
+		{&grammar.Type{Name: "usd_currency"}, "9.99", 9.99},
+		{&grammar.Type{Name: "boolean"}, "true", true},
+		{&grammar.Type{Name: "text", Optional: true}, "none", nil},
+	}
+	for _, c := range cases {
+		got, err := in.ParseValue(c.typ, c.text)
+		if err != nil || got != c.want {
+			t.Errorf("ParseValue(%s, %q) = %v, %v; want %v", c.typ.Name, c.text, got, err, c.want)
+		}
+	}
+
+	list, err := in.ParseValue(&grammar.Type{Name: "list", Element: &grammar.Type{Name: "int"}}, "1, 2")
+	if err != nil || Format(list) != "[1, 2]" {
+		t.Errorf("list = %v, %v", Format(list), err)
+	}
+	scores := &grammar.Type{Name: "map", Key: &grammar.Type{Name: "text"}, Value: &grammar.Type{Name: "int"}}
+	entries, err := in.ParseValue(scores, "ada=3, bob=5")
+	if err != nil || Format(entries) != "{ada: 3, bob: 5}" {
+		t.Errorf("map = %v, %v", Format(entries), err)
+	}
+	if _, err := in.ParseValue(scores, "ada"); err == nil {
+		t.Error("expected an error for a map entry without a value")
+	}
+	if _, err := in.ParseValue(&grammar.Type{Name: "int"}, "many"); err == nil {
+		t.Error("expected an error for a malformed number")
+	}
+	if _, err := in.ParseValue(&grammar.Type{Name: "Customer"}, "x"); err == nil {
+		t.Error("expected an error for a record")
+	}
+}
+
+func TestDebugger(t *testing.T) {
+	in := load(t)
+
+	var stops []string
+	actions := []Action{StepOver, StepIn, StepOver, Continue}
+	in.Debugger = NewDebugger(func(stop *Stop) Action {
+		frame := stop.Stack[0]
+		stops = append(stops, fmt.Sprintf("%s:%d:%s", frame.Function, frame.Position.Line, stop.Reason))
+		action := actions[0]
+		actions = actions[1:]
+		return action
+	})
+	in.Debugger.StopOnEntry = true
+
+	if _, err := in.Call("discount", []interface{}{order(150)}); err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	want := []string{"discount:13:entry", "discount:15:step"}
+	if strings.Join(stops, " ") != strings.Join(want, " ") {
+		t.Fatalf("stops = %v, want %v", stops, want)
+	}
+
+	// A breakpoint stops a run that is not stepping, with the frame's variables
+	stops = nil
+	actions = []Action{Continue}
+	in.Debugger = NewDebugger(func(stop *Stop) Action {
+		stops = append(stops, fmt.Sprintf("%s:%d:%s", stop.Stack[0].Function, stop.Stack[0].Position.Line, stop.Reason))
+		if _, ok := stop.Stack[0].Variables["order"]; !ok {
+			t.Errorf("expected order among the variables, got %v", stop.Stack[0].Variables)
+		}
+		return Continue
+	})
+	in.Debugger.SetBreakpoints("", []int{15})
+	in.Call("discount", []interface{}{order(150)})
+	if len(stops) != 1 || stops[0] != "discount:15:breakpoint" {
+		t.Fatalf("stops = %v", stops)
+	}
+
+	in.Debugger = NewDebugger(func(*Stop) Action { return Terminate })
+	in.Debugger.StopOnEntry = true
+	if _, err := in.Call("discount", []interface{}{order(150)}); !errors.Is(err, ErrTerminated) {
+		t.Fatalf("expected ErrTerminated, got %v", err)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	in := load(t)
+	in.Trace = &Trace{}
+	if _, err := in.Call("discount", []interface{}{order(150)}); err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	want := `discount(order = {id: "o1", total: 150})
+  why: Large orders earn a loyalty discount
+  line 13: if order.total < 0 is false, so nothing runs
+  line 15: order.total > 100 is true, so 10 is chosen
+  line 15: discount returned 10 because order.total < 0 is false and order.total > 100 is true
+`
+	if got := in.Trace.String(); got != want {
+		t.Fatalf("trace:\n%s\nwant:\n%s", got, want)
+	}
+
+	in.Trace = &Trace{}
+	in.Call("discount", []interface{}{order(-5)})
+	if got := in.Trace.Steps[len(in.Trace.Steps)-1]; got.Kind != "fail" ||
+		got.Text != `failed with "order total must not be negative" because order.total < 0 is true` {
+		t.Fatalf("unexpected last step %+v", got)
+	}
+}
+
+func TestRunTest(t *testing.T) {
+	src := source + `
+function checkout(order: Order) returns boolean
+    why: "Large orders are reviewed by hand"
+    do:
+        if order.total > 1000 then fail "order needs review"
+        return true
+
+test "small orders get no discount":
+    create Order with:
+        total = 20
+    expect discount(order) is 0
+
+test "large orders fail":
+    create Order with:
+        total = 5000
+    expect checkout(order) fails "order needs review"
+
+test "wrong discount":
+    expect discount(order) is 10
+`
+	file, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	in := New(file)
+	if len(file.Tests) != 3 {
+		t.Fatalf("expected three tests, got %d", len(file.Tests))
+	}
+	for _, test := range file.Tests[:2] {
+		if err := in.RunTest(test); err != nil {
+			t.Errorf("%s: %v", test.Name, err)
+		}
+	}
+	err = in.RunTest(file.Tests[2])
+	var assertion *AssertionError
+	if !errors.As(err, &assertion) || !strings.Contains(assertion.Message, "to be 10, but it was 0") {
+		t.Fatalf("expected an assertion failure, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package interp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Trace records the decisions of a run: every condition and what it
+// evaluated to, the branch taken, and the conditions a function's result
+// or failure depended on
+type Trace struct {
+	Steps []*Step `json:"steps"`
+}
+
+// Step is one entry of the decision log
+type Step struct {
+	Depth int    `json:"depth"` // nesting; a call's steps are one deeper than the call
+	Kind  string `json:"kind"`  // call, why, condition, set, create, return or fail
+	Line  int    `json:"line,omitempty"`
+	Text  string `json:"text"`
+}
+
+// String renders the trace as an indented decision log
+func (t *Trace) String() string {
+	var b strings.Builder
+	for _, step := range t.Steps {
+		b.WriteString(strings.Repeat("  ", step.Depth))
+		if step.Line > 0 {
+			fmt.Fprintf(&b, "line %d: ", step.Line)
+		}
+		b.WriteString(step.Text)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (in *Interpreter) record(kind string, pos *grammar.Position, format string, args ...interface{}) {
+	// A call's steps are indented under it
+	step := &Step{Depth: len(in.stack), Kind: kind, Text: fmt.Sprintf(format, args...)}
+	if kind == "call" {
+		step.Depth--
+	}
+	if pos != nil {
+		step.Line = pos.Line
+	}
+	in.Trace.Steps = append(in.Trace.Steps, step)
+}
+
+// decide notes that condition evaluated to value, for explaining the
+// result of the call it was made in
+func (in *Interpreter) decide(f *frame, condition grammar.Expression, value bool) {
+	f = rootFrame(f)
+	f.decisions = append(f.decisions, fmt.Sprintf("%s is %t", grammar.FormatExpression(condition), value))
+}
+
+// rootFrame is the call a query frame belongs to
+func rootFrame(f *frame) *frame {
+	for f.outer != nil {
+		f = f.outer
+	}
+	return f
+}
+
+// because explains an outcome by the decisions made on the way to it
+func because(f *frame) string {
+	if len(f.decisions) == 0 {
+		return "unconditionally"
+	}
+	return "because " + strings.Join(f.decisions, " and ")
+}
+
+func (in *Interpreter) traceCall(function *grammar.Function, args []interface{}) {
+	parts := make([]string, len(args))
+	for i, param := range function.Parameters {
+		parts[i] = param.Name + " = " + Format(args[i])
+	}
+	in.record("call", nil, "%s(%s)", function.Name, strings.Join(parts, ", "))
+	if function.Why != "" {
+		in.record("why", nil, "why: %s", function.Why)
+	}
+}
+
+func (in *Interpreter) traceReturn(function *grammar.Function, f *frame, value interface{}, err error) {
+	if err != nil {
+		return
+	}
+	in.record("return", f.pos, "%s returned %s %s", function.Name, Format(value), because(f))
+}
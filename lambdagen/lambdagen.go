@@ -0,0 +1,232 @@
+// Package lambdagen deploys CloudPact functions one by one to AWS Lambda
+// behind API Gateway. Each function gets a main package with an adapter
+// that takes API Gateway proxy events to the function's HTTP handler,
+// checking record parameters first, and a SAM template or serverless.yml
+// ties them together. The adapters live in the main packages, so the
+// packages of the generated Go stay as the build writes them.
+package lambdagen
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Options tunes the generated files
+type Options struct {
+	// Header is the provenance comment each file starts with; empty means
+	// none
+	Header string
+	// Packages maps records to the import path of the Go package they are
+	// generated into, for the record parameters the adapters check
+	Packages map[string]string
+}
+
+// Path is where API Gateway serves a function: POST /<name>, as the
+// generated server does
+func Path(function *grammar.Function) string {
+	return "/" + strings.ToLower(function.Name)
+}
+
+// exportedName is the exported Go name of a function's handler
+func exportedName(name string) string {
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// adapterName is the name of a function's adapter in its main package
+func adapterName(function *grammar.Function) string {
+	return strings.ToLower(function.Name[:1]) + function.Name[1:] + "Lambda"
+}
+
+// GenerateMain writes the main package of function, from the Go package
+// at importPath that files are generated into, with its adapter and a main
+// starting it. A function with requires clauses is checked by auth, a
+// variable of package main that another file sets. When the code is not
+// valid Go, it is returned unformatted along with the error.
+func GenerateMain(importPath string, files []*grammar.File, function *grammar.Function, opts Options) ([]byte, error) {
+	records := make(map[string]bool)
+	for _, file := range files {
+		for _, record := range file.Records {
+			records[record.Name] = true
+		}
+	}
+
+	var code strings.Builder
+	start := adapterName(function) + "(nil)"
+	if function.Access != nil {
+		start = adapterName(function) + "(auth, nil)"
+		code.WriteString("// auth checks the callers of the function, which is declared with\n")
+		code.WriteString("// requires. Set it from another file of this package, such as in an init\n")
+		code.WriteString("// function; while it is nil they are answered with 401.\n")
+		code.WriteString("var auth func(next http.Handler, roles []string) http.Handler\n\n")
+	}
+	code.WriteString(fmt.Sprintf("func main() {\n\tlambda.Start(%s)\n}\n\n", start))
+	code.WriteString(adapter(path.Base(importPath), function, records))
+	code.WriteString(runtime)
+
+	imports := []string{"context", "encoding/base64", "encoding/json", "net/http", "strings", "github.com/aws/aws-lambda-go/events", "github.com/aws/aws-lambda-go/lambda", importPath}
+	formatted, err := gogen.RenderFile("main", imports, code.String(), opts.Packages)
+	if err != nil {
+		return formatted, err
+	}
+	return codegen.Stamp("//", opts.Header, formatted), nil
+}
+
+// adapter writes the <function>Lambda of function, which checks the
+// parameters whose type is one of records before the handler of package
+// pkg runs
+func adapter(pkg string, function *grammar.Function, records map[string]bool) string {
+	name := exportedName(function.Name)
+	lambdaName := adapterName(function)
+	var params, checks strings.Builder
+	for _, param := range function.Parameters {
+		t := param.Type
+		list := t.Name == "list" && t.Element != nil
+		if list {
+			t = t.Element
+		}
+		required := *t
+		required.Optional = false
+		if !records[t.Name] || gogen.FieldType(&required) != t.Name {
+			continue
+		}
+		field := exportedName(param.Name)
+		if list {
+			params.WriteString(fmt.Sprintf("\t\t\t%s []%s `json:%q`\n", field, gogen.FieldType(t), param.Name))
+			checks.WriteString(fmt.Sprintf("\t\tfor i := range params.%s {\n", field))
+			checks.WriteString(fmt.Sprintf("\t\t\tif err := params.%s[i].Validate(); err != nil {\n\t\t\t\treturn err\n\t\t\t}\n\t\t}\n", field))
+			continue
+		}
+		params.WriteString(fmt.Sprintf("\t\t\t%s *%s `json:%q`\n", field, t.Name, param.Name))
+		checks.WriteString(fmt.Sprintf("\t\tif params.%s != nil {\n", field))
+		checks.WriteString(fmt.Sprintf("\t\t\tif err := params.%s.Validate(); err != nil {\n\t\t\t\treturn err\n\t\t\t}\n\t\t}\n", field))
+	}
+
+	var code strings.Builder
+	code.WriteString(fmt.Sprintf("// %s serves %s to API Gateway proxy events, as %s.%sHandler\n", lambdaName, function.Name, pkg, name))
+	if params.Len() > 0 {
+		code.WriteString("// serves it over HTTP, once the record parameters pass Validate; a\n")
+		code.WriteString("// record that does not is answered with 422. Start it with lambda.Start.\n")
+	} else {
+		code.WriteString("// serves it over HTTP. Start it with lambda.Start.\n")
+	}
+	handlerArgs := "respond"
+	if function.Access != nil {
+		code.WriteString(fmt.Sprintf("func %s(auth func(next http.Handler, roles []string) http.Handler, respond func(r *http.Request, header http.Header)) func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {\n", lambdaName))
+		handlerArgs = "auth, respond"
+	} else {
+		code.WriteString(fmt.Sprintf("func %s(respond func(r *http.Request, header http.Header)) func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {\n", lambdaName))
+	}
+	code.WriteString(fmt.Sprintf("\thandler := %s.%sHandler(%s)\n", pkg, name, handlerArgs))
+	if params.Len() == 0 {
+		code.WriteString("\treturn func(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {\n")
+		code.WriteString("\t\treturn serveLambda(ctx, handler, event, nil)\n\t}\n}\n\n")
+		return code.String()
+	}
+	code.WriteString("\tvalidate := func(body []byte) error {\n")
+	code.WriteString("\t\tvar params struct {\n")
+	code.WriteString(params.String())
+	code.WriteString("\t\t}\n")
+	code.WriteString("\t\tif err := json.Unmarshal(body, &params); err != nil {\n")
+	code.WriteString("\t\t\treturn nil // the handler answers a body it cannot decode\n")
+	code.WriteString("\t\t}\n")
+	code.WriteString(checks.String())
+	code.WriteString("\t\treturn nil\n\t}\n")
+	code.WriteString("\treturn func(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {\n")
+	code.WriteString("\t\treturn serveLambda(ctx, handler, event, validate)\n\t}\n}\n\n")
+	return code.String()
+}
+
+// runtime is the code every main package shares: serving an event with an
+// HTTP handler and collecting its response
+const runtime = `// lambdaResponse collects what a handler writes, for API Gateway
+type lambdaResponse struct {
+	header http.Header
+	status int
+	body   strings.Builder
+}
+
+func (w *lambdaResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *lambdaResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *lambdaResponse) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+// serveLambda serves an API Gateway proxy event with handler as the HTTP
+// request it stands for, once validate, when given, accepts the body. The
+// request ID of API Gateway is the X-Request-ID of the request, unless it
+// has one, so errors carry it.
+func serveLambda(ctx context.Context, handler http.Handler, event events.APIGatewayProxyRequest, validate func(body []byte) error) (events.APIGatewayProxyResponse, error) {
+	requestID := event.RequestContext.RequestID
+	body := event.Body
+	if event.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return lambdaError(http.StatusBadRequest, "bad_request", "the body is not valid base64", requestID), nil
+		}
+		body = string(decoded)
+	}
+	r, err := http.NewRequestWithContext(ctx, event.HTTPMethod, event.Path, strings.NewReader(body))
+	if err != nil {
+		return lambdaError(http.StatusBadRequest, "bad_request", err.Error(), requestID), nil
+	}
+	for name, values := range event.MultiValueHeaders {
+		for _, value := range values {
+			r.Header.Add(name, value)
+		}
+	}
+	for name, value := range event.Headers {
+		if r.Header.Get(name) == "" {
+			r.Header.Set(name, value)
+		}
+	}
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		requestID = id
+	} else if requestID != "" {
+		r.Header.Set("X-Request-ID", requestID)
+	}
+	if validate != nil && r.Method == http.MethodPost {
+		if err := validate([]byte(body)); err != nil {
+			return lambdaError(http.StatusUnprocessableEntity, "invalid", err.Error(), requestID), nil
+		}
+	}
+
+	w := &lambdaResponse{header: make(http.Header)}
+	handler.ServeHTTP(w, r)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode:        w.status,
+		MultiValueHeaders: w.header,
+		Body:              w.body.String(),
+	}, nil
+}
+
+// lambdaError answers with the Error object the HTTP handlers write
+func lambdaError(status int, code, message, requestID string) events.APIGatewayProxyResponse {
+	fields := map[string]string{"code": code, "message": message}
+	if requestID != "" {
+		fields["requestId"] = requestID
+	}
+	body, _ := json.Marshal(fields)
+	return events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body) + "\n",
+	}
+}
+`
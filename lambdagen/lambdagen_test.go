@@ -0,0 +1,157 @@
+package lambdagen
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/internal/testutil"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+const orders = `module Orders
+
+define record Order
+    total: usd_currency min 1
+
+function placeOrder(order: Order) returns Order
+    why: "Places an order"
+    do:
+        return order
+
+function cancelOrder(number: text) returns text
+    requires role admin
+    why: "Cancels an order"
+    do:
+        return number`
+
+func TestGenerateMain(t *testing.T) {
+	f := testutil.CheckedFile(t, orders)
+	packages := map[string]string{"Order": "example.com/shop/orders"}
+	out, err := GenerateMain("example.com/shop/orders", []*grammar.File{f}, f.Functions[0], Options{Header: "Code generated by cloudpact. DO NOT EDIT.", Packages: packages})
+	if err != nil {
+		t.Fatalf("GenerateMain error: %v\n%s", err, out)
+	}
+	code := string(out)
+	for _, want := range []string{
+		"// Code generated by cloudpact. DO NOT EDIT.",
+		"package main",
+		"\"github.com/aws/aws-lambda-go/events\"",
+		"\"example.com/shop/orders\"",
+		"lambda.Start(placeOrderLambda(nil))",
+		"func placeOrderLambda(respond func(r *http.Request, header http.Header)) func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {",
+		"handler := orders.PlaceOrderHandler(respond)",
+		"Order *orders.Order `json:\"order\"`",
+		"if err := params.Order.Validate(); err != nil {",
+		"func serveLambda(",
+		"func lambdaError(",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in output:\n%s", want, code)
+		}
+	}
+
+	out, err = GenerateMain("example.com/shop/orders", []*grammar.File{f}, f.Functions[1], Options{Packages: packages})
+	if err != nil {
+		t.Fatalf("GenerateMain error: %v\n%s", err, out)
+	}
+	code = string(out)
+	for _, want := range []string{
+		"var auth func(next http.Handler, roles []string) http.Handler",
+		"lambda.Start(cancelOrderLambda(auth, nil))",
+		"handler := orders.CancelOrderHandler(auth, respond)",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in output:\n%s", want, code)
+		}
+	}
+}
+
+func TestLambdaServesRecord(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("needs the go command")
+	}
+	f := testutil.CheckedFile(t, orders)
+	core, err := gogen.GenerateFile(f, gogen.Options{})
+	if err != nil {
+		t.Fatalf("GenerateFile error: %v\n%s", err, core)
+	}
+	main, err := GenerateMain("example.com/shop/orders", []*grammar.File{f}, f.Functions[0], Options{Packages: map[string]string{"Order": "example.com/shop/orders"}})
+	if err != nil {
+		t.Fatalf("GenerateMain error: %v\n%s", err, main)
+	}
+
+	// A valid order passes Validate and reaches placeOrder
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "orders"), 0755)
+	os.MkdirAll(filepath.Join(dir, "placeorder"), 0755)
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/shop\n\ngo 1.22\n\nrequire github.com/aws/aws-lambda-go v1.49.0\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "orders", "orders.go"), core, 0644)
+	os.WriteFile(filepath.Join(dir, "placeorder", "main.go"), main, 0644)
+	os.WriteFile(filepath.Join(dir, "placeorder", "main_test.go"), []byte(`package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestPlaceOrderLambda(t *testing.T) {
+	event := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/placeorder",
+		Body:       `+"`"+`{"order": {"id": "123e4567-e89b-12d3-a456-426614174000", "total": 25}}`+"`"+`,
+	}
+	resp, err := placeOrderLambda(nil)(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || !strings.Contains(resp.Body, `+"`"+`"total":25`+"`"+`) {
+		t.Fatalf("got %d %s", resp.StatusCode, resp.Body)
+	}
+}
+`), 0644)
+	cmd := exec.Command("go", "test", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GONOSUMDB=github.com/aws/aws-lambda-go")
+	out, err := cmd.CombinedOutput()
+	if err != nil && strings.Contains(string(out), "aws-lambda-go@") && !strings.Contains(string(out), "--- FAIL") {
+		t.Skipf("aws-lambda-go is not available: %s", out)
+	}
+	if err != nil {
+		t.Fatalf("go test: %v\n%s", err, out)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	functions := []Function{{Name: "placeOrder", Path: "/placeorder", Dir: "../go/cmd/lambda/placeorder"}}
+	sam := string(GenerateSAM("shop", functions, Options{Header: "Code generated by cloudpact. DO NOT EDIT."}))
+	for _, want := range []string{
+		"# Code generated by cloudpact. DO NOT EDIT.",
+		"Transform: AWS::Serverless-2016-10-31\n",
+		"  PlaceOrderFunction:\n    Type: AWS::Serverless::Function\n",
+		"      CodeUri: ../go/cmd/lambda/placeorder\n",
+		"            Path: /placeorder\n            Method: post\n",
+	} {
+		if !strings.Contains(sam, want) {
+			t.Errorf("expected %q in template:\n%s", want, sam)
+		}
+	}
+
+	serverless := string(GenerateServerless("shop", functions, Options{}))
+	for _, want := range []string{
+		"service: shop\n",
+		"  placeOrder: # ../go/cmd/lambda/placeorder\n",
+		"      artifact: build/placeorder.zip\n",
+		"          path: placeorder\n",
+	} {
+		if !strings.Contains(serverless, want) {
+			t.Errorf("expected %q in serverless.yml:\n%s", want, serverless)
+		}
+	}
+}
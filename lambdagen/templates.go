@@ -0,0 +1,72 @@
+package lambdagen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+)
+
+// Function is a function deployed on its own: its name, the path API
+// Gateway serves it at and the directory of its main package, relative to
+// the template
+type Function struct {
+	Name string
+	Path string
+	Dir  string
+}
+
+// resourceName is the logical ID of a function's resources in a template,
+// such as PlaceOrderFunction
+func resourceName(function Function) string {
+	return exportedName(function.Name) + "Function"
+}
+
+// GenerateSAM writes template.yaml, an AWS SAM template deploying each of
+// functions as a Lambda behind one API Gateway API, built by sam build
+func GenerateSAM(service string, functions []Function, opts Options) []byte {
+	var b strings.Builder
+	b.WriteString("AWSTemplateFormatVersion: \"2010-09-09\"\n")
+	b.WriteString("Transform: AWS::Serverless-2016-10-31\n")
+	b.WriteString(fmt.Sprintf("Description: The functions of %s, each as a Lambda behind API Gateway\n\n", service))
+	b.WriteString("Globals:\n  Function:\n")
+	b.WriteString("    Runtime: provided.al2023\n    Architectures: [arm64]\n    Handler: bootstrap\n")
+	b.WriteString("    MemorySize: 128\n    Timeout: 10\n\n")
+	b.WriteString("Resources:\n")
+	for _, function := range functions {
+		b.WriteString(fmt.Sprintf("  %s:\n", resourceName(function)))
+		b.WriteString("    Type: AWS::Serverless::Function\n")
+		b.WriteString("    Metadata:\n      BuildMethod: go1.x\n")
+		b.WriteString("    Properties:\n")
+		b.WriteString(fmt.Sprintf("      CodeUri: %s\n", function.Dir))
+		b.WriteString("      Events:\n        Api:\n          Type: Api\n          Properties:\n")
+		b.WriteString(fmt.Sprintf("            Path: %s\n            Method: post\n", function.Path))
+	}
+	b.WriteString("\nOutputs:\n  ApiUrl:\n")
+	b.WriteString("    Description: Where the API serves the functions\n")
+	b.WriteString("    Value:\n      Fn::Sub: https://${ServerlessRestApi}.execute-api.${AWS::Region}.amazonaws.com/Prod/\n")
+	return codegen.Stamp("#", opts.Header, []byte(b.String()))
+}
+
+// GenerateServerless writes serverless.yml, deploying each of functions
+// with the Serverless Framework from a zip of its bootstrap binary
+func GenerateServerless(service string, functions []Function, opts Options) []byte {
+	var b strings.Builder
+	b.WriteString("# Build each function's zip from this directory before deploying:\n")
+	b.WriteString("#   GOOS=linux GOARCH=arm64 go build -tags lambda.norpc -o bootstrap <dir>\n")
+	b.WriteString("#   zip build/<function>.zip bootstrap\n")
+	b.WriteString("# where <dir> is the main package named beside each function.\n\n")
+	b.WriteString(fmt.Sprintf("service: %s\n", service))
+	b.WriteString("frameworkVersion: \"3\"\n\n")
+	b.WriteString("provider:\n  name: aws\n  runtime: provided.al2023\n  architecture: arm64\n  memorySize: 128\n  timeout: 10\n\n")
+	b.WriteString("package:\n  individually: true\n\n")
+	b.WriteString("functions:\n")
+	for _, function := range functions {
+		b.WriteString(fmt.Sprintf("  %s: # %s\n", function.Name, function.Dir))
+		b.WriteString("    handler: bootstrap\n")
+		b.WriteString(fmt.Sprintf("    package:\n      artifact: build/%s.zip\n", strings.ToLower(function.Name)))
+		b.WriteString("    events:\n      - http:\n")
+		b.WriteString(fmt.Sprintf("          path: %s\n          method: post\n", strings.TrimPrefix(function.Path, "/")))
+	}
+	return codegen.Stamp("#", opts.Header, []byte(b.String()))
+}
@@ -0,0 +1,92 @@
+package mobilegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// kotlinKeywords are the hard keywords, which name a property only in
+// backticks
+var kotlinKeywords = map[string]bool{
+	"as": true, "break": true, "class": true, "continue": true, "do": true, "else": true, "false": true,
+	"for": true, "fun": true, "if": true, "in": true, "interface": true, "is": true, "null": true,
+	"object": true, "package": true, "return": true, "super": true, "this": true, "throw": true,
+	"true": true, "try": true, "typealias": true, "typeof": true, "val": true, "var": true,
+	"when": true, "while": true,
+}
+
+// KotlinType maps a CloudPact type to Kotlin. Integers are Long, as Go's
+// int is 64 bits; "maybe" types are nullable.
+func KotlinType(t *grammar.Type) string {
+	if t.Nullable {
+		inner := *t
+		inner.Nullable = false
+		return KotlinType(&inner) + "?"
+	}
+	if t.Name == "list" && t.Element != nil {
+		return "List<" + KotlinType(t.Element) + ">"
+	}
+	if t.Name == "map" && t.Key != nil {
+		return fmt.Sprintf("Map<%s, %s>", KotlinType(t.Key), KotlinType(t.Value))
+	}
+	return scalarType(t.Name, "Long", "Double", "Boolean", "String")
+}
+
+// GenerateKotlin translates a checked file into Kotlin: a kotlinx
+// serialization data class per record and model, in a package named after
+// the module. Optional properties default to null so they may be absent.
+func GenerateKotlin(file *grammar.File, opts Options) ([]byte, error) {
+	var code strings.Builder
+	code.WriteString("// Generated Kotlin models from CloudPact\n")
+	if file.Module != nil {
+		code.WriteString(fmt.Sprintf("package %s\n", strings.ToLower(file.Module.Name)))
+	}
+	code.WriteString("\nimport kotlinx.serialization.Serializable\n")
+
+	for _, decl := range typeDecls(file) {
+		code.WriteString("\n")
+		if len(decl.doc) > 0 {
+			code.WriteString("/**\n")
+			for _, line := range decl.doc {
+				code.WriteString(strings.TrimRight(" * "+strings.ReplaceAll(line, "*/", "* /"), " ") + "\n")
+			}
+			code.WriteString(" */\n")
+		}
+		code.WriteString("@Serializable\n")
+		if len(decl.properties) == 0 && !decl.implicitID && !decl.versioned {
+			// A data class needs a property
+			code.WriteString(fmt.Sprintf("class %s\n", decl.name))
+			continue
+		}
+		code.WriteString(fmt.Sprintf("data class %s(\n", decl.name))
+		if decl.implicitID {
+			code.WriteString("    val id: String, // UUID\n")
+		}
+		if decl.versioned {
+			code.WriteString("    val version: Long, // increases with every update\n")
+		}
+		for _, p := range decl.properties {
+			for _, line := range p.doc {
+				code.WriteString(strings.TrimRight("    // "+line, " ") + "\n")
+			}
+			kotlinType := KotlinType(p.t)
+			if p.optional && !strings.HasSuffix(kotlinType, "?") {
+				kotlinType += "?"
+			}
+			line := fmt.Sprintf("    val %s: %s", escapeName(p.name, kotlinKeywords), kotlinType)
+			if p.optional {
+				line += " = null"
+			}
+			line += ","
+			if p.comment != "" {
+				line += " // " + p.comment
+			}
+			code.WriteString(line + "\n")
+		}
+		code.WriteString(")\n")
+	}
+	return codegen.Stamp("//", opts.Header, []byte(code.String())), nil
+}
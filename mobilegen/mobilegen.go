@@ -0,0 +1,114 @@
+// Package mobilegen translates checked CloudPact records and models into
+// the model types of mobile apps: Kotlin data classes for Android and Swift
+// Codable structs for iOS. Both follow the TypeScript interfaces: properties
+// are named by their JSON keys, optional fields may be absent, and "maybe"
+// fields are present but may be null.
+package mobilegen
+
+import (
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Options tunes the generated code
+type Options struct {
+	// Header is the provenance comment the file starts with, usually
+	// codegen.Header; empty means none
+	Header string
+}
+
+// property is a record or model field as both languages declare it
+type property struct {
+	name     string
+	t        *grammar.Type
+	optional bool // may be absent, as opposed to null
+	comment  string
+	doc      []string
+}
+
+// typeDecl is a record or model: its own fields and those of the records
+// it extends, since neither data classes nor structs inherit
+type typeDecl struct {
+	name       string
+	doc        []string
+	implicitID bool
+	versioned  bool
+	properties []property
+}
+
+// typeDecls lists the records and then the legacy models of file
+func typeDecls(file *grammar.File) []typeDecl {
+	var decls []typeDecl
+	for _, record := range file.Records {
+		decl := typeDecl{
+			name:       record.Name,
+			doc:        codegen.DocLines(record.Leading, record.Trailing),
+			implicitID: record.HasImplicitID(),
+			versioned:  record.IsVersioned(),
+		}
+		for _, field := range record.AllFields() {
+			decl.properties = append(decl.properties, property{
+				name:     field.JSONKey(),
+				t:        field.Type,
+				optional: field.Type.Optional && !field.Type.Nullable, // "maybe" fields are present, if only as null
+				comment:  typeComment(field.Type),
+				doc:      codegen.DocLines(field.Leading, field.Trailing),
+			})
+		}
+		decls = append(decls, decl)
+	}
+	for _, model := range file.Models {
+		decl := typeDecl{name: model.Name, doc: codegen.DocLines(model.Leading, model.Trailing)}
+		for _, field := range model.Fields {
+			decl.properties = append(decl.properties, property{
+				name:     field.JSONKey(),
+				t:        field.Type,
+				optional: field.Type.Optional,
+				doc:      codegen.DocLines(field.Leading, field.Trailing),
+			})
+		}
+		decls = append(decls, decl)
+	}
+	return decls
+}
+
+// typeComment describes a field's type, naming the custom type it was
+// declared with, as the TypeScript comments do
+func typeComment(t *grammar.Type) string {
+	comment := codegen.TypeComment(t.Name)
+	if t.Alias == "" {
+		return comment
+	}
+	if comment == "" {
+		return t.Alias
+	}
+	return t.Alias + ", " + comment
+}
+
+// scalarType maps the CloudPact types that are not lists, maps or records.
+// Dates, times and durations stay ISO 8601 strings as in TypeScript.
+func scalarType(name, integer, float, boolean, text string) string {
+	switch strings.ToLower(name) {
+	case "int", "integer":
+		return integer
+	case "float", "number", "usd_currency", "eur_currency", "percentage":
+		return float
+	case "bool", "boolean":
+		return boolean
+	}
+	if codegen.IsRecordTypeName(name) {
+		return name
+	}
+	return text
+}
+
+// escapeName quotes name in backticks when it is a keyword of the language,
+// which both Kotlin and Swift allow for property names
+func escapeName(name string, keywords map[string]bool) string {
+	if keywords[name] {
+		return "`" + name + "`"
+	}
+	return name
+}
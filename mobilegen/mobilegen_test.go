@@ -0,0 +1,96 @@
+package mobilegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/internal/testutil"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+const fitness = `module Fitness
+
+// A logged workout
+define record Workout versioned
+    coachEmail: email
+    fee: usd_currency
+    laps: int
+    note: text optional
+    tags: list of text
+    splits: map<text, int>
+    route: maybe Route
+    class: text
+
+define record Race extends Workout
+    bib: text
+
+define record Route no id
+    start: text
+    zip: zip_code`
+
+// checkedFitness returns the fitness file with camelCase JSON names
+func checkedFitness(t *testing.T) *grammar.File {
+	t.Helper()
+	f := testutil.CheckedFile(t, fitness)
+	f.NameJSON("camel")
+	return f
+}
+
+func TestGenerateKotlin(t *testing.T) {
+	out, err := GenerateKotlin(checkedFitness(t), Options{Header: "Code generated by cloudpact. DO NOT EDIT."})
+	if err != nil {
+		t.Fatalf("GenerateKotlin error: %v", err)
+	}
+	code := string(out)
+	for _, want := range []string{
+		"// Code generated by cloudpact. DO NOT EDIT.",
+		"package fitness",
+		"import kotlinx.serialization.Serializable",
+		"/**\n * A logged workout\n */\n@Serializable\ndata class Workout(",
+		"    val id: String, // UUID",
+		"    val version: Long, // increases with every update",
+		"    val coachEmail: String, // Email address",
+		"    val laps: Long,",
+		"    val note: String? = null,",
+		"    val tags: List<String>,",
+		"    val splits: Map<String, Long>,",
+		"    val route: Route?,",
+		"    val `class`: String,",
+		"data class Race(\n    val id: String, // UUID\n    val version: Long, // increases with every update\n    val coachEmail: String,",
+		"    val bib: String,",
+		"data class Route(\n    val start: String,",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in output:\n%s", want, code)
+		}
+	}
+}
+
+func TestGenerateSwift(t *testing.T) {
+	out, err := GenerateSwift(checkedFitness(t), Options{})
+	if err != nil {
+		t.Fatalf("GenerateSwift error: %v", err)
+	}
+	code := string(out)
+	for _, want := range []string{
+		"// Module: Fitness",
+		"import Foundation",
+		"/// A logged workout\nstruct Workout: Codable, Equatable {",
+		"    var id: String // UUID",
+		"    var version: Int // increases with every update",
+		"    var coachEmail: String // Email address",
+		"    var laps: Int\n",
+		"    var note: String?\n",
+		"    var tags: [String]\n",
+		"    var splits: [String: Int]\n",
+		"    var route: Route?\n",
+		"    var `class`: String\n",
+		"struct Race: Codable, Equatable {\n    var id: String // UUID",
+		"    var bib: String\n",
+		"struct Route: Codable, Equatable {\n    var start: String\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in output:\n%s", want, code)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package mobilegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// swiftKeywords name a property only in backticks
+var swiftKeywords = map[string]bool{
+	"Any": true, "Self": true, "as": true, "associatedtype": true, "break": true, "case": true,
+	"catch": true, "class": true, "continue": true, "default": true, "defer": true, "deinit": true,
+	"do": true, "else": true, "enum": true, "extension": true, "fallthrough": true, "false": true,
+	"fileprivate": true, "for": true, "func": true, "guard": true, "if": true, "import": true,
+	"in": true, "init": true, "inout": true, "internal": true, "is": true, "let": true, "nil": true,
+	"open": true, "operator": true, "private": true, "protocol": true, "public": true, "repeat": true,
+	"rethrows": true, "return": true, "self": true, "static": true, "struct": true, "subscript": true,
+	"super": true, "switch": true, "throw": true, "throws": true, "true": true, "try": true,
+	"typealias": true, "var": true, "where": true, "while": true,
+}
+
+// SwiftType maps a CloudPact type to Swift; "maybe" types are optionals
+func SwiftType(t *grammar.Type) string {
+	if t.Nullable {
+		inner := *t
+		inner.Nullable = false
+		return SwiftType(&inner) + "?"
+	}
+	if t.Name == "list" && t.Element != nil {
+		return "[" + SwiftType(t.Element) + "]"
+	}
+	if t.Name == "map" && t.Key != nil {
+		return fmt.Sprintf("[%s: %s]", SwiftType(t.Key), SwiftType(t.Value))
+	}
+	return scalarType(t.Name, "Int", "Double", "Bool", "String")
+}
+
+// GenerateSwift translates a checked file into Swift: a Codable struct per
+// record and model. Optional and "maybe" properties are both Swift
+// optionals, since Codable decodes a missing key and a null alike.
+func GenerateSwift(file *grammar.File, opts Options) ([]byte, error) {
+	var code strings.Builder
+	code.WriteString("// Generated Swift models from CloudPact\n")
+	if file.Module != nil {
+		code.WriteString(fmt.Sprintf("// Module: %s\n", file.Module.Name))
+	}
+	code.WriteString("\nimport Foundation\n")
+
+	for _, decl := range typeDecls(file) {
+		code.WriteString("\n")
+		for _, line := range decl.doc {
+			code.WriteString(strings.TrimRight("/// "+line, " ") + "\n")
+		}
+		code.WriteString(fmt.Sprintf("struct %s: Codable, Equatable {\n", decl.name))
+		if decl.implicitID {
+			code.WriteString("    var id: String // UUID\n")
+		}
+		if decl.versioned {
+			code.WriteString("    var version: Int // increases with every update\n")
+		}
+		for _, p := range decl.properties {
+			for _, line := range p.doc {
+				code.WriteString(strings.TrimRight("    /// "+line, " ") + "\n")
+			}
+			swiftType := SwiftType(p.t)
+			if p.optional && !strings.HasSuffix(swiftType, "?") {
+				swiftType += "?"
+			}
+			line := fmt.Sprintf("    var %s: %s", escapeName(p.name, swiftKeywords), swiftType)
+			if p.comment != "" {
+				line += " // " + p.comment
+			}
+			code.WriteString(line + "\n")
+		}
+		code.WriteString("}\n")
+	}
+	return codegen.Stamp("//", opts.Header, []byte(code.String())), nil
+}
@@ -0,0 +1,372 @@
+// Package mock serves example responses for the operations described in
+// generated OpenAPI documents, so frontends can be built against the API
+// before a backend exists.
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// listSize is the number of items returned for array responses
+const listSize = 3
+
+// Route describes one mocked operation
+type Route struct {
+	Method string
+	Path   string
+}
+
+// Server answers requests for every operation in a set of OpenAPI documents
+type Server struct {
+	routes []*route
+}
+
+type route struct {
+	method    string
+	path      string
+	segments  []string
+	operation map[string]interface{}
+	doc       map[string]interface{}
+}
+
+// Load reads OpenAPI YAML documents from disk and builds a mock server for them
+func Load(paths ...string) (*Server, error) {
+	var docs [][]byte
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, data)
+	}
+	return New(docs...)
+}
+
+// New builds a mock server from OpenAPI YAML documents
+func New(docs ...[]byte) (*Server, error) {
+	server := &Server{}
+
+	for i, data := range docs {
+		doc, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("spec %d: %w", i, err)
+		}
+
+		paths, _ := doc["paths"].(map[string]interface{})
+		for path, item := range paths {
+			operations, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for method, op := range operations {
+				operation, ok := op.(map[string]interface{})
+				if !ok || method == "parameters" {
+					continue
+				}
+				server.routes = append(server.routes, &route{
+					method:    strings.ToUpper(method),
+					path:      path,
+					segments:  splitPath(path),
+					operation: operation,
+					doc:       doc,
+				})
+			}
+		}
+	}
+
+	// Literal segments win over templated ones, e.g. /users/me before /users/{id}
+	sort.SliceStable(server.routes, func(i, j int) bool {
+		return templateCount(server.routes[i].segments) < templateCount(server.routes[j].segments)
+	})
+
+	return server, nil
+}
+
+// Parse decodes an OpenAPI YAML document into maps that can be walked and
+// encoded as JSON
+func Parse(data []byte) (map[string]interface{}, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse spec: %w", err)
+	}
+	doc, ok := normalize(raw).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("spec is not a YAML mapping")
+	}
+	return doc, nil
+}
+
+// Example builds example data for a schema of doc, resolving $refs against it
+func Example(doc, schema map[string]interface{}) interface{} {
+	return exampleValue(doc, schema, 0, 0)
+}
+
+// Routes lists the mocked operations sorted by path and method
+func (s *Server) Routes() []Route {
+	routes := make([]Route, 0, len(s.routes))
+	for _, r := range s.routes {
+		routes = append(routes, Route{Method: r.method, Path: r.path})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// ServeHTTP answers with the example response of the matching operation
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+
+	pathMatched := false
+	for _, rt := range s.routes {
+		params, ok := matchPath(rt.segments, segments)
+		if !ok {
+			continue
+		}
+		pathMatched = true
+		if rt.method != r.Method {
+			continue
+		}
+		rt.serve(w, r, params)
+		return
+	}
+
+	if pathMatched {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (rt *route) serve(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	status, response := successResponse(rt.operation)
+
+	schema := responseSchema(response)
+	if schema == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	body := exampleValue(rt.doc, schema, 0, 0)
+
+	// Echo submitted fields back so created and updated records look real
+	if object, ok := body.(map[string]interface{}); ok && (r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch) {
+		var submitted map[string]interface{}
+		if data, err := io.ReadAll(r.Body); err == nil && json.Unmarshal(data, &submitted) == nil {
+			for key, value := range submitted {
+				object[key] = value
+			}
+		}
+	}
+	if object, ok := body.(map[string]interface{}); ok {
+		if id, ok := params["id"]; ok {
+			if _, hasID := object["id"]; hasID {
+				object["id"] = id
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// successResponse picks the lowest 2xx response declared for an operation
+func successResponse(operation map[string]interface{}) (int, map[string]interface{}) {
+	responses, _ := operation["responses"].(map[string]interface{})
+
+	codes := make([]string, 0, len(responses))
+	for code := range responses {
+		if strings.HasPrefix(code, "2") {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		return http.StatusOK, nil
+	}
+	sort.Strings(codes)
+
+	var status int
+	fmt.Sscanf(codes[0], "%d", &status)
+	response, _ := responses[codes[0]].(map[string]interface{})
+	return status, response
+}
+
+func responseSchema(response map[string]interface{}) map[string]interface{} {
+	content, _ := response["content"].(map[string]interface{})
+	media, _ := content["application/json"].(map[string]interface{})
+	schema, _ := media["schema"].(map[string]interface{})
+	return schema
+}
+
+// exampleValue builds example data for a schema. index varies generated
+// identifiers between list items; depth guards against recursive $refs.
+func exampleValue(doc, schema map[string]interface{}, index, depth int) interface{} {
+	if depth > 8 {
+		return nil
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved := resolveRef(doc, ref)
+		if resolved == nil {
+			return nil
+		}
+		return exampleValue(doc, resolved, index, depth+1)
+	}
+
+	// A record extending another combines both schemas' properties
+	if parts, ok := schema["allOf"].([]interface{}); ok {
+		object := make(map[string]interface{})
+		for _, part := range parts {
+			partSchema, _ := part.(map[string]interface{})
+			if fields, ok := exampleValue(doc, partSchema, index, depth+1).(map[string]interface{}); ok {
+				for name, value := range fields {
+					object[name] = value
+				}
+			}
+		}
+		return object
+	}
+
+	kind, _ := schema["type"].(string)
+	format, _ := schema["format"].(string)
+
+	switch kind {
+	case "object":
+		object := make(map[string]interface{})
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, prop := range properties {
+			if propSchema, ok := prop.(map[string]interface{}); ok {
+				object[name] = exampleValue(doc, propSchema, index, depth+1)
+			}
+		}
+		// A map gets one example entry
+		if values, ok := schema["additionalProperties"].(map[string]interface{}); ok && len(properties) == 0 {
+			key := "key"
+			if _, ok := schema["x-cloudpact-key"]; ok {
+				key = "1"
+			}
+			object[key] = exampleValue(doc, values, index, depth+1)
+		}
+		return object
+	case "array":
+		items, _ := schema["items"].(map[string]interface{})
+		list := make([]interface{}, 0, listSize)
+		for i := 0; i < listSize; i++ {
+			list = append(list, exampleValue(doc, items, i, depth+1))
+		}
+		return list
+	}
+
+	// Identifiers and emails must differ between list items
+	switch format {
+	case "uuid":
+		return fmt.Sprintf("123e4567-e89b-42d3-a456-%012d", index+1)
+	case "email":
+		return fmt.Sprintf("user%d@example.com", index+1)
+	}
+
+	if example, ok := schema["example"]; ok {
+		return example
+	}
+
+	switch kind {
+	case "integer":
+		return index + 1
+	case "number":
+		return float64(index) + 0.5
+	case "boolean":
+		return true
+	default:
+		return "string"
+	}
+}
+
+// resolveRef looks up a local reference such as #/components/schemas/User
+func resolveRef(doc map[string]interface{}, ref string) map[string]interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var current interface{} = doc
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		node, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = node[part]
+	}
+	resolved, _ := current.(map[string]interface{})
+	return resolved
+}
+
+func splitPath(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return nil
+	}
+	return strings.Split(trimmed, "/")
+}
+
+func isTemplate(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+func templateCount(segments []string) int {
+	count := 0
+	for _, segment := range segments {
+		if isTemplate(segment) {
+			count++
+		}
+	}
+	return count
+}
+
+// matchPath matches request segments against a templated path like /users/{id}
+func matchPath(pattern, segments []string) (map[string]string, bool) {
+	if len(pattern) != len(segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, segment := range pattern {
+		if isTemplate(segment) {
+			params[strings.Trim(segment, "{}")] = segments[i]
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// normalize converts yaml.v2 maps into map[string]interface{} so documents can
+// be walked and encoded as JSON; numeric keys like 200 become strings
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[fmt.Sprint(k)] = normalize(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = normalize(item)
+		}
+		return out
+	default:
+		return val
+	}
+}
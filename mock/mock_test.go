@@ -0,0 +1,74 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/spec/openapi"
+)
+
+func TestServeCRUDExamples(t *testing.T) {
+	file, err := grammar.ParseString(`model User {
+    email: email
+    balance: usd_currency
+}`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	spec, err := openapi.Generate(file)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	server, err := New([]byte(spec))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("GET", "/users", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var users []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &users); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	if len(users) != listSize || users[0]["email"] == users[1]["email"] || users[0]["id"] == users[1]["id"] {
+		t.Fatalf("expected distinct example users: %v", users)
+	}
+	if users[0]["balance"] != 99.99 {
+		t.Fatalf("expected currency example, got %v", users[0]["balance"])
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("GET", "/users/abc", nil))
+	var user map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil {
+		t.Fatalf("decode user: %v", err)
+	}
+	if user["id"] != "abc" {
+		t.Fatalf("expected path id to be echoed, got %v", user["id"])
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("POST", "/users", strings.NewReader(`{"email":"new@example.com"}`)))
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), "new@example.com") {
+		t.Fatalf("unexpected create response %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("DELETE", "/users/abc", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("GET", "/orders", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+}
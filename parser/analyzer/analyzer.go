@@ -0,0 +1,1363 @@
+// Package analyzer performs semantic checks on parsed CloudPact files.
+// It resolves the types of expressions against the records and function
+// signatures declared in a file and annotates the AST with what it finds,
+// so code generators can emit type-correct output.
+package analyzer
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// checker holds the declarations visible while checking a file
+type checker struct {
+	records   map[string]map[string]*grammar.Type
+	functions map[string]*grammar.Function
+	item      *queryItem        // innermost query being checked, if any
+	function  *grammar.Function // function being checked, whose returns apply
+	testing   bool              // checking a test block, where expect is allowed
+}
+
+// queryItem describes the element bound inside a query's where/select clauses
+type queryItem struct {
+	record string // name of the element record
+	fields map[string]*grammar.Type
+	known  bool // element record is declared in this file
+	outer  *queryItem
+}
+
+// scope maps variable names to their declared or inferred types
+type scope map[string]*grammar.Type
+
+// Check validates file and annotates its expressions with resolved types.
+// It returns the first semantic error found, as a *grammar.Diagnostic.
+func Check(file *grammar.File) error {
+	return CheckWith(file, nil)
+}
+
+// CheckWith is Check for a file of a project whose custom types, declared
+// in any of its files, are shared. Types the file declares itself are known
+// even when shared lacks them.
+func CheckWith(file *grammar.File, shared Types) error {
+	types, err := DeclaredTypes(file)
+	if err != nil {
+		return err
+	}
+	for name, t := range shared {
+		types[name] = t
+	}
+	types.resolveFile(file)
+
+	c := &checker{
+		records:   make(map[string]map[string]*grammar.Type),
+		functions: make(map[string]*grammar.Function),
+	}
+
+	if err := linkRecords(file.Records); err != nil {
+		return err
+	}
+	for _, record := range file.Records {
+		fields := make(map[string]*grammar.Type)
+		if record.Base != nil {
+			for _, field := range record.Base.AllFields() {
+				fields[field.Name] = field.Type
+			}
+		}
+		for _, field := range record.Fields {
+			if _, ok := fields[field.Name]; ok {
+				return grammar.NewDiagnostic(grammar.CodeDuplicate, field.Position, "record %s already has field %s from %s", record.Name, field.Name, record.Extends).
+					Until(field.End).
+					Suggest("remove %s or rename it", field.Name)
+			}
+			if err := checkRounding(field.Name, field.Type); err != nil {
+				return err
+			}
+			if err := checkConstraints(field.Name, field.Type); err != nil {
+				return err
+			}
+			if err := checkMapKeys(field.Name, field.Type); err != nil {
+				return err
+			}
+			if err := checkDefault(field); err != nil {
+				return err
+			}
+			if record.IsVersioned() && strings.EqualFold(field.Name, "version") {
+				return grammar.NewDiagnostic(grammar.CodeDuplicate, field.Position, "versioned record %s gets its version field automatically; remove %s", record.Name, field.Name).
+					Until(field.End)
+			}
+			fields[field.Name] = field.Type
+		}
+		if err := checkIdentity(record); err != nil {
+			return err
+		}
+		if record.IsVersioned() {
+			fields["version"] = &grammar.Type{Name: "int"}
+		}
+		if err := checkExample(record, fields); err != nil {
+			return err
+		}
+		c.records[record.Name] = fields
+	}
+	for _, model := range file.Models {
+		fields := make(map[string]*grammar.Type)
+		for _, field := range model.Fields {
+			if err := checkRounding(field.Name, field.Type); err != nil {
+				return err
+			}
+			if err := checkConstraints(field.Name, field.Type); err != nil {
+				return err
+			}
+			if err := checkMapKeys(field.Name, field.Type); err != nil {
+				return err
+			}
+			fields[field.Name] = field.Type
+		}
+		c.records[model.Name] = fields
+	}
+	if err := checkEvents(file); err != nil {
+		return err
+	}
+	if err := checkConfigs(file); err != nil {
+		return err
+	}
+	if err := checkPermissions(file); err != nil {
+		return err
+	}
+	if err := checkSchedules(file); err != nil {
+		return err
+	}
+	if err := checkWorkflows(file); err != nil {
+		return err
+	}
+	if err := checkQueues(file); err != nil {
+		return err
+	}
+	for _, function := range file.Functions {
+		c.functions[function.Name] = function
+	}
+
+	for _, function := range file.Functions {
+		if err := c.checkFunction(function); err != nil {
+			return err
+		}
+	}
+
+	for _, test := range file.Tests {
+		if err := c.checkTest(test); err != nil {
+			return err
+		}
+	}
+
+	if err := c.checkSeeds(file); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// linkRecords sets the Base of each record that extends another. The base
+// must be declared in the same file, and no record may extend itself.
+func linkRecords(records []*grammar.Record) error {
+	byName := make(map[string]*grammar.Record)
+	names := make(map[string]*grammar.Type)
+	for _, record := range records {
+		byName[record.Name] = record
+		names[record.Name] = &grammar.Type{Name: record.Name}
+	}
+	for _, record := range records {
+		if record.Extends == "" {
+			continue
+		}
+		base, ok := byName[record.Extends]
+		if !ok {
+			d := grammar.NewDiagnostic(grammar.CodeType, record.Position, "record %s extends %s, which is not a record in this file", record.Name, record.Extends)
+			if suggestion := closest(record.Extends, names); suggestion != "" {
+				d.Suggest("did you mean %s?", suggestion)
+			}
+			return d
+		}
+		record.Base = base
+	}
+	for _, record := range records {
+		chain := []string{record.Name}
+		for base := record.Base; base != nil && len(chain) <= len(records); base = base.Base {
+			chain = append(chain, base.Name)
+			if base == record {
+				record.Base = nil // so AllFields ends
+				return grammar.NewDiagnostic(grammar.CodeType, record.Position, "record %s extends itself (%s)", record.Name, strings.Join(chain, " -> "))
+			}
+		}
+	}
+	return nil
+}
+
+// checkTest checks a test block. Records are bound to sample values named
+// after them, as the test runner does.
+func (c *checker) checkTest(test *grammar.Test) error {
+	vars := make(scope)
+	for name := range c.records {
+		vars[strings.ToLower(name[:1])+name[1:]] = &grammar.Type{Name: name}
+	}
+	c.testing = true
+	defer func() { c.testing = false }()
+	for _, stmt := range test.Statements {
+		if err := c.checkStatement(stmt, vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkFunction(function *grammar.Function) error {
+	vars := make(scope)
+	for _, param := range function.Parameters {
+		vars[param.Name] = param.Type
+	}
+
+	// Request headers are bound as text variables; emitted ones are set by
+	// the handler, not the body
+	declared := make(map[string]bool)
+	for _, header := range function.Headers {
+		if declared[strings.ToLower(header.Name)] {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, header.Position, "header %s is declared twice in %s", header.Name, function.Name).
+				Until(header.End)
+		}
+		declared[strings.ToLower(header.Name)] = true
+		if header.Direction == grammar.HeaderEmitted {
+			continue
+		}
+		if _, ok := vars[header.Variable()]; ok {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, header.Position, "header %s binds %s, which %s already declares", header.Name, header.Variable(), function.Name).
+				Until(header.End)
+		}
+		vars[header.Variable()] = &grammar.Type{Name: "text", Optional: header.Direction == grammar.HeaderOptional, Position: header.Position}
+	}
+
+	if function.Body == nil {
+		return nil
+	}
+
+	c.function = function
+	defer func() { c.function = nil }()
+	for _, stmt := range function.Body.Statements {
+		if err := c.checkStatement(stmt, vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkStatement(stmt grammar.Statement, vars scope) error {
+	switch s := stmt.(type) {
+	case *grammar.IfStatement:
+		if _, err := c.checkExpression(s.Condition, vars); err != nil {
+			return err
+		}
+		if s.ThenStmt != nil {
+			if err := c.checkStatement(s.ThenStmt, vars); err != nil {
+				return err
+			}
+		}
+		if s.ElseStmt != nil {
+			if err := c.checkStatement(s.ElseStmt, vars); err != nil {
+				return err
+			}
+		}
+	case *grammar.ReturnStatement:
+		if s.Value != nil {
+			valueType, err := c.checkExpression(s.Value, vars)
+			if err != nil {
+				return err
+			}
+			if f := c.function; f != nil && f.ReturnType != nil && valueType != nil && !compatible(valueType, f.ReturnType) {
+				return grammar.NewDiagnostic(grammar.CodeType, s.Position, "%s is declared to return %s, but this returns %s", f.Name, typeName(f.ReturnType), typeName(valueType)).
+					Until(s.End)
+			}
+		}
+	case *grammar.AssignStatement:
+		if s.Variable == "__use__" {
+			return nil
+		}
+		t, err := c.checkExpression(s.Value, vars)
+		if err != nil {
+			return err
+		}
+		vars[s.Variable] = t
+	case *grammar.CreateStatement:
+		for _, assignment := range s.Assignments {
+			if _, err := c.checkExpression(assignment.Value, vars); err != nil {
+				return err
+			}
+		}
+		// Generated code binds the created record to its lowercased type name
+		vars[strings.ToLower(s.TypeName)] = &grammar.Type{Name: s.TypeName, Position: s.Position}
+	case *grammar.ExpectStatement:
+		if !c.testing {
+			return grammar.NewDiagnostic(grammar.CodeSyntax, s.Position, "expect is only allowed in test blocks").
+				Until(s.End)
+		}
+		if _, err := c.checkExpression(s.Value, vars); err != nil {
+			return err
+		}
+		if s.Expected != nil {
+			if _, err := c.checkExpression(s.Expected, vars); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkExpression returns the type of expr, or nil when it cannot be inferred
+func (c *checker) checkExpression(expr grammar.Expression, vars scope) (*grammar.Type, error) {
+	switch e := expr.(type) {
+	case *grammar.IdentifierExpression:
+		t, err := c.checkIdentifier(e, vars)
+		e.Type = t
+		return t, err
+
+	case *grammar.LiteralExpression:
+		return literalType(e), nil
+
+	case *grammar.BinaryExpression:
+		leftType, err := c.checkExpression(e.Left, vars)
+		if err != nil {
+			return nil, err
+		}
+		rightType, err := c.checkExpression(e.Right, vars)
+		if err != nil {
+			return nil, err
+		}
+		if (e.Operator == "is" || e.Operator == "is not") && leftType != nil && rightType != nil && rightType.Name == "none" && !leftType.Optional {
+			return nil, grammar.NewDiagnostic(grammar.CodeOptional, e.Position, "%s can never be none: it is not optional", describe(e.Left)).
+				Until(e.End)
+		}
+		if (e.Operator == "in" || e.Operator == "not in") && rightType != nil && rightType.Element == nil {
+			return nil, grammar.NewDiagnostic(grammar.CodeType, e.Position, "%s is not a list; '%s' needs a list", describe(e.Right), e.Operator).
+				Until(e.End).
+				Suggest("%s contains %s", describe(e.Right), describe(e.Left))
+		}
+		return &grammar.Type{Name: "boolean", Position: e.Position}, nil
+
+	case *grammar.EmptyExpression:
+		valueType, err := c.checkExpression(e.Value, vars)
+		if err != nil {
+			return nil, err
+		}
+		if valueType != nil {
+			switch KindOf(valueType) {
+			case KindText, KindList, KindMap:
+			default:
+				d := grammar.NewDiagnostic(grammar.CodeType, e.Position, "'is empty' applies to text, lists and maps, but %s is %s", describe(e.Value), valueType.Name).
+					Until(e.End)
+				if valueType.Optional {
+					d.Suggest("%s is none", describe(e.Value))
+				}
+				return nil, d
+			}
+		}
+		e.Type = valueType
+		return &grammar.Type{Name: "boolean", Position: e.Position}, nil
+
+	case *grammar.CallExpression:
+		var receiverType *grammar.Type
+		for i, arg := range e.Arguments {
+			argType, err := c.checkExpression(arg, vars)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				receiverType = argType
+			}
+		}
+		if e.Method {
+			if err := c.checkMethodCall(e, receiverType); err != nil {
+				return nil, err
+			}
+		}
+		if function, ok := c.functions[e.Function]; ok {
+			return function.ReturnType, nil
+		}
+		return nil, nil
+
+	case *grammar.MemberExpression:
+		return c.checkMember(e, vars)
+
+	case *grammar.DefaultExpression:
+		valueType, err := c.checkExpression(e.Value, vars)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.checkExpression(e.Fallback, vars); err != nil {
+			return nil, err
+		}
+		if valueType == nil {
+			return nil, nil
+		}
+		if !MayBeAbsent(e.Value) {
+			return nil, grammar.NewDiagnostic(grammar.CodeOptional, e.Position, "'or' fallback is never used: %s is not optional", describe(e.Value)).
+				Until(e.End).
+				Suggest("remove the 'or' fallback")
+		}
+		e.Type = &grammar.Type{
+			Name:        valueType.Name,
+			Constraints: valueType.Constraints,
+			Position:    valueType.Position,
+		}
+		return e.Type, nil
+
+	case *grammar.ConditionalExpression:
+		if _, err := c.checkExpression(e.Condition, vars); err != nil {
+			return nil, err
+		}
+		thenType, err := c.checkExpression(e.Then, vars)
+		if err != nil {
+			return nil, err
+		}
+		elseType, err := c.checkExpression(e.Else, vars)
+		if err != nil {
+			return nil, err
+		}
+		if thenType != nil && elseType != nil && !compatible(thenType, elseType) {
+			return nil, grammar.NewDiagnostic(grammar.CodeType, e.Position, "conditional branches have different types: %s and %s", thenType.Name, elseType.Name).
+				Until(e.End)
+		}
+		// A whole number in one branch widens to the other's number
+		e.Type = thenType
+		if e.Type == nil || elseType != nil && isWholeNumber(thenType) && IsNumeric(elseType) {
+			e.Type = elseType
+		}
+		return e.Type, nil
+
+	case *grammar.QueryExpression:
+		return c.checkQuery(e, vars)
+
+	case *grammar.AggregateExpression:
+		return c.checkAggregate(e, vars)
+	}
+
+	return nil, nil
+}
+
+// checkIdentifier resolves a name. Inside a query, fields of the current item
+// shadow variables; when the item's record is declared elsewhere, any name that
+// is not a variable is assumed to be one of its fields. A name that is neither
+// a variable nor a field of a record declared here is an error, as in
+// "item.name".
+func (c *checker) checkIdentifier(e *grammar.IdentifierExpression, vars scope) (*grammar.Type, error) {
+	if item := c.item; item != nil {
+		if e.Element {
+			return item.fields[e.Name], nil
+		}
+		if t, ok := item.fields[e.Name]; ok {
+			e.Element = true
+			return t, nil
+		}
+		if _, ok := vars[e.Name]; !ok {
+			if !item.known {
+				e.Element = true
+				return nil, nil
+			}
+			d := grammar.NewDiagnostic(grammar.CodeUnknownField, e.Position, "record %s has no field %s", item.record, e.Name).
+				Until(e.End)
+			if name := closest(e.Name, item.fields); name != "" {
+				d.Suggest("did you mean %s?", name)
+			}
+			return nil, d
+		}
+	}
+	return vars[e.Name], nil
+}
+
+func (c *checker) checkQuery(e *grammar.QueryExpression, vars scope) (*grammar.Type, error) {
+	sourceType, err := c.checkExpression(e.Source, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var elementType *grammar.Type
+	if sourceType != nil {
+		if sourceType.Element == nil {
+			return nil, grammar.NewDiagnostic(grammar.CodeType, e.Position, "%s is not a list; 'where' and 'select' need a list", describe(e.Source)).
+				Until(e.End)
+		}
+		elementType = sourceType.Element
+	}
+
+	item := &queryItem{outer: c.item}
+	if elementType != nil {
+		item.record = elementType.Name
+		item.fields, item.known = c.records[elementType.Name]
+	}
+	c.item = item
+	defer func() { c.item = item.outer }()
+
+	if e.Filter != nil {
+		filterType, err := c.checkExpression(e.Filter, vars)
+		if err != nil {
+			return nil, err
+		}
+		if filterType != nil && KindOf(filterType) != KindBoolean {
+			return nil, grammar.NewDiagnostic(grammar.CodeType, e.Filter.GetPosition(), "'where' condition must be boolean, got %s", filterType.Name).
+				Until(e.Filter.GetEnd())
+		}
+	}
+
+	if e.Select != nil {
+		elementType, err = c.checkExpression(e.Select, vars)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if elementType == nil {
+		return nil, nil
+	}
+	e.Type = &grammar.Type{Name: "list", Element: elementType, Position: e.Position}
+	return e.Type, nil
+}
+
+// checkMethodCall checks "order.discount()", a call of discount with order
+// as its first argument
+func (c *checker) checkMethodCall(e *grammar.CallExpression, receiverType *grammar.Type) error {
+	receiver := e.Arguments[0]
+	function, ok := c.functions[e.Function]
+	if !ok {
+		if receiverType != nil {
+			if _, isField := c.records[receiverType.Name][e.Function]; isField {
+				return grammar.NewDiagnostic(grammar.CodeType, e.Position, "%s is a field of %s, not a function", e.Function, receiverType.Name).
+					Until(e.End).
+					Suggest("%s.%s", describe(receiver), e.Function)
+			}
+		}
+		return nil // declared in another file
+	}
+	if len(function.Parameters) == 0 {
+		return grammar.NewDiagnostic(grammar.CodeType, e.Position, "%s takes no parameters, so it cannot be called on %s", e.Function, describe(receiver)).
+			Until(e.End).
+			Suggest("%s()", e.Function)
+	}
+	if first := function.Parameters[0].Type; receiverType != nil && !compatible(receiverType, first) {
+		return grammar.NewDiagnostic(grammar.CodeType, e.Position, "%s takes %s as its first parameter, but %s is %s", e.Function, first.Name, describe(receiver), receiverType.Name).
+			Until(e.End)
+	}
+	return nil
+}
+
+func (c *checker) checkMember(e *grammar.MemberExpression, vars scope) (*grammar.Type, error) {
+	objectType, err := c.checkExpression(e.Object, vars)
+	if err != nil {
+		return nil, err
+	}
+	if objectType == nil {
+		return nil, nil
+	}
+
+	if objectType.Element != nil {
+		return nil, grammar.NewDiagnostic(grammar.CodeType, e.Position, "%s is a list; use 'select %s' to read %s from each item", describe(e.Object), e.Property, e.Property).
+			Until(e.End)
+	}
+
+	if objectType.Key != nil {
+		return nil, grammar.NewDiagnostic(grammar.CodeType, e.Position, "%s is a map; its entries are not fields", describe(e.Object)).
+			Until(e.End)
+	}
+
+	if objectType.Optional && !e.Safe {
+		return nil, grammar.NewDiagnostic(grammar.CodeOptional, e.Position, "%s is optional; use '?.' to access %s", describe(e.Object), e.Property).
+			Until(e.End).
+			Suggest("%s?.%s", describe(e.Object), e.Property)
+	}
+
+	fields, ok := c.records[objectType.Name]
+	if !ok {
+		// Record declared in another file; nothing more to check
+		return nil, nil
+	}
+
+	fieldType, ok := fields[e.Property]
+	if !ok {
+		d := grammar.NewDiagnostic(grammar.CodeUnknownField, e.Position, "record %s has no field %s", objectType.Name, e.Property).
+			Until(e.End)
+		if name := closest(e.Property, fields); name != "" {
+			d.Suggest("did you mean %s?", name)
+		}
+		return nil, d
+	}
+
+	// The field keeps its declared type; read through ?. it may be none
+	e.Type = fieldType
+	if objectType.Optional && !fieldType.Optional {
+		valueType := *fieldType
+		valueType.Optional = true
+		return &valueType, nil
+	}
+	return fieldType, nil
+}
+
+func (c *checker) checkAggregate(e *grammar.AggregateExpression, vars scope) (*grammar.Type, error) {
+	// "sum of items.price" reads price from every item: check it as "items select price"
+	if member, ok := e.Source.(*grammar.MemberExpression); ok {
+		objectType, err := c.checkExpression(member.Object, vars)
+		if err != nil {
+			return nil, err
+		}
+		if objectType != nil && objectType.Element != nil {
+			e.Source = &grammar.QueryExpression{
+				Source:   member.Object,
+				Select:   &grammar.IdentifierExpression{Name: member.Property, Element: true, Position: member.Position, End: member.End},
+				Position: member.Position,
+				End:      member.End,
+			}
+		}
+	}
+
+	sourceType, err := c.checkExpression(e.Source, vars)
+	if err != nil {
+		return nil, err
+	}
+	if e.Function == "length" && sourceType != nil && KindOf(sourceType) == KindText {
+		// "length of name" counts characters
+		if e.Rounding != "" {
+			return nil, grammar.NewDiagnostic(grammar.CodeRounding, e.Position, "length is a whole number; 'round' does not apply").
+				Until(e.End)
+		}
+		e.Type = &grammar.Type{Name: "int", Position: e.Position}
+		return e.Type, nil
+	}
+	if sourceType != nil && sourceType.Element == nil {
+		return nil, grammar.NewDiagnostic(grammar.CodeType, e.Position, "%s of %s: %s is not a list", e.Function, describe(e.Source), describe(e.Source)).
+			Until(e.End)
+	}
+
+	if e.Function == "length" {
+		// The length of a list is its count, leaving "length" to text for the generators
+		e.Function = "count"
+	}
+	if e.Function == "count" {
+		if e.Rounding != "" {
+			return nil, grammar.NewDiagnostic(grammar.CodeRounding, e.Position, "%s is a whole number; 'round' does not apply", e.Function).
+				Until(e.End)
+		}
+		e.Type = &grammar.Type{Name: "int", Position: e.Position}
+		return e.Type, nil
+	}
+
+	if sourceType == nil {
+		return nil, nil
+	}
+	element := sourceType.Element
+
+	// "first of orders" is none when there are no orders
+	if e.Function == "first" || e.Function == "last" {
+		if e.Rounding != "" {
+			return nil, grammar.NewDiagnostic(grammar.CodeRounding, e.Position, "%s picks an item; 'round' does not apply", e.Function).
+				Until(e.End)
+		}
+		picked := *element
+		picked.Optional, picked.Nullable, picked.Position = true, true, e.Position
+		e.Type = &picked
+		return e.Type, nil
+	}
+	if !IsNumeric(element) {
+		return nil, grammar.NewDiagnostic(grammar.CodeType, e.Position, "%s needs numeric values, got %s", e.Function, element.Name).
+			Until(e.End)
+	}
+
+	// Money is rounded as declared on the aggregate, or else on the field
+	if mode, ok := element.Constraints["round"].(string); ok && e.Rounding == "" {
+		e.Rounding = mode
+	}
+	if e.Rounding != "" && !IsCurrency(element) {
+		return nil, grammar.NewDiagnostic(grammar.CodeRounding, e.Position, "'round' only applies to currency values, got %s", element.Name).
+			Until(e.End)
+	}
+
+	if e.Function == "average" {
+		e.Type = &grammar.Type{Name: "number", Position: e.Position}
+	} else {
+		e.Type = &grammar.Type{Name: element.Name, Constraints: element.Constraints, Position: e.Position}
+	}
+	return e.Type, nil
+}
+
+// checkRounding rejects "round:" on fields that do not hold money
+func checkRounding(name string, t *grammar.Type) error {
+	if _, ok := t.Constraints["round"]; ok && !IsCurrency(t) {
+		return grammar.NewDiagnostic(grammar.CodeRounding, t.Position, "'round' only applies to currency fields, but %s is %s", name, t.Name).
+			Until(t.End)
+	}
+	return nil
+}
+
+// checkConstraints checks that min and max constrain numbers, and
+// minlength, maxlength and domain text, and that each lower bound is below its upper
+func checkConstraints(name string, t *grammar.Type) error {
+	kind := KindOf(t)
+	for constraint := range t.Constraints {
+		switch constraint {
+		case grammar.ConstraintMin, grammar.ConstraintMax:
+			if kind != KindNumber {
+				d := grammar.NewDiagnostic(grammar.CodeConstraint, t.Position, "'%s' only applies to number fields, but %s is %s", constraint, name, t.Name).Until(t.End)
+				if kind == KindText {
+					d.Suggest("use %slength to limit the length of text", constraint)
+				}
+				return d
+			}
+		case grammar.ConstraintMinLength, grammar.ConstraintMaxLength, grammar.ConstraintDomain:
+			if kind != KindText {
+				return grammar.NewDiagnostic(grammar.CodeConstraint, t.Position, "'%s' only applies to text fields, but %s is %s", constraint, name, t.Name).
+					Until(t.End)
+			}
+		}
+	}
+	for _, bounds := range [][2]string{{grammar.ConstraintMin, grammar.ConstraintMax}, {grammar.ConstraintMinLength, grammar.ConstraintMaxLength}} {
+		low, hasLow := constraintValue(t, bounds[0])
+		high, hasHigh := constraintValue(t, bounds[1])
+		if hasLow && hasHigh && low > high {
+			return grammar.NewDiagnostic(grammar.CodeConstraint, t.Position, "%s of %s is greater than its %s", bounds[0], name, bounds[1]).
+				Until(t.End)
+		}
+	}
+	return nil
+}
+
+// checkMapKeys rejects maps keyed by anything but text or whole numbers,
+// the keys JSON objects can hold
+func checkMapKeys(name string, t *grammar.Type) error {
+	if t == nil {
+		return nil
+	}
+	if t.Key != nil {
+		kind := KindOf(t.Key)
+		if kind != KindText && !(kind == KindNumber && isWholeNumber(t.Key)) {
+			return grammar.NewDiagnostic(grammar.CodeType, t.Key.Position, "keys of map %s must be text or whole numbers, got %s", name, t.Key.Name).
+				Until(t.Key.End).
+				Suggest("map of text to %s", t.Value.Name)
+		}
+		if err := checkMapKeys(name, t.Key); err != nil {
+			return err
+		}
+	}
+	if err := checkMapKeys(name, t.Value); err != nil {
+		return err
+	}
+	return checkMapKeys(name, t.Element)
+}
+
+// constraintValue returns a numeric constraint of t, if it has one
+func constraintValue(t *grammar.Type, name string) (float64, bool) {
+	switch v := t.Constraints[name].(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// checkDefault checks that a field's default is a value of its type. Only
+// date and time fields take "now".
+func checkDefault(field *grammar.FieldDef) error {
+	if field.Default == nil {
+		return nil
+	}
+	start, end := field.Default.GetPosition(), field.Default.GetEnd()
+	if field.Type.Optional {
+		return grammar.NewDiagnostic(grammar.CodeOptional, start, "optional field %s cannot have a default", field.Name).
+			Until(end).
+			Suggest("remove 'optional'; a field with a default is never absent")
+	}
+	if grammar.IsDefaultNow(field.Default) {
+		switch strings.ToLower(field.Type.Name) {
+		case "date", "datetime", "timestamp":
+			return nil
+		}
+		return grammar.NewDiagnostic(grammar.CodeType, start, "'default now' only applies to date, datetime and timestamp fields, but %s is %s", field.Name, field.Type.Name).
+			Until(end)
+	}
+	return checkFieldValue("default for "+field.Name, field.Type, field.Default.(*grammar.LiteralExpression))
+}
+
+// checkFieldValue checks that literal is a value of a field of type t that
+// meets the field's constraints. what names the value in messages, such as
+// "default for total".
+func checkFieldValue(what string, t *grammar.Type, literal *grammar.LiteralExpression) error {
+	start, end := literal.Position, literal.End
+	valueType := literalType(literal)
+	kind := KindOf(t)
+	if kind == KindRecord || kind == KindList || kind == KindMap || !compatible(valueType, t) ||
+		(literal.Kind == grammar.LiteralFloat && strings.Contains(strings.ToLower(t.Name), "int")) {
+		return grammar.NewDiagnostic(grammar.CodeType, start, "%s must be %s, got %s", what, t.Name, valueType.Name).
+			Until(end)
+	}
+
+	// The value must itself satisfy the field's constraints
+	measure := 0.0
+	switch v := literal.Value.(type) {
+	case int64:
+		measure = float64(v)
+	case float64:
+		measure = v
+	case string:
+		measure = float64(utf8.RuneCountInString(v))
+	default:
+		return nil
+	}
+	lower, upper := grammar.ConstraintMin, grammar.ConstraintMax
+	if kind == KindText {
+		lower, upper = grammar.ConstraintMinLength, grammar.ConstraintMaxLength
+	}
+	if low, ok := constraintValue(t, lower); ok && measure < low {
+		return grammar.NewDiagnostic(grammar.CodeConstraint, start, "%s is below its %s", what, lower).Until(end)
+	}
+	if high, ok := constraintValue(t, upper); ok && measure > high {
+		return grammar.NewDiagnostic(grammar.CodeConstraint, start, "%s is above its %s", what, upper).Until(end)
+	}
+	return nil
+}
+
+// checkExample checks that a record's example gives values of the types of
+// fields the record has. Temporal fields take text: a date as 2024-01-02,
+// a datetime or timestamp in RFC 3339 and a duration such as 90m. The
+// implicit id takes a UUID.
+func checkExample(record *grammar.Record, fields map[string]*grammar.Type) error {
+	if record.Example == nil {
+		return nil
+	}
+	return checkValues(record, record.Example, "example", fields)
+}
+
+// checkValues checks the values of an example, or of a seed row when kind
+// is "row", as checkExample does
+func checkValues(record *grammar.Record, example *grammar.Example, kind string, fields map[string]*grammar.Type) error {
+	for _, value := range example.Values {
+		what := kind + " value of " + value.Field
+		t, ok := fields[value.Field]
+		if !ok && value.Field == "id" && record.HasImplicitID() {
+			t = &grammar.Type{Name: "uuid"}
+		} else if !ok {
+			d := grammar.NewDiagnostic(grammar.CodeUnknownField, value.Position, "record %s has no field %s", record.Name, value.Field).
+				Until(value.End)
+			if suggestion := closest(value.Field, fields); suggestion != "" {
+				d.Suggest("did you mean %s?", suggestion)
+			}
+			return d
+		}
+		if KindOf(t) != KindTemporal || value.Value.Kind != grammar.LiteralString {
+			if err := checkFieldValue(what, t, value.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		text := value.Value.Value.(string)
+		if strings.EqualFold(t.Name, "duration") {
+			if _, err := time.ParseDuration(text); err != nil {
+				return grammar.NewDiagnostic(grammar.CodeInvalidLiteral, value.Value.Position, "%s must be a duration such as 90m, got %q", what, text).
+					Until(value.Value.End)
+			}
+		} else if _, err := grammar.ParseTime(text); err != nil {
+			return grammar.NewDiagnostic(grammar.CodeInvalidLiteral, value.Value.Position, "%s must be a date such as 2024-01-02 or a time such as 2024-01-02T15:04:05Z, got %q", what, text).
+				Until(value.Value.End)
+		}
+	}
+	return nil
+}
+
+// checkSeeds checks that each seed names a record of the file with an
+// identity, seeded once, that its count covers its literal rows and that
+// the rows give values of the record's fields
+func (c *checker) checkSeeds(file *grammar.File) error {
+	byName := make(map[string]*grammar.Record)
+	names := make(map[string]*grammar.Type)
+	for _, record := range file.Records {
+		byName[record.Name] = record
+		names[record.Name] = &grammar.Type{Name: record.Name}
+	}
+	seeded := make(map[string]bool)
+	for _, seed := range file.Seeds {
+		record, ok := byName[seed.Record]
+		if !ok {
+			d := grammar.NewDiagnostic(grammar.CodeType, seed.Position, "seed names %s, which is not a record in this file", seed.Record).
+				Until(seed.End)
+			if suggestion := closest(seed.Record, names); suggestion != "" {
+				d.Suggest("did you mean %s?", suggestion)
+			}
+			return d
+		}
+		if !record.HasImplicitID() && record.KeyField() == nil {
+			return grammar.NewDiagnostic(grammar.CodeIdentity, seed.Position, "record %s has no id, so it has no table to seed", seed.Record).
+				Until(seed.End).
+				Suggest("remove no id from %s, or give it a key field", seed.Record)
+		}
+		if seeded[seed.Record] {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, seed.Position, "%s is seeded twice", seed.Record).
+				Until(seed.End)
+		}
+		seeded[seed.Record] = true
+		if seed.Count < len(seed.Rows) {
+			return grammar.NewDiagnostic(grammar.CodeConstraint, seed.Position, "seed %s asks for fewer records than its rows give", seed.Record).
+				Until(seed.End).
+				Suggest("write seed %s: %d records", seed.Record, len(seed.Rows))
+		}
+		for _, row := range seed.Rows {
+			if err := checkValues(record, row, "row", c.records[seed.Record]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MayBeAbsent reports whether expr can evaluate to "no value": an optional
+// field, or a property reached through safe access on an optional field.
+func MayBeAbsent(expr grammar.Expression) bool {
+	member, ok := expr.(*grammar.MemberExpression)
+	if !ok {
+		return false
+	}
+	if member.Type != nil && member.Type.Optional {
+		return true
+	}
+	return member.Safe && MayBeAbsent(member.Object)
+}
+
+// closest returns the field whose name is nearest to name, if one is within
+// a couple of edits of it
+func closest(name string, fields map[string]*grammar.Type) string {
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", 3
+	for _, field := range names {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(field)); d < bestDistance {
+			best, bestDistance = field, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// describe renders an expression for error messages
+func describe(expr grammar.Expression) string {
+	switch e := expr.(type) {
+	case *grammar.IdentifierExpression:
+		return e.Name
+	case *grammar.MemberExpression:
+		sep := "."
+		if e.Safe {
+			sep = "?."
+		}
+		return describe(e.Object) + sep + e.Property
+	case *grammar.CallExpression:
+		return e.Function + "(...)"
+	case *grammar.QueryExpression:
+		return describe(e.Source)
+	default:
+		return "expression"
+	}
+}
+
+// checkEvents checks that each event carries a record of the file, and that
+// no two events share a name or a channel
+func checkEvents(file *grammar.File) error {
+	records := make(map[string]bool)
+	for _, record := range file.Records {
+		records[record.Name] = true
+	}
+	names := make(map[string]bool)
+	channels := make(map[string]string)
+	for _, event := range file.Events {
+		if names[event.Name] || records[event.Name] {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, event.Position, "%s is declared more than once", event.Name).
+				Until(event.End).
+				Suggest("rename the event, such as %sEvent", event.Name)
+		}
+		names[event.Name] = true
+
+		payload := event.Payload
+		if payload.Nullable || !records[payload.Name] {
+			return grammar.NewDiagnostic(grammar.CodeType, payload.Position, "the payload of event %s must be a record of this file, not %s", event.Name, payload.Name).
+				Until(payload.End).
+				Suggest("declare a record holding what the event carries")
+		}
+
+		channel := event.ChannelName()
+		if other, ok := channels[channel]; ok {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, event.Position, "events %s and %s are both sent on %s", other, event.Name, channel).
+				Until(event.End).
+				Suggest("give one of them another channel:")
+		}
+		channels[channel] = event.Name
+	}
+	return nil
+}
+
+// envPattern is what an environment variable may be called: upper case
+// letters, digits and underscores, not starting with a digit
+var envPattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// checkConfigs checks the settings of file: each is text, a number, a
+// bool or a duration, read from a variable no other setting reads, and its
+// default is one of its values. A duration defaults to text such as "30s".
+func checkConfigs(file *grammar.File) error {
+	names := make(map[string]bool)
+	vars := make(map[string]string)
+	for _, config := range file.Configs {
+		if names[config.Name] {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, config.Position, "setting %s is declared more than once", config.Name).
+				Until(config.End)
+		}
+		names[config.Name] = true
+
+		env := config.EnvName()
+		if !envPattern.MatchString(env) {
+			return grammar.NewDiagnostic(grammar.CodeSyntax, config.Position, "environment variable %s of setting %s must be upper case letters, digits and underscores", env, config.Name).
+				Until(config.End).
+				Suggest("read it from env %s", strings.ToUpper(grammar.JSONName(env, grammar.JSONSnake)))
+		}
+		if other, ok := vars[env]; ok {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, config.Position, "settings %s and %s are both read from %s", other, config.Name, env).
+				Until(config.End)
+		}
+		vars[env] = config.Name
+
+		t := config.Type
+		duration := strings.EqualFold(t.Name, "duration")
+		switch kind := KindOf(t); {
+		case kind == KindRecord || kind == KindList || kind == KindMap:
+			return grammar.NewDiagnostic(grammar.CodeType, t.Position, "setting %s must be a single value, not a %s", config.Name, kind).
+				Until(t.End).
+				Suggest("read it as text and split it yourself, or keep structured settings in a file")
+		case kind == KindTemporal && !duration:
+			return grammar.NewDiagnostic(grammar.CodeType, t.Position, "setting %s must be text, a number, a bool or a duration, not %s", config.Name, t.Name).
+				Until(t.End)
+		}
+		if err := checkConstraints(config.Name, t); err != nil {
+			return err
+		}
+		if config.Default == nil {
+			continue
+		}
+		start, end := config.Default.GetPosition(), config.Default.GetEnd()
+		if t.Optional {
+			return grammar.NewDiagnostic(grammar.CodeOptional, start, "optional setting %s cannot have a default", config.Name).
+				Until(end).
+				Suggest("remove 'optional'; a setting with a default is never absent")
+		}
+		literal, ok := config.Default.(*grammar.LiteralExpression)
+		if !ok {
+			return grammar.NewDiagnostic(grammar.CodeType, start, "the default of setting %s must be a constant, not now", config.Name).
+				Until(end)
+		}
+		if duration && literal.Kind == grammar.LiteralString {
+			if _, err := time.ParseDuration(literal.Value.(string)); err != nil {
+				return grammar.NewDiagnostic(grammar.CodeInvalidLiteral, start, "default for %s must be a duration such as 90m, got %q", config.Name, literal.Value).
+					Until(end)
+			}
+			continue
+		}
+		if err := checkFieldValue("default for "+config.Name, t, literal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPermissions checks the roles and allow rules of file: no role is
+// declared twice and each rule acts on a record of the file, allowing what
+// no earlier rule did. Roles may be declared in another file of the
+// project, so the roles rules name are checked by the project.
+func checkPermissions(file *grammar.File) error {
+	roles := make(map[string]bool)
+	for _, role := range file.Roles {
+		if roles[role.Name] {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, role.Position, "role %s is declared more than once", role.Name).
+				Until(role.End)
+		}
+		roles[role.Name] = true
+	}
+
+	records := make(map[string]*grammar.Type)
+	for _, record := range file.Records {
+		records[record.Name] = nil
+	}
+	allowed := make(map[string]bool) // role, action and record
+	for _, allow := range file.Allows {
+		if _, ok := records[allow.Resource]; !ok {
+			d := grammar.NewDiagnostic(grammar.CodeType, allow.Position, "allow %s names %s, which is not a record in this file", allow.Role, allow.Resource).
+				Until(allow.End)
+			if suggestion := closest(allow.Resource, records); suggestion != "" {
+				d.Suggest("did you mean %s?", suggestion)
+			}
+			return d
+		}
+		for _, action := range allow.Actions {
+			key := allow.Role + " " + action + " " + allow.Resource
+			if allowed[key] {
+				return grammar.NewDiagnostic(grammar.CodeDuplicate, allow.Position, "%s is already allowed to %s %s", allow.Role, action, allow.Resource).
+					Until(allow.End)
+			}
+			allowed[key] = true
+		}
+	}
+	return nil
+}
+
+// checkSchedules checks that each schedule runs a function of the file
+// that takes nothing, since no caller passes it arguments, and that no
+// function is scheduled twice
+func checkSchedules(file *grammar.File) error {
+	functions := make(map[string]*grammar.Function)
+	names := make(map[string]*grammar.Type)
+	for _, function := range file.Functions {
+		functions[function.Name] = function
+		names[function.Name] = nil
+	}
+	scheduled := make(map[string]bool)
+	for _, schedule := range file.Schedules {
+		function, ok := functions[schedule.Function]
+		if !ok {
+			d := grammar.NewDiagnostic(grammar.CodeType, schedule.Position, "schedule names %s, which is not a function in this file", schedule.Function).
+				Until(schedule.End)
+			if suggestion := closest(schedule.Function, names); suggestion != "" {
+				d.Suggest("did you mean %s?", suggestion)
+			}
+			return d
+		}
+		if scheduled[schedule.Function] {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, schedule.Position, "function %s is scheduled more than once", schedule.Function).
+				Until(schedule.End)
+		}
+		scheduled[schedule.Function] = true
+		takes := len(function.Parameters) > 0
+		for _, header := range function.Headers {
+			takes = takes || header.Direction != grammar.HeaderEmitted
+		}
+		if takes {
+			return grammar.NewDiagnostic(grammar.CodeType, schedule.Position, "scheduled function %s must take no parameters or request headers", schedule.Function).
+				Until(schedule.End).
+				Suggest("schedule a function that finds what it works on itself")
+		}
+	}
+	return nil
+}
+
+// checkQueues checks that each queue handles a record of the file and that
+// no two queues share a name
+func checkQueues(file *grammar.File) error {
+	records := make(map[string]*grammar.Type)
+	for _, record := range file.Records {
+		records[record.Name] = nil
+	}
+	names := make(map[string]bool)
+	for _, queue := range file.Queues {
+		if names[queue.Name] {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, queue.Position, "queue %s is declared more than once", queue.Name).
+				Until(queue.End)
+		}
+		names[queue.Name] = true
+		if _, ok := records[queue.Payload]; !ok {
+			d := grammar.NewDiagnostic(grammar.CodeType, queue.Position, "queue %s handles %s, which is not a record in this file", queue.Name, queue.Payload).
+				Until(queue.End)
+			if suggestion := closest(queue.Payload, records); suggestion != "" {
+				d.Suggest("did you mean %s?", suggestion)
+			}
+			return d
+		}
+	}
+	return nil
+}
+
+// checkWorkflows checks that the steps and compensations of each workflow
+// are functions of the file taking no request headers. A step takes nothing
+// or the result of the step before it, and so does a compensation, which
+// the first step cannot have since nothing came before it.
+func checkWorkflows(file *grammar.File) error {
+	functions := make(map[string]*grammar.Function)
+	names := make(map[string]*grammar.Type)
+	for _, function := range file.Functions {
+		functions[function.Name] = function
+		names[function.Name] = nil
+	}
+	declared := make(map[string]bool)
+	for _, record := range file.Records {
+		declared[record.Name] = true
+	}
+	for _, workflow := range file.Workflows {
+		if _, ok := functions[workflow.Name]; ok || declared[workflow.Name] {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, workflow.Position, "%s is declared more than once", workflow.Name).
+				Until(workflow.End).
+				Suggest("rename the workflow, such as %sFlow", workflow.Name)
+		}
+		declared[workflow.Name] = true
+
+		find := func(name, what string, step *grammar.WorkflowStep) (*grammar.Function, error) {
+			function, ok := functions[name]
+			if !ok {
+				d := grammar.NewDiagnostic(grammar.CodeType, step.Position, "%s %s of workflow %s is not a function in this file", what, name, workflow.Name).
+					Until(step.End)
+				if suggestion := closest(name, names); suggestion != "" {
+					d.Suggest("did you mean %s?", suggestion)
+				}
+				return nil, d
+			}
+			for _, header := range function.Headers {
+				if header.Direction != grammar.HeaderEmitted {
+					return nil, grammar.NewDiagnostic(grammar.CodeType, step.Position, "%s %s of workflow %s reads the request header %s, which a workflow cannot pass", what, name, workflow.Name, header.Name).
+						Until(step.End)
+				}
+			}
+			return function, nil
+		}
+		// takes checks that function takes nothing or the result of the
+		// step before, previous
+		takes := func(function *grammar.Function, what string, step *grammar.WorkflowStep, previous *grammar.Function) error {
+			params := function.Parameters
+			switch {
+			case len(params) == 0:
+				return nil
+			case len(params) > 1:
+				return grammar.NewDiagnostic(grammar.CodeType, step.Position, "%s %s of workflow %s takes %d parameters, but is passed only the result of %s", what, function.Name, workflow.Name, len(params), previous.Name).
+					Until(step.End)
+			case previous.ReturnType == nil:
+				return grammar.NewDiagnostic(grammar.CodeType, step.Position, "%s %s of workflow %s takes %s, but %s returns nothing", what, function.Name, workflow.Name, typeName(params[0].Type), previous.Name).
+					Until(step.End)
+			case !sameType(params[0].Type, previous.ReturnType):
+				return grammar.NewDiagnostic(grammar.CodeType, step.Position, "%s %s of workflow %s takes %s, but %s returns %s", what, function.Name, workflow.Name, typeName(params[0].Type), previous.Name, typeName(previous.ReturnType)).
+					Until(step.End)
+			}
+			return nil
+		}
+
+		var previous *grammar.Function
+		for _, step := range workflow.Steps {
+			function, err := find(step.Function, "step", step)
+			if err != nil {
+				return err
+			}
+			if previous != nil {
+				if err := takes(function, "step", step, previous); err != nil {
+					return err
+				}
+			}
+			if step.Compensate != "" {
+				if previous == nil {
+					return grammar.NewDiagnostic(grammar.CodeType, step.Position, "the first step of workflow %s has nothing before it to compensate", workflow.Name).
+						Until(step.End).
+						Suggest("move 'on failure compensate %s' to the step after the one it undoes", step.Compensate)
+				}
+				compensation, err := find(step.Compensate, "compensation", step)
+				if err != nil {
+					return err
+				}
+				if err := takes(compensation, "compensation", step, previous); err != nil {
+					return err
+				}
+			}
+			previous = function
+		}
+	}
+	return nil
+}
+
+// sameType reports whether a and b are the same type, so a value of one
+// can be passed as the other in every generated language
+func sameType(a, b *grammar.Type) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return strings.EqualFold(a.Name, b.Name) && a.Nullable == b.Nullable &&
+		sameType(a.Element, b.Element) && sameType(a.Key, b.Key) && sameType(a.Value, b.Value)
+}
+
+// checkIdentity checks how a record is identified: by the implicit id, by
+// one required key field, or not at all with "no id". Records that extend
+// another share its identity.
+func checkIdentity(record *grammar.Record) error {
+	var key *grammar.FieldDef
+	for _, field := range record.Fields {
+		if !field.Key {
+			continue
+		}
+		if record.Extends != "" {
+			return grammar.NewDiagnostic(grammar.CodeIdentity, field.Position, "record %s is identified like %s, the record it extends", record.Name, record.Extends).
+				Until(field.End).
+				Suggest("mark the key field in %s instead", record.Extends)
+		}
+		if key != nil {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, field.Position, "record %s already has key field %s", record.Name, key.Name).
+				Until(field.End).
+				Suggest("a record has at most one key field")
+		}
+		if field.Type.Optional {
+			return grammar.NewDiagnostic(grammar.CodeOptional, field.Position, "key field %s cannot be optional", field.Name).
+				Until(field.End).
+				Suggest("every %s needs a %s; remove optional", record.Name, field.Name)
+		}
+		key = field
+	}
+	if record.Base != nil && record.Base.HasImplicitID() {
+		for _, field := range record.Fields {
+			if strings.EqualFold(field.Name, "id") {
+				return grammar.NewDiagnostic(grammar.CodeDuplicate, field.Position, "record %s already has an id from %s", record.Name, record.Extends).
+					Until(field.End).
+					Suggest("remove %s or rename it", field.Name)
+			}
+		}
+	}
+
+	if record.Persist != "" && record.Base != nil && !record.Base.HasImplicitID() && record.Base.KeyField() == nil {
+		return grammar.NewDiagnostic(grammar.CodeIdentity, record.Position, "persisted record %s needs an identity, but %s, the record it extends, has none", record.Name, record.Extends).
+			Suggest("remove no id from %s, or mark a key field in it", record.Extends)
+	}
+	if !record.NoID {
+		return nil
+	}
+	if record.Extends != "" {
+		return grammar.NewDiagnostic(grammar.CodeIdentity, record.Position, "record %s is identified like %s, the record it extends", record.Name, record.Extends).
+			Suggest("write no id on %s instead", record.Extends)
+	}
+	if key := record.KeyField(); key != nil {
+		return grammar.NewDiagnostic(grammar.CodeIdentity, key.Position, "record %s has no id but declares %s as its key", record.Name, key.Name).
+			Until(key.End).
+			Suggest("remove no id, or the key")
+	}
+	if record.Versioned {
+		return grammar.NewDiagnostic(grammar.CodeIdentity, record.Position, "versioned record %s needs an identity to be read and replaced by", record.Name).
+			Suggest("remove no id, or mark a key field")
+	}
+	if record.Persist != "" {
+		return grammar.NewDiagnostic(grammar.CodeIdentity, record.Position, "persisted record %s needs an identity to be stored by", record.Name).
+			Suggest("remove no id, or mark a key field")
+	}
+	return nil
+}
@@ -0,0 +1,892 @@
+package analyzer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+const records = `define record Address
+    zip: text
+
+define record User
+    name: text
+    address: Address optional
+`
+
+func TestCheckSafeAccess(t *testing.T) {
+	file, err := grammar.ParseString(records + `
+function zipOf(user: User) returns text
+    why: "Reads the zip code when an address is known"
+    do:
+        return user?.address?.zip or "unknown"`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(file); err != nil {
+		t.Fatalf("unexpected check error: %v", err)
+	}
+
+	ret := file.Functions[0].Body.Statements[0].(*grammar.ReturnStatement)
+	def := ret.Value.(*grammar.DefaultExpression)
+	if def.Type == nil || def.Type.Name != "text" || def.Type.Optional {
+		t.Fatalf("unexpected resolved type: %#v", def.Type)
+	}
+	if !MayBeAbsent(def.Value) {
+		t.Fatal("expected safe chain through an optional field to be possibly absent")
+	}
+}
+
+func TestCheckRejectsUnsafeAccess(t *testing.T) {
+	cases := map[string]string{
+		"return user.address.zip":                                     "user.address is optional; use '?.' to access zip",
+		"return user.name or \"nobody\"":                              "'or' fallback is never used: user.name is not optional",
+		"return user.nickname":                                        "record User has no field nickname",
+		"return user.name if user.name = user.name else user.address": "conditional branches have different types: text and Address",
+	}
+	for body, want := range cases {
+		file, err := grammar.ParseString(records + `
+function check(user: User) returns text
+    why: "Exercises the analyzer"
+    do:
+        ` + body)
+		if err != nil {
+			t.Fatalf("parse error for %q: %v", body, err)
+		}
+		err = Check(file)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error %q for %q, got %v", want, body, err)
+		}
+	}
+}
+
+func TestCheckDiagnostics(t *testing.T) {
+	file, err := grammar.ParseString(records + `
+function check(user: User) returns text
+    why: "Exercises the analyzer"
+    do:
+        return user.nmae`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	d, ok := Check(file).(*grammar.Diagnostic)
+	if !ok {
+		t.Fatalf("expected a *grammar.Diagnostic, got %v", d)
+	}
+	if d.Code != grammar.CodeUnknownField || d.Suggestion != "did you mean name?" {
+		t.Fatalf("unexpected diagnostic: %#v", d)
+	}
+	if d.Range.Start.Line != 11 || d.Range.End.Column-d.Range.Start.Column != len("user.nmae") {
+		t.Fatalf("unexpected range %s to %s", d.Range.Start, d.Range.End)
+	}
+}
+
+func TestCheckQueryInfersElementType(t *testing.T) {
+	file, err := grammar.ParseString(records + `
+function namesNear(users: list[User], zip: text) returns list[text]
+    why: "Lists users living in a zip code"
+    do:
+        set nearby = users where address?.zip = zip
+        return nearby select name`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(file); err != nil {
+		t.Fatalf("unexpected check error: %v", err)
+	}
+
+	stmts := file.Functions[0].Body.Statements
+	nearby := stmts[0].(*grammar.AssignStatement).Value.(*grammar.QueryExpression)
+	if nearby.Type == nil || nearby.Type.Element.Name != "User" {
+		t.Fatalf("unexpected query type: %#v", nearby.Type)
+	}
+	filter := nearby.Filter.(*grammar.BinaryExpression)
+	if !filter.Left.(*grammar.MemberExpression).Object.(*grammar.IdentifierExpression).Element {
+		t.Fatal("expected address to resolve to a field of the query item")
+	}
+	if filter.Right.(*grammar.IdentifierExpression).Element {
+		t.Fatal("expected zip to resolve to the parameter")
+	}
+
+	names := stmts[1].(*grammar.ReturnStatement).Value.(*grammar.QueryExpression)
+	if names.Type == nil || names.Type.Element.Name != "text" {
+		t.Fatalf("expected select to produce list[text], got %#v", names.Type)
+	}
+
+	for body, want := range map[string]string{
+		"return user where name = name":         "user is not a list",
+		"return users where name":               "'where' condition must be boolean, got text",
+		"return users where address.zip = name": "address is optional; use '?.' to access zip",
+		"return users where nmae = \"Ada\"":     "record User has no field nmae",
+		"return users where name = zip":         "record User has no field zip",
+	} {
+		file, err := grammar.ParseString(records + `
+function check(user: User, users: list[User]) returns text
+    why: "Exercises query checks"
+    do:
+        ` + body)
+		if err != nil {
+			t.Fatalf("parse error for %q: %v", body, err)
+		}
+		if err := Check(file); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error %q for %q, got %v", want, body, err)
+		}
+	}
+}
+
+func TestCheckUnknownQueryFieldSuggests(t *testing.T) {
+	file, err := grammar.ParseString(records + `
+function named(users: list[User]) returns list[User]
+    why: "Keeps users with a name"
+    do:
+        return users where nmae is not empty`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	var d *grammar.Diagnostic
+	if !errors.As(Check(file), &d) || d.Code != grammar.CodeUnknownField || d.Suggestion != "did you mean name?" {
+		t.Fatalf("unexpected diagnostic: %#v", d)
+	}
+}
+
+func TestCheckReturnType(t *testing.T) {
+	for body, want := range map[string]string{
+		"return user.name":                  "",
+		"return users where name = \"Ada\"": "",
+		"return user":                       "check is declared to return text, but this returns User",
+		"return users select name":          "check is declared to return text, but this returns list[text]",
+		"return 42":                         "check is declared to return text, but this returns int",
+		"return none":                       "check is declared to return text, but this returns none",
+	} {
+		returns := "text"
+		if strings.Contains(body, "where") {
+			returns = "list[User]"
+		}
+		file, err := grammar.ParseString(records + `
+function check(user: User, users: list[User]) returns ` + returns + `
+    why: "Exercises return checks"
+    do:
+        ` + body)
+		if err != nil {
+			t.Fatalf("parse error for %q: %v", body, err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", body, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, body, err)
+		}
+	}
+
+	file, _ := grammar.ParseString(records + `
+function find(users: list[User]) returns maybe User
+    why: "Finds the first user, if any"
+    do:
+        return none`)
+	if err := Check(file); err != nil {
+		t.Fatalf("expected none to be returned as maybe User, got %v", err)
+	}
+}
+
+func TestCheckAggregates(t *testing.T) {
+	src := `define record Item
+    name: text
+    price: usd_currency
+
+function orderTotal(items: list[Item]) returns usd_currency
+    why: "Adds up the price of every item"
+    do:
+        set average = average of items where price > 0 select price
+        return sum of items.price`
+	file, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(file); err != nil {
+		t.Fatalf("unexpected check error: %v", err)
+	}
+
+	stmts := file.Functions[0].Body.Statements
+	average := stmts[0].(*grammar.AssignStatement).Value.(*grammar.AggregateExpression)
+	if average.Type == nil || average.Type.Name != "number" {
+		t.Fatalf("unexpected average type: %#v", average.Type)
+	}
+	sum := stmts[1].(*grammar.ReturnStatement).Value.(*grammar.AggregateExpression)
+	if sum.Type == nil || sum.Type.Name != "usd_currency" {
+		t.Fatalf("unexpected sum type: %#v", sum.Type)
+	}
+	if query, ok := sum.Source.(*grammar.QueryExpression); !ok || !query.Select.(*grammar.IdentifierExpression).Element {
+		t.Fatalf("expected items.price to be read from each item, got %#v", sum.Source)
+	}
+
+	stock, err := grammar.ParseString("define record Stock\n    units: int round: banker\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(stock); err == nil || !strings.Contains(err.Error(), "'round' only applies to currency fields, but units is int") {
+		t.Fatalf("expected rounding error on a non-currency field, got %v", err)
+	}
+
+	for body, want := range map[string]string{
+		"return sum of items.name":                   "sum needs numeric values, got text",
+		"return count of total":                      "count of total: total is not a list",
+		"return items.price":                         "items is a list; use 'select price' to read price from each item",
+		"return sum of items.quantity round: banker": "'round' only applies to currency values, got int",
+		"return count of items round: half-up":       "count is a whole number; 'round' does not apply",
+	} {
+		file, err := grammar.ParseString(`define record Item
+    name: text
+    price: usd_currency
+    quantity: int
+
+function check(items: list[Item], total: usd_currency) returns usd_currency
+    why: "Exercises aggregate checks"
+    do:
+        ` + body)
+		if err != nil {
+			t.Fatalf("parse error for %q: %v", body, err)
+		}
+		if err := Check(file); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error %q for %q, got %v", want, body, err)
+		}
+	}
+}
+
+func TestCheckVersionedRecord(t *testing.T) {
+	src := `define record Order versioned
+    total: number
+
+function isNew(order: Order) returns boolean
+    why: "Orders start at version zero"
+    do:
+        return order.version = 0`
+	file, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(file); err != nil {
+		t.Fatalf("unexpected check error: %v", err)
+	}
+
+	file, err = grammar.ParseString("define record Order versioned\n    version: int\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(file); err == nil || !strings.Contains(err.Error(), "gets its version field automatically") {
+		t.Fatalf("expected duplicate version error, got %v", err)
+	}
+}
+
+func TestCheckRecordIdentity(t *testing.T) {
+	src := `define record Country
+    code: text key
+    name: text
+
+define record Money no id
+    amount: number
+
+define record Login versioned
+    id: uuid
+
+define record Admin extends Login
+    level: int`
+	file, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(file); err != nil {
+		t.Fatalf("unexpected check error: %v", err)
+	}
+
+	for body, want := range map[string]string{
+		"define record A\n    a: text key\n    b: text key\n":                                    "already has key field a",
+		"define record A\n    a: text optional key\n":                                            "key field a cannot be optional",
+		"define record A no id\n    a: text key\n":                                               "has no id but declares a as its key",
+		"define record A no id\n    id: text\n":                                                  "has no id but declares id as its key",
+		"define record A versioned no id\n    a: text\n":                                         "needs an identity",
+		"define record A persist bucket no id\n    a: text\n":                                    "persisted record A needs an identity",
+		"define record A no id\n    a: text\n\ndefine record B extends A persist\n    b: text\n": "but A, the record it extends, has none",
+		"define record A\n    a: text\n\ndefine record B extends A no id\n    b: text\n":         "identified like A",
+		"define record A\n    a: text\n\ndefine record B extends A\n    b: text key\n":           "identified like A",
+		"define record A\n    a: text\n\ndefine record B extends A\n    id: text\n":              "already has an id from A",
+	} {
+		file, err := grammar.ParseString(body)
+		if err != nil {
+			t.Fatalf("parse error for %q: %v", body, err)
+		}
+		if err := Check(file); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error %q for %q, got %v", want, body, err)
+		}
+	}
+}
+
+func TestCheckHeaderVariables(t *testing.T) {
+	src := `function tenantOf() returns text
+    header: X-Tenant-ID required
+    why: "Handlers scope data by tenant"
+    do:
+        return xTenantID`
+	file, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(file); err != nil {
+		t.Fatalf("unexpected check error: %v", err)
+	}
+
+	src = "function f(xTenantID: text)\n    header: X-Tenant-ID\n    why: \"x\"\n    do:\n        return xTenantID"
+	file, err = grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(file); err == nil || !strings.Contains(err.Error(), "header X-Tenant-ID binds xTenantID") {
+		t.Fatalf("expected header collision error, got %v", err)
+	}
+}
+
+func TestCheckExpectOutsideTest(t *testing.T) {
+	src := "function f() returns boolean\n    why: \"x\"\n    do:\n        expect true\n        return true"
+	file, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(file); err == nil || !strings.Contains(err.Error(), "expect is only allowed in test blocks") {
+		t.Fatalf("expected expect error, got %v", err)
+	}
+
+	src = "test \"truth\":\n    expect true is true"
+	file, err = grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(file); err != nil {
+		t.Fatalf("unexpected check error: %v", err)
+	}
+}
+
+func TestCheckFieldDefaults(t *testing.T) {
+	for src, want := range map[string]string{
+		"define record A\n    status: text default \"active\"\n    at: date default now\n    n: number default 1": "",
+		"define record A\n    n: int default \"many\"":                                                            "default for n must be int, got text",
+		"define record A\n    n: int default 1.5":                                                                 "default for n must be int, got number",
+		"define record A\n    name: text default now":                                                             "'default now' only applies to date, datetime and timestamp fields",
+		"define record A\n    name: text optional default \"x\"":                                                  "optional field name cannot have a default",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckRecordExample(t *testing.T) {
+	const record = "define record A\n    name: text maxlength 5\n    n: int\n    at: datetime\n    wait: duration\n    note: maybe text\n    example:\n"
+	for values, want := range map[string]string{
+		"id: \"123e4567-e89b-12d3-a456-426614174000\"\n        name: \"Ada\"\n        n: -3\n        at: \"2024-01-02T15:04:05Z\"\n        wait: \"90m\"\n        note: none": "",
+		"nane: \"Ada\"":      "record A has no field nane",
+		"n: \"many\"":        "example value of n must be int, got text",
+		"name: \"Adelaide\"": "example value of name is above its maxlength",
+		"at: \"yesterday\"":  "example value of at must be a date such as 2024-01-02",
+		"wait: \"a while\"":  "example value of wait must be a duration such as 90m",
+		"name: none":         "example value of name must be text",
+	} {
+		file, err := grammar.ParseString(record + "        " + values + "\n")
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", values, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, values, err)
+		}
+	}
+}
+
+func TestCheckSeeds(t *testing.T) {
+	const records = "define record User\n    name: text maxlength 5\n    joined: datetime\n\ndefine record Line no id\n    sku: text\n\n"
+	for src, want := range map[string]string{
+		"seed User: 10 records\n    row:\n        name: \"Ada\"\n        joined: \"2024-01-02T15:04:05Z\"\n": "",
+		"seed Usr: 10 records\n":                                      "seed names Usr, which is not a record in this file",
+		"seed Line: 10 records\n":                                     "record Line has no id, so it has no table to seed",
+		"seed User: 10 records\nseed User: 5 records\n":               "User is seeded twice",
+		"seed User: 0 records\n    row:\n        name: \"Ada\"\n":     "seed User asks for fewer records than its rows give",
+		"seed User: 1 record\n    row:\n        name: \"Adelaide\"\n": "row value of name is above its maxlength",
+		"seed User: 1 record\n    row:\n        joined: \"soon\"\n":   "row value of joined must be a date",
+		"seed User: 1 record\n    row:\n        age: 3\n":             "record User has no field age",
+	} {
+		file, err := grammar.ParseString(records + src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckEvents(t *testing.T) {
+	const records = "define record User\n    name: text\n\n"
+	for src, want := range map[string]string{
+		"define event UserCreated\n    payload: User\n":       "",
+		"define event UserCreated\n    payload: Account\n":    "the payload of event UserCreated must be a record of this file, not Account",
+		"define event UserCreated\n    payload: maybe User\n": "must be a record of this file",
+		"define event User\n    payload: User\n":              "User is declared more than once",
+		"define event UserCreated\n    payload: User\n\ndefine event Joined\n    payload: User\n    channel: \"user.created\"\n": "events UserCreated and Joined are both sent on user.created",
+	} {
+		file, err := grammar.ParseString(records + src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckConfigs(t *testing.T) {
+	for src, want := range map[string]string{
+		"config port: int min 1 default 8080\n    why: \"p\"\nconfig timeout: duration default \"30s\"\n    why: \"t\"\n": "",
+		"config port: int\n    why: \"p\"\nconfig port: text\n    why: \"p\"\n":                                           "setting port is declared more than once",
+		"config port: int\n    why: \"p\"\nconfig listen: int from env PORT\n    why: \"l\"\n":                            "settings port and listen are both read from PORT",
+		"config port: int from env port\n    why: \"p\"\n":                                                                "environment variable port of setting port must be upper case",
+		"config hosts: list of text\n    why: \"h\"\n":                                                                    "setting hosts must be a single value, not a list",
+		"config launch: datetime\n    why: \"l\"\n":                                                                       "setting launch must be text, a number, a bool or a duration, not datetime",
+		"config port: int default \"x\"\n    why: \"p\"\n":                                                                "default for port must be int, got text",
+		"config port: int min 1 default 0\n    why: \"p\"\n":                                                              "default for port is below its min",
+		"config port: int optional default 1\n    why: \"p\"\n":                                                           "optional setting port cannot have a default",
+		"config timeout: duration default \"soon\"\n    why: \"t\"\n":                                                     "default for timeout must be a duration",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	order := "define record Order\n    total: money\n"
+	for src, want := range map[string]string{
+		order + "define role admin\nallow admin to read, delete Order\nallow clerk to read Order\n": "",
+		"define role admin\ndefine role admin\n":                                                    "role admin is declared more than once",
+		order + "allow admin to read Ordr\n":                                                        "allow admin names Ordr, which is not a record in this file",
+		order + "allow admin to read, update Order\nallow admin to update Order\n":                  "admin is already allowed to update Order",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckSchedules(t *testing.T) {
+	cleanup := "function cleanup()\n    why: \"c\"\n    do:\n        return\n\n"
+	for src, want := range map[string]string{
+		cleanup + "schedule cleanup every 1 hour\n    why: \"s\"\n":                                                          "",
+		cleanup + "schedule cleanpu every 1 hour\n    why: \"s\"\n":                                                          "schedule names cleanpu, which is not a function in this file",
+		cleanup + "schedule cleanup every 1 hour\n    why: \"s\"\nschedule cleanup every 2 hours\n    why: \"s\"\n":          "function cleanup is scheduled more than once",
+		"function purge(days: int)\n    why: \"p\"\n    do:\n        return\n\nschedule purge every 1 day\n    why: \"s\"\n": "scheduled function purge must take no parameters or request headers",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckQueues(t *testing.T) {
+	task := "define record SendEmailTask\n    to: email\n\n"
+	for src, want := range map[string]string{
+		task + "queue emailQueue handles SendEmailTask\n":                                         "",
+		task + "queue emailQueue handles SendEmailTsk\n":                                          "queue emailQueue handles SendEmailTsk, which is not a record in this file",
+		task + "queue emailQueue handles SendEmailTask\nqueue emailQueue handles SendEmailTask\n": "queue emailQueue is declared more than once",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckWorkflows(t *testing.T) {
+	functions := `define record Account
+    email: email
+
+function createAccount(email: email) returns Account
+    why: "c"
+    do:
+        create Account with: email = email
+        return account
+
+function sendWelcome(account: Account)
+    why: "s"
+    do:
+        return
+
+function deleteAccount(account: Account)
+    why: "d"
+    do:
+        return
+
+function audit()
+    why: "a"
+    do:
+        return
+
+function rename(name: text, account: Account)
+    why: "r"
+    do:
+        return
+
+function findAccount(email: email) returns maybe Account
+    why: "f"
+    do:
+        return none
+
+`
+	for src, want := range map[string]string{
+		"workflow onboard: step createAccount then step sendWelcome on failure compensate deleteAccount then step audit\n    why: \"o\"\n": "",
+		"workflow onboard: step createAccount then step sendWelcom\n    why: \"o\"\n":                                                      "step sendWelcom of workflow onboard is not a function in this file",
+		"workflow onboard: step createAccount on failure compensate deleteAccount\n    why: \"o\"\n":                                       "the first step of workflow onboard has nothing before it to compensate",
+		"workflow onboard: step createAccount then step audit then step sendWelcome\n    why: \"o\"\n":                                     "step sendWelcome of workflow onboard takes Account, but audit returns nothing",
+		"workflow onboard: step createAccount then step rename\n    why: \"o\"\n":                                                          "step rename of workflow onboard takes 2 parameters, but is passed only the result of createAccount",
+		"workflow onboard: step audit then step createAccount\n    why: \"o\"\n":                                                           "step createAccount of workflow onboard takes email, but audit returns nothing",
+		"workflow onboard: step createAccount then step audit on failure compensate rename\n    why: \"o\"\n":                              "compensation rename of workflow onboard takes 2 parameters",
+		"workflow audit: step audit\n    why: \"o\"\n":                                                                                     "audit is declared more than once",
+		"workflow onboard: step findAccount then step sendWelcome\n    why: \"o\"\n":                                                       "step sendWelcome of workflow onboard takes Account, but findAccount returns maybe Account",
+	} {
+		file, err := grammar.ParseString(functions + src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckFieldConstraints(t *testing.T) {
+	for src, want := range map[string]string{
+		"define record A\n    age: int min 0 max 150\n    name: text maxlength 80": "",
+		"define record A\n    name: text max 80":                                   "'max' only applies to number fields, but name is text",
+		"define record A\n    age: int maxlength 3":                                "'maxlength' only applies to text fields, but age is int",
+		"define record A\n    age: int min 10 max 1":                               "min of age is greater than its max",
+		"define record A\n    age: int max 10 default 11":                          "default for age is above its max",
+		"define record A\n    code: text minlength 3 default \"ab\"":               "default for code is below its minlength",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+
+	file, _ := grammar.ParseString("define record A\n    name: text max 80")
+	var d *grammar.Diagnostic
+	if !errors.As(Check(file), &d) || d.Code != grammar.CodeConstraint || d.Suggestion != "use maxlength to limit the length of text" {
+		t.Fatalf("unexpected diagnostic: %#v", d)
+	}
+}
+
+func TestDeclaredTypes(t *testing.T) {
+	types, err := grammar.ParseString(`define type CustomerId as uuid
+
+define type Price as usd_currency
+    validate: value between 0.01 and 9999.99
+
+define type SalePrice as Price
+
+assign-use Handle as text
+    validate: "length between 3 and 15"
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	file, err := grammar.ParseString(`define record Product
+    id: CustomerId
+    price: SalePrice max 100
+    handles: list[Handle]
+
+function priceOf(product: Product) returns SalePrice
+    why: "Reads the price"
+    do:
+        return product.price
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	shared, err := DeclaredTypes(types)
+	if err != nil {
+		t.Fatalf("DeclaredTypes: %v", err)
+	}
+	if err := CheckWith(file, shared); err != nil {
+		t.Fatalf("CheckWith: %v", err)
+	}
+	fields := file.Records[0].Fields
+	if id := fields[0].Type; id.Name != "uuid" || id.Alias != "CustomerId" {
+		t.Errorf("unexpected id type: %+v", id)
+	}
+	if price := fields[1].Type; price.Name != "usd_currency" || price.Constraints["min"] != 0.01 || price.Constraints["max"] != int64(100) {
+		t.Errorf("unexpected price type: %+v", price)
+	}
+	if handle := fields[2].Type.Element; handle.Name != "text" || handle.Constraints["maxlength"] != int64(15) {
+		t.Errorf("unexpected handle type: %+v", handle)
+	}
+	if returns := file.Functions[0].ReturnType; returns.Name != "usd_currency" {
+		t.Errorf("unexpected return type: %+v", returns)
+	}
+
+	for src, want := range map[string]string{
+		"define type A as B\ndefine type B as A":                       "type A is defined in terms of itself (A -> B -> A)",
+		"define type A as text\nassign-use A as int":                   "type A is declared more than once",
+		"define type A as text\n    validate: \"shorter than 5\"":      `unknown validation rule "shorter than 5"`,
+		"define type A as int\n    validate: domain must be \"x.com\"": "'domain' only applies to text fields",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		if _, err := DeclaredTypes(file); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckRecordExtends(t *testing.T) {
+	for src, want := range map[string]string{
+		"define record User\n    name: text\n\ndefine record Admin extends User\n    level: int\n\nfunction nameOf(admin: Admin) returns text\n    why: \"Reads the name\"\n    do:\n        return admin.name": "",
+		"define record User\n    name: text\n\ndefine record Admin extends Usr\n    level: int":                                                                                                                 "record Admin extends Usr, which is not a record in this file",
+		"define record A extends B\n    x: int\n\ndefine record B extends A\n    y: int":                                                                                                                        "extends itself",
+		"define record User\n    name: text\n\ndefine record Admin extends User\n    name: text":                                                                                                                "record Admin already has field name from User",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+
+	file, _ := grammar.ParseString("define record User\n    name: text\n\ndefine record Admin extends Usr\n    level: int")
+	var d *grammar.Diagnostic
+	if !errors.As(Check(file), &d) || d.Suggestion != "did you mean User?" {
+		t.Fatalf("unexpected diagnostic: %#v", d)
+	}
+}
+
+func TestCheckMapTypes(t *testing.T) {
+	for src, want := range map[string]string{
+		"define record Team\n    scores: map of text to int\n\nfunction scoresOf(team: Team) returns map<text, int>\n    why: \"Reads the scores\"\n    do:\n        return team.scores": "",
+		"define record Team\n    scores: map of date to int": "keys of map scores must be text or whole numbers, got date",
+		"define record Team\n    scores: map of text to int\n\nfunction adaOf(team: Team) returns int\n    why: \"Reads a score\"\n    do:\n        return team.scores.ada": "team.scores is a map; its entries are not fields",
+		"define record Team\n    scores: map of text to int default 1": "default for scores must be map",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckMaybeAndNone(t *testing.T) {
+	for src, want := range map[string]string{
+		"define record Person\n    nickname: maybe text\n\nfunction pick(person: Person, flag: boolean) returns maybe text\n    why: \"Picks a nickname\"\n    do:\n        return person.nickname if flag else none":   "",
+		"define record Person\n    name: text\n\nfunction pick(person: Person, flag: boolean) returns text\n    why: \"Picks a name\"\n    do:\n        return person.name if flag else none":                           "conditional branches have different types: text and none",
+		"define record Address\n    city: text\n\ndefine record Person\n    home: maybe Address\n\nfunction cityOf(person: Person) returns text\n    why: \"Reads the city\"\n    do:\n        return person.home.city": "person.home is optional; use '?.' to access city",
+		"define record Person\n    name: text default none": "default for name must be text, got none",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckListOperations(t *testing.T) {
+	file, err := grammar.ParseString(`define record Order
+    total: usd_currency
+    status: text
+    tags: list of text
+
+function pick(orders: list of Order, order: Order) returns boolean
+    why: "Exercises list operations"
+    do:
+        set big = first of orders where total > 100
+        set latest = last of orders select total
+        set letters = length of order.status
+        set tagCount = length of order.tags
+        return "rush" in order.tags
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	statements := file.Functions[0].Body.Statements
+	types := make([]*grammar.Type, 4)
+	for i := range types {
+		types[i] = statements[i].(*grammar.AssignStatement).Value.(*grammar.AggregateExpression).Type
+	}
+	if types[0].Name != "Order" || !types[0].Nullable {
+		t.Errorf("first of orders = %+v, want maybe Order", types[0])
+	}
+	if types[1].Name != "usd_currency" || !types[1].Nullable {
+		t.Errorf("last of totals = %+v, want maybe usd_currency", types[1])
+	}
+	if types[2].Name != "int" || types[3].Name != "int" {
+		t.Errorf("lengths = %+v, %+v, want int", types[2], types[3])
+	}
+	if function := statements[3].(*grammar.AssignStatement).Value.(*grammar.AggregateExpression).Function; function != "count" {
+		t.Errorf("length of a list = %s, want count", function)
+	}
+
+	for src, want := range map[string]string{
+		"define record Order\n    status: text\n\nfunction f(order: Order) returns boolean\n    why: \"x\"\n    do:\n        return \"new\" in order.status":                                         "order.status is not a list; 'in' needs a list",
+		"define record Order\n    total: usd_currency\n\nfunction f(orders: list of Order) returns usd_currency\n    why: \"x\"\n    do:\n        return first of orders select total round: banker": "first picks an item; 'round' does not apply",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		if err := Check(file); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckIsOperators(t *testing.T) {
+	for src, want := range map[string]string{
+		"define record User\n    name: text\n    nickname: maybe text\n    tags: list of text\n\nfunction f(user: User) returns boolean\n    why: \"x\"\n    do:\n        if user.nickname is none\n            then return user.tags is not empty\n        return user.name is empty": "",
+		"define record User\n    age: int\n\nfunction f(user: User) returns boolean\n    why: \"x\"\n    do:\n        return user.age is empty":                                                                                                                                        "'is empty' applies to text, lists and maps, but user.age is int",
+		"define record User\n    name: text\n\nfunction f(user: User) returns boolean\n    why: \"x\"\n    do:\n        return user.name is none":                                                                                                                                      "user.name can never be none: it is not optional",
+	} {
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestCheckMethodCalls(t *testing.T) {
+	const records = "define record Customer\n    email: email\n\ndefine record Order\n    customer: Customer\n    total: usd_currency\n\nfunction isBig(order: Order) returns boolean\n    why: \"x\"\n    do:\n        return order.total > 100\n\n"
+	for body, want := range map[string]string{
+		"return order.isBig()":                       "",
+		"return order.customer.email contains \"@\"": "",
+		"return order.total()":                       "total is a field of Order, not a function",
+		"return order.customer.isBig()":              "isBig takes Order as its first parameter, but order.customer is Customer",
+	} {
+		src := records + "function f(order: Order) returns boolean\n    why: \"x\"\n    do:\n        " + body
+		file, err := grammar.ParseString(src)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		err = Check(file)
+		if want == "" {
+			if err != nil {
+				t.Errorf("unexpected check error for %q: %v", body, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, body, err)
+		}
+	}
+}
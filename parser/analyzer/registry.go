@@ -0,0 +1,246 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Types holds the custom types declared with "define type" and "assign-use",
+// by name. Each is resolved to the built-in type it is stored as, carrying
+// its validation rule as constraints.
+type Types map[string]*grammar.Type
+
+// customType is a declaration Types are built from
+type customType struct {
+	name     string
+	base     *grammar.Type
+	rule     string
+	position *grammar.Position
+	end      *grammar.Position
+}
+
+// DeclaredTypes collects the custom types of files, such as all the files
+// of a project. It fails on a type declared twice, a cycle of types or a
+// validation rule it does not understand.
+func DeclaredTypes(files ...*grammar.File) (Types, error) {
+	declared := make(map[string]*customType)
+	var names []string
+	add := func(c *customType) error {
+		if _, ok := declared[c.name]; ok {
+			return grammar.NewDiagnostic(grammar.CodeDuplicate, c.position, "type %s is declared more than once", c.name).
+				Until(c.end)
+		}
+		declared[c.name] = c
+		names = append(names, c.name)
+		return nil
+	}
+	for _, file := range files {
+		for _, typeDef := range file.TypeDefs {
+			rule, _ := typeDef.Validation["rule"].(string)
+			if err := add(&customType{typeDef.Name, typeDef.BaseType, rule, typeDef.Position, typeDef.End}); err != nil {
+				return nil, err
+			}
+		}
+		for _, assignment := range file.Assignments {
+			rule, _ := assignment.Validation["rule"].(string)
+			if err := add(&customType{assignment.TypeName, assignment.BaseType, rule, assignment.Position, assignment.End}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	types := make(Types)
+	var resolve func(name string, seen []string) (*grammar.Type, error)
+	resolve = func(name string, seen []string) (*grammar.Type, error) {
+		if t, ok := types[name]; ok {
+			return t, nil
+		}
+		c := declared[name]
+		for _, s := range seen {
+			if s == name {
+				return nil, grammar.NewDiagnostic(grammar.CodeType, c.position, "type %s is defined in terms of itself (%s)", name, strings.Join(append(seen, name), " -> ")).
+					Until(c.end)
+			}
+		}
+
+		t := &grammar.Type{Name: c.base.Name, Element: c.base.Element, Key: c.base.Key, Value: c.base.Value, Constraints: make(map[string]interface{})}
+		if _, ok := declared[c.base.Name]; ok {
+			base, err := resolve(c.base.Name, append(seen, name))
+			if err != nil {
+				return nil, err
+			}
+			t.Name, t.Element, t.Key, t.Value = base.Name, base.Element, base.Key, base.Value
+			for key, value := range base.Constraints {
+				t.Constraints[key] = value
+			}
+		}
+		for key, value := range c.base.Constraints {
+			t.Constraints[key] = value
+		}
+		if c.rule != "" {
+			constraints, err := parseRule(c.rule)
+			if err != nil {
+				return nil, grammar.NewDiagnostic(grammar.CodeConstraint, c.position, "type %s: %v", name, err).
+					Until(c.end).
+					Suggest(`write value between A and B, length between A and B, or domain must be "example.com"`)
+			}
+			for key, value := range constraints {
+				t.Constraints[key] = value
+			}
+		}
+		t.Position, t.End = c.position, c.end
+		if err := checkConstraints(name, t); err != nil {
+			return nil, err
+		}
+		types[name] = t
+		return t, nil
+	}
+	for _, name := range names {
+		if _, err := resolve(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range types {
+		types.Resolve(t.Element) // "define type Ids as list[CustomerId]"
+		types.Resolve(t.Key)
+		types.Resolve(t.Value)
+	}
+	return types, nil
+}
+
+// parseRule reads a validation rule into constraints. The rules are
+//
+//	value between 0.01 and 9999.99
+//	length between 1 and 80
+//	domain must be "company.com"
+func parseRule(rule string) (map[string]interface{}, error) {
+	words := strings.Fields(rule)
+	switch {
+	case len(words) == 5 && (words[0] == "value" || words[0] == "length") && words[1] == "between" && words[3] == "and":
+		low, high := grammar.ConstraintMin, grammar.ConstraintMax
+		if words[0] == "length" {
+			low, high = grammar.ConstraintMinLength, grammar.ConstraintMaxLength
+		}
+		lowValue, err := ruleNumber(words[2], words[0] == "length")
+		if err != nil {
+			return nil, err
+		}
+		highValue, err := ruleNumber(words[4], words[0] == "length")
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{low: lowValue, high: highValue}, nil
+	case len(words) == 4 && words[0] == "domain" && words[1] == "must" && words[2] == "be":
+		domain, err := strconv.Unquote(words[3])
+		if err != nil {
+			return nil, fmt.Errorf("expected a quoted domain, got %s", words[3])
+		}
+		return map[string]interface{}{grammar.ConstraintDomain: domain}, nil
+	}
+	return nil, fmt.Errorf("unknown validation rule %q", rule)
+}
+
+// ruleNumber parses a bound of a rule; lengths are whole numbers
+func ruleNumber(word string, whole bool) (interface{}, error) {
+	if n, err := strconv.ParseInt(word, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil && !whole {
+		return f, nil
+	}
+	if whole {
+		return nil, fmt.Errorf("expected a whole number, got %s", word)
+	}
+	return nil, fmt.Errorf("expected a number, got %s", word)
+}
+
+// Resolve replaces a custom type with the type it is stored as, recording
+// its name in Alias. Constraints of the field itself win over those of the
+// custom type. List elements and map keys and values are resolved too.
+func (types Types) Resolve(t *grammar.Type) {
+	if t == nil {
+		return
+	}
+	types.Resolve(t.Element)
+	types.Resolve(t.Key)
+	types.Resolve(t.Value)
+	custom, ok := types[t.Name]
+	if !ok {
+		return
+	}
+	t.Alias = t.Name
+	t.Name = custom.Name
+	if t.Element == nil {
+		t.Element = custom.Element
+	}
+	if t.Key == nil {
+		t.Key, t.Value = custom.Key, custom.Value
+	}
+	if t.Constraints == nil {
+		t.Constraints = make(map[string]interface{})
+	}
+	for key, value := range custom.Constraints {
+		if _, ok := t.Constraints[key]; !ok {
+			t.Constraints[key] = value
+		}
+	}
+}
+
+// resolveFile resolves the custom types of every field, parameter, return
+// type and setting of file
+func (types Types) resolveFile(file *grammar.File) {
+	for _, record := range file.Records {
+		for _, field := range record.Fields {
+			types.Resolve(field.Type)
+		}
+	}
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			types.Resolve(field.Type)
+		}
+	}
+	for _, function := range file.Functions {
+		for _, param := range function.Parameters {
+			types.Resolve(param.Type)
+		}
+		types.Resolve(function.ReturnType)
+	}
+	for _, config := range file.Configs {
+		types.Resolve(config.Type)
+	}
+}
+
+// Fingerprint describes every type, so a build can tell when the types
+// shared across a project changed
+func (types Types) Fingerprint() string {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		t := types[name]
+		fmt.Fprintf(&b, "%s=%s", name, t.Name)
+		if t.Element != nil {
+			fmt.Fprintf(&b, "[%s]", t.Element.Name)
+		}
+		if t.Key != nil {
+			fmt.Fprintf(&b, "<%s,%s>", t.Key.Name, t.Value.Name)
+		}
+		keys := make([]string, 0, len(t.Constraints))
+		for key := range t.Constraints {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&b, " %s:%v", key, t.Constraints[key])
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
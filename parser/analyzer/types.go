@@ -0,0 +1,121 @@
+package analyzer
+
+import (
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Kind groups CloudPact types by the value they hold at runtime
+type Kind string
+
+const (
+	KindText     Kind = "text"
+	KindNumber   Kind = "number"
+	KindBoolean  Kind = "boolean"
+	KindTemporal Kind = "temporal"
+	KindRecord   Kind = "record"
+	KindList     Kind = "list"
+	KindMap      Kind = "map"
+)
+
+// KindOf classifies a type; semantic types fall into the kind of the value
+// they are stored as, e.g. email is text and usd_currency is a number
+func KindOf(t *grammar.Type) Kind {
+	switch strings.ToLower(t.Name) {
+	case "int", "integer", "long", "bigint", "float", "double", "number",
+		"usd_currency", "eur_currency", "percentage":
+		return KindNumber
+	case "bool", "boolean":
+		return KindBoolean
+	case "date", "datetime", "timestamp", "duration":
+		return KindTemporal
+	case "text", "string":
+		return KindText
+	case "list":
+		return KindList
+	case "map":
+		return KindMap
+	}
+	if t.Name != "" && t.Name[0] >= 'A' && t.Name[0] <= 'Z' {
+		return KindRecord
+	}
+	return KindText
+}
+
+// IsNumeric reports whether values of t support arithmetic
+func IsNumeric(t *grammar.Type) bool {
+	return t != nil && KindOf(t) == KindNumber
+}
+
+// isWholeNumber reports whether t holds integers
+func isWholeNumber(t *grammar.Type) bool {
+	switch strings.ToLower(t.Name) {
+	case "int", "integer", "long", "bigint":
+		return true
+	}
+	return false
+}
+
+// IsCurrency reports whether t holds money, the only values that take a rounding mode
+func IsCurrency(t *grammar.Type) bool {
+	if t == nil {
+		return false
+	}
+	switch strings.ToLower(t.Name) {
+	case "usd_currency", "eur_currency":
+		return true
+	}
+	return false
+}
+
+// typeName writes t as it is declared, e.g. "maybe Address" or
+// "list[User]", for messages
+func typeName(t *grammar.Type) string {
+	name := t.Name
+	switch {
+	case t.Element != nil:
+		name = "list[" + typeName(t.Element) + "]"
+	case t.Key != nil && t.Value != nil:
+		name = "map<" + typeName(t.Key) + ", " + typeName(t.Value) + ">"
+	}
+	if t.Nullable && t.Name != "none" {
+		return "maybe " + name
+	}
+	return name
+}
+
+// literalType is the CloudPact type of a constant
+func literalType(e *grammar.LiteralExpression) *grammar.Type {
+	name := "text"
+	switch e.Kind {
+	case grammar.LiteralInt:
+		name = "int"
+	case grammar.LiteralFloat:
+		name = "number"
+	case grammar.LiteralBool:
+		name = "boolean"
+	case grammar.LiteralNone:
+		return &grammar.Type{Name: "none", Optional: true, Nullable: true, Position: e.Position}
+	}
+	return &grammar.Type{Name: name, Position: e.Position}
+}
+
+// compatible reports whether values of a and b can be used interchangeably
+func compatible(a, b *grammar.Type) bool {
+	// none is a value of any type that may be none
+	if a.Name == "none" || b.Name == "none" {
+		return a.Optional && b.Optional
+	}
+	kindA, kindB := KindOf(a), KindOf(b)
+	if kindA == KindList && kindB == KindList {
+		return a.Element == nil || b.Element == nil || compatible(a.Element, b.Element)
+	}
+	if kindA == KindMap && kindB == KindMap {
+		return a.Key == nil || b.Key == nil || (compatible(a.Key, b.Key) && compatible(a.Value, b.Value))
+	}
+	if kindA == KindRecord || kindB == KindRecord {
+		return a.Name == b.Name
+	}
+	return kindA == kindB
+}
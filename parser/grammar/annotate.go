@@ -0,0 +1,84 @@
+package grammar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetAnnotation gives a function an ai-<annotationType> annotation with
+// content, rewriting the first existing one of that type or adding one
+// before its do block. Only that line of source changes.
+func SetAnnotation(source []byte, function, annotationType, content string) ([]byte, error) {
+	return annotate(source, function, annotationType, content, true)
+}
+
+// AddAnnotation adds an ai-<annotationType> annotation before the
+// function's do block, keeping any of the same type, as decision history
+// such as ai-decision-accepted does
+func AddAnnotation(source []byte, function, annotationType, content string) ([]byte, error) {
+	return annotate(source, function, annotationType, content, false)
+}
+
+func annotate(source []byte, name, annotationType, content string, update bool) ([]byte, error) {
+	if !isAIAnnotation("ai-" + annotationType) {
+		return nil, fmt.Errorf("unknown AI annotation ai-%s", annotationType)
+	}
+	file, err := ParseString(string(source))
+	if err != nil {
+		return nil, err
+	}
+	var function *Function
+	for _, f := range file.Functions {
+		if f.Name == name {
+			function = f
+			break
+		}
+	}
+	if function == nil || function.Position == nil {
+		return nil, fmt.Errorf("no function %s", name)
+	}
+
+	lines := strings.Split(string(source), "\n")
+	// Strings cannot escape quotes, so quotes in content become apostrophes
+	text := fmt.Sprintf("ai-%s: \"%s\"", annotationType, strings.ReplaceAll(content, `"`, "'"))
+
+	if update {
+		for _, annotation := range function.AIAnnotations {
+			if annotation.Type == annotationType && annotation.Position != nil {
+				i := annotation.Position.Line - 1
+				lines[i] = indentation(lines[i]) + text + lineEnding(lines[i])
+				return reparse(lines)
+			}
+		}
+	}
+
+	for i := function.Position.Line; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "do:") {
+			line := indentation(lines[i]) + text + lineEnding(lines[i])
+			lines = append(lines[:i], append([]string{line}, lines[i:]...)...)
+			return reparse(lines)
+		}
+	}
+	return nil, fmt.Errorf("function %s has no do block", name)
+}
+
+// reparse joins edited lines, checking they still parse
+func reparse(lines []string) ([]byte, error) {
+	source := strings.Join(lines, "\n")
+	if _, err := ParseString(source); err != nil {
+		return nil, err
+	}
+	return []byte(source), nil
+}
+
+func indentation(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// lineEnding keeps the \r of files with Windows line endings
+func lineEnding(line string) string {
+	if strings.HasSuffix(line, "\r") {
+		return "\r"
+	}
+	return ""
+}
@@ -2,14 +2,19 @@
 // ast.go defines the core AST structures representing CloudPact programs.
 package grammar
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
 
 // Enhanced Position with more context
 type Position struct {
-	Line   int
-	Column int
-	Offset int
-	File   string
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Offset int    `json:"offset"`
+	File   string `json:"file,omitempty"`
 }
 
 func (p Position) String() string {
@@ -26,28 +31,305 @@ type File struct {
 	Models      []*Model      `json:"models"` // Legacy support
 	Functions   []*Function   `json:"functions"`
 	TypeDefs    []*TypeDef    `json:"type_defs"`
+	Events      []*Event      `json:"events,omitempty"`
 	Assignments []*Assignment `json:"assignments"` // Legacy support
+	Tests       []*Test       `json:"tests,omitempty"`
+	Seeds       []*Seed       `json:"seeds,omitempty"`
+	Configs     []*Config     `json:"configs,omitempty"`
+	Roles       []*Role       `json:"roles,omitempty"`
+	Allows      []*Allow      `json:"allows,omitempty"`
+	Schedules   []*Schedule   `json:"schedules,omitempty"`
+	Workflows   []*Workflow   `json:"workflows,omitempty"`
+	Queues      []*Queue      `json:"queues,omitempty"`
+	Comments    []*Comment    `json:"comments,omitempty"` // every comment in the file
 	Position    *Position     `json:"position,omitempty"`
+	End         *Position     `json:"end,omitempty"`
+}
+
+// Localize replaces each why clause with its translation for locale, where
+// one is given, so generators emit that language
+func (f *File) Localize(locale string) {
+	if locale == "" {
+		return
+	}
+	for _, function := range f.Functions {
+		if why, ok := function.Whys[locale]; ok {
+			function.Why = why
+		}
+	}
+	for _, typeDef := range f.TypeDefs {
+		if why, ok := typeDef.Whys[locale]; ok {
+			typeDef.Why = why
+		}
+	}
+	for _, assignment := range f.Assignments {
+		if why, ok := assignment.Whys[locale]; ok {
+			assignment.Why = why
+		}
+	}
+	for _, event := range f.Events {
+		if why, ok := event.Whys[locale]; ok {
+			event.Why = why
+		}
+	}
+	for _, config := range f.Configs {
+		if why, ok := config.Whys[locale]; ok {
+			config.Why = why
+		}
+	}
+	for _, role := range f.Roles {
+		if why, ok := role.Whys[locale]; ok {
+			role.Why = why
+		}
+	}
+	for _, schedule := range f.Schedules {
+		if why, ok := schedule.Whys[locale]; ok {
+			schedule.Why = why
+		}
+	}
+	for _, workflow := range f.Workflows {
+		if why, ok := workflow.Whys[locale]; ok {
+			workflow.Why = why
+		}
+	}
+	for _, queue := range f.Queues {
+		if why, ok := queue.Whys[locale]; ok {
+			queue.Why = why
+		}
+	}
+}
+
+// JSON naming policies, the json_names setting of cloudpact.yaml: how a
+// field's declared name becomes its key in JSON
+const (
+	JSONLower     = "lower"      // createdAt -> createdat, the default
+	JSONCamel     = "camel"      // created_at -> createdAt
+	JSONSnake     = "snake"      // createdAt -> created_at
+	JSONAsWritten = "as-written" // the name as declared
+)
+
+// JSONNamings are the policies NameJSON accepts
+var JSONNamings = []string{JSONLower, JSONCamel, JSONSnake, JSONAsWritten}
+
+// NameJSON sets the JSON key of every record and model field by policy, one
+// of JSONNamings, so generators agree on it; empty means JSONLower
+func (f *File) NameJSON(policy string) {
+	for _, record := range f.Records {
+		for _, field := range record.Fields {
+			field.JSONName = JSONName(field.Name, policy)
+		}
+	}
+	for _, model := range f.Models {
+		for _, field := range model.Fields {
+			field.JSONName = JSONName(field.Name, policy)
+		}
+	}
+}
+
+// JSONName is the JSON key of a field called name under policy
+func JSONName(name, policy string) string {
+	switch policy {
+	case JSONAsWritten:
+		return name
+	case JSONCamel:
+		words := splitWords(name)
+		for i, word := range words {
+			word = strings.ToLower(word)
+			if i > 0 {
+				word = strings.ToUpper(word[:1]) + word[1:]
+			}
+			words[i] = word
+		}
+		return strings.Join(words, "")
+	case JSONSnake:
+		words := splitWords(name)
+		for i, word := range words {
+			words[i] = strings.ToLower(word)
+		}
+		return strings.Join(words, "_")
+	}
+	return strings.ToLower(name)
+}
+
+// splitWords splits an identifier at underscores and case changes, keeping
+// acronyms whole: "userID" and "HTTPServer" give user ID and HTTP Server
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 0; i <= len(runes); i++ {
+		boundary := i == len(runes) || runes[i] == '_'
+		if !boundary && i > start && unicode.IsUpper(runes[i]) {
+			// a lower-case letter or digit ends a word, and so does an
+			// acronym followed by a capitalized word
+			boundary = !unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))
+		}
+		if !boundary {
+			continue
+		}
+		if i > start {
+			words = append(words, string(runes[start:i]))
+		}
+		start = i
+		if i < len(runes) && runes[i] == '_' {
+			start = i + 1
+		}
+	}
+	return words
+}
+
+// DefaultNow is the default of a temporal field set to the time of creation,
+// "createdAt: datetime default now"
+const DefaultNow = "now"
+
+// IsDefaultNow reports whether a field default is "now"
+func IsDefaultNow(e Expression) bool {
+	ident, ok := e.(*IdentifierExpression)
+	return ok && ident.Name == DefaultNow
 }
 
 // Module declaration
 type Module struct {
-	Name     string    `json:"name"`
-	Position *Position `json:"position,omitempty"`
+	Name     string     `json:"name"`
+	Leading  []*Comment `json:"leading_comments,omitempty"`
+	Trailing []*Comment `json:"trailing_comments,omitempty"`
+	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
 }
 
 // Record definition (new syntax)
 type Record struct {
-	Name     string      `json:"name"`
+	Name      string  `json:"name"`
+	Extends   string  `json:"extends,omitempty"`   // record whose fields this one shares
+	Base      *Record `json:"-"`                   // Resolved by the analyzer: the record named by Extends
+	Versioned bool    `json:"versioned,omitempty"` // carries a version for optimistic concurrency
+	NoID      bool    `json:"no_id,omitempty"`     // "no id": a value without an identity
+	// Persist is where the cloud infrastructure keeps the record: "table"
+	// for "persist", a managed database table, or "bucket" for "persist
+	// bucket", an object store; empty when it is not persisted
+	Persist  string      `json:"persist,omitempty"`
 	Fields   []*FieldDef `json:"fields"`
+	Example  *Example    `json:"example,omitempty"` // sample values, declared after the fields
+	Leading  []*Comment  `json:"leading_comments,omitempty"`
+	Trailing []*Comment  `json:"trailing_comments,omitempty"`
 	Position *Position   `json:"position,omitempty"`
+	End      *Position   `json:"end,omitempty"`
+}
+
+// Example is a sample of a record, the "example:" block after its fields
+// with a value per line: "name: \"Ada\"". The API specs show it and
+// generated tests and fixtures start from it.
+type Example struct {
+	Values   []*ExampleValue `json:"values"`
+	Position *Position       `json:"position,omitempty"`
+	End      *Position       `json:"end,omitempty"`
+}
+
+// ExampleValue is the value an example gives one field
+type ExampleValue struct {
+	Field    string             `json:"field"`
+	Value    *LiteralExpression `json:"value"`
+	Position *Position          `json:"position,omitempty"`
+	End      *Position          `json:"end,omitempty"`
+}
+
+// Value returns the value the example gives field, or nil when it gives
+// none. A nil example gives none.
+func (e *Example) Value(field string) *LiteralExpression {
+	if e == nil {
+		return nil
+	}
+	for _, value := range e.Values {
+		if value.Field == field {
+			return value.Value
+		}
+	}
+	return nil
+}
+
+// ExampleValue returns the value the record's example gives field, else the
+// value the example of the record it extends gives, or nil
+func (r *Record) ExampleValue(field string) *LiteralExpression {
+	if value := r.Example.Value(field); value != nil {
+		return value
+	}
+	if r.Base != nil {
+		return r.Base.ExampleValue(field)
+	}
+	return nil
+}
+
+// ParseTime reads the text given for a date, datetime or timestamp: a date
+// such as 2024-01-02 or a time in RFC 3339 such as 2024-01-02T15:04:05Z
+func ParseTime(text string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, text); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, text)
+}
+
+// AllFields returns the fields of the records this one extends, most basic
+// first, followed by its own. The base records must have been resolved by
+// the analyzer.
+func (r *Record) AllFields() []*FieldDef {
+	if r.Base == nil {
+		return r.Fields
+	}
+	return append(append([]*FieldDef{}, r.Base.AllFields()...), r.Fields...)
+}
+
+// IsVersioned reports whether the record or one it extends is versioned
+func (r *Record) IsVersioned() bool {
+	return r.Versioned || (r.Base != nil && r.Base.IsVersioned())
+}
+
+// KeyField returns the field identifying the record: the one marked key,
+// else one named id. It is nil when the record is identified by the
+// implicit id, or not at all.
+func (r *Record) KeyField() *FieldDef {
+	for _, field := range r.AllFields() {
+		if field.Key {
+			return field
+		}
+	}
+	for _, field := range r.AllFields() {
+		if strings.EqualFold(field.Name, "id") {
+			return field
+		}
+	}
+	return nil
+}
+
+// HasImplicitID reports whether the record, or the record it extends, gets
+// the id generators add to records that neither say "no id" nor declare a
+// key field
+func (r *Record) HasImplicitID() bool {
+	if r.Base != nil {
+		return r.Base.HasImplicitID()
+	}
+	return !r.NoID && r.KeyField() == nil
 }
 
 // FieldDef for new record syntax
 type FieldDef struct {
-	Name     string    `json:"name"`
-	Type     *Type     `json:"type"`
-	Position *Position `json:"position,omitempty"`
+	Name     string     `json:"name"`
+	Type     *Type      `json:"type"`
+	Default  Expression `json:"default,omitempty"` // a literal, or "now" for the time of creation
+	Key      bool       `json:"key,omitempty"`     // identifies the record in place of the implicit id
+	JSONName string     `json:"-"`                 // set by NameJSON; see JSONKey
+	Leading  []*Comment `json:"leading_comments,omitempty"`
+	Trailing []*Comment `json:"trailing_comments,omitempty"`
+	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
+}
+
+// JSONKey is the field's key in JSON: as named by File.NameJSON, or the
+// lowercased name before it has run
+func (f *FieldDef) JSONKey() string {
+	if f.JSONName != "" {
+		return f.JSONName
+	}
+	return strings.ToLower(f.Name)
 }
 
 // TypeDef for custom type definitions
@@ -56,18 +338,237 @@ type TypeDef struct {
 	BaseType   *Type                  `json:"base_type"`
 	Validation map[string]interface{} `json:"validation,omitempty"`
 	Why        string                 `json:"why,omitempty"`
+	Whys       map[string]string      `json:"whys,omitempty"` // translations by locale
+	Leading    []*Comment             `json:"leading_comments,omitempty"`
+	Trailing   []*Comment             `json:"trailing_comments,omitempty"`
 	Position   *Position              `json:"position,omitempty"`
+	End        *Position              `json:"end,omitempty"`
+}
+
+// Event is a message services publish and subscribe to, declared with
+// "define event": the record it carries and the channel it is sent on
+type Event struct {
+	Name     string            `json:"name"`
+	Payload  *Type             `json:"payload"`           // the record the event carries
+	Channel  string            `json:"channel,omitempty"` // empty means the default of ChannelName
+	Why      string            `json:"why,omitempty"`
+	Whys     map[string]string `json:"whys,omitempty"` // translations by locale
+	Leading  []*Comment        `json:"leading_comments,omitempty"`
+	Trailing []*Comment        `json:"trailing_comments,omitempty"`
+	Position *Position         `json:"position,omitempty"`
+	End      *Position         `json:"end,omitempty"`
+}
+
+// ChannelName is the address the event is sent on: the declared channel,
+// or the words of the event's name in lower case joined by dots, so
+// UserCreated is sent on user.created
+func (e *Event) ChannelName() string {
+	if e.Channel != "" {
+		return e.Channel
+	}
+	words := splitWords(e.Name)
+	for i, word := range words {
+		words[i] = strings.ToLower(word)
+	}
+	return strings.Join(words, ".")
+}
+
+// Role is a role callers may have, declared with "define role admin". What
+// it may do is given by allow rules.
+type Role struct {
+	Name     string            `json:"name"`
+	Why      string            `json:"why,omitempty"`
+	Whys     map[string]string `json:"whys,omitempty"` // translations by locale
+	Leading  []*Comment        `json:"leading_comments,omitempty"`
+	Trailing []*Comment        `json:"trailing_comments,omitempty"`
+	Position *Position         `json:"position,omitempty"`
+	End      *Position         `json:"end,omitempty"`
+}
+
+// Allow lets a role perform actions on a record:
+//
+//	allow support to read, update Order
+type Allow struct {
+	Role     string     `json:"role"`
+	Actions  []string   `json:"actions"`
+	Resource string     `json:"resource"` // the record acted on
+	Leading  []*Comment `json:"leading_comments,omitempty"`
+	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
+}
+
+// Schedule runs a function of the file at an interval while the server
+// runs:
+//
+//	schedule cleanupSessions every 1 hour
+//	    why: "Expired sessions are removed"
+type Schedule struct {
+	Function string            `json:"function"`
+	Every    int64             `json:"every"`
+	Unit     string            `json:"unit"` // one of ScheduleUnits, in the singular
+	Why      string            `json:"why,omitempty"`
+	Whys     map[string]string `json:"whys,omitempty"` // translations by locale
+	Leading  []*Comment        `json:"leading_comments,omitempty"`
+	Trailing []*Comment        `json:"trailing_comments,omitempty"`
+	Position *Position         `json:"position,omitempty"`
+	End      *Position         `json:"end,omitempty"`
+}
+
+// ScheduleUnits are the units a schedule's interval is given in, and their
+// lengths
+var ScheduleUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// Interval is how long the schedule waits between runs
+func (s *Schedule) Interval() time.Duration {
+	return time.Duration(s.Every) * ScheduleUnits[s.Unit]
+}
+
+// Describe is the interval as it is written, such as "every 2 hours"
+func (s *Schedule) Describe() string {
+	if s.Every == 1 {
+		return "every 1 " + s.Unit
+	}
+	return fmt.Sprintf("every %d %ss", s.Every, s.Unit)
+}
+
+// Queue holds tasks for workers to handle, each a record of the file:
+//
+//	queue emailQueue handles SendEmailTask
+//	    why: "Mail is sent outside the request"
+type Queue struct {
+	Name     string            `json:"name"`
+	Payload  string            `json:"payload"` // the record each task is
+	Why      string            `json:"why,omitempty"`
+	Whys     map[string]string `json:"whys,omitempty"` // translations by locale
+	Leading  []*Comment        `json:"leading_comments,omitempty"`
+	Trailing []*Comment        `json:"trailing_comments,omitempty"`
+	Position *Position         `json:"position,omitempty"`
+	End      *Position         `json:"end,omitempty"`
+}
+
+// Workflow runs functions of the file one after another, each step taking
+// the result of the one before:
+//
+//	workflow onboardUser:
+//	    step createAccount
+//	    then step sendWelcomeEmail on failure compensate deleteAccount
+//	    why: "New users get an account and a greeting"
+//
+// When a step fails, the workflow stops and undoes what was done.
+type Workflow struct {
+	Name     string            `json:"name"`
+	Steps    []*WorkflowStep   `json:"steps"`
+	Why      string            `json:"why,omitempty"`
+	Whys     map[string]string `json:"whys,omitempty"` // translations by locale
+	Leading  []*Comment        `json:"leading_comments,omitempty"`
+	Trailing []*Comment        `json:"trailing_comments,omitempty"`
+	Position *Position         `json:"position,omitempty"`
+	End      *Position         `json:"end,omitempty"`
+}
+
+// WorkflowStep is a function a workflow calls. Compensate, when set, is
+// the function undoing the steps before this one; from this step on, a
+// failure runs it, after the compensations of later steps.
+type WorkflowStep struct {
+	Function   string    `json:"function"`
+	Compensate string    `json:"compensate,omitempty"`
+	Position   *Position `json:"position,omitempty"`
+	End        *Position `json:"end,omitempty"`
+}
+
+// Config is a setting the program reads from an environment variable when
+// it starts:
+//
+//	config databaseUrl: url from env DATABASE_URL
+//	    why: "Orders are kept in PostgreSQL"
+type Config struct {
+	Name     string            `json:"name"`
+	Type     *Type             `json:"type"`
+	Default  Expression        `json:"default,omitempty"` // a literal used when the variable is unset
+	Env      string            `json:"env,omitempty"`     // empty means the default of EnvName
+	Why      string            `json:"why,omitempty"`
+	Whys     map[string]string `json:"whys,omitempty"` // translations by locale
+	Leading  []*Comment        `json:"leading_comments,omitempty"`
+	Trailing []*Comment        `json:"trailing_comments,omitempty"`
+	Position *Position         `json:"position,omitempty"`
+	End      *Position         `json:"end,omitempty"`
+}
+
+// EnvName is the environment variable the setting is read from: the
+// declared one, or the words of the setting's name in upper case joined by
+// underscores, so databaseUrl is read from DATABASE_URL
+func (c *Config) EnvName() string {
+	if c.Env != "" {
+		return c.Env
+	}
+	return strings.ToUpper(JSONName(c.Name, JSONSnake))
+}
+
+// Required reports whether the program cannot start without the variable:
+// the setting is neither optional nor defaulted
+func (c *Config) Required() bool {
+	return !c.Type.Optional && c.Default == nil
 }
 
 // Enhanced Function with AI annotations
 type Function struct {
-	Name          string          `json:"name"`
-	Parameters    []*Parameter    `json:"parameters"`
-	ReturnType    *Type           `json:"return_type,omitempty"`
-	Why           string          `json:"why"`
-	AIAnnotations []*AIAnnotation `json:"ai_annotations,omitempty"`
-	Body          *FunctionBody   `json:"body"`
-	Position      *Position       `json:"position,omitempty"`
+	Name          string            `json:"name"`
+	Parameters    []*Parameter      `json:"parameters"`
+	ReturnType    *Type             `json:"return_type,omitempty"`
+	Headers       []*HeaderDecl     `json:"headers,omitempty"`
+	Access        *Access           `json:"access,omitempty"` // from requires clauses; nil means anyone may call it
+	Why           string            `json:"why"`
+	Whys          map[string]string `json:"whys,omitempty"` // translations by locale, from why.<locale>
+	AIAnnotations []*AIAnnotation   `json:"ai_annotations,omitempty"`
+	Body          *FunctionBody     `json:"body"`
+	Leading       []*Comment        `json:"leading_comments,omitempty"`
+	Trailing      []*Comment        `json:"trailing_comments,omitempty"`
+	Position      *Position         `json:"position,omitempty"`
+	End           *Position         `json:"end,omitempty"`
+}
+
+// Access is who may call a function, from its requires clauses:
+// "requires auth" admits any authenticated caller and each
+// "requires role admin" names a role, any of which admits one
+type Access struct {
+	Roles    []string  `json:"roles,omitempty"`
+	Position *Position `json:"position,omitempty"`
+	End      *Position `json:"end,omitempty"`
+}
+
+// Header directions
+const (
+	HeaderRequired = "required" // the request must carry it
+	HeaderOptional = "optional" // the request may carry it
+	HeaderEmitted  = "emitted"  // the response carries it
+)
+
+// HeaderDecl declares an HTTP header a function reads or sets, e.g.
+// "header: X-Tenant-ID required"
+type HeaderDecl struct {
+	Name      string    `json:"name"`
+	Direction string    `json:"direction"`
+	Position  *Position `json:"position,omitempty"`
+	End       *Position `json:"end,omitempty"`
+}
+
+// Variable is the name a request header is bound to in the function body:
+// X-Tenant-ID becomes xTenantID
+func (h *HeaderDecl) Variable() string {
+	var b strings.Builder
+	for i, part := range strings.Split(h.Name, "-") {
+		if i == 0 {
+			b.WriteString(strings.ToLower(part))
+		} else if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
 }
 
 // AI Annotations for collaborative programming
@@ -75,6 +576,7 @@ type AIAnnotation struct {
 	Type     string    `json:"type"` // "feedback", "suggests", "security", "performance"
 	Content  string    `json:"content"`
 	Position *Position `json:"position,omitempty"`
+	End      *Position `json:"end,omitempty"`
 }
 
 // Enhanced FunctionBody with rich statements
@@ -82,12 +584,14 @@ type FunctionBody struct {
 	Statements   []Statement    `json:"statements"`
 	NativeBlocks []*NativeBlock `json:"native_blocks,omitempty"`
 	Position     *Position      `json:"position,omitempty"`
+	End          *Position      `json:"end,omitempty"`
 }
 
 // Statement interface for all statement types
 type Statement interface {
 	StatementType() string
 	GetPosition() *Position
+	GetEnd() *Position
 }
 
 // IfStatement for conditional logic
@@ -96,98 +600,230 @@ type IfStatement struct {
 	ThenStmt  Statement  `json:"then_stmt"`
 	ElseStmt  Statement  `json:"else_stmt,omitempty"`
 	Position  *Position  `json:"position,omitempty"`
+	End       *Position  `json:"end,omitempty"`
 }
 
 func (s *IfStatement) StatementType() string  { return "if" }
 func (s *IfStatement) GetPosition() *Position { return s.Position }
+func (s *IfStatement) GetEnd() *Position      { return s.End }
 
 // ReturnStatement
 type ReturnStatement struct {
 	Value    Expression `json:"value,omitempty"`
 	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
 }
 
 func (s *ReturnStatement) StatementType() string  { return "return" }
 func (s *ReturnStatement) GetPosition() *Position { return s.Position }
+func (s *ReturnStatement) GetEnd() *Position      { return s.End }
 
 // AssignStatement for variable assignments
 type AssignStatement struct {
 	Variable string     `json:"variable"`
 	Value    Expression `json:"value"`
 	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
 }
 
 func (s *AssignStatement) StatementType() string  { return "assign" }
 func (s *AssignStatement) GetPosition() *Position { return s.Position }
+func (s *AssignStatement) GetEnd() *Position      { return s.End }
 
 // CreateStatement for "create user with:" syntax
 type CreateStatement struct {
 	TypeName    string             `json:"type_name"`
 	Assignments []*FieldAssignment `json:"assignments"`
 	Position    *Position          `json:"position,omitempty"`
+	End         *Position          `json:"end,omitempty"`
 }
 
 func (s *CreateStatement) StatementType() string  { return "create" }
 func (s *CreateStatement) GetPosition() *Position { return s.Position }
+func (s *CreateStatement) GetEnd() *Position      { return s.End }
 
 // FieldAssignment for create statements
 type FieldAssignment struct {
 	Field    string     `json:"field"`
 	Value    Expression `json:"value"`
 	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
 }
 
-// FailStatement for explicit failures
+// Error codes of fail statements, which the generated APIs report to
+// callers: "fail not_found \"Order not found\""
+const (
+	FailBadRequest   = "bad_request"  // the request is malformed
+	FailUnauthorized = "unauthorized" // the caller is not authenticated
+	FailForbidden    = "forbidden"    // the caller may not do this
+	FailNotFound     = "not_found"    // something the request names does not exist
+	FailConflict     = "conflict"     // the request clashes with the current state
+	FailInvalid      = "invalid"      // the input breaks a rule, the default
+	FailInternal     = "internal"     // the function itself went wrong
+)
+
+// FailCodes are the codes a fail statement may give
+var FailCodes = []string{FailBadRequest, FailUnauthorized, FailForbidden, FailNotFound, FailConflict, FailInvalid, FailInternal}
+
+// FailStatement for explicit failures. Code is one of FailCodes, or empty
+// for FailInvalid.
 type FailStatement struct {
+	Code     string    `json:"code,omitempty"`
 	Message  string    `json:"message"`
 	Position *Position `json:"position,omitempty"`
+	End      *Position `json:"end,omitempty"`
+}
+
+// ErrorCode is the code the failure is reported with
+func (s *FailStatement) ErrorCode() string {
+	if s.Code == "" {
+		return FailInvalid
+	}
+	return s.Code
 }
 
 func (s *FailStatement) StatementType() string  { return "fail" }
 func (s *FailStatement) GetPosition() *Position { return s.Position }
+func (s *FailStatement) GetEnd() *Position      { return s.End }
+
+// ExpectStatement asserts something in a test. "expect X" wants X to be
+// true, "expect X is Y" wants it to equal Y, and "expect X fails" wants
+// evaluating X to fail, with Message when one is given.
+type ExpectStatement struct {
+	Value    Expression `json:"value"`
+	Expected Expression `json:"expected,omitempty"`
+	Fails    bool       `json:"fails,omitempty"`
+	Message  string     `json:"message,omitempty"`
+	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
+}
+
+func (s *ExpectStatement) StatementType() string  { return "expect" }
+func (s *ExpectStatement) GetPosition() *Position { return s.Position }
+func (s *ExpectStatement) GetEnd() *Position      { return s.End }
+
+// Test is a named check of a file's functions, run by cloudpact test:
+//
+//	test "rejects empty name":
+//	    create User with:
+//	        name = ""
+//	    expect validateUser(user) is false
+type Test struct {
+	Name       string      `json:"name"`
+	Statements []Statement `json:"statements"`
+	Leading    []*Comment  `json:"leading_comments,omitempty"`
+	Position   *Position   `json:"position,omitempty"`
+	End        *Position   `json:"end,omitempty"`
+}
+
+// Seed asks cloudpact db seed for sample rows of a record: Count of them,
+// the literal Rows first and generated ones after. Each row reads like a
+// record example:
+//
+//	seed Customer: 20 records
+//	    row:
+//	        email: "ada@example.com"
+//	        name: "Ada"
+type Seed struct {
+	Record   string     `json:"record"`
+	Count    int        `json:"count"`
+	Rows     []*Example `json:"rows,omitempty"`
+	Leading  []*Comment `json:"leading_comments,omitempty"`
+	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
+}
 
 // Legacy types for backward compatibility
 type Model struct {
-	Name     string    `json:"name"`
-	Fields   []*Field  `json:"fields"`
-	Position *Position `json:"position,omitempty"`
+	Name     string     `json:"name"`
+	Fields   []*Field   `json:"fields"`
+	Leading  []*Comment `json:"leading_comments,omitempty"`
+	Trailing []*Comment `json:"trailing_comments,omitempty"`
+	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
 }
 
 type Field struct {
 	Name         string        `json:"name"`
 	Type         *Type         `json:"type"`
 	Relationship *Relationship `json:"relationship,omitempty"`
+	JSONName     string        `json:"-"` // set by NameJSON; see JSONKey
+	Leading      []*Comment    `json:"leading_comments,omitempty"`
+	Trailing     []*Comment    `json:"trailing_comments,omitempty"`
 	Position     *Position     `json:"position,omitempty"`
+	End          *Position     `json:"end,omitempty"`
+}
+
+// JSONKey is the field's key in JSON: as named by File.NameJSON, or the
+// lowercased name before it has run
+func (f *Field) JSONKey() string {
+	if f.JSONName != "" {
+		return f.JSONName
+	}
+	return strings.ToLower(f.Name)
 }
 
 type Type struct {
 	Name        string                 `json:"name"`
+	Alias       string                 `json:"alias,omitempty"` // Resolved by the analyzer: the custom type Name was declared as
 	Constraints map[string]interface{} `json:"constraints,omitempty"`
+	Optional    bool                   `json:"optional,omitempty"` // Field may be absent ("address: Address optional")
+	Nullable    bool                   `json:"nullable,omitempty"` // Value may be none ("maybe Address"); implies Optional
+	Element     *Type                  `json:"element,omitempty"`  // Item type of "list[User]"
+	Key         *Type                  `json:"key,omitempty"`      // Key type of "map of text to int"
+	Value       *Type                  `json:"value,omitempty"`    // Value type of "map of text to int"
 	Position    *Position              `json:"position,omitempty"`
+	End         *Position              `json:"end,omitempty"`
+}
+
+// Field constraints, written after the type: "age: int min 0 max 150",
+// "name: text maxlength 80". Values are int64 or float64 unless noted.
+const (
+	ConstraintMin       = "min"       // smallest allowed number
+	ConstraintMax       = "max"       // largest allowed number
+	ConstraintMinLength = "minlength" // fewest characters in text
+	ConstraintMaxLength = "maxlength" // most characters in text
+	ConstraintDomain    = "domain"    // required domain of an email address, a string
+)
+
+// IsConstraint reports whether word names a field constraint
+func IsConstraint(word string) bool {
+	switch word {
+	case ConstraintMin, ConstraintMax, ConstraintMinLength, ConstraintMaxLength, ConstraintDomain:
+		return true
+	}
+	return false
 }
 
 type Relationship struct {
 	Kind     string    `json:"kind"`
 	Target   string    `json:"target"`
 	Position *Position `json:"position,omitempty"`
+	End      *Position `json:"end,omitempty"`
 }
 
 type Parameter struct {
 	Name     string    `json:"name"`
 	Type     *Type     `json:"type"`
 	Position *Position `json:"position,omitempty"`
+	End      *Position `json:"end,omitempty"`
 }
 
 type NativeBlock struct {
 	Language string    `json:"language"`
 	Code     string    `json:"code"`
 	Position *Position `json:"position,omitempty"`
+	End      *Position `json:"end,omitempty"`
 }
 
 type Assignment struct {
 	TypeName   string                 `json:"type_name"`
 	BaseType   *Type                  `json:"base_type"`
 	Why        string                 `json:"why,omitempty"`
+	Whys       map[string]string      `json:"whys,omitempty"` // translations by locale
 	Validation map[string]interface{} `json:"validation,omitempty"`
+	Leading    []*Comment             `json:"leading_comments,omitempty"`
+	Trailing   []*Comment             `json:"trailing_comments,omitempty"`
 	Position   *Position              `json:"position,omitempty"`
+	End        *Position              `json:"end,omitempty"`
 }
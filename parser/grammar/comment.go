@@ -0,0 +1,95 @@
+package grammar
+
+import (
+	"strings"
+	"text/scanner"
+)
+
+// Comment is a // or /* */ comment. The parser attaches comments to the
+// declaration they document: the group directly above it, with no blank
+// line in between, is its Leading group, and any after the code on its
+// first line are Trailing. File.Comments keeps every comment, attached or
+// not.
+type Comment struct {
+	Text     string    `json:"text"` // including the // or /* */ markers
+	Position *Position `json:"position,omitempty"`
+	End      *Position `json:"end,omitempty"`
+
+	afterCode bool // follows a token on the same line
+}
+
+// CommentLines returns the text of comments without their markers, one
+// entry per line, for rendering as documentation
+func CommentLines(comments []*Comment) []string {
+	var lines []string
+	for _, c := range comments {
+		text := c.Text
+		if strings.HasPrefix(text, "//") {
+			lines = append(lines, strings.TrimSpace(strings.TrimPrefix(text, "//")))
+			continue
+		}
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/")
+		for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+			line = strings.TrimSpace(line)
+			// Block comments often start continuation lines with '*'
+			line = strings.TrimSpace(strings.TrimPrefix(line, "*"))
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// scanComments consumes the comments at the current token, so the grammar
+// never sees them
+func (p *parser) scanComments() {
+	for p.tok == scanner.Comment {
+		end := p.tokenEnd()
+		c := &Comment{
+			Text:      p.scanner.TokenText(),
+			Position:  p.position(),
+			End:       &end,
+			afterCode: p.prevLine > 0 && p.scanner.Position.Line == p.prevLine,
+		}
+		p.comments = append(p.comments, c)
+		p.pending = append(p.pending, c)
+		p.tok = p.scanner.Scan()
+	}
+}
+
+// leadingComments claims the pending comments that document a declaration
+// starting at pos. Comments before them belong to nothing and are dropped.
+func (p *parser) leadingComments(pos *Position) []*Comment {
+	pending := p.pending
+	p.pending = nil
+
+	start := len(pending)
+	line := pos.Line
+	for start > 0 {
+		c := pending[start-1]
+		if c.afterCode || c.End.Line < line-1 || c.Position.Offset > pos.Offset {
+			break
+		}
+		start--
+		line = c.Position.Line
+	}
+	if start == len(pending) {
+		return nil
+	}
+	return pending[start:]
+}
+
+// trailingComments claims the pending comments on the line a declaration
+// ends on
+func (p *parser) trailingComments(end *Position) []*Comment {
+	var claimed []*Comment
+	kept := p.pending[:0]
+	for _, c := range p.pending {
+		if c.afterCode && c.Position.Line == end.Line {
+			claimed = append(claimed, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	p.pending = kept
+	return claimed
+}
@@ -0,0 +1,99 @@
+package grammar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity says whether a diagnostic stops the build
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic codes group problems so editors and scripts can filter them
+const (
+	CodeSyntax         = "syntax"          // source does not follow the grammar
+	CodeInvalidLiteral = "invalid-literal" // malformed string or number
+	CodeUnknownKeyword = "unknown-keyword" // a word where one of a fixed set was expected
+	CodeDuplicate      = "duplicate"       // something declared twice
+	CodeType           = "type"            // a value of the wrong type
+	CodeUnknownField   = "unknown-field"   // a record has no such field
+	CodeOptional       = "optional"        // misuse of an optional value
+	CodeRounding       = "rounding"        // a rounding mode where it does not apply
+	CodeConstraint     = "constraint"      // a field constraint that does not fit its type
+	CodeIdentity       = "identity"        // a record's id or key field that cannot apply
+	CodeGoBuild        = "go-build"        // generated Go that does not compile
+	CodeGoVet          = "go-vet"          // a problem go vet finds in generated Go
+)
+
+// Range is the span of source a diagnostic is about. End is the position
+// just past it; it equals Start when only the start is known.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Diagnostic is a problem found in CloudPact source by the parser or the
+// analyzer. It is also an error, worded as the message followed by its
+// position.
+type Diagnostic struct {
+	Severity   Severity `json:"severity"`
+	Code       string   `json:"code"`
+	Message    string   `json:"message"`
+	Range      Range    `json:"range"`
+	Suggestion string   `json:"suggestion,omitempty"`
+}
+
+// NewDiagnostic returns an error diagnostic at pos, which may be nil
+func NewDiagnostic(code string, pos *Position, format string, args ...interface{}) *Diagnostic {
+	d := &Diagnostic{Severity: SeverityError, Code: code, Message: fmt.Sprintf(format, args...)}
+	if pos != nil {
+		d.Range = Range{Start: *pos, End: *pos}
+	}
+	return d
+}
+
+// Until extends the diagnostic to end, the end of the node it is about,
+// when that is known
+func (d *Diagnostic) Until(end *Position) *Diagnostic {
+	if end != nil && d.Range.Start.Line > 0 {
+		d.Range.End = *end
+	}
+	return d
+}
+
+// Suggest sets the fix a tool can offer alongside the diagnostic
+func (d *Diagnostic) Suggest(format string, args ...interface{}) *Diagnostic {
+	d.Suggestion = fmt.Sprintf(format, args...)
+	return d
+}
+
+func (d *Diagnostic) Error() string {
+	if d.Range.Start.Line == 0 {
+		return d.Message
+	}
+	return fmt.Sprintf("%s at %s", d.Message, d.Range.Start)
+}
+
+// Format renders the diagnostic for a terminal, compiler style:
+//
+//	user.cp:5:1: error[syntax]: expected ')', got "do"
+//	    suggestion: ...
+func (d *Diagnostic) Format() string {
+	var b strings.Builder
+	if start := d.Range.Start; start.Line > 0 {
+		file := start.File
+		if file == "" {
+			file = "<input>"
+		}
+		fmt.Fprintf(&b, "%s:%d:%d: ", file, start.Line, start.Column)
+	}
+	fmt.Fprintf(&b, "%s[%s]: %s", d.Severity, d.Code, d.Message)
+	if d.Suggestion != "" {
+		fmt.Fprintf(&b, "\n    suggestion: %s", d.Suggestion)
+	}
+	return b.String()
+}
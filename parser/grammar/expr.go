@@ -2,29 +2,53 @@
 // expr.go defines expression-related AST nodes used by the parser.
 package grammar
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // Expression interface
 type Expression interface {
 	ExpressionType() string
 	GetPosition() *Position
+	GetEnd() *Position
 }
 
 // IdentifierExpression
 type IdentifierExpression struct {
 	Name     string    `json:"name"`
+	Element  bool      `json:"element,omitempty"` // Resolved by the analyzer: names a field of the current query item
+	Type     *Type     `json:"type,omitempty"`    // Resolved by the analyzer
 	Position *Position `json:"position,omitempty"`
+	End      *Position `json:"end,omitempty"`
 }
 
 func (e *IdentifierExpression) ExpressionType() string { return "identifier" }
 func (e *IdentifierExpression) GetPosition() *Position { return e.Position }
+func (e *IdentifierExpression) GetEnd() *Position      { return e.End }
+
+// Literal kinds. Value holds a string, int64, float64 or bool respectively,
+// and nil for none.
+const (
+	LiteralString = "string"
+	LiteralInt    = "int"
+	LiteralFloat  = "float"
+	LiteralBool   = "bool"
+	LiteralNone   = "none"
+)
 
-// LiteralExpression
+// LiteralExpression is a constant such as "hi", 42, 1.5, true or none
 type LiteralExpression struct {
+	Kind     string      `json:"kind"`
 	Value    interface{} `json:"value"`
 	Position *Position   `json:"position,omitempty"`
+	End      *Position   `json:"end,omitempty"`
 }
 
 func (e *LiteralExpression) ExpressionType() string { return "literal" }
 func (e *LiteralExpression) GetPosition() *Position { return e.Position }
+func (e *LiteralExpression) GetEnd() *Position      { return e.End }
 
 // BinaryExpression for operations like "user.age < 18"
 type BinaryExpression struct {
@@ -32,27 +56,175 @@ type BinaryExpression struct {
 	Operator string     `json:"operator"`
 	Right    Expression `json:"right"`
 	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
 }
 
 func (e *BinaryExpression) ExpressionType() string { return "binary" }
 func (e *BinaryExpression) GetPosition() *Position { return e.Position }
+func (e *BinaryExpression) GetEnd() *Position      { return e.End }
+
+// EmptyExpression for "name is empty" and "tags is not empty": text without
+// characters, or a list or map without entries
+type EmptyExpression struct {
+	Value    Expression `json:"value"`
+	Negated  bool       `json:"negated,omitempty"` // "is not empty"
+	Type     *Type      `json:"type,omitempty"`    // Resolved by the analyzer: the type of Value
+	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
+}
+
+func (e *EmptyExpression) ExpressionType() string { return "empty" }
+func (e *EmptyExpression) GetPosition() *Position { return e.Position }
+func (e *EmptyExpression) GetEnd() *Position      { return e.End }
 
-// CallExpression for function calls
+// CallExpression for function calls. A method-style call such as
+// "order.discount(10)" is a call of discount whose first argument is order.
 type CallExpression struct {
 	Function  string       `json:"function"`
 	Arguments []Expression `json:"arguments"`
+	Method    bool         `json:"method,omitempty"` // written as a call on Arguments[0]
 	Position  *Position    `json:"position,omitempty"`
+	End       *Position    `json:"end,omitempty"`
 }
 
 func (e *CallExpression) ExpressionType() string { return "call" }
 func (e *CallExpression) GetPosition() *Position { return e.Position }
+func (e *CallExpression) GetEnd() *Position      { return e.End }
 
-// MemberExpression for "user.email"
+// MemberExpression for "user.email" and safe access "user?.address"
 type MemberExpression struct {
 	Object   Expression `json:"object"`
 	Property string     `json:"property"`
+	Safe     bool       `json:"safe,omitempty"`
+	Type     *Type      `json:"type,omitempty"` // Resolved by the analyzer: the declared type of Property
 	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
 }
 
 func (e *MemberExpression) ExpressionType() string { return "member" }
 func (e *MemberExpression) GetPosition() *Position { return e.Position }
+func (e *MemberExpression) GetEnd() *Position      { return e.End }
+
+// DefaultExpression for "value or fallback", used when value may be missing
+type DefaultExpression struct {
+	Value    Expression `json:"value"`
+	Fallback Expression `json:"fallback"`
+	Type     *Type      `json:"type,omitempty"` // Resolved by the analyzer
+	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
+}
+
+func (e *DefaultExpression) ExpressionType() string { return "default" }
+func (e *DefaultExpression) GetPosition() *Position { return e.Position }
+func (e *DefaultExpression) GetEnd() *Position      { return e.End }
+
+// ConditionalExpression for "value if condition else other"
+type ConditionalExpression struct {
+	Condition Expression `json:"condition"`
+	Then      Expression `json:"then"`
+	Else      Expression `json:"else"`
+	Type      *Type      `json:"type,omitempty"` // Resolved by the analyzer
+	Position  *Position  `json:"position,omitempty"`
+	End       *Position  `json:"end,omitempty"`
+}
+
+func (e *ConditionalExpression) ExpressionType() string { return "conditional" }
+func (e *ConditionalExpression) GetPosition() *Position { return e.Position }
+func (e *ConditionalExpression) GetEnd() *Position      { return e.End }
+
+// QueryExpression for collection transforms like "users where age >= 18 select email".
+// Inside Filter and Select, bare field names refer to the current item.
+type QueryExpression struct {
+	Source   Expression `json:"source"`
+	Filter   Expression `json:"filter,omitempty"`
+	Select   Expression `json:"select,omitempty"`
+	Type     *Type      `json:"type,omitempty"` // Resolved by the analyzer
+	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
+}
+
+func (e *QueryExpression) ExpressionType() string { return "query" }
+func (e *QueryExpression) GetPosition() *Position { return e.Position }
+func (e *QueryExpression) GetEnd() *Position      { return e.End }
+
+// Rounding modes for currency values
+const (
+	RoundBanker = "banker"  // half to even
+	RoundHalfUp = "half-up" // half away from zero
+)
+
+// AggregateExpression for "count of users", "sum of items.price" and "average of scores".
+// It also covers "length of name", which counts characters of text too, and
+// "first of orders where total > 100" and "last of events", which pick an item.
+type AggregateExpression struct {
+	Function string     `json:"function"` // count, sum, average, length, first or last
+	Source   Expression `json:"source"`
+	Rounding string     `json:"rounding,omitempty"` // Declared here or inherited from the summed field by the analyzer
+	Type     *Type      `json:"type,omitempty"`     // Resolved by the analyzer
+	Position *Position  `json:"position,omitempty"`
+	End      *Position  `json:"end,omitempty"`
+}
+
+func (e *AggregateExpression) ExpressionType() string { return "aggregate" }
+func (e *AggregateExpression) GetPosition() *Position { return e.Position }
+func (e *AggregateExpression) GetEnd() *Position      { return e.End }
+
+// FormatExpression renders an expression as CloudPact source
+func FormatExpression(expr Expression) string {
+	switch e := expr.(type) {
+	case nil:
+		return ""
+	case *IdentifierExpression:
+		return e.Name
+	case *LiteralExpression:
+		switch e.Kind {
+		case LiteralString:
+			return strconv.Quote(e.Value.(string))
+		case LiteralNone:
+			return "none"
+		}
+		return fmt.Sprint(e.Value)
+	case *BinaryExpression:
+		return FormatExpression(e.Left) + " " + e.Operator + " " + FormatExpression(e.Right)
+	case *EmptyExpression:
+		if e.Negated {
+			return FormatExpression(e.Value) + " is not empty"
+		}
+		return FormatExpression(e.Value) + " is empty"
+	case *CallExpression:
+		args := make([]string, len(e.Arguments))
+		for i, arg := range e.Arguments {
+			args[i] = FormatExpression(arg)
+		}
+		if e.Method && len(args) > 0 {
+			return args[0] + "." + e.Function + "(" + strings.Join(args[1:], ", ") + ")"
+		}
+		return e.Function + "(" + strings.Join(args, ", ") + ")"
+	case *MemberExpression:
+		sep := "."
+		if e.Safe {
+			sep = "?."
+		}
+		return FormatExpression(e.Object) + sep + e.Property
+	case *DefaultExpression:
+		return FormatExpression(e.Value) + " or " + FormatExpression(e.Fallback)
+	case *ConditionalExpression:
+		return FormatExpression(e.Then) + " if " + FormatExpression(e.Condition) + " else " + FormatExpression(e.Else)
+	case *QueryExpression:
+		text := FormatExpression(e.Source)
+		if e.Filter != nil {
+			text += " where " + FormatExpression(e.Filter)
+		}
+		if e.Select != nil {
+			text += " select " + FormatExpression(e.Select)
+		}
+		return text
+	case *AggregateExpression:
+		text := e.Function + " of " + FormatExpression(e.Source)
+		if e.Rounding != "" {
+			text += " round: " + e.Rounding
+		}
+		return text
+	}
+	return expr.ExpressionType()
+}
@@ -1,8 +1,11 @@
 package grammar
 
-
 import (
+	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Test parsing of a model declaration without fields.
@@ -26,7 +29,7 @@ func TestParseFieldsAndTypes(t *testing.T) {
 model User {
     id: Int
     name: String
-}
+}`
 	file, err := ParseString(src)
 	if err != nil {
 		t.Fatalf("parse error: %v", err)
@@ -58,18 +61,1504 @@ name: String
 		t.Fatalf("parse error: %v", err)
 	}
 
-	if len(file.Models) != 1 {
-		t.Fatalf("expected 1 model, got %d", len(file.Models))
+	if len(file.Records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(file.Records))
+	}
+	record := file.Records[0]
+	if len(record.Fields) != 2 {
+		t.Fatalf("expected 2 fields, got %d", len(record.Fields))
+	}
+	if record.Fields[0].Name != "id" || record.Fields[0].Type.Name != "Int" {
+		t.Fatalf("unexpected first field: %#v", record.Fields[0])
+	}
+	if record.Fields[1].Name != "name" || record.Fields[1].Type.Name != "String" {
+		t.Fatalf("unexpected second field: %#v", record.Fields[1])
+	}
+}
+
+// Test that parse failures carry the position of the offending token.
+func TestParseErrorPosition(t *testing.T) {
+	src := `define record User
+    name: text
+
+function broken(
+`
+	_, err := ParseWithFilename(strings.NewReader(src), "user.cp")
+	if err == nil {
+		t.Fatal("expected parse error")
+	}
+	var d *Diagnostic
+	if !errors.As(err, &d) {
+		t.Fatalf("expected *Diagnostic, got %T", err)
+	}
+	if d.Range.Start.File != "user.cp" || d.Range.Start.Line != 5 {
+		t.Fatalf("unexpected error position: %s", d.Range.Start)
+	}
+}
+
+// Test that diagnostics carry a code, the range of the offending token and
+// a suggestion, and format like compiler output.
+func TestDiagnostic(t *testing.T) {
+	src := `define record Price
+    amount: currency round: nearest
+`
+	_, err := ParseWithFilename(strings.NewReader(src), "price.cp")
+	var d *Diagnostic
+	if !errors.As(err, &d) {
+		t.Fatalf("expected *Diagnostic, got %T (%v)", err, err)
+	}
+	if d.Code != CodeUnknownKeyword || d.Severity != SeverityError {
+		t.Fatalf("unexpected code %q or severity %q", d.Code, d.Severity)
+	}
+	if d.Range.Start.Line != 2 || d.Range.Start.Column != 29 || d.Range.End.Column != 36 {
+		t.Fatalf("unexpected range %s to %s", d.Range.Start, d.Range.End)
+	}
+	if d.Suggestion == "" {
+		t.Fatal("expected a suggestion")
+	}
+	if err.Error() != `unknown rounding mode "nearest" (expected "banker" or "half-up") at price.cp:2:29` {
+		t.Fatalf("unexpected error text: %s", err)
+	}
+	if want := `price.cp:2:29: error[unknown-keyword]: unknown rounding mode`; !strings.HasPrefix(d.Format(), want) {
+		t.Fatalf("unexpected format:\n%s", d.Format())
+	}
+	if !strings.Contains(d.Format(), "\n    suggestion: use round: banker") {
+		t.Fatalf("missing suggestion:\n%s", d.Format())
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"code":"unknown-keyword"`) || !strings.Contains(string(data), `"start":{"line":2,"column":29`) {
+		t.Fatalf("unexpected JSON: %s", data)
+	}
+}
+
+// Test parsing of optional fields, safe member chains and the 'or' fallback.
+func TestParseSafeAccessAndDefault(t *testing.T) {
+	src := `define record User
+    address: Address optional
+
+function zipOf(user: User) returns text
+    why: "Reads the zip code when an address is known"
+    do:
+        return user?.address?.zip or "unknown"`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if !file.Records[0].Fields[0].Type.Optional {
+		t.Fatal("expected address to be optional")
+	}
+
+	ret := file.Functions[0].Body.Statements[0].(*ReturnStatement)
+	def, ok := ret.Value.(*DefaultExpression)
+	if !ok {
+		t.Fatalf("expected default expression, got %T", ret.Value)
+	}
+	zip, ok := def.Value.(*MemberExpression)
+	if !ok || zip.Property != "zip" || !zip.Safe {
+		t.Fatalf("unexpected member expression: %#v", def.Value)
+	}
+	address, ok := zip.Object.(*MemberExpression)
+	if !ok || address.Property != "address" || !address.Safe {
+		t.Fatalf("unexpected nested member expression: %#v", zip.Object)
+	}
+	if lit, ok := def.Fallback.(*LiteralExpression); !ok || lit.Value != "unknown" {
+		t.Fatalf("unexpected fallback: %#v", def.Fallback)
+	}
+}
+
+// Test that inline conditionals parse, and that an 'if' on the next line
+// still starts a new statement.
+func TestParseConditionalExpression(t *testing.T) {
+	src := `function shippingFee(premium: boolean, fee: number, waived: number) returns number
+    why: "Premium members ship free"
+    do:
+        set total = waived if premium else fee
+        if premium
+            then return waived
+        return total`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	stmts := file.Functions[0].Body.Statements
+	if len(stmts) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(stmts))
+	}
+	set := stmts[0].(*AssignStatement)
+	cond, ok := set.Value.(*ConditionalExpression)
+	if !ok {
+		t.Fatalf("expected conditional expression, got %T", set.Value)
+	}
+	if cond.Then.(*IdentifierExpression).Name != "waived" || cond.Else.(*IdentifierExpression).Name != "fee" {
+		t.Fatalf("unexpected branches: %#v", cond)
+	}
+	if _, ok := stmts[1].(*IfStatement); !ok {
+		t.Fatalf("expected if statement, got %T", stmts[1])
+	}
+}
+
+func TestParseQueryExpression(t *testing.T) {
+	src := `function adultEmails(users: list[User]) returns list of email
+    why: "Collects addresses of users old enough to sign contracts"
+    do:
+        set adults = users where age >= 18
+        return adults select email`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	fn := file.Functions[0]
+	if param := fn.Parameters[0].Type; param.Name != "list" || param.Element == nil || param.Element.Name != "User" {
+		t.Fatalf("unexpected parameter type: %#v", param)
+	}
+	if fn.ReturnType.Element == nil || fn.ReturnType.Element.Name != "email" {
+		t.Fatalf("unexpected return type: %#v", fn.ReturnType)
+	}
+
+	set := fn.Body.Statements[0].(*AssignStatement)
+	query, ok := set.Value.(*QueryExpression)
+	if !ok {
+		t.Fatalf("expected query expression, got %T", set.Value)
+	}
+	filter, ok := query.Filter.(*BinaryExpression)
+	if !ok || filter.Operator != ">=" || query.Select != nil {
+		t.Fatalf("unexpected query: %#v", query)
+	}
+
+	ret := fn.Body.Statements[1].(*ReturnStatement)
+	if selected := ret.Value.(*QueryExpression); selected.Filter != nil || selected.Select.(*IdentifierExpression).Name != "email" {
+		t.Fatalf("unexpected select query: %#v", selected)
+	}
+}
+
+func TestParseAggregateExpression(t *testing.T) {
+	src := `function stats(items: list[Item]) returns boolean
+    why: "Summarizes an order"
+    do:
+        set total = sum of items.price
+        return count of items where price > 10 > 2`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	stmts := file.Functions[0].Body.Statements
+
+	sum, ok := stmts[0].(*AssignStatement).Value.(*AggregateExpression)
+	if !ok || sum.Function != "sum" {
+		t.Fatalf("expected sum aggregate, got %#v", stmts[0].(*AssignStatement).Value)
+	}
+	if member, ok := sum.Source.(*MemberExpression); !ok || member.Property != "price" {
+		t.Fatalf("unexpected sum operand: %#v", sum.Source)
+	}
+
+	// The where clause belongs to the aggregate and takes the comparison with it
+	count, ok := stmts[1].(*ReturnStatement).Value.(*AggregateExpression)
+	if !ok || count.Function != "count" {
+		t.Fatalf("expected count aggregate, got %#v", stmts[1].(*ReturnStatement).Value)
+	}
+	if query, ok := count.Source.(*QueryExpression); !ok || query.Filter == nil {
+		t.Fatalf("expected filtered count, got %#v", count.Source)
+	}
+}
+
+func TestParseRoundingModes(t *testing.T) {
+	src := `define record Item
+    price: usd_currency round: half-up
+    round: int
+
+function total(items: list[Item]) returns usd_currency
+    why: "Invoices use banker's rounding"
+    do:
+        return sum of items.price round: banker`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	fields := file.Records[0].Fields
+	if len(fields) != 2 || fields[1].Name != "round" {
+		t.Fatalf("expected a second field named round, got %#v", fields)
+	}
+	if mode := fields[0].Type.Constraints["round"]; mode != RoundHalfUp {
+		t.Fatalf("unexpected field rounding: %v", mode)
+	}
+	sum := file.Functions[0].Body.Statements[0].(*ReturnStatement).Value.(*AggregateExpression)
+	if sum.Rounding != RoundBanker {
+		t.Fatalf("unexpected aggregate rounding: %q", sum.Rounding)
+	}
+
+	if _, err := ParseString("define record Item\n    price: usd_currency round: ceiling\n"); err == nil ||
+		!strings.Contains(err.Error(), `unknown rounding mode "ceiling"`) {
+		t.Fatalf("expected unknown rounding mode error, got %v", err)
+	}
+}
+
+func TestParseVersionedRecord(t *testing.T) {
+	src := `define record Order versioned
+    total: number
+
+define record Flag
+    versioned: boolean`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if !file.Records[0].Versioned || len(file.Records[0].Fields) != 1 {
+		t.Fatalf("expected a versioned record with one field, got %#v", file.Records[0])
+	}
+	// On its own line "versioned" is an ordinary field
+	if file.Records[1].Versioned || file.Records[1].Fields[0].Name != "versioned" {
+		t.Fatalf("expected a field named versioned, got %#v", file.Records[1])
+	}
+}
+
+func TestParsePersistedRecord(t *testing.T) {
+	src := `define record Order persist versioned
+    total: number
+
+define record Receipt persist bucket
+    scan: text
+
+define record Flag
+    persist: boolean`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if file.Records[0].Persist != "table" || !file.Records[0].Versioned {
+		t.Fatalf("expected a versioned record persisted in a table, got %#v", file.Records[0])
+	}
+	if file.Records[1].Persist != "bucket" || len(file.Records[1].Fields) != 1 {
+		t.Fatalf("expected a record persisted in a bucket, got %#v", file.Records[1])
+	}
+	// On its own line "persist" is an ordinary field
+	if file.Records[2].Persist != "" || file.Records[2].Fields[0].Name != "persist" {
+		t.Fatalf("expected a field named persist, got %#v", file.Records[2])
+	}
+
+	if _, err := ParseString("define record Order persist persist bucket\n    total: number\n"); err == nil || !strings.Contains(err.Error(), "record Order is already persisted") {
+		t.Fatalf("expected an error for persist twice, got %v", err)
+	}
+}
+
+func TestParseRecordIdentity(t *testing.T) {
+	src := `define record Money no id
+    amount: number
+    currency: text
+
+define record Country
+    code: text maxlength 2 key
+    key: text`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	money, country := file.Records[0], file.Records[1]
+	if !money.NoID || money.HasImplicitID() || money.KeyField() != nil {
+		t.Fatalf("expected a record without an id, got %#v", money)
+	}
+	if country.HasImplicitID() || country.KeyField() != country.Fields[0] || country.Fields[0].Type.Constraints["maxlength"] == nil {
+		t.Fatalf("expected code to be the key, got %#v", country.Fields[0])
+	}
+	// Followed by ':' "key" is an ordinary field
+	if country.Fields[1].Name != "key" || country.Fields[1].Key {
+		t.Fatalf("expected a field named key, got %#v", country.Fields[1])
+	}
+
+	file, err = ParseString("define record Login\n    id: uuid\n    name: text\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if login := file.Records[0]; login.HasImplicitID() || login.KeyField() != login.Fields[0] {
+		t.Fatalf("expected the declared id to replace the implicit one, got %#v", login)
+	}
+
+	if _, err := ParseString("define record Money no key\n    amount: number\n"); err == nil || !strings.Contains(err.Error(), "expected id after no") {
+		t.Fatalf("expected an error for no key, got %v", err)
+	}
+}
+
+func TestJSONName(t *testing.T) {
+	for _, c := range []struct{ name, policy, want string }{
+		{"createdAt", "", "createdat"},
+		{"createdAt", JSONLower, "createdat"},
+		{"created_at", JSONCamel, "createdAt"},
+		{"userID", JSONCamel, "userId"},
+		{"HTTPServer", JSONCamel, "httpServer"},
+		{"createdAt", JSONSnake, "created_at"},
+		{"userID", JSONSnake, "user_id"},
+		{"address2Line", JSONSnake, "address2_line"},
+		{"created_at", JSONAsWritten, "created_at"},
+		{"createdAt", JSONAsWritten, "createdAt"},
+	} {
+		if got := JSONName(c.name, c.policy); got != c.want {
+			t.Errorf("JSONName(%q, %q) = %q, want %q", c.name, c.policy, got, c.want)
+		}
+	}
+
+	file, err := ParseString("define record User\n    createdAt: datetime\n\nmodel Tag {\n    display_name: text\n}\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if key := file.Records[0].Fields[0].JSONKey(); key != "createdat" {
+		t.Fatalf("expected lowercased keys before NameJSON, got %q", key)
+	}
+	file.NameJSON(JSONCamel)
+	if file.Records[0].Fields[0].JSONKey() != "createdAt" || file.Models[0].Fields[0].JSONKey() != "displayName" {
+		t.Fatalf("expected camelCase keys, got %q and %q", file.Records[0].Fields[0].JSONKey(), file.Models[0].Fields[0].JSONKey())
+	}
+}
+
+func TestParseHeaderDecls(t *testing.T) {
+	src := `function placeOrder(total: number)
+    header: X-Tenant-ID required
+    header: X-B3-TraceId
+    header: X-Request-ID emitted
+    why: "Orders belong to a tenant"
+    do:
+        return total`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	want := []struct{ name, direction, variable string }{
+		{"X-Tenant-ID", HeaderRequired, "xTenantID"},
+		{"X-B3-TraceId", HeaderOptional, "xB3TraceId"},
+		{"X-Request-ID", HeaderEmitted, "xRequestID"},
+	}
+	headers := file.Functions[0].Headers
+	if len(headers) != len(want) {
+		t.Fatalf("expected %d headers, got %#v", len(want), headers)
+	}
+	for i, w := range want {
+		if headers[i].Name != w.name || headers[i].Direction != w.direction || headers[i].Variable() != w.variable {
+			t.Fatalf("header %d: expected %v, got %#v (%s)", i, w, headers[i], headers[i].Variable())
+		}
+	}
+
+	src = "function f()\n    header: X-Tenant sometimes\n    why: \"x\"\n    do:\n        return 1"
+	if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), "unknown header direction") {
+		t.Fatalf("expected unknown direction error, got %v", err)
+	}
+}
+
+func TestParseRequires(t *testing.T) {
+	src := `function deleteOrder(id: uuid)
+    requires auth
+    header: X-Tenant-ID required
+    requires role admin
+    requires role support
+    why: "Only staff remove orders"
+    do:
+        return`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	access := file.Functions[0].Access
+	if access == nil || strings.Join(access.Roles, ",") != "admin,support" || access.Position == nil || access.Position.Line != 2 {
+		t.Fatalf("unexpected access: %#v", access)
+	}
+	if len(file.Functions[0].Headers) != 1 {
+		t.Fatalf("expected the header between requires clauses, got %#v", file.Functions[0].Headers)
+	}
+
+	// A bare return ends the body rather than taking the next function
+	file, _ = ParseString("function ping()\n    requires auth\n    why: \"x\"\n    do:\n        return\n\nfunction pong()\n    why: \"y\"\n    do:\n        return\n")
+	if len(file.Functions) != 2 {
+		t.Fatalf("expected 2 functions, got %d", len(file.Functions))
+	}
+	if access := file.Functions[0].Access; access == nil || len(access.Roles) != 0 {
+		t.Fatalf("expected auth without roles, got %#v", access)
+	}
+	file, _ = ParseString("function ping()\n    why: \"x\"\n    do:\n        return")
+	if file.Functions[0].Access != nil {
+		t.Fatalf("expected no access rule, got %#v", file.Functions[0].Access)
+	}
+
+	for src, want := range map[string]string{
+		"function f()\n    requires login\n    why: \"x\"\n    do:\n        return":                               `unknown requirement "login"`,
+		"function f()\n    requires role\n    why: \"x\"\n    do:\n        return":                                "expected a role name",
+		"function f()\n    requires role admin\n    requires role admin\n    why: \"x\"\n    do:\n        return": "role admin is required twice",
+	} {
+		if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestParseAIAnnotations(t *testing.T) {
+	src := `function calculateShipping(weight: number) returns number
+    ai-feedback: "Consider validating negative weights"
+    why: "Charges by weight"
+    ai-decision-accepted: "Rejects negative weights"
+    do:
+        return weight * 2`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	annotations := file.Functions[0].AIAnnotations
+	if len(annotations) != 2 || annotations[0].Type != "feedback" || annotations[1].Type != "decision-accepted" ||
+		annotations[1].Content != "Rejects negative weights" || annotations[1].Position.Line != 4 {
+		t.Fatalf("unexpected annotations %#v", annotations)
+	}
+
+	src = "function f()\n    ai-praise: \"x\"\n    why: \"x\"\n    do:\n        return 1"
+	if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), `unknown AI annotation "ai-praise"`) {
+		t.Fatalf("expected unknown annotation error, got %v", err)
+	}
+}
+
+func TestParseLocalizedWhy(t *testing.T) {
+	src := `function greet(name: text) returns text
+    why.en: "Greets a customer"
+    why.es: "Saluda a un cliente"
+    do:
+        return name`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	function := file.Functions[0]
+	if function.Why != "Greets a customer" || function.Whys["es"] != "Saluda a un cliente" || len(function.Whys) != 2 {
+		t.Fatalf("unexpected whys %q %v", function.Why, function.Whys)
+	}
+	file.Localize("es")
+	if function.Why != "Saluda a un cliente" {
+		t.Fatalf("expected the Spanish why, got %q", function.Why)
+	}
+	file.Localize("fr")
+	if function.Why != "Saluda a un cliente" {
+		t.Fatalf("a missing locale should keep the why, got %q", function.Why)
+	}
+
+	src = "function f()\n    why.es: \"a\"\n    why.es: \"b\"\n    do:\n        return 1"
+	if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), "why.es is declared twice") {
+		t.Fatalf("expected duplicate locale error, got %v", err)
+	}
+}
+
+func TestSetAnnotation(t *testing.T) {
+	src := "module shipping\n\nfunction cost(weight: number) returns number\n    why: \"Charges by weight\"\n    ai-feedback: \"old\"\n    do:\n        return weight * 2\n"
+
+	updated, err := SetAnnotation([]byte(src), "cost", "feedback", `Check "negative" weights`)
+	if err != nil {
+		t.Fatalf("SetAnnotation: %v", err)
+	}
+	want := strings.Replace(src, `ai-feedback: "old"`, `ai-feedback: "Check 'negative' weights"`, 1)
+	if string(updated) != want {
+		t.Fatalf("expected only the annotation line to change:\n%s", updated)
+	}
+
+	added, err := SetAnnotation(updated, "cost", "security", "No concerns")
+	if err != nil {
+		t.Fatalf("SetAnnotation: %v", err)
+	}
+	if !strings.Contains(string(added), "    ai-feedback: \"Check 'negative' weights\"\n    ai-security: \"No concerns\"\n    do:") {
+		t.Fatalf("expected a new annotation before do:\n%s", added)
+	}
+	file, err := ParseString(string(added))
+	if err != nil || len(file.Functions[0].AIAnnotations) != 2 {
+		t.Fatalf("expected both annotations to parse, got %v", err)
+	}
+
+	twice, _ := AddAnnotation(added, "cost", "decision-accepted", "a")
+	twice, _ = AddAnnotation(twice, "cost", "decision-accepted", "b")
+	if strings.Count(string(twice), "ai-decision-accepted") != 2 {
+		t.Fatalf("expected AddAnnotation to keep earlier decisions:\n%s", twice)
+	}
+
+	if _, err := SetAnnotation([]byte(src), "price", "feedback", "x"); err == nil {
+		t.Fatal("expected an error for a missing function")
+	}
+	if _, err := SetAnnotation([]byte(src), "cost", "praise", "x"); err == nil {
+		t.Fatal("expected an error for an unknown annotation")
+	}
+}
+
+func TestParseLiteralKinds(t *testing.T) {
+	src := `function sample() returns text
+    why: "Covers every literal kind"
+    do:
+        set greeting = "say \"hi\""
+        set count = 42
+        set ratio = 2.5
+        set active = true
+        return greeting`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	want := []struct {
+		kind  string
+		value interface{}
+	}{
+		{LiteralString, `say "hi"`},
+		{LiteralInt, int64(42)},
+		{LiteralFloat, 2.5},
+		{LiteralBool, true},
+	}
+	for i, w := range want {
+		lit, ok := file.Functions[0].Body.Statements[i].(*AssignStatement).Value.(*LiteralExpression)
+		if !ok || lit.Kind != w.kind || lit.Value != w.value {
+			t.Fatalf("statement %d: expected %s literal %#v, got %#v", i, w.kind, w.value, lit)
+		}
+	}
+}
+
+func TestParseExpressionAndStatement(t *testing.T) {
+	expr, err := ParseExpression(`order.total > 100`)
+	if err != nil {
+		t.Fatalf("ParseExpression: %v", err)
+	}
+	if binary, ok := expr.(*BinaryExpression); !ok || binary.Operator != ">" {
+		t.Fatalf("expected a comparison, got %#v", expr)
+	}
+	if _, err := ParseExpression(`total > 100 )`); err == nil {
+		t.Fatal("expected trailing input to be rejected")
+	}
+
+	stmt, err := ParseStatement(`if total < 0 then fail "total must not be negative"`)
+	if err != nil {
+		t.Fatalf("ParseStatement: %v", err)
+	}
+	if _, ok := stmt.(*IfStatement); !ok {
+		t.Fatalf("expected an if statement, got %#v", stmt)
+	}
+	if _, err := ParseStatement(`total`); err == nil {
+		t.Fatal("expected an expression to be rejected as a statement")
+	}
+	stmt, err = ParseStatement(`fail not_found "no such order"`)
+	if fail, ok := stmt.(*FailStatement); err != nil || !ok || fail.ErrorCode() != FailNotFound || fail.Message != "no such order" {
+		t.Fatalf("expected a not_found failure, got %#v, %v", stmt, err)
+	}
+	stmt, err = ParseStatement(`fail "name \"missing\""`)
+	if fail, ok := stmt.(*FailStatement); err != nil || !ok || fail.Message != `name "missing"` {
+		t.Fatalf("expected the message without its escapes, got %#v, %v", stmt, err)
+	}
+	stmt, _ = ParseStatement(`fail "empty order"`)
+	if fail := stmt.(*FailStatement); fail.Code != "" || fail.ErrorCode() != FailInvalid {
+		t.Fatalf("expected the default code, got %#v", fail)
+	}
+	if _, err := ParseStatement(`fail missing "no such order"`); err == nil || !strings.Contains(err.Error(), `unknown error code "missing"`) {
+		t.Fatalf("expected an unknown error code, got %v", err)
+	}
+	if !IsStatement("set x = 1") || IsStatement("discount(order)") {
+		t.Fatal("IsStatement misclassified its input")
+	}
+}
+
+func TestFormatExpression(t *testing.T) {
+	for _, src := range []string{
+		`order.total > 100`,
+		`user?.address?.zip or "00000"`,
+		`10 if big else 0`,
+		`count of orders where total > 100`,
+		`orders where active = true select email`,
+		`discount(order, 0.5)`,
+	} {
+		expr, err := ParseExpression(src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", src, err)
+		}
+		if got := FormatExpression(expr); got != src {
+			t.Errorf("FormatExpression(%q) = %q", src, got)
+		}
+	}
+}
+
+// Test that nodes record where they end, so their source can be recovered
+// from the start and end offsets.
+func TestParseEndPositions(t *testing.T) {
+	src := `define record Order
+    total: currency round: banker
+    note: text optional
+
+function discount(order: Order) returns currency
+    why: "Large orders get ten off"
+    do:
+        if order.total > 100 then return 10
+        return 0
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	span := func(start, end *Position) string {
+		t.Helper()
+		if start == nil || end == nil {
+			t.Fatalf("missing position: %v to %v", start, end)
+		}
+		return src[start.Offset:end.Offset]
+	}
+
+	record := file.Records[0]
+	if got := span(record.Position, record.End); got != "record Order\n    total: currency round: banker\n    note: text optional" {
+		t.Fatalf("unexpected record span %q", got)
+	}
+	note := record.Fields[1]
+	if got := span(note.Position, note.End); got != "note: text optional" {
+		t.Fatalf("unexpected field span %q", got)
+	}
+	if got := span(note.Type.Position, note.Type.End); got != "text optional" {
+		t.Fatalf("unexpected type span %q", got)
+	}
+
+	function := file.Functions[0]
+	if got := span(function.Position, function.End); !strings.HasPrefix(got, "function discount") || !strings.HasSuffix(got, "return 0") {
+		t.Fatalf("unexpected function span %q", got)
+	}
+	ifStmt := function.Body.Statements[0].(*IfStatement)
+	if got := span(ifStmt.GetPosition(), ifStmt.GetEnd()); got != "if order.total > 100 then return 10" {
+		t.Fatalf("unexpected statement span %q", got)
+	}
+	if got := span(ifStmt.Condition.GetPosition(), ifStmt.Condition.GetEnd()); got != "order.total > 100" {
+		t.Fatalf("unexpected condition span %q", got)
+	}
+	if end := ifStmt.Condition.GetEnd(); end.Line != 8 || end.Column != 29 {
+		t.Fatalf("unexpected condition end %s", end)
+	}
+}
+
+// Test that comments are kept and attached to the declarations they document.
+func TestParseComments(t *testing.T) {
+	src := `// User is someone who can sign in.
+// Emails are unique.
+define record User // stored in users
+    // shown on the profile page
+    name: text
+    email: email // verified at signup
+
+/* Greets a user
+ * by name. */
+function greet(user: User) returns text
+    why: "Welcomes the user"
+    do:
+        // a stray note
+        return user.name
+
+// orphaned
+
+function bye() returns text
+    why: "Says goodbye"
+    do:
+        return "bye"
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if len(file.Comments) != 8 {
+		t.Fatalf("expected 8 comments, got %d", len(file.Comments))
+	}
+
+	record := file.Records[0]
+	if got := CommentLines(record.Leading); strings.Join(got, "|") != "User is someone who can sign in.|Emails are unique." {
+		t.Fatalf("unexpected record comments %q", got)
+	}
+	if got := CommentLines(record.Trailing); len(got) != 1 || got[0] != "stored in users" {
+		t.Fatalf("unexpected record trailing comment %q", got)
+	}
+	if got := CommentLines(record.Fields[0].Leading); len(got) != 1 || got[0] != "shown on the profile page" {
+		t.Fatalf("unexpected field comment %q", got)
+	}
+	if got := CommentLines(record.Fields[1].Trailing); len(got) != 1 || got[0] != "verified at signup" {
+		t.Fatalf("unexpected field trailing comment %q", got)
+	}
+	if len(record.Fields[1].Leading) != 0 {
+		t.Fatalf("the previous field's comment leaked: %q", CommentLines(record.Fields[1].Leading))
+	}
+
+	greet, bye := file.Functions[0], file.Functions[1]
+	if got := CommentLines(greet.Leading); strings.Join(got, "|") != "Greets a user|by name." {
+		t.Fatalf("unexpected function comments %q", got)
+	}
+	if len(bye.Leading) != 0 {
+		t.Fatalf("comments separated by a blank line should not attach: %q", CommentLines(bye.Leading))
+	}
+	if len(greet.Body.Statements) != 1 {
+		t.Fatalf("comments should not become statements: %#v", greet.Body.Statements)
+	}
+	if end := greet.Leading[0].End; end.Line != 9 || end.Column != 15 {
+		t.Fatalf("unexpected block comment end %s", end)
+	}
+}
+
+// Test that statements and expressions name their node kind in JSON.
+func TestMarshalAST(t *testing.T) {
+	file, err := ParseString(`function hi(name: text) returns text
+    why: "Says hi"
+    do:
+        if name = "" then fail "no name"
+        return name`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"statements":[{"node":"if","condition":{"node":"binary","left":{"node":"identifier","name":"name"`,
+		`"right":{"node":"literal","kind":"string","value":""`,
+		`"then_stmt":{"node":"fail","message":"no name"`,
+		`{"node":"return","value":{"node":"identifier"`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected %s in:\n%s", want, data)
+		}
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("AST JSON does not decode: %v", err)
+	}
+}
+
+func TestParseTests(t *testing.T) {
+	src := `function validateUser(user: User) returns boolean
+    why: "Users need a name"
+    do:
+        return true
+
+test "rejects empty name":
+    expect validateUser(user) is false
+    expect validateUser(user) fails "name is required"
+
+test "accepts the sample user": expect validateUser(user)
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if len(file.Functions) != 1 || len(file.Tests) != 2 {
+		t.Fatalf("expected one function and two tests, got %d and %d", len(file.Functions), len(file.Tests))
+	}
+	test := file.Tests[0]
+	if test.Name != "rejects empty name" || len(test.Statements) != 2 {
+		t.Fatalf("unexpected test: %+v", test)
+	}
+	is, ok := test.Statements[0].(*ExpectStatement)
+	if !ok || is.Expected == nil || is.Fails {
+		t.Fatalf("unexpected expect statement: %#v", test.Statements[0])
+	}
+	fails := test.Statements[1].(*ExpectStatement)
+	if !fails.Fails || fails.Message != "name is required" {
+		t.Fatalf("unexpected fails statement: %#v", fails)
+	}
+	if inline := file.Tests[1]; len(inline.Statements) != 1 || inline.Statements[0].(*ExpectStatement).Expected != nil {
+		t.Fatalf("unexpected inline test: %+v", inline)
+	}
+}
+
+func TestParseFieldDefaults(t *testing.T) {
+	src := `define record Account
+    status: text default "active"
+    limit: int default 10
+    createdAt: datetime default now
+    default: boolean
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	fields := file.Records[0].Fields
+	if len(fields) != 4 {
+		t.Fatalf("expected four fields, got %d", len(fields))
+	}
+	if literal, ok := fields[0].Default.(*LiteralExpression); !ok || literal.Value != "active" {
+		t.Errorf("unexpected status default: %#v", fields[0].Default)
+	}
+	if literal, ok := fields[1].Default.(*LiteralExpression); !ok || literal.Value != int64(10) {
+		t.Errorf("unexpected limit default: %#v", fields[1].Default)
+	}
+	if !IsDefaultNow(fields[2].Default) {
+		t.Errorf("expected createdAt to default to now, got %#v", fields[2].Default)
+	}
+	if fields[3].Name != "default" || fields[3].Default != nil {
+		t.Errorf("expected a field named default, got %+v", fields[3])
+	}
+
+	if _, err := ParseString("define record Account\n    status: text default status\n"); err == nil || !strings.Contains(err.Error(), "a field default must be a constant") {
+		t.Fatalf("expected a constant error, got %v", err)
+	}
+}
+
+func TestParseRecordExample(t *testing.T) {
+	src := `define record Account
+    name: text
+    example: text
+    balance: number
+    example:
+        name: "Ada"
+        balance: -12.5
+        example: none
+
+define record Other
+    n: int
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	record := file.Records[0]
+	if len(record.Fields) != 3 || record.Fields[1].Name != "example" {
+		t.Fatalf("expected a field named example among three, got %+v", record.Fields)
+	}
+	if record.Example == nil || len(record.Example.Values) != 3 {
+		t.Fatalf("expected three example values, got %+v", record.Example)
+	}
+	if value := record.Example.Value("name"); value == nil || value.Value != "Ada" {
+		t.Errorf("unexpected name example: %#v", value)
+	}
+	if value := record.Example.Value("balance"); value == nil || value.Value != -12.5 {
+		t.Errorf("unexpected balance example: %#v", value)
+	}
+	if value := record.Example.Value("example"); value == nil || value.Kind != LiteralNone {
+		t.Errorf("expected none for the example field, got %#v", value)
+	}
+	if len(file.Records) != 2 || file.Records[1].Example != nil {
+		t.Errorf("expected the example to end with its record, got %d records", len(file.Records))
+	}
+
+	for src, want := range map[string]string{
+		"define record A\n    n: int\n    example:\n        n: m\n":               "an example value must be a constant",
+		"define record A\n    n: int\n    example:\n        n: 1\n        n: 2\n": "the example gives n twice",
+		"define record A\n    n: int\n    example:\n":                             "example gives no values",
+	} {
+		if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestParseSeed(t *testing.T) {
+	src := `define record User
+    email: email
+    seed: int
+
+// Accounts to try the app with
+seed User: 20 records
+    row:
+        email: "ada@example.com"
+        seed: -1
+    row:
+        email: "grace@example.com"
+
+seed Order: 1 record
+
+define record Order
+    total: number
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if fields := file.Records[0].Fields; len(fields) != 2 || fields[1].Name != "seed" {
+		t.Fatalf("expected a field named seed, got %+v", fields)
+	}
+	if len(file.Seeds) != 2 || len(file.Records) != 2 {
+		t.Fatalf("expected two seeds and two records, got %d and %d", len(file.Seeds), len(file.Records))
+	}
+	seed := file.Seeds[0]
+	if seed.Record != "User" || seed.Count != 20 || len(seed.Rows) != 2 || len(seed.Leading) != 1 {
+		t.Fatalf("unexpected seed %+v", seed)
+	}
+	if value := seed.Rows[0].Value("seed"); value == nil || value.Value != int64(-1) {
+		t.Errorf("unexpected seed value: %#v", value)
+	}
+	if value := seed.Rows[1].Value("email"); value == nil || value.Value != "grace@example.com" {
+		t.Errorf("unexpected email of the second row: %#v", value)
+	}
+	if file.Seeds[1].Record != "Order" || file.Seeds[1].Count != 1 || file.Seeds[1].Rows != nil {
+		t.Errorf("unexpected seed %+v", file.Seeds[1])
+	}
+
+	for src, want := range map[string]string{
+		"seed User: many records\n":                      "expected the number of User records to seed",
+		"seed User: 3 users\n":                           "expected records after 3",
+		"seed User: 3 records\n    row:\n":               "row gives no values",
+		"seed User: 3 records\n    row:\n        n: m\n": "a row value must be a constant",
+	} {
+		if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	src := `define record Server
+    config: text
+
+// The primary database
+config databaseUrl: url from env DATABASE_URL
+    why: "Orders are kept in PostgreSQL"
+
+config port: int min 1 default 8080 why: "The port to listen on"
+config sentryDsn: url optional
+    why: "Errors are reported here"
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if fields := file.Records[0].Fields; len(fields) != 1 || fields[0].Name != "config" {
+		t.Fatalf("expected a field named config, got %+v", fields)
+	}
+	if len(file.Configs) != 3 {
+		t.Fatalf("expected three settings, got %d", len(file.Configs))
+	}
+	database := file.Configs[0]
+	if database.Name != "databaseUrl" || database.Type.Name != "url" || database.EnvName() != "DATABASE_URL" || database.Why != "Orders are kept in PostgreSQL" || len(database.Leading) != 1 || !database.Required() {
+		t.Fatalf("unexpected setting %+v", database)
+	}
+	port := file.Configs[1]
+	if port.Default == nil || port.Type.Constraints[ConstraintMin] != int64(1) || port.EnvName() != "PORT" || port.Required() {
+		t.Errorf("unexpected setting %+v", port)
+	}
+	if sentry := file.Configs[2]; !sentry.Type.Optional || sentry.Env != "" || sentry.EnvName() != "SENTRY_DSN" || sentry.Required() {
+		t.Errorf("unexpected setting %+v", sentry)
+	}
+
+	for src, want := range map[string]string{
+		"config port: int\n":                                   "expected 'why'",
+		"config port: int from PORT\n    why: \"p\"\n":         "expected 'env'",
+		"config port: int from env \"PORT\"\n    why: \"p\"\n": "expected an environment variable after 'from env'",
+	} {
+		if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestParseRoles(t *testing.T) {
+	src := `define record Order
+    allow: bool
+
+define role admin
+    why: "Staff who run the shop"
+define role support
+
+// Support answers customers
+allow support to read, update Order
+allow admin to delete Order
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if fields := file.Records[0].Fields; len(fields) != 1 || fields[0].Name != "allow" {
+		t.Fatalf("expected a field named allow, got %+v", fields)
+	}
+	if len(file.Roles) != 2 || file.Roles[0].Name != "admin" || file.Roles[0].Why != "Staff who run the shop" || file.Roles[1].Name != "support" {
+		t.Fatalf("unexpected roles %+v", file.Roles)
+	}
+	if len(file.Allows) != 2 {
+		t.Fatalf("expected two allow rules, got %d", len(file.Allows))
+	}
+	support := file.Allows[0]
+	if support.Role != "support" || strings.Join(support.Actions, ",") != "read,update" || support.Resource != "Order" || len(support.Leading) != 1 {
+		t.Errorf("unexpected rule %+v", support)
+	}
+	if admin := file.Allows[1]; admin.Role != "admin" || strings.Join(admin.Actions, ",") != "delete" || admin.Resource != "Order" {
+		t.Errorf("unexpected rule %+v", admin)
+	}
+
+	for src, want := range map[string]string{
+		"allow admin delete Order\n":        "expected 'to'",
+		"allow admin to delete\nOrder\n":    "expected the record admin may delete",
+		"allow admin to read, read Order\n": "action read is given twice",
+		"define role\n":                     "expected role name",
+		"define policy admin\n":             "expected 'record', 'type', 'event' or 'role'",
+	} {
+		if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	src := `define record Session
+    schedule: text
+
+// Runs on every instance
+schedule cleanupSessions every 1 hour
+    why: "Expired sessions are removed"
+schedule sendDigest every 2 days why: "Users get a summary"
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if fields := file.Records[0].Fields; len(fields) != 1 || fields[0].Name != "schedule" {
+		t.Fatalf("expected a field named schedule, got %+v", fields)
+	}
+	if len(file.Schedules) != 2 {
+		t.Fatalf("expected two schedules, got %d", len(file.Schedules))
+	}
+	cleanup := file.Schedules[0]
+	if cleanup.Function != "cleanupSessions" || cleanup.Interval() != time.Hour || cleanup.Describe() != "every 1 hour" || cleanup.Why != "Expired sessions are removed" || len(cleanup.Leading) != 1 {
+		t.Errorf("unexpected schedule %+v", cleanup)
+	}
+	if digest := file.Schedules[1]; digest.Unit != "day" || digest.Interval() != 48*time.Hour || digest.Describe() != "every 2 days" || digest.Why != "Users get a summary" {
+		t.Errorf("unexpected schedule %+v", digest)
+	}
+
+	for src, want := range map[string]string{
+		"schedule cleanup every hour\n    why: \"c\"\n":     "expected a whole number after 'every'",
+		"schedule cleanup every 0 hours\n    why: \"c\"\n":  "the interval of schedule cleanup must be at least 1",
+		"schedule cleanup every 5 weeks\n    why: \"c\"\n":  "unknown interval unit \"weeks\"",
+		"schedule cleanup each 5 minutes\n    why: \"c\"\n": "expected 'every'",
+		"schedule cleanup every 5 minutes\n":                "expected 'why'",
+	} {
+		if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestParseQueue(t *testing.T) {
+	src := `define record SendEmailTask
+    queue: text
+
+// Mail leaves outside the request
+queue emailQueue handles SendEmailTask
+    why: "Sending mail is slow and may be retried"
+queue auditQueue handles SendEmailTask
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if fields := file.Records[0].Fields; len(fields) != 1 || fields[0].Name != "queue" {
+		t.Fatalf("expected a field named queue, got %+v", fields)
+	}
+	if len(file.Queues) != 2 {
+		t.Fatalf("expected two queues, got %d", len(file.Queues))
+	}
+	email := file.Queues[0]
+	if email.Name != "emailQueue" || email.Payload != "SendEmailTask" || email.Why != "Sending mail is slow and may be retried" || len(email.Leading) != 1 {
+		t.Errorf("unexpected queue %+v", email)
+	}
+	if audit := file.Queues[1]; audit.Name != "auditQueue" || audit.Why != "" {
+		t.Errorf("unexpected queue %+v", audit)
+	}
+
+	for src, want := range map[string]string{
+		"queue emailQueue takes SendEmailTask\n":  "expected 'handles'",
+		"queue emailQueue handles\n":              "expected the record queue emailQueue handles",
+		"queue \"email\" handles SendEmailTask\n": "expected queue name",
+	} {
+		if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestParseWorkflow(t *testing.T) {
+	src := `define record User
+    workflow: text
+
+// Signs a user up
+workflow onboardUser: step createAccount then step sendWelcomeEmail on failure compensate deleteAccount
+    why: "New users get an account and a greeting"
+
+workflow renew:
+    step charge
+    then step extend on failure compensate refund
+    then step notify
+    why: "Subscriptions renew monthly"
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if fields := file.Records[0].Fields; len(fields) != 1 || fields[0].Name != "workflow" {
+		t.Fatalf("expected a field named workflow, got %+v", fields)
+	}
+	if len(file.Workflows) != 2 {
+		t.Fatalf("expected two workflows, got %d", len(file.Workflows))
+	}
+	onboard := file.Workflows[0]
+	if onboard.Name != "onboardUser" || len(onboard.Steps) != 2 || onboard.Why != "New users get an account and a greeting" || len(onboard.Leading) != 1 {
+		t.Fatalf("unexpected workflow %+v", onboard)
+	}
+	if first, second := onboard.Steps[0], onboard.Steps[1]; first.Function != "createAccount" || first.Compensate != "" || second.Function != "sendWelcomeEmail" || second.Compensate != "deleteAccount" {
+		t.Errorf("unexpected steps %+v, %+v", first, second)
+	}
+	renew := file.Workflows[1]
+	if len(renew.Steps) != 3 || renew.Steps[1].Compensate != "refund" || renew.Steps[2].Function != "notify" {
+		t.Errorf("unexpected workflow %+v", renew)
+	}
+
+	for src, want := range map[string]string{
+		"workflow w step a\n    why: \"w\"\n":                                "expected ':'",
+		"workflow w: step a then b\n    why: \"w\"\n":                        "expected 'step'",
+		"workflow w: step a then step b on failure undo c\n    why: \"w\"\n": "expected 'compensate'",
+		"workflow w: step a step b\n    why: \"w\"\n":                        "expected 'then' or 'why'",
+	} {
+		if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	src := `define record User
+    name: text
+
+// Sent once a user has signed up
+define event UserCreated
+    payload: User
+    why: "Lets other services greet new users"
+
+define event UserRenamed
+    payload: User
+    channel: "users.renamed"
+    why.de: "Damit andere Dienste den neuen Namen kennen"
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if len(file.Events) != 2 {
+		t.Fatalf("expected two events, got %d", len(file.Events))
+	}
+	created, renamed := file.Events[0], file.Events[1]
+	if created.Payload.Name != "User" || created.Why != "Lets other services greet new users" || len(created.Leading) != 1 {
+		t.Errorf("unexpected UserCreated: %+v", created)
+	}
+	if channel := created.ChannelName(); channel != "user.created" {
+		t.Errorf("expected the default channel user.created, got %q", channel)
+	}
+	if channel := renamed.ChannelName(); channel != "users.renamed" || renamed.Whys["de"] == "" {
+		t.Errorf("unexpected UserRenamed: %+v", renamed)
+	}
+
+	for src, want := range map[string]string{
+		"define event E\n    why: \"x\"\n":                    "event E has no payload",
+		"define event E\n    payload: A\n    payload: B\n":    "event E already has a payload",
+		"define event E\n    payload: A\n    topic: \"t\"\n":  "unknown event clause \"topic\"",
+		"define event E\n    payload: A\n    channel: t\n":    "expected string after 'channel:'",
+		"define event E\n    payload: A\n    channel: \"\"\n": "the channel of event E is empty",
+	} {
+		if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestParseFieldConstraints(t *testing.T) {
+	src := `define record Person
+    age: int min 0 max 150
+    name: text maxlength 80 optional
+    balance: number min -10.5 default 0
+    min: int
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	fields := file.Records[0].Fields
+	if len(fields) != 4 {
+		t.Fatalf("expected four fields, got %d", len(fields))
+	}
+	if c := fields[0].Type.Constraints; c[ConstraintMin] != int64(0) || c[ConstraintMax] != int64(150) {
+		t.Errorf("unexpected age constraints: %v", c)
+	}
+	if c := fields[1].Type.Constraints; c[ConstraintMaxLength] != int64(80) || !fields[1].Type.Optional {
+		t.Errorf("unexpected name type: %+v", fields[1].Type)
+	}
+	if c := fields[2].Type.Constraints; c[ConstraintMin] != -10.5 || fields[2].Default == nil {
+		t.Errorf("unexpected balance field: %+v", fields[2])
+	}
+	if fields[3].Name != "min" {
+		t.Errorf("expected a field named min, got %q", fields[3].Name)
+	}
+
+	for src, want := range map[string]string{
+		"define record P\n    age: int min many\n":      "expected a number after min",
+		"define record P\n    age: int min 1 min 2\n":   "min is given twice",
+		"define record P\n    name: text maxlength 8.5": "maxlength must be a whole number of characters",
+	} {
+		if _, err := ParseString(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestParseCustomTypes(t *testing.T) {
+	src := `define type CompanyEmail as email
+    validate: domain must be "company.com"
+    why: "Only staff sign in"
+
+assign-use ProductPrice as usd_currency
+    why: "Prices stay in range"
+    validate: "value between 0.01 and 9999.99"
+
+define record Staff
+    email: CompanyEmail
+    backup: email domain "company.com"
+`
+	file, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if len(file.TypeDefs) != 1 || len(file.Assignments) != 1 || len(file.Records) != 1 {
+		t.Fatalf("unexpected declarations: %+v", file)
+	}
+	if rule := file.TypeDefs[0].Validation["rule"]; rule != `domain must be "company.com"` || file.TypeDefs[0].Why != "Only staff sign in" {
+		t.Errorf("unexpected type definition: %+v", file.TypeDefs[0])
+	}
+	if rule := file.Assignments[0].Validation["rule"]; file.Assignments[0].TypeName != "ProductPrice" || rule != "value between 0.01 and 9999.99" {
+		t.Errorf("unexpected assignment: %+v", file.Assignments[0])
+	}
+	if domain := file.Records[0].Fields[1].Type.Constraints[ConstraintDomain]; domain != "company.com" {
+		t.Errorf("unexpected domain constraint: %v", domain)
+	}
+}
+
+func TestParseRecordExtends(t *testing.T) {
+	file, err := ParseString("define record User\n    name: text\n\ndefine record AdminUser extends User versioned\n    level: int\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	admin := file.Records[1]
+	if admin.Extends != "User" || !admin.Versioned || len(admin.Fields) != 1 {
+		t.Fatalf("unexpected record: %+v", admin)
+	}
+
+	_, err = ParseString("define record AdminUser extends User extends Person\n    level: int\n")
+	if err == nil || !strings.Contains(err.Error(), "extends") {
+		t.Fatalf("expected an error for a second extends, got %v", err)
+	}
+}
+
+func TestParseMapTypes(t *testing.T) {
+	file, err := ParseString(`define record Team
+    scores: map of text to int
+    budgets: map<int, usd_currency>
+    tags: map of text to list of text
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	fields := file.Records[0].Fields
+	for i, want := range [][3]string{{"text", "int", ""}, {"int", "usd_currency", ""}, {"text", "list", "text"}} {
+		typ := fields[i].Type
+		if typ.Name != "map" || typ.Key.Name != want[0] || typ.Value.Name != want[1] {
+			t.Errorf("unexpected type of %s: %+v", fields[i].Name, typ)
+		}
+		if want[2] != "" && (typ.Value.Element == nil || typ.Value.Element.Name != want[2]) {
+			t.Errorf("unexpected value type of %s: %+v", fields[i].Name, typ.Value)
+		}
+	}
+
+	for _, src := range []string{
+		"define record Team\n    scores: map of text int\n",
+		"define record Team\n    scores: map<text int>\n",
+	} {
+		if _, err := ParseString(src); err == nil {
+			t.Errorf("expected an error for %q", src)
+		}
+	}
+}
+
+func TestParseMaybeAndNone(t *testing.T) {
+	file, err := ParseString(`define record Person
+    nickname: maybe text
+    aliases: list of maybe text
+
+function nicknameOf(person: Person) returns maybe text
+    why: "Reads the nickname"
+    do:
+        if person.nickname = none then return none
+        return person.nickname
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	fields := file.Records[0].Fields
+	if typ := fields[0].Type; typ.Name != "text" || !typ.Optional || !typ.Nullable {
+		t.Errorf("unexpected nickname type: %+v", typ)
+	}
+	if element := fields[1].Type.Element; !element.Nullable || fields[1].Type.Nullable {
+		t.Errorf("unexpected aliases type: %+v", fields[1].Type)
+	}
+	if returns := file.Functions[0].ReturnType; !returns.Nullable {
+		t.Errorf("unexpected return type: %+v", returns)
+	}
+	condition := file.Functions[0].Body.Statements[0].(*IfStatement).Condition.(*BinaryExpression)
+	if literal, ok := condition.Right.(*LiteralExpression); !ok || literal.Kind != LiteralNone || literal.Value != nil {
+		t.Errorf("expected the none literal, got %#v", condition.Right)
+	}
+	if got := FormatExpression(condition); got != "person.nickname = none" {
+		t.Errorf("FormatExpression = %q", got)
+	}
+}
+
+func TestParseListOperations(t *testing.T) {
+	expr, err := ParseExpression(`first of orders where total > 100`)
+	if err != nil {
+		t.Fatalf("ParseExpression: %v", err)
+	}
+	first, ok := expr.(*AggregateExpression)
+	if !ok || first.Function != "first" {
+		t.Fatalf("expected a first aggregate, got %#v", expr)
+	}
+	if query, ok := first.Source.(*QueryExpression); !ok || query.Filter == nil {
+		t.Errorf("expected a filtered source, got %#v", first.Source)
+	}
+
+	for src, want := range map[string]string{
+		`"rush" in order.tags`:     "in",
+		`"rush" not in order.tags`: "not in",
+	} {
+		expr, err := ParseExpression(src)
+		if err != nil {
+			t.Fatalf("ParseExpression(%q): %v", src, err)
+		}
+		if binary, ok := expr.(*BinaryExpression); !ok || binary.Operator != want {
+			t.Errorf("expected operator %q for %q, got %#v", want, src, expr)
+		}
+		if got := FormatExpression(expr); got != src {
+			t.Errorf("FormatExpression = %q, want %q", got, src)
+		}
+	}
+
+	for _, src := range []string{"length of name", "last of events"} {
+		expr, err := ParseExpression(src)
+		if err != nil {
+			t.Fatalf("ParseExpression(%q): %v", src, err)
+		}
+		if got := FormatExpression(expr); got != src {
+			t.Errorf("FormatExpression = %q, want %q", got, src)
+		}
+	}
+}
+
+func TestParseIsOperators(t *testing.T) {
+	for src, want := range map[string]Expression{
+		"record.name is empty":     &EmptyExpression{},
+		"tags is not empty":        &EmptyExpression{Negated: true},
+		"nickname is none":         &BinaryExpression{Operator: "is"},
+		`status is not "archived"`: &BinaryExpression{Operator: "is not"},
+	} {
+		expr, err := ParseExpression(src)
+		if err != nil {
+			t.Fatalf("ParseExpression(%q): %v", src, err)
+		}
+		switch want := want.(type) {
+		case *EmptyExpression:
+			if got, ok := expr.(*EmptyExpression); !ok || got.Negated != want.Negated {
+				t.Errorf("unexpected expression for %q: %#v", src, expr)
+			}
+		case *BinaryExpression:
+			if got, ok := expr.(*BinaryExpression); !ok || got.Operator != want.Operator {
+				t.Errorf("unexpected expression for %q: %#v", src, expr)
+			}
+		}
+		if got := FormatExpression(expr); got != src {
+			t.Errorf("FormatExpression = %q, want %q", got, src)
+		}
+	}
+}
+
+func TestParseMemberChains(t *testing.T) {
+	expr, err := ParseExpression("order.customer.address.city")
+	if err != nil {
+		t.Fatalf("ParseExpression: %v", err)
+	}
+	depth := 0
+	for member, ok := expr.(*MemberExpression); ok; member, ok = member.Object.(*MemberExpression) {
+		depth++
+	}
+	if depth != 3 {
+		t.Errorf("expected 3 nested member expressions, got %d", depth)
+	}
+
+	expr, err = ParseExpression(`order.customer.discount(10).amount`)
+	if err != nil {
+		t.Fatalf("ParseExpression: %v", err)
+	}
+	member, ok := expr.(*MemberExpression)
+	if !ok || member.Property != "amount" {
+		t.Fatalf("expected member access on the call, got %#v", expr)
+	}
+	call, ok := member.Object.(*CallExpression)
+	if !ok || call.Function != "discount" || !call.Method || len(call.Arguments) != 2 {
+		t.Fatalf("expected a method-style call, got %#v", member.Object)
+	}
+	if receiver, ok := call.Arguments[0].(*MemberExpression); !ok || receiver.Property != "customer" {
+		t.Errorf("expected order.customer as the receiver, got %#v", call.Arguments[0])
+	}
+	if got := FormatExpression(expr); got != "order.customer.discount(10).amount" {
+		t.Errorf("FormatExpression = %q", got)
+	}
+
+	if _, err := ParseExpression("order?.discount()"); err == nil {
+		t.Error("expected an error for a call through '?.'")
+	}
+}
+
+func TestMigrateSyntax(t *testing.T) {
+	source := `// Shop models
+model User { // the buyer
+    id: String
+    // shown on receipts
+    name: String optional
+    profile: Profile has_one Profile
+    orders: Order has_many Order // newest first
+}
+
+model Order {
+    userId: String belongs_to User
+    total: Float
+}
+
+assign-use ProductPrice as usd_currency why: "Prices must be positive"
+`
+	want := `// Shop models
+define record User // the buyer
+    id: text
+    // shown on receipts
+    name: text optional
+    profile: Profile
+    orders: list of Order // newest first
+
+define record Order
+    userId: text // belongs_to User
+    total: number
+
+define type ProductPrice as usd_currency why: "Prices must be positive"
+`
+	migrated, count, err := MigrateSyntax([]byte(source))
+	if err != nil {
+		t.Fatalf("MigrateSyntax: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 declarations migrated, got %d", count)
+	}
+	if string(migrated) != want {
+		t.Errorf("MigrateSyntax produced:\n%s\nwant:\n%s", migrated, want)
+	}
+
+	file, err := ParseString(string(migrated))
+	if err != nil {
+		t.Fatalf("parse migrated source: %v", err)
 	}
-	model := file.Models[0]
-	if len(model.Fields) != 2 {
-		t.Fatalf("expected 2 fields, got %d", len(model.Fields))
+	if len(file.Models) != 0 || len(file.Assignments) != 0 || len(file.Records) != 2 || len(file.TypeDefs) != 1 {
+		t.Errorf("expected only records and types after migrating, got %d models, %d assignments", len(file.Models), len(file.Assignments))
 	}
-	if model.Fields[0].Name != "id" || model.Fields[0].Type.Name != "Int" {
-		t.Fatalf("unexpected first field: %#v", model.Fields[0])
+	if lines := CommentLines(file.Records[0].Fields[1].Leading); len(lines) != 1 || lines[0] != "shown on receipts" {
+		t.Errorf("expected the field comment to stay with its field, got %v", lines)
 	}
-	if model.Fields[1].Name != "name" || model.Fields[1].Type.Name != "String" {
-		t.Fatalf("unexpected second field: %#v", model.Fields[1])
 
+	if _, count, _ := MigrateSyntax(migrated); count != 0 {
+		t.Errorf("expected nothing left to migrate, got %d", count)
 	}
 }
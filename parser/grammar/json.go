@@ -0,0 +1,105 @@
+package grammar
+
+import "encoding/json"
+
+// Statements and expressions are interfaces, so their JSON carries a
+// "node" member naming the node, as returned by StatementType or
+// ExpressionType, for tools reading the AST outside Go.
+
+func (s *IfStatement) MarshalJSON() ([]byte, error) {
+	type plain IfStatement
+	return marshalNode(s.StatementType(), (*plain)(s))
+}
+
+func (s *ReturnStatement) MarshalJSON() ([]byte, error) {
+	type plain ReturnStatement
+	return marshalNode(s.StatementType(), (*plain)(s))
+}
+
+func (s *AssignStatement) MarshalJSON() ([]byte, error) {
+	type plain AssignStatement
+	return marshalNode(s.StatementType(), (*plain)(s))
+}
+
+func (s *CreateStatement) MarshalJSON() ([]byte, error) {
+	type plain CreateStatement
+	return marshalNode(s.StatementType(), (*plain)(s))
+}
+
+func (s *FailStatement) MarshalJSON() ([]byte, error) {
+	type plain FailStatement
+	return marshalNode(s.StatementType(), (*plain)(s))
+}
+
+func (s *ExpectStatement) MarshalJSON() ([]byte, error) {
+	type plain ExpectStatement
+	return marshalNode(s.StatementType(), (*plain)(s))
+}
+
+func (e *IdentifierExpression) MarshalJSON() ([]byte, error) {
+	type plain IdentifierExpression
+	return marshalNode(e.ExpressionType(), (*plain)(e))
+}
+
+func (e *LiteralExpression) MarshalJSON() ([]byte, error) {
+	type plain LiteralExpression
+	return marshalNode(e.ExpressionType(), (*plain)(e))
+}
+
+func (e *BinaryExpression) MarshalJSON() ([]byte, error) {
+	type plain BinaryExpression
+	return marshalNode(e.ExpressionType(), (*plain)(e))
+}
+
+func (e *EmptyExpression) MarshalJSON() ([]byte, error) {
+	type plain EmptyExpression
+	return marshalNode(e.ExpressionType(), (*plain)(e))
+}
+
+func (e *CallExpression) MarshalJSON() ([]byte, error) {
+	type plain CallExpression
+	return marshalNode(e.ExpressionType(), (*plain)(e))
+}
+
+func (e *MemberExpression) MarshalJSON() ([]byte, error) {
+	type plain MemberExpression
+	return marshalNode(e.ExpressionType(), (*plain)(e))
+}
+
+func (e *DefaultExpression) MarshalJSON() ([]byte, error) {
+	type plain DefaultExpression
+	return marshalNode(e.ExpressionType(), (*plain)(e))
+}
+
+func (e *ConditionalExpression) MarshalJSON() ([]byte, error) {
+	type plain ConditionalExpression
+	return marshalNode(e.ExpressionType(), (*plain)(e))
+}
+
+func (e *QueryExpression) MarshalJSON() ([]byte, error) {
+	type plain QueryExpression
+	return marshalNode(e.ExpressionType(), (*plain)(e))
+}
+
+func (e *AggregateExpression) MarshalJSON() ([]byte, error) {
+	type plain AggregateExpression
+	return marshalNode(e.ExpressionType(), (*plain)(e))
+}
+
+// marshalNode encodes node, a struct, with its kind as the first member
+func marshalNode(kind string, node interface{}) ([]byte, error) {
+	fields, err := json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(map[string]string{"node": kind})
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) <= 2 {
+		return data, nil
+	}
+	// {"node":"if"} + {"condition":...} = {"node":"if","condition":...}
+	data[len(data)-1] = ','
+	return append(data, fields[1:]...), nil
+}
@@ -0,0 +1,153 @@
+package grammar
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// legacyTypes maps the type names of legacy models to CloudPact types
+var legacyTypes = map[string]string{
+	"String":    "text",
+	"Text":      "text",
+	"Int":       "int",
+	"Integer":   "int",
+	"Float":     "number",
+	"Double":    "number",
+	"Decimal":   "number",
+	"Number":    "number",
+	"Bool":      "boolean",
+	"Boolean":   "boolean",
+	"Date":      "date",
+	"DateTime":  "datetime",
+	"Timestamp": "timestamp",
+}
+
+var legacyTypePattern = regexp.MustCompile(`\b(String|Text|Int|Integer|Float|Double|Decimal|Number|Bool|Boolean|Date|DateTime|Timestamp)\b`)
+
+// MigrateSyntax rewrites legacy "model X { ... }" blocks as define record
+// and "assign-use" declarations as define type, and reports how many
+// declarations changed. Comments stay where they were. A has_many
+// relationship becomes a list of its target, and a has_one whose field
+// already holds the target needs nothing more; other relationships are kept
+// as a comment on the field, as records have no relationship clause.
+func MigrateSyntax(source []byte) ([]byte, int, error) {
+	file, err := ParseString(string(source))
+	if err != nil {
+		return nil, 0, err
+	}
+	text := string(source)
+	newline := "\n"
+	if strings.Contains(text, "\r\n") {
+		newline = "\r\n"
+	}
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	for _, a := range file.Assignments {
+		if a.Position == nil {
+			continue
+		}
+		// "assign-use" is three tokens; define type takes the same clauses
+		start := a.Position.Offset
+		end := strings.Index(text[start:], "use") + start + len("use")
+		edits = append(edits, edit{start, end, "define type"})
+	}
+	for _, m := range file.Models {
+		if m.Position == nil || m.End == nil {
+			continue
+		}
+		edits = append(edits, edit{m.Position.Offset, m.End.Offset, migrateModel(text, m, file.Comments, newline)})
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	for _, e := range edits {
+		text = text[:e.start] + e.text + text[e.end:]
+	}
+	if _, err := ParseString(text); err != nil {
+		return nil, 0, err
+	}
+	return []byte(text), len(edits), nil
+}
+
+// migrateModel writes m as a define record, with the comments inside its
+// braces on the lines they documented
+func migrateModel(source string, m *Model, comments []*Comment, newline string) string {
+	type line struct {
+		offset int
+		text   string
+	}
+	header := "define record " + m.Name + trailing(m.Trailing)
+
+	claimed := make(map[*Comment]bool)
+	for _, c := range m.Trailing {
+		claimed[c] = true
+	}
+	var lines []line
+	for _, f := range m.Fields {
+		text, note := migrateField(source, f)
+		trailer := trailing(f.Trailing)
+		if note != "" && trailer == "" {
+			trailer = " // " + note
+		} else if note != "" {
+			lines = append(lines, line{f.Position.Offset - 1, "    // " + note})
+		}
+		lines = append(lines, line{f.Position.Offset, "    " + text + trailer})
+		for _, c := range f.Trailing {
+			claimed[c] = true
+		}
+	}
+	for _, c := range comments {
+		if claimed[c] || c.Position.Offset < m.Position.Offset || c.Position.Offset >= m.End.Offset {
+			continue
+		}
+		lines = append(lines, line{c.Position.Offset, "    " + c.Text})
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].offset < lines[j].offset })
+
+	out := []string{header}
+	for _, l := range lines {
+		out = append(out, l.text)
+	}
+	return strings.Join(out, newline)
+}
+
+// migrateField rewrites a model field as a record field, returning the
+// relationship it cannot express in its type
+func migrateField(source string, f *Field) (text, note string) {
+	t := f.Type
+	typeText := legacyTypePattern.ReplaceAllStringFunc(source[t.Position.Offset:t.End.Offset], func(name string) string {
+		return legacyTypes[name]
+	})
+	restEnd := f.End.Offset
+	if f.Relationship != nil {
+		restEnd = f.Relationship.Position.Offset
+	}
+	rest := strings.TrimSpace(source[t.End.Offset:restEnd])
+
+	if r := f.Relationship; r != nil {
+		switch {
+		case r.Kind == "has_many" && t.Name == r.Target:
+			typeText = "list of " + typeText
+		case r.Kind == "has_one" && t.Name == r.Target:
+		default:
+			note = r.Kind + " " + r.Target
+		}
+	}
+	text = f.Name + ": " + typeText
+	if rest != "" {
+		text += " " + rest
+	}
+	return text, note
+}
+
+func trailing(comments []*Comment) string {
+	var b strings.Builder
+	for _, c := range comments {
+		b.WriteString(" " + c.Text)
+	}
+	return b.String()
+}
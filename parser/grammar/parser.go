@@ -3,8 +3,8 @@
 package grammar
 
 import (
-	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"text/scanner"
 )
@@ -13,14 +13,24 @@ import (
 //   File            := ModuleDecl { Declaration }
 //   ModuleDecl      := 'module' IDENT
 //   Declaration     := RecordDef | FunctionDef | TypeDef | Model | Assignment
-//   RecordDef       := 'define' 'record' IDENT { FieldDef }
-//   FieldDef        := IDENT ':' Type
-//   FunctionDef     := 'function' IDENT '(' ParamList ')' [ 'returns' Type ] AIAnnotations WhyClause DoBlock
+//   RecordDef       := 'define' 'record' IDENT [ 'versioned' ] [ 'no' 'id' ] { FieldDef }
+//   FieldDef        := IDENT ':' Type [ 'optional' ] [ 'key' ] [ 'round' ':' RoundingMode ]
+//   RoundingMode    := 'banker' | 'half-up'
+//   FunctionDef     := 'function' IDENT '(' ParamList ')' [ 'returns' Type ] { HeaderDecl } { AIAnnotation } WhyClause { WhyClause } { AIAnnotation } DoBlock
+//   WhyClause       := 'why' [ '.' LOCALE ] ':' STRING
+//   HeaderDecl      := 'header' ':' HEADER-NAME [ 'required' | 'optional' | 'emitted' ]
 //   DoBlock         := 'do:' { Statement }
 //   Statement       := IfStatement | Assignment | Return | CreateStatement | Expression
 //   IfStatement     := 'if' Expression 'then' Statement [ 'else' Statement ]
 //   CreateStatement := 'create' IDENT 'with:' { FieldAssignment }
-//   AIAnnotation    := ('ai-feedback:' | 'ai-suggests:' | 'ai-security:' | 'ai-performance:') STRING
+//   Expression      := Query [ 'if' Default 'else' Expression ]
+//   Query           := Default [ 'where' Default ] [ 'select' Default ]
+//   Default         := Comparison { 'or' Comparison }
+//   Member          := Primary { ( '.' | '?.' ) IDENT }
+//   Aggregate       := ( 'count' | 'sum' | 'average' ) 'of' Member [ 'where' Default ] [ 'select' Default ] [ 'round' ':' RoundingMode ]
+//   Type            := IDENT | 'list' '[' Type ']' | 'list' 'of' Type
+//   AIAnnotation    := ( 'ai-feedback' | 'ai-suggests' | 'ai-security' | 'ai-performance' |
+//                        'ai-decision-accepted' | 'ai-decision-rejected' ) ':' STRING
 //
 //   // Legacy support for existing models
 //   Model           := 'model' IDENT '{' { Field } '}'
@@ -31,6 +41,10 @@ type parser struct {
 	scanner  scanner.Scanner
 	tok      rune
 	filename string
+	prevLine int      // line of the previously consumed token
+	prevEnd  Position // position just past the previously consumed token
+	comments []*Comment
+	pending  []*Comment // comments not yet attached to a declaration
 }
 
 // Parse reads CloudPact content from r and returns the parsed AST
@@ -40,21 +54,87 @@ func Parse(r io.Reader) (*File, error) {
 
 // ParseWithFilename allows tracking source file for better error messages
 func ParseWithFilename(r io.Reader, filename string) (*File, error) {
+	p := newParser(r, filename)
+	file, err := p.parseFile()
+	if err != nil {
+		return nil, p.diagnostic(err)
+	}
+	return file, nil
+}
+
+// ParseString parses a string containing CloudPact grammar into an AST
+func ParseString(s string) (*File, error) {
+	return Parse(strings.NewReader(s))
+}
+
+// ParseExpression parses a single expression such as `total > 100`, for
+// tools that evaluate CloudPact outside a file
+func ParseExpression(s string) (Expression, error) {
+	p := newParser(strings.NewReader(s), "")
+	expr, err := p.parseExpression()
+	if err == nil {
+		err = p.expectEnd()
+	}
+	if err != nil {
+		return nil, p.diagnostic(err)
+	}
+	return expr, nil
+}
+
+// ParseStatement parses a single statement of a do block, such as
+// `set total = 0` or `if total < 0 then fail "..."`
+func ParseStatement(s string) (Statement, error) {
+	p := newParser(strings.NewReader(s), "")
+	var stmt Statement
+	var err error
+	if p.tok == scanner.Ident && isStatementKeyword(p.scanner.TokenText()) {
+		stmt, err = p.parseStatement()
+	} else {
+		err = p.errorf(CodeSyntax, "expected a statement, got %q", p.scanner.TokenText())
+	}
+	if err == nil {
+		err = p.expectEnd()
+	}
+	if err != nil {
+		return nil, p.diagnostic(err)
+	}
+	return stmt, nil
+}
+
+// IsStatement reports whether s starts with a statement keyword
+func IsStatement(s string) bool {
+	fields := strings.Fields(s)
+	return len(fields) > 0 && isStatementKeyword(fields[0])
+}
+
+func newParser(r io.Reader, filename string) *parser {
 	p := &parser{filename: filename}
 	p.scanner.Init(r)
 	p.scanner.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats |
 		scanner.ScanChars | scanner.ScanStrings | scanner.ScanComments
 	p.next()
-	return p.parseFile()
+	return p
 }
 
-// ParseString parses a string containing CloudPact grammar into an AST
-func ParseString(s string) (*File, error) {
-	return Parse(strings.NewReader(s))
+func (p *parser) expectEnd() error {
+	if p.tok != scanner.EOF {
+		return p.errorf(CodeSyntax, "unexpected %q", p.scanner.TokenText())
+	}
+	return nil
 }
 
 func (p *parser) next() {
+	p.prevLine = p.scanner.Position.Line
+	p.prevEnd = p.tokenEnd()
 	p.tok = p.scanner.Scan()
+	p.scanComments()
+}
+
+// end is the position just past the last token consumed, where the node
+// being parsed ends
+func (p *parser) end() *Position {
+	end := p.prevEnd
+	return &end
 }
 
 func (p *parser) position() *Position {
@@ -67,6 +147,50 @@ func (p *parser) position() *Position {
 	}
 }
 
+// errorf reports a problem with the current token
+func (p *parser) errorf(code, format string, args ...interface{}) *Diagnostic {
+	d := NewDiagnostic(code, p.position(), format, args...)
+	d.Range.End = p.tokenEnd()
+	return d
+}
+
+// errorAt reports a problem with the source from pos to the end of the
+// last token consumed, or with the current token if none has been since pos
+func (p *parser) errorAt(pos *Position, code, format string, args ...interface{}) *Diagnostic {
+	if p.prevEnd.Offset <= pos.Offset {
+		return p.errorf(code, format, args...)
+	}
+	d := NewDiagnostic(code, pos, format, args...)
+	d.Range.End = p.prevEnd
+	return d
+}
+
+// tokenEnd is the position just past the current token
+func (p *parser) tokenEnd() Position {
+	end := *p.position()
+	if p.tok != scanner.EOF {
+		text := p.scanner.TokenText()
+		end.Offset += len(text)
+		if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+			// Block comments and raw strings may span lines
+			end.Line += strings.Count(text, "\n")
+			end.Column = len(text) - i
+		} else {
+			end.Column += len(text)
+		}
+	}
+	return end
+}
+
+// diagnostic makes err a Diagnostic, placing errors that are not already
+// one at the current token
+func (p *parser) diagnostic(err error) *Diagnostic {
+	if d, ok := err.(*Diagnostic); ok {
+		return d
+	}
+	return p.errorf(CodeSyntax, "%s", err.Error())
+}
+
 func (p *parser) parseFile() (*File, error) {
 	file := &File{
 		Records:     []*Record{},
@@ -108,7 +232,56 @@ func (p *parser) parseFile() (*File, error) {
 			}
 			file.Models = append(file.Models, model)
 
-		case p.tok == scanner.Ident && p.scanner.TokenText() == "assign-use":
+		case p.tok == scanner.Ident && p.scanner.TokenText() == "test":
+			test, err := p.parseTest()
+			if err != nil {
+				return nil, err
+			}
+			file.Tests = append(file.Tests, test)
+
+		case p.atSeed():
+			seed, err := p.parseSeed()
+			if err != nil {
+				return nil, err
+			}
+			file.Seeds = append(file.Seeds, seed)
+
+		case p.atConfig():
+			config, err := p.parseConfig()
+			if err != nil {
+				return nil, err
+			}
+			file.Configs = append(file.Configs, config)
+
+		case p.atAllow():
+			allow, err := p.parseAllow()
+			if err != nil {
+				return nil, err
+			}
+			file.Allows = append(file.Allows, allow)
+
+		case p.atSchedule():
+			schedule, err := p.parseSchedule()
+			if err != nil {
+				return nil, err
+			}
+			file.Schedules = append(file.Schedules, schedule)
+
+		case p.atWorkflow():
+			workflow, err := p.parseWorkflow()
+			if err != nil {
+				return nil, err
+			}
+			file.Workflows = append(file.Workflows, workflow)
+
+		case p.atQueue():
+			queue, err := p.parseQueue()
+			if err != nil {
+				return nil, err
+			}
+			file.Queues = append(file.Queues, queue)
+
+		case p.atAssignUse():
 			assignment, err := p.parseAssignment()
 			if err != nil {
 				return nil, err
@@ -116,31 +289,38 @@ func (p *parser) parseFile() (*File, error) {
 			file.Assignments = append(file.Assignments, assignment)
 
 		default:
-			return nil, fmt.Errorf("unexpected token %q at %s", p.scanner.TokenText(), p.position())
+			return nil, p.errorf(CodeSyntax, "unexpected token %q", p.scanner.TokenText())
 		}
 	}
 
+	file.End = p.end()
+	file.Comments = p.comments
 	return file, nil
 }
 
 func (p *parser) parseModule() (*Module, error) {
 	pos := p.position()
+	leading := p.leadingComments(pos)
 
 	if err := p.expectKeyword("module"); err != nil {
 		return nil, err
 	}
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected module name, got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected module name, got %q", p.scanner.TokenText())
 	}
 
 	name := p.scanner.TokenText()
 	p.next()
 
-	return &Module{
+	module := &Module{
 		Name:     name,
+		Leading:  leading,
 		Position: pos,
-	}, nil
+		End:      p.end(),
+	}
+	module.Trailing = p.trailingComments(module.End)
+	return module, nil
 }
 
 func (p *parser) parseDefine(file *File) error {
@@ -149,7 +329,7 @@ func (p *parser) parseDefine(file *File) error {
 	}
 
 	if p.tok != scanner.Ident {
-		return fmt.Errorf("expected 'record' or 'type' after 'define', got %q at %s", p.scanner.TokenText(), p.position())
+		return p.errorf(CodeSyntax, "expected 'record', 'type', 'event' or 'role' after 'define', got %q", p.scanner.TokenText())
 	}
 
 	switch p.scanner.TokenText() {
@@ -165,8 +345,20 @@ func (p *parser) parseDefine(file *File) error {
 			return err
 		}
 		file.TypeDefs = append(file.TypeDefs, typeDef)
+	case "event":
+		event, err := p.parseEvent()
+		if err != nil {
+			return err
+		}
+		file.Events = append(file.Events, event)
+	case "role":
+		role, err := p.parseRole()
+		if err != nil {
+			return err
+		}
+		file.Roles = append(file.Roles, role)
 	default:
-		return fmt.Errorf("expected 'record' or 'type' after 'define', got %q at %s", p.scanner.TokenText(), p.position())
+		return p.errorf(CodeSyntax, "expected 'record', 'type', 'event' or 'role' after 'define', got %q", p.scanner.TokenText())
 	}
 
 	return nil
@@ -174,13 +366,14 @@ func (p *parser) parseDefine(file *File) error {
 
 func (p *parser) parseRecord() (*Record, error) {
 	pos := p.position()
+	leading := p.leadingComments(pos)
 
 	if err := p.expectKeyword("record"); err != nil {
 		return nil, err
 	}
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected record name, got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected record name, got %q", p.scanner.TokenText())
 	}
 
 	name := p.scanner.TokenText()
@@ -188,57 +381,391 @@ func (p *parser) parseRecord() (*Record, error) {
 
 	record := &Record{
 		Name:     name,
+		Leading:  leading,
 		Position: pos,
 		Fields:   []*FieldDef{},
 	}
 
-	// Parse fields until we hit a keyword that starts a new declaration
-	for p.tok == scanner.Ident && !isTopLevelKeyword(p.scanner.TokenText()) {
-		field, err := p.parseFieldDef()
+	// "extends Base", "versioned", "no id" and "persist" follow the name on
+	// the same line; on the next line they would be fields
+	for p.tok == scanner.Ident && p.scanner.Position.Line == p.prevLine {
+		switch p.scanner.TokenText() {
+		case "versioned":
+			record.Versioned = true
+			p.next()
+			continue
+		case "persist":
+			if record.Persist != "" {
+				return nil, p.errorf(CodeDuplicate, "record %s is already persisted", name).
+					Suggest("write persist once, followed by bucket for an object store")
+			}
+			p.next()
+			record.Persist = "table"
+			if p.tok == scanner.Ident && p.scanner.Position.Line == p.prevLine {
+				switch p.scanner.TokenText() {
+				case "table", "bucket":
+					record.Persist = p.scanner.TokenText()
+					p.next()
+				}
+			}
+			continue
+		case "no":
+			p.next()
+			if p.tok != scanner.Ident || p.scanner.TokenText() != "id" {
+				return nil, p.errorf(CodeSyntax, "expected id after no, got %q", p.scanner.TokenText()).
+					Suggest("write no id for a record without an identity")
+			}
+			record.NoID = true
+			p.next()
+			continue
+		case "extends":
+			if record.Extends != "" {
+				return nil, p.errorf(CodeDuplicate, "record %s already extends %s", name, record.Extends).
+					Suggest("a record extends at most one other record")
+			}
+			p.next()
+			if p.tok != scanner.Ident {
+				return nil, p.errorf(CodeSyntax, "expected the name of the record %s extends, got %q", name, p.scanner.TokenText())
+			}
+			record.Extends = p.scanner.TokenText()
+			p.next()
+			continue
+		}
+		break
+	}
+	record.Trailing = p.trailingComments(p.end())
+
+	// Parse fields until we hit a keyword that starts a new declaration. An
+	// "example:" ending its line starts the example, the record's last part;
+	// followed by a type it is a field named example.
+	for p.tok == scanner.Ident && !p.atTopLevelKeyword() {
+		pos := p.position()
+		leading := p.leadingComments(pos)
+		name := p.scanner.TokenText()
+		p.next()
+		if err := p.expect(':', "':'"); err != nil {
+			return nil, err
+		}
+		if name == "example" && p.scanner.Position.Line != p.prevLine {
+			example, err := p.parseExample(pos)
+			if err != nil {
+				return nil, err
+			}
+			record.Example = example
+			break
+		}
+		field, err := p.parseFieldDef(name, pos, leading)
 		if err != nil {
 			return nil, err
 		}
 		record.Fields = append(record.Fields, field)
 	}
 
+	record.End = p.end()
 	return record, nil
 }
 
-func (p *parser) parseFieldDef() (*FieldDef, error) {
-	pos := p.position()
+// parseExample parses the "field: value" lines of a record's example, the
+// "example:" at pos having been consumed. Values are literals; a number may
+// be negative.
+func (p *parser) parseExample(pos *Position) (*Example, error) {
+	return p.parseValues(pos, "example")
+}
 
-	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected field name, got %q at %s", p.scanner.TokenText(), p.position())
-	}
+// parseValues parses the "field: value" lines of an example, or of a seed
+// row when what is "row", in which case they end at the next "row:"
+func (p *parser) parseValues(pos *Position, what string) (*Example, error) {
+	example := &Example{Position: pos}
+	for p.tok == scanner.Ident && !p.atTopLevelKeyword() && !(what == "row" && p.scanner.TokenText() == "row") {
+		valuePos := p.position()
+		field := p.scanner.TokenText()
+		p.next()
+		if err := p.expect(':', "':'"); err != nil {
+			return nil, err
+		}
 
-	name := p.scanner.TokenText()
-	p.next()
+		negative := p.tok == '-'
+		if negative {
+			p.next()
+			if p.tok != scanner.Int && p.tok != scanner.Float {
+				return nil, p.errorf(CodeSyntax, "expected a number after '-', got %q", p.scanner.TokenText())
+			}
+		}
+		value, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		literal, ok := value.(*LiteralExpression)
+		if !ok {
+			if what == "row" {
+				return nil, p.errorAt(value.GetPosition(), CodeSyntax, "a row value must be a constant").
+					Until(value.GetEnd()).
+					Suggest("use a text, number or true/false literal or none")
+			}
+			return nil, p.errorAt(value.GetPosition(), CodeSyntax, "an example value must be a constant").
+				Until(value.GetEnd()).
+				Suggest("use a text, number or true/false literal or none; fields go before example:")
+		}
+		if negative {
+			switch v := literal.Value.(type) {
+			case int64:
+				literal.Value = -v
+			case float64:
+				literal.Value = -v
+			}
+		}
 
-	if err := p.expect(':', "':'"); err != nil {
-		return nil, err
+		for _, other := range example.Values {
+			if other.Field == field {
+				return nil, p.errorAt(valuePos, CodeDuplicate, "the %s gives %s twice", what, field).Until(p.end())
+			}
+		}
+		example.Values = append(example.Values, &ExampleValue{Field: field, Value: literal, Position: valuePos, End: p.end()})
+	}
+	if len(example.Values) == 0 {
+		return nil, p.errorAt(pos, CodeSyntax, "%s gives no values", what).
+			Suggest("list field: value lines after %s:", what)
 	}
+	example.End = p.end()
+	return example, nil
+}
 
+// parseFieldDef parses the type and markers of a field whose name and ':'
+// were consumed, the name starting at pos
+func (p *parser) parseFieldDef(name string, pos *Position, leading []*Comment) (*FieldDef, error) {
 	fieldType, err := p.parseType()
 	if err != nil {
 		return nil, err
 	}
+	p.parseOptionalMarker(fieldType)
+	if err := p.parseConstraints(fieldType); err != nil {
+		return nil, err
+	}
+	p.parseOptionalMarker(fieldType) // may also follow the constraints
+	key := p.parseKeyMarker()
+	if err := p.parseRoundingMarker(fieldType); err != nil {
+		return nil, err
+	}
+	defaultValue, err := p.parseDefaultMarker()
+	if err != nil {
+		return nil, err
+	}
 
-	return &FieldDef{
+	field := &FieldDef{
 		Name:     name,
 		Type:     fieldType,
+		Default:  defaultValue,
+		Key:      key,
+		Leading:  leading,
 		Position: pos,
-	}, nil
+		End:      p.end(),
+	}
+	field.Trailing = p.trailingComments(field.End)
+	return field, nil
+}
+
+// parseOptionalMarker consumes a trailing 'optional' keyword after a field type.
+// A following ':' means the next field is itself named "optional".
+func (p *parser) parseOptionalMarker(t *Type) {
+	if p.tok == scanner.Ident && p.scanner.TokenText() == "optional" && p.scanner.Peek() != ':' {
+		t.Optional = true
+		p.next()
+		t.End = p.end()
+	}
+}
+
+// parseKeyMarker consumes a 'key' keyword on the type's line, marking the
+// field as the record's identity
+func (p *parser) parseKeyMarker() bool {
+	if p.tok == scanner.Ident && p.scanner.TokenText() == "key" && p.scanner.Position.Line == p.prevLine && p.scanner.Peek() != ':' {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// parseConstraints consumes constraints such as "min 0 max 150" on the
+// type's line, in any order, storing them in the type's Constraints
+func (p *parser) parseConstraints(t *Type) error {
+	for p.tok == scanner.Ident && IsConstraint(p.scanner.TokenText()) && p.scanner.Position.Line == p.prevLine {
+		pos := p.position()
+		name := p.scanner.TokenText()
+		if _, ok := t.Constraints[name]; ok {
+			return p.errorf(CodeDuplicate, "%s is given twice", name).
+				Suggest("remove one of the %s constraints", name)
+		}
+		p.next()
+
+		if name == ConstraintDomain {
+			if p.tok != scanner.String {
+				return p.errorf(CodeSyntax, "expected a quoted domain after domain, got %q", p.scanner.TokenText()).
+					Suggest(`write the domain in quotes, e.g. domain "example.com"`)
+			}
+			domain, err := strconv.Unquote(p.scanner.TokenText())
+			if err != nil {
+				return p.errorf(CodeInvalidLiteral, "invalid string %s", p.scanner.TokenText())
+			}
+			p.next()
+			t.Constraints[name] = domain
+			t.End = p.end()
+			continue
+		}
+
+		negative := p.tok == '-'
+		if negative {
+			p.next()
+		}
+		var value interface{}
+		switch p.tok {
+		case scanner.Int:
+			n, err := strconv.ParseInt(p.scanner.TokenText(), 0, 64)
+			if err != nil {
+				return p.errorf(CodeInvalidLiteral, "invalid whole number %s", p.scanner.TokenText())
+			}
+			if negative {
+				n = -n
+			}
+			value = n
+		case scanner.Float:
+			f, err := strconv.ParseFloat(p.scanner.TokenText(), 64)
+			if err != nil {
+				return p.errorf(CodeInvalidLiteral, "invalid number %s", p.scanner.TokenText())
+			}
+			if negative {
+				f = -f
+			}
+			value = f
+		default:
+			return p.errorf(CodeSyntax, "expected a number after %s, got %q", name, p.scanner.TokenText()).
+				Suggest("write the limit as a number, e.g. %s 10", name)
+		}
+		p.next()
+
+		if _, whole := value.(int64); (name == ConstraintMinLength || name == ConstraintMaxLength) && (!whole || negative) {
+			return p.errorAt(pos, CodeInvalidLiteral, "%s must be a whole number of characters", name).Until(p.end())
+		}
+		t.Constraints[name] = value
+		t.End = p.end()
+	}
+	return nil
+}
+
+// parseRoundingMarker consumes "round: banker" or "round: half-up" after a field
+// type. It must share the type's line, otherwise it is the next field, named "round".
+func (p *parser) parseRoundingMarker(t *Type) error {
+	if p.tok != scanner.Ident || p.scanner.TokenText() != "round" || p.scanner.Position.Line != p.prevLine {
+		return nil
+	}
+	mode, err := p.parseRounding()
+	if err != nil {
+		return err
+	}
+	t.Constraints["round"] = mode
+	t.End = p.end()
+	return nil
+}
+
+// parseDefaultMarker parses "default <literal>" or "default now" after a
+// field type. Like "round:", it must share the type's line.
+func (p *parser) parseDefaultMarker() (Expression, error) {
+	if p.tok != scanner.Ident || p.scanner.TokenText() != "default" || p.scanner.Position.Line != p.prevLine {
+		return nil, nil
+	}
+	p.next() // consume 'default'
+
+	if p.tok == scanner.Ident && p.scanner.TokenText() == DefaultNow {
+		pos := p.position()
+		p.next()
+		return &IdentifierExpression{Name: DefaultNow, Position: pos, End: p.end()}, nil
+	}
+	switch p.tok {
+	case scanner.String, scanner.Int, scanner.Float, scanner.Ident:
+		value, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		if literal, ok := value.(*LiteralExpression); ok {
+			return literal, nil
+		}
+		return nil, p.errorAt(value.GetPosition(), CodeSyntax, "a field default must be a constant").
+			Until(value.GetEnd()).
+			Suggest("use a text, number or true/false literal, or 'now' for temporal fields")
+	}
+	return nil, p.errorf(CodeSyntax, "expected a default value, got %q", p.scanner.TokenText()).
+		Suggest("use a text, number or true/false literal, or 'now' for temporal fields")
+}
+
+// parseRounding parses "round: <mode>" and returns the mode
+func (p *parser) parseRounding() (string, error) {
+	p.next() // consume 'round'
+	if err := p.expect(':', "':' after 'round'"); err != nil {
+		return "", err
+	}
+
+	if p.tok != scanner.Ident {
+		return "", p.errorf(CodeSyntax, "expected rounding mode, got %q", p.scanner.TokenText())
+	}
+	pos := p.position()
+	mode := p.scanner.TokenText()
+	p.next()
+
+	// "half-up" scans as three tokens
+	if mode == "half" && p.tok == '-' {
+		p.next()
+		if p.tok != scanner.Ident {
+			return "", p.errorf(CodeSyntax, "expected rounding mode, got %q", p.scanner.TokenText())
+		}
+		mode += "-" + p.scanner.TokenText()
+		p.next()
+	}
+
+	if mode != RoundBanker && mode != RoundHalfUp {
+		return "", p.errorAt(pos, CodeUnknownKeyword, "unknown rounding mode %q (expected %q or %q)", mode, RoundBanker, RoundHalfUp).
+			Suggest("use round: %s for half to even or round: %s for half away from zero", RoundBanker, RoundHalfUp)
+	}
+	return mode, nil
+}
+
+// parseValidateRule parses "validate:" and its rule, either a string or the
+// rest of the line: validate: value between 0.01 and 9999.99. The analyzer
+// interprets the rule.
+func (p *parser) parseValidateRule() (string, error) {
+	p.next() // consume 'validate'
+	if err := p.expect(':', "':'"); err != nil {
+		return "", err
+	}
+	if p.tok == scanner.String {
+		rule, err := strconv.Unquote(p.scanner.TokenText())
+		if err != nil {
+			return "", p.errorf(CodeInvalidLiteral, "invalid string %s", p.scanner.TokenText())
+		}
+		p.next()
+		return rule, nil
+	}
+
+	line := p.prevLine // the rule must follow on the same line
+	var words []string
+	for p.tok != scanner.EOF && p.scanner.Position.Line == line {
+		words = append(words, p.scanner.TokenText())
+		p.next()
+	}
+	if len(words) == 0 {
+		return "", p.errorf(CodeSyntax, "expected a validation rule").
+			Suggest("write a rule such as value between 1 and 100")
+	}
+	return strings.Join(words, " "), nil
 }
 
 func (p *parser) parseTypeDef() (*TypeDef, error) {
 	pos := p.position()
+	leading := p.leadingComments(pos)
 
 	if err := p.expectKeyword("type"); err != nil {
 		return nil, err
 	}
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected type name, got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected type name, got %q", p.scanner.TokenText())
 	}
 
 	name := p.scanner.TokenText()
@@ -256,50 +783,188 @@ func (p *parser) parseTypeDef() (*TypeDef, error) {
 	typeDef := &TypeDef{
 		Name:       name,
 		BaseType:   baseType,
+		Leading:    leading,
 		Position:   pos,
 		Validation: make(map[string]interface{}),
 	}
+	typeDef.Trailing = p.trailingComments(p.end())
 
 	// Parse optional why and validation clauses
 	for p.tok == scanner.Ident {
 		switch p.scanner.TokenText() {
 		case "why":
+			if err := p.parseWhy(&typeDef.Why, &typeDef.Whys); err != nil {
+				return nil, err
+			}
+		case "validate":
+			rule, err := p.parseValidateRule()
+			if err != nil {
+				return nil, err
+			}
+			typeDef.Validation["rule"] = rule
+		default:
+			// Not a type definition clause, break out
+			typeDef.End = p.end()
+			return typeDef, nil
+		}
+	}
+
+	typeDef.End = p.end()
+	return typeDef, nil
+}
+
+// parseEvent parses "event Name" and its clauses, one per line: the
+// payload record, the channel and why
+func (p *parser) parseEvent() (*Event, error) {
+	pos := p.position()
+	leading := p.leadingComments(pos)
+
+	if err := p.expectKeyword("event"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.Ident {
+		return nil, p.errorf(CodeSyntax, "expected event name, got %q", p.scanner.TokenText())
+	}
+	event := &Event{
+		Name:     p.scanner.TokenText(),
+		Leading:  leading,
+		Position: pos,
+	}
+	p.next()
+	event.Trailing = p.trailingComments(p.end())
+
+	for p.tok == scanner.Ident && !p.atTopLevelKeyword() {
+		clausePos := p.position()
+		switch clause := p.scanner.TokenText(); clause {
+		case "payload":
+			if event.Payload != nil {
+				return nil, p.errorf(CodeDuplicate, "event %s already has a payload", event.Name).
+					Suggest("an event carries one record")
+			}
 			p.next()
 			if err := p.expect(':', "':'"); err != nil {
 				return nil, err
 			}
-			if p.tok != scanner.String {
-				return nil, fmt.Errorf("expected string after 'why:', got %q at %s", p.scanner.TokenText(), p.position())
+			payload, err := p.parseType()
+			if err != nil {
+				return nil, err
+			}
+			event.Payload = payload
+		case "channel":
+			if event.Channel != "" {
+				return nil, p.errorf(CodeDuplicate, "event %s already has a channel", event.Name)
 			}
-			typeDef.Why = strings.Trim(p.scanner.TokenText(), `"`)
-			p.next()
-		case "validate":
 			p.next()
 			if err := p.expect(':', "':'"); err != nil {
 				return nil, err
 			}
-			if p.tok == scanner.String {
-				typeDef.Validation["rule"] = strings.Trim(p.scanner.TokenText(), `"`)
-				p.next()
+			if p.tok != scanner.String {
+				return nil, p.errorf(CodeSyntax, "expected string after 'channel:', got %q", p.scanner.TokenText()).
+					Suggest("write the channel in quotes, such as channel: \"users.created\"")
+			}
+			event.Channel = strings.Trim(p.scanner.TokenText(), `"`)
+			if event.Channel == "" {
+				return nil, p.errorAt(clausePos, CodeSyntax, "the channel of event %s is empty", event.Name).Until(p.end())
+			}
+			p.next()
+		case "why":
+			if err := p.parseWhy(&event.Why, &event.Whys); err != nil {
+				return nil, err
 			}
 		default:
-			// Not a type definition clause, break out
-			return typeDef, nil
+			return nil, p.errorf(CodeUnknownKeyword, "unknown event clause %q", clause).
+				Suggest("an event declares payload:, channel: and why:")
 		}
 	}
+	if event.Payload == nil {
+		return nil, p.errorAt(pos, CodeSyntax, "event %s has no payload", event.Name).
+			Until(p.end()).
+			Suggest("add payload: <Record> naming the record it carries")
+	}
 
-	return typeDef, nil
+	event.End = p.end()
+	return event, nil
+}
+
+// parseRole parses "role name" and its optional why
+func (p *parser) parseRole() (*Role, error) {
+	pos := p.position()
+	leading := p.leadingComments(pos)
+
+	if err := p.expectKeyword("role"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.Ident {
+		return nil, p.errorf(CodeSyntax, "expected role name, got %q", p.scanner.TokenText())
+	}
+	role := &Role{Name: p.scanner.TokenText(), Leading: leading, Position: pos}
+	p.next()
+	role.Trailing = p.trailingComments(p.end())
+
+	for p.tok == scanner.Ident && p.scanner.TokenText() == "why" {
+		if err := p.parseWhy(&role.Why, &role.Whys); err != nil {
+			return nil, err
+		}
+	}
+	role.End = p.end()
+	return role, nil
+}
+
+// parseAllow parses "allow role to action[, action...] Record", all on the
+// line of the keyword
+func (p *parser) parseAllow() (*Allow, error) {
+	pos := p.position()
+	leading := p.leadingComments(pos)
+
+	if err := p.expectKeyword("allow"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.Ident {
+		return nil, p.errorf(CodeSyntax, "expected role name after 'allow', got %q", p.scanner.TokenText())
+	}
+	allow := &Allow{Role: p.scanner.TokenText(), Leading: leading, Position: pos}
+	p.next()
+	if err := p.expectKeyword("to"); err != nil {
+		return nil, err
+	}
+	for {
+		if p.tok != scanner.Ident || p.scanner.Position.Line != p.prevLine {
+			return nil, p.errorf(CodeSyntax, "expected an action such as read or delete, got %q", p.scanner.TokenText()).
+				Suggest("write allow %s to delete Order", allow.Role)
+		}
+		action := p.scanner.TokenText()
+		for _, declared := range allow.Actions {
+			if declared == action {
+				return nil, p.errorf(CodeDuplicate, "action %s is given twice", action)
+			}
+		}
+		allow.Actions = append(allow.Actions, action)
+		p.next()
+		if p.tok != ',' {
+			break
+		}
+		p.next()
+	}
+	if p.tok != scanner.Ident || p.scanner.Position.Line != p.prevLine {
+		return nil, p.errorf(CodeSyntax, "expected the record %s may %s, got %q", allow.Role, strings.Join(allow.Actions, ", "), p.scanner.TokenText()).
+			Suggest("name the record after the actions, such as allow %s to %s Order", allow.Role, strings.Join(allow.Actions, ", "))
+	}
+	allow.Resource = p.scanner.TokenText()
+	p.next()
+	allow.End = p.end()
+	return allow, nil
 }
 
 func (p *parser) parseFunction() (*Function, error) {
 	pos := p.position()
+	leading := p.leadingComments(pos)
 
 	if err := p.expectKeyword("function"); err != nil {
 		return nil, err
 	}
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected function name, got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected function name, got %q", p.scanner.TokenText())
 	}
 
 	name := p.scanner.TokenText()
@@ -321,6 +986,7 @@ func (p *parser) parseFunction() (*Function, error) {
 	function := &Function{
 		Name:          name,
 		Parameters:    parameters,
+		Leading:       leading,
 		Position:      pos,
 		AIAnnotations: []*AIAnnotation{},
 	}
@@ -334,68 +1000,215 @@ func (p *parser) parseFunction() (*Function, error) {
 		}
 		function.ReturnType = returnType
 	}
+	function.Trailing = p.trailingComments(p.end())
 
-	// Parse AI annotations
-	for p.tok == scanner.Ident && isAIAnnotation(p.scanner.TokenText()) {
-		annotation, err := p.parseAIAnnotation()
+	// Parse header declarations and requires clauses
+	for p.tok == scanner.Ident && (p.scanner.TokenText() == "header" || p.scanner.TokenText() == "requires") {
+		if p.scanner.TokenText() == "requires" {
+			if err := p.parseRequires(function); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		header, err := p.parseHeaderDecl()
 		if err != nil {
 			return nil, err
 		}
-		function.AIAnnotations = append(function.AIAnnotations, annotation)
+		function.Headers = append(function.Headers, header)
+	}
+
+	// Parse AI annotations
+	if err := p.parseAIAnnotations(function); err != nil {
+		return nil, err
+	}
+
+	// Parse why clause, once per locale
+	if p.tok != scanner.Ident || p.scanner.TokenText() != "why" {
+		return nil, p.errorf(CodeSyntax, "expected 'why', got %q", p.scanner.TokenText())
+	}
+	for p.tok == scanner.Ident && p.scanner.TokenText() == "why" {
+		if err := p.parseWhy(&function.Why, &function.Whys); err != nil {
+			return nil, err
+		}
+	}
+
+	// Annotations may also follow the why clause they comment on
+	if err := p.parseAIAnnotations(function); err != nil {
+		return nil, err
+	}
+
+	// Parse function body
+	if err := p.expectKeyword("do"); err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(':', "':'"); err != nil {
+		return nil, err
+	}
+
+	body, err := p.parseFunctionBody()
+	if err != nil {
+		return nil, err
+	}
+
+	function.Body = body
+	function.End = p.end()
+
+	return function, nil
+}
+
+// parseWhy parses `why: "..."` or a translation such as `why.es: "..."`.
+// A translation is stored in whys under its locale; the untranslated why
+// is kept in why, or the first translation when there is none.
+func (p *parser) parseWhy(why *string, whys *map[string]string) error {
+	p.next() // consume 'why'
+	locale := ""
+	if p.tok == '.' {
+		p.next()
+		if p.tok != scanner.Ident {
+			return p.errorf(CodeSyntax, "expected locale after 'why.', got %q", p.scanner.TokenText())
+		}
+		locale = p.scanner.TokenText()
+		p.next()
+	}
+	if err := p.expect(':', "':'"); err != nil {
+		return err
+	}
+	if p.tok != scanner.String {
+		return p.errorf(CodeSyntax, "expected string after 'why:', got %q", p.scanner.TokenText())
 	}
+	text := strings.Trim(p.scanner.TokenText(), `"`)
+	p.next()
 
-	// Parse why clause
-	if err := p.expectKeyword("why"); err != nil {
-		return nil, err
+	if locale == "" {
+		*why = text
+		return nil
+	}
+	if *whys == nil {
+		*whys = make(map[string]string)
+	}
+	if _, ok := (*whys)[locale]; ok {
+		return p.errorf(CodeDuplicate, "why.%s is declared twice", locale)
 	}
+	if len(*whys) == 0 && *why == "" {
+		*why = text
+	}
+	(*whys)[locale] = text
+	return nil
+}
 
-	if err := p.expect(':', "':'"); err != nil {
+// parseHeaderDecl parses "header: X-Tenant-ID required". Header names scan
+// as words separated by '-' tokens; the direction defaults to optional and
+// must share the name's line, otherwise it starts the next clause.
+func (p *parser) parseHeaderDecl() (*HeaderDecl, error) {
+	pos := p.position()
+	p.next() // consume 'header'
+	if err := p.expect(':', "':' after 'header'"); err != nil {
 		return nil, err
 	}
 
-	if p.tok != scanner.String {
-		return nil, fmt.Errorf("expected string after 'why:', got %q at %s", p.scanner.TokenText(), p.position())
+	if p.tok != scanner.Ident {
+		return nil, p.errorf(CodeSyntax, "expected header name, got %q", p.scanner.TokenText())
 	}
-
-	function.Why = strings.Trim(p.scanner.TokenText(), `"`)
+	name := p.scanner.TokenText()
 	p.next()
-
-	// Parse function body
-	if err := p.expectKeyword("do"); err != nil {
-		return nil, err
+	for p.tok == '-' {
+		p.next()
+		if p.tok != scanner.Ident && p.tok != scanner.Int {
+			return nil, p.errorf(CodeSyntax, "expected header name after '-', got %q", p.scanner.TokenText())
+		}
+		name += "-" + p.scanner.TokenText()
+		p.next()
 	}
 
-	if err := p.expect(':', "':'"); err != nil {
-		return nil, err
+	header := &HeaderDecl{Name: name, Direction: HeaderOptional, Position: pos}
+	if p.tok == scanner.Ident && p.scanner.Position.Line == p.prevLine {
+		switch direction := p.scanner.TokenText(); direction {
+		case HeaderRequired, HeaderOptional, HeaderEmitted:
+			header.Direction = direction
+			p.next()
+		default:
+			return nil, p.errorf(CodeUnknownKeyword, "unknown header direction %q (expected %q, %q or %q)",
+				direction, HeaderRequired, HeaderOptional, HeaderEmitted)
+		}
 	}
+	header.End = p.end()
+	return header, nil
+}
 
-	body, err := p.parseFunctionBody()
-	if err != nil {
-		return nil, err
+// parseRequires parses "requires auth" or "requires role admin" into the
+// access rule of function. Both parts share the line of the keyword.
+func (p *parser) parseRequires(function *Function) error {
+	pos := p.position()
+	p.next() // consume 'requires'
+	if p.tok != scanner.Ident || p.scanner.Position.Line != p.prevLine {
+		return p.errorf(CodeSyntax, "expected 'auth' or 'role' after 'requires'")
+	}
+	if function.Access == nil {
+		function.Access = &Access{Position: pos}
 	}
 
-	function.Body = body
+	switch what := p.scanner.TokenText(); what {
+	case "auth":
+		p.next()
+	case "role":
+		p.next()
+		if p.tok != scanner.Ident || p.scanner.Position.Line != p.prevLine {
+			return p.errorf(CodeSyntax, "expected a role name after 'requires role'")
+		}
+		role := p.scanner.TokenText()
+		for _, declared := range function.Access.Roles {
+			if declared == role {
+				return p.errorf(CodeDuplicate, "role %s is required twice", role)
+			}
+		}
+		function.Access.Roles = append(function.Access.Roles, role)
+		p.next()
+	default:
+		return p.errorf(CodeUnknownKeyword, "unknown requirement %q (expected 'auth' or 'role')", what)
+	}
+	function.Access.End = p.end()
+	return nil
+}
 
-	return function, nil
+func (p *parser) parseAIAnnotations(function *Function) error {
+	for p.tok == scanner.Ident && p.scanner.TokenText() == "ai" && p.scanner.Peek() == '-' {
+		annotation, err := p.parseAIAnnotation()
+		if err != nil {
+			return err
+		}
+		function.AIAnnotations = append(function.AIAnnotations, annotation)
+	}
+	return nil
 }
 
+// parseAIAnnotation parses `ai-feedback: "..."`. The keyword scans as words
+// separated by '-' tokens, like header names.
 func (p *parser) parseAIAnnotation() (*AIAnnotation, error) {
 	pos := p.position()
 
-	if !isAIAnnotation(p.scanner.TokenText()) {
-		return nil, fmt.Errorf("expected AI annotation, got %q at %s", p.scanner.TokenText(), p.position())
-	}
-
-	annotationType := strings.TrimPrefix(p.scanner.TokenText(), "ai-")
-	annotationType = strings.TrimSuffix(annotationType, ":")
+	keyword := p.scanner.TokenText()
 	p.next()
+	for p.tok == '-' {
+		p.next()
+		if p.tok != scanner.Ident {
+			return nil, p.errorf(CodeSyntax, "expected AI annotation, got %q", keyword+"-"+p.scanner.TokenText())
+		}
+		keyword += "-" + p.scanner.TokenText()
+		p.next()
+	}
+	if !isAIAnnotation(keyword) {
+		return nil, p.errorAt(pos, CodeUnknownKeyword, "unknown AI annotation %q", keyword).
+			Suggest("use one of %s", strings.Join(aiAnnotations, ", "))
+	}
+	annotationType := strings.TrimPrefix(keyword, "ai-")
 
 	if err := p.expect(':', "':'"); err != nil {
 		return nil, err
 	}
 
 	if p.tok != scanner.String {
-		return nil, fmt.Errorf("expected string after AI annotation, got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected string after AI annotation, got %q", p.scanner.TokenText())
 	}
 
 	content := strings.Trim(p.scanner.TokenText(), `"`)
@@ -405,6 +1218,7 @@ func (p *parser) parseAIAnnotation() (*AIAnnotation, error) {
 		Type:     annotationType,
 		Content:  content,
 		Position: pos,
+		End:      p.end(),
 	}, nil
 }
 
@@ -418,7 +1232,7 @@ func (p *parser) parseFunctionBody() (*FunctionBody, error) {
 	}
 
 	// Parse statements until we hit EOF or a top-level keyword
-	for p.tok != scanner.EOF && !(p.tok == scanner.Ident && isTopLevelKeyword(p.scanner.TokenText())) {
+	for p.tok != scanner.EOF && !p.atTopLevelKeyword() {
 		// Check for native blocks
 		if p.tok == scanner.Ident && (p.scanner.TokenText() == "go-native" || p.scanner.TokenText() == "ts-native") {
 			nativeBlock, err := p.parseNativeBlock()
@@ -439,6 +1253,7 @@ func (p *parser) parseFunctionBody() (*FunctionBody, error) {
 		}
 	}
 
+	body.End = p.end()
 	return body, nil
 }
 
@@ -454,6 +1269,8 @@ func (p *parser) parseStatement() (Statement, error) {
 		return p.parseCreateStatement()
 	case p.tok == scanner.Ident && p.scanner.TokenText() == "fail":
 		return p.parseFailStatement()
+	case p.tok == scanner.Ident && p.scanner.TokenText() == "expect":
+		return p.parseExpectStatement()
 	case p.tok == scanner.Ident && p.scanner.TokenText() == "use":
 		// Handle "use SHA256 algorithm" style statements
 		return p.parseUseStatement()
@@ -501,6 +1318,7 @@ func (p *parser) parseIfStatement() (*IfStatement, error) {
 		ifStmt.ElseStmt = elseStmt
 	}
 
+	ifStmt.End = p.end()
 	return ifStmt, nil
 }
 
@@ -511,9 +1329,9 @@ func (p *parser) parseReturnStatement() (*ReturnStatement, error) {
 		return nil, err
 	}
 
-	// Optional return value
+	// Optional return value, which starts on the line of the keyword
 	var value Expression
-	if p.tok != scanner.EOF && !(p.tok == scanner.Ident && isStatementKeyword(p.scanner.TokenText())) {
+	if p.tok != scanner.EOF && p.scanner.Position.Line == p.prevLine && !(p.tok == scanner.Ident && isStatementKeyword(p.scanner.TokenText())) {
 		var err error
 		value, err = p.parseExpression()
 		if err != nil {
@@ -524,6 +1342,7 @@ func (p *parser) parseReturnStatement() (*ReturnStatement, error) {
 	return &ReturnStatement{
 		Value:    value,
 		Position: pos,
+		End:      p.end(),
 	}, nil
 }
 
@@ -535,7 +1354,7 @@ func (p *parser) parseSetStatement() (*AssignStatement, error) {
 	}
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected variable name after 'set', got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected variable name after 'set', got %q", p.scanner.TokenText())
 	}
 
 	variable := p.scanner.TokenText()
@@ -554,6 +1373,7 @@ func (p *parser) parseSetStatement() (*AssignStatement, error) {
 		Variable: variable,
 		Value:    value,
 		Position: pos,
+		End:      p.end(),
 	}, nil
 }
 
@@ -565,7 +1385,7 @@ func (p *parser) parseCreateStatement() (*CreateStatement, error) {
 	}
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected type name after 'create', got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected type name after 'create', got %q", p.scanner.TokenText())
 	}
 
 	typeName := p.scanner.TokenText()
@@ -582,7 +1402,7 @@ func (p *parser) parseCreateStatement() (*CreateStatement, error) {
 	var assignments []*FieldAssignment
 
 	// Parse field assignments
-	for p.tok == scanner.Ident && !isStatementKeyword(p.scanner.TokenText()) && !isTopLevelKeyword(p.scanner.TokenText()) {
+	for p.tok == scanner.Ident && !isStatementKeyword(p.scanner.TokenText()) && !p.atTopLevelKeyword() {
 		fieldPos := p.position()
 		field := p.scanner.TokenText()
 		p.next()
@@ -595,39 +1415,384 @@ func (p *parser) parseCreateStatement() (*CreateStatement, error) {
 		if err != nil {
 			return nil, err
 		}
-
-		assignments = append(assignments, &FieldAssignment{
-			Field:    field,
-			Value:    value,
-			Position: fieldPos,
-		})
+
+		assignments = append(assignments, &FieldAssignment{
+			Field:    field,
+			Value:    value,
+			Position: fieldPos,
+			End:      p.end(),
+		})
+	}
+
+	return &CreateStatement{
+		TypeName:    typeName,
+		Assignments: assignments,
+		Position:    pos,
+		End:         p.end(),
+	}, nil
+}
+
+func (p *parser) parseFailStatement() (*FailStatement, error) {
+	pos := p.position()
+
+	if err := p.expectKeyword("fail"); err != nil {
+		return nil, err
+	}
+
+	code := ""
+	if p.tok == scanner.Ident {
+		code = p.scanner.TokenText()
+		known := false
+		for _, c := range FailCodes {
+			known = known || c == code
+		}
+		if !known {
+			return nil, p.errorf(CodeUnknownKeyword, "unknown error code %q (expected one of %s)", code, strings.Join(FailCodes, ", "))
+		}
+		p.next()
+	}
+
+	if p.tok != scanner.String {
+		return nil, p.errorf(CodeSyntax, "expected error message string after 'fail', got %q", p.scanner.TokenText())
+	}
+
+	message, err := strconv.Unquote(p.scanner.TokenText())
+	if err != nil {
+		return nil, p.errorf(CodeInvalidLiteral, "invalid string %s", p.scanner.TokenText())
+	}
+	p.next()
+
+	return &FailStatement{
+		Code:     code,
+		Message:  message,
+		Position: pos,
+		End:      p.end(),
+	}, nil
+}
+
+// parseTest parses `test "name":` and the statements that follow it
+func (p *parser) parseTest() (*Test, error) {
+	pos := p.position()
+	leading := p.leadingComments(pos)
+
+	if err := p.expectKeyword("test"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.String {
+		return nil, p.errorf(CodeSyntax, "expected test name string after 'test', got %q", p.scanner.TokenText())
+	}
+	name, err := strconv.Unquote(p.scanner.TokenText())
+	if err != nil {
+		return nil, p.errorf(CodeInvalidLiteral, "invalid string %s", p.scanner.TokenText())
+	}
+	p.next()
+	if err := p.expect(':', "':'"); err != nil {
+		return nil, err
+	}
+
+	test := &Test{Name: name, Statements: []Statement{}, Leading: leading, Position: pos}
+	for p.tok != scanner.EOF && !p.atTopLevelKeyword() {
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		if stmt != nil {
+			test.Statements = append(test.Statements, stmt)
+		}
+	}
+	test.End = p.end()
+	return test, nil
+}
+
+// parseSeed parses "seed Record: N records" and the "row:" blocks of
+// literal values that may follow it
+func (p *parser) parseSeed() (*Seed, error) {
+	pos := p.position()
+	leading := p.leadingComments(pos)
+
+	if err := p.expectKeyword("seed"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.Ident {
+		return nil, p.errorf(CodeSyntax, "expected the name of the record to seed, got %q", p.scanner.TokenText())
+	}
+	seed := &Seed{Record: p.scanner.TokenText(), Leading: leading, Position: pos}
+	p.next()
+	if err := p.expect(':', "':'"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.Int {
+		return nil, p.errorf(CodeSyntax, "expected the number of %s records to seed, got %q", seed.Record, p.scanner.TokenText()).
+			Suggest("write seed %s: 20 records", seed.Record)
+	}
+	count, err := strconv.Atoi(p.scanner.TokenText())
+	if err != nil {
+		return nil, p.errorf(CodeInvalidLiteral, "invalid record count %s", p.scanner.TokenText())
+	}
+	seed.Count = count
+	p.next()
+	if p.tok != scanner.Ident || (p.scanner.TokenText() != "records" && p.scanner.TokenText() != "record") {
+		return nil, p.errorf(CodeSyntax, "expected records after %d, got %q", count, p.scanner.TokenText()).
+			Suggest("write seed %s: %d records", seed.Record, count)
+	}
+	p.next()
+
+	for p.tok == scanner.Ident && p.scanner.TokenText() == "row" {
+		rowPos := p.position()
+		p.next()
+		if err := p.expect(':', "':'"); err != nil {
+			return nil, err
+		}
+		row, err := p.parseValues(rowPos, "row")
+		if err != nil {
+			return nil, err
+		}
+		seed.Rows = append(seed.Rows, row)
+	}
+	seed.End = p.end()
+	return seed, nil
+}
+
+// parseConfig parses "config name: type from env NAME" and its why. The
+// type takes the markers a field's does: optional, constraints and a
+// default. Without "from env" the variable is named after the setting.
+func (p *parser) parseConfig() (*Config, error) {
+	pos := p.position()
+	leading := p.leadingComments(pos)
+
+	if err := p.expectKeyword("config"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.Ident {
+		return nil, p.errorf(CodeSyntax, "expected setting name, got %q", p.scanner.TokenText())
+	}
+	config := &Config{Name: p.scanner.TokenText(), Leading: leading, Position: pos}
+	p.next()
+	if err := p.expect(':', "':'"); err != nil {
+		return nil, err
+	}
+
+	settingType, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	p.parseOptionalMarker(settingType)
+	if err := p.parseConstraints(settingType); err != nil {
+		return nil, err
+	}
+	p.parseOptionalMarker(settingType)
+	defaultValue, err := p.parseDefaultMarker()
+	if err != nil {
+		return nil, err
+	}
+	config.Type = settingType
+	config.Default = defaultValue
+
+	if p.tok == scanner.Ident && p.scanner.TokenText() == "from" && p.scanner.Position.Line == p.prevLine {
+		p.next()
+		if err := p.expectKeyword("env"); err != nil {
+			return nil, err
+		}
+		if p.tok != scanner.Ident {
+			return nil, p.errorf(CodeSyntax, "expected an environment variable after 'from env', got %q", p.scanner.TokenText()).
+				Suggest("name the variable as it is set, such as from env DATABASE_URL")
+		}
+		config.Env = p.scanner.TokenText()
+		p.next()
+	}
+	config.Trailing = p.trailingComments(p.end())
+
+	if p.tok != scanner.Ident || p.scanner.TokenText() != "why" {
+		return nil, p.errorf(CodeSyntax, "expected 'why', got %q", p.scanner.TokenText()).
+			Suggest("say what the setting is for, such as why: \"Orders are kept in PostgreSQL\"")
+	}
+	for p.tok == scanner.Ident && p.scanner.TokenText() == "why" {
+		if err := p.parseWhy(&config.Why, &config.Whys); err != nil {
+			return nil, err
+		}
+	}
+	config.End = p.end()
+	return config, nil
+}
+
+// parseSchedule parses "schedule function every N unit" and its required
+// why. The unit may be singular or plural.
+func (p *parser) parseSchedule() (*Schedule, error) {
+	pos := p.position()
+	leading := p.leadingComments(pos)
+
+	if err := p.expectKeyword("schedule"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.Ident {
+		return nil, p.errorf(CodeSyntax, "expected the function to schedule, got %q", p.scanner.TokenText())
+	}
+	schedule := &Schedule{Function: p.scanner.TokenText(), Leading: leading, Position: pos}
+	p.next()
+	if err := p.expectKeyword("every"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.Int {
+		return nil, p.errorf(CodeSyntax, "expected a whole number after 'every', got %q", p.scanner.TokenText()).
+			Suggest("write schedule %s every 1 hour", schedule.Function)
+	}
+	every, err := strconv.ParseInt(p.scanner.TokenText(), 10, 64)
+	if err != nil || every < 1 {
+		return nil, p.errorf(CodeInvalidLiteral, "the interval of schedule %s must be at least 1, got %s", schedule.Function, p.scanner.TokenText())
+	}
+	schedule.Every = every
+	p.next()
+	unit := strings.TrimSuffix(p.scanner.TokenText(), "s")
+	if _, ok := ScheduleUnits[unit]; p.tok != scanner.Ident || !ok {
+		return nil, p.errorf(CodeUnknownKeyword, "unknown interval unit %q (expected seconds, minutes, hours or days)", p.scanner.TokenText())
+	}
+	schedule.Unit = unit
+	p.next()
+	schedule.Trailing = p.trailingComments(p.end())
+
+	if p.tok != scanner.Ident || p.scanner.TokenText() != "why" {
+		return nil, p.errorf(CodeSyntax, "expected 'why', got %q", p.scanner.TokenText()).
+			Suggest("say why the function runs on a schedule, such as why: \"Expired sessions are removed\"")
+	}
+	for p.tok == scanner.Ident && p.scanner.TokenText() == "why" {
+		if err := p.parseWhy(&schedule.Why, &schedule.Whys); err != nil {
+			return nil, err
+		}
+	}
+	schedule.End = p.end()
+	return schedule, nil
+}
+
+// parseQueue parses "queue name handles Record" and its optional why
+func (p *parser) parseQueue() (*Queue, error) {
+	pos := p.position()
+	leading := p.leadingComments(pos)
+
+	if err := p.expectKeyword("queue"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.Ident {
+		return nil, p.errorf(CodeSyntax, "expected queue name, got %q", p.scanner.TokenText())
+	}
+	queue := &Queue{Name: p.scanner.TokenText(), Leading: leading, Position: pos}
+	p.next()
+	if err := p.expectKeyword("handles"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.Ident || p.scanner.Position.Line != p.prevLine {
+		return nil, p.errorf(CodeSyntax, "expected the record queue %s handles, got %q", queue.Name, p.scanner.TokenText()).
+			Suggest("queue %s handles SendEmailTask", queue.Name)
+	}
+	queue.Payload = p.scanner.TokenText()
+	p.next()
+	queue.Trailing = p.trailingComments(p.end())
+
+	for p.tok == scanner.Ident && p.scanner.TokenText() == "why" {
+		if err := p.parseWhy(&queue.Why, &queue.Whys); err != nil {
+			return nil, err
+		}
+	}
+	queue.End = p.end()
+	return queue, nil
+}
+
+// parseWorkflow parses "workflow name:" and its steps, separated by
+// "then" on one line or several, followed by a required why
+func (p *parser) parseWorkflow() (*Workflow, error) {
+	pos := p.position()
+	leading := p.leadingComments(pos)
+
+	if err := p.expectKeyword("workflow"); err != nil {
+		return nil, err
+	}
+	if p.tok != scanner.Ident {
+		return nil, p.errorf(CodeSyntax, "expected workflow name, got %q", p.scanner.TokenText())
+	}
+	workflow := &Workflow{Name: p.scanner.TokenText(), Leading: leading, Position: pos}
+	p.next()
+	if err := p.expect(':', "':'"); err != nil {
+		return nil, err
+	}
+
+	for {
+		stepPos := p.position()
+		if err := p.expectKeyword("step"); err != nil {
+			return nil, err
+		}
+		if p.tok != scanner.Ident {
+			return nil, p.errorf(CodeSyntax, "expected the function of the step, got %q", p.scanner.TokenText())
+		}
+		step := &WorkflowStep{Function: p.scanner.TokenText(), Position: stepPos}
+		p.next()
+		if p.tok == scanner.Ident && p.scanner.TokenText() == "on" && p.scanner.Position.Line == p.prevLine {
+			p.next()
+			if err := p.expectKeyword("failure"); err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("compensate"); err != nil {
+				return nil, err
+			}
+			if p.tok != scanner.Ident {
+				return nil, p.errorf(CodeSyntax, "expected the function that compensates, got %q", p.scanner.TokenText()).
+					Suggest("on failure compensate deleteAccount")
+			}
+			step.Compensate = p.scanner.TokenText()
+			p.next()
+		}
+		step.End = p.end()
+		workflow.Steps = append(workflow.Steps, step)
+		if p.tok != scanner.Ident || p.scanner.TokenText() != "then" {
+			break
+		}
+		p.next()
+	}
+	workflow.Trailing = p.trailingComments(p.end())
+
+	if p.tok != scanner.Ident || p.scanner.TokenText() != "why" {
+		return nil, p.errorf(CodeSyntax, "expected 'then' or 'why', got %q", p.scanner.TokenText()).
+			Suggest("say what the workflow achieves, such as why: \"New users get an account and a greeting\"")
+	}
+	for p.tok == scanner.Ident && p.scanner.TokenText() == "why" {
+		if err := p.parseWhy(&workflow.Why, &workflow.Whys); err != nil {
+			return nil, err
+		}
 	}
-
-	return &CreateStatement{
-		TypeName:    typeName,
-		Assignments: assignments,
-		Position:    pos,
-	}, nil
+	workflow.End = p.end()
+	return workflow, nil
 }
 
-func (p *parser) parseFailStatement() (*FailStatement, error) {
+// parseExpectStatement parses "expect X", "expect X is Y" and
+// "expect X fails ["message"]"
+func (p *parser) parseExpectStatement() (*ExpectStatement, error) {
 	pos := p.position()
 
-	if err := p.expectKeyword("fail"); err != nil {
+	if err := p.expectKeyword("expect"); err != nil {
+		return nil, err
+	}
+	value, err := p.parseExpression()
+	if err != nil {
 		return nil, err
 	}
+	stmt := &ExpectStatement{Value: value, Position: pos}
 
-	if p.tok != scanner.String {
-		return nil, fmt.Errorf("expected error message string after 'fail', got %q at %s", p.scanner.TokenText(), p.position())
+	// The expression parser reads "expect total is 10" as a comparison
+	if binary, ok := value.(*BinaryExpression); ok && binary.Operator == "is" {
+		stmt.Value, stmt.Expected = binary.Left, binary.Right
 	}
 
-	message := strings.Trim(p.scanner.TokenText(), `"`)
-	p.next()
+	if p.tok == scanner.Ident && p.scanner.TokenText() == "fails" {
+		p.next()
+		stmt.Fails = true
+		if p.tok == scanner.String && p.scanner.Position.Line == p.prevLine {
+			if stmt.Message, err = strconv.Unquote(p.scanner.TokenText()); err != nil {
+				return nil, p.errorf(CodeInvalidLiteral, "invalid string %s", p.scanner.TokenText())
+			}
+			p.next()
+		}
+	}
 
-	return &FailStatement{
-		Message:  message,
-		Position: pos,
-	}, nil
+	stmt.End = p.end()
+	return stmt, nil
 }
 
 func (p *parser) parseUseStatement() (*AssignStatement, error) {
@@ -650,15 +1815,120 @@ func (p *parser) parseUseStatement() (*AssignStatement, error) {
 	return &AssignStatement{
 		Variable: "__use__",
 		Value: &LiteralExpression{
+			Kind:     LiteralString,
 			Value:    useExpr,
 			Position: pos,
+			End:      p.end(),
 		},
 		Position: pos,
+		End:      p.end(),
 	}, nil
 }
 
 func (p *parser) parseExpression() (Expression, error) {
-	return p.parseComparison()
+	return p.parseConditional()
+}
+
+// parseConditional handles "value if condition else other". The 'if' must be
+// on the same line as the value so it is not confused with an if statement.
+func (p *parser) parseConditional() (Expression, error) {
+	value, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok != scanner.Ident || p.scanner.TokenText() != "if" || p.scanner.Position.Line != p.prevLine {
+		return value, nil
+	}
+	p.next()
+
+	condition, err := p.parseDefault()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("else"); err != nil {
+		return nil, err
+	}
+
+	other, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConditionalExpression{
+		Condition: condition,
+		Then:      value,
+		Else:      other,
+		Position:  value.GetPosition(),
+		End:       p.end(),
+	}, nil
+}
+
+// parseQuery handles collection transforms: "users where age >= 18 select email".
+// Both clauses are optional, but 'where' must come before 'select'.
+func (p *parser) parseQuery() (Expression, error) {
+	source, err := p.parseDefault()
+	if err != nil {
+		return nil, err
+	}
+	return p.parseQueryClauses(source)
+}
+
+// parseQueryClauses parses optional 'where' and 'select' clauses applied to source
+func (p *parser) parseQueryClauses(source Expression) (Expression, error) {
+	var err error
+	if p.tok != scanner.Ident || (p.scanner.TokenText() != "where" && p.scanner.TokenText() != "select") {
+		return source, nil
+	}
+
+	query := &QueryExpression{
+		Source:   source,
+		Position: source.GetPosition(),
+	}
+
+	if p.scanner.TokenText() == "where" {
+		p.next()
+		query.Filter, err = p.parseDefault()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.tok == scanner.Ident && p.scanner.TokenText() == "select" {
+		p.next()
+		query.Select, err = p.parseDefault()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query.End = p.end()
+	return query, nil
+}
+
+// parseDefault handles "value or fallback", the lowest precedence operator
+func (p *parser) parseDefault() (Expression, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok == scanner.Ident && p.scanner.TokenText() == "or" {
+		p.next()
+		fallback, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = &DefaultExpression{
+			Value:    left,
+			Fallback: fallback,
+			Position: left.GetPosition(),
+			End:      p.end(),
+		}
+	}
+
+	return left, nil
 }
 
 func (p *parser) parseComparison() (Expression, error) {
@@ -669,14 +1939,32 @@ func (p *parser) parseComparison() (Expression, error) {
 
 	// Handle comparison operators
 	for p.tok == '<' || p.tok == '>' || p.tok == '=' ||
-		(p.tok == scanner.Ident && (p.scanner.TokenText() == "contains" || p.scanner.TokenText() == "not")) {
+		(p.tok == scanner.Ident && (p.scanner.TokenText() == "contains" || p.scanner.TokenText() == "in" || p.scanner.TokenText() == "not" || p.scanner.TokenText() == "is")) {
 
 		var operator string
 		if p.tok == scanner.Ident {
-			if p.scanner.TokenText() == "not" {
+			if p.scanner.TokenText() == "is" {
+				// "is empty", "is not empty", "is none", "is x" and "is not x"
+				p.next()
+				operator = "is"
+				if p.tok == scanner.Ident && p.scanner.TokenText() == "not" {
+					operator = "is not"
+					p.next()
+				}
+				if p.tok == scanner.Ident && p.scanner.TokenText() == "empty" {
+					p.next()
+					left = &EmptyExpression{
+						Value:    left,
+						Negated:  operator == "is not",
+						Position: left.GetPosition(),
+						End:      p.end(),
+					}
+					continue
+				}
+			} else if p.scanner.TokenText() == "not" {
 				p.next()
-				if p.tok == scanner.Ident && p.scanner.TokenText() == "contains" {
-					operator = "not contains"
+				if p.tok == scanner.Ident && (p.scanner.TokenText() == "contains" || p.scanner.TokenText() == "in") {
+					operator = "not " + p.scanner.TokenText()
 					p.next()
 				} else {
 					operator = "not"
@@ -688,6 +1976,11 @@ func (p *parser) parseComparison() (Expression, error) {
 		} else {
 			operator = string(rune(p.tok))
 			p.next()
+			// Two-character comparisons: ">=" and "<="
+			if (operator == "<" || operator == ">") && p.tok == '=' {
+				operator += "="
+				p.next()
+			}
 		}
 
 		right, err := p.parsePrimary()
@@ -700,6 +1993,7 @@ func (p *parser) parseComparison() (Expression, error) {
 			Operator: operator,
 			Right:    right,
 			Position: left.GetPosition(),
+			End:      p.end(),
 		}
 	}
 
@@ -714,89 +2008,196 @@ func (p *parser) parsePrimary() (Expression, error) {
 		name := p.scanner.TokenText()
 		p.next()
 
-		// Check for member access (user.email)
-		if p.tok == '.' {
-			p.next()
-			if p.tok != scanner.Ident {
-				return nil, fmt.Errorf("expected property name after '.', got %q at %s", p.scanner.TokenText(), p.position())
-			}
-			property := p.scanner.TokenText()
-			p.next()
-			return &MemberExpression{
-				Object: &IdentifierExpression{
-					Name:     name,
-					Position: pos,
-				},
-				Property: property,
-				Position: pos,
-			}, nil
-		}
-
 		// Check for function call (functionName())
 		if p.tok == '(' {
-			p.next()
-			var args []Expression
-
-			// Parse arguments
-			if p.tok != ')' {
-				for {
-					arg, err := p.parseExpression()
-					if err != nil {
-						return nil, err
-					}
-					args = append(args, arg)
-
-					if p.tok != ',' {
-						break
-					}
-					p.next() // consume comma
-				}
-			}
-
-			if err := p.expect(')', "')'"); err != nil {
+			args, err := p.parseArguments()
+			if err != nil {
 				return nil, err
 			}
 
-			return &CallExpression{
+			return p.parseMemberAccess(&CallExpression{
 				Function:  name,
 				Arguments: args,
 				Position:  pos,
-			}, nil
+				End:       p.end(),
+			})
+		}
+
+		if isAggregateFunction(name) && p.tok == scanner.Ident && p.scanner.TokenText() == "of" {
+			return p.parseAggregate(name, pos)
+		}
+
+		if name == "true" || name == "false" {
+			return &LiteralExpression{Kind: LiteralBool, Value: name == "true", Position: pos, End: p.end()}, nil
+		}
+		if name == "none" {
+			return &LiteralExpression{Kind: LiteralNone, Position: pos, End: p.end()}, nil
 		}
 
-		// Simple identifier
-		return &IdentifierExpression{
+		// Simple identifier, possibly followed by member access (user.email)
+		return p.parseMemberAccess(&IdentifierExpression{
 			Name:     name,
 			Position: pos,
-		}, nil
+			End:      p.end(),
+		})
 
 	case scanner.String:
-		value := strings.Trim(p.scanner.TokenText(), `"`)
+		value, err := strconv.Unquote(p.scanner.TokenText())
+		if err != nil {
+			return nil, p.errorAt(pos, CodeInvalidLiteral, "invalid string %s", p.scanner.TokenText())
+		}
 		p.next()
 		return &LiteralExpression{
+			Kind:     LiteralString,
 			Value:    value,
 			Position: pos,
+			End:      p.end(),
 		}, nil
 
 	case scanner.Int:
-		value := p.scanner.TokenText()
+		value, err := strconv.ParseInt(p.scanner.TokenText(), 0, 64)
+		if err != nil {
+			return nil, p.errorAt(pos, CodeInvalidLiteral, "invalid whole number %s", p.scanner.TokenText())
+		}
 		p.next()
-		// Convert to int - simplified for now
 		return &LiteralExpression{
+			Kind:     LiteralInt,
 			Value:    value,
 			Position: pos,
+			End:      p.end(),
 		}, nil
 
 	case scanner.Float:
-		value := p.scanner.TokenText()
+		value, err := strconv.ParseFloat(p.scanner.TokenText(), 64)
+		if err != nil {
+			return nil, p.errorAt(pos, CodeInvalidLiteral, "invalid number %s", p.scanner.TokenText())
+		}
 		p.next()
 		return &LiteralExpression{
+			Kind:     LiteralFloat,
 			Value:    value,
 			Position: pos,
+			End:      p.end(),
 		}, nil
 
 	default:
-		return nil, fmt.Errorf("unexpected token in expression: %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "unexpected token in expression: %q", p.scanner.TokenText())
+	}
+}
+
+// parseAggregate parses the operand of "sum of items.price". The operand may
+// carry its own where/select clauses: "count of users where active".
+func (p *parser) parseAggregate(function string, pos *Position) (Expression, error) {
+	p.next() // consume 'of'
+
+	operand, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := p.parseQueryClauses(operand)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregate := &AggregateExpression{
+		Function: function,
+		Source:   source,
+		Position: pos,
+	}
+
+	if p.tok == scanner.Ident && p.scanner.TokenText() == "round" && p.scanner.Peek() == ':' {
+		aggregate.Rounding, err = p.parseRounding()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	aggregate.End = p.end()
+	return aggregate, nil
+}
+
+func isAggregateFunction(name string) bool {
+	switch name {
+	case "count", "sum", "average", "length", "first", "last":
+		return true
+	}
+	return false
+}
+
+// parseArguments parses the parenthesized arguments of a call
+func (p *parser) parseArguments() ([]Expression, error) {
+	p.next() // consume '('
+	var args []Expression
+
+	if p.tok != ')' {
+		for {
+			arg, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if p.tok != ',' {
+				break
+			}
+			p.next() // consume comma
+		}
+	}
+
+	if err := p.expect(')', "')'"); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// parseMemberAccess parses a chain of '.' and '?.' property accesses on
+// object, such as "order.customer.email". A property followed by arguments is
+// a method-style call: "order.customer.discount(10)" calls
+// discount(order.customer, 10).
+func (p *parser) parseMemberAccess(object Expression) (Expression, error) {
+	for {
+		safe := false
+		if p.tok == '?' && p.scanner.Peek() == '.' {
+			safe = true
+			p.next()
+		} else if p.tok != '.' {
+			return object, nil
+		}
+		p.next()
+
+		if p.tok != scanner.Ident {
+			return nil, p.errorf(CodeSyntax, "expected property name after '.', got %q", p.scanner.TokenText())
+		}
+		property := p.scanner.TokenText()
+		propertyPos := p.position()
+		p.next()
+
+		if p.tok == '(' {
+			if safe {
+				return nil, p.errorAt(propertyPos, CodeSyntax, "'?.' cannot call %s; check the value with 'is none' first", property)
+			}
+			args, err := p.parseArguments()
+			if err != nil {
+				return nil, err
+			}
+			object = &CallExpression{
+				Function:  property,
+				Arguments: append([]Expression{object}, args...),
+				Method:    true,
+				Position:  object.GetPosition(),
+				End:       p.end(),
+			}
+			continue
+		}
+
+		object = &MemberExpression{
+			Object:   object,
+			Property: property,
+			Safe:     safe,
+			Position: object.GetPosition(),
+			End:      p.end(),
+		}
 	}
 }
 
@@ -827,7 +2228,7 @@ func (p *parser) parseParameter() (*Parameter, error) {
 	pos := p.position()
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected parameter name, got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected parameter name, got %q", p.scanner.TokenText())
 	}
 
 	name := p.scanner.TokenText()
@@ -846,6 +2247,7 @@ func (p *parser) parseParameter() (*Parameter, error) {
 		Name:     name,
 		Type:     paramType,
 		Position: pos,
+		End:      p.end(),
 	}, nil
 }
 
@@ -853,15 +2255,91 @@ func (p *parser) parseType() (*Type, error) {
 	pos := p.position()
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected type name, got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected type name, got %q", p.scanner.TokenText())
 	}
 
 	typeName := p.scanner.TokenText()
 	p.next()
 
+	// "maybe Address": a value that may be none
+	if typeName == "maybe" && p.tok == scanner.Ident {
+		t, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		t.Optional, t.Nullable = true, true
+		t.Position = pos
+		return t, nil
+	}
+
+	// Collections: "list[User]" or "list of User"
+	if typeName == "list" && (p.tok == '[' || (p.tok == scanner.Ident && p.scanner.TokenText() == "of")) {
+		bracketed := p.tok == '['
+		p.next()
+
+		element, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+
+		if bracketed {
+			if err := p.expect(']', "']'"); err != nil {
+				return nil, err
+			}
+		}
+
+		return &Type{
+			Name:        typeName,
+			Element:     element,
+			Position:    pos,
+			End:         p.end(),
+			Constraints: make(map[string]interface{}),
+		}, nil
+	}
+
+	// Maps: "map of text to int" or "map<text, int>"
+	if typeName == "map" && (p.tok == '<' || (p.tok == scanner.Ident && p.scanner.TokenText() == "of")) {
+		bracketed := p.tok == '<'
+		p.next()
+
+		key, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+
+		if bracketed {
+			if err := p.expect(',', "','"); err != nil {
+				return nil, err
+			}
+		} else if err := p.expectKeyword("to"); err != nil {
+			return nil, err
+		}
+
+		value, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+
+		if bracketed {
+			if err := p.expect('>', "'>'"); err != nil {
+				return nil, err
+			}
+		}
+
+		return &Type{
+			Name:        typeName,
+			Key:         key,
+			Value:       value,
+			Position:    pos,
+			End:         p.end(),
+			Constraints: make(map[string]interface{}),
+		}, nil
+	}
+
 	return &Type{
 		Name:        typeName,
 		Position:    pos,
+		End:         p.end(),
 		Constraints: make(map[string]interface{}),
 	}, nil
 }
@@ -870,7 +2348,7 @@ func (p *parser) parseNativeBlock() (*NativeBlock, error) {
 	pos := p.position()
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected native block type at %s", p.position())
+		return nil, p.errorf(CodeSyntax, "expected native block type")
 	}
 
 	blockType := p.scanner.TokenText()
@@ -882,7 +2360,8 @@ func (p *parser) parseNativeBlock() (*NativeBlock, error) {
 	case "ts-native":
 		language = "ts"
 	default:
-		return nil, fmt.Errorf("invalid native block type %q at %s", blockType, p.position())
+		return nil, p.errorf(CodeUnknownKeyword, "invalid native block type %q", blockType).
+			Suggest("use go-native or ts-native")
 	}
 	p.next()
 
@@ -893,7 +2372,7 @@ func (p *parser) parseNativeBlock() (*NativeBlock, error) {
 	// For now, we'll expect the native code as a string
 	// In a full implementation, you'd parse the ``` delimited code blocks
 	if p.tok != scanner.String {
-		return nil, fmt.Errorf("expected native code string at %s", p.position())
+		return nil, p.errorf(CodeSyntax, "expected native code string")
 	}
 
 	code := strings.Trim(p.scanner.TokenText(), `"`)
@@ -903,19 +2382,21 @@ func (p *parser) parseNativeBlock() (*NativeBlock, error) {
 		Language: language,
 		Code:     code,
 		Position: pos,
+		End:      p.end(),
 	}, nil
 }
 
 // Legacy parser methods for backward compatibility
 func (p *parser) parseModel() (*Model, error) {
 	pos := p.position()
+	leading := p.leadingComments(pos)
 
 	if err := p.expectKeyword("model"); err != nil {
 		return nil, err
 	}
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected model name, got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected model name, got %q", p.scanner.TokenText())
 	}
 
 	name := p.scanner.TokenText()
@@ -927,9 +2408,11 @@ func (p *parser) parseModel() (*Model, error) {
 
 	model := &Model{
 		Name:     name,
+		Leading:  leading,
 		Position: pos,
 		Fields:   []*Field{},
 	}
+	model.Trailing = p.trailingComments(p.end())
 
 	for p.tok != '}' && p.tok != scanner.EOF {
 		field, err := p.parseField()
@@ -943,14 +2426,16 @@ func (p *parser) parseModel() (*Model, error) {
 		return nil, err
 	}
 
+	model.End = p.end()
 	return model, nil
 }
 
 func (p *parser) parseField() (*Field, error) {
 	pos := p.position()
+	leading := p.leadingComments(pos)
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected field name, got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected field name, got %q", p.scanner.TokenText())
 	}
 
 	name := p.scanner.TokenText()
@@ -964,10 +2449,15 @@ func (p *parser) parseField() (*Field, error) {
 	if err != nil {
 		return nil, err
 	}
+	p.parseOptionalMarker(fieldType)
+	if err := p.parseRoundingMarker(fieldType); err != nil {
+		return nil, err
+	}
 
 	field := &Field{
 		Name:     name,
 		Type:     fieldType,
+		Leading:  leading,
 		Position: pos,
 	}
 
@@ -983,6 +2473,8 @@ func (p *parser) parseField() (*Field, error) {
 		}
 	}
 
+	field.End = p.end()
+	field.Trailing = p.trailingComments(field.End)
 	return field, nil
 }
 
@@ -990,17 +2482,18 @@ func (p *parser) parseRelationship() (*Relationship, error) {
 	pos := p.position()
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected relationship keyword at %s", p.position())
+		return nil, p.errorf(CodeSyntax, "expected relationship keyword")
 	}
 
 	kind := p.scanner.TokenText()
 	if !isRelationshipKeyword(kind) {
-		return nil, fmt.Errorf("invalid relationship type %q at %s", kind, p.position())
+		return nil, p.errorf(CodeUnknownKeyword, "invalid relationship type %q", kind).
+			Suggest("use one of %s", strings.Join(relationshipKeywords, ", "))
 	}
 	p.next()
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected target model name, got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected target model name, got %q", p.scanner.TokenText())
 	}
 
 	target := p.scanner.TokenText()
@@ -1010,18 +2503,26 @@ func (p *parser) parseRelationship() (*Relationship, error) {
 		Kind:     kind,
 		Target:   target,
 		Position: pos,
+		End:      p.end(),
 	}, nil
 }
 
 func (p *parser) parseAssignment() (*Assignment, error) {
 	pos := p.position()
+	leading := p.leadingComments(pos)
 
-	if err := p.expectKeyword("assign-use"); err != nil {
+	if err := p.expectKeyword("assign"); err != nil {
+		return nil, err
+	}
+	if err := p.expect('-', "'-'"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("use"); err != nil {
 		return nil, err
 	}
 
 	if p.tok != scanner.Ident {
-		return nil, fmt.Errorf("expected type name, got %q at %s", p.scanner.TokenText(), p.position())
+		return nil, p.errorf(CodeSyntax, "expected type name, got %q", p.scanner.TokenText())
 	}
 
 	typeName := p.scanner.TokenText()
@@ -1039,44 +2540,36 @@ func (p *parser) parseAssignment() (*Assignment, error) {
 	assignment := &Assignment{
 		TypeName:   typeName,
 		BaseType:   baseType,
+		Leading:    leading,
 		Position:   pos,
 		Validation: make(map[string]interface{}),
 	}
+	assignment.Trailing = p.trailingComments(p.end())
 
-	// Optional why clause
-	if p.tok == scanner.Ident && p.scanner.TokenText() == "why" {
-		p.next()
-		if err := p.expect(':', "':'"); err != nil {
+	// Optional why clauses
+	for p.tok == scanner.Ident && p.scanner.TokenText() == "why" {
+		if err := p.parseWhy(&assignment.Why, &assignment.Whys); err != nil {
 			return nil, err
 		}
-		if p.tok != scanner.String {
-			return nil, fmt.Errorf("expected string after 'why:', got %q at %s", p.scanner.TokenText(), p.position())
-		}
-		assignment.Why = strings.Trim(p.scanner.TokenText(), `"`)
-		p.next()
 	}
 
 	// Optional validate clause (simplified)
 	if p.tok == scanner.Ident && p.scanner.TokenText() == "validate" {
-		p.next()
-		if err := p.expect(':', "':'"); err != nil {
+		rule, err := p.parseValidateRule()
+		if err != nil {
 			return nil, err
 		}
-		// For now, we'll store validation as a string
-		// You'd extend this to parse actual validation rules
-		if p.tok == scanner.String {
-			assignment.Validation["rule"] = strings.Trim(p.scanner.TokenText(), `"`)
-			p.next()
-		}
+		assignment.Validation["rule"] = rule
 	}
 
+	assignment.End = p.end()
 	return assignment, nil
 }
 
 // Utility functions
 func (p *parser) expect(tok rune, expected string) error {
 	if p.tok != tok {
-		return fmt.Errorf("expected %s, got %q at %s", expected, p.scanner.TokenText(), p.position())
+		return p.errorf(CodeSyntax, "expected %s, got %q", expected, p.scanner.TokenText())
 	}
 	p.next()
 	return nil
@@ -1084,15 +2577,62 @@ func (p *parser) expect(tok rune, expected string) error {
 
 func (p *parser) expectKeyword(keyword string) error {
 	if p.tok != scanner.Ident || p.scanner.TokenText() != keyword {
-		return fmt.Errorf("expected '%s', got %q at %s", keyword, p.scanner.TokenText(), p.position())
+		return p.errorf(CodeSyntax, "expected '%s', got %q", keyword, p.scanner.TokenText())
 	}
 	p.next()
 	return nil
 }
 
+// atTopLevelKeyword reports whether the current token starts a declaration
+func (p *parser) atTopLevelKeyword() bool {
+	return p.tok == scanner.Ident && (isTopLevelKeyword(p.scanner.TokenText()) || p.atAssignUse() || p.atSeed() || p.atConfig() || p.atAllow() || p.atSchedule() || p.atWorkflow() || p.atQueue())
+}
+
+// atQueue reports whether the current token starts "queue name handles";
+// a field named queue is followed by its ':' instead
+func (p *parser) atQueue() bool {
+	return p.tok == scanner.Ident && p.scanner.TokenText() == "queue" && (p.scanner.Peek() == ' ' || p.scanner.Peek() == '\t')
+}
+
+// atWorkflow reports whether the current token starts "workflow name:"; a
+// field named workflow is followed by its ':' instead
+func (p *parser) atWorkflow() bool {
+	return p.tok == scanner.Ident && p.scanner.TokenText() == "workflow" && (p.scanner.Peek() == ' ' || p.scanner.Peek() == '\t')
+}
+
+// atSchedule reports whether the current token starts "schedule function";
+// a field named schedule is followed by its ':' instead
+func (p *parser) atSchedule() bool {
+	return p.tok == scanner.Ident && p.scanner.TokenText() == "schedule" && (p.scanner.Peek() == ' ' || p.scanner.Peek() == '\t')
+}
+
+// atAllow reports whether the current token starts "allow role to"; a
+// field named allow is followed by its ':' instead
+func (p *parser) atAllow() bool {
+	return p.tok == scanner.Ident && p.scanner.TokenText() == "allow" && (p.scanner.Peek() == ' ' || p.scanner.Peek() == '\t')
+}
+
+// atConfig reports whether the current token starts "config name:"; like
+// seed, a field named config is followed by its ':' instead
+func (p *parser) atConfig() bool {
+	return p.tok == scanner.Ident && p.scanner.TokenText() == "config" && (p.scanner.Peek() == ' ' || p.scanner.Peek() == '\t')
+}
+
+// atSeed reports whether the current token starts "seed Record:"; a field
+// named seed is followed by its ':' instead
+func (p *parser) atSeed() bool {
+	return p.tok == scanner.Ident && p.scanner.TokenText() == "seed" && (p.scanner.Peek() == ' ' || p.scanner.Peek() == '\t')
+}
+
+// atAssignUse reports whether the current token starts "assign-use", which
+// the scanner splits at the hyphen
+func (p *parser) atAssignUse() bool {
+	return p.tok == scanner.Ident && p.scanner.TokenText() == "assign" && p.scanner.Peek() == '-'
+}
+
 // Helper functions for keyword recognition
 func isTopLevelKeyword(keyword string) bool {
-	topLevel := []string{"module", "define", "function", "model", "assign-use"}
+	topLevel := []string{"module", "define", "function", "model", "assign-use", "test"}
 	for _, kw := range topLevel {
 		if keyword == kw {
 			return true
@@ -1102,7 +2642,7 @@ func isTopLevelKeyword(keyword string) bool {
 }
 
 func isStatementKeyword(keyword string) bool {
-	statements := []string{"if", "return", "set", "create", "fail", "use", "for", "while"}
+	statements := []string{"if", "return", "set", "create", "fail", "use", "expect", "for", "while"}
 	for _, kw := range statements {
 		if keyword == kw {
 			return true
@@ -1111,9 +2651,10 @@ func isStatementKeyword(keyword string) bool {
 	return false
 }
 
+var aiAnnotations = []string{"ai-feedback", "ai-suggests", "ai-security", "ai-performance", "ai-decision-accepted", "ai-decision-rejected"}
+
 func isAIAnnotation(keyword string) bool {
-	annotations := []string{"ai-feedback", "ai-suggests", "ai-security", "ai-performance", "ai-decision-accepted", "ai-decision-rejected"}
-	for _, ann := range annotations {
+	for _, ann := range aiAnnotations {
 		if keyword == ann || keyword == ann+":" {
 			return true
 		}
@@ -1121,9 +2662,10 @@ func isAIAnnotation(keyword string) bool {
 	return false
 }
 
+var relationshipKeywords = []string{"belongs_to", "has_one", "has_many", "references"}
+
 func isRelationshipKeyword(keyword string) bool {
-	relationships := []string{"belongs_to", "has_one", "has_many", "references"}
-	for _, rel := range relationships {
+	for _, rel := range relationshipKeywords {
 		if keyword == rel {
 			return true
 		}
@@ -0,0 +1,274 @@
+// Package postman exports the operations of generated OpenAPI documents as a
+// Postman collection, with an environment holding the base URL and auth
+// token. Insomnia imports the same format.
+package postman
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/mock"
+)
+
+// SchemaURL identifies the collection format, v2.1
+const SchemaURL = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// defaultBaseURL is used when no document declares a server
+const defaultBaseURL = "http://localhost:8080"
+
+// Collection is a Postman v2.1 collection with one folder per tag
+type Collection struct {
+	Info     Info       `json:"info"`
+	Auth     Auth       `json:"auth"`
+	Variable []Variable `json:"variable"`
+	Item     []Folder   `json:"item"`
+}
+
+type Info struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+// Auth sends {{authToken}} as a bearer token with every request
+type Auth struct {
+	Type   string     `json:"type"`
+	Bearer []Variable `json:"bearer"`
+}
+
+type Variable struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Type  string `json:"type,omitempty"`
+}
+
+type Folder struct {
+	Name string `json:"name"`
+	Item []Item `json:"item"`
+}
+
+type Item struct {
+	Name    string  `json:"name"`
+	Request Request `json:"request"`
+}
+
+type Request struct {
+	Method      string   `json:"method"`
+	Description string   `json:"description,omitempty"`
+	Header      []Header `json:"header"`
+	Body        *Body    `json:"body,omitempty"`
+	URL         URL      `json:"url"`
+}
+
+type Header struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// Body is an example JSON request body
+type Body struct {
+	Mode    string      `json:"mode"`
+	Raw     string      `json:"raw"`
+	Options BodyOptions `json:"options"`
+}
+
+type BodyOptions struct {
+	Raw struct {
+		Language string `json:"language"`
+	} `json:"raw"`
+}
+
+type URL struct {
+	Raw      string     `json:"raw"`
+	Host     []string   `json:"host"`
+	Path     []string   `json:"path"`
+	Variable []Variable `json:"variable,omitempty"`
+}
+
+// Environment holds the values the collection refers to, such as
+// {{baseUrl}}, so each deployment gets its own environment
+type Environment struct {
+	Name   string             `json:"name"`
+	Values []EnvironmentValue `json:"values"`
+	Scope  string             `json:"_postman_variable_scope"`
+}
+
+type EnvironmentValue struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Load reads OpenAPI YAML documents from disk and exports them
+func Load(name string, paths ...string) (*Collection, *Environment, error) {
+	var docs [][]byte
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		docs = append(docs, data)
+	}
+	return New(name, docs...)
+}
+
+// New exports the operations of OpenAPI YAML documents as a collection
+// called name and an environment for it
+func New(name string, docs ...[]byte) (*Collection, *Environment, error) {
+	collection := &Collection{
+		Info: Info{Name: name, Schema: SchemaURL},
+		Auth: Auth{Type: "bearer", Bearer: []Variable{{Key: "token", Value: "{{authToken}}", Type: "string"}}},
+		Variable: []Variable{
+			{Key: "baseUrl", Value: defaultBaseURL},
+		},
+	}
+	environment := &Environment{Name: name, Scope: "environment"}
+
+	baseURL := ""
+	headers := make(map[string]bool) // request headers needing an environment value
+	folders := make(map[string]*Folder)
+	var order []string
+
+	for i, data := range docs {
+		doc, err := mock.Parse(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("spec %d: %w", i, err)
+		}
+		if baseURL == "" {
+			baseURL = serverURL(doc)
+		}
+
+		paths, _ := doc["paths"].(map[string]interface{})
+		for _, path := range sortedKeys(paths) {
+			operations, _ := paths[path].(map[string]interface{})
+			shared, _ := operations["parameters"].([]interface{})
+			for _, method := range sortedKeys(operations) {
+				operation, ok := operations[method].(map[string]interface{})
+				if !ok || method == "parameters" {
+					continue
+				}
+				item := newItem(doc, strings.ToUpper(method), path, operation, shared)
+				for _, header := range item.Request.Header {
+					if strings.HasPrefix(header.Value, "{{") {
+						headers[strings.Trim(header.Value, "{}")] = true
+					}
+				}
+
+				tag := "Default"
+				if tags, ok := operation["tags"].([]interface{}); ok && len(tags) > 0 {
+					tag = fmt.Sprint(tags[0])
+				}
+				folder, ok := folders[tag]
+				if !ok {
+					folder = &Folder{Name: tag}
+					folders[tag] = folder
+					order = append(order, tag)
+				}
+				folder.Item = append(folder.Item, item)
+			}
+		}
+	}
+
+	sort.Strings(order)
+	for _, tag := range order {
+		collection.Item = append(collection.Item, *folders[tag])
+	}
+
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	collection.Variable[0].Value = baseURL
+	environment.Values = []EnvironmentValue{
+		{Key: "baseUrl", Value: baseURL, Type: "default", Enabled: true},
+		{Key: "authToken", Value: "", Type: "secret", Enabled: true},
+	}
+	for _, header := range sortedKeys(headers) {
+		environment.Values = append(environment.Values, EnvironmentValue{Key: header, Type: "default", Enabled: true})
+	}
+
+	return collection, environment, nil
+}
+
+// newItem describes one operation as a request with example values. shared
+// holds the parameters declared for every operation on the path.
+func newItem(doc map[string]interface{}, method, path string, operation map[string]interface{}, shared []interface{}) Item {
+	request := Request{Method: method, Header: []Header{}}
+	request.Description, _ = operation["description"].(string)
+
+	url := URL{Host: []string{"{{baseUrl}}"}}
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segment = ":" + strings.Trim(segment, "{}")
+		}
+		url.Path = append(url.Path, segment)
+	}
+	url.Raw = "{{baseUrl}}/" + strings.Join(url.Path, "/")
+
+	parameters, _ := operation["parameters"].([]interface{})
+	parameters = append(append([]interface{}{}, shared...), parameters...)
+	for _, p := range parameters {
+		param, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := param["name"].(string)
+		schema, _ := param["schema"].(map[string]interface{})
+		switch param["in"] {
+		case "path":
+			url.Variable = append(url.Variable, Variable{Key: name, Value: fmt.Sprint(mock.Example(doc, schema))})
+		case "header":
+			// Optional headers are listed but left off until filled in
+			required, _ := param["required"].(bool)
+			request.Header = append(request.Header, Header{Key: name, Value: "{{" + name + "}}", Disabled: !required})
+		}
+	}
+	request.URL = url
+
+	if body, _ := operation["requestBody"].(map[string]interface{}); body != nil {
+		content, _ := body["content"].(map[string]interface{})
+		for _, mediaType := range sortedKeys(content) {
+			media, _ := content[mediaType].(map[string]interface{})
+			schema, _ := media["schema"].(map[string]interface{})
+			example, err := json.MarshalIndent(mock.Example(doc, schema), "", "  ")
+			if err != nil {
+				continue
+			}
+			request.Header = append(request.Header, Header{Key: "Content-Type", Value: mediaType})
+			request.Body = &Body{Mode: "raw", Raw: string(example)}
+			request.Body.Options.Raw.Language = "json"
+			break
+		}
+	}
+
+	name, _ := operation["summary"].(string)
+	if name == "" {
+		name = method + " " + path
+	}
+	return Item{Name: name, Request: request}
+}
+
+// serverURL returns the first server URL a document declares
+func serverURL(doc map[string]interface{}) string {
+	servers, _ := doc["servers"].([]interface{})
+	for _, s := range servers {
+		if server, ok := s.(map[string]interface{}); ok {
+			if url, _ := server["url"].(string); url != "" {
+				return strings.TrimSuffix(url, "/")
+			}
+		}
+	}
+	return ""
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
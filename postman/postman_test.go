@@ -0,0 +1,71 @@
+package postman
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/spec/openapi"
+)
+
+func TestNewCollection(t *testing.T) {
+	file, err := grammar.ParseString(`define record Order versioned
+    total: number
+
+function placeOrder(total: number) returns number
+    header: X-Tenant-ID required
+    why: "Orders belong to a tenant"
+    do:
+        return total`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	spec, err := openapi.Generate(file)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	collection, environment, err := New("shop", []byte(spec))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if collection.Info.Schema != SchemaURL || collection.Auth.Bearer[0].Value != "{{authToken}}" {
+		t.Fatalf("unexpected collection header: %#v", collection.Info)
+	}
+
+	requests := make(map[string]Request)
+	for _, folder := range collection.Item {
+		for _, item := range folder.Item {
+			requests[item.Request.Method+" "+item.Request.URL.Raw] = item.Request
+		}
+	}
+
+	put, ok := requests["PUT {{baseUrl}}/orders/:id"]
+	if !ok {
+		t.Fatalf("expected a PUT request for orders, got %v", requests)
+	}
+	if len(put.URL.Variable) != 1 || put.URL.Variable[0].Key != "id" || put.URL.Variable[0].Value == "" {
+		t.Fatalf("expected an example id path variable, got %#v", put.URL.Variable)
+	}
+	if put.Body == nil || !strings.Contains(put.Body.Raw, `"total": `) {
+		t.Fatalf("expected an example body, got %#v", put.Body)
+	}
+
+	call, ok := requests["POST {{baseUrl}}/placeorder"]
+	if !ok {
+		t.Fatalf("expected a request for placeOrder, got %v", requests)
+	}
+	if call.Header[0].Key != "X-Tenant-ID" || call.Header[0].Value != "{{X-Tenant-ID}}" || call.Header[0].Disabled {
+		t.Fatalf("expected the required tenant header, got %#v", call.Header)
+	}
+
+	keys := make(map[string]bool)
+	for _, value := range environment.Values {
+		keys[value.Key] = true
+	}
+	for _, key := range []string{"baseUrl", "authToken", "X-Tenant-ID"} {
+		if !keys[key] {
+			t.Fatalf("expected environment value %s, got %#v", key, environment.Values)
+		}
+	}
+}
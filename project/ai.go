@@ -0,0 +1,102 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/daveroberts0321/cloudpact/ai"
+	"github.com/daveroberts0321/cloudpact/config"
+)
+
+// loadAIConfig reads the ai section of cloudpact.yaml; a missing file or
+// section names no provider, which NewProvider rejects
+func loadAIConfig() (ai.Config, error) {
+	cfg, err := config.Load(config.FileName)
+	if err != nil {
+		return ai.Config{}, err
+	}
+	return ai.Config(cfg.AI), nil
+}
+
+// ReviewFile has the configured AI provider review a .cp file, or the
+// built-in rules when offline is set. The review is written to
+// cmd/ai-integration/cache/ and its suggestions are added to the
+// suggestion store; it returns the review and the path written.
+func ReviewFile(path string, offline bool) (*ai.Review, string, error) {
+	var provider ai.Provider = ai.Offline{}
+	if !offline {
+		cfg, err := loadAIConfig()
+		if err != nil {
+			return nil, "", err
+		}
+		if provider, err = ai.NewProvider(cfg); err != nil {
+			return nil, "", err
+		}
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	review, err := ai.ReviewFile(context.Background(), provider, path, source)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to review %s: %w", path, err)
+	}
+	output, err := ai.WriteReview(ai.CacheDir, review)
+	if err != nil {
+		return nil, "", err
+	}
+
+	store, err := ai.LoadStore(ai.StorePath)
+	if err != nil {
+		return nil, "", err
+	}
+	store.Add(review)
+	if err := store.Save(ai.StorePath); err != nil {
+		return nil, "", err
+	}
+	return review, output, nil
+}
+
+// PendingSuggestions lists the suggestions waiting for a decision
+func PendingSuggestions() ([]*ai.Suggestion, error) {
+	store, err := ai.LoadStore(ai.StorePath)
+	if err != nil {
+		return nil, err
+	}
+	return store.Pending(), nil
+}
+
+// AcceptSuggestion applies a pending suggestion to its .cp file, recording
+// the decision in the source and in the suggestion store
+func AcceptSuggestion(id string) (*ai.Suggestion, error) {
+	store, err := ai.LoadStore(ai.StorePath)
+	if err != nil {
+		return nil, err
+	}
+	suggestion := store.Find(id)
+	if suggestion == nil {
+		return nil, fmt.Errorf("no suggestion %s; run cloudpact ai status to list them", id)
+	}
+	if suggestion.Status != ai.StatusPending {
+		return nil, fmt.Errorf("suggestion %s is already %s", id, suggestion.Status)
+	}
+
+	path := filepath.FromSlash(suggestion.File)
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := ai.Apply(suggestion, source)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, patched, 0644); err != nil {
+		return nil, err
+	}
+
+	suggestion.Status = ai.StatusAccepted
+	return suggestion, store.Save(ai.StorePath)
+}
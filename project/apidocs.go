@@ -0,0 +1,175 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// The API docs pages load Swagger UI and Redoc at these pinned releases
+const (
+	swaggerUIAssets = "https://cdn.jsdelivr.net/npm/swagger-ui-dist@5.17.14"
+	redocBundle     = "https://cdn.jsdelivr.net/npm/redoc@2.1.5/bundles/redoc.standalone.js"
+)
+
+// apiDocSpec is a generated spec as the docs pages list it
+type apiDocSpec struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	path string
+}
+
+// apiDocsPage is the data of the docs page templates
+type apiDocsPage struct {
+	Specs     []apiDocSpec
+	Selected  apiDocSpec // the spec Redoc shows
+	SwaggerUI string
+	Redoc     string
+}
+
+// handleAPIDocs serves the generated OpenAPI specs under /docs/: Swagger UI
+// at /docs/, Redoc at /docs/redoc and the specs themselves at
+// /docs/specs/<name>.yaml. The specs are listed afresh on every request, and
+// the pages reload once a rebuild changes them.
+func (s *devServer) handleAPIDocs() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		specs, err := apiDocSpecs()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		page := strings.TrimPrefix(r.URL.Path, "/docs/")
+		if name, ok := strings.CutPrefix(page, "specs/"); ok {
+			for _, spec := range specs {
+				if spec.Name+".yaml" == name {
+					w.Header().Set("Content-Type", "application/yaml")
+					w.Header().Set("Cache-Control", "no-cache")
+					http.ServeFile(w, r, spec.path)
+					return
+				}
+			}
+			http.NotFound(w, r)
+			return
+		}
+
+		var tmpl *template.Template
+		switch page {
+		case "":
+			tmpl = swaggerUITemplate
+		case "redoc":
+			tmpl = redocTemplate
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if buildErr := s.currentError(); buildErr != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			if err := overlayTemplate.Execute(w, buildErr); err != nil {
+				fmt.Fprintf(w, "build failed: %s", buildErr.Message)
+			}
+			return
+		}
+		if len(specs) == 0 {
+			tmpl = noSpecsTemplate
+		}
+
+		data := apiDocsPage{Specs: specs, SwaggerUI: swaggerUIAssets, Redoc: redocBundle}
+		if len(specs) > 0 {
+			data.Selected = specs[0]
+			for _, spec := range specs {
+				if spec.Name == r.URL.Query().Get("spec") {
+					data.Selected = spec
+				}
+			}
+		}
+		var html bytes.Buffer
+		if err := tmpl.Execute(&html, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(injectReloadClient(html.Bytes()))
+	})
+}
+
+// apiDocSpecs lists the specs in the configured openapi directory
+func apiDocSpecs() ([]apiDocSpec, error) {
+	dir, err := OutputDir("openapi")
+	if err != nil {
+		return nil, err
+	}
+	paths, err := specFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var specs []apiDocSpec
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".yaml")
+		specs = append(specs, apiDocSpec{Name: name, URL: "/docs/specs/" + name + ".yaml", path: path})
+	}
+	return specs, nil
+}
+
+var swaggerUITemplate = template.Must(template.New("swagger-ui").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>API docs</title>
+<link rel="stylesheet" href="{{.SwaggerUI}}/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="{{.SwaggerUI}}/swagger-ui-bundle.js"></script>
+<script src="{{.SwaggerUI}}/swagger-ui-standalone-preset.js"></script>
+<script>
+  window.ui = SwaggerUIBundle({
+    urls: {{.Specs}},
+    dom_id: "#swagger-ui",
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    layout: "StandaloneLayout"
+  });
+</script>
+</body>
+</html>
+`))
+
+var redocTemplate = template.Must(template.New("redoc").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>API docs: {{.Selected.Name}}</title>
+<style>
+  body { margin: 0; }
+  form { padding: 8px 16px; font-family: sans-serif; border-bottom: 1px solid #e5e7eb; }
+</style>
+</head>
+<body>
+{{if gt (len .Specs) 1}}<form action="/docs/redoc">
+  <label>Spec <select name="spec" onchange="this.form.submit()">
+  {{- range .Specs}}
+    <option value="{{.Name}}"{{if eq .Name $.Selected.Name}} selected{{end}}>{{.Name}}</option>
+  {{- end}}
+  </select></label>
+</form>{{end}}
+<redoc spec-url="{{.Selected.URL}}"></redoc>
+<script src="{{.Redoc}}"></script>
+</body>
+</html>
+`))
+
+var noSpecsTemplate = template.Must(template.New("no-specs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>API docs</title>
+</head>
+<body>
+<p>No OpenAPI specs have been generated yet. Add a .cp file, and the openapi target if cloudpact.yaml lists targets; this page reloads after the next build.</p>
+</body>
+</html>
+`))
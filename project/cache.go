@@ -0,0 +1,159 @@
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// buildCachePath is the manifest Build uses to skip unchanged sources.
+// Deleting it forces a full rebuild.
+var buildCachePath = filepath.Join(".cloudpact", "cache", "manifest.json")
+
+// cacheVersion is bumped whenever generated output changes, so caches
+// written by older generators are discarded
+const cacheVersion = "7"
+
+// buildCache records the hash of each source and of the files generated from it
+type buildCache struct {
+	Version string                 `json:"version"`
+	Config  string                 `json:"config"` // hash of cloudpact.yaml and template overrides
+	Types   string                 `json:"types"`  // fingerprint of the custom types shared by all sources
+	Files   map[string]*cacheEntry `json:"files"`
+}
+
+type cacheEntry struct {
+	Hash    string            `json:"hash"`
+	Outputs map[string]string `json:"outputs"` // output path -> hash
+}
+
+// loadBuildCache reads the manifest; a missing, unreadable or outdated
+// manifest, or a changed cloudpact.yaml or template override, yields an
+// empty cache so everything is rebuilt
+func loadBuildCache() *buildCache {
+	config := hashConfig()
+	cache := &buildCache{Version: cacheVersion, Config: config, Files: make(map[string]*cacheEntry)}
+
+	data, err := os.ReadFile(buildCachePath)
+	if err != nil {
+		return cache
+	}
+
+	var stored buildCache
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Version != cacheVersion || stored.Config != config || stored.Files == nil {
+		return cache
+	}
+	return &stored
+}
+
+func (c *buildCache) save() error {
+	if err := os.MkdirAll(filepath.Dir(buildCachePath), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(buildCachePath, data, 0644)
+}
+
+// saveBuildCache writes the cache and the artifact manifest derived from
+// it; failing to do so only costs a full rebuild next time, so it is
+// reported rather than returned
+func saveBuildCache(c *buildCache) {
+	if err := c.save(); err != nil {
+		fmt.Printf("   Warning: failed to write build cache: %v\n", err)
+	}
+	if err := newManifest(c).save(); err != nil {
+		fmt.Printf("   Warning: failed to write %s: %v\n", ManifestPath, err)
+	}
+}
+
+// fresh reports whether source and all of its outputs still match the
+// hashes recorded at the last build; edited or deleted outputs are rebuilt
+func (c *buildCache) fresh(source string) bool {
+	entry, ok := c.Files[source]
+	if !ok {
+		return false
+	}
+	if hash, err := hashFile(source); err != nil || hash != entry.Hash {
+		return false
+	}
+	for output, outputHash := range entry.Outputs {
+		if hash, err := hashFile(output); err != nil || hash != outputHash {
+			return false
+		}
+	}
+	return true
+}
+
+// record stores the current hashes of source and the outputs built from it
+func (c *buildCache) record(source string, outputs []string) error {
+	hash, err := hashFile(source)
+	if err != nil {
+		return err
+	}
+	entry := &cacheEntry{Hash: hash, Outputs: make(map[string]string)}
+	for _, output := range outputs {
+		outputHash, err := hashFile(output)
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %w", output, err)
+		}
+		entry.Outputs[output] = outputHash
+	}
+	c.Files[source] = entry
+	return nil
+}
+
+// useTypes records the project's custom types. Sources use types declared
+// in other files, so when they change every entry is dropped.
+func (c *buildCache) useTypes(fingerprint string) {
+	if c.Types != fingerprint {
+		c.Types = fingerprint
+		c.Files = make(map[string]*cacheEntry)
+	}
+}
+
+// prune drops entries for sources that are not in sources
+func (c *buildCache) prune(sources []string) {
+	keep := make(map[string]struct{}, len(sources))
+	for _, source := range sources {
+		keep[source] = struct{}{}
+	}
+	for source := range c.Files {
+		if _, ok := keep[source]; !ok {
+			delete(c.Files, source)
+		}
+	}
+}
+
+// hashConfig hashes the project settings that shape every output:
+// cloudpact.yaml and any codegen template overrides
+func hashConfig() string {
+	files := []string{"cloudpact.yaml"}
+	overrides, _ := filepath.Glob(filepath.Join(templateOverrideDir, "*", "*.tmpl"))
+	files = append(files, overrides...)
+
+	h := sha256.New()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(h, "%s %d\n", file, len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
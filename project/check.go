@@ -0,0 +1,62 @@
+package project
+
+import (
+	"errors"
+	"os"
+
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Check parses and analyzes the given .cp files, or every .cp file under
+// the current directory when none are given, and returns the problems
+// found: at most one per file, since parsing and checking stop at the
+// first. The error is for files that could not be read at all.
+func Check(sources []string) ([]*grammar.Diagnostic, error) {
+	if len(sources) == 0 {
+		var err error
+		if sources, err = FindCloudPactFiles("."); err != nil {
+			return nil, err
+		}
+	}
+
+	diagnostics := []*grammar.Diagnostic{}
+	project, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	// A problem with the shared types, such as a type declared in two
+	// files, is reported unless a file's own check finds it too
+	types, err := projectTypes(project)
+	var shared *grammar.Diagnostic
+	if errors.As(err, &shared) {
+		types = nil
+	}
+
+	for _, source := range sources {
+		file, err := ParseCloudPactFile(source)
+		if err == nil {
+			err = analyzer.CheckWith(file, types)
+		}
+		if err == nil {
+			continue
+		}
+		var d *grammar.Diagnostic
+		if !errors.As(err, &d) {
+			if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+				return nil, err
+			}
+			d = grammar.NewDiagnostic(grammar.CodeSyntax, &grammar.Position{File: source}, "%s", err.Error())
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	for _, d := range diagnostics {
+		if shared != nil && d.Format() == shared.Format() {
+			shared = nil
+		}
+	}
+	if shared != nil {
+		diagnostics = append([]*grammar.Diagnostic{shared}, diagnostics...)
+	}
+	return diagnostics, nil
+}
@@ -0,0 +1,105 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/envgen"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// configPackage is the Go package, under the go directory, that GenerateConfig
+// writes the settings loader to
+const configPackage = "config"
+
+// GenerateConfig writes what the program needs to read the settings of
+// the project's config declarations and returns the paths written: the Go
+// package config under the configured go directory, env.d.ts in the ts
+// directory and .env.example in the project root. Settings of every file
+// are read together, so no two may share a name or a variable.
+func GenerateConfig() ([]string, error) {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []*grammar.Config
+	declared := make(map[string]string) // lower-cased setting name to its file
+	vars := make(map[string]string)     // variable to the setting reading it
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		if file.Module != nil && gogen.PackageName(file) == configPackage {
+			return nil, fmt.Errorf("module %s of %s would share the Go package %s with the settings; rename the module", file.Module.Name, source, configPackage)
+		}
+		file.Localize(opts.Locale)
+		for _, config := range file.Configs {
+			key := strings.ToLower(config.Name)
+			if other, ok := declared[key]; ok {
+				return nil, fmt.Errorf("setting %s is declared in both %s and %s", config.Name, other, source)
+			}
+			declared[key] = source
+			if other, ok := vars[config.EnvName()]; ok {
+				return nil, fmt.Errorf("settings %s and %s are both read from %s", other, config.Name, config.EnvName())
+			}
+			vars[config.EnvName()] = config.Name
+			configs = append(configs, config)
+		}
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no config declarations to read settings for")
+	}
+
+	header := fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)
+	genOpts := envgen.Options{Header: header}
+	goDir := filepath.Join(opts.outputDir("go"), configPackage)
+	if err := os.MkdirAll(goDir, 0755); err != nil {
+		return nil, err
+	}
+	goPath := filepath.Join(goDir, "config.go")
+	code, err := envgen.GenerateGo(configPackage, configs, genOpts)
+	if err != nil {
+		return nil, writeInvalidGo(goPath, code, err)
+	}
+	if err := os.WriteFile(goPath, code, 0644); err != nil {
+		return nil, err
+	}
+
+	tsDir := opts.outputDir("ts")
+	if err := os.MkdirAll(tsDir, 0755); err != nil {
+		return nil, err
+	}
+	tsPath := filepath.Join(tsDir, "env.d.ts")
+	if err := os.WriteFile(tsPath, envgen.GenerateTS(configs, genOpts), 0644); err != nil {
+		return nil, err
+	}
+
+	examplePath := ".env.example"
+	if err := os.WriteFile(examplePath, envgen.GenerateExample(configs, genOpts), 0644); err != nil {
+		return nil, err
+	}
+	return []string{goPath, tsPath, examplePath}, nil
+}
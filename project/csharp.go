@@ -0,0 +1,120 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/config"
+	"github.com/daveroberts0321/cloudpact/csgen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// GenerateCSharp writes the C# records of each project file declaring
+// records or models to <outDir>/<file>.cs and returns the paths written.
+// Files go in the namespace of their module, or one named after the
+// project, and use the others' namespaces. An empty outDir means the
+// configured csharp directory, generated/csharp by default.
+func GenerateCSharp(outDir string) ([]string, error) {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := config.Load(config.FileName)
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := outputDirOr(outDir, "csharp")
+	if err != nil {
+		return nil, err
+	}
+
+	type csSource struct {
+		path    string
+		content []byte
+		file    *grammar.File
+	}
+	var sources []csSource
+	fallback := csgen.PascalCase(settings.Name)
+	namespaces := make(map[string]bool)
+	declared := make(map[string]string) // type name to the file declaring it
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		if len(file.Records) == 0 && len(file.Models) == 0 {
+			continue
+		}
+		file.NameJSON(opts.JSONNames)
+
+		// Every file uses every namespace, so names must be unique
+		var names []string
+		for _, record := range file.Records {
+			names = append(names, record.Name)
+		}
+		for _, model := range file.Models {
+			names = append(names, model.Name)
+		}
+		for _, name := range names {
+			if other, ok := declared[name]; ok {
+				return nil, fmt.Errorf("%s is declared in both %s and %s", name, other, source)
+			}
+			declared[name] = source
+		}
+		namespaces[csgen.Namespace(file, fallback)] = true
+		sources = append(sources, csSource{path: source, content: content, file: file})
+	}
+	var usings []string
+	for namespace := range namespaces {
+		usings = append(usings, namespace)
+	}
+	sort.Strings(usings)
+
+	var outputs []string
+	written := make(map[string]string) // output path to its source
+	for _, source := range sources {
+		outputPath := filepath.Join(dir, strings.TrimSuffix(filepath.Base(source.path), ".cp")+".cs")
+		if other, ok := written[outputPath]; ok {
+			return nil, fmt.Errorf("%s and %s would both be written to %s", other, source.path, outputPath)
+		}
+		written[outputPath] = source.path
+
+		code, err := csgen.GenerateFile(source.file, csgen.Options{
+			Header:    codegen.Header(source.path, source.content),
+			Namespace: fallback,
+			Usings:    usings,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(outputPath, code, 0644); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, outputPath)
+	}
+	return outputs, nil
+}
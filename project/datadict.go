@@ -0,0 +1,187 @@
+package project
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// dataDictionaryColumns heads every data dictionary export
+var dataDictionaryColumns = []string{"Source", "Record", "Field", "Type", "Required", "Constraints", "Description", "PII"}
+
+// piiTypes are semantic types whose values identify or authenticate a person
+var piiTypes = map[string]bool{
+	"email":    true,
+	"phone":    true,
+	"password": true,
+}
+
+// GenerateDataDictionary describes every record and model field of the
+// project in <outDir>/datadict.<format>, where format is "csv" or "xlsx", and
+// returns the path written. An empty outDir means the configured datadict
+// directory, generated/datadict by default.
+func GenerateDataDictionary(format, outDir string) (string, error) {
+	if format != "csv" && format != "xlsx" {
+		return "", fmt.Errorf("unknown data dictionary format %q (expected csv or xlsx)", format)
+	}
+
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return "", err
+	}
+	rows := [][]string{dataDictionaryColumns}
+	for _, source := range cpFiles {
+		file, err := ParseCloudPactFile(source)
+		if err != nil {
+			return "", err
+		}
+		rows = append(rows, dataDictionaryRows(source, file)...)
+	}
+
+	dir, err := outputDirOr(outDir, "datadict")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	outputPath := filepath.Join(dir, "datadict."+format)
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	if format == "xlsx" {
+		err = writeXLSX(out, "Data Dictionary", rows)
+	} else {
+		w := csv.NewWriter(out)
+		w.WriteAll(rows)
+		err = w.Error()
+	}
+	if err != nil {
+		return "", err
+	}
+	return outputPath, out.Close()
+}
+
+// dataDictionaryRows describes the fields declared in file, one row each
+func dataDictionaryRows(source string, file *grammar.File) [][]string {
+	var rows [][]string
+	for _, record := range file.Records {
+		for _, field := range record.Fields {
+			rows = append(rows, dataDictionaryRow(source, record.Name, field.Name, field.Type))
+		}
+		if record.Versioned {
+			rows = append(rows, []string{source, record.Name, "version", "int", "yes", "read-only", "Increases with every update", "no"})
+		}
+	}
+	for _, model := range file.Models {
+		for _, field := range model.Fields {
+			rows = append(rows, dataDictionaryRow(source, model.Name, field.Name, field.Type))
+		}
+	}
+	return rows
+}
+
+func dataDictionaryRow(source, record, field string, t *grammar.Type) []string {
+	typeName := t.Name
+	if t.Name == "list" && t.Element != nil {
+		typeName = "list of " + t.Element.Name
+	}
+	if t.Name == "map" && t.Key != nil {
+		typeName = "map of " + t.Key.Name + " to " + t.Value.Name
+	}
+
+	// Validation rules without the presence checks, which Required covers
+	var constraints []string
+	for _, rule := range strings.Split(codegen.ValidationTag(t.Name), ",") {
+		if rule != "required" {
+			constraints = append(constraints, rule)
+		}
+	}
+	if mode, ok := t.Constraints["round"]; ok {
+		constraints = append(constraints, fmt.Sprintf("round=%v", mode))
+	}
+
+	return []string{
+		source,
+		record,
+		field,
+		typeName,
+		yesNo(!t.Optional),
+		strings.Join(constraints, ", "),
+		codegen.TypeComment(t.Name),
+		yesNo(piiTypes[strings.ToLower(t.Name)]),
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// writeXLSX writes rows as the only sheet of a minimal Excel workbook,
+// storing every cell as an inline string
+func writeXLSX(w io.Writer, sheet string, rows [][]string) error {
+	var data strings.Builder
+	data.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	data.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range rows {
+		data.WriteString(fmt.Sprintf(`<row r="%d">`, i+1))
+		for _, cell := range row {
+			data.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			xml.EscapeText(&data, []byte(cell))
+			data.WriteString(`</t></is></c>`)
+		}
+		data.WriteString(`</row>`)
+	}
+	data.WriteString(`</sheetData></worksheet>`)
+
+	var name strings.Builder
+	xml.EscapeText(&name, []byte(sheet))
+
+	parts := []struct{ path, content string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="` + name.String() + `" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`},
+		{"xl/worksheets/sheet1.xml", data.String()},
+	}
+
+	archive := zip.NewWriter(w)
+	for _, part := range parts {
+		f, err := archive.Create(part.path)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
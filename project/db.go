@@ -0,0 +1,247 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/config"
+	"github.com/daveroberts0321/cloudpact/dbgen"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// GenerateDatabase writes the persistence layer of the project's records
+// with an id, as persistence.orm in cloudpact.yaml picks, and returns the
+// paths written. gorm, the default, writes <outDir>/<package>/db/db_gorm.go,
+// a package of its own importing the generated Go, so an empty outDir means
+// the configured go directory. sqlc writes schema.sql, queries/<table>.sql and sqlc.yaml to
+// outDir, the configured db directory, generated/db, when empty.
+func GenerateDatabase(outDir string) ([]string, error) {
+	settings, err := config.Load(config.FileName)
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*grammar.File
+	var headers []string
+	tables := make(map[string]string) // table to the record stored in it
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		for _, record := range file.Records {
+			if !dbgen.Stored(record) {
+				continue
+			}
+			table := dbgen.TableName(record.Name)
+			if other, ok := tables[table]; ok {
+				return nil, fmt.Errorf("%s and %s would both be stored in the %s table", other, record.Name, table)
+			}
+			tables[table] = record.Name
+		}
+		files = append(files, file)
+		headers = append(headers, codegen.Header(source, content))
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no records with an id to store")
+	}
+
+	if settings.Persistence.ORM == "sqlc" {
+		return writeSQLC(outDir, files, headers)
+	}
+	return writeGORM(outDir, files)
+}
+
+// writeGORM writes db/db_gorm.go into the package directory of each Go
+// package with stored records
+func writeGORM(outDir string, files []*grammar.File) ([]string, error) {
+	dir, err := outputDirOr(outDir, "go")
+	if err != nil {
+		return nil, err
+	}
+	return writeGoPackages(dir, "db_gorm.go", files, dbgen.GenerateGORM)
+}
+
+// writeGoPackages writes the file name generate gives for each Go package
+// of files to the db package in its directory under dir, and returns the
+// paths written. The db package imports the records, so it needs their
+// import path; files without a module are in package main and cannot be
+// imported, so they are left out, as gen lambda does.
+func writeGoPackages(dir, name string, files []*grammar.File, generate func([]*grammar.File, dbgen.Options) ([]byte, error)) ([]string, error) {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := goImportRoot(opts.outputDir("go")); err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	packages := projectGoPackages(cpFiles, opts)
+
+	var dirs []string
+	byDir := make(map[string][]*grammar.File)
+	for _, file := range files {
+		if file.Module == nil {
+			continue
+		}
+		pkgDir := filepath.Join(dir, gogen.PackageName(file), dbgen.Package)
+		if _, ok := byDir[pkgDir]; !ok {
+			dirs = append(dirs, pkgDir)
+		}
+		byDir[pkgDir] = append(byDir[pkgDir], file)
+	}
+
+	var outputs []string
+	header := fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)
+	for _, pkgDir := range dirs {
+		pkgFiles := byDir[pkgDir]
+		code, err := generate(pkgFiles, dbgen.Options{Header: header, Packages: packages})
+		if code == nil && err == nil {
+			continue
+		}
+		if err := os.MkdirAll(pkgDir, 0755); err != nil {
+			return nil, err
+		}
+		outputPath := filepath.Join(pkgDir, name)
+		if err != nil {
+			return nil, writeInvalidGo(outputPath, code, err)
+		}
+		if err := os.WriteFile(outputPath, code, 0644); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, outputPath)
+	}
+	return outputs, nil
+}
+
+// writeSQLC writes the schema of every stored record, a query file per
+// record and the sqlc.yaml tying them together
+func writeSQLC(outDir string, files []*grammar.File, headers []string) ([]string, error) {
+	dir, err := outputDirOr(outDir, "db")
+	if err != nil {
+		return nil, err
+	}
+	queriesDir := filepath.Join(dir, "queries")
+	if err := os.MkdirAll(queriesDir, 0755); err != nil {
+		return nil, err
+	}
+
+	var outputs []string
+	var records []*grammar.Record
+	for i, file := range files {
+		for _, record := range file.Records {
+			if !dbgen.Stored(record) {
+				continue
+			}
+			records = append(records, record)
+			queriesPath := filepath.Join(queriesDir, dbgen.TableName(record.Name)+".sql")
+			if err := os.WriteFile(queriesPath, dbgen.GenerateQueries(record, dbgen.Options{Header: headers[i]}), 0644); err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, queriesPath)
+		}
+	}
+
+	opts := dbgen.Options{Header: fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)}
+	schemaPath := filepath.Join(dir, "schema.sql")
+	if err := os.WriteFile(schemaPath, dbgen.GenerateSchema(records, opts), 0644); err != nil {
+		return nil, err
+	}
+	configPath := filepath.Join(dir, "sqlc.yaml")
+	if err := os.WriteFile(configPath, dbgen.GenerateSQLCConfig(opts), 0644); err != nil {
+		return nil, err
+	}
+	return append([]string{schemaPath, configPath}, outputs...), nil
+}
+
+// GenerateSeed writes the sample data the seed declarations of the project
+// ask for, as persistence.orm in cloudpact.yaml picks, and returns the
+// paths written. gorm, the default, writes <outDir>/<package>/db/db_seed.go,
+// whose Seed inserts the rows through the models gen db writes beside it; an
+// empty outDir means the configured go directory. sqlc writes INSERT statements
+// to <outDir>/seed.sql, the configured db directory when outDir is empty.
+func GenerateSeed(outDir string) ([]string, error) {
+	settings, err := config.Load(config.FileName)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*grammar.File
+	seeds := 0
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		file.NameJSON(opts.JSONNames)
+		files = append(files, file)
+		seeds += len(file.Seeds)
+	}
+	if seeds == 0 {
+		return nil, fmt.Errorf("no seed declarations to generate data for")
+	}
+
+	if settings.Persistence.ORM == "sqlc" {
+		dir, err := outputDirOr(outDir, "db")
+		if err != nil {
+			return nil, err
+		}
+		header := fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)
+		sql := dbgen.GenerateSeedSQL(files, dbgen.Options{Header: header})
+		if sql == nil {
+			return nil, nil
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		outputPath := filepath.Join(dir, "seed.sql")
+		return []string{outputPath}, os.WriteFile(outputPath, sql, 0644)
+	}
+	dir, err := outputDirOr(outDir, "go")
+	if err != nil {
+		return nil, err
+	}
+	return writeGoPackages(dir, "db_seed.go", files, dbgen.GenerateSeedGORM)
+}
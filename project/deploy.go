@@ -0,0 +1,75 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/config"
+	"github.com/daveroberts0321/cloudpact/deploygen"
+)
+
+// GenerateDeploy writes what Kubernetes needs to run the server gen server
+// generates, as the deploy section of cloudpact.yaml describes, and returns
+// the paths written. The kubernetes format, the default, writes
+// deployment.yaml, service.yaml and, with a host, ingress.yaml to outDir;
+// helm writes a chart to <outDir>/<name>. An empty outDir means the
+// configured deploy directory, generated/deploy by default.
+func GenerateDeploy(outDir string) ([]string, error) {
+	settings, err := config.Load(config.FileName)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := outputDirOr(outDir, "deploy")
+	if err != nil {
+		return nil, err
+	}
+
+	name := settings.Name
+	if name == "" {
+		cwd, _ := os.Getwd()
+		name = filepath.Base(cwd)
+	}
+	deploy := settings.Deploy
+	app := deploygen.App{
+		Name:         deploygen.ResourceName(name),
+		Version:      settings.Version,
+		Image:        deploy.Image,
+		Replicas:     deploy.Replicas,
+		Namespace:    deploy.Namespace,
+		Host:         deploy.Host,
+		IngressClass: deploy.IngressClass,
+		TLSSecret:    deploy.TLSSecret,
+	}
+	if app.Image == "" {
+		tag := strings.TrimPrefix(settings.Version, "v")
+		if tag == "" {
+			tag = "latest"
+		}
+		app.Image = app.Name + ":" + tag
+	}
+	if app.Replicas == 0 {
+		app.Replicas = 1
+	}
+
+	opts := deploygen.Options{Header: fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)}
+	files := deploygen.Manifests(app, opts)
+	if deploy.Format == "helm" {
+		dir = filepath.Join(dir, app.Name)
+		files = deploygen.Chart(app, opts)
+	}
+	var outputs []string
+	for _, file := range files {
+		outputPath := filepath.Join(dir, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(outputPath, file.Content, 0644); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, outputPath)
+	}
+	return outputs, nil
+}
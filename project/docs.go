@@ -0,0 +1,51 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/docgen"
+)
+
+// GenerateDocs documents each .cp file of the project as
+// <outDir>/<source base name>.<format>, where format is "md" or "html", and
+// returns the paths written. An empty outDir means the configured docs
+// directory, generated/docs by default.
+func GenerateDocs(format, outDir string) ([]string, error) {
+	render := docgen.Markdown
+	switch format {
+	case "md":
+	case "html":
+		render = docgen.HTML
+	default:
+		return nil, fmt.Errorf("unknown docs format %q (expected md or html)", format)
+	}
+
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	dir, err := outputDirOr(outDir, "docs")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var outputs []string
+	for _, source := range cpFiles {
+		file, err := ParseCloudPactFile(source)
+		if err != nil {
+			return nil, err
+		}
+		baseName := strings.TrimSuffix(filepath.Base(source), ".cp")
+		outputPath := filepath.Join(dir, baseName+"."+format)
+		if err := os.WriteFile(outputPath, []byte(render(file, baseName)), 0644); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, outputPath)
+	}
+	return outputs, nil
+}
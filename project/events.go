@@ -0,0 +1,165 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/config"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/spec/asyncapi"
+	"github.com/daveroberts0321/cloudpact/spec/openapi"
+)
+
+// eventSource is a checked project file declaring events
+type eventSource struct {
+	path    string
+	content []byte
+	file    *grammar.File
+}
+
+// eventSources parses and checks the project's files and returns those
+// declaring events, with JSON keys named as cloudpact.yaml says
+func eventSources() ([]eventSource, error) {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []eventSource
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		if len(file.Events) == 0 {
+			continue
+		}
+		file.Localize(opts.Locale)
+		file.NameJSON(opts.JSONNames)
+		sources = append(sources, eventSource{path: source, content: content, file: file})
+	}
+	return sources, nil
+}
+
+// GenerateAsyncAPI writes an AsyncAPI document for each project file that
+// declares events to <outDir>/<name>.yaml and returns the paths written. An
+// empty outDir means the configured asyncapi directory, generated/asyncapi
+// by default.
+func GenerateAsyncAPI(outDir string) ([]string, error) {
+	sources, err := eventSources()
+	if err != nil {
+		return nil, err
+	}
+	apiConfig, err := openapi.LoadAPIConfig(config.FileName)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := outputDirOr(outDir, "asyncapi")
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []string
+	for _, source := range sources {
+		doc, err := asyncapi.Generate(source.file, apiConfig)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		outputPath := filepath.Join(dir, strings.TrimSuffix(filepath.Base(source.path), ".cp")+".yaml")
+		data := codegen.Stamp("#", codegen.Header(source.path, source.content), []byte(doc))
+		if err := os.WriteFile(outputPath, data, 0644); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, outputPath)
+	}
+	return outputs, nil
+}
+
+// GenerateEvents writes the publisher and subscriber stubs of the events
+// each module declares to events/events.go in the directory of its Go
+// package, under the configured go directory or outDir when given, and
+// returns the paths written. The stubs are a package of their own,
+// importing the module's records, so rebuilding the module never leaves
+// them behind in its package. Files without a module are in package main
+// and cannot be imported, so they are left out, as gen lambda does.
+func GenerateEvents(outDir string) ([]string, error) {
+	sources, err := eventSources()
+	if err != nil {
+		return nil, err
+	}
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := goImportRoot(opts.outputDir("go")); err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	packages := projectGoPackages(cpFiles, opts)
+	dir, err := outputDirOr(outDir, "go")
+	if err != nil {
+		return nil, err
+	}
+
+	// Files of one module share a package, and so its Broker
+	var pkgDirs []string
+	files := make(map[string][]*grammar.File)
+	for _, source := range sources {
+		if source.file.Module == nil {
+			continue
+		}
+		pkgDir := filepath.Join(dir, gogen.PackageName(source.file), gogen.EventsPackage)
+		if _, ok := files[pkgDir]; !ok {
+			pkgDirs = append(pkgDirs, pkgDir)
+		}
+		files[pkgDir] = append(files[pkgDir], source.file)
+	}
+	if len(pkgDirs) == 0 {
+		return nil, fmt.Errorf("no events in files with a module to generate stubs for")
+	}
+
+	header := fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)
+	var outputs []string
+	for _, pkgDir := range pkgDirs {
+		code, err := gogen.GenerateEvents(files[pkgDir], gogen.Options{Header: header, Packages: packages})
+		if err := os.MkdirAll(pkgDir, 0755); err != nil {
+			return nil, err
+		}
+		outputPath := filepath.Join(pkgDir, "events.go")
+		if err != nil {
+			return nil, writeInvalidGo(outputPath, code, err)
+		}
+		if err := os.WriteFile(outputPath, code, 0644); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, outputPath)
+	}
+	return outputs, nil
+}
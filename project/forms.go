@@ -0,0 +1,97 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/formgen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// GenerateForms writes an HTML form and its TypeScript binding for each
+// identified record of the project to <outDir>/<record>.form.html and
+// <outDir>/<record>.form.ts, with the forms.ts module they share, and
+// returns the paths written. The bindings import the record interfaces the
+// ts target builds. An empty outDir means the configured forms directory,
+// generated/forms by default.
+func GenerateForms(outDir string) ([]string, error) {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := outputDirOr(outDir, "forms")
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []string
+	written := make(map[string]string) // file name to the record written to it
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		file.NameJSON(opts.JSONNames)
+
+		// The binding imports the interfaces from where the ts target puts them
+		typesImport, err := importPath(filepath.Join(dir, formgen.RuntimeName), findTarget("ts").outputPath(source, file, opts))
+		if err != nil {
+			return nil, err
+		}
+
+		header := codegen.Header(source, content)
+		for _, record := range file.Records {
+			if !formgen.Editable(record) {
+				continue
+			}
+			name := formgen.FileName(record)
+			if other, ok := written[name]; ok {
+				return nil, fmt.Errorf("the forms of %s and %s would both be written to %s", other, record.Name, name)
+			}
+			written[name] = record.Name
+
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, err
+			}
+			htmlPath := filepath.Join(dir, name+".html")
+			if err := os.WriteFile(htmlPath, formgen.GenerateHTML(record, header), 0644); err != nil {
+				return nil, err
+			}
+			tsPath := filepath.Join(dir, name+".ts")
+			binding := formgen.GenerateTS(record, formgen.TSOptions{Header: header, TypesImport: typesImport})
+			if err := os.WriteFile(tsPath, binding, 0644); err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, htmlPath, tsPath)
+		}
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("no records with an id to generate forms for")
+	}
+
+	header := fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)
+	runtimePath := filepath.Join(dir, formgen.RuntimeName)
+	if err := os.WriteFile(runtimePath, formgen.Runtime(header), 0644); err != nil {
+		return nil, err
+	}
+	return append(outputs, runtimePath), nil
+}
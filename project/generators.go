@@ -0,0 +1,415 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/config"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/mobilegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/pygen"
+	"github.com/daveroberts0321/cloudpact/reactgen"
+	"github.com/daveroberts0321/cloudpact/spec/openapi"
+	"github.com/daveroberts0321/cloudpact/tsgen"
+)
+
+// Generator is a code generation backend. Build runs every enabled
+// generator on each checked .cp file; a generator writes one file per
+// source at ctx.OutputPath, or none when the source has nothing for it.
+type Generator interface {
+	Name() string
+	Generate(file *grammar.File, ctx OutputContext) error
+}
+
+// OutputContext tells a Generator which source it is building and where
+// its output goes
+type OutputContext struct {
+	SourcePath string // the .cp file being built
+	OutputPath string // generated/<name>/<source base name><ext>, or the directory cloudpact.yaml gives the target
+
+	// TrackChanges mirrors track_changes in cloudpact.yaml
+	TrackChanges bool
+	// Tracing mirrors observability.tracing in cloudpact.yaml
+	Tracing bool
+	// Logging mirrors observability.logging in cloudpact.yaml
+	Logging bool
+	// GoPackages maps the records of each module to the import path of its
+	// Go package, for Go output using records of other modules
+	GoPackages map[string]string
+
+	// Header is the provenance line every output starts with as a comment,
+	// naming the cloudpact release and the source and its hash
+	Header string
+}
+
+// target is a registered generator and the extension of the files it writes
+type target struct {
+	gen Generator
+	ext string
+	// dirOf names the target whose directory the files go to, when they
+	// belong beside its output rather than in their own
+	dirOf string
+	// byModule puts the output of a file that declares a module in a
+	// directory named after its Go package, so each module compiles as a
+	// package of its own
+	byModule bool
+	// optIn leaves the target out of builds whose cloudpact.yaml has no
+	// targets list; it runs only when the list names it
+	optIn bool
+	// needs names the targets whose output this one imports, which a
+	// targets list naming it must also name
+	needs []string
+}
+
+// outputPath is where t writes the output for sourcePath. file is the
+// parsed source; nil is treated as a file without a module.
+func (t *target) outputPath(sourcePath string, file *grammar.File, opts codegenOptions) string {
+	baseName := strings.TrimSuffix(filepath.Base(sourcePath), ".cp")
+	dir := t.gen.Name()
+	if t.dirOf != "" {
+		dir = t.dirOf
+	}
+	if t.byModule && file != nil && file.Module != nil {
+		return filepath.Join(opts.outputDir(dir), gogen.PackageName(file), baseName+t.ext)
+	}
+	return filepath.Join(opts.outputDir(dir), baseName+t.ext)
+}
+
+// generators lists registered targets in registration order
+var generators []*target
+
+// RegisterGenerator adds a code generation target. ext is the extension of
+// the file it writes per source, such as ".py". Programs embedding CloudPact
+// register their own targets before calling Build; a project then enables
+// them in the targets list of cloudpact.yaml. Registering a name twice panics.
+func RegisterGenerator(gen Generator, ext string) {
+	for _, t := range generators {
+		if t.gen.Name() == gen.Name() {
+			panic(fmt.Sprintf("project: generator %q registered twice", gen.Name()))
+		}
+	}
+	generators = append(generators, &target{gen: gen, ext: ext})
+}
+
+func init() {
+	RegisterGenerator(goGenerator{}, ".go")
+	findTarget("go").byModule = true
+	RegisterGenerator(tsGenerator{}, ".ts")
+	RegisterGenerator(openapiGenerator{}, ".yaml")
+	RegisterGenerator(zodGenerator{}, ".schemas.ts")
+
+	// Go tests must be in the package they test
+	RegisterGenerator(goTestGenerator{}, "_test.go")
+	findTarget("gotest").dirOf = "go"
+	findTarget("gotest").byModule = true
+
+	// Mocks of a module's functions are for projects that test against them
+	RegisterGenerator(mocksGenerator{}, "_mock.go")
+	findTarget("mocks").dirOf = "go"
+	findTarget("mocks").byModule = true
+	findTarget("mocks").optIn = true
+
+	// Python, the mobile models and the TypeScript API client are for
+	// projects that ask for them
+	RegisterGenerator(pythonGenerator{}, ".py")
+	findTarget("python").optIn = true
+	RegisterGenerator(kotlinGenerator{}, ".kt")
+	findTarget("kotlin").optIn = true
+	RegisterGenerator(swiftGenerator{}, ".swift")
+	findTarget("swift").optIn = true
+	RegisterGenerator(clientGenerator{}, ".ts")
+	findTarget("client").optIn = true
+
+	// React hooks and forms use the TypeScript interfaces, zod schemas and
+	// API client
+	RegisterGenerator(reactGenerator{}, ".tsx")
+	findTarget("react").optIn = true
+	findTarget("react").needs = []string{"ts", "zod", "client"}
+}
+
+// enabledTargets resolves the targets list from cloudpact.yaml; when it is
+// unset every registered generator but the opt-in ones runs
+func enabledTargets(opts codegenOptions) ([]*target, error) {
+	if len(opts.Targets) == 0 {
+		var enabled []*target
+		for _, t := range generators {
+			if !t.optIn {
+				enabled = append(enabled, t)
+			}
+		}
+		return enabled, nil
+	}
+
+	var enabled []*target
+	for _, name := range opts.Targets {
+		t := findTarget(name)
+		if t == nil {
+			var known []string
+			for _, t := range generators {
+				known = append(known, t.gen.Name())
+			}
+			return nil, fmt.Errorf("unknown target %q in cloudpact.yaml (available: %s)", name, strings.Join(known, ", "))
+		}
+		enabled = append(enabled, t)
+	}
+	for _, t := range enabled {
+		for _, need := range t.needs {
+			if !hasTarget(enabled, need) {
+				return nil, fmt.Errorf("target %q in cloudpact.yaml needs the %s target, which is not listed", t.gen.Name(), need)
+			}
+		}
+	}
+	return enabled, nil
+}
+
+// hasTarget reports whether targets include the generator name
+func hasTarget(targets []*target, name string) bool {
+	for _, t := range targets {
+		if t.gen.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func findTarget(name string) *target {
+	for _, t := range generators {
+		if t.gen.Name() == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// templateOverrideDir holds project templates replacing built-in ones of the
+// same name, e.g. templates/go/record.tmpl
+const templateOverrideDir = "templates"
+
+// goGenerator emits Go structs, models and functions
+type goGenerator struct{}
+
+func (goGenerator) Name() string { return "go" }
+
+func (goGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	code, err := gogen.GenerateFile(file, goOptions(ctx))
+	if err != nil && code != nil {
+		return writeInvalidGo(ctx.OutputPath, code, err)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ctx.OutputPath, code, 0644)
+}
+
+// goOptions are the options the go target generates the Go of a source with
+func goOptions(ctx OutputContext) gogen.Options {
+	return gogen.Options{
+		TrackChanges: ctx.TrackChanges,
+		TemplateDir:  filepath.Join(templateOverrideDir, "go"),
+		Header:       ctx.Header,
+		Tracing:      ctx.Tracing,
+		Logging:      ctx.Logging,
+		Packages:     ctx.GoPackages,
+	}
+}
+
+// writeInvalidGo keeps unformattable output on disk for inspection and
+// reports why it is not valid Go
+func writeInvalidGo(outputPath string, goCode []byte, err error) error {
+	if writeErr := os.WriteFile(outputPath, goCode, 0644); writeErr != nil {
+		return writeErr
+	}
+	return fmt.Errorf("generated Go in %s is invalid: %w", outputPath, err)
+}
+
+// goTestGenerator emits tests of the Go output's validation and functions
+type goTestGenerator struct{}
+
+func (goTestGenerator) Name() string { return "gotest" }
+
+func (goTestGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	code, err := gogen.GenerateTestFile(file, gogen.Options{Header: ctx.Header, Packages: ctx.GoPackages})
+	if err != nil && code != nil {
+		return writeInvalidGo(ctx.OutputPath, code, err)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ctx.OutputPath, code, 0644)
+}
+
+// mocksGenerator emits an interface of the module's functions, its
+// implementation by the generated Go and a mock for tests
+type mocksGenerator struct{}
+
+func (mocksGenerator) Name() string { return "mocks" }
+
+func (mocksGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	code, err := gogen.GenerateMocks(file, gogen.Options{Header: ctx.Header, Packages: ctx.GoPackages})
+	if err != nil && code != nil {
+		return writeInvalidGo(ctx.OutputPath, code, err)
+	}
+	if err != nil {
+		return err
+	}
+	// A file without functions has no mocks, including those of an
+	// earlier build
+	if code == nil {
+		if err := os.Remove(ctx.OutputPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(ctx.OutputPath, code, 0644)
+}
+
+// tsGenerator emits TypeScript interfaces and functions
+type tsGenerator struct{}
+
+func (tsGenerator) Name() string { return "ts" }
+
+func (tsGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	code, err := tsgen.GenerateFile(file, tsgen.Options{
+		TrackChanges: ctx.TrackChanges,
+		TemplateDir:  filepath.Join(templateOverrideDir, "ts"),
+		Header:       ctx.Header,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ctx.OutputPath, code, 0644)
+}
+
+// openapiGenerator emits an OpenAPI spec for the file's records and models
+type openapiGenerator struct{}
+
+func (openapiGenerator) Name() string { return "openapi" }
+
+func (openapiGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	apiConfig, err := openapi.LoadAPIConfig(config.FileName)
+	if err != nil {
+		return err
+	}
+	spec, err := openapi.GenerateWithConfig(file, apiConfig)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ctx.OutputPath, codegen.Stamp("#", ctx.Header, []byte(spec)), 0644)
+}
+
+// clientGenerator emits a TypeScript API client for the operations of the
+// file's OpenAPI spec, declaring the schemas it uses
+type clientGenerator struct{}
+
+func (clientGenerator) Name() string { return "client" }
+
+func (clientGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	apiConfig, err := openapi.LoadAPIConfig(config.FileName)
+	if err != nil {
+		return err
+	}
+	spec, err := openapi.GenerateWithConfig(file, apiConfig)
+	if err != nil {
+		return err
+	}
+	code, err := tsgen.GenerateClient([]byte(spec), tsgen.Options{Header: ctx.Header})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ctx.OutputPath, code, 0644)
+}
+
+// pythonGenerator emits pydantic models and a requests client
+type pythonGenerator struct{}
+
+func (pythonGenerator) Name() string { return "python" }
+
+func (pythonGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	code, err := pygen.GenerateFile(file, pygen.Options{Header: ctx.Header})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ctx.OutputPath, code, 0644)
+}
+
+// kotlinGenerator emits Kotlin data classes for Android apps
+type kotlinGenerator struct{}
+
+func (kotlinGenerator) Name() string { return "kotlin" }
+
+func (kotlinGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	code, err := mobilegen.GenerateKotlin(file, mobilegen.Options{Header: ctx.Header})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ctx.OutputPath, code, 0644)
+}
+
+// swiftGenerator emits Swift Codable structs for iOS apps
+type swiftGenerator struct{}
+
+func (swiftGenerator) Name() string { return "swift" }
+
+func (swiftGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	code, err := mobilegen.GenerateSwift(file, mobilegen.Options{Header: ctx.Header})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ctx.OutputPath, code, 0644)
+}
+
+// reactGenerator emits React hooks around the API client's functions and
+// forms checked by the zod schemas
+type reactGenerator struct{}
+
+func (reactGenerator) Name() string { return "react" }
+
+func (reactGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return err
+	}
+	typesImport, err := importPath(ctx.OutputPath, findTarget("ts").outputPath(ctx.SourcePath, file, opts))
+	if err != nil {
+		return err
+	}
+	schemasImport, err := importPath(ctx.OutputPath, findTarget("zod").outputPath(ctx.SourcePath, file, opts))
+	if err != nil {
+		return err
+	}
+	clientImport, err := importPath(ctx.OutputPath, findTarget("client").outputPath(ctx.SourcePath, file, opts))
+	if err != nil {
+		return err
+	}
+	code, err := reactgen.GenerateFile(file, reactgen.Options{Header: ctx.Header, TypesImport: typesImport, SchemasImport: schemasImport, ClientImport: clientImport})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ctx.OutputPath, code, 0644)
+}
+
+// importPath is how a TypeScript module at from imports the one at to:
+// relative, with forward slashes and without the extension
+func importPath(from, to string) (string, error) {
+	fromDir, err := filepath.Abs(filepath.Dir(from))
+	if err != nil {
+		return "", err
+	}
+	to, err = filepath.Abs(strings.TrimSuffix(to, ".ts"))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(fromDir, to)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, ".") {
+		rel = "./" + rel
+	}
+	return rel, nil
+}
@@ -0,0 +1,24 @@
+package project
+
+import (
+	"github.com/daveroberts0321/cloudpact/graph"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Graph maps the references between the records and functions of every
+// .cp file in the project
+func Graph() (*graph.Graph, error) {
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*grammar.File)
+	for _, source := range cpFiles {
+		file, err := ParseCloudPactFile(source)
+		if err != nil {
+			return nil, err
+		}
+		files[source] = file
+	}
+	return graph.Build(files), nil
+}
@@ -0,0 +1,107 @@
+package project
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/spec/openapi"
+)
+
+// ImportOpenAPI translates the OpenAPI document at spec into CloudPact
+// records and function stubs, writes them to output and returns its path.
+// An empty output means the spec's name with .cp beside it; an empty module
+// names the module after the spec's title. Existing files are not
+// overwritten.
+func ImportOpenAPI(spec, output, module string) (string, error) {
+	data, err := os.ReadFile(spec)
+	if err != nil {
+		return "", err
+	}
+	source, err := openapi.Import(data, module)
+	if err != nil {
+		return "", fmt.Errorf("failed to import %s: %w", spec, err)
+	}
+	if output == "" {
+		output = strings.TrimSuffix(spec, filepath.Ext(spec)) + ".cp"
+	}
+	return writeImported(source, output)
+}
+
+// ImportGo translates the exported structs of the Go package in dir, or of
+// the single Go file dir names, into CloudPact records, writes them to
+// output and returns its path. Test files are skipped. An empty output
+// means <package>.cp in the current directory; an empty module names the
+// module after the package. Existing files are not overwritten.
+func ImportGo(dir, output, module string) (string, error) {
+	paths := []string{dir}
+	if info, err := os.Stat(dir); err != nil {
+		return "", err
+	} else if info.IsDir() {
+		if paths, err = filepath.Glob(filepath.Join(dir, "*.go")); err != nil {
+			return "", err
+		}
+		sort.Strings(paths)
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		// A directory holds one package, apart from stray files such as
+		// generators declaring main
+		if len(files) > 0 && file.Name.Name != files[0].Name.Name {
+			continue
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no Go files in %s", dir)
+	}
+
+	source, err := gogen.Import(files, module)
+	if err != nil {
+		return "", fmt.Errorf("failed to import %s: %w", dir, err)
+	}
+	if output == "" {
+		output = files[0].Name.Name + ".cp"
+	}
+	return writeImported(source, output)
+}
+
+// writeImported writes imported source to output, which must not exist
+// yet, once it parses and checks
+func writeImported(source, output string) (string, error) {
+	file, err := grammar.ParseWithFilename(strings.NewReader(source), output)
+	if err != nil {
+		return "", fmt.Errorf("imported source does not parse: %w", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		return "", fmt.Errorf("imported source does not check: %w", err)
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		return "", fmt.Errorf("%s already exists", output)
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(output, []byte(source), 0644); err != nil {
+		return "", err
+	}
+	return output, nil
+}
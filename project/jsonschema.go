@@ -0,0 +1,67 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/spec/jsonschema"
+)
+
+// GenerateJSONSchema writes a JSON Schema document for every record of the
+// project to <outDir>/<Record>.schema.json and returns the paths written. An
+// empty outDir means the configured jsonschema directory,
+// generated/jsonschema by default.
+func GenerateJSONSchema(outDir string) ([]string, error) {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := outputDirOr(outDir, "jsonschema")
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []string
+	declared := make(map[string]string) // record name to the file declaring it
+	for _, source := range cpFiles {
+		file, err := ParseCloudPactFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		file.NameJSON(opts.JSONNames)
+
+		for _, doc := range jsonschema.Generate(file) {
+			if other, ok := declared[doc.Name]; ok {
+				return nil, fmt.Errorf("record %s is declared in both %s and %s", doc.Name, other, source)
+			}
+			declared[doc.Name] = source
+
+			data, err := doc.JSON()
+			if err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, err
+			}
+			outputPath := filepath.Join(dir, doc.FileName())
+			if err := os.WriteFile(outputPath, data, 0644); err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, outputPath)
+		}
+	}
+	return outputs, nil
+}
@@ -0,0 +1,145 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/config"
+	"github.com/daveroberts0321/cloudpact/deploygen"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/lambdagen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// GenerateLambda deploys each function of the project as a Lambda behind
+// API Gateway and returns the paths written. Each function gets a main
+// package in cmd/lambda/<name> under the configured go directory, with the
+// adapter serving it; the packages of the generated Go are left to the
+// build. outDir, the configured lambda directory when empty, gets
+// template.yaml, or serverless.yml when serverless.framework in
+// cloudpact.yaml says so. Files without a module are in package main and
+// cannot be imported, so they are left out, as gen server does.
+func GenerateLambda(outDir string) ([]string, error) {
+	settings, err := config.Load(config.FileName)
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+	codegenOpts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	goDir := codegenOpts.outputDir("go")
+	root, err := goImportRoot(goDir)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := outputDirOr(outDir, "lambda")
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgDirs []string
+	byDir := make(map[string][]*grammar.File)
+	served := make(map[string]string) // path to the function served at it
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		if file.Module == nil {
+			continue
+		}
+		for _, function := range file.Functions {
+			name := gogen.PackageName(file) + "." + function.Name
+			if other, ok := served[lambdagen.Path(function)]; ok {
+				return nil, fmt.Errorf("%s and %s are both served at %s; rename one of them", other, name, lambdagen.Path(function))
+			}
+			served[lambdagen.Path(function)] = name
+		}
+		pkgDir := filepath.Join(goDir, gogen.PackageName(file))
+		if _, ok := byDir[pkgDir]; !ok {
+			pkgDirs = append(pkgDirs, pkgDir)
+		}
+		byDir[pkgDir] = append(byDir[pkgDir], file)
+	}
+	if len(served) == 0 {
+		return nil, fmt.Errorf("no functions in files with a module to deploy")
+	}
+
+	header := fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)
+	opts := lambdagen.Options{Header: header, Packages: projectGoPackages(cpFiles, codegenOpts)}
+	var outputs []string
+	var functions []lambdagen.Function
+	for _, pkgDir := range pkgDirs {
+		files := byDir[pkgDir]
+		importPath := path.Join(root, gogen.PackageName(files[0]))
+		for _, file := range files {
+			for _, function := range file.Functions {
+				mainDir := filepath.Join(goDir, "cmd", "lambda", strings.ToLower(function.Name))
+				code, err := lambdagen.GenerateMain(importPath, files, function, opts)
+				if err := os.MkdirAll(mainDir, 0755); err != nil {
+					return nil, err
+				}
+				mainPath := filepath.Join(mainDir, "main.go")
+				if err != nil {
+					return nil, writeInvalidGo(mainPath, code, err)
+				}
+				if err := os.WriteFile(mainPath, code, 0644); err != nil {
+					return nil, err
+				}
+				outputs = append(outputs, mainPath)
+
+				rel, err := filepath.Rel(dir, mainDir)
+				if err != nil {
+					return nil, err
+				}
+				rel = filepath.ToSlash(rel)
+				if !strings.HasPrefix(rel, "../") {
+					rel = "./" + rel
+				}
+				functions = append(functions, lambdagen.Function{Name: function.Name, Path: lambdagen.Path(function), Dir: rel})
+			}
+		}
+	}
+
+	name := settings.Name
+	if name == "" {
+		cwd, _ := os.Getwd()
+		name = filepath.Base(cwd)
+	}
+	service := deploygen.ResourceName(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	templatePath := filepath.Join(dir, "template.yaml")
+	template := lambdagen.GenerateSAM(service, functions, opts)
+	if settings.Serverless.Framework == "serverless" {
+		templatePath = filepath.Join(dir, "serverless.yml")
+		template = lambdagen.GenerateServerless(service, functions, opts)
+	}
+	if err := os.WriteFile(templatePath, template, 0644); err != nil {
+		return nil, err
+	}
+	return append(outputs, templatePath), nil
+}
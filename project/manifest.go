@@ -0,0 +1,197 @@
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestPath lists every generated file after each build, for clean, the
+// dev server, packaging and deploy tooling
+var ManifestPath = filepath.Join("generated", "manifest.json")
+
+// Manifest describes the generated artifacts of a project
+type Manifest struct {
+	Version   string     `json:"version"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// Artifact is one generated file
+type Artifact struct {
+	Path    string `json:"path"`              // e.g. generated/go/user.go
+	Source  string `json:"source"`            // the .cp file it was generated from
+	Target  string `json:"target"`            // the generator that wrote it, e.g. "go"
+	Hash    string `json:"hash"`              // SHA-256 of the contents, hex encoded
+	Command string `json:"command,omitempty"` // the gen command that wrote it, e.g. "gen server"; empty for the build
+}
+
+// newManifest lists the outputs recorded in the build cache and those of gen
+// commands in the last manifest, sorted by path
+func newManifest(c *buildCache) *Manifest {
+	// The cache is discarded when cloudpact.yaml changes, so these are the
+	// options its outputs were written with
+	opts, _ := loadCodegenOptions()
+
+	manifest := &Manifest{Version: cacheVersion, Artifacts: []Artifact{}}
+	for source, entry := range c.Files {
+		for output, hash := range entry.Outputs {
+			manifest.Artifacts = append(manifest.Artifacts, Artifact{
+				Path:   filepath.ToSlash(output),
+				Source: filepath.ToSlash(source),
+				Target: artifactTarget(source, output, opts),
+				Hash:   hash,
+			})
+		}
+	}
+	// The build does not run gen commands, so what they wrote stays listed
+	if previous, err := LoadManifest(); err == nil {
+		built := manifest.hashes()
+		for _, artifact := range previous.Artifacts {
+			if _, ok := built[artifact.Path]; artifact.Command != "" && !ok {
+				manifest.Artifacts = append(manifest.Artifacts, artifact)
+			}
+		}
+	}
+	manifest.sort()
+	return manifest
+}
+
+func (m *Manifest) sort() {
+	sort.Slice(m.Artifacts, func(i, j int) bool {
+		return m.Artifacts[i].Path < m.Artifacts[j].Path
+	})
+}
+
+// RecordGenerated lists the files a gen command such as "gen server" wrote
+// in the manifest, so clean removes them, and removes the files it wrote
+// the last time it ran but not this time
+func RecordGenerated(command string, outputs []string) error {
+	manifest, err := LoadManifest()
+	if os.IsNotExist(err) {
+		manifest, err = &Manifest{Version: cacheVersion}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]bool, len(outputs))
+	for _, output := range outputs {
+		current[filepath.ToSlash(output)] = true
+	}
+	artifacts := []Artifact{}
+	for _, artifact := range manifest.Artifacts {
+		switch {
+		case current[artifact.Path]:
+			// Listed again below with its new hash
+		case artifact.Command == command:
+			if err := os.Remove(filepath.FromSlash(artifact.Path)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		default:
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	target := command
+	if words := strings.Fields(command); len(words) > 1 {
+		target = words[1]
+	}
+	for _, output := range outputs {
+		hash, err := hashFile(output)
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %w", output, err)
+		}
+		artifacts = append(artifacts, Artifact{Path: filepath.ToSlash(output), Target: target, Hash: hash, Command: command})
+	}
+	manifest.Artifacts = artifacts
+	manifest.sort()
+	return manifest.save()
+}
+
+// artifactTarget names the generator that writes output for source,
+// falling back to <name> of an output at generated/<name>/...
+func artifactTarget(source, output string, opts codegenOptions) string {
+	for _, t := range generators {
+		path := t.outputPath(source, nil, opts)
+		if path == output {
+			return t.gen.Name()
+		}
+		// Or the same file in a module's package directory
+		if t.byModule && filepath.Base(path) == filepath.Base(output) && filepath.Dir(path) == filepath.Dir(filepath.Dir(output)) {
+			return t.gen.Name()
+		}
+	}
+	parts := strings.Split(filepath.ToSlash(output), "/")
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[1]
+}
+
+func (m *Manifest) save() error {
+	if err := os.MkdirAll(filepath.Dir(ManifestPath), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ManifestPath, append(data, '\n'), 0644)
+}
+
+// LoadManifest reads the manifest written by the last build
+func LoadManifest() (*Manifest, error) {
+	data, err := os.ReadFile(ManifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", ManifestPath, err)
+	}
+	return &manifest, nil
+}
+
+// hashes maps each artifact path to its hash
+func (m *Manifest) hashes() map[string]string {
+	hashes := make(map[string]string, len(m.Artifacts))
+	for _, artifact := range m.Artifacts {
+		hashes[artifact.Path] = artifact.Hash
+	}
+	return hashes
+}
+
+// Clean removes every file listed in the manifest, then the manifest and the
+// build cache, and returns the files removed. Files neither the build nor a
+// gen command wrote are left alone.
+func Clean() ([]string, error) {
+	manifest, err := LoadManifest()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, artifact := range manifest.Artifacts {
+		path := filepath.FromSlash(artifact.Path)
+		if err := os.Remove(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, err
+		}
+		removed = append(removed, path)
+		// Drop the target directory once it is empty
+		os.Remove(filepath.Dir(path))
+	}
+	for _, path := range []string{ManifestPath, buildCachePath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,37 @@
+package project
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// MigrateSyntax rewrites the legacy model and assign-use declarations of the
+// .cp file at path, or of every .cp file under it, in the define record and
+// define type syntax. It returns the files it changed.
+func MigrateSyntax(path string) ([]string, error) {
+	cpFiles, err := FindCloudPactFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for _, source := range cpFiles {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return changed, err
+		}
+		migrated, count, err := grammar.MigrateSyntax(data)
+		if err != nil {
+			return changed, fmt.Errorf("%s: %w", source, err)
+		}
+		if count == 0 {
+			continue
+		}
+		if err := os.WriteFile(source, migrated, 0644); err != nil {
+			return changed, err
+		}
+		changed = append(changed, source)
+	}
+	return changed, nil
+}
@@ -0,0 +1,46 @@
+package project
+
+import (
+	"io"
+	"maps"
+)
+
+// GenerateMocks runs the mocks target on every .cp file, whether or not
+// cloudpact.yaml enables it, and returns the paths written: for each file
+// with functions, <dir>/<package>/<file>_mock.go with an interface per
+// module, its implementation by the generated functions and a mock for
+// tests. The files belong in the package of the generated Go, so an empty
+// outDir means the configured go directory, and a file without a module
+// goes directly in it.
+func GenerateMocks(outDir string) ([]string, error) {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+	if outDir != "" {
+		opts.Outputs = maps.Clone(opts.Outputs)
+		if opts.Outputs == nil {
+			opts.Outputs = make(map[string]string)
+		}
+		opts.Outputs["go"] = outDir
+	}
+	opts.goPackages = projectGoPackages(cpFiles, opts)
+
+	var outputs []string
+	for _, source := range cpFiles {
+		written, err := buildFile(io.Discard, source, []*target{findTarget("mocks")}, opts, types)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, written...)
+	}
+	return outputs, nil
+}
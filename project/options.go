@@ -0,0 +1,93 @@
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/config"
+)
+
+// extraOutputs are the outputs of commands other than build that
+// cloudpact.yaml may redirect alongside the generators
+var extraOutputs = []string{"asyncapi", "csharp", "datadict", "db", "deploy", "docs", "forms", "jsonschema", "lambda", "package", "postman", "rust", "terraform"}
+
+// codegenOptions are the cloudpact.yaml settings that shape generated code
+type codegenOptions struct {
+	// TrackChanges adds dirty-field tracking and Patch types to records
+	TrackChanges bool
+	// Targets names the generators to run; empty means all registered
+	Targets []string
+	// Locale picks the why.<locale> translations emitted into comments and
+	// API descriptions; empty means the untranslated why clauses
+	Locale string
+	// JSONNames is the naming policy of JSON keys, one of
+	// grammar.JSONNamings; empty means lowercased names
+	JSONNames string
+	// VerifyGo runs go build and go vet on the generated Go after a build
+	VerifyGo bool
+	// Tracing instruments the generated Go with OpenTelemetry
+	Tracing bool
+	// Logging adds log/slog calls to the generated Go
+	Logging bool
+	// Outputs maps generator and command names to the directories their
+	// files go to instead of generated/<name>
+	Outputs map[string]string
+
+	// goPackages maps records of modules to the import path of their Go
+	// package; builds fill it in from the project's sources
+	goPackages map[string]string
+}
+
+// outputDir is where the files of the generator or command name go
+func (o codegenOptions) outputDir(name string) string {
+	if dir, ok := o.Outputs[name]; ok {
+		return filepath.Clean(dir)
+	}
+	return filepath.Join("generated", name)
+}
+
+// loadCodegenOptions reads code generation settings from cloudpact.yaml;
+// a missing file means defaults
+func loadCodegenOptions() (codegenOptions, error) {
+	cfg, err := config.Load(config.FileName)
+	if err != nil {
+		return codegenOptions{}, err
+	}
+	opts := codegenOptions{TrackChanges: cfg.TrackChanges, Targets: cfg.Targets, Locale: cfg.Locale, JSONNames: cfg.JSONNames, VerifyGo: cfg.VerifyGo, Tracing: cfg.Observability.Tracing, Logging: cfg.Observability.Logging, Outputs: cfg.Outputs}
+	for name := range opts.Outputs {
+		if t := findTarget(name); t != nil && t.dirOf != "" {
+			return opts, fmt.Errorf("output %q in cloudpact.yaml cannot be moved: its files go beside the %s output", name, t.dirOf)
+		}
+		if findTarget(name) == nil && !slices.Contains(extraOutputs, name) {
+			known := append([]string(nil), extraOutputs...)
+			for _, t := range generators {
+				known = append(known, t.gen.Name())
+			}
+			sort.Strings(known)
+			return opts, fmt.Errorf("unknown output %q in cloudpact.yaml (available: %s)", name, strings.Join(known, ", "))
+		}
+	}
+	return opts, nil
+}
+
+// OutputDir is the directory the generator or command name writes to:
+// generated/<name> unless cloudpact.yaml redirects it
+func OutputDir(name string) (string, error) {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return "", err
+	}
+	return opts.outputDir(name), nil
+}
+
+// outputDirOr is out when a command was given one, or the configured
+// directory of name
+func outputDirOr(out, name string) (string, error) {
+	if out != "" {
+		return out, nil
+	}
+	return OutputDir(name)
+}
@@ -0,0 +1,301 @@
+package project
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// BuildError describes a failed build in a form the dev server can render
+type BuildError struct {
+	File       string `json:"file,omitempty"`
+	Line       int    `json:"line,omitempty"`
+	Column     int    `json:"column,omitempty"`
+	Code       string `json:"code,omitempty"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+	Source     string `json:"source,omitempty"`
+}
+
+// NewBuildError extracts file, line, column and the offending source line
+// from a Build error. Errors without position information only carry a message.
+func NewBuildError(err error) *BuildError {
+	if err == nil {
+		return nil
+	}
+
+	buildErr := &BuildError{Message: err.Error()}
+
+	var diag *grammar.Diagnostic
+	if errors.As(err, &diag) {
+		start := diag.Range.Start
+		buildErr.File = start.File
+		buildErr.Line = start.Line
+		buildErr.Column = start.Column
+		buildErr.Code = diag.Code
+		buildErr.Message = diag.Message
+		buildErr.Suggestion = diag.Suggestion
+		buildErr.Source = sourceLine(start.File, start.Line)
+	}
+
+	return buildErr
+}
+
+// sourceLine returns the given 1-based line of a file, or "" if unavailable
+func sourceLine(filename string, line int) string {
+	if filename == "" || line < 1 {
+		return ""
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(content), "\n")
+	if line > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line-1], "\r")
+}
+
+// devServer tracks the last build result and the browsers listening on the
+// reload channel
+type devServer struct {
+	mu      sync.Mutex
+	lastErr *BuildError
+	clients map[chan string]struct{}
+	hashes  map[string]string // artifact hashes of the last successful build
+}
+
+func newDevServer() *devServer {
+	return &devServer{clients: make(map[chan string]struct{})}
+}
+
+// rebuild runs a build and pushes the outcome to connected browsers
+func (s *devServer) rebuild() error {
+	err := Build()
+	s.setResult(err)
+	return err
+}
+
+// rebuildFiles rebuilds a watcher change set. While an earlier build is
+// failing a full build runs instead, so errors in other files are not lost.
+func (s *devServer) rebuildFiles(changed []string) error {
+	var err error
+	if s.currentError() != nil {
+		err = Build()
+	} else {
+		err = BuildFiles(changed)
+	}
+	s.setResult(err)
+	return err
+}
+
+// setResult records a build outcome. A successful build reloads pages only
+// when it changed an artifact in the manifest or fixed a failing build; the
+// event lists the artifacts that changed.
+func (s *devServer) setResult(err error) {
+	buildErr := NewBuildError(err)
+
+	var hashes map[string]string
+	if buildErr == nil {
+		if manifest, err := LoadManifest(); err == nil {
+			hashes = manifest.hashes()
+		}
+	}
+
+	s.mu.Lock()
+	wasFailing := s.lastErr != nil
+	s.lastErr = buildErr
+	previous := s.hashes
+	if hashes != nil {
+		s.hashes = hashes
+	}
+	s.mu.Unlock()
+
+	if buildErr != nil {
+		payload, _ := json.Marshal(buildErr)
+		s.broadcast("event: error\ndata: " + string(payload) + "\n\n")
+		return
+	}
+
+	changed := []string{}
+	for path, hash := range hashes {
+		if previous[path] != hash {
+			changed = append(changed, path)
+		}
+	}
+	for path := range previous {
+		if _, ok := hashes[path]; !ok && hashes != nil {
+			changed = append(changed, path)
+		}
+	}
+	if len(changed) == 0 && hashes != nil && previous != nil && !wasFailing {
+		return
+	}
+	sort.Strings(changed)
+	payload, _ := json.Marshal(map[string][]string{"changed": changed})
+	s.broadcast("event: reload\ndata: " + string(payload) + "\n\n")
+}
+
+func (s *devServer) currentError() *BuildError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+func (s *devServer) broadcast(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		select {
+		case client <- msg:
+		default:
+			// Slow client; it will pick up the state on its next reconnect
+		}
+	}
+}
+
+// handleEvents serves the reload channel as a server-sent event stream
+func (s *devServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	client := make(chan string, 4)
+	s.mu.Lock()
+	s.clients[client] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+	}()
+
+	// Send the current state so a freshly loaded page knows whether to show the overlay
+	if buildErr := s.currentError(); buildErr != nil {
+		payload, _ := json.Marshal(buildErr)
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", payload)
+	} else {
+		fmt.Fprint(w, "event: ok\ndata: {}\n\n")
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-client:
+			fmt.Fprint(w, msg)
+			flusher.Flush()
+		}
+	}
+}
+
+// handleStatic serves the web directory, replacing HTML pages with the error
+// overlay while the last build is failing and injecting the reload client
+func (s *devServer) handleStatic(root string) http.Handler {
+	files := http.FileServer(http.Dir(root))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if strings.HasSuffix(path, "/") {
+			path += "index.html"
+		}
+		if !strings.HasSuffix(path, ".html") {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		if buildErr := s.currentError(); buildErr != nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			if err := overlayTemplate.Execute(w, buildErr); err != nil {
+				fmt.Fprintf(w, "build failed: %s", buildErr.Message)
+			}
+			return
+		}
+
+		content, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(path)))
+		if err != nil {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(injectReloadClient(content))
+	})
+}
+
+// injectReloadClient adds the reload channel script before the closing body tag
+func injectReloadClient(page []byte) []byte {
+	script := []byte("<script>" + reloadClientJS + "</script>\n")
+	if idx := bytes.LastIndex(page, []byte("</body>")); idx >= 0 {
+		out := make([]byte, 0, len(page)+len(script))
+		out = append(out, page[:idx]...)
+		out = append(out, script...)
+		return append(out, page[idx:]...)
+	}
+	return append(page, script...)
+}
+
+const reloadClientJS = `(function () {
+  var source = new EventSource("/__cloudpact/events");
+  var failing = document.getElementById("cloudpact-error-overlay") !== null;
+  source.addEventListener("reload", function () { location.reload(); });
+  source.addEventListener("ok", function () { if (failing) location.reload(); });
+  source.addEventListener("error", function (e) { if (e.data && !failing) location.reload(); });
+})();`
+
+var overlayTemplate = template.Must(template.New("overlay").Funcs(template.FuncMap{
+	"caret": func(column int) template.HTML {
+		if column < 1 {
+			column = 1
+		}
+		// Offset by the width of the "%4d | " line-number gutter
+		return template.HTML(strings.Repeat(" ", column+6) + `<span class="caret">^</span>`)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>CloudPact build failed</title>
+<style>
+  body { margin: 0; background: #1f2937; color: #f9fafb; font-family: ui-monospace, Menlo, monospace; }
+  #cloudpact-error-overlay { max-width: 960px; margin: 48px auto; padding: 24px; background: #111827; border-top: 4px solid #ef4444; }
+  h1 { margin-top: 0; font-size: 20px; color: #fca5a5; }
+  .location { color: #93c5fd; margin-bottom: 16px; }
+  pre { background: #000; padding: 12px; overflow-x: auto; }
+  .caret { color: #ef4444; }
+</style>
+</head>
+<body>
+<div id="cloudpact-error-overlay">
+  <h1>Build failed</h1>
+  {{if .File}}<div class="location">{{.File}}{{if .Line}}:{{.Line}}:{{.Column}}{{end}}</div>{{end}}
+  <pre>{{.Message}}</pre>
+  {{if .Source}}<pre>{{printf "%4d | " .Line}}{{.Source}}
+{{caret .Column}}</pre>{{end}}
+  {{if .Suggestion}}<p class="suggestion">Suggestion: {{.Suggestion}}</p>{{end}}
+  <p>Fix the error and save; this page reloads automatically.</p>
+</div>
+<script>` + reloadClientJS + `</script>
+</body>
+</html>
+`))
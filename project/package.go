@@ -0,0 +1,320 @@
+package project
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/daveroberts0321/cloudpact/config"
+)
+
+// compileTypeScript compiles the .ts files in srcDir into JavaScript and
+// declaration files in outDir
+var compileTypeScript = func(srcDir, outDir string, files []string) error {
+	tsc, err := exec.LookPath("tsc")
+	if err != nil {
+		return fmt.Errorf("tsc not found; install TypeScript with npm install -g typescript")
+	}
+	args := []string{"--declaration", "--target", "es2019", "--module", "commonjs", "--skipLibCheck", "--outDir", outDir}
+	for _, file := range files {
+		args = append(args, filepath.Join(srcDir, file))
+	}
+	output, err := exec.Command(tsc, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tsc failed: %w\n%s", err, output)
+	}
+	return nil
+}
+
+// packageEpoch stamps every archive entry, so packaging the same build
+// twice gives identical archives
+var packageEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Package builds the project and packages the generated SDKs as version:
+// the TypeScript output as an npm tarball and the Go output as a module
+// zip, with the .mod and .info files a module proxy serves. version
+// defaults to the version in cloudpact.yaml. It returns the files written.
+func Package(version string) ([]string, error) {
+	settings, err := config.Load(config.FileName)
+	if err != nil {
+		return nil, err
+	}
+	if version == "" {
+		version = settings.Version
+	}
+	if version == "" {
+		return nil, fmt.Errorf("no version given and none set in cloudpact.yaml")
+	}
+	version = strings.TrimPrefix(version, "v")
+	if settings.Name == "" {
+		dir, _ := os.Getwd()
+		settings.Name = filepath.Base(dir)
+	}
+
+	if err := Build(); err != nil {
+		return nil, err
+	}
+	manifest, err := LoadManifest()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := OutputDir("package")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var outputs []string
+	if ts := manifestFiles(manifest, "ts"); len(ts) > 0 {
+		tarball, err := packageNPM(settings, dir, version, ts)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, tarball)
+	}
+	if goFiles := manifestFiles(manifest, "go"); len(goFiles) > 0 {
+		goDir, err := OutputDir("go")
+		if err != nil {
+			return nil, err
+		}
+		files, err := packageGoModule(settings, dir, "v"+version, goDir, goFiles)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, files...)
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("nothing to package; enable the go or ts target")
+	}
+	return outputs, nil
+}
+
+// manifestFiles lists the artifacts of target
+func manifestFiles(manifest *Manifest, target string) []string {
+	var files []string
+	for _, artifact := range manifest.Artifacts {
+		if artifact.Target == target {
+			files = append(files, filepath.FromSlash(artifact.Path))
+		}
+	}
+	return files
+}
+
+// npmPackageName turns a project name into a valid npm package name
+func npmPackageName(settings *config.Config) string {
+	if settings.Package.NPMName != "" {
+		return settings.Package.NPMName
+	}
+	return strings.ReplaceAll(strings.ToLower(settings.Name), " ", "-") + "-sdk"
+}
+
+// packageNPM compiles the TypeScript sources and writes
+// <dir>/<name>-<version>.tgz, laid out as npm pack does
+func packageNPM(settings *config.Config, dir, version string, sources []string) (string, error) {
+	stage, err := os.MkdirTemp("", "cloudpact-npm-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(stage)
+	srcDir := filepath.Join(stage, "src")
+	distDir := filepath.Join(stage, "dist")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		return "", err
+	}
+
+	var index strings.Builder
+	index.WriteString("// Generated from CloudPact\n")
+	var files []string
+	for _, source := range sources {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return "", err
+		}
+		name := filepath.Base(source)
+		if err := os.WriteFile(filepath.Join(srcDir, name), data, 0644); err != nil {
+			return "", err
+		}
+		files = append(files, name)
+		fmt.Fprintf(&index, "export * from \"./%s\";\n", strings.TrimSuffix(name, ".ts"))
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "index.ts"), []byte(index.String()), 0644); err != nil {
+		return "", err
+	}
+	files = append(files, "index.ts")
+	if err := compileTypeScript(srcDir, distDir, files); err != nil {
+		return "", err
+	}
+
+	name := npmPackageName(settings)
+	pkg := map[string]interface{}{
+		"name":    name,
+		"version": version,
+		"main":    "dist/index.js",
+		"types":   "dist/index.d.ts",
+		"files":   []string{"dist", "src"},
+	}
+	pkgJSON, err := json.MarshalIndent(pkg, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	entries := map[string][]byte{"package/package.json": append(pkgJSON, '\n')}
+	for _, dir := range []string{"src", "dist"} {
+		err := filepath.Walk(filepath.Join(stage, dir), func(file string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(stage, file)
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(file)
+			entries["package/"+filepath.ToSlash(rel)] = data
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	base := strings.TrimPrefix(strings.ReplaceAll(name, "/", "-"), "@")
+	output := filepath.Join(dir, fmt.Sprintf("%s-%s.tgz", base, version))
+	return output, writeTarball(output, entries)
+}
+
+// writeTarball writes entries as a gzipped tar in name order
+func writeTarball(output string, entries map[string][]byte) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.ModTime = packageEpoch
+	tw := tar.NewWriter(gz)
+	for _, name := range sortedNames(entries) {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(entries[name])), ModTime: packageEpoch, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entries[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(output, buf.Bytes(), 0644)
+}
+
+func sortedNames(entries map[string][]byte) []string {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// packageGoModule writes the module zip, .mod and .info files for version
+// under dir, named as a module proxy serves them, and uploads them when a
+// proxy is configured. Sources keep their paths relative to goDir.
+func packageGoModule(settings *config.Config, dir, version, goDir string, sources []string) ([]string, error) {
+	module := settings.GoModule
+	if module == "" {
+		return nil, fmt.Errorf("set go_module in cloudpact.yaml to package the Go code")
+	}
+	goMod := []byte(fmt.Sprintf("module %s\n\ngo 1.22\n", module))
+	info, err := json.Marshal(map[string]interface{}{"Version": version, "Time": packageEpoch})
+	if err != nil {
+		return nil, err
+	}
+
+	// A module zip holds every file under <module>@<version>/
+	prefix := module + "@" + version + "/"
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	files := map[string][]byte{prefix + "go.mod": goMod}
+	for _, source := range sources {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		// Module packages keep their directories under the Go output
+		name, err := filepath.Rel(goDir, source)
+		if err != nil || strings.HasPrefix(name, "..") {
+			name = filepath.Base(source)
+		}
+		files[prefix+filepath.ToSlash(name)] = data
+	}
+	for _, name := range sortedNames(files) {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: packageEpoch})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	base := filepath.Join(dir, path.Base(module)+"@"+version)
+	parts := []struct {
+		ext  string
+		data []byte
+	}{{".zip", buf.Bytes()}, {".mod", goMod}, {".info", info}}
+	var outputs []string
+	for _, part := range parts {
+		if err := os.WriteFile(base+part.ext, part.data, 0644); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, base+part.ext)
+	}
+
+	if proxy := settings.Package.GoProxy; proxy != "" {
+		for _, part := range parts {
+			url := fmt.Sprintf("%s/%s/@v/%s%s", strings.TrimSuffix(proxy, "/"), module, version, part.ext)
+			if err := upload(url, part.data); err != nil {
+				return outputs, err
+			}
+		}
+	}
+	return outputs, nil
+}
+
+// upload PUTs data to a module proxy, authenticating with GOPROXY_TOKEN
+// when it is set
+func upload(url string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if token := os.Getenv("GOPROXY_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("upload to %s failed: %s %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// policyPackage is the Go package, under the go directory, that
+// GeneratePolicy writes the authorization checks to
+const policyPackage = "policy"
+
+// GeneratePolicy writes the Go package policy under the configured go
+// directory, deciding what callers may do from the roles and allow rules of
+// every file, and returns the path written. Roles are shared by the project,
+// so each is declared once, and every role an allow rule or a requires
+// clause names must be declared.
+func GeneratePolicy() (string, error) {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return "", err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return "", err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return "", err
+	}
+
+	var files []*grammar.File
+	declared := make(map[string]string) // role to its file
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return "", err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return "", fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		if file.Module != nil && gogen.PackageName(file) == policyPackage {
+			return "", fmt.Errorf("module %s of %s would share the Go package %s with the authorization checks; rename the module", file.Module.Name, source, policyPackage)
+		}
+		file.Localize(opts.Locale)
+		for _, role := range file.Roles {
+			if other, ok := declared[role.Name]; ok {
+				return "", fmt.Errorf("role %s is declared in both %s and %s", role.Name, other, source)
+			}
+			declared[role.Name] = source
+		}
+		files = append(files, file)
+	}
+	if len(declared) == 0 {
+		return "", fmt.Errorf("no roles declared; declare them with define role")
+	}
+	for i, file := range files {
+		for _, allow := range file.Allows {
+			if _, ok := declared[allow.Role]; !ok {
+				return "", fmt.Errorf("%s allows undeclared role %s to %s %s; declare it with define role %s", cpFiles[i], allow.Role, allow.Actions[0], allow.Resource, allow.Role)
+			}
+		}
+		for _, function := range file.Functions {
+			if function.Access == nil {
+				continue
+			}
+			for _, role := range function.Access.Roles {
+				if _, ok := declared[role]; !ok {
+					return "", fmt.Errorf("function %s of %s requires undeclared role %s; declare it with define role %s", function.Name, cpFiles[i], role, role)
+				}
+			}
+		}
+	}
+
+	header := fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)
+	dir := filepath.Join(opts.outputDir("go"), policyPackage)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "policy.go")
+	code, err := gogen.GeneratePolicy(files, gogen.Options{Header: header})
+	if err != nil {
+		return "", writeInvalidGo(path, code, err)
+	}
+	if err := os.WriteFile(path, code, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -1,748 +1,689 @@
 package project
 
 import (
+	"bytes"
 	"context"
-	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/config"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/mock"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
 	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/postman"
 	"github.com/daveroberts0321/cloudpact/spec/openapi"
 	"github.com/daveroberts0321/cloudpact/watch"
 )
 
-//go:embed templates/*
-var templates embed.FS
-
-// Init creates a new CloudPact project with scaffolding
-func Init(name string) error {
-	// Create project directory
-	if err := os.MkdirAll(name, 0755); err != nil {
-		return fmt.Errorf("failed to create project directory: %w", err)
-	}
-
-	// Create directory structure
-	dirs := []string{
-		filepath.Join(name, "models"),
-		filepath.Join(name, "services"),
-		filepath.Join(name, "generated", "go"),
-		filepath.Join(name, "generated", "ts"),
-		filepath.Join(name, "generated", "openapi"),
-		filepath.Join(name, "web"),
-		filepath.Join(name, "cmd", "ai-integration", "context"),
-		filepath.Join(name, "cmd", "ai-integration", "cache"),
-	}
-
-	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", dir, err)
-		}
-	}
-
-	// Write template files
-	templateFiles := map[string]string{
-		"cloudpact.yaml":           "templates/cloudpact.yaml",
-		"models/user.cp":           "templates/user.cp",
-		"services/user_service.cp": "templates/user_service.cp",
-		"web/index.html":           "templates/index.html",
-		"web/main.ts":              "templates/main.ts",
-		"go.mod":                   "templates/go.mod.tmpl",
-		"README.md":                "templates/README.md",
-		".gitignore":               "templates/gitignore",
-	}
-
-	for filePath, templatePath := range templateFiles {
-		if err := writeTemplateFile(name, filePath, templatePath, name); err != nil {
-			return fmt.Errorf("failed to write %s: %w", filePath, err)
-		}
-	}
-
-	return nil
-}
+// StartDevServer starts the development server with file watching and hot reload
+func StartDevServer() error {
+	fmt.Println("Starting CloudPact development server...")
 
-func writeTemplateFile(projectDir, filePath, templatePath, projectName string) error {
-	content, err := templates.ReadFile(templatePath)
+	cfg, err := config.Load(config.FileName)
 	if err != nil {
 		return err
 	}
 
-	contentStr := string(content)
-	contentStr = strings.ReplaceAll(contentStr, "{{.ProjectName}}", projectName)
-	contentStr = strings.ReplaceAll(contentStr, "{{.ModuleName}}", strings.ToLower(projectName))
-
-	fullPath := filepath.Join(projectDir, filePath)
-	return os.WriteFile(fullPath, []byte(contentStr), 0644)
-}
+	server := newDevServer()
 
-// StartDevServer starts the development server with file watching and hot reload
-func StartDevServer() error {
-	fmt.Println("Starting CloudPact development server...")
-
-	if err := Build(); err != nil {
-		return fmt.Errorf("initial build failed: %w", err)
+	// A failing initial build is shown in the browser overlay instead of aborting
+	if err := server.rebuild(); err != nil {
+		fmt.Printf("Build failed: %v\n", err)
 	}
 
 	go func() {
-		if err := watch.Watch(context.Background(), Build); err != nil {
+		if err := watch.Watch(context.Background(), server.rebuildFiles); err != nil {
 			log.Printf("File watcher error: %v", err)
 		}
 	}()
 
-	http.Handle("/", http.FileServer(http.Dir("./web")))
+	http.Handle("/", server.handleStatic("./web"))
+	http.HandleFunc("/__cloudpact/events", server.handleEvents)
 	http.Handle("/generated/", http.StripPrefix("/generated/", http.FileServer(http.Dir("./generated"))))
+	http.Handle("/docs/", server.handleAPIDocs())
+	http.Handle("/docs", http.RedirectHandler("/docs/", http.StatusMovedPermanently))
 
 	http.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, `{"status": "ok", "timestamp": "%s"}`, time.Now().Format(time.RFC3339))
 	})
 
-	port := 8080
+	port := cfg.Port
 	fmt.Printf("Server running at http://localhost:%d\n", port)
-	fmt.Println("   Frontend: http://localhost:8080")
-	fmt.Println("   API: http://localhost:8080/api/health")
-	fmt.Println("   Generated files: http://localhost:8080/generated/")
+	fmt.Printf("   Frontend: http://localhost:%d\n", port)
+	fmt.Printf("   API: http://localhost:%d/api/health\n", port)
+	fmt.Printf("   Generated files: http://localhost:%d/generated/\n", port)
+	fmt.Printf("   API docs: http://localhost:%d/docs/ (Redoc: /docs/redoc)\n", port)
 	fmt.Println("\nWatching for file changes...")
 
 	return http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
 }
 
-// Build compiles all .cp files in the project
-func Build() error {
-	fmt.Println("Building CloudPact project...")
+// StartMockServer builds the project and serves example responses for every
+// operation in the generated OpenAPI specs
+func StartMockServer() error {
+	fmt.Println("Starting CloudPact mock API server...")
 
-	cpFiles, err := FindCloudPactFiles(".")
+	cfg, err := config.Load(config.FileName)
 	if err != nil {
 		return err
 	}
-
-	if len(cpFiles) == 0 {
-		fmt.Println("   No .cp files found")
-		return nil
+	specs, err := generatedSpecs()
+	if err != nil {
+		return err
 	}
 
-	for _, file := range cpFiles {
-		fmt.Printf("   Processing %s...\n", file)
+	server, err := mock.Load(specs...)
+	if err != nil {
+		return fmt.Errorf("failed to load OpenAPI specs: %w", err)
+	}
 
-		parsedFile, err := ParseCloudPactFile(file)
-		if err != nil {
-			return fmt.Errorf("failed to parse %s: %w", file, err)
-		}
+	port := cfg.Port
+	fmt.Printf("Mock API running at http://localhost:%d\n", port)
+	for _, route := range server.Routes() {
+		fmt.Printf("   %-6s %s\n", route.Method, route.Path)
+	}
 
-		if err := generateGoCode(parsedFile, file); err != nil {
-			return fmt.Errorf("failed to generate Go code for %s: %w", file, err)
-		}
+	return http.ListenAndServe(fmt.Sprintf(":%d", port), server)
+}
 
-		if err := generateTSCode(parsedFile, file); err != nil {
-			return fmt.Errorf("failed to generate TypeScript code for %s: %w", file, err)
-		}
+// GeneratePostman builds the project and exports every operation in the
+// generated OpenAPI specs as a Postman collection and environment, named
+// after the project directory, into outDir or the configured postman directory
+func GeneratePostman(outDir string) ([]string, error) {
+	specs, err := generatedSpecs()
+	if err != nil {
+		return nil, err
+	}
 
-		specPath := filepath.Join("generated", "openapi", strings.TrimSuffix(filepath.Base(file), ".cp")+".yaml")
-		if err := openapi.WriteFile(parsedFile, specPath); err != nil {
-			return fmt.Errorf("failed to generate OpenAPI spec for %s: %w", file, err)
-		}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
 	}
+	name := filepath.Base(cwd)
 
-	fmt.Printf("Built %d CloudPact files\n", len(cpFiles))
-	return nil
-}
+	collection, environment, err := postman.Load(name, specs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OpenAPI specs: %w", err)
+	}
 
-func ParseCloudPactFile(filename string) (*grammar.File, error) {
-	f, err := os.Open(filename)
+	dir, err := outputDirOr(outDir, "postman")
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	return grammar.ParseWithFilename(f, filename)
-}
-
-func FindCloudPactFiles(root string) ([]string, error) {
-	var files []string
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	outputs := []string{
+		filepath.Join(dir, name+".postman_collection.json"),
+		filepath.Join(dir, name+".postman_environment.json"),
+	}
+	for i, v := range []interface{}{collection, environment} {
+		data, err := json.MarshalIndent(v, "", "  ")
 		if err != nil {
-			return err
-		}
-		if strings.Contains(path, "generated") || strings.Contains(path, "cmd/ai-integration/cache") {
-			return nil
+			return nil, err
 		}
-		if strings.HasSuffix(path, ".cp") {
-			files = append(files, path)
+		if err := os.WriteFile(outputs[i], append(data, '\n'), 0644); err != nil {
+			return nil, err
 		}
-		return nil
-	})
-	return files, err
-}
-
-// --- helper functions for code generation (generateGoCode, generateTSCode, etc.) will be placed here ---
-
-// generateGoCode generates Go code from parsed CloudPact file with enhanced syntax support
-func generateGoCode(file *grammar.File, sourcePath string) error {
-	baseName := strings.TrimSuffix(filepath.Base(sourcePath), ".cp")
-	outputPath := filepath.Join("generated", "go", baseName+".go")
-
-	var goCode strings.Builder
-
-	// Package and imports
-	packageName := "main"
-	if file.Module != nil {
-		packageName = strings.ToLower(file.Module.Name)
 	}
+	return outputs, nil
+}
 
-	goCode.WriteString(fmt.Sprintf("package %s\n\n", packageName))
-	goCode.WriteString("import (\n")
-	goCode.WriteString("\t\"encoding/json\"\n")
-	goCode.WriteString("\t\"fmt\"\n")
-	goCode.WriteString("\t\"time\"\n")
-	goCode.WriteString("\t\"errors\"\n")
-	goCode.WriteString(")\n\n")
-
-	// Generate module comment if present
-	if file.Module != nil {
-		goCode.WriteString(fmt.Sprintf("// %s module generated from CloudPact\n", file.Module.Name))
-		goCode.WriteString("// This module contains business logic with embedded context\n\n")
+// LintOpenAPI checks the OpenAPI spec generated for each .cp file in
+// sources, or for every project file when sources is empty, with fields
+// named as json_names in cloudpact.yaml says. It also writes the equivalent
+// Spectral ruleset to generated/openapi/.spectral.yaml.
+func LintOpenAPI(sources []string) ([]openapi.Violation, error) {
+	if len(sources) == 0 {
+		var err error
+		if sources, err = FindCloudPactFiles("."); err != nil {
+			return nil, err
+		}
 	}
 
-	// Generate Records (new syntax)
-	for _, record := range file.Records {
-		goCode.WriteString(generateGoRecord(record))
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
 	}
-
-	// Generate Models (legacy syntax - for backward compatibility)
-	for _, model := range file.Models {
-		goCode.WriteString(generateGoModel(model))
+	var violations []openapi.Violation
+	for _, source := range sources {
+		file, err := ParseCloudPactFile(source)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, openapi.Lint(file, opts.JSONNames)...)
 	}
 
-	// Generate Functions with business logic
-	for _, function := range file.Functions {
-		goCode.WriteString(generateGoFunction(function))
+	dir := opts.outputDir("openapi")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
 	}
-
-	return os.WriteFile(outputPath, []byte(goCode.String()), 0644)
+	return violations, os.WriteFile(filepath.Join(dir, ".spectral.yaml"), []byte(openapi.SpectralRulesetFor(opts.JSONNames)), 0644)
 }
 
-// generateGoRecord creates Go struct from CloudPact record
-func generateGoRecord(record *grammar.Record) string {
-	var code strings.Builder
-
-	code.WriteString(fmt.Sprintf("// %s represents a %s entity\n", record.Name, strings.ToLower(record.Name)))
-	code.WriteString(fmt.Sprintf("type %s struct {\n", record.Name))
-
-	// Add ID field by default
-	code.WriteString("\tID string `json:\"id\" validate:\"required,uuid\"`\n")
+// SpecViolation is a problem ValidateOpenAPI found in a spec file
+type SpecViolation struct {
+	Spec string
+	openapi.Violation
+}
 
-	for _, field := range record.Fields {
-		goType := mapCloudPactTypeToGo(field.Type.Name)
-		jsonTag := strings.ToLower(field.Name)
-		validateTag := getValidationTag(field.Type.Name)
+func (v SpecViolation) String() string {
+	return fmt.Sprintf("%s: %s %s: %s (at %s)", v.Spec, v.Severity, v.Rule, v.Message, v.Path)
+}
 
-		tag := fmt.Sprintf("`json:\"%s\"", jsonTag)
-		if validateTag != "" {
-			tag += fmt.Sprintf(" validate:\"%s\"", validateTag)
+// ValidateOpenAPI checks OpenAPI documents, in YAML or JSON, against the
+// structure the OpenAPI 3.0 and 3.1 meta-schemas require. Without specs it
+// builds the project and checks the specs generated for it.
+func ValidateOpenAPI(specs []string) ([]SpecViolation, error) {
+	if len(specs) == 0 {
+		var err error
+		if specs, err = generatedSpecs(); err != nil {
+			return nil, err
 		}
-		tag += "`"
-
-		code.WriteString(fmt.Sprintf("\t%s %s %s\n", field.Name, goType, tag))
 	}
-
-	code.WriteString("}\n\n")
-	return code.String()
+	return validateSpecs(specs)
 }
 
-// generateGoModel creates Go struct from legacy CloudPact model
-func generateGoModel(model *grammar.Model) string {
-	var code strings.Builder
-
-	code.WriteString(fmt.Sprintf("// %s represents a %s entity (legacy model)\n", model.Name, strings.ToLower(model.Name)))
-	code.WriteString(fmt.Sprintf("type %s struct {\n", model.Name))
-
-	for _, field := range model.Fields {
-		goType := mapCloudPactTypeToGo(field.Type.Name)
-		jsonTag := fmt.Sprintf("`json:\"%s\"`", strings.ToLower(field.Name))
-		code.WriteString(fmt.Sprintf("\t%s %s %s\n", field.Name, goType, jsonTag))
+func validateSpecs(specs []string) ([]SpecViolation, error) {
+	var violations []SpecViolation
+	for _, spec := range specs {
+		found, err := openapi.ValidateFile(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", spec, err)
+		}
+		for _, v := range found {
+			violations = append(violations, SpecViolation{Spec: spec, Violation: v})
+		}
 	}
-
-	code.WriteString("}\n\n")
-	return code.String()
+	return violations, nil
 }
 
-// generateGoFunction creates Go function from CloudPact function with business context
-func generateGoFunction(function *grammar.Function) string {
-	var code strings.Builder
-
-	// Function signature
-	code.WriteString(fmt.Sprintf("// %s %s\n", function.Name, function.Why))
-
-	// Add AI annotations as comments
-	for _, annotation := range function.AIAnnotations {
-		code.WriteString(fmt.Sprintf("// AI %s: %s\n", annotation.Type, annotation.Content))
+// generatedSpecs builds the project and returns its OpenAPI specs
+func generatedSpecs() ([]string, error) {
+	if err := Build(); err != nil {
+		return nil, fmt.Errorf("build failed: %w", err)
 	}
 
-	code.WriteString(fmt.Sprintf("func %s(", function.Name))
-
-	// Parameters
-	for i, param := range function.Parameters {
-		if i > 0 {
-			code.WriteString(", ")
-		}
-		goType := mapCloudPactTypeToGo(param.Type.Name)
-		code.WriteString(fmt.Sprintf("%s %s", param.Name, goType))
+	dir, err := OutputDir("openapi")
+	if err != nil {
+		return nil, err
 	}
-
-	code.WriteString(")")
-
-	// Return type
-	if function.ReturnType != nil {
-		goType := mapCloudPactTypeToGo(function.ReturnType.Name)
-		code.WriteString(fmt.Sprintf(" %s", goType))
+	specs, err := specFiles(dir)
+	if err != nil {
+		return nil, err
 	}
-
-	code.WriteString(" {\n")
-
-	// Function body - convert CloudPact statements to Go
-	if function.Body != nil {
-		bodyCode := generateGoFunctionBody(function.Body)
-		code.WriteString(bodyCode)
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no OpenAPI specs found in %s", dir)
 	}
-
-	code.WriteString("}\n\n")
-	return code.String()
+	return specs, nil
 }
 
-// generateGoFunctionBody converts CloudPact function body to Go code
-func generateGoFunctionBody(body *grammar.FunctionBody) string {
-	var code strings.Builder
-
-	for _, stmt := range body.Statements {
-		switch s := stmt.(type) {
-		case *grammar.IfStatement:
-			code.WriteString(generateGoIfStatement(s))
-		case *grammar.ReturnStatement:
-			code.WriteString(generateGoReturnStatement(s))
-		case *grammar.AssignStatement:
-			code.WriteString(generateGoAssignStatement(s))
-		case *grammar.CreateStatement:
-			code.WriteString(generateGoCreateStatement(s))
-		case *grammar.FailStatement:
-			code.WriteString(generateGoFailStatement(s))
-		}
-	}
-
-	// Add native Go blocks
-	for _, nativeBlock := range body.NativeBlocks {
-		if nativeBlock.Language == "go" {
-			code.WriteString("\t// Native Go code block\n")
-			// Split code by lines and indent each line
-			lines := strings.Split(nativeBlock.Code, "\n")
-			for _, line := range lines {
-				if strings.TrimSpace(line) != "" {
-					code.WriteString(fmt.Sprintf("\t%s\n", line))
-				}
-			}
+// specFiles lists the OpenAPI specs in dir, skipping the .spectral.yaml
+// ruleset LintOpenAPI writes beside them
+func specFiles(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var specs []string
+	for _, match := range matches {
+		if !strings.HasPrefix(filepath.Base(match), ".") {
+			specs = append(specs, match)
 		}
 	}
-
-	return code.String()
+	return specs, nil
 }
 
-// generateGoIfStatement converts CloudPact if statement to Go
-func generateGoIfStatement(stmt *grammar.IfStatement) string {
-	var code strings.Builder
-
-	condition := generateGoExpression(stmt.Condition)
-	code.WriteString(fmt.Sprintf("\tif %s {\n", condition))
-
-	// Then body
-	if stmt.ThenStmt != nil {
-		thenCode := generateGoStatement(stmt.ThenStmt)
-		code.WriteString(fmt.Sprintf("\t\t%s\n", thenCode))
-	}
-
-	code.WriteString("\t}")
-
-	// Else body
-	if stmt.ElseStmt != nil {
-		code.WriteString(" else {\n")
-		elseCode := generateGoStatement(stmt.ElseStmt)
-		code.WriteString(fmt.Sprintf("\t\t%s\n", elseCode))
-		code.WriteString("\t}")
-	}
-
-	code.WriteString("\n")
-	return code.String()
+// Build compiles all .cp files in the project
+func Build() error {
+	_, err := BuildWith(context.Background(), BuildSettings{Log: os.Stdout})
+	return err
 }
 
-// generateGoReturnStatement converts CloudPact return to Go
-func generateGoReturnStatement(stmt *grammar.ReturnStatement) string {
-	if stmt.Value != nil {
-		value := generateGoExpression(stmt.Value)
-		return fmt.Sprintf("\treturn %s\n", value)
-	}
-	return "\treturn\n"
+// BuildSettings adjust one build beyond what cloudpact.yaml says
+type BuildSettings struct {
+	// Targets replaces the targets of cloudpact.yaml when not empty
+	Targets []string
+	// Force rebuilds sources the build cache considers unchanged
+	Force bool
+	// KeepGoing builds the remaining files after one fails to parse or
+	// check, so the report lists the problems of every file
+	KeepGoing bool
+	// VerifyGo compiles and vets the generated Go afterwards, as
+	// verify_go in cloudpact.yaml does
+	VerifyGo bool
+	// Strict validates the generated OpenAPI specs afterwards and fails
+	// the build when they break the OpenAPI meta-schema
+	Strict bool
+	// Log receives the progress lines the CLI prints; nil discards them
+	Log io.Writer
 }
 
-// generateGoAssignStatement converts CloudPact assignment to Go
-func generateGoAssignStatement(stmt *grammar.AssignStatement) string {
-	value := generateGoExpression(stmt.Value)
-	return fmt.Sprintf("\t%s := %s\n", stmt.Variable, value)
+// BuildReport is what one build did
+type BuildReport struct {
+	// Files are the sources built, in build order
+	Files []FileReport
+	// Unchanged are the sources skipped because the cache had them
+	Unchanged []string
+	// Diagnostics are the parse and check problems of sources that failed,
+	// followed by what verifying the generated Go found
+	Diagnostics []*grammar.Diagnostic
+	Duration    time.Duration
 }
 
-// generateGoCreateStatement converts CloudPact create statement to Go
-func generateGoCreateStatement(stmt *grammar.CreateStatement) string {
-	var code strings.Builder
-
-	code.WriteString(fmt.Sprintf("\t%s := &%s{\n", strings.ToLower(stmt.TypeName), stmt.TypeName))
-
-	for _, assignment := range stmt.Assignments {
-		value := generateGoExpression(assignment.Value)
-		code.WriteString(fmt.Sprintf("\t\t%s: %s,\n", assignment.Field, value))
-	}
-
-	code.WriteString("\t}\n")
-	return code.String()
+// FileReport is the build of one source
+type FileReport struct {
+	Source   string
+	Outputs  []string
+	Duration time.Duration
 }
 
-// generateGoFailStatement converts CloudPact fail to Go error
-func generateGoFailStatement(stmt *grammar.FailStatement) string {
-	return fmt.Sprintf("\treturn errors.New(\"%s\")\n", stmt.Message)
-}
+// BuildWith compiles all .cp files in the project, stopping between files
+// when ctx is done. The report is returned even when the build fails, and
+// covers the files handled before it stopped.
+func BuildWith(ctx context.Context, settings BuildSettings) (*BuildReport, error) {
+	started := time.Now()
+	report := &BuildReport{}
+	defer func() { report.Duration = time.Since(started) }()
 
-// generateGoStatement converts any CloudPact statement to Go
-func generateGoStatement(stmt grammar.Statement) string {
-	switch s := stmt.(type) {
-	case *grammar.IfStatement:
-		return strings.TrimSpace(generateGoIfStatement(s))
-	case *grammar.ReturnStatement:
-		return strings.TrimSpace(generateGoReturnStatement(s))
-	case *grammar.AssignStatement:
-		return strings.TrimSpace(generateGoAssignStatement(s))
-	case *grammar.CreateStatement:
-		return strings.TrimSpace(generateGoCreateStatement(s))
-	case *grammar.FailStatement:
-		return strings.TrimSpace(generateGoFailStatement(s))
-	default:
-		return "// Unknown statement type"
+	progress := settings.Log
+	if progress == nil {
+		progress = io.Discard
 	}
-}
+	fmt.Fprintln(progress, "Building CloudPact project...")
 
-// generateGoExpression converts CloudPact expressions to Go
-func generateGoExpression(expr grammar.Expression) string {
-	switch e := expr.(type) {
-	case *grammar.IdentifierExpression:
-		return e.Name
-	case *grammar.LiteralExpression:
-		return fmt.Sprintf("%v", e.Value)
-	case *grammar.BinaryExpression:
-		left := generateGoExpression(e.Left)
-		right := generateGoExpression(e.Right)
-
-		// Map CloudPact operators to Go
-		switch e.Operator {
-		case "contains":
-			return fmt.Sprintf("strings.Contains(%s, %s)", left, right)
-		case "not contains":
-			return fmt.Sprintf("!strings.Contains(%s, %s)", left, right)
-		default:
-			return fmt.Sprintf("%s %s %s", left, e.Operator, right)
-		}
-	case *grammar.MemberExpression:
-		object := generateGoExpression(e.Object)
-		return fmt.Sprintf("%s.%s", object, e.Property)
-	case *grammar.CallExpression:
-		var args []string
-		for _, arg := range e.Arguments {
-			args = append(args, generateGoExpression(arg))
-		}
-		return fmt.Sprintf("%s(%s)", e.Function, strings.Join(args, ", "))
-	default:
-		return "/* unknown expression */"
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return report, err
 	}
-}
 
-// generateTSCode generates TypeScript code from parsed CloudPact file
-func generateTSCode(file *grammar.File, sourcePath string) error {
-	baseName := strings.TrimSuffix(filepath.Base(sourcePath), ".cp")
-	outputPath := filepath.Join("generated", "ts", baseName+".ts")
+	if len(cpFiles) == 0 {
+		fmt.Fprintln(progress, "   No .cp files found")
+		return report, nil
+	}
 
-	var tsCode strings.Builder
-	tsCode.WriteString("// Generated TypeScript interfaces and functions from CloudPact\n")
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return report, err
+	}
+	if len(settings.Targets) > 0 {
+		opts.Targets = settings.Targets
+	}
+	targets, err := enabledTargets(opts)
+	if err != nil {
+		return report, err
+	}
 
-	if file.Module != nil {
-		tsCode.WriteString(fmt.Sprintf("// Module: %s\n", file.Module.Name))
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return report, err
 	}
+	opts.goPackages = projectGoPackages(cpFiles, opts)
 
-	tsCode.WriteString("// This code contains business logic with embedded context\n\n")
+	// Sources whose hash and outputs match the cache manifest are skipped
+	cache := loadBuildCache()
+	cache.useTypes(types.Fingerprint() + goPackagesFingerprint(opts.goPackages))
+	cache.prune(cpFiles)
+	defer saveBuildCache(cache)
 
-	// Generate Records (new syntax)
-	for _, record := range file.Records {
-		tsCode.WriteString(generateTSRecord(record))
+	var failed error
+	for _, file := range cpFiles {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if !settings.Force && cache.fresh(file) {
+			report.Unchanged = append(report.Unchanged, file)
+			continue
+		}
+		fileStarted := time.Now()
+		outputs, err := buildFile(progress, file, targets, opts, types)
+		if err != nil {
+			var d *grammar.Diagnostic
+			if !errors.As(err, &d) {
+				return report, err
+			}
+			report.Diagnostics = append(report.Diagnostics, d)
+			if !settings.KeepGoing {
+				return report, err
+			}
+			if failed == nil {
+				failed = err
+			}
+			continue
+		}
+		if err := removeStaleOutputs(cache, file, outputs, targets, opts); err != nil {
+			return report, err
+		}
+		if err := cache.record(file, outputs); err != nil {
+			return report, err
+		}
+		report.Files = append(report.Files, FileReport{Source: file, Outputs: outputs, Duration: time.Since(fileStarted)})
 	}
 
-	// Generate Models (legacy syntax)
-	for _, model := range file.Models {
-		tsCode.WriteString(generateTSModel(model))
+	if len(report.Diagnostics) > 1 {
+		return report, fmt.Errorf("%d CloudPact files have errors, the first: %w", len(report.Diagnostics), failed)
 	}
-
-	// Generate Functions
-	for _, function := range file.Functions {
-		tsCode.WriteString(generateTSFunction(function))
+	if failed != nil {
+		return report, failed
 	}
 
-	return os.WriteFile(outputPath, []byte(tsCode.String()), 0644)
+	if (settings.VerifyGo || opts.VerifyGo) && hasTarget(targets, "go") {
+		fmt.Fprintln(progress, "   Verifying generated Go...")
+		diagnostics, err := verifyGo(ctx, cache, opts)
+		if err != nil {
+			return report, err
+		}
+		var broken error
+		for _, d := range diagnostics {
+			fmt.Fprintln(progress, d.Format())
+			if d.Severity == grammar.SeverityError && broken == nil {
+				broken = d
+			}
+		}
+		report.Diagnostics = append(report.Diagnostics, diagnostics...)
+		if broken != nil {
+			return report, fmt.Errorf("generated Go does not compile: %w", broken)
+		}
+	}
+	if settings.Strict && hasTarget(targets, "openapi") {
+		fmt.Fprintln(progress, "   Validating generated OpenAPI...")
+		if err := validateGeneratedSpecs(progress, cpFiles, opts); err != nil {
+			return report, err
+		}
+	}
+	fmt.Fprintf(progress, "Built %d CloudPact files (%d unchanged)\n", len(report.Files), len(report.Unchanged))
+	return report, nil
 }
 
-// generateTSRecord creates TypeScript interface from CloudPact record
-func generateTSRecord(record *grammar.Record) string {
-	var code strings.Builder
-
-	code.WriteString(fmt.Sprintf("// %s interface\n", record.Name))
-	code.WriteString(fmt.Sprintf("export interface %s {\n", record.Name))
-
-	// Add ID field by default
-	code.WriteString("  id: string; // UUID\n")
-
-	for _, field := range record.Fields {
-		tsType := mapCloudPactTypeToTS(field.Type.Name)
-		comment := getTypeComment(field.Type.Name)
-
-		if comment != "" {
-			code.WriteString(fmt.Sprintf("  %s: %s; // %s\n", strings.ToLower(field.Name), tsType, comment))
-		} else {
-			code.WriteString(fmt.Sprintf("  %s: %s;\n", strings.ToLower(field.Name), tsType))
+// validateGeneratedSpecs validates the spec of each source, including
+// those the cache kept, printing what it finds to progress
+func validateGeneratedSpecs(progress io.Writer, cpFiles []string, opts codegenOptions) error {
+	openapiTarget := findTarget("openapi")
+	var specs []string
+	for _, file := range cpFiles {
+		spec := openapiTarget.outputPath(file, nil, opts)
+		if _, err := os.Stat(spec); err == nil {
+			specs = append(specs, spec)
 		}
 	}
-
-	code.WriteString("}\n\n")
-	return code.String()
+	violations, err := validateSpecs(specs)
+	if err != nil {
+		return err
+	}
+	for _, v := range violations {
+		fmt.Fprintln(progress, v)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("generated OpenAPI has %d problems, the first: %s", len(violations), violations[0])
+	}
+	return nil
 }
 
-// generateTSModel creates TypeScript interface from legacy CloudPact model
-func generateTSModel(model *grammar.Model) string {
-	var code strings.Builder
+// BuildFiles rebuilds only the given .cp files, such as the change set
+// reported by the watcher. Generated outputs of files that no longer exist
+// are removed. Files use the custom types of other files and, in the Go
+// output, the records of other modules, so when a change alters either,
+// every file is rebuilt with Build instead.
+func BuildFiles(paths []string) error {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return err
+	}
+	targets, err := enabledTargets(opts)
+	if err != nil {
+		return err
+	}
 
-	code.WriteString(fmt.Sprintf("// %s interface (legacy model)\n", model.Name))
-	code.WriteString(fmt.Sprintf("export interface %s {\n", model.Name))
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return err
+	}
+	opts.goPackages = projectGoPackages(cpFiles, opts)
+
+	// A change to the custom types or the modules of records can affect
+	// any file
+	cache := loadBuildCache()
+	if cache.Types != types.Fingerprint()+goPackagesFingerprint(opts.goPackages) {
+		return Build()
+	}
+	defer saveBuildCache(cache)
+
+	built := 0
+	for _, file := range paths {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			fmt.Printf("   Removing outputs of %s...\n", file)
+			// The deleted file's module is unknown, so prefer the
+			// outputs recorded when it was last built
+			outputs := outputPaths(file, opts)
+			if entry, ok := cache.Files[file]; ok {
+				outputs = nil
+				for output := range entry.Outputs {
+					outputs = append(outputs, output)
+				}
+			}
+			for _, output := range outputs {
+				if err := os.Remove(output); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+			delete(cache.Files, file)
+			continue
+		}
 
-	for _, field := range model.Fields {
-		tsType := mapCloudPactTypeToTS(field.Type.Name)
-		code.WriteString(fmt.Sprintf("  %s: %s;\n", strings.ToLower(field.Name), tsType))
+		// Editors sometimes rewrite a file without changing it
+		if cache.fresh(file) {
+			continue
+		}
+		outputs, err := buildFile(os.Stdout, file, targets, opts, types)
+		if err != nil {
+			return err
+		}
+		if err := removeStaleOutputs(cache, file, outputs, targets, opts); err != nil {
+			return err
+		}
+		if err := cache.record(file, outputs); err != nil {
+			return err
+		}
+		built++
 	}
 
-	code.WriteString("}\n\n")
-	return code.String()
+	fmt.Printf("Rebuilt %d of %d changed CloudPact files\n", built, len(paths))
+	return nil
 }
 
-// generateTSFunction creates TypeScript function from CloudPact function
-func generateTSFunction(function *grammar.Function) string {
-	var code strings.Builder
-
-	// Function comment with business context
-	code.WriteString(fmt.Sprintf("/**\n * %s\n", function.Why))
+// buildFile parses and checks one .cp file against the project's custom
+// types, then runs each target on it, returning the files written
+func buildFile(progress io.Writer, file string, targets []*target, opts codegenOptions, types analyzer.Types) ([]string, error) {
+	fmt.Fprintf(progress, "   Processing %s...\n", file)
 
-	// Add AI annotations as JSDoc comments
-	for _, annotation := range function.AIAnnotations {
-		code.WriteString(fmt.Sprintf(" * @%s %s\n", annotation.Type, annotation.Content))
+	parsedFile, source, err := checkedSource(file, opts, types)
+	if err != nil {
+		return nil, err
 	}
 
-	code.WriteString(" */\n")
-
-	// Function signature
-	code.WriteString(fmt.Sprintf("export function %s(", function.Name))
-
-	// Parameters
-	for i, param := range function.Parameters {
-		if i > 0 {
-			code.WriteString(", ")
+	var outputs []string
+	for _, t := range targets {
+		ctx := OutputContext{
+			SourcePath:   file,
+			OutputPath:   t.outputPath(file, parsedFile, opts),
+			TrackChanges: opts.TrackChanges,
+			Tracing:      opts.Tracing,
+			Logging:      opts.Logging,
+			GoPackages:   opts.goPackages,
+			Header:       codegen.Header(file, source),
 		}
-		tsType := mapCloudPactTypeToTS(param.Type.Name)
-		code.WriteString(fmt.Sprintf("%s: %s", param.Name, tsType))
+		if err := os.MkdirAll(filepath.Dir(ctx.OutputPath), 0755); err != nil {
+			return nil, err
+		}
+		if err := t.gen.Generate(parsedFile, ctx); err != nil {
+			return nil, fmt.Errorf("failed to generate %s output for %s: %w", t.gen.Name(), file, err)
+		}
+		if _, err := os.Stat(ctx.OutputPath); os.IsNotExist(err) {
+			continue
+		}
+		outputs = append(outputs, ctx.OutputPath)
 	}
+	return outputs, nil
+}
 
-	code.WriteString(")")
-
-	// Return type
-	if function.ReturnType != nil {
-		tsType := mapCloudPactTypeToTS(function.ReturnType.Name)
-		code.WriteString(fmt.Sprintf(": %s", tsType))
+// checkedSource reads, parses and checks a .cp file the way the build
+// passes it to generators, and returns it with its contents
+func checkedSource(file string, opts codegenOptions, types analyzer.Types) (*grammar.File, []byte, error) {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	parsedFile, err := grammar.ParseWithFilename(bytes.NewReader(source), file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", file, err)
 	}
 
-	code.WriteString(" {\n")
+	if err := analyzer.CheckWith(parsedFile, types); err != nil {
+		return nil, nil, fmt.Errorf("failed to check %s: %w", file, err)
+	}
+	parsedFile.Localize(opts.Locale)
+	parsedFile.NameJSON(opts.JSONNames)
+	return parsedFile, source, nil
+}
 
-	// Function body - simplified TypeScript generation
-	if function.Body != nil {
-		code.WriteString("  // Business logic implementation\n")
-		code.WriteString("  // TODO: Implement CloudPact function body translation\n")
+// outputPaths lists the files every registered target generates for a
+// source, in registration order: Go, TypeScript, OpenAPI, then any others.
+// A source that cannot be read or parsed is taken to declare no module.
+func outputPaths(sourcePath string, opts codegenOptions) []string {
+	var file *grammar.File
+	if source, err := os.ReadFile(sourcePath); err == nil {
+		file, _ = grammar.ParseWithFilename(bytes.NewReader(source), sourcePath)
+	}
+	var paths []string
+	for _, t := range generators {
+		paths = append(paths, t.outputPath(sourcePath, file, opts))
+	}
+	return paths
+}
 
-		// Add native TypeScript blocks
-		for _, nativeBlock := range function.Body.NativeBlocks {
-			if nativeBlock.Language == "ts" {
-				code.WriteString("  // Native TypeScript code block\n")
-				lines := strings.Split(nativeBlock.Code, "\n")
-				for _, line := range lines {
-					if strings.TrimSpace(line) != "" {
-						code.WriteString(fmt.Sprintf("  %s\n", line))
-					}
-				}
-			}
+// removeStaleOutputs deletes the files targets wrote at the last build of
+// source that outputs no longer includes, such as the Go of a file whose
+// module was renamed
+func removeStaleOutputs(c *buildCache, source string, outputs []string, targets []*target, opts codegenOptions) error {
+	entry, ok := c.Files[source]
+	if !ok {
+		return nil
+	}
+	current := make(map[string]bool, len(outputs))
+	for _, output := range outputs {
+		current[output] = true
+	}
+	built := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		built[t.gen.Name()] = true
+	}
+	for output := range entry.Outputs {
+		if current[output] || !built[artifactTarget(source, output, opts)] {
+			continue
 		}
-
-		// Placeholder return for now
-		if function.ReturnType != nil {
-			switch mapCloudPactTypeToTS(function.ReturnType.Name) {
-			case "boolean":
-				code.WriteString("  return false;\n")
-			case "number":
-				code.WriteString("  return 0;\n")
-			case "string":
-				code.WriteString("  return '';\n")
-			default:
-				code.WriteString("  return null as any;\n")
-			}
+		if err := os.Remove(output); err != nil && !os.IsNotExist(err) {
+			return err
 		}
 	}
-
-	code.WriteString("}\n\n")
-	return code.String()
+	return nil
 }
 
-// Enhanced type mapping functions with semantic types
-func mapCloudPactTypeToGo(cpType string) string {
-	switch strings.ToLower(cpType) {
-	// Basic types
-	case "int", "integer":
-		return "int"
-	case "float", "number":
-		return "float64"
-	case "bool", "boolean":
-		return "bool"
-	case "text", "string":
-		return "string"
-
-	// Semantic types - all map to string but with validation
-	case "email", "url", "uuid", "phone":
-		return "string"
-	case "address", "zip_code", "country_code", "state_code":
-		return "string"
-	case "password", "token", "api_key":
-		return "string"
-	case "html", "markdown", "json":
-		return "string"
-
-	// Currency types
-	case "usd_currency", "eur_currency", "percentage":
-		return "float64"
-
-	// Date/time types
-	case "date", "datetime", "timestamp":
-		return "time.Time"
-	case "time":
-		return "string" // Store as string for simplicity
-	case "duration":
-		return "time.Duration"
-
-	// Default
-	default:
-		return "string"
+// projectTypes collects the custom types declared across files. Files that
+// do not parse are left out; building them reports why.
+func projectTypes(files []string) (analyzer.Types, error) {
+	var parsed []*grammar.File
+	for _, file := range files {
+		if f, err := ParseCloudPactFile(file); err == nil {
+			parsed = append(parsed, f)
+		}
 	}
+	return analyzer.DeclaredTypes(parsed...)
 }
 
-func mapCloudPactTypeToTS(cpType string) string {
-	switch strings.ToLower(cpType) {
-	// Basic types
-	case "int", "integer", "float", "number":
-		return "number"
-	case "bool", "boolean":
-		return "boolean"
-	case "text", "string":
-		return "string"
-
-	// Semantic types - all become string but with type comments
-	case "email", "url", "uuid", "phone":
-		return "string"
-	case "address", "zip_code", "country_code", "state_code":
-		return "string"
-	case "password", "token", "api_key":
-		return "string"
-	case "html", "markdown", "json":
-		return "string"
-
-	// Currency and numeric types
-	case "usd_currency", "eur_currency", "percentage":
-		return "number"
-
-	// Date/time types
-	case "date", "datetime", "timestamp", "time":
-		return "string" // ISO format strings
-	case "duration":
-		return "string" // ISO duration format
-
-	// Default
-	default:
-		return "string"
+// projectGoPackages maps the records and models of files declaring a module
+// to the import path of the Go package they are generated into, so the Go
+// of other modules can import them. Without a Go module path there are no
+// import paths, and nil is returned.
+func projectGoPackages(files []string, opts codegenOptions) map[string]string {
+	root, err := goImportRoot(opts.outputDir("go"))
+	if err != nil {
+		return nil
+	}
+	packages := make(map[string]string)
+	add := func(name, importPath string) {
+		if _, ok := packages[name]; !ok {
+			packages[name] = importPath
+		}
+	}
+	for _, file := range files {
+		f, err := ParseCloudPactFile(file)
+		if err != nil || f.Module == nil {
+			continue
+		}
+		importPath := path.Join(root, gogen.PackageName(f))
+		for _, record := range f.Records {
+			add(record.Name, importPath)
+		}
+		for _, model := range f.Models {
+			add(model.Name, importPath)
+		}
 	}
+	return packages
 }
 
-// getValidationTag returns validation tag for Go struct fields
-func getValidationTag(cpType string) string {
-	switch strings.ToLower(cpType) {
-	case "email":
-		return "required,email"
-	case "url":
-		return "required,url"
-	case "uuid":
-		return "required,uuid"
-	case "phone":
-		return "required,e164" // E.164 phone format
-	case "zip_code":
-		return "required,len=5"
-	case "country_code":
-		return "required,len=2,alpha"
-	case "state_code":
-		return "required,len=2,alpha"
-	case "percentage":
-		return "required,min=0,max=100"
-	case "usd_currency", "eur_currency":
-		return "required,min=0"
-	case "password":
-		return "required,min=8"
-	default:
-		return "required"
+// goPackagesFingerprint identifies packages for the build cache
+func goPackagesFingerprint(packages map[string]string) string {
+	names := make([]string, 0, len(packages))
+	for name := range packages {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s@%s\n", name, packages[name])
+	}
+	return b.String()
 }
 
-// getTypeComment returns helpful comment for TypeScript types
-func getTypeComment(cpType string) string {
-	switch strings.ToLower(cpType) {
-	case "email":
-		return "Email address format"
-	case "url":
-		return "URL format"
-	case "uuid":
-		return "UUID format"
-	case "phone":
-		return "Phone number format"
-	case "zip_code":
-		return "ZIP/Postal code"
-	case "country_code":
-		return "ISO country code (US, CA, etc.)"
-	case "state_code":
-		return "State/province code"
-	case "usd_currency":
-		return "USD currency amount"
-	case "eur_currency":
-		return "EUR currency amount"
-	case "percentage":
-		return "Percentage (0-100)"
-	case "date":
-		return "Date in YYYY-MM-DD format"
-	case "datetime", "timestamp":
-		return "ISO 8601 datetime"
-	case "password":
-		return "Password (minimum 8 characters)"
-	default:
-		return ""
+func ParseCloudPactFile(filename string) (*grammar.File, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
+	return grammar.ParseWithFilename(f, filename)
+}
+
+func FindCloudPactFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.Contains(path, "generated") || strings.Contains(path, "cmd/ai-integration/cache") {
+			return nil
+		}
+		if strings.HasSuffix(path, ".cp") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
 }
@@ -1,9 +1,28 @@
 package project
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/config"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
 )
 
 func TestFindCloudPactFiles(t *testing.T) {
@@ -27,3 +46,2883 @@ func TestFindCloudPactFiles(t *testing.T) {
 		t.Fatalf("unexpected files: %v", files)
 	}
 }
+
+func TestBuildErrorOverlay(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "broken.cp")
+	src := "define record User\n    name: text\n\nfunction broken(name text) returns text\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	_, err := ParseCloudPactFile(path)
+	if err == nil {
+		t.Fatal("expected parse error")
+	}
+
+	buildErr := NewBuildError(fmt.Errorf("failed to parse %s: %w", path, err))
+	if buildErr.File != path || buildErr.Line != 4 || buildErr.Column == 0 {
+		t.Fatalf("unexpected error location: %+v", buildErr)
+	}
+	if buildErr.Source != "function broken(name text) returns text" {
+		t.Fatalf("unexpected source line: %q", buildErr.Source)
+	}
+
+	web := filepath.Join(dir, "web")
+	if err := os.Mkdir(web, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(web, "index.html"), []byte("<html><body>app</body></html>"), 0644); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+
+	server := newDevServer()
+	handler := server.handleStatic(web)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), "/__cloudpact/events") {
+		t.Fatalf("expected reload client to be injected: %s", rec.Body.String())
+	}
+
+	server.setResult(err)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != 500 || !strings.Contains(rec.Body.String(), "cloudpact-error-overlay") {
+		t.Fatalf("expected error overlay, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "function broken(name text)") {
+		t.Fatalf("overlay is missing the source line: %s", rec.Body.String())
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.cp")
+	bad := filepath.Join(dir, "bad.cp")
+	files := map[string]string{
+		good: "define record User\n    name: text\n",
+		bad:  "define record User\n    name: text\n\nfunction greet(user: User) returns text\n    why: \"Greets a user by name\"\n    do:\n        return user.nmae\n",
+	}
+	for path, src := range files {
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+
+	diagnostics, err := Check([]string{good, bad})
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", diagnostics)
+	}
+	d := diagnostics[0]
+	if d.Code != grammar.CodeUnknownField || d.Range.Start.File != bad || d.Range.Start.Line != 7 {
+		t.Fatalf("unexpected diagnostic: %#v", d)
+	}
+	want := bad + ":7:16: error[unknown-field]: record User has no field nmae\n    suggestion: did you mean name?"
+	if d.Format() != want {
+		t.Fatalf("unexpected format:\n%s\nwant:\n%s", d.Format(), want)
+	}
+
+	buildErr := NewBuildError(fmt.Errorf("failed to check %s: %w", bad, d))
+	if buildErr.Code != grammar.CodeUnknownField || buildErr.Suggestion != "did you mean name?" || buildErr.Source != "        return user.nmae" {
+		t.Fatalf("unexpected build error: %+v", buildErr)
+	}
+}
+
+func TestBuildFilesOnlyTouchesChangeSet(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	for _, sub := range []string{"models", "generated/go", "generated/ts", "generated/openapi"} {
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	orders := filepath.Join("models", "orders.cp")
+	users := filepath.Join("models", "users.cp")
+	os.WriteFile(orders, []byte("define record Order\n    total: number\n"), 0644)
+	os.WriteFile(users, []byte("define record User\n    name: text\n"), 0644)
+
+	if err := BuildFiles([]string{orders}); err != nil {
+		t.Fatalf("BuildFiles error: %v", err)
+	}
+	for _, output := range defaultOutputs(orders) {
+		if _, err := os.Stat(output); err != nil {
+			t.Fatalf("expected %s to be generated: %v", output, err)
+		}
+	}
+	for _, output := range outputPaths(users, codegenOptions{}) {
+		if _, err := os.Stat(output); err == nil {
+			t.Fatalf("unchanged file was rebuilt: %s", output)
+		}
+	}
+
+	os.Remove(orders)
+	if err := BuildFiles([]string{orders}); err != nil {
+		t.Fatalf("BuildFiles error after removal: %v", err)
+	}
+	for _, output := range outputPaths(orders, codegenOptions{}) {
+		if _, err := os.Stat(output); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", output)
+		}
+	}
+}
+
+// defaultOutputs are the outputs a build without a targets list writes
+// for source, leaving out the opt-in targets
+func defaultOutputs(source string) []string {
+	targets, _ := enabledTargets(codegenOptions{})
+	var file *grammar.File
+	if data, err := os.ReadFile(source); err == nil {
+		file, _ = grammar.ParseWithFilename(bytes.NewReader(data), source)
+	}
+	var paths []string
+	for _, t := range targets {
+		paths = append(paths, t.outputPath(source, file, codegenOptions{}))
+	}
+	return paths
+}
+
+func TestBuildSkipsUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	for _, sub := range []string{"models", "generated/go", "generated/ts", "generated/openapi"} {
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	source := filepath.Join("models", "orders.cp")
+	os.WriteFile(source, []byte("define record Order\n    total: number\n"), 0644)
+	goOutput := outputPaths(source, codegenOptions{})[0]
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	if _, err := os.Stat(buildCachePath); err != nil {
+		t.Fatalf("expected cache manifest: %v", err)
+	}
+
+	// An unchanged source is skipped, but a hand-edited output is regenerated
+	os.WriteFile(goOutput, []byte("edited"), 0644)
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	if data, _ := os.ReadFile(goOutput); string(data) == "edited" {
+		t.Fatal("expected edited output to be regenerated")
+	}
+
+	cache := loadBuildCache()
+	if !cache.fresh(source) {
+		t.Fatal("expected source to be fresh after a build")
+	}
+	os.WriteFile(source, []byte("define record Order\n    total: number\n    paid: boolean\n"), 0644)
+	if cache.fresh(source) {
+		t.Fatal("expected a modified source to be stale")
+	}
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	if data, _ := os.ReadFile(goOutput); !strings.Contains(string(data), "paid") {
+		t.Fatalf("expected rebuilt output to include the new field: %s", data)
+	}
+}
+
+func TestBuildStampsHeaders(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "orders.cp")
+	content := []byte("module Orders\n\ndefine record Order\n    total: number\n    note: text optional\n")
+	os.WriteFile(source, content, 0644)
+	header := codegen.Header(source, content)
+	if !strings.HasPrefix(header, "Code generated by cloudpact v"+codegen.Version+" from models/orders.cp (sha256 ") ||
+		!strings.HasSuffix(header, "; DO NOT EDIT.") {
+		t.Fatalf("unexpected header %q", header)
+	}
+
+	// Outputs are identical byte for byte when built again from scratch
+	var builds [2]map[string]string
+	for i := range builds {
+		os.RemoveAll("generated")
+		if err := Build(); err != nil {
+			t.Fatalf("Build error: %v", err)
+		}
+		builds[i] = map[string]string{}
+		for _, output := range defaultOutputs(source) {
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatalf("read %s: %v", output, err)
+			}
+			builds[i][output] = string(data)
+		}
+	}
+	for output, data := range builds[0] {
+		marker := "// "
+		if strings.HasSuffix(output, ".yaml") {
+			marker = "# "
+		}
+		if !strings.HasPrefix(data, marker+header+"\n\n") {
+			t.Fatalf("expected %s to start with the header:\n%s", output, data)
+		}
+		if builds[1][output] != data {
+			t.Fatalf("%s differs between builds", output)
+		}
+	}
+}
+
+func TestBuildManifestAndClean(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "orders.cp")
+	os.WriteFile(source, []byte("define record Order\n    total: number\n"), 0644)
+	keep := filepath.Join("generated", "go", "handwritten.go")
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	os.WriteFile(keep, []byte("package generated\n"), 0644)
+
+	manifest, err := LoadManifest()
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	if len(manifest.Artifacts) != len(defaultOutputs(source)) {
+		t.Fatalf("expected one artifact per target, got %+v", manifest.Artifacts)
+	}
+	goArtifact := manifest.Artifacts[0]
+	if goArtifact.Path != "generated/go/orders.go" || goArtifact.Source != "models/orders.cp" || goArtifact.Target != "go" {
+		t.Fatalf("unexpected artifact: %+v", goArtifact)
+	}
+	if hash, _ := hashFile(goArtifact.Path); hash != goArtifact.Hash {
+		t.Fatalf("artifact hash %s does not match file hash %s", goArtifact.Hash, hash)
+	}
+
+	removed, err := Clean()
+	if err != nil {
+		t.Fatalf("Clean: %v", err)
+	}
+	if len(removed) != len(manifest.Artifacts) {
+		t.Fatalf("expected every artifact removed, got %v", removed)
+	}
+	for _, path := range append(outputPaths(source, codegenOptions{}), ManifestPath, buildCachePath) {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", path)
+		}
+	}
+	if _, err := os.Stat(keep); err != nil {
+		t.Fatalf("files not in the manifest should be kept: %v", err)
+	}
+}
+
+func TestRecordGenerated(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("orders.cp", []byte("define record Order\n    total: number\n"), 0644)
+	os.MkdirAll("docs", 0755)
+	md, html := filepath.Join("docs", "orders.md"), filepath.Join("docs", "orders.html")
+	os.WriteFile(md, []byte("# Orders\n"), 0644)
+	os.WriteFile(html, []byte("<h1>Orders</h1>\n"), 0644)
+	if err := RecordGenerated("gen docs md", []string{md}); err != nil {
+		t.Fatalf("RecordGenerated: %v", err)
+	}
+	if err := RecordGenerated("gen docs html", []string{html}); err != nil {
+		t.Fatalf("RecordGenerated: %v", err)
+	}
+
+	// A build keeps what gen commands wrote listed
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	manifest, err := LoadManifest()
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	hashes := manifest.hashes()
+	if _, ok := hashes["docs/orders.md"]; !ok || len(hashes) != len(defaultOutputs("orders.cp"))+2 {
+		t.Fatalf("expected the build and gen docs outputs, got %+v", manifest.Artifacts)
+	}
+	for _, artifact := range manifest.Artifacts {
+		if artifact.Path == "docs/orders.html" && (artifact.Target != "docs" || artifact.Command != "gen docs html") {
+			t.Fatalf("unexpected artifact: %+v", artifact)
+		}
+	}
+
+	// Running a command again removes what it no longer writes
+	other := filepath.Join("docs", "index.md")
+	os.WriteFile(other, []byte("# Index\n"), 0644)
+	if err := RecordGenerated("gen docs md", []string{other}); err != nil {
+		t.Fatalf("RecordGenerated: %v", err)
+	}
+	if _, err := os.Stat(md); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed", md)
+	}
+	if _, err := os.Stat(html); err != nil {
+		t.Fatalf("expected another command's output to be kept: %v", err)
+	}
+
+	if _, err := Clean(); err != nil {
+		t.Fatalf("Clean: %v", err)
+	}
+	for _, path := range []string{other, html} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", path)
+		}
+	}
+}
+
+func TestPackage(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	var uploaded []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			uploaded = append(uploaded, r.URL.Path)
+		}
+	}))
+	defer proxy.Close()
+
+	compile := compileTypeScript
+	defer func() { compileTypeScript = compile }()
+	compileTypeScript = func(srcDir, outDir string, files []string) error {
+		os.MkdirAll(outDir, 0755)
+		for _, file := range files {
+			name := strings.TrimSuffix(file, ".ts")
+			os.WriteFile(filepath.Join(outDir, name+".js"), []byte("// js\n"), 0644)
+			os.WriteFile(filepath.Join(outDir, name+".d.ts"), []byte("// types\n"), 0644)
+		}
+		return nil
+	}
+
+	os.MkdirAll("models", 0755)
+	os.WriteFile(filepath.Join("models", "orders.cp"), []byte("define record Order\n    total: number\n"), 0644)
+	os.WriteFile(filepath.Join("models", "customers.cp"), []byte("module Crm\n\ndefine record Customer\n    name: text\n"), 0644)
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\nversion: 0.1.0\ngo_module: example.com/shop\ntargets: [go, ts]\npackage:\n  go_proxy: "+proxy.URL+"\n"), 0644)
+
+	outputs, err := Package("v1.2.0")
+	if err != nil {
+		t.Fatalf("Package: %v", err)
+	}
+	want := []string{
+		filepath.Join("generated", "package", "shop-sdk-1.2.0.tgz"),
+		filepath.Join("generated", "package", "shop@v1.2.0.zip"),
+		filepath.Join("generated", "package", "shop@v1.2.0.mod"),
+		filepath.Join("generated", "package", "shop@v1.2.0.info"),
+	}
+	if strings.Join(outputs, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected %v, got %v", want, outputs)
+	}
+
+	f, _ := os.Open(want[0])
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("tarball: %v", err)
+	}
+	var entries []string
+	var pkgJSON []byte
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		entries = append(entries, header.Name)
+		if header.Name == "package/package.json" {
+			pkgJSON, _ = io.ReadAll(tr)
+		}
+	}
+	for _, entry := range []string{"package/dist/index.d.ts", "package/dist/orders.js", "package/src/orders.ts"} {
+		if !strings.Contains(strings.Join(entries, " "), entry) {
+			t.Fatalf("expected %s in tarball, got %v", entry, entries)
+		}
+	}
+	if !strings.Contains(string(pkgJSON), `"name": "shop-sdk"`) || !strings.Contains(string(pkgJSON), `"version": "1.2.0"`) {
+		t.Fatalf("unexpected package.json: %s", pkgJSON)
+	}
+
+	archive, err := zip.OpenReader(want[1])
+	if err != nil {
+		t.Fatalf("module zip: %v", err)
+	}
+	defer archive.Close()
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, " ") != "example.com/shop@v1.2.0/crm/customers.go example.com/shop@v1.2.0/go.mod example.com/shop@v1.2.0/orders.go" {
+		t.Fatalf("unexpected module zip entries %v", names)
+	}
+	if len(uploaded) != 3 || uploaded[0] != "/example.com/shop/@v/v1.2.0.zip" {
+		t.Fatalf("expected the module uploaded to the proxy, got %v", uploaded)
+	}
+}
+
+func TestBuildLocalizedWhy(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("services", 0755)
+	source := filepath.Join("services", "greet.cp")
+	os.WriteFile(source, []byte("function greet(name: text) returns text\n    why: \"Greets a customer\"\n    why.es: \"Saluda a un cliente\"\n    do:\n        return name\n"), 0644)
+	os.WriteFile("cloudpact.yaml", []byte("locale: es\n"), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	for _, output := range outputPaths(source, codegenOptions{})[:3] {
+		data, _ := os.ReadFile(output)
+		if !strings.Contains(string(data), "Saluda a un cliente") || strings.Contains(string(data), "Greets a customer") {
+			t.Fatalf("expected only the Spanish why in %s:\n%s", output, data)
+		}
+	}
+}
+
+func TestBuildJSONNames(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "account.cp")
+	os.WriteFile(source, []byte("module Accounts\n\ndefine record Account\n    displayName: text\n    createdAt: datetime default now\n"), 0644)
+	os.WriteFile("cloudpact.yaml", []byte("json_names: snake\n"), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	outputs := outputPaths(source, codegenOptions{})
+	for i, want := range []string{
+		"DisplayName string    `json:\"display_name\" validate:\"required\"`",
+		"  display_name: string;",
+		"display_name:",
+		"  display_name: z.string(),",
+	} {
+		data, _ := os.ReadFile(outputs[i])
+		if !strings.Contains(string(data), want) || strings.Contains(string(data), "displayname") {
+			t.Fatalf("expected %q in %s:\n%s", want, outputs[i], data)
+		}
+	}
+	goCode, _ := os.ReadFile(outputs[0])
+	if !strings.Contains(string(goCode), "errors.New(\"display_name is required\")") {
+		t.Fatalf("expected validation errors to name JSON keys:\n%s", goCode)
+	}
+
+	// The tags sit on exported fields, so vet has nothing to say about them
+	if _, err := exec.LookPath("go"); err != nil {
+		return
+	}
+	report, err := BuildWith(context.Background(), BuildSettings{VerifyGo: true})
+	if err != nil {
+		t.Fatalf("BuildWith error: %v", err)
+	}
+	for _, d := range report.Diagnostics {
+		if d.Code == grammar.CodeGoVet || d.Code == grammar.CodeGoBuild {
+			t.Fatalf("expected the generated Go to verify cleanly, got %s", d.Format())
+		}
+	}
+}
+
+func TestBuildKeepsComments(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("services", 0755)
+	source := filepath.Join("services", "user.cp")
+	os.WriteFile(source, []byte(`// Accounts are never deleted.
+define record User
+    name: text // as typed at signup
+
+// Falls back to "guest".
+function greet(user: User) returns text
+    why: "Greets a user"
+    do:
+        return user.name
+`), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	outputs := outputPaths(source, codegenOptions{})
+	goCode, _ := os.ReadFile(outputs[0])
+	for _, want := range []string{
+		"// User represents a user entity\n// Accounts are never deleted.\ntype User struct",
+		"\t// as typed at signup\n\tName string",
+		"// greet Greets a user\n// Falls back to \"guest\".\nfunc greet",
+	} {
+		if !strings.Contains(string(goCode), want) {
+			t.Fatalf("expected %q in generated Go:\n%s", want, goCode)
+		}
+	}
+	tsCode, _ := os.ReadFile(outputs[1])
+	if !strings.Contains(string(tsCode), "  // as typed at signup\n  name: string;") || !strings.Contains(string(tsCode), " * Falls back to \"guest\".") {
+		t.Fatalf("expected comments in generated TypeScript:\n%s", tsCode)
+	}
+}
+
+func TestBuildFieldDefaults(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("services", 0755)
+	source := filepath.Join("services", "account.cp")
+	os.WriteFile(source, []byte(`define record Account
+    name: text
+    status: text default "active"
+    limit: usd_currency default 100
+    createdAt: datetime default now
+`), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	outputs := outputPaths(source, codegenOptions{})
+	goCode, _ := os.ReadFile(outputs[0])
+	for _, want := range []string{
+		"func NewAccount(name string) (*Account, error) {",
+		"\t\tName:      name,\n\t\tStatus:    \"active\",\n\t\tLimit:     100,\n\t\tCreatedAt: time.Now(),",
+		"\t\"time\"",
+	} {
+		if !strings.Contains(string(goCode), want) {
+			t.Fatalf("expected %q in generated Go:\n%s", want, goCode)
+		}
+	}
+	tsCode, _ := os.ReadFile(outputs[1])
+	for _, want := range []string{
+		`export function defaultAccount(): Pick<Account, "status" | "limit" | "createdat"> {`,
+		"    createdat: new Date().toISOString(),",
+	} {
+		if !strings.Contains(string(tsCode), want) {
+			t.Fatalf("expected %q in generated TypeScript:\n%s", want, tsCode)
+		}
+	}
+}
+
+func TestStampVersion(t *testing.T) {
+	config := "name: shop\nversion: 0.1.0\n\napi:\n  title: Shop\n  # bumped on release\n  version: 0.1.0\nport: 8080\n"
+	want := "name: shop\nversion: 1.2.0\n\napi:\n  title: Shop\n  # bumped on release\n  version: 1.2.0\nport: 8080\n"
+	if got := stampVersion(config, "1.2.0"); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+	for _, key := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(key, "Test")
+	}
+	for _, key := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(key, "test@example.com")
+	}
+
+	compile := compileTypeScript
+	defer func() { compileTypeScript = compile }()
+	compileTypeScript = func(srcDir, outDir string, files []string) error { return os.MkdirAll(outDir, 0755) }
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "orders.cp")
+	os.WriteFile(source, []byte("define record Order\n    total: number\n"), 0644)
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\nversion: 0.1.0\ngo_module: example.com/shop\napi:\n  version: 0.1.0\n"), 0644)
+	os.WriteFile(".gitignore", []byte("generated/\n.cloudpact/\n"), 0644)
+	for _, args := range [][]string{{"init", "-q"}, {"add", "-A"}, {"commit", "-qm", "Add orders"}} {
+		if _, err := git(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Release("1.0"); err == nil {
+		t.Fatal("expected an invalid version to be rejected")
+	}
+	if _, err := Release("v1.0.0"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+
+	os.WriteFile(source, []byte("define record Order\n    total: number\n    paid: boolean\n"), 0644)
+	if _, err := Release("v1.1.0"); err == nil {
+		t.Fatal("expected a dirty working tree to be rejected")
+	}
+	git("commit", "-qam", "Track payment")
+	if _, err := Release("v1.1.0"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+
+	if tags, _ := git("tag", "--list"); tags != "v1.0.0\nv1.1.0" {
+		t.Fatalf("expected both releases tagged, got %q", tags)
+	}
+	changelog, _ := os.ReadFile(changelogPath)
+	first := strings.Index(string(changelog), "## v1.1.0")
+	second := strings.Index(string(changelog), "## v1.0.0")
+	if first < 0 || second < first || !strings.Contains(string(changelog)[first:second], "- Track payment") ||
+		!strings.Contains(string(changelog)[second:], "- Add orders") || strings.Contains(string(changelog), "- Release") {
+		t.Fatalf("unexpected changelog:\n%s", changelog)
+	}
+	spec, _ := os.ReadFile(outputPaths(source, codegenOptions{})[2])
+	if !strings.Contains(string(spec), `version: "1.1.0"`) {
+		t.Fatalf("expected the OpenAPI info to carry the release version:\n%s", spec)
+	}
+	if _, err := os.Stat(filepath.Join("generated", "package", "shop@v1.1.0.zip")); err != nil {
+		t.Fatalf("expected the Go module to be packaged: %v", err)
+	}
+}
+
+func TestBuildWithChangeTracking(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	for _, sub := range []string{"models", "generated/go", "generated/ts", "generated/openapi"} {
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	os.WriteFile("cloudpact.yaml", []byte("track_changes: true\n"), 0644)
+	source := filepath.Join("models", "users.cp")
+	os.WriteFile(source, []byte("define record User\n    name: text\n    nickname: text optional\n"), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+
+	goCode, _ := os.ReadFile(outputPaths(source, codegenOptions{})[0])
+	for _, want := range []string{
+		"\tchangedFields map[string]bool",
+		"type UserPatch struct {\n\tName     *string `json:\"name,omitempty\"`\n\tNickname *string `json:\"nickname,omitempty\"`\n}",
+		"func (r *User) SetName(value string) {\n\tr.Name = value\n\tr.markChanged(\"name\")\n}",
+		"func (r *User) Patch() UserPatch {",
+		"func (p UserPatch) Apply(r *User) {\n\tif p.Name != nil {\n\t\tr.Name = *p.Name",
+	} {
+		if !strings.Contains(string(goCode), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, goCode)
+		}
+	}
+
+	tsCode, _ := os.ReadFile(outputPaths(source, codegenOptions{})[1])
+	for _, want := range []string{
+		"function trackChanges<T extends object>(record: T): Tracked<T> {",
+		"export type UserPatch = Partial<Omit<User, 'id'>>;",
+		"export function trackUser(user: User): Tracked<User> {",
+		"export function applyUserPatch(user: User, patch: UserPatch): User {",
+	} {
+		if !strings.Contains(string(tsCode), want) {
+			t.Fatalf("expected %q in TS output:\n%s", want, tsCode)
+		}
+	}
+}
+
+// namesGenerator is a third-party style target writing the source's record names
+type namesGenerator struct{}
+
+func (namesGenerator) Name() string { return "names" }
+
+func (namesGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	var names []string
+	for _, record := range file.Records {
+		names = append(names, strings.ToUpper(record.Name))
+	}
+	return os.WriteFile(ctx.OutputPath, []byte(strings.Join(names, "\n")), 0644)
+}
+
+func TestBuildRunsRegisteredTargets(t *testing.T) {
+	RegisterGenerator(namesGenerator{}, ".txt")
+	defer func() { generators = generators[:len(generators)-1] }()
+
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	os.WriteFile("cloudpact.yaml", []byte("targets:\n  - go\n  - names\n"), 0644)
+	source := filepath.Join("models", "orders.cp")
+	os.WriteFile(source, []byte("define record Order\n    total: number\n"), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join("generated", "names", "orders.txt")); err != nil || string(data) != "ORDER" {
+		t.Fatalf("expected registered target output, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join("generated", "go", "orders.go")); err != nil {
+		t.Fatalf("expected Go output: %v", err)
+	}
+	for _, disabled := range []string{filepath.Join("generated", "ts", "orders.ts"), filepath.Join("generated", "openapi", "orders.yaml")} {
+		if _, err := os.Stat(disabled); err == nil {
+			t.Fatalf("disabled target wrote %s", disabled)
+		}
+	}
+
+	// A second build finds everything fresh
+	cache := loadBuildCache()
+	if !cache.fresh(source) {
+		t.Fatal("expected source to be fresh after a build")
+	}
+
+	os.WriteFile("cloudpact.yaml", []byte("targets:\n  - cobol\n"), 0644)
+	if err := Build(); err == nil || !strings.Contains(err.Error(), `unknown target "cobol"`) {
+		t.Fatalf("expected unknown target error, got %v", err)
+	}
+}
+
+func TestBuildOptInTargets(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "orders.cp")
+	os.WriteFile(source, []byte("define record Order\n    total: number\n"), 0644)
+	outputs := map[string]string{
+		filepath.Join("generated", "python", "orders.py"):   "class Order(BaseModel):",
+		filepath.Join("generated", "kotlin", "orders.kt"):   "data class Order(",
+		filepath.Join("generated", "swift", "orders.swift"): "struct Order: Codable, Equatable {",
+		filepath.Join("generated", "client", "orders.ts"):   "export interface ErrorBody {",
+		filepath.Join("generated", "react", "orders.tsx"):   "import { OrderSchema } from \"../zod/orders.schemas\";",
+	}
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	for output := range outputs {
+		if _, err := os.Stat(output); err == nil {
+			t.Fatalf("%s written without its target being listed", output)
+		}
+	}
+
+	os.WriteFile("cloudpact.yaml", []byte("targets: [go, python, kotlin, swift, react]\n"), 0644)
+	if err := Build(); err == nil || !strings.Contains(err.Error(), `target "react" in cloudpact.yaml needs the ts target`) {
+		t.Fatalf("expected an error for react without ts, got %v", err)
+	}
+
+	os.WriteFile("cloudpact.yaml", []byte("targets: [go, ts, zod, python, kotlin, swift, client, react]\n"), 0644)
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	for output, want := range outputs {
+		code, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatalf("expected %s: %v", output, err)
+		}
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in %s:\n%s", want, output, code)
+		}
+	}
+}
+
+func TestBuildUsesTemplateOverrides(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	os.MkdirAll(filepath.Join("templates", "go"), 0755)
+	override := filepath.Join("templates", "go", "record.tmpl")
+	os.WriteFile(override, []byte("type {{.Name}} struct {\n{{- range .Fields}}\n\t{{title .Name}} {{.Type}} `db:\"{{snake .Name}}\"`\n{{- end}}\n}\n\n"), 0644)
+	source := filepath.Join("models", "orders.cp")
+	os.WriteFile(source, []byte("define record Order\n    createdAt: text\n\nfunction ping() returns boolean\n    why: \"Checks liveness\"\n    do:\n        return true\n"), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	goCode, _ := os.ReadFile(outputPaths(source, codegenOptions{})[0])
+	if !strings.Contains(string(goCode), "type Order struct {\n\tCreatedAt string `db:\"created_at\"`\n}") {
+		t.Fatalf("expected overridden record template:\n%s", goCode)
+	}
+	// Templates that are not overridden keep the built-in layout
+	if !strings.Contains(string(goCode), "// ping Checks liveness\nfunc ping() bool {") {
+		t.Fatalf("expected built-in function template:\n%s", goCode)
+	}
+
+	// Editing an override invalidates the build cache
+	os.WriteFile(override, []byte("type {{.Name}} struct{}\n\n"), 0644)
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	if goCode, _ := os.ReadFile(outputPaths(source, codegenOptions{})[0]); !strings.Contains(string(goCode), "type Order struct{}") {
+		t.Fatalf("expected rebuild after template change:\n%s", goCode)
+	}
+
+	os.WriteFile(override, []byte("{{.Missing"), 0644)
+	if err := Build(); err == nil || !strings.Contains(err.Error(), "template overrides") {
+		t.Fatalf("expected template parse error, got %v", err)
+	}
+}
+
+func TestBuildVersionedRecord(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "orders.cp")
+	os.WriteFile(source, []byte("define record Order versioned\n    total: number\n"), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+
+	goCode, _ := os.ReadFile(outputPaths(source, codegenOptions{})[0])
+	for _, want := range []string{
+		"\tID      string  `json:\"id\" validate:\"required,uuid\"`\n\tVersion int64   `json:\"version\"`\n",
+		"\t\"net/http\"\n\t\"path\"\n",
+		"var ErrOrderConflict = errors.New(\"order was modified concurrently\")",
+		"func (r *Order) ETag() string {",
+		"func GetOrderHandler(load func(id string) (*Order, error)) http.HandlerFunc {",
+		"writeError(w, r, http.StatusPreconditionRequired, \"precondition_required\", \"If-Match header required\")",
+		"if ifMatch != record.ETag() && ifMatch != \"*\" {",
+		"updated.Version = record.Version + 1",
+		"if errors.Is(err, ErrOrderConflict) {\n\t\t\t\tstatus, code = http.StatusConflict, \"conflict\"",
+	} {
+		if !strings.Contains(string(goCode), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, goCode)
+		}
+	}
+
+	tsCode, _ := os.ReadFile(outputPaths(source, codegenOptions{})[1])
+	for _, want := range []string{
+		"  version: number;",
+		"async function updateWithRetry<T, R = T>(url: string, change: (current: R) => T, attempts = 3): Promise<R> {",
+		"'If-Match': etag",
+		"export function updateOrder(baseUrl: string, id: string, change: (current: Order) => Order, attempts = 3): Promise<Order> {",
+	} {
+		if !strings.Contains(string(tsCode), want) {
+			t.Fatalf("expected %q in TS output:\n%s", want, tsCode)
+		}
+	}
+}
+
+func TestBuildFormatsGoOutput(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "orders.cp")
+	os.WriteFile(source, []byte("module Shop\n\ndefine record Order\n    total: usd_currency\n    placed: datetime\n\nfunction isLarge(order: Order) returns boolean\n    why: \"Large orders need review\"\n    do:\n        return order.total > 100\n"), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	goCode, _ := os.ReadFile(outputPaths(source, codegenOptions{})[0])
+	if formatted, err := format.Source(goCode); err != nil || string(formatted) != string(goCode) {
+		t.Fatalf("expected gofmt-clean output (%v):\n%s", err, goCode)
+	}
+	if !strings.Contains(string(goCode), "import (\n\t\"crypto/rand\"\n\t\"encoding/json\"\n\t\"errors\"\n\t\"fmt\"\n\t\"net/http\"\n\t\"regexp\"\n\t\"time\"\n)") {
+		t.Fatalf("expected only the imports the code uses:\n%s", goCode)
+	}
+}
+
+func TestBuildFunctionHeaders(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "orders.cp")
+	os.WriteFile(source, []byte(`function placeOrder(total: number) returns number
+    header: X-Tenant-ID required
+    header: X-Trace-Id
+    header: X-Request-ID emitted
+    why: "Orders belong to a tenant"
+    do:
+        return total
+`), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+
+	goCode, _ := os.ReadFile(outputPaths(source, codegenOptions{})[0])
+	for _, want := range []string{
+		"func placeOrder(total float64, xTenantID string, xTraceId *string) float64 {",
+		"func PlaceOrderHandler(respond func(r *http.Request, header http.Header)) http.HandlerFunc {",
+		"writeError(w, r, http.StatusBadRequest, \"bad_request\", \"X-Tenant-ID header required\")",
+		"if value := r.Header.Get(\"X-Trace-Id\"); value != \"\" {",
+		"result := placeOrder(params.Total, xTenantID, xTraceId)",
+		"respond sets the response\n// headers X-Request-ID",
+	} {
+		if !strings.Contains(string(goCode), want) {
+			t.Fatalf("expected %q in Go output:\n%s", want, goCode)
+		}
+	}
+
+	tsCode, _ := os.ReadFile(outputPaths(source, codegenOptions{})[1])
+	for _, want := range []string{
+		"export function placeOrder(total: number, xTenantID: string, xTraceId: string | null): number {",
+		"export async function callPlaceOrder(baseUrl: string, params: { total: number }, headers: { 'X-Tenant-ID': string; 'X-Trace-Id'?: string }, onHeaders?: (headers: Headers) => void): Promise<number> {",
+		"fetch(`${baseUrl}/placeorder`",
+	} {
+		if !strings.Contains(string(tsCode), want) {
+			t.Fatalf("expected %q in TS output:\n%s", want, tsCode)
+		}
+	}
+}
+
+func TestGenerateJSONSchema(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("json_names: camel\n"), 0644)
+	os.WriteFile("customers.cp", []byte(`define record Customer
+    email_address: email
+    nickname: text optional
+`), 0644)
+
+	outputs, err := GenerateJSONSchema("")
+	if err != nil {
+		t.Fatalf("GenerateJSONSchema error: %v", err)
+	}
+	want := filepath.Join("generated", "jsonschema", "Customer.schema.json")
+	if len(outputs) != 1 || outputs[0] != want {
+		t.Fatalf("expected %s, got %v", want, outputs)
+	}
+	data, _ := os.ReadFile(want)
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("expected JSON: %v\n%s", err, data)
+	}
+	props, _ := schema["properties"].(map[string]interface{})
+	if _, ok := props["emailAddress"]; !ok {
+		t.Fatalf("expected the configured JSON names:\n%s", data)
+	}
+
+	os.WriteFile("more.cp", []byte(`define record Customer
+    name: text
+`), 0644)
+	if _, err := GenerateJSONSchema(""); err == nil || !strings.Contains(err.Error(), "declared in both") {
+		t.Fatalf("expected an error for a record declared twice, got %v", err)
+	}
+}
+
+func TestGenerateRust(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("json_names: camel\n"), 0644)
+	os.WriteFile("customers.cp", []byte(`define record Customer
+    email_address: email
+    since: datetime
+`), 0644)
+	os.WriteFile("order-lines.cp", []byte(`define record Order
+    customer: Customer
+`), 0644)
+
+	outputs, err := GenerateRust("")
+	if err != nil {
+		t.Fatalf("GenerateRust error: %v", err)
+	}
+	rustDir := filepath.Join("generated", "rust")
+	want := []string{filepath.Join(rustDir, "customers.rs"), filepath.Join(rustDir, "order_lines.rs"), filepath.Join(rustDir, "mod.rs")}
+	if strings.Join(outputs, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected %v, got %v", want, outputs)
+	}
+	customers, _ := os.ReadFile(want[0])
+	for _, s := range []string{"// Code generated by cloudpact", "use chrono::{DateTime, Utc};", `#[serde(rename = "emailAddress")]`, "pub since: DateTime<Utc>,"} {
+		if !strings.Contains(string(customers), s) {
+			t.Fatalf("expected %q in customers.rs:\n%s", s, customers)
+		}
+	}
+	if orders, _ := os.ReadFile(want[1]); !strings.Contains(string(orders), "use super::*;") {
+		t.Fatalf("expected the other files' records to be imported:\n%s", orders)
+	}
+	if mod, _ := os.ReadFile(want[2]); !strings.Contains(string(mod), "pub mod customers;\npub mod order_lines;\n\npub use customers::*;\npub use order_lines::*;") {
+		t.Fatalf("unexpected mod.rs:\n%s", mod)
+	}
+
+	os.WriteFile("more.cp", []byte(`define record Customer
+    name: text
+`), 0644)
+	if _, err := GenerateRust(""); err == nil || !strings.Contains(err.Error(), "declared in both") {
+		t.Fatalf("expected an error for a record declared twice, got %v", err)
+	}
+}
+
+func TestGenerateCSharp(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("name: my-shop\njson_names: camel\n"), 0644)
+	os.WriteFile("customers.cp", []byte(`define record Customer
+    email_address: email
+`), 0644)
+	os.WriteFile("orders.cp", []byte(`module Orders
+
+define record Order
+    customer: Customer
+`), 0644)
+
+	outputs, err := GenerateCSharp("")
+	if err != nil {
+		t.Fatalf("GenerateCSharp error: %v", err)
+	}
+	csDir := filepath.Join("generated", "csharp")
+	want := []string{filepath.Join(csDir, "customers.cs"), filepath.Join(csDir, "orders.cs")}
+	if strings.Join(outputs, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected %v, got %v", want, outputs)
+	}
+	customers, _ := os.ReadFile(want[0])
+	for _, s := range []string{"// Code generated by cloudpact", "namespace MyShop;", "using Orders;", `[JsonPropertyName("emailAddress")]`, "public required string EmailAddress { get; init; }"} {
+		if !strings.Contains(string(customers), s) {
+			t.Fatalf("expected %q in customers.cs:\n%s", s, customers)
+		}
+	}
+	if orders, _ := os.ReadFile(want[1]); !strings.Contains(string(orders), "using MyShop;\n\nnamespace Orders;") {
+		t.Fatalf("expected the other files' namespace to be used:\n%s", orders)
+	}
+
+	os.WriteFile("more.cp", []byte(`define record Customer
+    name: text
+`), 0644)
+	if _, err := GenerateCSharp(""); err == nil || !strings.Contains(err.Error(), "declared in both") {
+		t.Fatalf("expected an error for a record declared twice, got %v", err)
+	}
+}
+
+func TestGenerateForms(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\njson_names: camel\noutputs:\n  forms: web/forms\n"), 0644)
+	os.WriteFile("orders.cp", []byte(`define record Order
+    email_address: email
+
+define record Line no id
+    sku: text
+`), 0644)
+
+	outputs, err := GenerateForms("")
+	if err != nil {
+		t.Fatalf("GenerateForms error: %v", err)
+	}
+	formsDir := filepath.Join("web", "forms")
+	want := []string{filepath.Join(formsDir, "order.form.html"), filepath.Join(formsDir, "order.form.ts"), filepath.Join(formsDir, "forms.ts")}
+	if strings.Join(outputs, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected %v, got %v", want, outputs)
+	}
+	form, _ := os.ReadFile(want[0])
+	if !strings.Contains(string(form), `name="emailAddress" type="email"`) {
+		t.Fatalf("expected an email input named by the JSON key:\n%s", form)
+	}
+	binding, _ := os.ReadFile(want[1])
+	if !strings.Contains(string(binding), `import type { Order } from "../../generated/ts/orders";`) {
+		t.Fatalf("expected the interfaces imported from the ts output:\n%s", binding)
+	}
+
+	os.WriteFile("more.cp", []byte(`define record Order
+    name: text
+`), 0644)
+	if _, err := GenerateForms(""); err == nil || !strings.Contains(err.Error(), "would both be written") {
+		t.Fatalf("expected an error for two forms of one name, got %v", err)
+	}
+}
+
+func TestGenerateDatabase(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\n"), 0644)
+	os.WriteFile("orders.cp", []byte(`module Orders
+
+define record Order versioned
+    total: usd_currency
+
+define record Line no id
+    sku: text
+`), 0644)
+
+	if _, err := GenerateDatabase(""); err == nil || !strings.Contains(err.Error(), "add a go.mod") {
+		t.Fatalf("expected an error asking for the module path, got %v", err)
+	}
+	os.WriteFile("go.mod", []byte("module example.com/shop\n\ngo 1.21\n"), 0644)
+	outputs, err := GenerateDatabase("")
+	if err != nil {
+		t.Fatalf("GenerateDatabase error: %v", err)
+	}
+	want := filepath.Join("generated", "go", "orders", "db", "db_gorm.go")
+	if len(outputs) != 1 || outputs[0] != want {
+		t.Fatalf("expected %s, got %v", want, outputs)
+	}
+	models, _ := os.ReadFile(want)
+	for _, s := range []string{"package db", `"example.com/shop/generated/go/orders"`, "func (row *OrderRow) Record() *orders.Order", "return db.AutoMigrate(&OrderRow{})"} {
+		if !strings.Contains(string(models), s) {
+			t.Fatalf("expected %q in the db package of orders:\n%s", s, models)
+		}
+	}
+
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\npersistence:\n  orm: sqlc\n"), 0644)
+	outputs, err = GenerateDatabase("")
+	if err != nil {
+		t.Fatalf("GenerateDatabase error: %v", err)
+	}
+	dbDir := filepath.Join("generated", "db")
+	wantSQLC := []string{filepath.Join(dbDir, "schema.sql"), filepath.Join(dbDir, "sqlc.yaml"), filepath.Join(dbDir, "queries", "orders.sql")}
+	if strings.Join(outputs, " ") != strings.Join(wantSQLC, " ") {
+		t.Fatalf("expected %v, got %v", wantSQLC, outputs)
+	}
+	schema, _ := os.ReadFile(wantSQLC[0])
+	if !strings.Contains(string(schema), "CREATE TABLE orders (") || strings.Contains(string(schema), "lines") {
+		t.Fatalf("expected only the orders table:\n%s", schema)
+	}
+
+	os.WriteFile("more.cp", []byte(`define record Order
+    name: text
+`), 0644)
+	if _, err := GenerateDatabase(""); err == nil || !strings.Contains(err.Error(), "would both be stored in the orders table") {
+		t.Fatalf("expected an error for two records in one table, got %v", err)
+	}
+}
+
+func TestGenerateSeed(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\n"), 0644)
+	os.WriteFile("go.mod", []byte("module example.com/shop\n\ngo 1.21\n"), 0644)
+	os.WriteFile("orders.cp", []byte(`module Orders
+
+define record Order
+    total: usd_currency
+`), 0644)
+	if _, err := GenerateSeed(""); err == nil || !strings.Contains(err.Error(), "no seed declarations") {
+		t.Fatalf("expected an error without seeds, got %v", err)
+	}
+
+	os.WriteFile("orders.cp", []byte(`module Orders
+
+define record Order
+    total: usd_currency
+
+seed Order: 4 records
+    row:
+        total: 12.5
+`), 0644)
+	outputs, err := GenerateSeed("")
+	if err != nil {
+		t.Fatalf("GenerateSeed error: %v", err)
+	}
+	want := filepath.Join("generated", "go", "orders", "db", "db_seed.go")
+	if len(outputs) != 1 || outputs[0] != want {
+		t.Fatalf("expected %s, got %v", want, outputs)
+	}
+	code, _ := os.ReadFile(want)
+	if !strings.Contains(string(code), "package db") || !strings.Contains(string(code), "Total: 12.5}") {
+		t.Fatalf("expected a Seed in the db package of orders:\n%s", code)
+	}
+
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\npersistence:\n  orm: sqlc\n"), 0644)
+	outputs, err = GenerateSeed("")
+	if err != nil {
+		t.Fatalf("GenerateSeed error: %v", err)
+	}
+	want = filepath.Join("generated", "db", "seed.sql")
+	if len(outputs) != 1 || outputs[0] != want {
+		t.Fatalf("expected %s, got %v", want, outputs)
+	}
+	sql, _ := os.ReadFile(want)
+	if strings.Count(string(sql), "\n  (") != 4 || !strings.Contains(string(sql), ", 12.5)") {
+		t.Fatalf("expected four orders, the first as written:\n%s", sql)
+	}
+}
+
+func TestGenerateDeploy(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("name: Shop API\nversion: 1.0.0\n"), 0644)
+	outputs, err := GenerateDeploy("")
+	if err != nil {
+		t.Fatalf("GenerateDeploy error: %v", err)
+	}
+	deployDir := filepath.Join("generated", "deploy")
+	want := []string{filepath.Join(deployDir, "deployment.yaml"), filepath.Join(deployDir, "service.yaml")}
+	if strings.Join(outputs, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected %v, got %v", want, outputs)
+	}
+	deployment, _ := os.ReadFile(want[0])
+	for _, line := range []string{"  name: shop-api\n", "  replicas: 1\n", "          image: \"shop-api:1.0.0\"\n"} {
+		if !strings.Contains(string(deployment), line) {
+			t.Fatalf("expected %q in the deployment:\n%s", line, deployment)
+		}
+	}
+
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\ndeploy:\n  format: helm\n  host: shop.example.com\n"), 0644)
+	outputs, err = GenerateDeploy("k8s")
+	if err != nil {
+		t.Fatalf("GenerateDeploy error: %v", err)
+	}
+	chart := filepath.Join("k8s", "shop")
+	if len(outputs) != 5 || outputs[0] != filepath.Join(chart, "Chart.yaml") || outputs[4] != filepath.Join(chart, "templates", "ingress.yaml") {
+		t.Fatalf("expected a chart in %s, got %v", chart, outputs)
+	}
+	values, _ := os.ReadFile(filepath.Join(chart, "values.yaml"))
+	if !strings.Contains(string(values), "image: \"shop:latest\"\n") || !strings.Contains(string(values), "  host: \"shop.example.com\"\n") {
+		t.Fatalf("expected the settings in values.yaml:\n%s", values)
+	}
+}
+
+func TestGenerateTerraform(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("name: Shop API\njson_names: camel\n"), 0644)
+	os.WriteFile("orders.cp", []byte(`module Orders
+
+define record Order
+    total: usd_currency
+`), 0644)
+	if _, err := GenerateTerraform("aws", ""); err == nil || !strings.Contains(err.Error(), "no records marked persist") {
+		t.Fatalf("expected an error without persisted records, got %v", err)
+	}
+	if _, err := GenerateTerraform("gcp", ""); err == nil || !strings.Contains(err.Error(), `unknown cloud provider "gcp"`) {
+		t.Fatalf("expected an unknown provider error, got %v", err)
+	}
+
+	os.WriteFile("orders.cp", []byte(`module Orders
+
+define record Order persist
+    orderNumber: text key
+    total: usd_currency
+`), 0644)
+	outputs, err := GenerateTerraform("aws", "")
+	if err != nil {
+		t.Fatalf("GenerateTerraform error: %v", err)
+	}
+	tfDir := filepath.Join("generated", "terraform")
+	if len(outputs) != 4 || outputs[0] != filepath.Join(tfDir, "main.tf") || outputs[3] != filepath.Join(tfDir, "versions.tf") {
+		t.Fatalf("expected a module in %s, got %v", tfDir, outputs)
+	}
+	main, _ := os.ReadFile(outputs[0])
+	if !strings.Contains(string(main), "  hash_key                    = \"orderNumber\"\n") {
+		t.Fatalf("expected the table keyed by the JSON key of the key field:\n%s", main)
+	}
+	variables, _ := os.ReadFile(outputs[1])
+	if !strings.Contains(string(variables), "default     = \"shop-api\"") {
+		t.Fatalf("expected the project name as the default prefix:\n%s", variables)
+	}
+
+	os.WriteFile("more.cp", []byte(`define record Order persist bucket
+    name: text
+`), 0644)
+	if _, err := GenerateTerraform("aws", ""); err == nil || !strings.Contains(err.Error(), "would both be kept in orders") {
+		t.Fatalf("expected an error for two records in one resource, got %v", err)
+	}
+}
+
+func TestGenerateLambda(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("go.mod", []byte("module example.com/shop\n\ngo 1.22\n"), 0644)
+	os.WriteFile("cloudpact.yaml", []byte("name: Shop API\n"), 0644)
+	os.WriteFile("scripts.cp", []byte("function ping() returns text\n    why: \"Checks the service\"\n    do:\n        return \"pong\"\n"), 0644)
+	if _, err := GenerateLambda(""); err == nil || !strings.Contains(err.Error(), "no functions") {
+		t.Fatalf("expected an error without functions in a module, got %v", err)
+	}
+
+	os.WriteFile("greetings.cp", []byte(`module Greetings
+
+function greet(name: text) returns text
+    why: "Greets a customer"
+    do:
+        return name
+`), 0644)
+	outputs, err := GenerateLambda("")
+	if err != nil {
+		t.Fatalf("GenerateLambda error: %v", err)
+	}
+	goDir := filepath.Join("generated", "go")
+	want := []string{
+		filepath.Join(goDir, "cmd", "lambda", "greet", "main.go"),
+		filepath.Join("generated", "lambda", "template.yaml"),
+	}
+	if fmt.Sprint(outputs) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, outputs)
+	}
+	main, _ := os.ReadFile(outputs[0])
+	if !strings.Contains(string(main), "\"example.com/shop/generated/go/greetings\"") || !strings.Contains(string(main), "greetings.GreetHandler(respond)") {
+		t.Fatalf("expected main to serve the package's handler:\n%s", main)
+	}
+	if _, err := os.Stat(filepath.Join(goDir, "greetings", "lambda.go")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing written to the package of the build, got %v", err)
+	}
+	template, _ := os.ReadFile(outputs[1])
+	for _, want := range []string{"The functions of shop-api,", "CodeUri: ../go/cmd/lambda/greet\n", "Path: /greet\n"} {
+		if !strings.Contains(string(template), want) {
+			t.Fatalf("expected %q in template:\n%s", want, template)
+		}
+	}
+
+	os.WriteFile("cloudpact.yaml", []byte("name: Shop API\nserverless:\n  framework: serverless\n"), 0644)
+	outputs, err = GenerateLambda("deploy")
+	if err != nil {
+		t.Fatalf("GenerateLambda error: %v", err)
+	}
+	if last := outputs[len(outputs)-1]; last != filepath.Join("deploy", "serverless.yml") {
+		t.Fatalf("expected serverless.yml in deploy, got %s", last)
+	}
+
+	os.WriteFile("more.cp", []byte(`module Welcome
+
+function Greet(name: text) returns text
+    why: "Greets a visitor"
+    do:
+        return name
+`), 0644)
+	if _, err := GenerateLambda(""); err == nil || !strings.Contains(err.Error(), "both served at /greet") {
+		t.Fatalf("expected an error for two functions at one path, got %v", err)
+	}
+}
+
+func TestGenerateConfig(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\n"), 0644)
+	os.WriteFile("orders.cp", []byte("module Orders\n\ndefine record Order\n    total: usd_currency\n"), 0644)
+	if _, err := GenerateConfig(); err == nil || !strings.Contains(err.Error(), "no config declarations") {
+		t.Fatalf("expected an error without settings, got %v", err)
+	}
+
+	os.WriteFile("settings.cp", []byte(`config databaseUrl: url from env DATABASE_URL
+    why: "Orders are kept in PostgreSQL"
+
+config port: int default 8080
+    why: "The port to listen on"
+`), 0644)
+	outputs, err := GenerateConfig()
+	if err != nil {
+		t.Fatalf("GenerateConfig error: %v", err)
+	}
+	want := []string{
+		filepath.Join("generated", "go", "config", "config.go"),
+		filepath.Join("generated", "ts", "env.d.ts"),
+		".env.example",
+	}
+	if fmt.Sprint(outputs) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, outputs)
+	}
+	code, _ := os.ReadFile(outputs[0])
+	if !strings.Contains(string(code), "package config") || !strings.Contains(string(code), "func Load() (*Config, error) {") {
+		t.Fatalf("expected the loader in package config:\n%s", code)
+	}
+	example, _ := os.ReadFile(outputs[2])
+	if !strings.Contains(string(example), "DATABASE_URL=\n") || !strings.Contains(string(example), "PORT=8080\n") {
+		t.Fatalf("expected every variable in .env.example:\n%s", example)
+	}
+
+	os.WriteFile("more.cp", []byte("config listen: int from env PORT\n    why: \"The port to listen on\"\n"), 0644)
+	if _, err := GenerateConfig(); err == nil || !strings.Contains(err.Error(), "settings listen and port are both read from PORT") {
+		t.Fatalf("expected an error for two settings read from one variable, got %v", err)
+	}
+	os.WriteFile("more.cp", []byte("config Port: int\n    why: \"The port to listen on\"\n"), 0644)
+	if _, err := GenerateConfig(); err == nil || !strings.Contains(err.Error(), "setting port is declared in both more.cp and settings.cp") {
+		t.Fatalf("expected an error for a setting declared twice, got %v", err)
+	}
+}
+
+func TestGeneratePolicy(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\n"), 0644)
+	os.WriteFile("orders.cp", []byte(`module Orders
+
+define record Order
+    total: usd_currency
+
+allow support to read Order
+allow admin to read, delete Order
+
+function cancelOrder(id: uuid)
+    requires role admin
+    why: "Only staff cancel orders"
+    do:
+        return
+`), 0644)
+	if _, err := GeneratePolicy(); err == nil || !strings.Contains(err.Error(), "no roles declared") {
+		t.Fatalf("expected an error without roles, got %v", err)
+	}
+
+	os.WriteFile("roles.cp", []byte("define role admin\n    why: \"Staff who run the shop\"\n"), 0644)
+	if _, err := GeneratePolicy(); err == nil || !strings.Contains(err.Error(), "orders.cp allows undeclared role support to read Order") {
+		t.Fatalf("expected an error for an undeclared role, got %v", err)
+	}
+
+	os.WriteFile("roles.cp", []byte("define role admin\n    why: \"Staff who run the shop\"\ndefine role support\n"), 0644)
+	output, err := GeneratePolicy()
+	if err != nil {
+		t.Fatalf("GeneratePolicy error: %v", err)
+	}
+	if want := filepath.Join("generated", "go", "policy", "policy.go"); output != want {
+		t.Fatalf("expected %s, got %s", want, output)
+	}
+	code, _ := os.ReadFile(output)
+	if !strings.Contains(string(code), "package policy") || !strings.Contains(string(code), "\"Order\": {\"read\", \"delete\"},") {
+		t.Fatalf("expected the rules in package policy:\n%s", code)
+	}
+
+	os.WriteFile("staff.cp", []byte("define role admin\n"), 0644)
+	if _, err := GeneratePolicy(); err == nil || !strings.Contains(err.Error(), "role admin is declared in both roles.cp and staff.cp") {
+		t.Fatalf("expected an error for a role declared twice, got %v", err)
+	}
+	os.WriteFile("staff.cp", []byte("function refund(id: uuid)\n    requires role clerk\n    why: \"r\"\n    do:\n        return\n"), 0644)
+	if _, err := GeneratePolicy(); err == nil || !strings.Contains(err.Error(), "function refund of staff.cp requires undeclared role clerk") {
+		t.Fatalf("expected an error for a required role not declared, got %v", err)
+	}
+}
+
+func TestGenerateEventsAndAsyncAPI(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("users.cp", []byte(`module Users
+
+define record User
+    Name: text
+
+define event UserCreated
+    payload: User
+`), 0644)
+	os.WriteFile("billing.cp", []byte(`module Billing
+
+define record Invoice
+    total: usd_currency
+`), 0644)
+
+	outputs, err := GenerateAsyncAPI("")
+	if err != nil {
+		t.Fatalf("GenerateAsyncAPI error: %v", err)
+	}
+	doc := filepath.Join("generated", "asyncapi", "users.yaml")
+	if len(outputs) != 1 || outputs[0] != doc {
+		t.Fatalf("expected only %s, got %v", doc, outputs)
+	}
+	data, _ := os.ReadFile(doc)
+	if !strings.HasPrefix(string(data), "# Code generated by cloudpact") || !strings.Contains(string(data), "address: user.created") {
+		t.Fatalf("unexpected AsyncAPI document:\n%s", data)
+	}
+
+	// The stubs import the records, so they need an import path
+	if _, err := GenerateEvents(""); err == nil || !strings.Contains(err.Error(), "add a go.mod") {
+		t.Fatalf("expected an error without a go.mod, got %v", err)
+	}
+	os.WriteFile("go.mod", []byte("module example.com/shop\n\ngo 1.22\n"), 0644)
+	outputs, err = GenerateEvents("")
+	if err != nil {
+		t.Fatalf("GenerateEvents error: %v", err)
+	}
+	stubs := filepath.Join("generated", "go", "users", "events", "events.go")
+	if len(outputs) != 1 || outputs[0] != stubs {
+		t.Fatalf("expected only %s, got %v", stubs, outputs)
+	}
+	data, _ = os.ReadFile(stubs)
+	for _, want := range []string{"package events", "\"example.com/shop/generated/go/users\"", "func PublishUserCreated(ctx context.Context, broker Broker, event *users.User) error {"} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected %q in event stubs:\n%s", want, data)
+		}
+	}
+
+	// They compile against the package the build writes
+	if _, err := exec.LookPath("go"); err != nil {
+		return
+	}
+	os.WriteFile("cloudpact.yaml", []byte("targets: [go]\n"), 0644)
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	if out, err := exec.Command("go", "vet", "./generated/go/users/...").CombinedOutput(); err != nil {
+		t.Fatalf("go vet: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateQueues(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\n"), 0644)
+	os.WriteFile("mail.cp", []byte("module Mail\n\ndefine record SendEmailTask\n    To: email\n"), 0644)
+	if _, err := GenerateQueues(""); err == nil || !strings.Contains(err.Error(), "no queues declared") {
+		t.Fatalf("expected an error without queues, got %v", err)
+	}
+
+	os.WriteFile("mail.cp", []byte(`module Mail
+
+define record SendEmailTask
+    To: email
+
+queue emailQueue handles SendEmailTask
+    why: "Sending mail is slow"
+`), 0644)
+	outputs, err := GenerateQueues("")
+	if err != nil {
+		t.Fatalf("GenerateQueues error: %v", err)
+	}
+	want := []string{
+		filepath.Join("generated", "go", "mail", "queues.go"),
+		filepath.Join("generated", "ts", "queues.ts"),
+	}
+	if fmt.Sprint(outputs) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, outputs)
+	}
+	code, _ := os.ReadFile(outputs[0])
+	if !strings.Contains(string(code), "package mail") || !strings.Contains(string(code), "func ConsumeEmailQueue(") {
+		t.Fatalf("unexpected queues.go:\n%s", code)
+	}
+	ts, _ := os.ReadFile(outputs[1])
+	for _, want := range []string{
+		"import type { SendEmailTask } from \"./mail\";",
+		"export const EmailQueue = \"emailQueue\" as const;",
+		"export interface QueueTasks {\n  emailQueue: SendEmailTask;\n}",
+	} {
+		if !strings.Contains(string(ts), want) {
+			t.Fatalf("expected %q in queues.ts:\n%s", want, ts)
+		}
+	}
+
+	os.WriteFile("more.cp", []byte("define record SendEmailTask\n    To: email\n\nqueue emailQueue handles SendEmailTask\n"), 0644)
+	if _, err := GenerateQueues(""); err == nil || !strings.Contains(err.Error(), "queue emailQueue is declared in both mail.cp and more.cp") {
+		t.Fatalf("expected an error for a queue declared twice, got %v", err)
+	}
+	os.WriteFile("more.cp", []byte("define record SendEmailTask\n    To: email\n\nqueue bulkQueue handles SendEmailTask\n"), 0644)
+	if _, err := GenerateQueues(""); err == nil || !strings.Contains(err.Error(), "queue payload SendEmailTask is declared in both ./mail and ./more") {
+		t.Fatalf("expected an error for payloads of one name, got %v", err)
+	}
+}
+
+func TestGenerateDataDictionary(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("customers.cp", []byte(`define record Customer
+    email: email
+    nickname: text optional
+    balance: usd_currency round: banker
+`), 0644)
+
+	output, err := GenerateDataDictionary("csv", "")
+	if err != nil {
+		t.Fatalf("GenerateDataDictionary error: %v", err)
+	}
+	data, _ := os.ReadFile(output)
+	for _, want := range []string{
+		"Source,Record,Field,Type,Required,Constraints,Description,PII\n",
+		"customers.cp,Customer,email,email,yes,email,Email address format,yes\n",
+		"customers.cp,Customer,nickname,text,no,,,no\n",
+		"customers.cp,Customer,balance,usd_currency,yes,\"min=0, round=banker\",USD currency amount,no\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected %q in CSV:\n%s", want, data)
+		}
+	}
+
+	output, err = GenerateDataDictionary("xlsx", "")
+	if err != nil {
+		t.Fatalf("GenerateDataDictionary error: %v", err)
+	}
+	archive, err := zip.OpenReader(output)
+	if err != nil {
+		t.Fatalf("expected a zip archive: %v", err)
+	}
+	defer archive.Close()
+	for _, f := range archive.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		r, _ := f.Open()
+		sheet, _ := io.ReadAll(r)
+		r.Close()
+		if !strings.Contains(string(sheet), `<t xml:space="preserve">min=0, round=banker</t>`) {
+			t.Fatalf("expected constraints in sheet:\n%s", sheet)
+		}
+		return
+	}
+	t.Fatalf("expected a worksheet in %s", output)
+}
+
+func TestBuildZodSchemas(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "customers.cp")
+	os.WriteFile(source, []byte(`define record Customer versioned
+    email: email
+    phone: phone optional
+    discount: percentage
+    orders: list of Order
+
+define record Order
+    total: usd_currency
+    owner: Account
+
+define record Money no id
+    amount: number
+
+define record Country
+    code: text key
+`), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+
+	zodCode, err := os.ReadFile(filepath.Join("generated", "zod", "customers.schemas.ts"))
+	if err != nil {
+		t.Fatalf("expected zod output: %v", err)
+	}
+	for _, want := range []string{
+		"import { z } from 'zod';",
+		"export const CustomerSchema = z.object({\n  id: z.string().uuid(),\n  email: z.string().email(),\n",
+		"  phone: z.string().regex(/^\\+[1-9]\\d{1,14}$/).optional(),\n",
+		"  discount: z.number().min(0).max(100),\n",
+		"  orders: z.array(z.lazy(() => OrderSchema)),\n",
+		"  version: z.number().int(),\n",
+		"export type CustomerInput = z.infer<typeof CustomerSchema>;",
+		"  total: z.number().min(0),\n",
+		"  owner: z.unknown(),\n",
+		"export const MoneySchema = z.object({\n  amount: z.number(),\n});",
+		"export const CountrySchema = z.object({\n  code: z.string(),\n});",
+	} {
+		if !strings.Contains(string(zodCode), want) {
+			t.Fatalf("expected %q in zod output:\n%s", want, zodCode)
+		}
+	}
+}
+
+func TestBuildFieldConstraints(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "people.cp")
+	os.WriteFile(source, []byte(`define record Person
+    name: text maxlength 80
+    age: int min 0 max 150
+    score: percentage max 50 optional
+`), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	goCode, _ := os.ReadFile(outputPaths(source, codegenOptions{})[0])
+	for _, want := range []string{
+		`validate:"required,max=80"`,
+		`validate:"required,min=0,max=150"`,
+		`validate:"omitempty,min=0,max=50"`,
+	} {
+		if !strings.Contains(string(goCode), want) {
+			t.Fatalf("expected %q in generated Go:\n%s", want, goCode)
+		}
+	}
+	zodCode, _ := os.ReadFile(filepath.Join("generated", "zod", "people.schemas.ts"))
+	for _, want := range []string{
+		"  name: z.string().max(80),\n",
+		"  age: z.number().int().min(0).max(150),\n",
+		"  score: z.number().min(0).max(50).optional(),\n",
+	} {
+		if !strings.Contains(string(zodCode), want) {
+			t.Fatalf("expected %q in zod output:\n%s", want, zodCode)
+		}
+	}
+}
+
+func TestBuildCustomTypes(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	typesSource := filepath.Join("models", "types.cp")
+	source := filepath.Join("models", "staff.cp")
+	os.WriteFile(typesSource, []byte("define type StaffEmail as email\n    validate: domain must be \"company.com\"\n"), 0644)
+	os.WriteFile(source, []byte("define record Staff\n    email: StaffEmail\n"), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	outputs := outputPaths(source, codegenOptions{})
+	goCode, _ := os.ReadFile(outputs[0])
+	if !strings.Contains(string(goCode), "Email string `json:\"email\" validate:\"required,email,endswith=@company.com\"`") {
+		t.Fatalf("expected the custom type's validation in generated Go:\n%s", goCode)
+	}
+	tsCode, _ := os.ReadFile(outputs[1])
+	if !strings.Contains(string(tsCode), "email: string; // StaffEmail, Email address format") {
+		t.Fatalf("expected the custom type in generated TypeScript:\n%s", tsCode)
+	}
+
+	// Changing the type rebuilds the files using it, though they are unchanged
+	os.WriteFile(typesSource, []byte("define type StaffEmail as email\n    validate: domain must be \"example.org\"\n"), 0644)
+	if err := BuildFiles([]string{typesSource}); err != nil {
+		t.Fatalf("BuildFiles error: %v", err)
+	}
+	goCode, _ = os.ReadFile(outputs[0])
+	if !strings.Contains(string(goCode), "endswith=@example.org") {
+		t.Fatalf("expected the changed type in generated Go:\n%s", goCode)
+	}
+}
+
+func TestBuildRecordExtends(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "users.cp")
+	os.WriteFile(source, []byte(`define record User
+    name: text
+
+define record AdminUser extends User
+    level: int
+`), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	outputs := outputPaths(source, codegenOptions{})
+	goCode, _ := os.ReadFile(outputs[0])
+	if !strings.Contains(string(goCode), "type AdminUser struct {\n\tUser\n\tLevel int") {
+		t.Fatalf("expected AdminUser to embed User in generated Go:\n%s", goCode)
+	}
+	tsCode, _ := os.ReadFile(outputs[1])
+	if !strings.Contains(string(tsCode), "export interface AdminUser extends User {\n  level: number;\n}") {
+		t.Fatalf("expected AdminUser to extend User in generated TypeScript:\n%s", tsCode)
+	}
+	zodCode, _ := os.ReadFile(filepath.Join("generated", "zod", "users.schemas.ts"))
+	if !strings.Contains(string(zodCode), "export const AdminUserSchema = z.object({\n  id: z.string().uuid(),\n  name: z.string(),\n  level: z.number().int(),\n});") {
+		t.Fatalf("expected flattened fields in zod output:\n%s", zodCode)
+	}
+}
+
+func TestBuildMapTypes(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "teams.cp")
+	os.WriteFile(source, []byte(`define record Team
+    scores: map of text to int
+    budgets: map<int, usd_currency>
+`), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	outputs := outputPaths(source, codegenOptions{})
+	goCode, _ := os.ReadFile(outputs[0])
+	for _, want := range []string{"Scores  map[string]int ", "Budgets map[int]float64 "} {
+		if !strings.Contains(string(goCode), want) {
+			t.Fatalf("expected %q in generated Go:\n%s", want, goCode)
+		}
+	}
+	tsCode, _ := os.ReadFile(outputs[1])
+	for _, want := range []string{"scores: Record<string, number>;", "budgets: Record<number, number>;"} {
+		if !strings.Contains(string(tsCode), want) {
+			t.Fatalf("expected %q in generated TypeScript:\n%s", want, tsCode)
+		}
+	}
+	zodCode, _ := os.ReadFile(filepath.Join("generated", "zod", "teams.schemas.ts"))
+	if !strings.Contains(string(zodCode), "  scores: z.record(z.string(), z.number().int()),\n") {
+		t.Fatalf("expected a record schema in zod output:\n%s", zodCode)
+	}
+}
+
+func TestBuildMaybeFields(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "people.cp")
+	os.WriteFile(source, []byte(`define record Person
+    nickname: maybe text
+    note: text optional
+
+function hasNickname(person: Person) returns boolean
+    why: "Checks for a nickname"
+    do:
+        return person.nickname not none
+`), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	outputs := outputPaths(source, codegenOptions{})
+	goCode, _ := os.ReadFile(outputs[0])
+	for _, want := range []string{
+		"Nickname *string `json:\"nickname\" validate:\"omitempty\"`",
+		"Note     *string `json:\"note,omitempty\"",
+		"return person.Nickname != nil",
+	} {
+		if !strings.Contains(string(goCode), want) {
+			t.Fatalf("expected %q in generated Go:\n%s", want, goCode)
+		}
+	}
+	tsCode, _ := os.ReadFile(outputs[1])
+	for _, want := range []string{"  nickname: string | null;\n", "  note?: string;\n", "return person.nickname != null;"} {
+		if !strings.Contains(string(tsCode), want) {
+			t.Fatalf("expected %q in generated TypeScript:\n%s", want, tsCode)
+		}
+	}
+	zodCode, _ := os.ReadFile(filepath.Join("generated", "zod", "people.schemas.ts"))
+	if !strings.Contains(string(zodCode), "  nickname: z.string().nullable(),\n") {
+		t.Fatalf("expected a nullable schema in zod output:\n%s", zodCode)
+	}
+}
+
+func TestBuildListOperations(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "orders.cp")
+	os.WriteFile(source, []byte(`define record Order
+    total: usd_currency
+    tags: list of text
+
+function firstBig(orders: list of Order) returns maybe Order
+    why: "Finds the first large order"
+    do:
+        return first of orders where total > 100
+
+function isRush(order: Order) returns boolean
+    why: "Rush orders carry a tag"
+    do:
+        return "rush" in order.tags
+`), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	outputs := outputPaths(source, codegenOptions{})
+	goCode, _ := os.ReadFile(outputs[0])
+	for _, want := range []string{
+		"\"slices\"",
+		"for _, item := range orders {\n\t\t\tif item.Total > 100 {\n\t\t\t\tfound := item\n\t\t\t\treturn &found",
+		"return slices.Contains(order.Tags, \"rush\")",
+	} {
+		if !strings.Contains(string(goCode), want) {
+			t.Fatalf("expected %q in generated Go:\n%s", want, goCode)
+		}
+	}
+	tsCode, _ := os.ReadFile(outputs[1])
+	for _, want := range []string{
+		"return (orders.find((item) => item.total > 100) ?? null);",
+		"return order.tags.includes(\"rush\");",
+	} {
+		if !strings.Contains(string(tsCode), want) {
+			t.Fatalf("expected %q in generated TypeScript:\n%s", want, tsCode)
+		}
+	}
+}
+
+func TestBuildIsOperators(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "users.cp")
+	os.WriteFile(source, []byte(`define record User
+    name: text
+    nickname: maybe text
+    tags: list of text
+
+function greet(user: User) returns boolean
+    why: "Only named users are greeted"
+    do:
+        if user.name is empty
+            then return false
+        if user.nickname is not empty
+            then return true
+        return user.tags is not empty
+`), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	outputs := outputPaths(source, codegenOptions{})
+	goCode, _ := os.ReadFile(outputs[0])
+	for _, want := range []string{`if user.Name == "" {`, `if user.Nickname != nil && *user.Nickname != "" {`, "return len(user.Tags) > 0"} {
+		if !strings.Contains(string(goCode), want) {
+			t.Fatalf("expected %q in generated Go:\n%s", want, goCode)
+		}
+	}
+	tsCode, _ := os.ReadFile(outputs[1])
+	for _, want := range []string{"if (user.name.length === 0) {", "(user.nickname != null && user.nickname.length > 0)", "return user.tags.length > 0;"} {
+		if !strings.Contains(string(tsCode), want) {
+			t.Fatalf("expected %q in generated TypeScript:\n%s", want, tsCode)
+		}
+	}
+}
+
+func TestREPL(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+
+	src := `define record Order
+    total: number
+
+function discount(order: Order) returns number
+    why: "Large orders earn a loyalty discount"
+    do:
+        if order.total > 5000
+            then fail "orders over 5000 need approval"
+        return 10 if order.total > 100 else 0
+`
+	if err := os.WriteFile("orders.cp", []byte(src), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	input := strings.Join([]string{
+		"discount(order)",
+		`create Order with: total = 150`,
+		"discount(order)",
+		`create Order with: total = 6000`,
+		"discount(order)",
+		"unknown",
+		"disc\t",
+		"order.to\t",
+		":reset",
+		"order.total",
+		":quit",
+		"discount(order)",
+	}, "\n")
+	var out strings.Builder
+	if err := REPL(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("REPL: %v", err)
+	}
+
+	for _, want := range []string{
+		"1 functions and 1 records loaded",
+		"cp> 0\n",
+		"cp> 10\n",
+		"fail: orders over 5000 need approval",
+		"error: unknown is not defined",
+		"cp> discount\n",
+		"cp> order.total\n",
+		"sample values restored",
+		"cp> 1.5\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in output:\n%s", want, out.String())
+		}
+	}
+	if strings.Count(out.String(), "cp> ") != 11 {
+		t.Errorf("expected input after :quit to be ignored:\n%s", out.String())
+	}
+}
+
+func TestRunFunctionDebug(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "orders.cp")
+	src := `define record Order
+    total: number
+
+function discount(order: Order) returns number
+    why: "Large orders earn a loyalty discount"
+    do:
+        set big = order.total > 100
+        return 10 if big else 0
+`
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	result, err := RunFunction(path, "discount", RunOptions{Output: io.Discard})
+	if err != nil || result != int64(0) {
+		t.Fatalf("RunFunction = %v, %v", result, err)
+	}
+
+	result, err = RunFunction(path, "discount", RunOptions{Args: map[string]string{"order.total": "150"}, Output: io.Discard})
+	if err != nil || result != int64(10) {
+		t.Fatalf("RunFunction with args = %v, %v", result, err)
+	}
+	for args, want := range map[string]string{
+		"customer":    "discount has no parameter customer",
+		"order.count": "record Order has no field count",
+		"order.total": `expected a number for number, got "lots"`,
+	} {
+		_, err := RunFunction(path, "discount", RunOptions{Args: map[string]string{args: "lots"}, Output: io.Discard})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("--arg %s=lots: expected %q, got %v", args, want, err)
+		}
+	}
+
+	var trace strings.Builder
+	if _, err := RunFunction(path, "discount", RunOptions{Trace: true, Output: &trace}); err != nil {
+		t.Fatalf("traced RunFunction: %v", err)
+	}
+	if !strings.Contains(trace.String(), "line 8: discount returned 0 because big is false") {
+		t.Errorf("unexpected trace:\n%s", trace.String())
+	}
+
+	input := strings.Join([]string{"vars", "p order.total > 1", "n", "p big", "c"}, "\n")
+	var out strings.Builder
+	result, err = RunFunction(path, "discount", RunOptions{Debug: true, Input: strings.NewReader(input), Output: &out})
+	if err != nil || result != int64(0) {
+		t.Fatalf("debug RunFunction = %v, %v", result, err)
+	}
+	for _, want := range []string{
+		"stopped at line 7 in discount (entry)",
+		"   7  set big = order.total > 100",
+		"order = {total: 1.5}",
+		"(debug) true\n",
+		"stopped at line 8 in discount (step)",
+		"(debug) false\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in output:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	_, err = RunFunction(path, "discount", RunOptions{Breakpoints: []int{8}, Input: strings.NewReader("q\n"), Output: &out})
+	if !strings.Contains(out.String(), "stopped at line 8 in discount (breakpoint)") || err == nil {
+		t.Fatalf("expected to stop at the breakpoint and terminate, got %v:\n%s", err, out.String())
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	files := map[string]string{
+		"users.cp": `define record User
+    name: text
+
+function validateUser(user: User) returns boolean
+    why: "Users need a name"
+    do:
+        if user.name = "" then return false
+        return true
+`,
+		"users_test.cp": `test "rejects empty name":
+    create User with:
+        name = ""
+    expect validateUser(user) is false
+
+test "accepts empty name":
+    create User with:
+        name = ""
+    expect validateUser(user)
+`,
+	}
+	for path, src := range files {
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+
+	results, err := RunTests([]string{"users_test.cp"})
+	if err != nil {
+		t.Fatalf("RunTests: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected two results, got %v", results)
+	}
+	if results[0].Name != "rejects empty name" || results[0].Err != nil {
+		t.Errorf("unexpected first result: %+v", results[0])
+	}
+	if results[1].Err == nil || !strings.Contains(results[1].Err.Error(), "to be true, but it was false") {
+		t.Errorf("unexpected second result: %+v", results[1])
+	}
+
+	results, err = RunTests(nil)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("RunTests over the project = %v, %v", results, err)
+	}
+}
+
+func TestGenerateDocs(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("customers.cp", []byte(`define record Customer
+    email: email
+`), 0644)
+
+	for format, want := range map[string]string{
+		"md":   "| `email` | `email` | yes | An email address, such as user@example.com. |",
+		"html": "<td><code>email</code></td>",
+	} {
+		outputs, err := GenerateDocs(format, "")
+		if err != nil {
+			t.Fatalf("GenerateDocs(%s) error: %v", format, err)
+		}
+		if len(outputs) != 1 || outputs[0] != filepath.Join("generated", "docs", "customers."+format) {
+			t.Fatalf("unexpected outputs %v", outputs)
+		}
+		data, _ := os.ReadFile(outputs[0])
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in:\n%s", want, data)
+		}
+	}
+	if _, err := GenerateDocs("pdf", ""); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestGraph(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("orders.cp", []byte(`define record Customer
+    email: email
+
+define record Order
+    customer: Customer
+`), 0644)
+
+	g, err := Graph()
+	if err != nil {
+		t.Fatalf("Graph error: %v", err)
+	}
+	if dot := g.DOT(); !strings.Contains(dot, `"orders.Order" -> "orders.Customer" [label="customer"];`) {
+		t.Errorf("expected the customer relationship in:\n%s", dot)
+	}
+}
+
+func TestMigrateSyntax(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	os.WriteFile("models/user.cp", []byte("model User {\n    name: String\n}\n"), 0644)
+	os.WriteFile("models/order.cp", []byte("define record Order\n    total: usd_currency\n"), 0644)
+
+	changed, err := MigrateSyntax("models")
+	if err != nil {
+		t.Fatalf("MigrateSyntax error: %v", err)
+	}
+	if len(changed) != 1 || changed[0] != filepath.Join("models", "user.cp") {
+		t.Fatalf("expected only user.cp to change, got %v", changed)
+	}
+	data, _ := os.ReadFile("models/user.cp")
+	if string(data) != "define record User\n    name: text\n" {
+		t.Errorf("unexpected migrated source:\n%s", data)
+	}
+}
+
+func TestEnterRoot(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+
+	os.MkdirAll(filepath.Join(dir, "app", "models", "billing"), 0755)
+	os.WriteFile(filepath.Join(dir, "app", "cloudpact.yaml"), []byte("targets: [go]\n"), 0644)
+
+	os.Chdir(filepath.Join(dir, "app", "models", "billing"))
+	root, err := FindRoot(".")
+	if err != nil || root != filepath.Join(dir, "app") {
+		t.Fatalf("FindRoot = %q, %v", root, err)
+	}
+	rel, err := EnterRoot("")
+	if err != nil {
+		t.Fatalf("EnterRoot error: %v", err)
+	}
+	if wd, _ := os.Getwd(); wd != filepath.Join(dir, "app") || rel != filepath.Join("models", "billing") {
+		t.Errorf("EnterRoot moved to %s, returned %q", wd, rel)
+	}
+
+	// An explicit directory is searched from, even from outside the project
+	os.Chdir(dir)
+	if rel, err := EnterRoot(filepath.Join("app", "models")); err != nil || rel != ".." {
+		t.Errorf("EnterRoot(app/models) = %q, %v", rel, err)
+	}
+	if _, err := EnterRoot("missing"); err == nil {
+		t.Error("expected an error for a missing project directory")
+	}
+
+	// Outside a project the working directory stays
+	os.Chdir(dir)
+	if _, err := FindRoot("."); !errors.Is(err, ErrNoProject) {
+		t.Errorf("expected ErrNoProject, got %v", err)
+	}
+	if rel, err := EnterRoot(""); err != nil || rel != "." {
+		t.Errorf("EnterRoot outside a project = %q, %v", rel, err)
+	}
+	if wd, _ := os.Getwd(); wd != dir {
+		t.Errorf("expected to stay in %s, moved to %s", dir, wd)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("target: [go]\n"), 0644)
+	os.WriteFile("user.cp", []byte("define record User\n    name: text\n"), 0644)
+	err := Build()
+	if err == nil || !strings.Contains(err.Error(), "unknown setting target") {
+		t.Errorf("expected the misspelled setting to be reported, got %v", err)
+	}
+}
+
+func TestOutputDirectories(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("outputs:\n  go: internal/gen\n  ts: web/src/api\n  docs: site\n"), 0644)
+	os.WriteFile("user.cp", []byte("define record User\n    name: text\n"), 0644)
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	manifest, err := LoadManifest()
+	if err != nil {
+		t.Fatalf("LoadManifest error: %v", err)
+	}
+	targets := make(map[string]string)
+	for _, a := range manifest.Artifacts {
+		targets[filepath.ToSlash(a.Path)] = a.Target
+	}
+	for path, target := range map[string]string{
+		"internal/gen/user.go":        "go",
+		"internal/gen/user_test.go":   "gotest",
+		"web/src/api/user.ts":         "ts",
+		"generated/openapi/user.yaml": "openapi",
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to be generated: %v", path, err)
+		}
+		if targets[path] != target {
+			t.Errorf("manifest target of %s = %q, want %q", path, targets[path], target)
+		}
+	}
+
+	outputs, err := GenerateDocs("md", "")
+	if err != nil || len(outputs) != 1 || outputs[0] != filepath.Join("site", "user.md") {
+		t.Errorf("GenerateDocs = %v, %v; want the configured directory", outputs, err)
+	}
+	outputs, err = GenerateDocs("md", filepath.Join("out", "docs"))
+	if err != nil || len(outputs) != 1 || outputs[0] != filepath.Join("out", "docs", "user.md") {
+		t.Errorf("GenerateDocs = %v, %v; want the given directory", outputs, err)
+	}
+
+	os.WriteFile("cloudpact.yaml", []byte("outputs:\n  golang: internal/gen\n"), 0644)
+	if err := Build(); err == nil || !strings.Contains(err.Error(), `unknown output "golang"`) {
+		t.Errorf("expected the unknown output to be reported, got %v", err)
+	}
+	os.WriteFile("cloudpact.yaml", []byte("outputs:\n  gotest: internal/tests\n"), 0644)
+	if err := Build(); err == nil || !strings.Contains(err.Error(), `output "gotest" in cloudpact.yaml cannot be moved`) {
+		t.Errorf("expected moving the Go tests to be rejected, got %v", err)
+	}
+}
+
+func TestInitTemplatesVerifyGo(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("needs the go command")
+	}
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+
+	// The services of these templates use records of the models module
+	for _, template := range []string{"api", "fullstack"} {
+		os.Chdir(dir)
+		if err := Init(template, InitOptions{Template: template, Module: "example.com/" + template}); err != nil {
+			t.Fatalf("Init(%s) error: %v", template, err)
+		}
+		os.Chdir(template)
+		if _, err := BuildWith(context.Background(), BuildSettings{VerifyGo: true}); err != nil {
+			t.Errorf("%s: the scaffolded Go does not verify: %v", template, err)
+		}
+	}
+}
+
+func TestInitTemplates(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+
+	for _, template := range Templates {
+		os.Chdir(dir)
+		if err := Init(template, InitOptions{Template: template, Module: "example.com/" + template}); err != nil {
+			t.Fatalf("Init(%s) error: %v", template, err)
+		}
+		os.Chdir(template)
+		settings, err := config.Load(config.FileName)
+		if err != nil {
+			t.Fatalf("%s: %v", template, err)
+		}
+		if settings.Name != template || settings.GoModule != "example.com/"+template {
+			t.Errorf("%s: unexpected settings %+v", template, settings)
+		}
+		if _, err := os.Stat(".gitignore"); err != nil {
+			t.Errorf("%s: expected a .gitignore: %v", template, err)
+		}
+		if _, err := os.Stat(filepath.Join("web", "index.html")); (err == nil) != (template == "fullstack") {
+			t.Errorf("%s: web/index.html exists = %v", template, err == nil)
+		}
+		if err := Build(); err != nil {
+			t.Errorf("%s: the scaffolded project does not build: %v", template, err)
+		}
+	}
+
+	os.Chdir(dir)
+	if goMod, _ := os.ReadFile(filepath.Join("api", "go.mod")); !strings.HasPrefix(string(goMod), "module example.com/api\n") {
+		t.Errorf("unexpected go.mod:\n%s", goMod)
+	}
+	if err := Init("shop", InitOptions{Template: "desktop"}); err == nil || !strings.Contains(err.Error(), "available: minimal, api, fullstack") {
+		t.Errorf("expected the unknown template to be reported, got %v", err)
+	}
+}
+
+func TestInitFrom(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	files := map[string]string{
+		"template/cloudpact.yaml":   "name: {{.ProjectName}}\ngo_module: {{.GoModule}}\n",
+		"template/gitignore":        "generated/\n",
+		"template/go.mod.tmpl":      "module {{.GoModule}}\n",
+		"template/models/order.cp":  "module {{.ModuleName}}\n\ndefine record Order\n    total: number\n",
+		"template/NOTICE":           "{{if not .Template}}Custom scaffold{{end}} (c) {{.Year}} {{upper .Author}}\n",
+		"template/.git/HEAD":        "ref: refs/heads/main\n",
+		"unclosed/README.md":        "{{if .Author}}by {{.Author}}\n",
+		"broken/models/order.cp":    "define record\n",
+		"remote/models/customer.cp": "define record Customer\n    email: email\n",
+		"remote/README.md":          "# {{.ProjectName}}\n",
+	}
+	for path, content := range files {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+
+	if err := Init("shop", InitOptions{From: "template", Module: "example.com/shop", Author: "Ada"}); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	for path, want := range map[string]string{
+		"shop/cloudpact.yaml":  "name: shop\ngo_module: example.com/shop\n",
+		"shop/.gitignore":      "generated/\n",
+		"shop/go.mod":          "module example.com/shop\n",
+		"shop/NOTICE":          fmt.Sprintf("Custom scaffold (c) %d ADA\n", time.Now().Year()),
+		"shop/models/order.cp": "module shop\n\ndefine record Order\n    total: number\n",
+	} {
+		if got, _ := os.ReadFile(path); string(got) != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("shop", ".git")); err == nil {
+		t.Error("the template's .git directory was copied")
+	}
+
+	if err := Init("bad", InitOptions{From: "broken"}); err == nil || !strings.Contains(err.Error(), "order.cp") {
+		t.Errorf("expected the unparsable template to be reported, got %v", err)
+	}
+	if err := Init("unclosed", InitOptions{From: "unclosed"}); err == nil || !strings.Contains(err.Error(), "README.md") {
+		t.Errorf("expected the broken template file to be reported, got %v", err)
+	}
+	if err := Init("both", InitOptions{From: "template", Template: "api"}); err == nil {
+		t.Error("expected an error for both a template and --from")
+	}
+
+	fetch := fetchTemplate
+	defer func() { fetchTemplate = fetch }()
+	var fetched string
+	fetchTemplate = func(source, dest string) error {
+		fetched = source
+		os.MkdirAll(filepath.Join(dest, "models"), 0755)
+		for _, path := range []string{"models/customer.cp", "README.md"} {
+			os.WriteFile(filepath.Join(dest, path), []byte(files["remote/"+path]), 0644)
+		}
+		return nil
+	}
+	if err := Init("crm", InitOptions{From: "github.com/acme/cp-template"}); err != nil {
+		t.Fatalf("Init from a repository error: %v", err)
+	}
+	if readme, _ := os.ReadFile(filepath.Join("crm", "README.md")); fetched != "github.com/acme/cp-template" || string(readme) != "# crm\n" {
+		t.Errorf("fetched %q, README %q", fetched, readme)
+	}
+
+	fetched = ""
+	if err := Init("evil", InitOptions{From: "--upload-pack=touch pwned"}); err == nil || fetched != "" {
+		t.Errorf("expected an option-like template to be rejected before fetching, got %v, fetched %q", err, fetched)
+	}
+}
+
+func TestBuildMocks(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\ntargets: [go, mocks]\n"), 0644)
+	os.WriteFile("customers.cp", []byte("module Customers\n\ndefine record Customer\n    email: email\n"), 0644)
+	os.WriteFile("greetings.cp", []byte(`module Greetings
+
+function greet(name: text) returns text
+    why: "Greets a customer"
+    do:
+        return name
+`), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	want := filepath.Join("generated", "go", "greetings", "greetings_mock.go")
+	data, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatalf("expected %s: %v", want, err)
+	}
+	for _, want := range []string{
+		"// Code generated by cloudpact v" + codegen.Version + " from greetings.cp",
+		"package greetings",
+		"type Greetings interface {",
+		"type MockGreetings struct {",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected %q in mocks:\n%s", want, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("generated", "go", "customers", "customers_mock.go")); !os.IsNotExist(err) {
+		t.Fatalf("expected no mocks of a file without functions, got %v", err)
+	}
+	manifest, err := LoadManifest()
+	if err != nil {
+		t.Fatalf("LoadManifest error: %v", err)
+	}
+	for _, artifact := range manifest.Artifacts {
+		if strings.HasSuffix(artifact.Path, "customers_mock.go") {
+			t.Fatalf("expected no manifest entry for missing mocks, got %+v", artifact)
+		}
+	}
+}
+
+func TestBuildModulePackages(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "users.cp")
+	os.WriteFile(source, []byte("module Users\n\ndefine record User\n    name: text\n"), 0644)
+	os.WriteFile("cloudpact.yaml", []byte("targets: [go, gotest]\n"), 0644)
+
+	if err := Build(); err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	goOutput := filepath.Join("generated", "go", "users", "users.go")
+	testOutput := filepath.Join("generated", "go", "users", "users_test.go")
+	if outputs := outputPaths(source, codegenOptions{}); outputs[0] != goOutput || outputs[4] != testOutput {
+		t.Fatalf("expected module outputs in generated/go/users, got %v", outputs)
+	}
+	manifest, err := LoadManifest()
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	for _, artifact := range manifest.Artifacts {
+		if want := map[string]string{"generated/go/users/users.go": "go", "generated/go/users/users_test.go": "gotest"}[artifact.Path]; artifact.Target != want {
+			t.Fatalf("unexpected artifact: %+v", artifact)
+		}
+	}
+
+	// Renaming the module moves its package and removes the old one
+	os.WriteFile(source, []byte("module Accounts\n\ndefine record User\n    name: text\n"), 0644)
+	if err := BuildFiles([]string{source}); err != nil {
+		t.Fatalf("BuildFiles error: %v", err)
+	}
+	if _, err := os.Stat(goOutput); !os.IsNotExist(err) {
+		t.Fatalf("expected %s removed, got %v", goOutput, err)
+	}
+	moved := filepath.Join("generated", "go", "accounts", "users.go")
+	if goCode, _ := os.ReadFile(moved); !strings.Contains(string(goCode), "package accounts") {
+		t.Fatalf("expected package accounts in %s:\n%s", moved, goCode)
+	}
+
+	// A deleted source's outputs are found through the cache
+	os.Remove(source)
+	if err := BuildFiles([]string{source}); err != nil {
+		t.Fatalf("BuildFiles error: %v", err)
+	}
+	if _, err := os.Stat(moved); !os.IsNotExist(err) {
+		t.Fatalf("expected %s removed, got %v", moved, err)
+	}
+}
+
+func TestGenerateServer(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("go.mod", []byte("module example.com/shop\n\ngo 1.22\n"), 0644)
+	os.WriteFile("cloudpact.yaml", []byte("go_module: example.com/ignored\n"), 0644)
+	os.WriteFile("customers.cp", []byte("module Customers\n\ndefine record Customer\n    email: email\n"), 0644)
+	os.WriteFile("scripts.cp", []byte("function ping() returns text\n    why: \"Checks the service\"\n    do:\n        return \"pong\"\n"), 0644)
+	os.WriteFile("greetings.cp", []byte(`module Greetings
+
+function greet(name: text) returns text
+    why: "Greets a customer"
+    do:
+        return name
+`), 0644)
+
+	output, err := GenerateServer("")
+	if err != nil {
+		t.Fatalf("GenerateServer error: %v", err)
+	}
+	if want := filepath.Join("generated", "go", "cmd", "server", "main.go"); output != want {
+		t.Fatalf("expected %s, got %s", want, output)
+	}
+	data, _ := os.ReadFile(output)
+	for _, want := range []string{
+		"// Code generated by cloudpact v" + codegen.Version + "; DO NOT EDIT.",
+		"\t\"example.com/shop/generated/go/greetings\"\n)",
+		"mux.Handle(\"/greet\", greetings.GreetHandler(nil))",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected %q in server:\n%s", want, data)
+		}
+	}
+	// Packages without functions and files in package main are left out
+	if strings.Contains(string(data), "customers") || strings.Contains(string(data), "ping") {
+		t.Fatalf("unexpected routes in server:\n%s", data)
+	}
+
+	os.Remove("go.mod")
+	os.WriteFile("cloudpact.yaml", []byte("name: shop\n"), 0644)
+	if _, err := GenerateServer(""); err == nil || !strings.Contains(err.Error(), "go_module") {
+		t.Fatalf("expected an error without a module path, got %v", err)
+	}
+}
+
+func TestBuildVerifyGo(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("needs the go command")
+	}
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	source := filepath.Join("models", "users.cp")
+	os.WriteFile(source, []byte(`module Users
+
+define record User
+    displayName: text
+    display_name: text
+
+function greet(name: text) returns text
+    why: "Greets a user"
+    do:
+        return name
+`), 0644)
+	os.WriteFile("cloudpact.yaml", []byte("targets: [go]\nverify_go: true\n"), 0644)
+
+	// A template override that breaks the function body
+	override := filepath.Join(templateOverrideDir, "go", "function.tmpl")
+	os.MkdirAll(filepath.Dir(override), 0755)
+	os.WriteFile(override, []byte("func {{.Name}}({{range $i, $p := .Params}}{{if $i}}, {{end}}{{$p.Name}} {{$p.Type}}{{end}}){{with .Returns}} {{.}}{{end}} {\n\treturn missing\n}"), 0644)
+
+	report, err := BuildWith(context.Background(), BuildSettings{})
+	if err == nil || !strings.Contains(err.Error(), "generated Go does not compile") {
+		t.Fatalf("expected a compile error, got %v", err)
+	}
+	if len(report.Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", report.Diagnostics)
+	}
+	d := report.Diagnostics[0]
+	if d.Code != grammar.CodeGoBuild || d.Range.Start.File != source || d.Range.Start.Line != 7 || !strings.Contains(d.Message, "generated/go/users/users.go") || !strings.Contains(d.Message, "undefined: missing") {
+		t.Fatalf("expected the error at greet, got %s", d.Format())
+	}
+
+	// Errors in a function body are reported at the statement they come from
+	os.RemoveAll(templateOverrideDir)
+	os.WriteFile(source, []byte(`module Users
+
+function greet(name: text) returns text
+    why: "Greets a user"
+    do:
+        set greeting = name
+        return name
+`), 0644)
+	report, err = BuildWith(context.Background(), BuildSettings{})
+	if err == nil || len(report.Diagnostics) != 1 {
+		t.Fatalf("expected one compile error, got %v: %v", err, report.Diagnostics)
+	}
+	if d := report.Diagnostics[0]; d.Range.Start.File != source || d.Range.Start.Line != 6 || d.Range.Start.Column != 9 || !strings.Contains(d.Message, "declared and not used: greeting") {
+		t.Fatalf("expected the error at the set statement, got %s", d.Format())
+	}
+
+	// vet findings are warnings, reported at the field they came from: with
+	// snake_case names both fields are sent as display_name
+	os.WriteFile(source, []byte(`module Users
+
+define record User
+    displayName: text
+    display_name: text
+`), 0644)
+	os.WriteFile("cloudpact.yaml", []byte("targets: [go]\njson_names: snake\n"), 0644)
+	report, err = BuildWith(context.Background(), BuildSettings{VerifyGo: true})
+	if err != nil {
+		t.Fatalf("BuildWith error: %v", err)
+	}
+	var warned bool
+	for _, d := range report.Diagnostics {
+		if d.Severity == grammar.SeverityError {
+			t.Fatalf("unexpected error: %s", d.Format())
+		}
+		if d.Code == grammar.CodeGoVet && d.Range.Start.Line == 5 && strings.Contains(d.Message, "repeats json tag") {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Fatalf("expected a vet warning at the display_name field, got %v", report.Diagnostics)
+	}
+}
+
+func TestValidateOpenAPI(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	os.WriteFile(filepath.Join("models", "notes.cp"), []byte("define record Note\n    text: text optional\n"), 0644)
+	os.WriteFile("cloudpact.yaml", []byte("targets: [openapi]\n"), 0644)
+
+	if _, err := BuildWith(context.Background(), BuildSettings{Strict: true}); err != nil {
+		t.Fatalf("strict build: %v", err)
+	}
+	violations, err := ValidateOpenAPI(nil)
+	if err != nil {
+		t.Fatalf("ValidateOpenAPI: %v", err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("expected the generated spec to be valid, got %v", violations)
+	}
+
+	os.WriteFile("broken.yaml", []byte("openapi: 3.0.3\ninfo:\n  title: Notes\n  version: \"1\"\npaths:\n  notes: {}\n"), 0644)
+	violations, err = ValidateOpenAPI([]string{"broken.yaml"})
+	if err != nil {
+		t.Fatalf("ValidateOpenAPI: %v", err)
+	}
+	if len(violations) != 1 || violations[0].String() != "broken.yaml: error oas3-schema: path notes must start with / (at /paths/notes)" {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+}
+
+func TestAPIDocs(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("models", 0755)
+	os.WriteFile(filepath.Join("models", "notes.cp"), []byte("define record Note\n    text: text\n"), 0644)
+	os.WriteFile("cloudpact.yaml", []byte("targets: [openapi]\n"), 0644)
+
+	server := newDevServer()
+	handler := server.handleAPIDocs()
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	if rec := get("/docs/"); !strings.Contains(rec.Body.String(), "No OpenAPI specs have been generated yet") {
+		t.Fatalf("expected a page saying there are no specs, got %s", rec.Body.String())
+	}
+
+	if err := server.rebuild(); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	rec := get("/docs/")
+	for _, want := range []string{"swagger-ui-bundle.js", `"url":"/docs/specs/notes.yaml"`, "/__cloudpact/events"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("expected %q in Swagger UI page:\n%s", want, rec.Body.String())
+		}
+	}
+	if rec := get("/docs/redoc"); !strings.Contains(rec.Body.String(), `<redoc spec-url="/docs/specs/notes.yaml">`) {
+		t.Fatalf("expected Redoc to show the notes spec:\n%s", rec.Body.String())
+	}
+	if rec := get("/docs/specs/notes.yaml"); rec.Code != 200 || !strings.Contains(rec.Body.String(), "Note:") {
+		t.Fatalf("expected the spec, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/docs/specs/cloudpact.yaml"); rec.Code != 404 {
+		t.Fatalf("expected only specs to be served, got %d", rec.Code)
+	}
+
+	server.setResult(fmt.Errorf("broken"))
+	if rec := get("/docs/"); rec.Code != 500 || !strings.Contains(rec.Body.String(), "cloudpact-error-overlay") {
+		t.Fatalf("expected the error overlay while the build fails, got %d", rec.Code)
+	}
+}
+
+func TestImportOpenAPI(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("json_names: as-written\n"), 0644)
+	os.WriteFile("orders.yaml", []byte(`openapi: 3.1.0
+info: {title: Orders, version: "1"}
+paths:
+  /orders/{id}:
+    get:
+      operationId: getOrder
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: string}}
+      responses:
+        "200":
+          description: ok
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Order"}
+components:
+  schemas:
+    Order:
+      type: object
+      required: [id, total]
+      properties:
+        id: {type: string}
+        total: {type: number}
+`), 0644)
+
+	output, err := ImportOpenAPI("orders.yaml", "", "Shop")
+	if err != nil {
+		t.Fatalf("ImportOpenAPI error: %v", err)
+	}
+	if output != "orders.cp" {
+		t.Fatalf("expected orders.cp, got %s", output)
+	}
+	data, _ := os.ReadFile(output)
+	for _, want := range []string{"module Shop\n", "define record Order\n", "function getOrder(id: text) returns Order\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in %s:\n%s", want, output, data)
+		}
+	}
+	if _, err := GenerateJSONSchema(""); err != nil {
+		t.Fatalf("imported file should build: %v", err)
+	}
+
+	if _, err := ImportOpenAPI("orders.yaml", "", "Shop"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected an error for an existing file, got %v", err)
+	}
+}
+
+func TestImportGo(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("billing", 0755)
+	os.WriteFile("billing/invoice.go", []byte("package billing\n\n// Invoice is a bill sent to a customer\ntype Invoice struct {\n\tID    string  `json:\"id\"`\n\tEmail string  `json:\"email\"`\n\tTotal float64 `json:\"total\"`\n}\n"), 0644)
+	os.WriteFile("billing/invoice_test.go", []byte("package billing\n\ntype Fixture struct{ Name string }\n"), 0644)
+
+	output, err := ImportGo("billing", "", "")
+	if err != nil {
+		t.Fatalf("ImportGo error: %v", err)
+	}
+	if output != "billing.cp" {
+		t.Fatalf("expected billing.cp, got %s", output)
+	}
+	data, _ := os.ReadFile(output)
+	for _, want := range []string{"module Billing\n", "// A bill sent to a customer\ndefine record Invoice\n", "    email: email\n", "    total: number\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in %s:\n%s", want, output, data)
+		}
+	}
+	if strings.Contains(string(data), "Fixture") {
+		t.Errorf("test files should be skipped:\n%s", data)
+	}
+
+	if _, err := ImportGo("billing", "", ""); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected an error for an existing file, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/tsgen"
+)
+
+// GenerateQueues writes queues.go, the producers and consumers of the
+// queues a Go package's files declare, into each package under the
+// configured go directory, or outDir when given, and queues.ts, naming
+// every queue and its payload, into the ts directory. It returns the paths
+// written.
+func GenerateQueues(outDir string) ([]string, error) {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := outputDirOr(outDir, "go")
+	if err != nil {
+		return nil, err
+	}
+	tsDir := opts.outputDir("ts")
+	tsPath := filepath.Join(tsDir, "queues.ts")
+
+	// Files of one module share a package, and so its Queue
+	var pkgDirs []string
+	files := make(map[string][]*grammar.File)
+	var tsFiles []*grammar.File
+	var tsImports []string
+	declared := make(map[string]string) // queue name to its file
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		if len(file.Queues) == 0 {
+			continue
+		}
+		for _, queue := range file.Queues {
+			if other, ok := declared[queue.Name]; ok {
+				return nil, fmt.Errorf("queue %s is declared in both %s and %s", queue.Name, other, source)
+			}
+			declared[queue.Name] = source
+		}
+		file.Localize(opts.Locale)
+		file.NameJSON(opts.JSONNames)
+
+		pkgDir := dir
+		if file.Module != nil {
+			pkgDir = filepath.Join(dir, gogen.PackageName(file))
+		}
+		if _, ok := files[pkgDir]; !ok {
+			pkgDirs = append(pkgDirs, pkgDir)
+		}
+		files[pkgDir] = append(files[pkgDir], file)
+
+		// queues.ts imports the payloads from where the ts target puts them
+		typesImport, err := importPath(tsPath, findTarget("ts").outputPath(source, file, opts))
+		if err != nil {
+			return nil, err
+		}
+		tsFiles = append(tsFiles, file)
+		tsImports = append(tsImports, typesImport)
+	}
+	if len(tsFiles) == 0 {
+		return nil, fmt.Errorf("no queues declared; declare them with queue <name> handles <Record>")
+	}
+
+	header := fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)
+	var outputs []string
+	for _, pkgDir := range pkgDirs {
+		code, err := gogen.GenerateQueues(files[pkgDir], gogen.Options{Header: header})
+		if err := os.MkdirAll(pkgDir, 0755); err != nil {
+			return nil, err
+		}
+		outputPath := filepath.Join(pkgDir, "queues.go")
+		if err != nil {
+			return nil, writeInvalidGo(outputPath, code, err)
+		}
+		if err := os.WriteFile(outputPath, code, 0644); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, outputPath)
+	}
+
+	code, err := tsgen.GenerateQueues(tsFiles, tsImports, tsgen.Options{Header: header})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tsDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(tsPath, code, 0644); err != nil {
+		return nil, err
+	}
+	return append(outputs, tsPath), nil
+}
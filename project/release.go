@@ -0,0 +1,174 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// changelogPath is regenerated from the git history on every release
+const changelogPath = "CHANGELOG.md"
+
+var releaseVersion = regexp.MustCompile(`^v\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?$`)
+
+// Release cuts version, such as v1.2.0. It checks that the working tree is
+// clean and that building twice gives identical output, stamps the version
+// into cloudpact.yaml (and so into the OpenAPI info), regenerates
+// CHANGELOG.md, commits both, tags the commit and packages the SDKs with
+// the version. It returns the files written.
+func Release(version string) ([]string, error) {
+	if !releaseVersion.MatchString(version) {
+		return nil, fmt.Errorf("invalid release version %q (expected vMAJOR.MINOR.PATCH)", version)
+	}
+	if out, err := git("tag", "--list", version); err != nil {
+		return nil, err
+	} else if out != "" {
+		return nil, fmt.Errorf("tag %s already exists", version)
+	}
+	if status, err := git("status", "--porcelain"); err != nil {
+		return nil, err
+	} else if status != "" {
+		return nil, fmt.Errorf("working tree has uncommitted changes:\n%s", status)
+	}
+
+	fmt.Println("Verifying the build is deterministic...")
+	if err := verifyDeterministicBuild(); err != nil {
+		return nil, err
+	}
+
+	config, err := os.ReadFile("cloudpact.yaml")
+	if err != nil {
+		return nil, err
+	}
+	number := strings.TrimPrefix(version, "v")
+	if err := os.WriteFile("cloudpact.yaml", []byte(stampVersion(string(config), number)), 0644); err != nil {
+		return nil, err
+	}
+
+	changelog, err := generateChangelog(version, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(changelogPath, []byte(changelog), 0644); err != nil {
+		return nil, err
+	}
+
+	if _, err := git("add", "cloudpact.yaml", changelogPath); err != nil {
+		return nil, err
+	}
+	if _, err := git("commit", "-m", "Release "+version); err != nil {
+		return nil, err
+	}
+	if _, err := git("tag", "-a", version, "-m", "Release "+version); err != nil {
+		return nil, err
+	}
+
+	outputs, err := Package(version)
+	if err != nil {
+		return nil, fmt.Errorf("tagged %s but packaging failed: %w", version, err)
+	}
+	return append([]string{"cloudpact.yaml", changelogPath}, outputs...), nil
+}
+
+// verifyDeterministicBuild builds the project twice from scratch and
+// compares the hashes of everything generated
+func verifyDeterministicBuild() error {
+	var builds [2]map[string]string
+	for i := range builds {
+		if err := os.Remove(buildCachePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := Build(); err != nil {
+			return err
+		}
+		manifest, err := LoadManifest()
+		if err != nil {
+			return err
+		}
+		builds[i] = manifest.hashes()
+	}
+	for path, hash := range builds[0] {
+		if builds[1][path] != hash {
+			return fmt.Errorf("build is not deterministic: %s differs between two builds", path)
+		}
+	}
+	if len(builds[0]) != len(builds[1]) {
+		return fmt.Errorf("build is not deterministic: the two builds wrote different files")
+	}
+	return nil
+}
+
+var (
+	topLevelVersion = regexp.MustCompile(`(?m)^version:.*$`)
+	apiVersion      = regexp.MustCompile(`(?m)^(api:\s*\n(?:[ \t]+.*\n|\s*\n)*?[ \t]+)version:.*$`)
+)
+
+// stampVersion sets the project version and the api version in the text
+// of cloudpact.yaml, keeping comments and layout
+func stampVersion(config, version string) string {
+	if topLevelVersion.MatchString(config) {
+		config = topLevelVersion.ReplaceAllLiteralString(config, "version: "+version)
+	} else {
+		config = "version: " + version + "\n" + config
+	}
+	if apiVersion.MatchString(config) {
+		config = apiVersion.ReplaceAllString(config, "${1}version: "+version)
+	}
+	return config
+}
+
+// generateChangelog lists the commit subjects of every release, newest
+// first, with the commits since the last tag under version
+func generateChangelog(version string, date time.Time) (string, error) {
+	tags, err := git("tag", "--list", "v*", "--sort=-v:refname")
+	if err != nil {
+		return "", err
+	}
+	releases := []string{version}
+	if tags != "" {
+		releases = append(releases, strings.Split(tags, "\n")...)
+	}
+
+	var b strings.Builder
+	b.WriteString("# Changelog\n")
+	for i, release := range releases {
+		head, day := release, ""
+		if i == 0 {
+			head, day = "HEAD", date.Format("2006-01-02")
+		} else if day, err = git("log", "-1", "--format=%cs", release); err != nil {
+			return "", err
+		}
+		span := head
+		if i+1 < len(releases) {
+			span = releases[i+1] + ".." + head
+		}
+		subjects, err := git("log", "--no-merges", "--format=%s", span)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&b, "\n## %s - %s\n\n", release, day)
+		if subjects == "" {
+			b.WriteString("- No changes\n")
+			continue
+		}
+		for _, subject := range strings.Split(subjects, "\n") {
+			if !strings.HasPrefix(subject, "Release v") {
+				fmt.Fprintf(&b, "- %s\n", subject)
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// git runs a git command in the project and returns its trimmed output
+func git(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
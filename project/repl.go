@@ -0,0 +1,203 @@
+package project
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/interp"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+const replHelp = `Enter an expression to see its value, or a statement to run it:
+    discount(total)
+    count of orders where total > 100
+    set limit = 50
+    if limit > 10 then fail "limit too high"
+Each record is bound to a sample value named after it, e.g. user for User.
+End a line with Tab to list completions for its last word.
+    :vars    list variables
+    :reset   restore the sample values
+    :help    show this help
+    :quit    leave`
+
+// repl is an interactive session over the project's declarations
+type repl struct {
+	in      *interp.Interpreter
+	env     interp.Env
+	symbols []string // functions, records, fields and keywords, for completion
+}
+
+// REPL loads the project's .cp files and evaluates lines read from input,
+// printing results to output, until input ends or the user quits
+func REPL(input io.Reader, output io.Writer) error {
+	paths, err := FindCloudPactFiles(".")
+	if err != nil {
+		return err
+	}
+	var files []*grammar.File
+	for _, path := range paths {
+		file, err := ParseCloudPactFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if err := analyzer.Check(file); err != nil {
+			return fmt.Errorf("failed to check %s: %w", path, err)
+		}
+		files = append(files, file)
+	}
+
+	r := newREPL(files...)
+	fmt.Fprintf(output, "CloudPact REPL: %d functions and %d records loaded. Type :help for help.\n", len(r.in.Functions), len(r.in.Records))
+	return r.run(input, output)
+}
+
+func newREPL(files ...*grammar.File) *repl {
+	r := &repl{in: interp.New(files...)}
+	r.reset()
+
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			r.symbols = append(r.symbols, name)
+		}
+	}
+	for _, keyword := range []string{"if", "then", "else", "set", "create", "with", "fail", "return",
+		"where", "select", "count", "sum", "average", "of", "or", "contains", "in", "not", "true", "false", "none", "length", "first", "last"} {
+		add(keyword)
+	}
+	for name, function := range r.in.Functions {
+		add(name)
+		for _, param := range function.Parameters {
+			add(param.Name)
+		}
+	}
+	for name, record := range r.in.Records {
+		add(name)
+		for _, field := range record.AllFields() {
+			add(field.Name)
+		}
+	}
+	sort.Strings(r.symbols)
+	return r
+}
+
+// reset binds every record to a sample value, user for User
+func (r *repl) reset() {
+	r.env = r.in.Samples()
+}
+
+func (r *repl) run(input io.Reader, output io.Writer) error {
+	lines := bufio.NewScanner(input)
+	for {
+		fmt.Fprint(output, "cp> ")
+		if !lines.Scan() {
+			fmt.Fprintln(output)
+			return lines.Err()
+		}
+		line := lines.Text()
+		if strings.HasSuffix(line, "\t") {
+			fmt.Fprintln(output, strings.Join(r.complete(strings.TrimRight(line, "\t")), "  "))
+			continue
+		}
+		if quit := r.eval(strings.TrimSpace(line), output); quit {
+			return nil
+		}
+	}
+}
+
+// eval handles one line, reporting whether the session should end
+func (r *repl) eval(line string, output io.Writer) bool {
+	switch line {
+	case "":
+		return false
+	case ":quit", ":q", "exit":
+		return true
+	case ":help":
+		fmt.Fprintln(output, replHelp)
+		return false
+	case ":reset":
+		r.reset()
+		fmt.Fprintln(output, "sample values restored")
+		return false
+	case ":vars":
+		for _, name := range sortedVariables(r.env) {
+			fmt.Fprintf(output, "%s = %s\n", name, interp.Format(r.env[name]))
+		}
+		return false
+	}
+
+	var value interface{}
+	var err error
+	show := true
+	if grammar.IsStatement(line) {
+		var stmt grammar.Statement
+		if stmt, err = grammar.ParseStatement(line); err == nil {
+			value, show, err = r.in.Exec(stmt, r.env)
+		}
+	} else {
+		var expr grammar.Expression
+		if expr, err = grammar.ParseExpression(line); err == nil {
+			value, err = r.in.Eval(expr, r.env)
+		}
+	}
+
+	var failure *interp.Failure
+	switch {
+	case errors.As(err, &failure):
+		fmt.Fprintf(output, "fail: %s\n", failure.Message)
+	case err != nil:
+		fmt.Fprintf(output, "error: %v\n", err)
+	case show:
+		fmt.Fprintln(output, interp.Format(value))
+	}
+	return false
+}
+
+// complete lists the symbols and variables the last word of line could
+// become. After a dot it offers the fields of the value before it.
+func (r *repl) complete(line string) []string {
+	word := line
+	if i := strings.LastIndexAny(line, " \t(,"); i >= 0 {
+		word = line[i+1:]
+	}
+
+	candidates := r.symbols
+	prefix := ""
+	if i := strings.LastIndex(word, "."); i >= 0 {
+		prefix, word = word[:i+1], word[i+1:]
+		candidates = nil
+		if expr, err := grammar.ParseExpression(strings.TrimSuffix(strings.TrimSuffix(prefix, "."), "?")); err == nil {
+			if fields, ok := r.evalQuietly(expr).(map[string]interface{}); ok {
+				for name := range fields {
+					candidates = append(candidates, name)
+				}
+			}
+		}
+	} else {
+		for name := range r.env {
+			candidates = append(candidates, name)
+		}
+	}
+
+	var matches []string
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) && !seen[candidate] {
+			seen[candidate] = true
+			matches = append(matches, prefix+candidate)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func (r *repl) evalQuietly(expr grammar.Expression) interface{} {
+	value, _ := r.in.Eval(expr, r.env)
+	return value
+}
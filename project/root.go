@@ -0,0 +1,67 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoProject is returned by FindRoot when no directory holds cloudpact.yaml
+var ErrNoProject = errors.New("not in a CloudPact project (no cloudpact.yaml found)")
+
+// FindRoot returns the nearest directory at or above dir that holds
+// cloudpact.yaml
+func FindRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "cloudpact.yaml")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ErrNoProject
+		}
+		dir = parent
+	}
+}
+
+// EnterRoot makes the project root the working directory, so every path
+// the project package reads or writes resolves against it. The root is
+// found above project, which must exist, or above the working directory
+// when project is empty; outside a project the working directory stays as
+// it is. It returns the former working directory relative to the root, for
+// resolving paths given on the command line.
+func EnterRoot(project string) (string, error) {
+	start := project
+	if start == "" {
+		start = "."
+	} else if info, err := os.Stat(project); err != nil {
+		return "", err
+	} else if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", project)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	root, err := FindRoot(start)
+	if errors.Is(err, ErrNoProject) {
+		if project == "" {
+			return ".", nil
+		}
+		// An explicit project directory is its own root
+		root, err = filepath.Abs(project)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chdir(root); err != nil {
+		return "", err
+	}
+	return filepath.Rel(root, cwd)
+}
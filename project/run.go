@@ -0,0 +1,270 @@
+package project
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/interp"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+const debugHelp = `    c, continue      run to the next breakpoint
+    s, step          run one statement, entering calls
+    n, next          run one statement, stepping over calls
+    o, out           run until the current function returns
+    b, break LINE    set a breakpoint; clear LINE removes it
+    p, print EXPR    evaluate an expression in the current frame
+    v, vars          list the variables of the current frame
+    bt, stack        list the active calls
+    q, quit          end the run`
+
+// RunOptions control RunFunction
+type RunOptions struct {
+	// Args set parameters by name, in place of sample values. A dotted
+	// name such as user.email sets one field of a record parameter.
+	Args map[string]string
+	// Debug stops before the first statement and reads debugger commands
+	// from Input
+	Debug       bool
+	Breakpoints []int // lines of the file to stop at
+	// Trace writes a decision log of the run to Output
+	Trace  bool
+	Input  io.Reader
+	Output io.Writer
+}
+
+// RunFunction runs a function of the .cp file at path with the
+// interpreter, passing opts.Args or else sample values for its parameters,
+// and returns its result. A fail statement is returned as an
+// *interp.Failure.
+func RunFunction(path, function string, opts RunOptions) (interface{}, error) {
+	file, err := ParseCloudPactFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		return nil, fmt.Errorf("failed to check %s: %w", path, err)
+	}
+	in := interp.New(file)
+	fn, ok := in.Functions[function]
+	if !ok {
+		return nil, fmt.Errorf("%s has no function %s", path, function)
+	}
+
+	args, err := runArgs(in, fn, opts.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Output == nil {
+		opts.Output = os.Stdout
+	}
+	if opts.Debug || len(opts.Breakpoints) > 0 {
+		if opts.Input == nil {
+			opts.Input = os.Stdin
+		}
+		session := &debugSession{
+			in:     in,
+			lines:  bufio.NewScanner(opts.Input),
+			output: opts.Output,
+			source: sourceLines(path),
+		}
+		in.Debugger = interp.NewDebugger(session.pause)
+		in.Debugger.StopOnEntry = opts.Debug
+		in.Debugger.SetBreakpoints("", opts.Breakpoints)
+		session.debugger = in.Debugger
+	}
+	if opts.Trace {
+		in.Trace = &interp.Trace{}
+	}
+
+	result, err := in.Call(function, args)
+	if opts.Trace {
+		fmt.Fprint(opts.Output, in.Trace)
+	}
+	return result, err
+}
+
+// runArgs builds the arguments of a call from name=value settings, using
+// sample values for whatever they leave out
+func runArgs(in *interp.Interpreter, fn *grammar.Function, settings map[string]string) ([]interface{}, error) {
+	args := make([]interface{}, len(fn.Parameters))
+	index := make(map[string]int)
+	for i, param := range fn.Parameters {
+		args[i] = in.Sample(param.Type)
+		index[param.Name] = i
+	}
+
+	// Whole parameters go first, so the fields set on them are kept
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := strings.Count(names[i], "."), strings.Count(names[j], ".")
+		return di < dj || di == dj && names[i] < names[j]
+	})
+
+	for _, name := range names {
+		path := strings.Split(name, ".")
+		i, ok := index[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("%s has no parameter %s", fn.Name, path[0])
+		}
+		if len(path) == 1 {
+			value, err := in.ParseValue(fn.Parameters[i].Type, settings[name])
+			if err != nil {
+				return nil, fmt.Errorf("--arg %s: %w", name, err)
+			}
+			args[i] = value
+			continue
+		}
+		if err := setField(in, args[i], fn.Parameters[i].Type, path, settings[name]); err != nil {
+			return nil, fmt.Errorf("--arg %s: %w", name, err)
+		}
+	}
+	return args, nil
+}
+
+// setField parses text into the field of value, a record of type t, that
+// path names after its first element
+func setField(in *interp.Interpreter, value interface{}, t *grammar.Type, path []string, text string) error {
+	for depth := 1; depth < len(path); depth++ {
+		fields, isRecord := value.(map[string]interface{})
+		record, declared := in.Records[t.Name]
+		if !isRecord || !declared {
+			return fmt.Errorf("%s is not a record", strings.Join(path[:depth], "."))
+		}
+		var field *grammar.FieldDef
+		for _, f := range record.AllFields() {
+			if f.Name == path[depth] {
+				field = f
+			}
+		}
+		if field == nil {
+			return fmt.Errorf("record %s has no field %s", record.Name, path[depth])
+		}
+		if depth == len(path)-1 {
+			v, err := in.ParseValue(field.Type, text)
+			if err != nil {
+				return err
+			}
+			fields[field.Name] = v
+			return nil
+		}
+		value, t = fields[field.Name], field.Type
+	}
+	return nil
+}
+
+func sourceLines(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(string(data), "\n")
+}
+
+// debugSession is the terminal front end of the debugger
+type debugSession struct {
+	in       *interp.Interpreter
+	debugger *interp.Debugger
+	lines    *bufio.Scanner
+	output   io.Writer
+	source   []string
+}
+
+// pause shows where the run stopped and reads commands until one resumes it
+func (d *debugSession) pause(stop *interp.Stop) interp.Action {
+	frame := stop.Stack[0]
+	line := 0
+	if frame.Position != nil {
+		line = frame.Position.Line
+	}
+	fmt.Fprintf(d.output, "stopped at line %d in %s (%s)\n", line, frame.Function, stop.Reason)
+	if line >= 1 && line <= len(d.source) {
+		fmt.Fprintf(d.output, "%4d  %s\n", line, strings.TrimSpace(d.source[line-1]))
+	}
+
+	for {
+		fmt.Fprint(d.output, "(debug) ")
+		if !d.lines.Scan() {
+			fmt.Fprintln(d.output)
+			return interp.Terminate
+		}
+		command, arg, _ := strings.Cut(strings.TrimSpace(d.lines.Text()), " ")
+		arg = strings.TrimSpace(arg)
+		switch command {
+		case "c", "continue":
+			return interp.Continue
+		case "s", "step":
+			return interp.StepIn
+		case "n", "next":
+			return interp.StepOver
+		case "o", "out":
+			return interp.StepOut
+		case "q", "quit":
+			return interp.Terminate
+		case "b", "break", "clear":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				fmt.Fprintf(d.output, "expected a line number, got %q\n", arg)
+				continue
+			}
+			lines := d.debugger.Breakpoints("")
+			if command == "clear" {
+				kept := lines[:0]
+				for _, l := range lines {
+					if l != n {
+						kept = append(kept, l)
+					}
+				}
+				lines = kept
+			} else {
+				lines = append(lines, n)
+			}
+			d.debugger.SetBreakpoints("", lines)
+		case "p", "print":
+			expr, err := grammar.ParseExpression(arg)
+			if err != nil {
+				fmt.Fprintf(d.output, "error: %v\n", err)
+				continue
+			}
+			value, err := d.in.Inspect(expr, frame.Variables)
+			if err != nil {
+				fmt.Fprintf(d.output, "error: %v\n", err)
+				continue
+			}
+			fmt.Fprintln(d.output, interp.Format(value))
+		case "v", "vars":
+			for _, name := range sortedVariables(frame.Variables) {
+				fmt.Fprintf(d.output, "%s = %s\n", name, interp.Format(frame.Variables[name]))
+			}
+		case "bt", "stack":
+			for _, f := range stop.Stack {
+				if f.Position != nil {
+					fmt.Fprintf(d.output, "%s at line %d\n", f.Function, f.Position.Line)
+				}
+			}
+		case "", "h", "help":
+			fmt.Fprintln(d.output, debugHelp)
+		default:
+			fmt.Fprintf(d.output, "unknown command %q; type help for the list\n", command)
+		}
+	}
+}
+
+func sortedVariables(env interp.Env) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
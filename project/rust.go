@@ -0,0 +1,101 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/rustgen"
+)
+
+// GenerateRust writes a Rust module of serde structs for each project file
+// declaring records or models to <outDir>/<file>.rs, and mod.rs declaring
+// them, and returns the paths written. An empty outDir means the configured
+// rust directory, generated/rust by default.
+func GenerateRust(outDir string) ([]string, error) {
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := outputDirOr(outDir, "rust")
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs, modules []string
+	moduleSources := make(map[string]string) // Rust module to the file it is generated from
+	declared := make(map[string]string)      // struct name to the file declaring it
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		if len(file.Records) == 0 && len(file.Models) == 0 {
+			continue
+		}
+		file.NameJSON(opts.JSONNames)
+
+		// mod.rs re-exports every struct, so names must be unique
+		var names []string
+		for _, record := range file.Records {
+			names = append(names, record.Name)
+		}
+		for _, model := range file.Models {
+			names = append(names, model.Name)
+		}
+		for _, name := range names {
+			if other, ok := declared[name]; ok {
+				return nil, fmt.Errorf("%s is declared in both %s and %s", name, other, source)
+			}
+			declared[name] = source
+		}
+		module := rustgen.ModuleName(source)
+		if other, ok := moduleSources[module]; ok {
+			return nil, fmt.Errorf("%s and %s would both be the Rust module %s", other, source, module)
+		}
+		moduleSources[module] = source
+		modules = append(modules, module)
+
+		code, err := rustgen.GenerateFile(file, rustgen.Options{Header: codegen.Header(source, content)})
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		outputPath := filepath.Join(dir, module+".rs")
+		if err := os.WriteFile(outputPath, code, 0644); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, outputPath)
+	}
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("no records or models to generate Rust for")
+	}
+
+	header := fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)
+	modPath := filepath.Join(dir, "mod.rs")
+	if err := os.WriteFile(modPath, rustgen.GenerateMod(modules, header), 0644); err != nil {
+		return nil, err
+	}
+	return append(outputs, modPath), nil
+}
@@ -0,0 +1,208 @@
+package project
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+)
+
+// templates holds one directory per project template. Files are written
+// under the same relative path, in templates given by --from too, except that gitignore becomes .gitignore
+// and a .tmpl suffix, which keeps go.mod files out of this module, is
+// dropped.
+//
+//go:embed templates
+var templates embed.FS
+
+// Templates are the layouts init can scaffold
+var Templates = []string{"minimal", "api", "fullstack"}
+
+// DefaultTemplate is the layout init scaffolds unless told otherwise
+const DefaultTemplate = "fullstack"
+
+// InitOptions picks what Init scaffolds
+type InitOptions struct {
+	// Template is one of Templates; empty means DefaultTemplate
+	Template string
+	// Module is the Go module path; empty means the lowercased project name
+	Module string
+	// Author is credited in the scaffold; empty means git's user.name
+	Author string
+	// From is a local directory or a git repository, such as
+	// github.com/acme/cp-template, to use as the template instead
+	From string
+}
+
+// fetchTemplate clones the git repository source into dir
+var fetchTemplate = func(source, dir string) error {
+	url := source
+	if !strings.Contains(source, "://") && !strings.HasPrefix(source, "git@") {
+		url = "https://" + source
+	}
+	_, err := git("clone", "--quiet", "--depth", "1", "--", url, dir)
+	return err
+}
+
+// TemplateData is what scaffolded files can use, as in
+// {{if .Author}}Maintained by {{.Author}}{{end}}
+type TemplateData struct {
+	ProjectName string // the name given to init, e.g. "shop"
+	ModuleName  string // the project name as a CloudPact module name
+	GoModule    string // the Go module path
+	Year        int
+	Author      string
+	Template    string // the built-in template, or empty for --from
+}
+
+var notIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Init creates a new CloudPact project called name from a template, and
+// checks that the .cp files of a template given by From parse
+func Init(name string, opts InitOptions) error {
+	source, cleanup, err := templateSource(opts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	projectName := filepath.Base(name)
+	data := TemplateData{
+		ProjectName: projectName,
+		ModuleName:  notIdentifier.ReplaceAllString(strings.ToLower(projectName), ""),
+		GoModule:    opts.Module,
+		Year:        time.Now().Year(),
+		Author:      opts.Author,
+	}
+	if data.GoModule == "" {
+		data.GoModule = strings.ToLower(projectName)
+	}
+	if data.Author == "" {
+		data.Author, _ = git("config", "user.name")
+	}
+	if opts.From == "" {
+		data.Template = opts.Template
+		if data.Template == "" {
+			data.Template = DefaultTemplate
+		}
+	}
+
+	if err := os.MkdirAll(name, 0755); err != nil {
+		return fmt.Errorf("failed to create project directory: %w", err)
+	}
+	var written []string
+	err = fs.WalkDir(source, ".", func(templatePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		filePath := scaffoldPath(templatePath)
+		if err := writeTemplateFile(source, name, filePath, templatePath, data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filePath, err)
+		}
+		written = append(written, filePath)
+		return nil
+	})
+	if err != nil || opts.From == "" {
+		return err
+	}
+
+	var problems []string
+	for _, filePath := range written {
+		if strings.HasSuffix(filePath, ".cp") {
+			if _, err := ParseCloudPactFile(filepath.Join(name, filePath)); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", filePath, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("template %s does not parse:\n%s", opts.From, strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// templateSource opens the template opts picks: an embedded one, a local
+// directory or a clone of a repository, removed by cleanup
+func templateSource(opts InitOptions) (fs.FS, func(), error) {
+	cleanup := func() {}
+	if opts.From == "" {
+		if opts.Template == "" {
+			opts.Template = DefaultTemplate
+		}
+		if !slices.Contains(Templates, opts.Template) {
+			return nil, cleanup, fmt.Errorf("unknown template %q (available: %s)", opts.Template, strings.Join(Templates, ", "))
+		}
+		source, err := fs.Sub(templates, path.Join("templates", opts.Template))
+		return source, cleanup, err
+	}
+	if opts.Template != "" {
+		return nil, cleanup, fmt.Errorf("give either a template or --from, not both")
+	}
+	if info, err := os.Stat(opts.From); err == nil {
+		if !info.IsDir() {
+			return nil, cleanup, fmt.Errorf("template %s is not a directory", opts.From)
+		}
+		return os.DirFS(opts.From), cleanup, nil
+	}
+
+	// git would read a repository starting with - as an option
+	if strings.HasPrefix(opts.From, "-") {
+		return nil, cleanup, fmt.Errorf("template %s is not a directory or a git repository", opts.From)
+	}
+	dir, err := os.MkdirTemp("", "cloudpact-template-")
+	if err != nil {
+		return nil, cleanup, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+	if err := fetchTemplate(opts.From, dir); err != nil {
+		cleanup()
+		return nil, func() {}, fmt.Errorf("failed to fetch template %s: %w", opts.From, err)
+	}
+	return os.DirFS(dir), cleanup, nil
+}
+
+// scaffoldPath is where the template file at rel is written in a project
+func scaffoldPath(rel string) string {
+	dir, file := path.Split(rel)
+	if file == "gitignore" {
+		file = ".gitignore"
+	}
+	return filepath.FromSlash(dir + strings.TrimSuffix(file, ".tmpl"))
+}
+
+// writeTemplateFile executes the template file at templatePath with data,
+// using the functions of codegen templates, and writes it to filePath
+func writeTemplateFile(source fs.FS, projectDir, filePath, templatePath string, data TemplateData) error {
+	content, err := fs.ReadFile(source, templatePath)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(templatePath).Funcs(codegen.Funcs).Parse(string(content))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	fullPath := filepath.Join(projectDir, filePath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(fullPath, buf.Bytes(), 0644)
+}
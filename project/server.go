@@ -0,0 +1,146 @@
+package project
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/config"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// GenerateServer writes <outDir>/main.go, a server importing the Go
+// package of every module and routing each function to its generated
+// handler on the router server.framework in cloudpact.yaml names. An
+// empty outDir means cmd/server under the configured go directory. Import
+// paths start with the module of the project's go.mod, or go_module in
+// cloudpact.yaml when there is none. Files without a module are in package
+// main and cannot be imported, so they are left out. It returns the path
+// written.
+func GenerateServer(outDir string) (string, error) {
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return "", err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return "", err
+	}
+	settings, err := config.Load(config.FileName)
+	if err != nil {
+		return "", err
+	}
+	goDir, err := OutputDir("go")
+	if err != nil {
+		return "", err
+	}
+	dir := outDir
+	if dir == "" {
+		dir = filepath.Join(goDir, "cmd", "server")
+	}
+	root, err := goImportRoot(goDir)
+	if err != nil {
+		return "", err
+	}
+
+	var packages []gogen.ServerPackage
+	byPath := make(map[string]int)
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return "", err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return "", fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		if file.Module == nil {
+			continue
+		}
+
+		importPath := path.Join(root, gogen.PackageName(file))
+		if i, ok := byPath[importPath]; ok {
+			packages[i].Files = append(packages[i].Files, file)
+			continue
+		}
+		byPath[importPath] = len(packages)
+		packages = append(packages, gogen.ServerPackage{Path: importPath, Files: []*grammar.File{file}})
+	}
+
+	header := fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)
+	code, err := gogen.GenerateServer(packages, gogen.Options{
+		Header:    header,
+		Framework: settings.Server.Framework,
+		Logging:   settings.Observability.Logging,
+		LogLevel:  settings.Observability.LogLevel,
+	})
+	if code == nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	outputPath := filepath.Join(dir, "main.go")
+	if err != nil {
+		return "", writeInvalidGo(outputPath, code, err)
+	}
+	return outputPath, os.WriteFile(outputPath, code, 0644)
+}
+
+// goImportRoot is the import path of goDir: the project's Go module path
+// followed by goDir relative to the project root
+func goImportRoot(goDir string) (string, error) {
+	module := goModFilePath("go.mod")
+	if module == "" {
+		settings, err := config.Load(config.FileName)
+		if err != nil {
+			return "", err
+		}
+		module = settings.GoModule
+	}
+	if module == "" {
+		return "", fmt.Errorf("add a go.mod or set go_module in cloudpact.yaml to import the generated packages")
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(goDir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(cwd, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("the go output %s is outside the project, so its packages have no import path", goDir)
+	}
+	return path.Join(module, filepath.ToSlash(rel)), nil
+}
+
+// goModFilePath reads the module path declared by the go.mod at name,
+// or returns "" when there is none
+func goModFilePath(name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,6 @@
+module {{.ModuleName}}
+
+define record User
+    name: text
+    email: email
+    age: int
@@ -1,13 +1,8 @@
-module {{.ProjectName}}Service
-
-define record User
-    name: text
-    email: text
-    age: number
+module {{.ModuleName}}Service
 
 function validateUser(user: User) returns boolean
     why: "Ensures user data meets basic requirements"
     do:
         if user.age < 18
             then return false
-        return true
\ No newline at end of file
+        return true
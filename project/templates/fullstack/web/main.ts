@@ -0,0 +1,7 @@
+// main.ts is the entry point of the web app. The types and validators
+// generated from models/ are in ../generated/ts after cloudpact start build.
+import type { User } from "../generated/ts/user";
+
+export function greet(user: User): string {
+    return `Welcome to {{.ProjectName}}, ${user.name}!`;
+}
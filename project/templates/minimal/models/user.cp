@@ -0,0 +1,5 @@
+module {{.ModuleName}}
+
+define record User
+    name: text
+    email: email
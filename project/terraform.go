@@ -0,0 +1,105 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/config"
+	"github.com/daveroberts0321/cloudpact/deploygen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/tfgen"
+)
+
+// GenerateTerraform writes a Terraform module for provider, where records
+// marked persist are kept, to outDir and returns the paths written: main.tf,
+// variables.tf, outputs.tf and versions.tf. aws, the only provider so far,
+// gives each a DynamoDB table or an S3 bucket. An empty outDir means the
+// configured terraform directory, generated/terraform by default.
+func GenerateTerraform(provider, outDir string) ([]string, error) {
+	if provider != "aws" {
+		return nil, fmt.Errorf("unknown cloud provider %q (expected aws)", provider)
+	}
+	settings, err := config.Load(config.FileName)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := loadCodegenOptions()
+	if err != nil {
+		return nil, err
+	}
+	cpFiles, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	types, err := projectTypes(cpFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*grammar.Record
+	resources := make(map[string]string) // resource name to the record kept in it
+	for _, source := range cpFiles {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", source, err)
+		}
+		file.NameJSON(opts.JSONNames)
+		for _, record := range file.Records {
+			if record.Persist == "" {
+				continue
+			}
+			name := tfgen.ResourceName(record)
+			if other, ok := resources[name]; ok {
+				return nil, fmt.Errorf("%s and %s would both be kept in %s", other, record.Name, name)
+			}
+			resources[name] = record.Name
+			records = append(records, record)
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no records marked persist to provision")
+	}
+
+	dir, err := outputDirOr(outDir, "terraform")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := settings.Name
+	if name == "" {
+		cwd, _ := os.Getwd()
+		name = filepath.Base(cwd)
+	}
+	tfOpts := tfgen.Options{Header: fmt.Sprintf("Code generated by cloudpact v%s; DO NOT EDIT.", codegen.Version)}
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"main.tf", tfgen.GenerateMain(records, tfOpts)},
+		{"variables.tf", tfgen.GenerateVariables(deploygen.ResourceName(name), records, tfOpts)},
+		{"outputs.tf", tfgen.GenerateOutputs(records, tfOpts)},
+		{"versions.tf", tfgen.GenerateVersions(tfOpts)},
+	}
+	var outputs []string
+	for _, file := range files {
+		outputPath := filepath.Join(dir, file.name)
+		if err := os.WriteFile(outputPath, file.content, 0644); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, outputPath)
+	}
+	return outputs, nil
+}
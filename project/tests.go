@@ -0,0 +1,63 @@
+package project
+
+import (
+	"fmt"
+
+	"github.com/daveroberts0321/cloudpact/interp"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// TestResult is the outcome of one test block
+type TestResult struct {
+	File string
+	Name string
+	Err  error // nil when the test passed
+}
+
+// RunTests runs the test blocks of the given .cp files, or of every .cp
+// file under the current directory when none are given. Tests may call
+// functions declared in any file of the project.
+func RunTests(sources []string) ([]TestResult, error) {
+	project, err := FindCloudPactFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		sources = project
+	}
+
+	parsed := make(map[string]*grammar.File)
+	var paths []string
+	var files []*grammar.File
+	for _, path := range append(append([]string{}, sources...), project...) {
+		if _, ok := parsed[path]; ok {
+			continue
+		}
+		file, err := ParseCloudPactFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		parsed[path] = file
+		paths = append(paths, path)
+		files = append(files, file)
+	}
+	types, err := analyzer.DeclaredTypes(files...)
+	if err != nil {
+		return nil, err
+	}
+	for i, file := range files {
+		if err := analyzer.CheckWith(file, types); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", paths[i], err)
+		}
+	}
+
+	in := interp.New(files...)
+	var results []TestResult
+	for _, path := range sources {
+		for _, test := range parsed[path].Tests {
+			results = append(results, TestResult{File: path, Name: test.Name, Err: in.RunTest(test)})
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,295 @@
+package project
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/gogen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// goToolLine matches a problem reported by go build or go vet, such as
+// users/users.go:21:2: undefined: total
+var goToolLine = regexp.MustCompile(`^(?:vet: )?(?:\./)?([^\s:]+\.go):(\d+):(\d+): (.+)$`)
+
+// verifyGo compiles and vets a copy of the generated Go tree and maps what
+// the go command reports back to the .cp statements or declarations the
+// code came from.
+// Compile errors are errors and vet findings warnings; vet runs only once
+// the tree compiles. The copy gets a go.mod of its own, so the project's
+// module does not matter.
+func verifyGo(ctx context.Context, c *buildCache, opts codegenOptions) ([]*grammar.Diagnostic, error) {
+	goDir := opts.outputDir("go")
+	if _, err := os.Stat(goDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		return nil, fmt.Errorf("verify_go needs the go command: %w", err)
+	}
+
+	tmp, err := os.MkdirTemp("", "cloudpact-verify-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyGoTree(goDir, tmp); err != nil {
+		return nil, err
+	}
+	module, err := goImportRoot(goDir)
+	if err != nil {
+		module = "generated"
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "go.mod"), []byte(fmt.Sprintf("module %s\n\ngo 1.22\n", module)), 0644); err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string]string)
+	var cpFiles []string
+	for source, entry := range c.Files {
+		cpFiles = append(cpFiles, source)
+		for output := range entry.Outputs {
+			sources[filepath.Clean(output)] = source
+		}
+	}
+	statements := goStatementPositions(cpFiles, opts)
+
+	for _, step := range []struct {
+		args     []string
+		code     string
+		severity grammar.Severity
+	}{
+		{[]string{"build", "./..."}, grammar.CodeGoBuild, grammar.SeverityError},
+		{[]string{"vet", "./..."}, grammar.CodeGoVet, grammar.SeverityWarning},
+	} {
+		cmd := exec.CommandContext(ctx, "go", step.args...)
+		cmd.Dir = tmp
+		cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+		output, runErr := cmd.CombinedOutput()
+		if runErr == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		var diagnostics []*grammar.Diagnostic
+		for _, line := range strings.Split(string(output), "\n") {
+			match := goToolLine.FindStringSubmatch(strings.TrimSpace(line))
+			if match == nil {
+				continue
+			}
+			generated := filepath.Join(goDir, filepath.FromSlash(match[1]))
+			lineNo, _ := strconv.Atoi(match[2])
+			pos := statements(generated, lineNo, sources[generated])
+			if pos == nil {
+				pos = goSourcePosition(generated, lineNo, sources[generated])
+			}
+			d := grammar.NewDiagnostic(step.code, pos,
+				"generated Go %s:%d: %s", filepath.ToSlash(generated), lineNo, match[4])
+			d.Severity = step.severity
+			diagnostics = append(diagnostics, d)
+		}
+		if len(diagnostics) == 0 {
+			return nil, fmt.Errorf("go %s failed on the generated Go: %v\n%s", step.args[0], runErr, output)
+		}
+		return diagnostics, nil
+	}
+	return nil, nil
+}
+
+// copyGoTree copies the .go files under dir into the same places under dst
+func copyGoTree(dir, dst string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) != ".go" {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
+
+// goStatementPositions returns a function mapping line of a generated Go
+// file to the position in source of the function body statement it was
+// generated from, or nil when it comes from no statement or the go target
+// did not write the file. The Go of each source is generated again, as the
+// build wrote it, to learn which statement each line comes from.
+func goStatementPositions(cpFiles []string, opts codegenOptions) func(generated string, line int, source string) *grammar.Position {
+	var types analyzer.Types
+	loaded := false
+	lines := make(map[string][]*grammar.Position)
+	goTarget := findTarget("go")
+	return func(generated string, line int, source string) *grammar.Position {
+		if source == "" {
+			return nil
+		}
+		if _, ok := lines[source]; !ok {
+			if !loaded {
+				types, _ = projectTypes(cpFiles)
+				loaded = true
+			}
+			lines[source] = nil
+			if file, content, err := checkedSource(source, opts, types); err == nil && goTarget.outputPath(source, file, opts) == generated {
+				ctx := OutputContext{TrackChanges: opts.TrackChanges, Tracing: opts.Tracing, Logging: opts.Logging, GoPackages: opts.goPackages, Header: codegen.Header(source, content)}
+				lines[source], _ = gogen.SourceLines(file, goOptions(ctx))
+			}
+		}
+		if line < 1 || line > len(lines[source]) || lines[source][line-1] == nil {
+			return nil
+		}
+		pos := *lines[source][line-1]
+		pos.File = source
+		return &pos
+	}
+}
+
+// goSourcePosition maps line of the generated Go file to the position in
+// source of the declaration it was generated from: the record, field or
+// function named by the Go declaration around the line, or the start of
+// source when none matches. It returns nil when there is no source, such
+// as for a hand-written file.
+func goSourcePosition(generated string, line int, source string) *grammar.Position {
+	if source == "" {
+		return nil
+	}
+	start := &grammar.Position{File: source, Line: 1, Column: 1}
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return start
+	}
+	file, err := grammar.ParseWithFilename(bytes.NewReader(content), source)
+	if err != nil {
+		return start
+	}
+	name, field := goDeclAt(generated, line)
+	if pos := goDeclSource(file, name, field); pos != nil {
+		return pos
+	}
+	return start
+}
+
+// goDeclAt names the top-level declaration of a Go file that spans line:
+// a function, a method's receiver type, a type, or a variable or constant.
+// When line is a field of a struct type, field names it.
+func goDeclAt(path string, line int) (name, field string) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return "", ""
+	}
+	spans := func(node ast.Node) bool {
+		return fset.Position(node.Pos()).Line <= line && line <= fset.Position(node.End()).Line
+	}
+	for _, decl := range f.Decls {
+		if !spans(decl) {
+			continue
+		}
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				typ := decl.Recv.List[0].Type
+				if star, ok := typ.(*ast.StarExpr); ok {
+					typ = star.X
+				}
+				if ident, ok := typ.(*ast.Ident); ok {
+					return ident.Name, ""
+				}
+			}
+			return decl.Name.Name, ""
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if !spans(spec) {
+					continue
+				}
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if st, ok := spec.Type.(*ast.StructType); ok {
+						for _, f := range st.Fields.List {
+							if spans(f) && len(f.Names) > 0 {
+								return spec.Name.Name, f.Names[0].Name
+							}
+						}
+					}
+					return spec.Name.Name, ""
+				case *ast.ValueSpec:
+					return spec.Names[0].Name, ""
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+// goDeclSource finds the declaration of file a Go declaration was generated
+// from. An exact name wins; otherwise the longest record or function name
+// within it, so NewOrder and OrderPatch map to Order and GreetHandler to
+// greet. A field of a record's struct maps to the field.
+func goDeclSource(file *grammar.File, name, field string) *grammar.Position {
+	if name == "" {
+		return nil
+	}
+	for _, record := range file.Records {
+		if record.Name != name || field == "" {
+			continue
+		}
+		for _, f := range record.Fields {
+			if gogen.FieldName(f.Name) == field && f.Position != nil {
+				return f.Position
+			}
+		}
+	}
+	type decl struct {
+		name string
+		pos  *grammar.Position
+	}
+	var decls []decl
+	for _, record := range file.Records {
+		decls = append(decls, decl{record.Name, record.Position})
+	}
+	for _, model := range file.Models {
+		decls = append(decls, decl{model.Name, model.Position})
+	}
+	for _, typeDef := range file.TypeDefs {
+		decls = append(decls, decl{typeDef.Name, typeDef.Position})
+	}
+	for _, function := range file.Functions {
+		decls = append(decls, decl{function.Name, function.Position})
+	}
+
+	var best decl
+	for _, d := range decls {
+		if d.pos == nil {
+			continue
+		}
+		if d.name == name {
+			return d.pos
+		}
+		if strings.Contains(strings.ToLower(name), strings.ToLower(d.name)) && len(d.name) > len(best.name) {
+			best = d
+		}
+	}
+	return best.pos
+}
@@ -0,0 +1,178 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/tsgen"
+)
+
+// zodGenerator emits zod validators mirroring the Go validate tags, so
+// frontends reject the same input the backend does
+type zodGenerator struct{}
+
+func (zodGenerator) Name() string { return "zod" }
+
+func (zodGenerator) Generate(file *grammar.File, ctx OutputContext) error {
+	return os.WriteFile(ctx.OutputPath, codegen.Stamp("//", ctx.Header, []byte(generateZodSchemas(file))), 0644)
+}
+
+// generateZodSchemas writes one <Name>Schema per record and model
+func generateZodSchemas(file *grammar.File) string {
+	var code strings.Builder
+	code.WriteString("// Generated zod schemas from CloudPact\n")
+	if file.Module != nil {
+		code.WriteString(fmt.Sprintf("// Module: %s\n", file.Module.Name))
+	}
+	code.WriteString("import { z } from 'zod';\n\n")
+
+	declared := make(map[string]bool)
+	for _, record := range file.Records {
+		declared[record.Name] = true
+	}
+	for _, model := range file.Models {
+		declared[model.Name] = true
+	}
+
+	// Inherited fields are repeated, since a base schema may be declared
+	// after the records extending it
+	for _, record := range file.Records {
+		var fields []string
+		for _, field := range record.AllFields() {
+			fields = append(fields, zodField(field.JSONKey(), field.Type, declared))
+		}
+		if record.IsVersioned() {
+			fields = append(fields, "  version: z.number().int(),")
+		}
+		writeZodObject(&code, record.Name, record.HasImplicitID(), fields)
+	}
+	for _, model := range file.Models {
+		implicitID := true
+		var fields []string
+		for _, field := range model.Fields {
+			fields = append(fields, zodField(field.JSONKey(), field.Type, declared))
+			if strings.EqualFold(field.Name, "id") {
+				implicitID = false
+			}
+		}
+		writeZodObject(&code, model.Name, implicitID, fields)
+	}
+
+	return code.String()
+}
+
+// writeZodObject emits the schema and its inferred type, starting with the
+// generated id when the record has one
+func writeZodObject(code *strings.Builder, name string, implicitID bool, fields []string) {
+	code.WriteString(fmt.Sprintf("export const %sSchema = z.object({\n", name))
+	if implicitID {
+		code.WriteString("  id: z.string().uuid(),\n")
+	}
+	for _, field := range fields {
+		code.WriteString(field + "\n")
+	}
+	code.WriteString("});\n")
+	code.WriteString(fmt.Sprintf("export type %sInput = z.infer<typeof %sSchema>;\n\n", name, name))
+}
+
+func zodField(name string, t *grammar.Type, declared map[string]bool) string {
+	schema := withZodConstraints(zodType(t, declared), t)
+	if t.Nullable {
+		schema += ".nullable()"
+	} else if t.Optional {
+		schema += ".optional()"
+	}
+	return fmt.Sprintf("  %s: %s,", name, schema)
+}
+
+// withZodConstraints adds a type's constraints to a zod schema like
+// the Go validate tags; zod's min and max also bound the length of strings
+func withZodConstraints(schema string, t *grammar.Type) string {
+	for _, name := range codegen.FieldConstraints {
+		value, ok := t.Constraints[name]
+		if !ok {
+			continue
+		}
+		check := fmt.Sprintf(".%s(", strings.TrimSuffix(name, "length"))
+		if name == grammar.ConstraintDomain {
+			schema += fmt.Sprintf(".endsWith(%q)", "@"+fmt.Sprint(value))
+			continue
+		}
+		if i := strings.Index(schema, check); i >= 0 {
+			end := i + strings.Index(schema[i:], ")") + 1
+			schema = schema[:i] + fmt.Sprintf("%s%v)", check, value) + schema[end:]
+		} else {
+			schema += fmt.Sprintf("%s%v)", check, value)
+		}
+	}
+	return schema
+}
+
+// zodNullable lets the schema of a list item or map value accept null when
+// its type is "maybe"
+func zodNullable(schema string, t *grammar.Type) string {
+	if t.Nullable {
+		return schema + ".nullable()"
+	}
+	return schema
+}
+
+// zodType maps a CloudPact type to a zod schema with the constraints of
+// codegen.ValidationTag. declared names the records with schemas in this file.
+func zodType(t *grammar.Type, declared map[string]bool) string {
+	if t.Name == "list" && t.Element != nil {
+		return fmt.Sprintf("z.array(%s)", zodNullable(zodType(t.Element, declared), t.Element))
+	}
+	if t.Name == "map" && t.Key != nil {
+		// JSON object keys are strings, whole numbers included
+		key := "z.string()"
+		if tsgen.FieldType(t.Key) == "number" {
+			key = `z.string().regex(/^-?\d+$/)`
+		}
+		return fmt.Sprintf("z.record(%s, %s)", key, zodNullable(zodType(t.Value, declared), t.Value))
+	}
+
+	switch strings.ToLower(t.Name) {
+	case "int", "integer":
+		return "z.number().int()"
+	case "float", "number":
+		return "z.number()"
+	case "bool", "boolean":
+		return "z.boolean()"
+	case "email":
+		return "z.string().email()"
+	case "url":
+		return "z.string().url()"
+	case "uuid":
+		return "z.string().uuid()"
+	case "phone":
+		return `z.string().regex(/^\+[1-9]\d{1,14}$/)` // E.164, like the e164 tag
+	case "zip_code":
+		return "z.string().length(5)"
+	case "country_code", "state_code":
+		return "z.string().length(2).regex(/^[A-Za-z]+$/)"
+	case "percentage":
+		return "z.number().min(0).max(100)"
+	case "usd_currency", "eur_currency":
+		return "z.number().min(0)"
+	case "password":
+		return "z.string().min(8)"
+	case "date":
+		return "z.string().date()"
+	case "datetime", "timestamp":
+		return "z.string().datetime()"
+	}
+
+	// Records may be declared after the one referring to them; those of
+	// other files have no schema here
+	if codegen.IsRecordTypeName(t.Name) {
+		if declared[t.Name] {
+			return fmt.Sprintf("z.lazy(() => %sSchema)", t.Name)
+		}
+		return "z.unknown()"
+	}
+	return "z.string()"
+}
@@ -0,0 +1,170 @@
+package pygen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// writeClient writes APIError and Client, whose methods call the file's
+// functions at POST /<name> and update its versioned records
+func writeClient(code *strings.Builder, file *grammar.File, declared map[string]bool) {
+	code.WriteString(pyClientSource)
+
+	for _, record := range file.Records {
+		if !record.Versioned {
+			continue
+		}
+		lower := strings.ToLower(record.Name)
+		method := snakeCase(record.Name)
+		code.WriteString(fmt.Sprintf("\n    def update_%s(self, id: str, change: Callable[[%s], %s], attempts: int = 3) -> %s:\n", method, record.Name, record.Name, record.Name))
+		code.WriteString(fmt.Sprintf("        \"\"\"Applies change to the latest %s, retrying when it was modified concurrently\"\"\"\n", lower))
+		code.WriteString(fmt.Sprintf("        data = self._update_with_retry(f\"/%ss/{id}\", lambda current: _encode(change(%s.model_validate(current))), attempts)\n", lower, record.Name))
+		code.WriteString(fmt.Sprintf("        return %s.model_validate(data)\n", record.Name))
+	}
+
+	for _, function := range file.Functions {
+		writeFunctionMethod(code, function, declared)
+	}
+}
+
+// writeFunctionMethod writes the client method calling function. Its
+// parameters are sent as the JSON body, and its request headers are
+// keyword-only arguments.
+func writeFunctionMethod(code *strings.Builder, function *grammar.Function, declared map[string]bool) {
+	params := []string{"self"}
+	var body []string
+	for _, param := range function.Parameters {
+		name := snakeCase(param.Name)
+		params = append(params, fmt.Sprintf("%s: %s", name, FieldType(param.Type, declared)))
+		body = append(body, fmt.Sprintf("%q: _encode(%s)", param.Name, name))
+	}
+	var headers []string
+	for i, header := range codegen.RequestHeaders(function) {
+		if i == 0 {
+			params = append(params, "*")
+		}
+		name := snakeCase(header.Variable())
+		if header.Direction == grammar.HeaderOptional {
+			params = append(params, name+": Optional[str] = None")
+		} else {
+			params = append(params, name+": str")
+		}
+		headers = append(headers, fmt.Sprintf("%q: %s", header.Name, name))
+	}
+	returns := "None"
+	if function.ReturnType != nil {
+		returns = FieldType(function.ReturnType, declared)
+	}
+
+	code.WriteString(fmt.Sprintf("\n    def %s(%s) -> %s:\n", snakeCase(function.Name), strings.Join(params, ", "), returns))
+	doc := codegen.DocLines(function.Leading, function.Trailing)
+	if function.Why != "" {
+		doc = append(doc, "Why: "+function.Why)
+	}
+	writeDocstring(code, doc, "        ")
+
+	call := fmt.Sprintf("self._post(\"/%s\", {%s}", strings.ToLower(function.Name), strings.Join(body, ", "))
+	if len(headers) > 0 {
+		call += fmt.Sprintf(", {%s}", strings.Join(headers, ", "))
+	}
+	call += ")"
+	if function.ReturnType == nil {
+		code.WriteString("        " + call + "\n")
+		return
+	}
+	code.WriteString(fmt.Sprintf("        response = %s\n", call))
+	code.WriteString(fmt.Sprintf("        return TypeAdapter(%s).validate_python(response.json())\n", returns))
+}
+
+// pyClientSource declares APIError, the JSON encoding of arguments and the
+// Client the function methods are added to
+const pyClientSource = `
+
+class APIError(Exception):
+    """An error response of the API: its HTTP status and the code and message
+    the function failed with
+    """
+
+    def __init__(self, status: int, code: str, message: str, details: Optional[Dict[str, Any]] = None, request_id: Optional[str] = None):
+        super().__init__(f"{status} {code}: {message}")
+        self.status = status
+        self.code = code
+        self.message = message
+        self.details = details or {}
+        self.request_id = request_id
+
+    @classmethod
+    def from_response(cls, response: requests.Response) -> APIError:
+        try:
+            body = response.json()
+        except ValueError:
+            body = {}
+        if not isinstance(body, dict):
+            body = {}
+        return cls(
+            response.status_code,
+            body.get("code", "internal"),
+            body.get("message", response.reason or ""),
+            body.get("details"),
+            body.get("requestId"),
+        )
+
+
+def _encode(value: Any) -> Any:
+    """Converts value to what JSON encodes, models by their JSON keys"""
+    if isinstance(value, BaseModel):
+        return value.model_dump(mode="json", by_alias=True, exclude_none=True)
+    if isinstance(value, (list, tuple)):
+        return [_encode(item) for item in value]
+    if isinstance(value, dict):
+        return {str(key): _encode(item) for key, item in value.items()}
+    if isinstance(value, (datetime.datetime, datetime.date)):
+        return value.isoformat()
+    if isinstance(value, uuid.UUID):
+        return str(value)
+    return value
+
+
+class Client:
+    """Calls the API at base_url. A token is sent as a bearer token to
+    functions that require authentication; pass a session to add other
+    headers, retries or certificates. last_response is the response of the
+    last call, for the headers it carries.
+    """
+
+    def __init__(self, base_url: str, token: Optional[str] = None, session: Optional[requests.Session] = None, timeout: float = 30):
+        self.base_url = base_url.rstrip("/")
+        self.session = session or requests.Session()
+        if token:
+            self.session.headers["Authorization"] = f"Bearer {token}"
+        self.timeout = timeout
+        self.last_response: Optional[requests.Response] = None
+
+    def _post(self, path: str, body: Dict[str, Any], headers: Optional[Dict[str, Optional[str]]] = None) -> requests.Response:
+        sent = {name: value for name, value in (headers or {}).items() if value is not None}
+        response = self.session.post(self.base_url + path, json=body, headers=sent, timeout=self.timeout)
+        self.last_response = response
+        if not response.ok:
+            raise APIError.from_response(response)
+        return response
+
+    def _update_with_retry(self, path: str, change: Callable[[Any], Any], attempts: int) -> Any:
+        """Reads a record, applies change and writes it back with If-Match,
+        starting over when someone else saved first (409 or 412)
+        """
+        for attempt in range(1, attempts + 1):
+            response = self.session.get(self.base_url + path, timeout=self.timeout)
+            if not response.ok:
+                raise APIError.from_response(response)
+            etag = response.headers.get("ETag", "*")
+            updated = self.session.put(self.base_url + path, json=change(response.json()), headers={"If-Match": etag}, timeout=self.timeout)
+            self.last_response = updated
+            if updated.ok:
+                return updated.json()
+            if updated.status_code not in (409, 412) or attempt >= attempts:
+                raise APIError.from_response(updated)
+        raise ValueError("attempts must be at least 1")
+`
@@ -0,0 +1,220 @@
+// Package pygen translates checked CloudPact files into Python: records and
+// models become pydantic models validating like the Go validate tags, and
+// functions become methods of a requests-based client calling the generated
+// handlers.
+package pygen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Options tunes the generated code
+type Options struct {
+	// Header is the provenance comment the file starts with, usually
+	// codegen.Header; empty means none
+	Header string
+}
+
+// GenerateFile translates a checked file into a Python module. Each record
+// and model is a pydantic model whose attributes carry the JSON keys as
+// aliases. When the file declares functions or versioned records, Client
+// calls them over HTTP and APIError reports the failures.
+func GenerateFile(file *grammar.File, opts Options) ([]byte, error) {
+	declared := make(map[string]bool)
+	for _, record := range file.Records {
+		declared[record.Name] = true
+	}
+	for _, model := range file.Models {
+		declared[model.Name] = true
+	}
+
+	var body strings.Builder
+	var classes []string
+	for _, record := range sortedRecords(file.Records) {
+		writeRecord(&body, record, declared)
+		classes = append(classes, record.Name)
+	}
+	for _, model := range file.Models {
+		writeModel(&body, model, declared)
+		classes = append(classes, model.Name)
+	}
+	// Annotations naming classes declared further down resolve once all are
+	if len(classes) > 0 {
+		body.WriteString("\n\n")
+		for _, name := range classes {
+			body.WriteString(name + ".model_rebuild()\n")
+		}
+	}
+
+	hasClient := len(file.Functions) > 0
+	for _, record := range file.Records {
+		hasClient = hasClient || record.Versioned
+	}
+	if hasClient {
+		writeClient(&body, file, declared)
+	}
+
+	var code strings.Builder
+	code.WriteString("# Generated Python models and client from CloudPact\n")
+	if file.Module != nil {
+		code.WriteString(fmt.Sprintf("# Module: %s\n", file.Module.Name))
+	}
+	code.WriteString("from __future__ import annotations\n\n")
+	code.WriteString("import datetime\nimport uuid\n")
+	code.WriteString("from typing import Any, Callable, Dict, List, Optional\n\n")
+	if hasClient {
+		code.WriteString("import requests\n")
+	}
+	code.WriteString("from pydantic import BaseModel, ConfigDict, Field, TypeAdapter\n")
+	code.WriteString(body.String())
+	return codegen.Stamp("#", opts.Header, []byte(code.String())), nil
+}
+
+// sortedRecords orders records so that a record's base comes before it,
+// as Python requires of base classes, keeping their order otherwise
+func sortedRecords(records []*grammar.Record) []*grammar.Record {
+	byName := make(map[string]*grammar.Record)
+	for _, record := range records {
+		byName[record.Name] = record
+	}
+	var sorted []*grammar.Record
+	added := make(map[string]bool)
+	var add func(record *grammar.Record)
+	add = func(record *grammar.Record) {
+		if added[record.Name] {
+			return
+		}
+		added[record.Name] = true
+		if base, ok := byName[record.Extends]; ok {
+			add(base)
+		}
+		sorted = append(sorted, record)
+	}
+	for _, record := range records {
+		add(record)
+	}
+	return sorted
+}
+
+// writeRecord writes the pydantic model of a record. A record extending one
+// of the same file subclasses it; one extending a record of another file
+// repeats the base's fields.
+func writeRecord(code *strings.Builder, record *grammar.Record, declared map[string]bool) {
+	base := "BaseModel"
+	fields := record.Fields
+	if record.Extends != "" {
+		if declared[record.Extends] {
+			base = record.Extends
+		} else {
+			fields = record.AllFields()
+		}
+	}
+
+	code.WriteString(fmt.Sprintf("\n\nclass %s(%s):\n", record.Name, base))
+	writeDocstring(code, codegen.DocLines(record.Leading, record.Trailing), "    ")
+	if base == "BaseModel" {
+		writeModelConfig(code, codegen.DocLines(record.Leading, record.Trailing))
+	}
+	if base == "BaseModel" && record.HasImplicitID() {
+		code.WriteString("    id: uuid.UUID = Field(default_factory=uuid.uuid4)\n")
+	}
+	for _, field := range fields {
+		writeField(code, field, declared)
+	}
+	if record.Versioned {
+		code.WriteString("    # Increases with every update; sent as the ETag\n")
+		code.WriteString("    version: int = 0\n")
+	}
+	// A subclass adding nothing still needs a body
+	if base != "BaseModel" && len(fields) == 0 && !record.Versioned {
+		code.WriteString("    pass\n")
+	}
+}
+
+// writeModel writes the pydantic model of a legacy model, which has an id
+// unless it declares one
+func writeModel(code *strings.Builder, model *grammar.Model, declared map[string]bool) {
+	code.WriteString(fmt.Sprintf("\n\nclass %s(BaseModel):\n", model.Name))
+	writeDocstring(code, codegen.DocLines(model.Leading, model.Trailing), "    ")
+	writeModelConfig(code, codegen.DocLines(model.Leading, model.Trailing))
+	declaresID := false
+	for _, field := range model.Fields {
+		declaresID = declaresID || strings.EqualFold(field.Name, "id")
+	}
+	if !declaresID {
+		code.WriteString("    id: uuid.UUID = Field(default_factory=uuid.uuid4)\n")
+	}
+	for _, field := range model.Fields {
+		writeField(code, &grammar.FieldDef{Name: field.Name, Type: field.Type, JSONName: field.JSONName, Leading: field.Leading, Trailing: field.Trailing}, declared)
+	}
+}
+
+// writeField writes a model attribute: its type, its JSON key as an alias
+// when the two differ, its default and its constraints. Optional fields
+// default to None; "maybe" fields must be given, if only as None.
+func writeField(code *strings.Builder, field *grammar.FieldDef, declared map[string]bool) {
+	for _, line := range codegen.DocLines(field.Leading, field.Trailing) {
+		code.WriteString(strings.TrimRight("    # "+line, " ") + "\n")
+	}
+	name := pyName(field.Name)
+	pyType := FieldType(field.Type, declared)
+	if field.Type.Optional && !field.Type.Nullable {
+		pyType = "Optional[" + pyType + "]"
+	}
+
+	var args []string
+	switch value := field.Default.(type) {
+	case *grammar.LiteralExpression:
+		args = append(args, "default="+pyLiteral(value))
+	case *grammar.IdentifierExpression:
+		// "now", which only date and time fields take
+		if strings.ToLower(field.Type.Name) == "date" {
+			args = append(args, "default_factory=datetime.date.today")
+		} else {
+			args = append(args, "default_factory=lambda: datetime.datetime.now(datetime.timezone.utc)")
+		}
+	default:
+		if field.Type.Optional && !field.Type.Nullable {
+			args = append(args, "default=None")
+		}
+	}
+	if field.JSONKey() != name {
+		args = append(args, fmt.Sprintf("alias=%q", field.JSONKey()))
+	}
+	args = append(args, pyConstraints(field.Type)...)
+
+	switch {
+	case len(args) == 0:
+		code.WriteString(fmt.Sprintf("    %s: %s\n", name, pyType))
+	case len(args) == 1 && strings.HasPrefix(args[0], "default="):
+		code.WriteString(fmt.Sprintf("    %s: %s = %s\n", name, pyType, strings.TrimPrefix(args[0], "default=")))
+	default:
+		code.WriteString(fmt.Sprintf("    %s: %s = Field(%s)\n", name, pyType, strings.Join(args, ", ")))
+	}
+}
+
+// writeModelConfig lets a model be built from attribute names as well as
+// JSON keys, set apart from the class docstring doc
+func writeModelConfig(code *strings.Builder, doc []string) {
+	if len(doc) > 0 {
+		code.WriteString("\n")
+	}
+	code.WriteString("    model_config = ConfigDict(populate_by_name=True)\n\n")
+}
+
+// writeDocstring writes lines as a docstring at indent
+func writeDocstring(code *strings.Builder, lines []string, indent string) {
+	if len(lines) == 0 {
+		return
+	}
+	text := strings.NewReplacer(`\`, `\\`, `"""`, `\"\"\"`).Replace(strings.Join(lines, "\n"+indent))
+	if len(lines) == 1 {
+		code.WriteString(indent + `"""` + text + `"""` + "\n")
+		return
+	}
+	code.WriteString(indent + `"""` + text + "\n" + indent + `"""` + "\n")
+}
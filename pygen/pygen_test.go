@@ -0,0 +1,105 @@
+package pygen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/internal/testutil"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+func TestGenerateFile(t *testing.T) {
+	src := `module Clinic
+
+// A booked appointment
+define record Appointment versioned
+    patientEmail: email
+    fee: usd_currency
+    discount: percentage default 0
+    note: text optional maxlength 200
+    tags: list of text
+    bookedAt: datetime default now
+    visitSite: maybe Site
+
+define record Checkup extends Appointment
+    reason: text
+
+define record Site no id
+    street: text
+    zip: zip_code
+
+function bookAppointment(appointment: Appointment) returns Appointment
+    header: X-Tenant-ID required
+    header: X-Trace-Id
+    why: "Appointments belong to the tenant booking them"
+    do:
+        return appointment
+
+function cancelAppointment(appointmentID: uuid)
+    why: "Patients change their plans"
+    do:
+        return`
+	f := testutil.CheckedFile(t, src)
+	f.NameJSON("snake")
+
+	out, err := GenerateFile(f, Options{Header: "Code generated by cloudpact. DO NOT EDIT."})
+	if err != nil {
+		t.Fatalf("GenerateFile error: %v", err)
+	}
+	code := string(out)
+	for _, want := range []string{
+		"# Code generated by cloudpact. DO NOT EDIT.",
+		"from __future__ import annotations",
+		"import requests",
+		"class Appointment(BaseModel):\n    \"\"\"A booked appointment\"\"\"\n\n    model_config = ConfigDict(populate_by_name=True)",
+		"    id: uuid.UUID = Field(default_factory=uuid.uuid4)",
+		`    patientEmail: str = Field(alias="patient_email", pattern=r"^[^@\s]+@[^@\s]+\.[^@\s]+$")`,
+		"    fee: float = Field(ge=0)",
+		"    discount: float = Field(default=0, ge=0, le=100)",
+		"    note: Optional[str] = Field(default=None, max_length=200)",
+		"    tags: List[str]",
+		`    bookedAt: datetime.datetime = Field(default_factory=lambda: datetime.datetime.now(datetime.timezone.utc), alias="booked_at")`,
+		`    visitSite: Optional[Site] = Field(alias="visit_site")`,
+		"    version: int = 0",
+		"class Checkup(Appointment):\n    reason: str",
+		"    zip: str = Field(min_length=5, max_length=5)",
+		"Checkup.model_rebuild()",
+		"class APIError(Exception):",
+		"    def update_appointment(self, id: str, change: Callable[[Appointment], Appointment], attempts: int = 3) -> Appointment:",
+		`self._update_with_retry(f"/appointments/{id}"`,
+		"    def book_appointment(self, appointment: Appointment, *, x_tenant_id: str, x_trace_id: Optional[str] = None) -> Appointment:",
+		`        """Why: Appointments belong to the tenant booking them"""`,
+		`self._post("/bookappointment", {"appointment": _encode(appointment)}, {"X-Tenant-ID": x_tenant_id, "X-Trace-Id": x_trace_id})`,
+		"        return TypeAdapter(Appointment).validate_python(response.json())",
+		"    def cancel_appointment(self, appointment_id: uuid.UUID) -> None:",
+		`        self._post("/cancelappointment", {"appointmentID": _encode(appointment_id)})`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in output:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "class Site(BaseModel):\n    model_config = ConfigDict(populate_by_name=True)\n\n    id:") {
+		t.Error("a record with no id should not get one")
+	}
+	if strings.Index(code, "class Appointment(") > strings.Index(code, "class Checkup(") {
+		t.Error("a base class must come before its subclasses")
+	}
+}
+
+func TestGenerateFileWithoutFunctions(t *testing.T) {
+	f, err := grammar.ParseString("define record Note\n    class: text\n    body: text")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	out, err := GenerateFile(f, Options{})
+	if err != nil {
+		t.Fatalf("GenerateFile error: %v", err)
+	}
+	code := string(out)
+	if strings.Contains(code, "requests") || strings.Contains(code, "class Client") {
+		t.Errorf("a file without functions needs no client:\n%s", code)
+	}
+	if !strings.Contains(code, `    class_: str = Field(alias="class")`) {
+		t.Errorf("expected a keyword to be renamed:\n%s", code)
+	}
+}
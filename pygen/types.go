@@ -0,0 +1,174 @@
+package pygen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// pyKeywords cannot name attributes or parameters, nor can self in a
+// method, so names that are take a trailing underscore
+var pyKeywords = map[string]bool{
+	"self": true, "False": true, "None": true, "True": true, "and": true, "as": true, "assert": true, "async": true,
+	"await": true, "break": true, "class": true, "continue": true, "def": true, "del": true, "elif": true,
+	"else": true, "except": true, "finally": true, "for": true, "from": true, "global": true, "if": true,
+	"import": true, "in": true, "is": true, "lambda": true, "nonlocal": true, "not": true, "or": true,
+	"pass": true, "raise": true, "return": true, "try": true, "while": true, "with": true, "yield": true,
+}
+
+// FieldType maps a CloudPact type to a Python annotation. declared names
+// the records and models with classes in the same file; those of other
+// files are Any. "maybe" types admit None.
+func FieldType(t *grammar.Type, declared map[string]bool) string {
+	if t.Nullable {
+		inner := *t
+		inner.Nullable = false
+		return "Optional[" + FieldType(&inner, declared) + "]"
+	}
+	if t.Name == "list" && t.Element != nil {
+		return "List[" + FieldType(t.Element, declared) + "]"
+	}
+	if t.Name == "map" && t.Key != nil {
+		return fmt.Sprintf("Dict[%s, %s]", FieldType(t.Key, declared), FieldType(t.Value, declared))
+	}
+
+	switch strings.ToLower(t.Name) {
+	case "int", "integer":
+		return "int"
+	case "float", "number", "usd_currency", "eur_currency", "percentage":
+		return "float"
+	case "bool", "boolean":
+		return "bool"
+	case "uuid":
+		return "uuid.UUID"
+	case "date":
+		return "datetime.date"
+	case "datetime", "timestamp":
+		return "datetime.datetime"
+	}
+	if codegen.IsRecordTypeName(t.Name) {
+		if declared[t.Name] {
+			return t.Name
+		}
+		return "Any"
+	}
+	// Text, times of day, ISO durations and the text semantic types
+	return "str"
+}
+
+// pyConstraints are the pydantic Field arguments validating a value of t
+// like the Go validate tags and the zod schemas do
+func pyConstraints(t *grammar.Type) []string {
+	var args []string
+	switch strings.ToLower(t.Name) {
+	case "email":
+		pattern := `^[^@\s]+@[^@\s]+\.[^@\s]+$`
+		if domain, ok := t.Constraints[grammar.ConstraintDomain]; ok {
+			pattern = `^[^@\s]+@` + regexp.QuoteMeta(fmt.Sprint(domain)) + `$`
+		}
+		args = append(args, "pattern="+pyRawString(pattern))
+	case "url":
+		args = append(args, "pattern="+pyRawString(`^[A-Za-z][A-Za-z0-9+.-]*://\S+$`))
+	case "phone":
+		args = append(args, "pattern="+pyRawString(`^\+[1-9]\d{1,14}$`)) // E.164, like the e164 tag
+	case "zip_code":
+		args = append(args, "min_length=5", "max_length=5")
+	case "country_code", "state_code":
+		args = append(args, "pattern="+pyRawString(`^[A-Za-z]{2}$`))
+	case "percentage":
+		args = append(args, "ge=0", "le=100")
+	case "usd_currency", "eur_currency":
+		args = append(args, "ge=0")
+	case "password":
+		args = append(args, "min_length=8")
+	}
+	if _, ok := t.Constraints[grammar.ConstraintDomain]; ok && strings.ToLower(t.Name) != "email" {
+		args = append(args, "pattern="+pyRawString(regexp.QuoteMeta("@"+fmt.Sprint(t.Constraints[grammar.ConstraintDomain]))+"$"))
+	}
+
+	// Declared bounds replace those of the semantic type
+	replace := func(key, value string) {
+		for i, arg := range args {
+			if strings.HasPrefix(arg, key+"=") {
+				args[i] = key + "=" + value
+				return
+			}
+		}
+		args = append(args, key+"="+value)
+	}
+	for _, name := range codegen.FieldConstraints {
+		value, ok := t.Constraints[name]
+		if !ok {
+			continue
+		}
+		switch name {
+		case grammar.ConstraintMin:
+			replace("ge", fmt.Sprint(value))
+		case grammar.ConstraintMax:
+			replace("le", fmt.Sprint(value))
+		case grammar.ConstraintMinLength:
+			replace("min_length", fmt.Sprint(value))
+		case grammar.ConstraintMaxLength:
+			replace("max_length", fmt.Sprint(value))
+		}
+	}
+	return args
+}
+
+// pyRawString writes s as a Python raw string, for regular expressions
+func pyRawString(s string) string {
+	return `r"` + s + `"`
+}
+
+// pyLiteral writes a constant as Python source
+func pyLiteral(e *grammar.LiteralExpression) string {
+	switch v := e.Value.(type) {
+	case string:
+		// A JSON string is a valid Python string
+		var b bytes.Buffer
+		encoder := json.NewEncoder(&b)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(v)
+		return strings.TrimSuffix(b.String(), "\n")
+	case float64:
+		return codegen.FloatLiteral(v)
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case nil:
+		return "None"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// pyName makes name usable as a Python attribute or parameter
+func pyName(name string) string {
+	if pyKeywords[name] {
+		return name + "_"
+	}
+	return name
+}
+
+// snakeCase writes a CloudPact name the Python way: createUser gives
+// create_user and userID user_id
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return pyName(b.String())
+}
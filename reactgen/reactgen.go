@@ -0,0 +1,423 @@
+// Package reactgen writes React hooks for CloudPact functions and form
+// components for records. Hooks wrap the function methods of the generated
+// API client with loading and error state; forms validate with the zod
+// schemas before submitting.
+package reactgen
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/formgen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Options tunes the generated code
+type Options struct {
+	// Header is the provenance comment the file starts with, usually
+	// codegen.Header; empty means none
+	Header string
+	// TypesImport is the module declaring the record interfaces, relative
+	// to the output, such as "../ts/shop"
+	TypesImport string
+	// SchemasImport is the module declaring the zod schemas, relative to
+	// the output, such as "../zod/shop.schemas"
+	SchemasImport string
+	// ClientImport is the module declaring the APIClient, relative to the
+	// output, such as "../client/shop"
+	ClientImport string
+}
+
+// errorHelpers turn what hooks and forms catch into errors
+const errorHelpers = `function toError(err: unknown): Error {
+  return err instanceof Error ? err : new Error(String(err));
+}
+`
+
+// hookHelpers are the state and request wrapper the functions' hooks share
+const hookHelpers = `
+/** The state of a request a hook makes */
+export interface RequestState<T> {
+  data: T | undefined;
+  error: Error | undefined;
+  loading: boolean;
+}
+
+function useMutation<A extends unknown[], T>(run: (...args: A) => Promise<T>): RequestState<T> & { mutate: (...args: A) => Promise<T> } {
+  const [state, setState] = useState<RequestState<T>>({ data: undefined, error: undefined, loading: false });
+  const latest = useRef(run);
+  latest.current = run;
+  const mutate = useCallback(async (...args: A) => {
+    setState({ data: undefined, error: undefined, loading: true });
+    try {
+      const data = await latest.current(...args);
+      setState({ data, error: undefined, loading: false });
+      return data;
+    } catch (err) {
+      const error = toError(err);
+      setState({ data: undefined, error, loading: false });
+      throw error;
+    }
+  }, []);
+  return { ...state, mutate };
+}
+`
+
+// formHelpers are the types and functions the records' forms share
+const formHelpers = `
+/** How a form field's value is read from its input */
+type FieldKind = "text" | "number" | "checkbox" | "lines" | "datetime";
+
+interface FormField {
+  name: string;
+  kind: FieldKind;
+  optional?: boolean;
+  nullable?: boolean;
+}
+
+/** Reads the inputs of form as record fields */
+function readForm(form: HTMLFormElement, fields: FormField[]): Record<string, unknown> {
+  const values: Record<string, unknown> = {};
+  for (const field of fields) {
+    const input = form.elements.namedItem(field.name);
+    if (!(input instanceof HTMLInputElement || input instanceof HTMLTextAreaElement)) continue;
+    if (field.kind === "checkbox") {
+      values[field.name] = (input as HTMLInputElement).checked;
+      continue;
+    }
+    const raw = input.value.trim();
+    if (field.kind === "lines") {
+      values[field.name] = raw.split("\n").map((line) => line.trim()).filter((line) => line !== "");
+      continue;
+    }
+    if (raw === "") {
+      if (field.nullable) values[field.name] = null;
+      else if (!field.optional && field.kind === "text") values[field.name] = "";
+      continue;
+    }
+    if (field.kind === "number") values[field.name] = Number(raw);
+    else if (field.kind === "datetime") values[field.name] = new Date(raw).toISOString();
+    else values[field.name] = raw;
+  }
+  return values;
+}
+
+/** Writes a record field's value as its input shows it */
+function inputValue(value: unknown, kind: FieldKind, fallback = ""): string {
+  if (value === undefined || value === null) return fallback;
+  if (kind === "lines") return (value as unknown[]).join("\n");
+  if (kind === "datetime") {
+    const time = new Date(String(value));
+    if (Number.isNaN(time.getTime())) return "";
+    return new Date(time.getTime() - time.getTimezoneOffset() * 60000).toISOString().slice(0, 16);
+  }
+  return String(value);
+}
+
+/**
+ * Keys the first message of each invalid field by its name; problems with
+ * fields the form has no input for go under "", the form's own error
+ */
+function fieldErrors(error: z.ZodError, fields: FormField[]): Record<string, string> {
+  const errors: Record<string, string> = {};
+  for (const issue of error.issues) {
+    const name = String(issue.path[0] ?? "");
+    if (fields.some((field) => field.name === name)) {
+      errors[name] ??= issue.message;
+    } else {
+      errors[""] ??= name === "" ? issue.message : name + ": " + issue.message;
+    }
+  }
+  return errors;
+}
+
+/** Joins the ids describing an input, or is undefined when there are none */
+function describedBy(...ids: (string | false | undefined)[]): string | undefined {
+  const described = ids.filter(Boolean).join(" ");
+  return described === "" ? undefined : described;
+}
+`
+
+// GenerateFile writes a hook for each function of file, which the API
+// serves and the client calls by its name, and a form component for each
+// record with an id. A file with neither gives an empty module.
+func GenerateFile(file *grammar.File, opts Options) ([]byte, error) {
+	var records []*grammar.Record
+	for _, record := range file.Records {
+		if formgen.Editable(record) {
+			records = append(records, record)
+		}
+	}
+
+	var code strings.Builder
+	code.WriteString("// Generated React hooks and forms from CloudPact\n")
+	if file.Module != nil {
+		code.WriteString(fmt.Sprintf("// Module: %s\n", file.Module.Name))
+	}
+	if len(records) == 0 && len(file.Functions) == 0 {
+		code.WriteString("export {};\n")
+		return codegen.Stamp("//", opts.Header, []byte(code.String())), nil
+	}
+
+	// Each helper imports only what the code using it needs
+	var hooks []string
+	if len(file.Functions) > 0 {
+		hooks = append(hooks, "useCallback", "useRef")
+	}
+	if len(records) > 0 {
+		hooks = append(hooks, "useId")
+	}
+	hooks = append(hooks, "useState")
+	if len(records) > 0 {
+		hooks = append(hooks, "type FormEvent")
+	}
+	code.WriteString(fmt.Sprintf("import { %s } from \"react\";\n", strings.Join(hooks, ", ")))
+	if len(records) > 0 {
+		code.WriteString("import type { z } from \"zod\";\n")
+		var names, schemas []string
+		for _, record := range records {
+			names = append(names, record.Name)
+			schemas = append(schemas, record.Name+"Schema")
+		}
+		code.WriteString(fmt.Sprintf("import type { %s } from %q;\n", strings.Join(names, ", "), opts.TypesImport))
+		code.WriteString(fmt.Sprintf("import { %s } from %q;\n", strings.Join(schemas, ", "), opts.SchemasImport))
+	}
+	if len(file.Functions) > 0 {
+		code.WriteString(fmt.Sprintf("import type { APIClient } from %q;\n", opts.ClientImport))
+	}
+	code.WriteString("\n" + errorHelpers)
+	if len(file.Functions) > 0 {
+		code.WriteString(hookHelpers)
+	}
+	if len(records) > 0 {
+		code.WriteString(formHelpers)
+	}
+
+	for _, function := range file.Functions {
+		writeHook(&code, function)
+	}
+	for _, record := range records {
+		writeForm(&code, record)
+	}
+	return codegen.Stamp("//", opts.Header, []byte(code.String())), nil
+}
+
+// writeHook writes use<Function>, whose mutate calls the function through
+// the APIClient method of its name, taking the same arguments
+func writeHook(code *strings.Builder, function *grammar.Function) {
+	name := function.Name
+	code.WriteString(fmt.Sprintf("\n/** Calls %s, tracking the request's state */\n", name))
+	code.WriteString(fmt.Sprintf("export function use%s(client: Pick<APIClient, %q>) {\n", strings.ToUpper(name[:1])+name[1:], name))
+	code.WriteString(fmt.Sprintf("  return useMutation((...args: Parameters<APIClient[%q]>) => client.%s(...args));\n}\n", name, name))
+}
+
+// writeForm writes <Record>Form, whose inputs are those of the HTML forms
+// and whose values are checked by the record's zod schema, less what the
+// API assigns, before onSubmit is called
+func writeForm(code *strings.Builder, record *grammar.Record) {
+	name := record.Name
+	variable := lowerFirst(name)
+	inputs := formgen.Inputs(record)
+
+	var assigned []string
+	if record.HasImplicitID() {
+		assigned = append(assigned, "id: true")
+	}
+	if record.IsVersioned() {
+		assigned = append(assigned, "version: true")
+	}
+	code.WriteString("\n")
+	if len(assigned) > 0 {
+		code.WriteString(fmt.Sprintf("/** What %sForm checks: a %s without what the API assigns */\n", name, name))
+		code.WriteString(fmt.Sprintf("export const %sFormSchema = %sSchema.omit({ %s });\n", name, name, strings.Join(assigned, ", ")))
+	} else {
+		code.WriteString(fmt.Sprintf("/** What %sForm checks */\n", name))
+		code.WriteString(fmt.Sprintf("export const %sFormSchema = %sSchema;\n", name, name))
+	}
+	code.WriteString(fmt.Sprintf("export type %sFormValues = z.infer<typeof %sFormSchema>;\n", name, name))
+
+	code.WriteString(fmt.Sprintf("\nconst %sFields: FormField[] = [\n", variable))
+	for _, in := range inputs {
+		field := fmt.Sprintf("{ name: %q, kind: %q", in.Field.JSONKey(), in.Kind)
+		if in.Field.Type.Nullable {
+			field += ", nullable: true"
+		} else if in.Field.Type.Optional {
+			field += ", optional: true"
+		}
+		code.WriteString("  " + field + " },\n")
+	}
+	code.WriteString("];\n")
+
+	code.WriteString(fmt.Sprintf("\nexport interface %sFormProps {\n", name))
+	code.WriteString(fmt.Sprintf("  /** The %s to edit; without it the form starts from the defaults */\n", name))
+	code.WriteString(fmt.Sprintf("  initial?: %s;\n", name))
+	code.WriteString("  /** Called with the checked values; a rejection is shown in the form */\n")
+	code.WriteString(fmt.Sprintf("  onSubmit: (values: %sFormValues) => void | Promise<void>;\n", name))
+	code.WriteString("  submitLabel?: string;\n")
+	code.WriteString("}\n")
+
+	code.WriteString("\n/**\n")
+	code.WriteString(fmt.Sprintf(" * A form for a %s. Fields without an input keep their values in initial.\n", name))
+	code.WriteString(" * Invalid fields are marked and described by their errors, and the first\n")
+	code.WriteString(" * is focused.\n")
+	code.WriteString(" */\n")
+	code.WriteString(fmt.Sprintf("export function %sForm({ initial, onSubmit, submitLabel = \"Save\" }: %sFormProps) {\n", name, name))
+	code.WriteString("  const id = useId();\n")
+	code.WriteString("  const [errors, setErrors] = useState<Record<string, string>>({});\n")
+	code.WriteString("  const [submitting, setSubmitting] = useState(false);\n\n")
+	code.WriteString("  async function handleSubmit(event: FormEvent<HTMLFormElement>) {\n")
+	code.WriteString("    event.preventDefault();\n")
+	code.WriteString("    const form = event.currentTarget;\n")
+	code.WriteString(fmt.Sprintf("    const result = %sFormSchema.safeParse({ ...initial, ...readForm(form, %sFields) });\n", name, variable))
+	code.WriteString("    if (!result.success) {\n")
+	code.WriteString(fmt.Sprintf("      const invalid = fieldErrors(result.error, %sFields);\n", variable))
+	code.WriteString("      setErrors(invalid);\n")
+	code.WriteString(fmt.Sprintf("      const first = %sFields.find((field) => field.name in invalid);\n", variable))
+	code.WriteString("      if (first) (form.elements.namedItem(first.name) as HTMLElement | null)?.focus();\n")
+	code.WriteString("      return;\n")
+	code.WriteString("    }\n")
+	code.WriteString("    setErrors({});\n")
+	code.WriteString("    setSubmitting(true);\n")
+	code.WriteString("    try {\n")
+	code.WriteString("      await onSubmit(result.data);\n")
+	code.WriteString("    } catch (err) {\n")
+	code.WriteString("      setErrors({ \"\": toError(err).message });\n")
+	code.WriteString("    } finally {\n")
+	code.WriteString("      setSubmitting(false);\n")
+	code.WriteString("    }\n")
+	code.WriteString("  }\n\n")
+
+	code.WriteString("  return (\n")
+	code.WriteString("    <form className=\"cloudpact-form\" onSubmit={handleSubmit} noValidate aria-busy={submitting}>\n")
+	for _, in := range inputs {
+		writeInput(code, in)
+	}
+	code.WriteString("      {errors[\"\"] && (\n")
+	code.WriteString("        <p className=\"form-error\" role=\"alert\">\n")
+	code.WriteString("          {errors[\"\"]}\n")
+	code.WriteString("        </p>\n")
+	code.WriteString("      )}\n")
+	code.WriteString("      <button type=\"submit\" disabled={submitting}>\n")
+	code.WriteString("        {submitLabel}\n")
+	code.WriteString("      </button>\n")
+	code.WriteString("    </form>\n")
+	code.WriteString("  );\n")
+	code.WriteString("}\n")
+}
+
+// jsxNames are the React names of the HTML attributes inputs carry
+var jsxNames = map[string]string{
+	"autocomplete": "autoComplete",
+	"inputmode":    "inputMode",
+	"minlength":    "minLength",
+	"maxlength":    "maxLength",
+}
+
+// writeInput writes a field's label, input, hint and error
+func writeInput(code *strings.Builder, in formgen.Input) {
+	key := in.Field.JSONKey()
+	// useId keeps the ids unique when a page shows the form twice
+	suffix := "-" + key
+	inputID := fmt.Sprintf("{`${id}%s`}", suffix)
+	errorRef := "errors" + tsProperty(key)
+
+	var attrs []string
+	attrs = append(attrs, "id="+inputID, fmt.Sprintf("name=%q", key))
+	var fallback string
+	checked := "false"
+	for _, attr := range in.Attributes {
+		switch {
+		case attr.Name == "value":
+			fallback = attr.Value
+		case attr.Name == "checked":
+			checked = "true"
+		case attr.Name == "rows":
+			attrs = append(attrs, fmt.Sprintf("rows={%s}", attr.Value))
+		case attr.Boolean:
+			attrs = append(attrs, attr.Name)
+		default:
+			attrName := attr.Name
+			if jsx, ok := jsxNames[attrName]; ok {
+				attrName = jsx
+			}
+			attrs = append(attrs, fmt.Sprintf("%s=\"%s\"", attrName, strings.ReplaceAll(attr.Value, `"`, "&quot;")))
+		}
+	}
+	if in.Kind == "checkbox" {
+		attrs = append(attrs, fmt.Sprintf("defaultChecked={initial ? initial%s === true : %s}", tsProperty(key), checked))
+	} else {
+		value := fmt.Sprintf("inputValue(initial%s, %q", optionalProperty(key), in.Kind)
+		if fallback != "" {
+			value += fmt.Sprintf(", %q", fallback)
+		}
+		attrs = append(attrs, fmt.Sprintf("defaultValue={%s)}", value))
+	}
+	attrs = append(attrs, fmt.Sprintf("aria-invalid={%s !== undefined}", errorRef))
+	described := fmt.Sprintf("%s !== undefined && `${id}%s-error`", errorRef, suffix)
+	if in.Hint != "" {
+		described = fmt.Sprintf("`${id}%s-hint`, ", suffix) + described
+	}
+	attrs = append(attrs, fmt.Sprintf("aria-describedby={describedBy(%s)}", described))
+
+	element := "input"
+	if in.Textarea {
+		element = "textarea"
+	}
+	inputTag := fmt.Sprintf("        <%s\n", element)
+	for _, attr := range attrs {
+		inputTag += "          " + attr + "\n"
+	}
+	inputTag += "        />\n"
+	labelTag := fmt.Sprintf("        <label htmlFor=%s>%s</label>\n", inputID, jsxText(in.Label))
+
+	code.WriteString("      <div className=\"field\">\n")
+	if in.Kind == "checkbox" {
+		code.WriteString(inputTag + labelTag)
+	} else {
+		code.WriteString(labelTag + inputTag)
+	}
+	if in.Hint != "" {
+		code.WriteString(fmt.Sprintf("        <p id={`${id}%s-hint`} className=\"hint\">\n          %s\n        </p>\n", suffix, jsxText(in.Hint)))
+	}
+	code.WriteString(fmt.Sprintf("        {%s !== undefined && (\n", errorRef))
+	code.WriteString(fmt.Sprintf("          <p id={`${id}%s-error`} className=\"field-error\">\n", suffix))
+	code.WriteString(fmt.Sprintf("            {%s}\n", errorRef))
+	code.WriteString("          </p>\n")
+	code.WriteString("        )}\n")
+	code.WriteString("      </div>\n")
+}
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// tsProperty writes access to the property key
+func tsProperty(key string) string {
+	if tsIdentifier.MatchString(key) {
+		return "." + key
+	}
+	return fmt.Sprintf("[%q]", key)
+}
+
+// optionalProperty writes optional access to the property key
+func optionalProperty(key string) string {
+	if tsIdentifier.MatchString(key) {
+		return "?." + key
+	}
+	return fmt.Sprintf("?.[%q]", key)
+}
+
+// jsxText escapes text shown in JSX
+func jsxText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "{", "&#123;", "}", "&#125;").Replace(s)
+}
+
+// lowerFirst lowercases the first letter of a name, for variables
+func lowerFirst(name string) string {
+	runes := []rune(name)
+	if len(runes) > 0 {
+		runes[0] = unicode.ToLower(runes[0])
+	}
+	return string(runes)
+}
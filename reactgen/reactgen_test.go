@@ -0,0 +1,94 @@
+package reactgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/internal/testutil"
+)
+
+func TestGenerateFile(t *testing.T) {
+	src := `module Bookings
+
+define record Guest versioned
+    emailAddress: email
+    // What we call them
+    nickname: text optional
+    active: bool default true
+    referrer: maybe Guest
+
+define record Room
+    number: text key
+    rate: usd_currency
+
+define record Night no id
+    date: text
+
+function hold(number: text, nights: int) returns Room
+    header: Idempotency-Key required
+    why: "Holds a room for a guest"
+    do:
+        fail "fully booked"`
+	f := testutil.CheckedFile(t, src)
+	f.NameJSON("camel")
+
+	out, err := GenerateFile(f, Options{Header: "Code generated by cloudpact. DO NOT EDIT.", TypesImport: "../ts/bookings", SchemasImport: "../zod/bookings.schemas", ClientImport: "../client/bookings"})
+	if err != nil {
+		t.Fatalf("GenerateFile error: %v", err)
+	}
+	code := string(out)
+	for _, want := range []string{
+		"// Code generated by cloudpact. DO NOT EDIT.",
+		"import { useCallback, useRef, useId, useState, type FormEvent } from \"react\";",
+		"import type { Guest, Room } from \"../ts/bookings\";\nimport { GuestSchema, RoomSchema } from \"../zod/bookings.schemas\";\nimport type { APIClient } from \"../client/bookings\";",
+		"export function useHold(client: Pick<APIClient, \"hold\">) {\n  return useMutation((...args: Parameters<APIClient[\"hold\"]>) => client.hold(...args));\n}",
+		"export const GuestFormSchema = GuestSchema.omit({ id: true, version: true });",
+		"export const RoomFormSchema = RoomSchema;",
+		"export function GuestForm({ initial, onSubmit, submitLabel = \"Save\" }: GuestFormProps) {",
+		"<label htmlFor={`${id}-emailAddress`}>Email address</label>",
+		"          type=\"email\"\n          autoComplete=\"email\"\n          required\n          defaultValue={inputValue(initial?.emailAddress, \"text\")}",
+		"aria-describedby={describedBy(`${id}-nickname-hint`, errors.nickname !== undefined && `${id}-nickname-error`)}",
+		"defaultChecked={initial ? initial.active === true : true}",
+		"type=\"number\"\n          step=\"0.01\"\n          inputMode=\"decimal\"\n          min=\"0\"",
+		`<p className="form-error" role="alert">`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in output:\n%s", want, code)
+		}
+	}
+	// The API serves functions only, so records get no hooks of their own
+	for _, unwanted := range []string{"useGuestList", "createGuest", "GuestClient", "NightForm", "name=\"referrer\""} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("unexpected %q in output", unwanted)
+		}
+	}
+}
+
+func TestGenerateFileWithoutRecords(t *testing.T) {
+	f := testutil.CheckedFile(t, "define record Night no id\n    date: text")
+	out, err := GenerateFile(f, Options{})
+	if err != nil {
+		t.Fatalf("GenerateFile error: %v", err)
+	}
+	if code := string(out); strings.Contains(code, "import") || !strings.Contains(code, "export {};") {
+		t.Fatalf("expected an empty module:\n%s", code)
+	}
+}
+
+func TestGenerateFileFunctionsOnly(t *testing.T) {
+	f := testutil.CheckedFile(t, "function ping() returns boolean\n    why: \"Checks liveness\"\n    do:\n        return true")
+	out, err := GenerateFile(f, Options{ClientImport: "../client/ping"})
+	if err != nil {
+		t.Fatalf("GenerateFile error: %v", err)
+	}
+	code := string(out)
+	if !strings.Contains(code, "import { useCallback, useRef, useState } from \"react\";\nimport type { APIClient } from \"../client/ping\";\n") ||
+		!strings.Contains(code, "export function usePing(client: Pick<APIClient, \"ping\">) {") {
+		t.Fatalf("expected a hook for ping:\n%s", code)
+	}
+	for _, unwanted := range []string{"zod", "readForm", "FormField"} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("unexpected %q in output:\n%s", unwanted, code)
+		}
+	}
+}
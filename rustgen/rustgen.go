@@ -0,0 +1,272 @@
+// Package rustgen translates checked CloudPact records and models into Rust
+// structs deriving serde's Serialize and Deserialize, with chrono types for
+// dates and datetimes. Each .cp file becomes a module of one Rust module
+// tree, whose mod.rs re-exports every module's structs so records of
+// different files can name each other.
+package rustgen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Options tunes the generated code
+type Options struct {
+	// Header is the provenance comment the file starts with, usually
+	// codegen.Header; empty means none
+	Header string
+}
+
+// field is a struct field as Rust declares it
+type field struct {
+	name     string
+	json     string
+	t        *grammar.Type
+	optional bool // may be absent, as opposed to null
+	boxed    bool // a record containing, eventually, the struct itself
+	comment  string
+	doc      []string
+}
+
+// rustType is the type f is declared with
+func (f field) rustType() string {
+	t := *f.t
+	t.Nullable = false
+	rustType := FieldType(&t)
+	if f.boxed {
+		rustType = "Box<" + rustType + ">"
+	}
+	if f.t.Nullable || f.optional {
+		rustType = "Option<" + rustType + ">"
+	}
+	return rustType
+}
+
+// GenerateFile translates a checked file into a Rust module with a struct
+// per record and model. Field names are snake case and renamed to their
+// JSON keys for serde. Optional fields are skipped when None; "maybe"
+// fields are written as null. A record that extends another repeats the
+// base's fields, as structs do not inherit.
+func GenerateFile(file *grammar.File, opts Options) ([]byte, error) {
+	var body strings.Builder
+	cycles := recursiveFields(file)
+	declared := make(map[string]bool)
+	referenced := make(map[string]bool)
+	var types []string
+	for _, record := range file.Records {
+		declared[record.Name] = true
+		var fields []field
+		for _, f := range record.AllFields() {
+			fields = append(fields, field{
+				name:     fieldName(f.Name),
+				json:     f.JSONKey(),
+				t:        f.Type,
+				optional: f.Type.Optional && !f.Type.Nullable, // "maybe" fields are present, if only as null
+				boxed:    cycles[record.Name][f.Type.Name],
+				comment:  typeComment(f.Type),
+				doc:      codegen.DocLines(f.Leading, f.Trailing),
+			})
+		}
+		writeStruct(&body, record.Name, codegen.DocLines(record.Leading, record.Trailing), record.HasImplicitID(), record.IsVersioned(), fields)
+		types = append(types, fieldTypes(fields, referenced)...)
+	}
+	for _, model := range file.Models {
+		declared[model.Name] = true
+		var fields []field
+		for _, f := range model.Fields {
+			fields = append(fields, field{
+				name:     fieldName(f.Name),
+				json:     f.JSONKey(),
+				t:        f.Type,
+				optional: f.Type.Optional,
+				boxed:    cycles[model.Name][f.Type.Name],
+				doc:      codegen.DocLines(f.Leading, f.Trailing),
+			})
+		}
+		writeStruct(&body, model.Name, codegen.DocLines(model.Leading, model.Trailing), false, false, fields)
+		types = append(types, fieldTypes(fields, referenced)...)
+	}
+	for name := range referenced {
+		if declared[name] {
+			delete(referenced, name)
+		}
+	}
+
+	var code strings.Builder
+	code.WriteString("// Generated Rust types from CloudPact\n")
+	if file.Module != nil {
+		code.WriteString(fmt.Sprintf("// Module: %s\n", file.Module.Name))
+	}
+	code.WriteString("\n")
+	all := strings.Join(types, " ")
+	if strings.Contains(all, "DateTime<Utc>") && strings.Contains(all, "NaiveDate") {
+		code.WriteString("use chrono::{DateTime, NaiveDate, Utc};\n")
+	} else if strings.Contains(all, "DateTime<Utc>") {
+		code.WriteString("use chrono::{DateTime, Utc};\n")
+	} else if strings.Contains(all, "NaiveDate") {
+		code.WriteString("use chrono::NaiveDate;\n")
+	}
+	code.WriteString("use serde::{Deserialize, Serialize};\n")
+	if strings.Contains(all, "HashMap<") {
+		code.WriteString("use std::collections::HashMap;\n")
+	}
+	if len(referenced) > 0 {
+		// The records of the project's other files, re-exported by mod.rs
+		code.WriteString("\n#[allow(unused_imports)]\nuse super::*;\n")
+	}
+	code.WriteString(body.String())
+	return codegen.Stamp("//", opts.Header, []byte(code.String())), nil
+}
+
+// GenerateMod writes mod.rs, declaring the modules of the project's files
+// and re-exporting their structs
+func GenerateMod(modules []string, header string) []byte {
+	sorted := append([]string(nil), modules...)
+	sort.Strings(sorted)
+	var code strings.Builder
+	for _, module := range sorted {
+		code.WriteString(fmt.Sprintf("pub mod %s;\n", module))
+	}
+	if len(sorted) > 0 {
+		code.WriteString("\n")
+	}
+	for _, module := range sorted {
+		code.WriteString(fmt.Sprintf("pub use %s::*;\n", module))
+	}
+	return codegen.Stamp("//", header, []byte(code.String()))
+}
+
+// writeStruct writes a struct with the serde derives, the implicit id and
+// the version of a versioned record first
+func writeStruct(code *strings.Builder, name string, doc []string, implicitID, versioned bool, fields []field) {
+	code.WriteString("\n")
+	for _, line := range doc {
+		code.WriteString(strings.TrimRight("/// "+line, " ") + "\n")
+	}
+	code.WriteString("#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]\n")
+	code.WriteString(fmt.Sprintf("pub struct %s {\n", name))
+	if implicitID {
+		code.WriteString("    pub id: String, // UUID\n")
+	}
+	if versioned {
+		code.WriteString("    /// Increases with every update\n")
+		code.WriteString("    pub version: i64,\n")
+	}
+	for _, f := range fields {
+		for _, line := range f.doc {
+			code.WriteString(strings.TrimRight("    /// "+line, " ") + "\n")
+		}
+		var attrs []string
+		if strings.TrimPrefix(f.name, "r#") != f.json {
+			attrs = append(attrs, fmt.Sprintf("rename = %q", f.json))
+		}
+		if f.optional {
+			attrs = append(attrs, `default, skip_serializing_if = "Option::is_none"`)
+		}
+		if len(attrs) > 0 {
+			code.WriteString(fmt.Sprintf("    #[serde(%s)]\n", strings.Join(attrs, ", ")))
+		}
+		line := fmt.Sprintf("    pub %s: %s,", f.name, f.rustType())
+		if f.comment != "" {
+			line += " // " + f.comment
+		}
+		code.WriteString(line + "\n")
+	}
+	code.WriteString("}\n")
+}
+
+// fieldTypes lists the Rust types of fields, adding the records they name
+// to records
+func fieldTypes(fields []field, records map[string]bool) []string {
+	var types []string
+	for _, f := range fields {
+		types = append(types, f.rustType())
+		addRecords(f.t, records)
+	}
+	return types
+}
+
+// addRecords adds the records t names, in lists and maps too, to records
+func addRecords(t *grammar.Type, records map[string]bool) {
+	if t == nil {
+		return
+	}
+	if codegen.IsRecordTypeName(t.Name) {
+		records[t.Name] = true
+	}
+	addRecords(t.Element, records)
+	addRecords(t.Key, records)
+	addRecords(t.Value, records)
+}
+
+// recursiveFields finds the records and models of file that contain
+// themselves through fields of record types, not in lists or maps, which
+// would make them infinitely large. For each it gives the records its
+// fields must box.
+func recursiveFields(file *grammar.File) map[string]map[string]bool {
+	direct := make(map[string][]string)
+	for _, record := range file.Records {
+		for _, f := range record.AllFields() {
+			if codegen.IsRecordTypeName(f.Type.Name) {
+				direct[record.Name] = append(direct[record.Name], f.Type.Name)
+			}
+		}
+	}
+	for _, model := range file.Models {
+		for _, f := range model.Fields {
+			if codegen.IsRecordTypeName(f.Type.Name) {
+				direct[model.Name] = append(direct[model.Name], f.Type.Name)
+			}
+		}
+	}
+	reaches := func(from, to string) bool {
+		seen := make(map[string]bool)
+		var visit func(name string) bool
+		visit = func(name string) bool {
+			if name == to {
+				return true
+			}
+			if seen[name] {
+				return false
+			}
+			seen[name] = true
+			for _, next := range direct[name] {
+				if visit(next) {
+					return true
+				}
+			}
+			return false
+		}
+		return visit(from)
+	}
+
+	boxed := make(map[string]map[string]bool)
+	for name, refs := range direct {
+		for _, ref := range refs {
+			if reaches(ref, name) {
+				if boxed[name] == nil {
+					boxed[name] = make(map[string]bool)
+				}
+				boxed[name][ref] = true
+			}
+		}
+	}
+	return boxed
+}
+
+// typeComment describes a field's type, naming the custom type it was
+// declared with, as the TypeScript comments do
+func typeComment(t *grammar.Type) string {
+	comment := codegen.TypeComment(t.Name)
+	if t.Alias == "" {
+		return comment
+	}
+	if comment == "" {
+		return t.Alias
+	}
+	return t.Alias + ", " + comment
+}
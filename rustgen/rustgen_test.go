@@ -0,0 +1,90 @@
+package rustgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/internal/testutil"
+)
+
+func TestGenerateFile(t *testing.T) {
+	src := `module Fleet
+
+// A driver of the fleet
+define record Driver
+    emailAddress: email
+    nickname: text optional
+    birthday: date
+    mentor: maybe Driver
+    type: text
+
+define record Trip versioned
+    driver: Driver
+    fare: usd_currency
+    stops: map<text, int>
+    legs: list of Leg
+
+define record Charter extends Trip
+    client: text
+
+define record Leg no id
+    origin: text
+    minutes: int`
+	f := testutil.CheckedFile(t, src)
+	f.NameJSON("camel")
+
+	out, err := GenerateFile(f, Options{Header: "Code generated by cloudpact. DO NOT EDIT."})
+	if err != nil {
+		t.Fatalf("GenerateFile error: %v", err)
+	}
+	code := string(out)
+	for _, want := range []string{
+		"// Code generated by cloudpact. DO NOT EDIT.",
+		"// Module: Fleet",
+		"use chrono::NaiveDate;\nuse serde::{Deserialize, Serialize};\nuse std::collections::HashMap;\n",
+		"/// A driver of the fleet\n#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]\npub struct Driver {\n    pub id: String, // UUID\n",
+		"    #[serde(rename = \"emailAddress\")]\n    pub email_address: String, // Email address format\n",
+		"    #[serde(default, skip_serializing_if = \"Option::is_none\")]\n    pub nickname: Option<String>,\n",
+		"    pub birthday: NaiveDate,",
+		"    pub mentor: Option<Box<Driver>>,",
+		"    pub r#type: String,",
+		"    /// Increases with every update\n    pub version: i64,\n    pub driver: Driver,",
+		"    pub fare: f64,",
+		"    pub stops: HashMap<String, i64>,",
+		"    pub legs: Vec<Leg>,",
+		"pub struct Charter {\n    pub id: String, // UUID\n    /// Increases with every update\n    pub version: i64,\n    pub driver: Driver,",
+		"pub struct Leg {\n    pub origin: String,\n    pub minutes: i64,\n}",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in output:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "use super::*;") {
+		t.Error("a file naming only its own records needs no imports")
+	}
+	if strings.Contains(code, "rename = \"type\"") {
+		t.Error("serde names raw identifiers without r#")
+	}
+}
+
+func TestModuleName(t *testing.T) {
+	for source, want := range map[string]string{
+		"models/orders.cp":      "orders",
+		"models/order-lines.cp": "order_lines",
+		"OrderLines.cp":         "order_lines",
+		"type.cp":               "type_",
+		"2024.cp":               "m_2024",
+	} {
+		if got := ModuleName(source); got != want {
+			t.Errorf("ModuleName(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
+
+func TestGenerateMod(t *testing.T) {
+	mod := string(GenerateMod([]string{"orders", "customers"}, "Code generated by cloudpact. DO NOT EDIT."))
+	want := "// Code generated by cloudpact. DO NOT EDIT.\n\npub mod customers;\npub mod orders;\n\npub use customers::*;\npub use orders::*;\n"
+	if mod != want {
+		t.Fatalf("expected:\n%s\ngot:\n%s", want, mod)
+	}
+}
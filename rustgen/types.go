@@ -0,0 +1,108 @@
+package rustgen
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// rustKeywords are the strict and reserved keywords, which name a field
+// only as a raw identifier
+var rustKeywords = map[string]bool{
+	"as": true, "async": true, "await": true, "break": true, "const": true, "continue": true, "crate": true,
+	"dyn": true, "else": true, "enum": true, "extern": true, "false": true, "fn": true, "for": true,
+	"if": true, "impl": true, "in": true, "let": true, "loop": true, "match": true, "mod": true,
+	"move": true, "mut": true, "pub": true, "ref": true, "return": true, "self": true, "static": true,
+	"struct": true, "super": true, "trait": true, "true": true, "type": true, "unsafe": true, "use": true,
+	"where": true, "while": true, "abstract": true, "become": true, "box": true, "do": true,
+	"final": true, "macro": true, "override": true, "priv": true, "try": true, "typeof": true,
+	"unsized": true, "virtual": true, "yield": true,
+}
+
+// FieldType maps a CloudPact type to Rust. Dates and datetimes are chrono
+// types; UUIDs, times of day and durations stay strings. "maybe" types are
+// Options.
+func FieldType(t *grammar.Type) string {
+	if t.Nullable {
+		inner := *t
+		inner.Nullable = false
+		return "Option<" + FieldType(&inner) + ">"
+	}
+	if t.Name == "list" && t.Element != nil {
+		return "Vec<" + FieldType(t.Element) + ">"
+	}
+	if t.Name == "map" && t.Key != nil {
+		return fmt.Sprintf("HashMap<%s, %s>", FieldType(t.Key), FieldType(t.Value))
+	}
+
+	switch strings.ToLower(t.Name) {
+	case "int", "integer":
+		return "i64"
+	case "float", "number", "usd_currency", "eur_currency", "percentage":
+		return "f64"
+	case "bool", "boolean":
+		return "bool"
+	case "date":
+		return "NaiveDate"
+	case "datetime", "timestamp":
+		return "DateTime<Utc>"
+	}
+	if codegen.IsRecordTypeName(t.Name) {
+		return t.Name
+	}
+	return "String"
+}
+
+// fieldName writes a CloudPact field name the Rust way, customerEmail as
+// customer_email, as a raw identifier when it is a keyword
+func fieldName(name string) string {
+	snake := snakeCase(name)
+	switch {
+	case snake == "self" || snake == "super" || snake == "crate":
+		// These cannot be raw identifiers
+		return snake + "_"
+	case rustKeywords[snake]:
+		return "r#" + snake
+	}
+	return snake
+}
+
+// ModuleName is the Rust module of a .cp file: its base name in snake
+// case, with a trailing underscore when that is a keyword
+func ModuleName(sourcePath string) string {
+	base := strings.TrimSuffix(filepath.Base(sourcePath), ".cp")
+	var b strings.Builder
+	for _, r := range snakeCase(base) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "m_" + name
+	}
+	if rustKeywords[name] {
+		name += "_"
+	}
+	return name
+}
+
+// snakeCase lowercases name, separating its words with underscores
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,93 @@
+// Package asyncapi describes the events of a CloudPact file as an AsyncAPI
+// 3.0 document: a channel per event, the operations sending and receiving
+// it, and the records the events carry as JSON schemas.
+package asyncapi
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/spec/jsonschema"
+	"github.com/daveroberts0321/cloudpact/spec/openapi"
+)
+
+// Version is the AsyncAPI version of the generated documents
+const Version = "3.0.0"
+
+// Generate returns the AsyncAPI document of file's events as YAML. The
+// title, version and description come from config, the api section of
+// cloudpact.yaml the OpenAPI specs use.
+func Generate(file *grammar.File, config *openapi.APIConfig) (string, error) {
+	if file == nil {
+		return "", fmt.Errorf("nil file")
+	}
+	data, err := yaml.Marshal(buildDocument(file, config))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// buildDocument assembles the AsyncAPI document for file as nested maps
+func buildDocument(file *grammar.File, config *openapi.APIConfig) map[string]interface{} {
+	channels := make(map[string]interface{})
+	operations := make(map[string]interface{})
+	messages := make(map[string]interface{})
+
+	for _, event := range file.Events {
+		message := map[string]interface{}{
+			"name":        event.Name,
+			"contentType": "application/json",
+			"payload":     map[string]interface{}{"$ref": "#/components/schemas/" + event.Payload.Name},
+		}
+		if event.Why != "" {
+			message["summary"] = event.Why
+		}
+		if doc := codegen.DocLines(event.Leading, event.Trailing); len(doc) > 0 {
+			message["description"] = strings.Join(doc, "\n")
+		}
+		messages[event.Name] = message
+
+		channels[event.Name] = map[string]interface{}{
+			"address": event.ChannelName(),
+			"messages": map[string]interface{}{
+				event.Name: map[string]interface{}{"$ref": "#/components/messages/" + event.Name},
+			},
+		}
+
+		// The generated Go both publishes and subscribes
+		for _, action := range []string{"send", "receive"} {
+			operation := map[string]interface{}{
+				"action":  action,
+				"channel": map[string]interface{}{"$ref": "#/channels/" + event.Name},
+				"messages": []interface{}{
+					map[string]interface{}{"$ref": "#/channels/" + event.Name + "/messages/" + event.Name},
+				},
+			}
+			if event.Why != "" {
+				operation["summary"] = event.Why
+			}
+			operations[action+event.Name] = operation
+		}
+	}
+
+	return map[string]interface{}{
+		"asyncapi": Version,
+		"info": map[string]interface{}{
+			"title":       config.Title,
+			"version":     config.Version,
+			"description": config.Description,
+		},
+		"defaultContentType": "application/json",
+		"channels":           channels,
+		"operations":         operations,
+		"components": map[string]interface{}{
+			"messages": messages,
+			"schemas":  jsonschema.Schemas(file, "#/components/schemas/"),
+		},
+	}
+}
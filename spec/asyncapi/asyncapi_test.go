@@ -0,0 +1,56 @@
+package asyncapi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/spec/openapi"
+)
+
+func TestGenerate(t *testing.T) {
+	src := `define record User
+    email: email
+    nickname: maybe text
+
+// Sent once a user has signed up
+define event UserCreated
+    payload: User
+    why: "Lets other services greet new users"
+
+define event UserRenamed
+    payload: User
+    channel: "users.renamed"`
+	f, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(f); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	doc, err := Generate(f, openapi.DefaultAPIConfig())
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, want := range []string{
+		"asyncapi: 3.0.0",
+		"title: CloudPact API",
+		"address: user.created",
+		"address: users.renamed",
+		"$ref: '#/components/messages/UserCreated'",
+		"sendUserCreated:\n    action: send",
+		"receiveUserRenamed:\n    action: receive",
+		"- $ref: '#/channels/UserCreated/messages/UserCreated'",
+		"$ref: '#/components/schemas/User'",
+		"summary: Lets other services greet new users",
+		"description: Sent once a user has signed up",
+		"contentType: application/json",
+		"format: email",
+		"type:\n          - string\n          - \"null\"",
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("expected %q in document:\n%s", want, doc)
+		}
+	}
+}
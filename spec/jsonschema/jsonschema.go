@@ -0,0 +1,231 @@
+// Package jsonschema exports CloudPact records as standalone JSON Schema
+// (draft 2020-12) documents, for validating configuration and messages
+// outside of an OpenAPI spec.
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/spec/openapi"
+)
+
+// Dialect is the meta-schema every document declares
+const Dialect = "https://json-schema.org/draft/2020-12/schema"
+
+// formats are the semantic type formats JSON Schema defines; the others,
+// such as int32 and currency, only mean something to OpenAPI tooling
+var formats = map[string]bool{
+	"date":      true,
+	"date-time": true,
+	"duration":  true,
+	"email":     true,
+	"uri":       true,
+	"uuid":      true,
+}
+
+// Document is the schema of one record
+type Document struct {
+	Name   string
+	Schema map[string]interface{}
+}
+
+// FileName is the name the document is written under, <Record>.schema.json
+func (d *Document) FileName() string {
+	return d.Name + ".schema.json"
+}
+
+// JSON encodes the document, indented, with a trailing newline
+func (d *Document) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(d.Schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Generate returns a document per record of file, in declaration order.
+// Each stands alone: the records it refers to are under $defs, and a
+// record that extends another lists the fields of both.
+func Generate(file *grammar.File) []*Document {
+	records := make(map[string]*grammar.Record)
+	for _, record := range file.Records {
+		records[record.Name] = record
+	}
+	var docs []*Document
+	for _, record := range file.Records {
+		g := &generator{records: records, root: record.Name, defs: make(map[string]interface{})}
+		schema := g.recordSchema(record)
+		root := map[string]interface{}{
+			"$schema": Dialect,
+			"$id":     record.Name + ".schema.json",
+			"title":   record.Name,
+		}
+		for key, value := range schema {
+			root[key] = value
+		}
+		if len(g.defs) > 0 {
+			root["$defs"] = g.defs
+		}
+		docs = append(docs, &Document{Name: record.Name, Schema: root})
+	}
+	return docs
+}
+
+// Schemas returns the schema of every record of file by name. They refer
+// to each other as refPrefix followed by the record's name, for documents
+// that collect schemas in one place, such as the components of AsyncAPI.
+func Schemas(file *grammar.File, refPrefix string) map[string]interface{} {
+	g := &generator{records: make(map[string]*grammar.Record), refPrefix: refPrefix}
+	for _, record := range file.Records {
+		g.records[record.Name] = record
+	}
+	schemas := make(map[string]interface{})
+	for _, record := range file.Records {
+		schemas[record.Name] = g.recordSchema(record)
+	}
+	return schemas
+}
+
+// generator builds the schemas of records. A standalone document collects
+// the records it refers to under $defs; with a refPrefix they are referred
+// to where they are.
+type generator struct {
+	records   map[string]*grammar.Record
+	root      string // the record the document describes, referred to as #
+	defs      map[string]interface{}
+	refPrefix string
+}
+
+// recordSchema is the object schema of record
+func (g *generator) recordSchema(record *grammar.Record) map[string]interface{} {
+	props := make(map[string]interface{})
+	required := []interface{}{}
+	example := make(map[string]interface{})
+	for _, field := range codegen.ExampleFields(record) {
+		example[field.Key] = field.Value.Value
+	}
+
+	if record.HasImplicitID() {
+		props["id"] = map[string]interface{}{
+			"type":        "string",
+			"format":      "uuid",
+			"description": "Identifies the record",
+		}
+		required = append(required, "id")
+	}
+	for _, field := range record.AllFields() {
+		fieldSchema := g.typeSchema(field.Type)
+		props[field.JSONKey()] = fieldSchema
+		if value := example[field.JSONKey()]; value != nil {
+			fieldSchema["examples"] = []interface{}{value}
+		}
+		switch value := field.Default.(type) {
+		case *grammar.LiteralExpression:
+			fieldSchema["default"] = value.Value
+			continue
+		case *grammar.IdentifierExpression:
+			description := "Defaults to the time of creation"
+			if existing, ok := fieldSchema["description"].(string); ok && existing != "" {
+				description = existing + ", defaults to the time of creation"
+			}
+			fieldSchema["description"] = description
+			continue
+		}
+		// "maybe" fields are always sent, if only as null
+		if !field.Type.Optional || field.Type.Nullable {
+			required = append(required, field.JSONKey())
+		}
+	}
+	if record.IsVersioned() {
+		props["version"] = map[string]interface{}{
+			"type":        "integer",
+			"minimum":     0,
+			"description": "Increases with every update",
+		}
+		required = append(required, "version")
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+	if doc := codegen.DocLines(record.Leading, record.Trailing); len(doc) > 0 {
+		schema["description"] = strings.Join(doc, "\n")
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if len(example) > 0 {
+		schema["examples"] = []interface{}{example}
+	}
+	return schema
+}
+
+// typeSchema maps a CloudPact type to a schema, adding the records it
+// refers to to $defs
+func (g *generator) typeSchema(t *grammar.Type) map[string]interface{} {
+	// "maybe T" is T or null
+	if t.Nullable {
+		inner := *t
+		inner.Nullable = false
+		schema := g.typeSchema(&inner)
+		if baseType, ok := schema["type"].(string); ok {
+			schema["type"] = []interface{}{baseType, "null"}
+			return schema
+		}
+		return map[string]interface{}{
+			"anyOf": []interface{}{schema, map[string]interface{}{"type": "null"}},
+		}
+	}
+
+	if t.Name == "list" && t.Element != nil {
+		return map[string]interface{}{
+			"type":  "array",
+			"items": g.typeSchema(t.Element),
+		}
+	}
+
+	// The keys of JSON objects are strings, so whole-number keys are
+	// matched by pattern
+	if t.Name == "map" && t.Key != nil {
+		schema := map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": g.typeSchema(t.Value),
+		}
+		if openapi.SemanticSchema(t.Key)["type"] == "integer" {
+			schema["propertyNames"] = map[string]interface{}{"pattern": "^-?[0-9]+$"}
+		}
+		return schema
+	}
+
+	if record, ok := g.records[t.Name]; ok {
+		if g.refPrefix != "" {
+			return map[string]interface{}{"$ref": g.refPrefix + record.Name}
+		}
+		if record.Name == g.root {
+			return map[string]interface{}{"$ref": "#"}
+		}
+		if _, seen := g.defs[record.Name]; !seen {
+			g.defs[record.Name] = nil // guards against cycles
+			g.defs[record.Name] = g.recordSchema(record)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + record.Name}
+	}
+
+	// The semantic type's placeholder example and the OpenAPI-only formats
+	// and extensions would trip strict validators
+	schema := openapi.SemanticSchema(t)
+	delete(schema, "example")
+	if format, ok := schema["format"].(string); ok && !formats[format] {
+		delete(schema, "format")
+	}
+	for key := range schema {
+		if strings.HasPrefix(key, "x-") {
+			delete(schema, key)
+		}
+	}
+	return schema
+}
@@ -0,0 +1,103 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+func TestGenerate(t *testing.T) {
+	src := `// A customer of the shop
+define record Customer
+    email: email
+    nickname: maybe text
+    since: datetime default now
+    tags: list of text
+    address: Address optional
+    referrer: maybe Customer
+    example:
+        email: "ada@example.com"
+
+define record Address no id
+    street: text maxlength 80
+    country: country_code
+
+define record Order versioned
+    customer: Customer
+    total: usd_currency round: banker
+    lines: map<int, int>`
+	f, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(f); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	docs := Generate(f)
+	if len(docs) != 3 {
+		t.Fatalf("expected a document per record, got %d", len(docs))
+	}
+	encoded := make(map[string]string)
+	for _, doc := range docs {
+		data, err := doc.JSON()
+		if err != nil {
+			t.Fatalf("encoding %s: %v", doc.Name, err)
+		}
+		encoded[doc.FileName()] = string(data)
+	}
+
+	customer := encoded["Customer.schema.json"]
+	for _, want := range []string{
+		`"$schema": "https://json-schema.org/draft/2020-12/schema"`,
+		`"$id": "Customer.schema.json"`,
+		`"title": "Customer"`,
+		`"description": "A customer of the shop"`,
+		`"format": "email"`,
+		`"examples": [
+        "ada@example.com"
+      ]`,
+		`"type": [
+        "string",
+        "null"
+      ]`,
+		`"$ref": "#/$defs/Address"`,
+		`"maxLength": 80`,
+		`"anyOf"`,
+		`"$ref": "#"`,
+		`"required": [
+    "id",
+    "email",
+    "nickname",
+    "tags",
+    "referrer"
+  ]`,
+	} {
+		if !strings.Contains(customer, want) {
+			t.Errorf("expected %s in Customer schema:\n%s", want, customer)
+		}
+	}
+	for _, unwanted := range []string{`"example"`, `x-cloudpact`, `"nullable"`, `"sample value"`} {
+		if strings.Contains(customer, unwanted) {
+			t.Errorf("unexpected %s in Customer schema:\n%s", unwanted, customer)
+		}
+	}
+
+	order := encoded["Order.schema.json"]
+	for _, want := range []string{
+		`"$ref": "#/$defs/Customer"`,
+		`"Address": {`,
+		`"multipleOf": 0.01`,
+		`"pattern": "^-?[0-9]+$"`,
+		`"version": {`,
+	} {
+		if !strings.Contains(order, want) {
+			t.Errorf("expected %s in Order schema:\n%s", want, order)
+		}
+	}
+	if strings.Contains(order, `"format": "currency"`) {
+		t.Errorf("OpenAPI-only formats should be left out:\n%s", order)
+	}
+}
@@ -0,0 +1,736 @@
+package openapi
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// importFormats map the string formats of OpenAPI to semantic types
+var importFormats = map[string]string{
+	"email":     "email",
+	"uuid":      "uuid",
+	"date":      "date",
+	"date-time": "datetime",
+	"time":      "time",
+	"duration":  "duration",
+	"uri":       "url",
+	"url":       "url",
+	"password":  "password",
+}
+
+// importMethods are the operations of a path item, in the order they are
+// imported
+var importMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// headerName matches the header names a header clause can declare
+var headerName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(-([A-Za-z_][A-Za-z0-9_]*|[0-9]+))*$`)
+
+// reservedNames start declarations, so fields and parameters cannot take
+// them as names
+var reservedNames = map[string]bool{"module": true, "define": true, "function": true, "model": true, "test": true}
+
+// Import translates an OpenAPI 3.0 or 3.1 document, in YAML or JSON, into
+// the source of a CloudPact file declaring module, or a module named after
+// the spec's title when module is empty. Each object schema of
+// its components becomes a record, and each operation a function stub that
+// fails until it is written. Descriptions become comments, and what
+// CloudPact cannot express, such as enums and patterns, is noted in them.
+func Import(data []byte, module string) (string, error) {
+	var raw yaml.MapSlice
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return "", fmt.Errorf("failed to parse spec: %w", err)
+	}
+	doc := ordered(raw).(*omap)
+	if version, _ := doc.get("openapi").(string); !strings.HasPrefix(version, "3.") {
+		return "", fmt.Errorf("not an OpenAPI 3 document: openapi is %q", version)
+	}
+
+	if module == "" {
+		title, _ := doc.obj("info").get("title").(string)
+		if module = toPascal(title); module == "" {
+			module = "Imported"
+		}
+	}
+
+	imp := &importer{
+		doc:       doc,
+		names:     make(map[string]string),
+		taken:     make(map[string]bool),
+		functions: make(map[string]bool),
+	}
+	imp.importSchemas()
+	imp.importOperations()
+	return imp.source(module), nil
+}
+
+// omap is a YAML mapping that remembers the order of its keys
+type omap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *omap) get(key string) interface{} {
+	if m == nil {
+		return nil
+	}
+	return m.values[key]
+}
+
+// obj returns the mapping at key, or nil
+func (m *omap) obj(key string) *omap {
+	o, _ := m.get(key).(*omap)
+	return o
+}
+
+// ordered converts decoded YAML into omaps and slices
+func ordered(v interface{}) interface{} {
+	switch val := v.(type) {
+	case yaml.MapSlice:
+		m := &omap{values: make(map[string]interface{}, len(val))}
+		for _, item := range val {
+			key := fmt.Sprint(item.Key)
+			m.keys = append(m.keys, key)
+			m.values[key] = ordered(item.Value)
+		}
+		return m
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = ordered(item)
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+// importer collects the declarations of the CloudPact file
+type importer struct {
+	doc       *omap
+	names     map[string]string // component schema name to record name
+	taken     map[string]bool   // record names in use
+	records   []*importedRecord
+	functions map[string]bool
+	stubs     []string
+}
+
+// importedRecord is a record about to be written
+type importedRecord struct {
+	name    string
+	extends string
+	noID    bool
+	doc     []string
+	fields  []string
+}
+
+// importSchemas declares a record for each object schema of the
+// components, in the order the spec lists them
+func (imp *importer) importSchemas() {
+	schemas := imp.doc.obj("components").obj("schemas")
+	if schemas == nil {
+		return
+	}
+	// Names are reserved first, so references resolve in any order
+	for _, name := range schemas.keys {
+		if isObjectSchema(schemas.obj(name)) {
+			imp.names[name] = imp.recordName(name)
+		}
+	}
+	// The Go of a record declares a New<Record> constructor, so a record
+	// named like one, such as NewPet beside Pet, takes another name
+	for _, name := range schemas.keys {
+		if record, ok := imp.names[name]; ok && strings.HasPrefix(record, "New") && imp.taken[record[3:]] {
+			delete(imp.taken, record)
+			imp.names[name] = imp.recordName(record + "Input")
+		}
+	}
+	for _, name := range schemas.keys {
+		if record, ok := imp.names[name]; ok {
+			imp.addRecord(record, schemas.obj(name))
+		}
+	}
+}
+
+// isObjectSchema reports whether a schema describes an object with fields
+func isObjectSchema(schema *omap) bool {
+	if schema == nil {
+		return false
+	}
+	if schema.get("properties") != nil || schema.get("allOf") != nil {
+		return true
+	}
+	return schema.get("type") == "object" && schema.get("additionalProperties") == nil
+}
+
+// recordName turns name into a free record name
+func (imp *importer) recordName(name string) string {
+	base := toPascal(name)
+	if base == "" {
+		base = "Record"
+	}
+	candidate := base
+	for i := 2; imp.taken[candidate]; i++ {
+		candidate = fmt.Sprintf("%s%d", base, i)
+	}
+	imp.taken[candidate] = true
+	return candidate
+}
+
+// addRecord declares record from an object schema. An allOf whose first
+// member refers to another record extends it; the other members' fields
+// are merged in.
+func (imp *importer) addRecord(name string, schema *omap) {
+	record := &importedRecord{name: name, doc: descriptionLines(schema)}
+	imp.records = append(imp.records, record)
+
+	members := []*omap{schema}
+	if allOf, ok := schema.get("allOf").([]interface{}); ok {
+		members = nil
+		for i, item := range allOf {
+			member, _ := item.(*omap)
+			if ref, ok := member.get("$ref").(string); ok && i == 0 {
+				if base, ok := imp.names[refName(ref)]; ok {
+					record.extends = base
+					continue
+				}
+			}
+			if ref, ok := member.get("$ref").(string); ok {
+				member = imp.doc.obj("components").obj("schemas").obj(refName(ref))
+			}
+			members = append(members, member)
+		}
+		members = append(members, schema)
+	}
+
+	hasID := false
+	declared := make(map[string]bool)
+	for _, member := range members {
+		required := make(map[string]bool)
+		if list, ok := member.get("required").([]interface{}); ok {
+			for _, item := range list {
+				required[fmt.Sprint(item)] = true
+			}
+		}
+		props := member.obj("properties")
+		if props == nil {
+			continue
+		}
+		for _, key := range props.keys {
+			field := fieldName(key)
+			if declared[field] {
+				continue
+			}
+			declared[field] = true
+			hasID = hasID || field == "id"
+			record.fields = append(record.fields, imp.field(name, field, props.obj(key), required[key])...)
+		}
+	}
+	// The API's objects are identified by their own fields, if at all
+	record.noID = !hasID && record.extends == ""
+}
+
+// field writes the lines declaring a record field: its comments and the
+// field itself
+func (imp *importer) field(record, name string, schema *omap, required bool) []string {
+	cpType, nullable, notes := imp.typeOf(schema, record+toPascal(name))
+	var lines []string
+	for _, line := range append(descriptionLines(schema), notes...) {
+		lines = append(lines, "// "+line)
+	}
+
+	// A field with a default is never absent, so it cannot be optional;
+	// a nullable one keeps maybe and loses the default
+	def := defaultValue(cpType, schema.get("default"))
+	decl := name + ": "
+	switch {
+	case nullable:
+		decl += "maybe " + cpType
+		def = ""
+	case !required && def == "":
+		decl += cpType + " optional"
+	default:
+		decl += cpType
+	}
+	decl += constraints(cpType, schema)
+	if def != "" {
+		decl += " default " + def
+	}
+	return append(lines, decl)
+}
+
+// typeOf maps a schema to a CloudPact type. Inline objects become records
+// named context. The notes say what the type leaves out.
+func (imp *importer) typeOf(schema *omap, context string) (cpType string, nullable bool, notes []string) {
+	if schema == nil {
+		return "text", false, nil
+	}
+	if marked, _ := schema.get("nullable").(bool); marked {
+		defer func() { nullable = true }()
+	}
+
+	if ref, ok := schema.get("$ref").(string); ok {
+		if record, ok := imp.names[refName(ref)]; ok {
+			return record, false, nil
+		}
+		target := imp.doc.obj("components").obj("schemas").obj(refName(ref))
+		if target == nil || !strings.HasPrefix(ref, "#/components/schemas/") {
+			return "text", false, []string{"refers to " + ref + " in the spec"}
+		}
+		return imp.typeOf(target, context)
+	}
+	for _, keyword := range []string{"oneOf", "anyOf"} {
+		if options, ok := schema.get(keyword).([]interface{}); ok {
+			var names []string
+			for _, option := range options {
+				if ref, ok := option.(*omap).get("$ref").(string); ok {
+					names = append(names, refName(ref))
+				}
+			}
+			note := fmt.Sprintf("%s in the spec", keyword)
+			if len(names) > 0 {
+				note = fmt.Sprintf("%s %s in the spec", keyword, strings.Join(names, ", "))
+			}
+			return "text", false, []string{note}
+		}
+	}
+	if allOf, ok := schema.get("allOf").([]interface{}); ok && len(allOf) == 1 {
+		if member, ok := allOf[0].(*omap); ok {
+			return imp.typeOf(member, context)
+		}
+	}
+
+	// OpenAPI 3.1 writes nullable types as a list: [string, "null"]
+	typeName, _ := schema.get("type").(string)
+	if types, ok := schema.get("type").([]interface{}); ok {
+		for _, t := range types {
+			if t == "null" {
+				nullable = true
+			} else if typeName == "" {
+				typeName = fmt.Sprint(t)
+			}
+		}
+	}
+	if enum, ok := schema.get("enum").([]interface{}); ok {
+		var values []string
+		for _, value := range enum {
+			if value != nil {
+				values = append(values, fmt.Sprint(value))
+			}
+		}
+		notes = append(notes, "one of: "+strings.Join(values, ", "))
+	}
+	if pattern, ok := schema.get("pattern").(string); ok {
+		notes = append(notes, "matches "+pattern)
+	}
+
+	switch {
+	case typeName == "integer":
+		return "int", nullable, notes
+	case typeName == "number":
+		return "number", nullable, notes
+	case typeName == "boolean":
+		return "boolean", nullable, notes
+	case typeName == "array":
+		element, _, elementNotes := imp.typeOf(schema.obj("items"), context+"Item")
+		return "list of " + element, nullable, append(notes, elementNotes...)
+	case schema.get("properties") != nil:
+		record := imp.recordName(context)
+		imp.addRecord(record, schema)
+		return record, nullable, notes
+	case typeName == "object" || schema.get("additionalProperties") != nil:
+		values, ok := schema.get("additionalProperties").(*omap)
+		if !ok {
+			return "map of text to text", nullable, append(notes, "free-form object in the spec")
+		}
+		value, _, valueNotes := imp.typeOf(values, context+"Value")
+		return "map of text to " + value, nullable, append(notes, valueNotes...)
+	}
+	format, _ := schema.get("format").(string)
+	if semantic, ok := importFormats[format]; ok {
+		return semantic, nullable, notes
+	}
+	return "text", nullable, notes
+}
+
+// constraints writes the bounds of schema that apply to cpType
+func constraints(cpType string, schema *omap) string {
+	var b strings.Builder
+	kind := analyzer.KindOf(&grammar.Type{Name: cpType})
+	keywords := [][2]string{{"minimum", grammar.ConstraintMin}, {"maximum", grammar.ConstraintMax}}
+	if kind == analyzer.KindText {
+		keywords = [][2]string{{"minLength", grammar.ConstraintMinLength}, {"maxLength", grammar.ConstraintMaxLength}}
+	} else if kind != analyzer.KindNumber {
+		return ""
+	}
+	for _, keyword := range keywords {
+		if value, ok := number(schema.get(keyword[0])); ok {
+			b.WriteString(" " + keyword[1] + " " + strconv.FormatFloat(value, 'f', -1, 64))
+		}
+	}
+	return b.String()
+}
+
+// defaultValue writes a schema default as a CloudPact literal, or "" when
+// the type cannot take it
+func defaultValue(cpType string, value interface{}) string {
+	switch analyzer.KindOf(&grammar.Type{Name: cpType}) {
+	case analyzer.KindText:
+		if text, ok := value.(string); ok {
+			return strconv.Quote(text)
+		}
+	case analyzer.KindNumber:
+		// The parser reads no sign before a default
+		if n, ok := number(value); ok && n >= 0 {
+			return strconv.FormatFloat(n, 'f', -1, 64)
+		}
+	case analyzer.KindBoolean:
+		if b, ok := value.(bool); ok {
+			return strconv.FormatBool(b)
+		}
+	}
+	return ""
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// importOperations declares a function stub for each operation, in the
+// order of the paths
+func (imp *importer) importOperations() {
+	paths := imp.doc.obj("paths")
+	if paths == nil {
+		return
+	}
+	for _, path := range paths.keys {
+		item := paths.obj(path)
+		for _, method := range importMethods {
+			if operation := item.obj(method); operation != nil {
+				imp.addFunction(path, method, item, operation)
+			}
+		}
+	}
+}
+
+// addFunction declares the stub of one operation. Path and query
+// parameters and the request body become parameters, header parameters
+// header clauses, and the first 2xx response with content the return type.
+func (imp *importer) addFunction(path, method string, item, operation *omap) {
+	name := imp.functionName(path, method, operation)
+	context := toPascal(name)
+
+	var params, headers []string
+	paramNames := make(map[string]bool)
+	var parameters []interface{}
+	if list, ok := item.get("parameters").([]interface{}); ok {
+		parameters = append(parameters, list...)
+	}
+	if list, ok := operation.get("parameters").([]interface{}); ok {
+		parameters = append(parameters, list...)
+	}
+	for _, p := range parameters {
+		param, _ := p.(*omap)
+		if ref, ok := param.get("$ref").(string); ok {
+			param = imp.doc.obj("components").obj("parameters").obj(refName(ref))
+		}
+		paramName, _ := param.get("name").(string)
+		if paramName == "" {
+			continue
+		}
+		required, _ := param.get("required").(bool)
+		switch param.get("in") {
+		case "header":
+			if !headerName.MatchString(paramName) {
+				continue
+			}
+			direction := "optional"
+			if required {
+				direction = "required"
+			}
+			headers = append(headers, "header: "+paramName+" "+direction)
+		case "path", "query":
+			cpType, nullable, _ := imp.typeOf(param.obj("schema"), context+toPascal(paramName))
+			field := fieldName(paramName)
+			if paramNames[field] {
+				continue
+			}
+			paramNames[field] = true
+			if nullable || !required {
+				cpType = "maybe " + cpType
+			}
+			params = append(params, field+": "+cpType)
+		}
+	}
+
+	if body := operation.obj("requestBody"); body != nil {
+		if ref, ok := body.get("$ref").(string); ok {
+			body = imp.doc.obj("components").obj("requestBodies").obj(refName(ref))
+		}
+		if schema := contentSchema(body); schema != nil {
+			cpType, nullable, _ := imp.typeOf(schema, context+"Request")
+			field := "body"
+			if analyzer.KindOf(&grammar.Type{Name: cpType}) == analyzer.KindRecord {
+				field = toCamel(cpType)
+			}
+			for paramNames[field] {
+				field += "Body"
+			}
+			if required, _ := body.get("required").(bool); nullable || !required {
+				cpType = "maybe " + cpType
+			}
+			params = append(params, field+": "+cpType)
+		}
+	}
+
+	returns := ""
+	if responses := operation.obj("responses"); responses != nil {
+		for _, status := range responses.keys {
+			if !strings.HasPrefix(status, "2") {
+				continue
+			}
+			response := responses.obj(status)
+			if ref, ok := response.get("$ref").(string); ok {
+				response = imp.doc.obj("components").obj("responses").obj(refName(ref))
+			}
+			if schema := contentSchema(response); schema != nil {
+				cpType, nullable, _ := imp.typeOf(schema, context+"Response")
+				if nullable {
+					cpType = "maybe " + cpType
+				}
+				returns = " returns " + cpType
+				break
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, line := range descriptionLines(operation) {
+		b.WriteString("// " + line + "\n")
+	}
+	b.WriteString(fmt.Sprintf("function %s(%s)%s\n", name, strings.Join(params, ", "), returns))
+	for _, header := range headers {
+		b.WriteString("    " + header + "\n")
+	}
+	if imp.secured(operation) {
+		b.WriteString("    requires auth\n")
+	}
+	why, _ := operation.get("summary").(string)
+	if why == "" {
+		why = fmt.Sprintf("Serves %s %s", strings.ToUpper(method), path)
+	}
+	b.WriteString("    why: \"" + whyText(why) + "\"\n")
+	b.WriteString("    do:\n")
+	b.WriteString("        fail internal \"not implemented\"\n")
+	imp.stubs = append(imp.stubs, b.String())
+}
+
+// functionName is the operationId in camel case, or the method and path
+// words, made unique
+func (imp *importer) functionName(path, method string, operation *omap) string {
+	base := ""
+	if id, ok := operation.get("operationId").(string); ok {
+		base = toCamel(id)
+	}
+	if base == "" {
+		base = method
+		for _, segment := range strings.Split(path, "/") {
+			if strings.HasPrefix(segment, "{") {
+				base += "By" + toPascal(strings.Trim(segment, "{}"))
+			} else {
+				base += toPascal(segment)
+			}
+		}
+	}
+	if reservedNames[base] {
+		base += "Operation"
+	}
+	name := base
+	for i := 2; imp.functions[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	imp.functions[name] = true
+	return name
+}
+
+// secured reports whether the operation requires authentication: its own
+// security, or else the document's, lists a requirement
+func (imp *importer) secured(operation *omap) bool {
+	security, ok := operation.get("security").([]interface{})
+	if !ok {
+		security, _ = imp.doc.get("security").([]interface{})
+	}
+	for _, requirement := range security {
+		if m, ok := requirement.(*omap); ok && len(m.keys) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// contentSchema is the schema of a body's JSON content, or of its first
+// content type
+func contentSchema(body *omap) *omap {
+	content := body.obj("content")
+	if content == nil {
+		return nil
+	}
+	for _, mediaType := range content.keys {
+		if strings.Contains(mediaType, "json") {
+			return content.obj(mediaType).obj("schema")
+		}
+	}
+	if len(content.keys) > 0 {
+		return content.obj(content.keys[0]).obj("schema")
+	}
+	return nil
+}
+
+// source writes the CloudPact file
+func (imp *importer) source(module string) string {
+	var b strings.Builder
+	title, _ := imp.doc.obj("info").get("title").(string)
+	if title != "" {
+		b.WriteString("// Imported from the OpenAPI spec " + strconv.Quote(title) + "\n")
+	}
+	b.WriteString("module " + module + "\n")
+
+	for _, record := range imp.records {
+		b.WriteString("\n")
+		for _, line := range record.doc {
+			b.WriteString("// " + line + "\n")
+		}
+		b.WriteString("define record " + record.name)
+		if record.extends != "" {
+			b.WriteString(" extends " + record.extends)
+		}
+		if record.noID {
+			b.WriteString(" no id")
+		}
+		b.WriteString("\n")
+		for _, line := range record.fields {
+			b.WriteString("    " + line + "\n")
+		}
+	}
+	for _, stub := range imp.stubs {
+		b.WriteString("\n" + stub)
+	}
+	return b.String()
+}
+
+// descriptionLines are the lines of a schema or operation's description
+func descriptionLines(m *omap) []string {
+	description, _ := m.get("description").(string)
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(description), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// whyText fits text into a why clause, which holds one line without quotes
+func whyText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	return strings.NewReplacer(`"`, "'", `\`, "/").Replace(text)
+}
+
+// refName is the last segment of a $ref, the name of what it refers to
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+// fieldName makes name a CloudPact identifier, keeping it as written where
+// it already is one
+func fieldName(name string) string {
+	if !isIdentifier(name) {
+		name = toCamel(name)
+	}
+	if name == "" {
+		name = "value"
+	}
+	if reservedNames[name] {
+		name += "Value"
+	}
+	return name
+}
+
+func isIdentifier(name string) bool {
+	for i, r := range name {
+		if !(unicode.IsLetter(r) || r == '_' || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// words splits a name at anything but letters and digits, and at case
+// changes
+func words(name string) []string {
+	var result []string
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		start := 0
+		runes := []rune(part)
+		for i := 1; i <= len(runes); i++ {
+			// a lower-case letter or digit ends a word, and so does an
+			// acronym followed by a capitalized word
+			if i == len(runes) || (unicode.IsUpper(runes[i]) &&
+				(!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1])))) {
+				result = append(result, string(runes[start:i]))
+				start = i
+			}
+		}
+	}
+	return result
+}
+
+// toPascal joins the words of name, each capitalized
+func toPascal(name string) string {
+	var b strings.Builder
+	for _, word := range words(name) {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	result := b.String()
+	if result != "" && unicode.IsDigit(rune(result[0])) {
+		result = "N" + result
+	}
+	return result
+}
+
+// toCamel is toPascal with the first word lower case
+func toCamel(name string) string {
+	pascal := toPascal(name)
+	if pascal == "" {
+		return ""
+	}
+	// A leading acronym is lowered whole: HTTPServer gives httpServer
+	runes := []rune(pascal)
+	i := 0
+	for i < len(runes) && unicode.IsUpper(runes[i]) {
+		i++
+	}
+	if i > 1 && i < len(runes) {
+		i--
+	}
+	return strings.ToLower(string(runes[:i])) + string(runes[i:])
+}
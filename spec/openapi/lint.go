@@ -0,0 +1,224 @@
+package openapi
+
+import (
+	_ "embed"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// SpectralRuleset is a Spectral ruleset with the checks Lint applies under
+// the default json_names, for teams running Spectral on the generated specs;
+// SpectralRulesetFor adapts it to the others
+//
+//go:embed spectral.yaml
+var SpectralRuleset string
+
+// propertyCasingRule is the rule field names in a spec follow, which
+// depends on how the json_names policy turns them into keys
+type propertyCasingRule struct {
+	name        string
+	description string
+	casing      string // the Spectral casing type
+	display     string // as messages name it
+	pattern     *regexp.Regexp
+}
+
+var (
+	camelCaseProperties = &propertyCasingRule{"cloudpact-property-camel-case", "Fields are named in camelCase", "camel", "camelCase", regexp.MustCompile(`^[a-z][a-zA-Z0-9]*$`)}
+	snakeCaseProperties = &propertyCasingRule{"cloudpact-property-snake-case", "Fields are named in snake_case", "snake", "snake_case", regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)}
+)
+
+// propertyCasing is the casing rule of properties under a json_names
+// policy. Keys kept as written follow no casing, so it is nil for those.
+func propertyCasing(jsonNames string) *propertyCasingRule {
+	switch jsonNames {
+	case grammar.JSONSnake:
+		return snakeCaseProperties
+	case grammar.JSONAsWritten:
+		return nil
+	}
+	return camelCaseProperties
+}
+
+// SpectralRulesetFor is SpectralRuleset with the property casing rule of
+// the json_names policy jsonNames
+func SpectralRulesetFor(jsonNames string) string {
+	start := strings.Index(SpectralRuleset, "  "+camelCaseProperties.name+":\n")
+	end := strings.Index(SpectralRuleset, "  cloudpact-money-rounding:\n")
+	rule := propertyCasing(jsonNames)
+	if rule == camelCaseProperties || start < 0 || end < start {
+		return SpectralRuleset
+	}
+	var block string
+	if rule != nil {
+		block = fmt.Sprintf("  %s:\n    description: %s\n    severity: warn\n    given: \"$.components.schemas[?(@property != 'Error')].properties\"\n    then:\n      field: \"@key\"\n      function: casing\n      functionOptions:\n        type: %s\n", rule.name, rule.description, rule.casing)
+	}
+	return SpectralRuleset[:start] + block + SpectralRuleset[end:]
+}
+
+// Lint severities, named as in Spectral
+const (
+	SeverityError = "error"
+	SeverityWarn  = "warn"
+)
+
+// Violation is a lint finding in the spec generated for a file
+type Violation struct {
+	Rule     string
+	Severity string
+	Message  string
+	Path     string            // JSON pointer into the spec, e.g. /paths/~1orders/get
+	Source   *grammar.Position // the declaration the spec element came from, if known
+}
+
+func (v Violation) String() string {
+	location := "spec"
+	if v.Source != nil {
+		location = v.Source.String()
+	}
+	return fmt.Sprintf("%s: %s %s: %s (at %s)", location, v.Severity, v.Rule, v.Message, v.Path)
+}
+
+var (
+	lowercasePath = regexp.MustCompile(`^(/[a-z0-9{}.-]*)+$`)
+	pascalCase    = regexp.MustCompile(`^[A-Z][a-zA-Z0-9]*$`)
+)
+
+var operationMethods = []string{"get", "put", "post", "patch", "delete"}
+
+// Lint generates the spec for file, with its fields named by the
+// json_names policy jsonNames, and checks it against the rules of
+// SpectralRulesetFor(jsonNames), pointing each violation at the declaration
+// behind it
+func Lint(file *grammar.File, jsonNames string) []Violation {
+	file.NameJSON(jsonNames)
+	doc := buildDocument(file, DefaultAPIConfig())
+	sources := newSourceIndex(file)
+	casing := propertyCasing(jsonNames)
+	var violations []Violation
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	for _, path := range sortedKeys(paths) {
+		pointer := "/paths/" + escapePointer(path)
+		source := sources.path(path)
+		if !lowercasePath.MatchString(path) {
+			violations = append(violations, Violation{"cloudpact-path-lowercase", SeverityWarn,
+				fmt.Sprintf("path %s should be lower case with words separated by -", path), pointer, source})
+		}
+
+		operations, _ := paths[path].(map[string]interface{})
+		for _, method := range operationMethods {
+			operation, ok := operations[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			opPointer := pointer + "/" + method
+			if description, _ := operation["description"].(string); strings.TrimSpace(description) == "" {
+				violations = append(violations, Violation{"cloudpact-operation-description", SeverityWarn,
+					fmt.Sprintf("%s %s has no description", strings.ToUpper(method), path), opPointer, source})
+			}
+			if !hasSuccessResponse(operation) {
+				violations = append(violations, Violation{"operation-success-response", SeverityError,
+					fmt.Sprintf("%s %s declares no 2xx response", strings.ToUpper(method), path), opPointer + "/responses", source})
+			}
+		}
+	}
+
+	schemas, _ := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, name := range sortedKeys(schemas) {
+		pointer := "/components/schemas/" + escapePointer(name)
+		if !pascalCase.MatchString(name) {
+			violations = append(violations, Violation{"cloudpact-schema-pascal-case", SeverityWarn,
+				fmt.Sprintf("%s should be PascalCase", name), pointer, sources.schemas[name]})
+		}
+
+		schema, _ := schemas[name].(map[string]interface{})
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, property := range sortedKeys(properties) {
+			propPointer := pointer + "/properties/" + escapePointer(property)
+			source := sources.fields[name+"."+property]
+			// Schemas CloudPact declares itself, such as Error, keep their
+			// keys whatever json_names says
+			if casing != nil && sources.schemas[name] != nil && !casing.pattern.MatchString(property) {
+				violations = append(violations, Violation{casing.name, SeverityWarn,
+					fmt.Sprintf("field %s of %s should be %s", property, name, casing.display), propPointer, source})
+			}
+			prop, _ := properties[property].(map[string]interface{})
+			if prop["format"] == "currency" && prop["x-cloudpact-rounding"] == nil {
+				violations = append(violations, Violation{"cloudpact-money-rounding", SeverityWarn,
+					fmt.Sprintf("currency field %s of %s has no rounding mode; add round: banker or round: half-up", property, name), propPointer, source})
+			}
+		}
+	}
+
+	return violations
+}
+
+func hasSuccessResponse(operation map[string]interface{}) bool {
+	responses, _ := operation["responses"].(map[string]interface{})
+	for code := range responses {
+		if strings.HasPrefix(code, "2") {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceIndex finds the declarations spec elements were generated from
+type sourceIndex struct {
+	schemas   map[string]*grammar.Position // schema name -> record or model
+	fields    map[string]*grammar.Position // "Schema.key" -> field, by its JSON key
+	resources map[string]*grammar.Position // first path segment -> declaration
+}
+
+func newSourceIndex(file *grammar.File) *sourceIndex {
+	index := &sourceIndex{
+		schemas:   make(map[string]*grammar.Position),
+		fields:    make(map[string]*grammar.Position),
+		resources: make(map[string]*grammar.Position),
+	}
+	for _, record := range file.Records {
+		index.schemas[record.Name] = record.Position
+		index.resources[strings.ToLower(record.Name)+"s"] = record.Position
+		for _, field := range record.Fields {
+			index.fields[record.Name+"."+field.JSONKey()] = field.Position
+		}
+	}
+	for _, model := range file.Models {
+		index.schemas[model.Name] = model.Position
+		index.schemas[model.Name+"Patch"] = model.Position
+		index.resources[strings.ToLower(model.Name)+"s"] = model.Position
+		for _, field := range model.Fields {
+			index.fields[model.Name+"."+field.JSONKey()] = field.Position
+			index.fields[model.Name+"Patch."+field.JSONKey()] = field.Position
+		}
+	}
+	for _, function := range file.Functions {
+		index.resources[strings.ToLower(function.Name)] = function.Position
+	}
+	return index
+}
+
+// path finds the declaration behind a path such as /orders/{id}
+func (s *sourceIndex) path(path string) *grammar.Position {
+	segment := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	return s.resources[segment]
+}
+
+// escapePointer escapes a JSON pointer token
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
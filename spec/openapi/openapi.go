@@ -3,11 +3,14 @@ package openapi
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
 
-	"gopkg.in/yaml.v2"
-
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/config"
 	"github.com/daveroberts0321/cloudpact/parser/grammar"
 )
 
@@ -17,6 +20,9 @@ type APIConfig struct {
 	Version     string `yaml:"version"`
 	Description string `yaml:"description"`
 	ServerURL   string `yaml:"server_url"`
+	// Auth declares the security schemes; without any, functions with
+	// requires clauses take a bearer token
+	Auth config.Auth `yaml:"auth"`
 }
 
 // DefaultAPIConfig provides sensible defaults
@@ -29,44 +35,30 @@ func DefaultAPIConfig() *APIConfig {
 	}
 }
 
-// LoadAPIConfig attempts to load API configuration from cloudpact.yaml
+// LoadAPIConfig reads the api section of cloudpact.yaml, keeping the
+// defaults for settings it leaves out
 func LoadAPIConfig(configPath string) (*APIConfig, error) {
-	config := DefaultAPIConfig()
-
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return config, nil // Use defaults if no config file
-	}
+	apiConfig := DefaultAPIConfig()
 
-	data, err := os.ReadFile(configPath)
+	cfg, err := config.Load(configPath)
 	if err != nil {
-		return config, err
+		return apiConfig, err
 	}
 
-	var projectConfig struct {
-		API *APIConfig `yaml:"api"`
+	if cfg.API.Title != "" {
+		apiConfig.Title = cfg.API.Title
 	}
-
-	if err := yaml.Unmarshal(data, &projectConfig); err != nil {
-		return config, err
+	if cfg.API.Version != "" {
+		apiConfig.Version = cfg.API.Version
 	}
-
-	if projectConfig.API != nil {
-		// Merge with defaults
-		if projectConfig.API.Title != "" {
-			config.Title = projectConfig.API.Title
-		}
-		if projectConfig.API.Version != "" {
-			config.Version = projectConfig.API.Version
-		}
-		if projectConfig.API.Description != "" {
-			config.Description = projectConfig.API.Description
-		}
-		if projectConfig.API.ServerURL != "" {
-			config.ServerURL = projectConfig.API.ServerURL
-		}
+	if cfg.API.Description != "" {
+		apiConfig.Description = cfg.API.Description
 	}
-
-	return config, nil
+	if cfg.API.ServerURL != "" {
+		apiConfig.ServerURL = cfg.API.ServerURL
+	}
+	apiConfig.Auth = cfg.API.Auth
+	return apiConfig, nil
 }
 
 // Generate converts a parsed CloudPact AST into an OpenAPI document
@@ -80,7 +72,11 @@ func GenerateWithConfig(file *grammar.File, config *APIConfig) (string, error) {
 	if file == nil {
 		return "", fmt.Errorf("nil file")
 	}
+	return toYAML(buildDocument(file, config), 0), nil
+}
 
+// buildDocument assembles the OpenAPI document for file as nested maps
+func buildDocument(file *grammar.File, config *APIConfig) map[string]interface{} {
 	doc := map[string]interface{}{
 		"openapi": "3.0.0",
 		"info": map[string]interface{}{
@@ -116,6 +112,7 @@ func GenerateWithConfig(file *grammar.File, config *APIConfig) (string, error) {
 	for _, m := range file.Models {
 		schema := generateModelSchema(m, schemaNames)
 		schemas[m.Name] = schema
+		schemas[m.Name+"Patch"] = generateModelPatchSchema(m, schemaNames)
 
 		// Generate basic CRUD paths for each model
 		generateModelPaths(paths, m)
@@ -125,14 +122,162 @@ func GenerateWithConfig(file *grammar.File, config *APIConfig) (string, error) {
 	for _, r := range file.Records {
 		schema := generateRecordSchema(r, schemaNames)
 		schemas[r.Name] = schema
+
+		// Versioned records are read and replaced with ETags
+		if r.Versioned {
+			generateVersionedRecordPaths(paths, r)
+		}
 	}
 
 	// Generate paths for functions
+	requiresAuth := false
 	for _, f := range file.Functions {
 		generateFunctionPath(paths, f, schemaNames)
+		requiresAuth = requiresAuth || f.Access != nil
+	}
+
+	describePermissions(paths, file)
+	applySecurity(doc, config.Auth, requiresAuth)
+
+	// Errors share one schema, named Error unless a record or model is
+	errorName := "Error"
+	if _, ok := schemaNames[errorName]; ok {
+		errorName = "APIError"
 	}
+	schemas[errorName] = errorSchema()
+	addErrorResponses(doc, errorName)
 
-	return toYAML(doc, 0), nil
+	return doc
+}
+
+// oauthFlowNames maps the flows of api.auth to their OpenAPI names
+var oauthFlowNames = map[string]string{
+	"authorization_code": "authorizationCode",
+	"client_credentials": "clientCredentials",
+	"password":           "password",
+	"implicit":           "implicit",
+}
+
+// applySecurity adds the security schemes of auth to doc. Without any,
+// functions with requires clauses take a bearer token. Otherwise Apply
+// becomes the security of the whole document and the operations of a tag
+// in Tags accept the schemes listed there instead. Functions with requires
+// clauses are never public: unless their tag names schemes, they accept the
+// applied ones, or every scheme when none is applied, and their roles are
+// the scopes of the OAuth2 schemes among them.
+func applySecurity(doc map[string]interface{}, auth config.Auth, requiresAuth bool) {
+	components := doc["components"].(map[string]interface{})
+	if len(auth.Schemes) == 0 {
+		if requiresAuth {
+			components["securitySchemes"] = map[string]interface{}{
+				bearerScheme: map[string]interface{}{
+					"type":   "http",
+					"scheme": "bearer",
+				},
+			}
+		}
+		return
+	}
+
+	securitySchemes := make(map[string]interface{})
+	var all []string
+	for name, scheme := range auth.Schemes {
+		securitySchemes[name] = securityScheme(scheme)
+		all = append(all, name)
+	}
+	sort.Strings(all)
+	components["securitySchemes"] = securitySchemes
+	if len(auth.Apply) > 0 {
+		doc["security"] = securityRequirements(auth, auth.Apply, nil)
+	}
+
+	for _, item := range doc["paths"].(map[string]interface{}) {
+		for _, value := range item.(map[string]interface{}) {
+			op, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			var tagged []string
+			hasTag := false
+			if tags, ok := op["tags"].([]string); ok {
+				for _, tag := range tags {
+					if schemes, ok := auth.Tags[tag]; ok {
+						tagged, hasTag = schemes, true
+						break
+					}
+				}
+			}
+			if _, requires := op["security"]; !requires {
+				if hasTag {
+					op["security"] = securityRequirements(auth, tagged, nil)
+				}
+				continue
+			}
+
+			schemes := tagged
+			if len(schemes) == 0 {
+				schemes = auth.Apply
+			}
+			if len(schemes) == 0 {
+				schemes = all
+			}
+			roles, _ := op["x-roles"].([]interface{})
+			op["security"] = securityRequirements(auth, schemes, roles)
+		}
+	}
+}
+
+// securityScheme is the OpenAPI security scheme object of scheme
+func securityScheme(scheme config.AuthScheme) map[string]interface{} {
+	switch scheme.Type {
+	case "apiKey":
+		return map[string]interface{}{
+			"type": "apiKey",
+			"in":   scheme.In,
+			"name": scheme.Name,
+		}
+	case "oauth2":
+		flow := map[string]interface{}{}
+		if scheme.AuthorizationURL != "" {
+			flow["authorizationUrl"] = scheme.AuthorizationURL
+		}
+		if scheme.TokenURL != "" {
+			flow["tokenUrl"] = scheme.TokenURL
+		}
+		scopes := map[string]interface{}{}
+		for name, description := range scheme.Scopes {
+			scopes[name] = description
+		}
+		flow["scopes"] = scopes
+		return map[string]interface{}{
+			"type":  "oauth2",
+			"flows": map[string]interface{}{oauthFlowNames[scheme.Flow]: flow},
+		}
+	default:
+		result := map[string]interface{}{
+			"type":   "http",
+			"scheme": "bearer",
+		}
+		if scheme.BearerFormat != "" {
+			result["bearerFormat"] = scheme.BearerFormat
+		}
+		return result
+	}
+}
+
+// securityRequirements lists names as alternatives, any one of them being
+// enough. The scopes of OAuth2 schemes are roles; the other schemes take
+// none. An empty list makes an operation public.
+func securityRequirements(auth config.Auth, names []string, roles []interface{}) []interface{} {
+	requirements := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		scopes := []interface{}{}
+		if auth.Schemes[name].Type == "oauth2" && len(roles) > 0 {
+			scopes = roles
+		}
+		requirements = append(requirements, map[string]interface{}{name: scopes})
+	}
+	return requirements
 }
 
 // generateModelSchema creates an OpenAPI schema for a CloudPact model
@@ -140,63 +285,211 @@ func generateModelSchema(model *grammar.Model, schemaNames map[string]struct{})
 	schema := map[string]interface{}{
 		"type":       "object",
 		"properties": map[string]interface{}{},
-		"required":   []interface{}{},
 	}
 
 	props := schema["properties"].(map[string]interface{})
 	required := []interface{}{}
 
-	// Add an ID field by default for all models
-	props["id"] = map[string]interface{}{
-		"type":        "string",
-		"format":      "uuid",
-		"description": "Unique identifier",
-		"example":     "123e4567-e89b-12d3-a456-426614174000",
+	// Add an ID field by default for all models, unless one is declared
+	declaresID := false
+	for _, field := range model.Fields {
+		declaresID = declaresID || strings.EqualFold(field.Name, "id")
+	}
+	if !declaresID {
+		props["id"] = map[string]interface{}{
+			"type":        "string",
+			"format":      "uuid",
+			"description": "Unique identifier",
+			"example":     "123e4567-e89b-12d3-a456-426614174000",
+		}
+		required = append(required, "id")
 	}
-	required = append(required, "id")
 
 	for _, field := range model.Fields {
 		fieldSchema := generateTypeSchema(field.Type, schemaNames)
-		props[field.Name] = fieldSchema
+		props[field.JSONKey()] = fieldSchema
 
-		// For now, mark all fields as required
-		// TODO: Add optional field support to CloudPact syntax
-		required = append(required, field.Name)
+		if !field.Type.Optional {
+			required = append(required, field.JSONKey())
+		}
 	}
 
-	schema["required"] = required
+	// OpenAPI 3.0 does not allow an empty required list
+	if len(required) > 0 {
+		schema["required"] = required
+	}
 	return schema
 }
 
-// generateRecordSchema creates an OpenAPI schema for a CloudPact record
+// generateModelPatchSchema describes a JSON Merge Patch (RFC 7386) body for a
+// model: every field may be omitted, and optional fields may be null to clear them
+func generateModelPatchSchema(model *grammar.Model, schemaNames map[string]struct{}) map[string]interface{} {
+	props := map[string]interface{}{}
+	for _, field := range model.Fields {
+		if strings.ToLower(field.Name) == "id" {
+			continue // ids are fixed once created
+		}
+		fieldSchema := generateTypeSchema(field.Type, schemaNames)
+		if field.Type.Optional {
+			if _, isRef := fieldSchema["$ref"]; isRef {
+				// OpenAPI 3.0 ignores siblings of $ref, so wrap it to allow null
+				fieldSchema = map[string]interface{}{"allOf": []interface{}{fieldSchema}}
+			}
+			fieldSchema["nullable"] = true
+		}
+		props[field.JSONKey()] = fieldSchema
+	}
+
+	return map[string]interface{}{
+		"type":        "object",
+		"description": fmt.Sprintf("Partial %s update; omitted fields are left unchanged", model.Name),
+		"properties":  props,
+	}
+}
+
+// generateRecordSchema creates an OpenAPI schema for a CloudPact record. A
+// record that extends another combines its schema with the base's in allOf.
 func generateRecordSchema(record *grammar.Record, schemaNames map[string]struct{}) map[string]interface{} {
 	schema := map[string]interface{}{
 		"type":       "object",
 		"properties": map[string]interface{}{},
-		"required":   []interface{}{},
 	}
 
 	props := schema["properties"].(map[string]interface{})
 	required := []interface{}{}
+	example := recordExample(record)
+
+	// The server assigns the implicit id; a record extending another
+	// inherits it from the base's schema
+	if record.Extends == "" && record.HasImplicitID() {
+		id := map[string]interface{}{
+			"type":        "string",
+			"format":      "uuid",
+			"readOnly":    true,
+			"description": "Assigned by the server when the record is created",
+		}
+		if value := example["id"]; value != nil {
+			id["example"] = value
+		}
+		props["id"] = id
+		required = append(required, "id")
+	}
 
 	for _, field := range record.Fields {
 		fieldSchema := generateTypeSchema(field.Type, schemaNames)
-		props[field.Name] = fieldSchema
-		required = append(required, field.Name)
+		props[field.JSONKey()] = fieldSchema
+		// Secrets are accepted in requests but never sent back
+		if codegen.IsSecret(field.Type.Name) {
+			fieldSchema["writeOnly"] = true
+		}
+		// The record's example replaces the semantic type's placeholder
+		if value := example[field.JSONKey()]; value != nil && fieldSchema["type"] != nil {
+			fieldSchema["example"] = value
+		}
+		// Clients may omit a defaulted field; "now" has no fixed value to declare
+		switch value := field.Default.(type) {
+		case *grammar.LiteralExpression:
+			fieldSchema["default"] = value.Value
+			continue
+		case *grammar.IdentifierExpression:
+			description := "Defaults to the time of creation"
+			if existing, ok := fieldSchema["description"].(string); ok && existing != "" {
+				description = existing + ", defaults to the time of creation"
+			}
+			fieldSchema["description"] = description
+			continue
+		}
+		// "maybe" fields are always sent, if only as null
+		if !field.Type.Optional || field.Type.Nullable {
+			required = append(required, field.JSONKey())
+		}
+	}
+
+	if record.Versioned {
+		props["version"] = map[string]interface{}{
+			"type":        "integer",
+			"format":      "int64",
+			"readOnly":    true,
+			"description": "Increases with every update; sent as the ETag",
+		}
+		required = append(required, "version")
 	}
 
-	schema["required"] = required
+	// OpenAPI 3.0 does not allow an empty required list
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if record.Extends != "" {
+		schema = map[string]interface{}{
+			"allOf": []interface{}{
+				map[string]interface{}{"$ref": fmt.Sprintf("#/components/schemas/%s", record.Extends)},
+				schema,
+			},
+		}
+	}
+	if len(example) > 0 {
+		schema["example"] = example
+	}
 	return schema
 }
 
+// recordExample is the example object of a record's schema, by JSON key
+func recordExample(record *grammar.Record) map[string]interface{} {
+	example := make(map[string]interface{})
+	for _, field := range codegen.ExampleFields(record) {
+		example[field.Key] = field.Value.Value
+	}
+	return example
+}
+
 // generateTypeSchema maps a CloudPact type to an OpenAPI schema, with $ref support
 func generateTypeSchema(t *grammar.Type, schemaNames map[string]struct{}) map[string]interface{} {
+	// "maybe T" is T or null
+	if t.Nullable {
+		inner := *t
+		inner.Nullable = false
+		schema := generateTypeSchema(&inner, schemaNames)
+		if _, isRef := schema["$ref"]; isRef {
+			// OpenAPI 3.0 ignores siblings of $ref, so wrap it to allow null
+			schema = map[string]interface{}{"allOf": []interface{}{schema}}
+		}
+		schema["nullable"] = true
+		return schema
+	}
+
+	if t.Name == "list" && t.Element != nil {
+		return map[string]interface{}{
+			"type":  "array",
+			"items": generateTypeSchema(t.Element, schemaNames),
+		}
+	}
+
+	// Maps become objects whose properties all share the value schema; the
+	// keys of JSON objects are strings, so whole-number keys are noted
+	if t.Name == "map" && t.Key != nil {
+		schema := map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": generateTypeSchema(t.Value, schemaNames),
+		}
+		if baseType, _, _, _, _ := mapSemanticType(t.Key.Name); baseType == "integer" {
+			schema["x-cloudpact-key"] = t.Key.Name
+		}
+		return schema
+	}
+
 	if _, ok := schemaNames[t.Name]; ok {
 		return map[string]interface{}{
 			"$ref": fmt.Sprintf("#/components/schemas/%s", t.Name),
 		}
 	}
+	return SemanticSchema(t)
+}
 
+// SemanticSchema is the schema of a scalar CloudPact type: the JSON type,
+// format, bounds and example of its semantic type, overridden by the
+// constraints declared on t. Other schema generators, such as the JSON
+// Schema export, start from it.
+func SemanticSchema(t *grammar.Type) map[string]interface{} {
 	baseType, format, description, example, constraints := mapSemanticType(t.Name)
 
 	fieldSchema := map[string]interface{}{
@@ -219,9 +512,44 @@ func generateTypeSchema(t *grammar.Type, schemaNames map[string]struct{}) map[st
 		fieldSchema[key] = value
 	}
 
+	// Declared constraints override those of the semantic type
+	for constraint, keyword := range constraintKeywords {
+		if value, ok := t.Constraints[constraint]; ok {
+			fieldSchema[keyword] = value
+		}
+	}
+	if domain, ok := t.Constraints[grammar.ConstraintDomain].(string); ok {
+		fieldSchema["pattern"] = "@" + regexp.QuoteMeta(domain) + "$"
+	}
+	if t.Alias != "" {
+		fieldSchema["x-cloudpact-type"] = t.Alias
+	}
+
+	// Money declared with "round:" is exchanged in whole cents
+	if mode, ok := t.Constraints["round"].(string); ok {
+		fieldSchema["multipleOf"] = 0.01
+		fieldSchema["x-cloudpact-rounding"] = mode
+		fieldSchema["description"] = fmt.Sprintf("%s, rounded %s", description, roundingDescription(mode))
+	}
+
 	return fieldSchema
 }
 
+// constraintKeywords maps CloudPact field constraints to JSON Schema keywords
+var constraintKeywords = map[string]string{
+	grammar.ConstraintMin:       "minimum",
+	grammar.ConstraintMax:       "maximum",
+	grammar.ConstraintMinLength: "minLength",
+	grammar.ConstraintMaxLength: "maxLength",
+}
+
+func roundingDescription(mode string) string {
+	if mode == grammar.RoundBanker {
+		return "half to even (banker's rounding)"
+	}
+	return "half away from zero"
+}
+
 // mapSemanticType maps CloudPact semantic types to OpenAPI types with validation and examples
 func mapSemanticType(cpType string) (baseType, format, description string, example interface{}, constraints map[string]interface{}) {
 	constraints = make(map[string]interface{})
@@ -396,7 +724,7 @@ func generateModelPaths(paths map[string]interface{}, model *grammar.Model) {
 		},
 	}
 
-	// Individual resource endpoints: GET/PUT/DELETE /users/{id}
+	// Individual resource endpoints: GET/PUT/PATCH/DELETE /users/{id}
 	paths[fmt.Sprintf("/%s/{id}", modelNamePlural)] = map[string]interface{}{
 		"parameters": []interface{}{
 			map[string]interface{}{
@@ -460,6 +788,39 @@ func generateModelPaths(paths map[string]interface{}, model *grammar.Model) {
 				},
 			},
 		},
+		"patch": map[string]interface{}{
+			"summary":     fmt.Sprintf("Partially update a %s", modelNameLower),
+			"description": fmt.Sprintf("Apply a JSON Merge Patch (RFC 7386) to an existing %s record", modelNameLower),
+			"tags":        []string{modelName},
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/merge-patch+json": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"$ref": fmt.Sprintf("#/components/schemas/%sPatch", modelName),
+						},
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Updated successfully",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"$ref": fmt.Sprintf("#/components/schemas/%s", modelName),
+							},
+						},
+					},
+				},
+				"404": map[string]interface{}{
+					"description": "Record not found",
+				},
+				"422": map[string]interface{}{
+					"description": "Patch is invalid for this record",
+				},
+			},
+		},
 		"delete": map[string]interface{}{
 			"summary":     fmt.Sprintf("Delete a %s", modelNameLower),
 			"description": fmt.Sprintf("Delete a %s record", modelNameLower),
@@ -476,17 +837,213 @@ func generateModelPaths(paths map[string]interface{}, model *grammar.Model) {
 	}
 }
 
+// generateVersionedRecordPaths adds GET and PUT /<records>/{id} for a
+// versioned record: GET returns an ETag that PUT requires in If-Match
+func generateVersionedRecordPaths(paths map[string]interface{}, record *grammar.Record) {
+	recordName := record.Name
+	recordNameLower := strings.ToLower(recordName)
+	recordRef := map[string]interface{}{
+		"$ref": fmt.Sprintf("#/components/schemas/%s", recordName),
+	}
+	etagHeader := map[string]interface{}{
+		"ETag": map[string]interface{}{
+			"description": fmt.Sprintf("Current version of the %s", recordNameLower),
+			"schema":      map[string]interface{}{"type": "string"},
+		},
+	}
+
+	// The id in the path is the record's key field, if it declares one
+	idSchema := map[string]interface{}{"type": "string", "format": "uuid"}
+	if key := record.KeyField(); key != nil {
+		idSchema = generateTypeSchema(key.Type, nil)
+	}
+
+	paths[fmt.Sprintf("/%ss/{id}", recordNameLower)] = map[string]interface{}{
+		"parameters": []interface{}{
+			map[string]interface{}{
+				"name":        "id",
+				"in":          "path",
+				"required":    true,
+				"description": fmt.Sprintf("%s ID", recordName),
+				"schema":      idSchema,
+			},
+		},
+		"get": map[string]interface{}{
+			"summary":     fmt.Sprintf("Get a %s by ID", recordNameLower),
+			"description": fmt.Sprintf("Retrieve a %s and its ETag", recordNameLower),
+			"tags":        []string{recordName},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Successful response",
+					"headers":     etagHeader,
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": recordRef},
+					},
+				},
+				"404": map[string]interface{}{
+					"description": "Record not found",
+				},
+			},
+		},
+		"put": map[string]interface{}{
+			"summary":     fmt.Sprintf("Update a %s", recordNameLower),
+			"description": fmt.Sprintf("Replace a %s if it is unchanged since the ETag in If-Match was read", recordNameLower),
+			"tags":        []string{recordName},
+			"parameters": []interface{}{
+				map[string]interface{}{
+					"name":        "If-Match",
+					"in":          "header",
+					"required":    true,
+					"description": "ETag from the last read",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+			},
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": recordRef},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Updated successfully",
+					"headers":     etagHeader,
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": recordRef},
+					},
+				},
+				"404": map[string]interface{}{
+					"description": "Record not found",
+				},
+				"409": map[string]interface{}{
+					"description": "Record was saved concurrently; reload and retry",
+				},
+				"412": map[string]interface{}{
+					"description": "If-Match does not match the current ETag; reload and retry",
+				},
+				"428": map[string]interface{}{
+					"description": "If-Match header is required",
+				},
+			},
+		},
+	}
+}
+
+// recordActions are the HTTP methods of versioned record paths and the
+// actions of allow rules they perform
+var recordActions = map[string]string{"get": "read", "put": "update"}
+
+// describePermissions adds what the allow rules of file permit to the
+// descriptions of its operations: the roles allowed each operation on a
+// versioned record, and what the roles a function requires may do
+func describePermissions(paths map[string]interface{}, file *grammar.File) {
+	if len(file.Allows) == 0 {
+		return
+	}
+	for _, record := range file.Records {
+		path, ok := paths[fmt.Sprintf("/%ss/{id}", strings.ToLower(record.Name))].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for method, action := range recordActions {
+			op, ok := path[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			var roles []string
+			for _, allow := range file.Allows {
+				if allow.Resource == record.Name && slices.Contains(allow.Actions, action) && !slices.Contains(roles, allow.Role) {
+					roles = append(roles, allow.Role)
+				}
+			}
+			sentence := fmt.Sprintf("No role is allowed to %s a %s.", action, strings.ToLower(record.Name))
+			switch len(roles) {
+			case 0:
+			case 1:
+				sentence = fmt.Sprintf("Allowed for role %s.", roles[0])
+			default:
+				sentence = fmt.Sprintf("Allowed for roles %s and %s.", strings.Join(roles[:len(roles)-1], ", "), roles[len(roles)-1])
+			}
+			appendDescription(op, sentence)
+		}
+	}
+
+	for _, fn := range file.Functions {
+		if fn.Access == nil || len(fn.Access.Roles) == 0 {
+			continue
+		}
+		op := paths["/"+strings.ToLower(fn.Name)].(map[string]interface{})["post"].(map[string]interface{})
+		var permissions []string
+		for _, role := range fn.Access.Roles {
+			var granted []string
+			for _, allow := range file.Allows {
+				if allow.Role == role {
+					granted = append(granted, strings.Join(allow.Actions, ", ")+" "+allow.Resource)
+				}
+			}
+			if len(granted) > 0 {
+				permissions = append(permissions, role+" may "+strings.Join(granted, "; "))
+			}
+		}
+		if len(permissions) > 0 {
+			appendDescription(op, "Permissions: "+strings.Join(permissions, ". ")+".")
+		}
+	}
+}
+
+// appendDescription adds sentence to the description of op, ending the
+// description's last sentence first
+func appendDescription(op map[string]interface{}, sentence string) {
+	description, _ := op["description"].(string)
+	if description = strings.TrimRight(description, " "); description != "" {
+		if !strings.HasSuffix(description, ".") && !strings.HasSuffix(description, "!") && !strings.HasSuffix(description, "?") {
+			description += "."
+		}
+		sentence = description + " " + sentence
+	}
+	op["description"] = sentence
+}
+
 // generateFunctionPath creates a POST endpoint for a function
+// bearerScheme names the security scheme of functions with requires clauses
+const bearerScheme = "bearerAuth"
+
 func generateFunctionPath(paths map[string]interface{}, fn *grammar.Function, schemaNames map[string]struct{}) {
 	funcName := strings.ToLower(fn.Name)
 
 	op := map[string]interface{}{
+		"operationId": fn.Name,
 		"summary":     fmt.Sprintf("Call %s", fn.Name),
 		"description": fn.Why,
 		"tags":        []string{"Functions"},
 		"responses":   map[string]interface{}{},
 	}
 
+	// Declared headers: request headers become parameters, emitted ones are
+	// documented on the success response
+	var headerParams []interface{}
+	emitted := map[string]interface{}{}
+	requiresHeaders := false
+	for _, h := range fn.Headers {
+		if h.Direction == grammar.HeaderEmitted {
+			emitted[h.Name] = map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string"},
+			}
+			continue
+		}
+		required := h.Direction == grammar.HeaderRequired
+		requiresHeaders = requiresHeaders || required
+		headerParams = append(headerParams, map[string]interface{}{
+			"name":     h.Name,
+			"in":       "header",
+			"required": required,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	if len(headerParams) > 0 {
+		op["parameters"] = headerParams
+	}
+
 	// Request body from parameters
 	if len(fn.Parameters) > 0 {
 		paramSchema := map[string]interface{}{
@@ -527,31 +1084,159 @@ func generateFunctionPath(paths map[string]interface{}, fn *grammar.Function, sc
 			"description": "No content",
 		}
 	}
+	if len(emitted) > 0 {
+		for _, response := range responses {
+			response.(map[string]interface{})["headers"] = emitted
+		}
+	}
+	switch {
+	case requiresHeaders && len(fn.Parameters) > 0:
+		responses["400"] = map[string]interface{}{
+			"description": "The request body is malformed or a required header is missing",
+		}
+	case requiresHeaders:
+		responses["400"] = map[string]interface{}{
+			"description": "A required header is missing",
+		}
+	}
+
+	// requires clauses: OpenAPI 3.0 has no scopes for bearer tokens, so the
+	// roles are listed in x-roles
+	if access := fn.Access; access != nil {
+		op["security"] = []interface{}{
+			map[string]interface{}{bearerScheme: []interface{}{}},
+		}
+		responses["401"] = map[string]interface{}{
+			"description": "Authentication required",
+		}
+		if len(access.Roles) > 0 {
+			roles := make([]interface{}, len(access.Roles))
+			for i, role := range access.Roles {
+				roles[i] = role
+			}
+			op["x-roles"] = roles
+			responses["403"] = map[string]interface{}{
+				"description": "The caller has none of the required roles",
+			}
+		}
+	}
+
+	// fail statements answer with the status of their error code; the
+	// messages of each describe the response
+	var statuses []string
+	messages := make(map[string][]string)
+	for _, fail := range codegen.FailStatements(fn) {
+		status := fmt.Sprint(codegen.FailStatus[fail.ErrorCode()])
+		if _, ok := messages[status]; !ok {
+			statuses = append(statuses, status)
+		}
+		messages[status] = append(messages[status], fail.Message)
+	}
+	for _, status := range statuses {
+		description := strings.Join(messages[status], "; ")
+		if response, ok := responses[status].(map[string]interface{}); ok {
+			description = response["description"].(string) + "; " + description
+		}
+		responses[status] = map[string]interface{}{
+			"description": description,
+		}
+	}
 
 	paths[fmt.Sprintf("/%s", funcName)] = map[string]interface{}{
 		"post": op,
 	}
 }
 
+// errorSchema is the shared body of error responses, the object the
+// generated handlers answer a fail with
+func errorSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"code", "message"},
+		"properties": map[string]interface{}{
+			"code": map[string]interface{}{
+				"type":        "string",
+				"description": "Machine-readable error code, such as not_found or invalid",
+				"example":     grammar.FailNotFound,
+			},
+			"message": map[string]interface{}{
+				"type":        "string",
+				"description": "What went wrong, for people",
+				"example":     "Record not found",
+			},
+			"details": map[string]interface{}{
+				"type":                 "object",
+				"description":          "More about the error, such as the fields that failed validation",
+				"additionalProperties": true,
+			},
+			"requestId": map[string]interface{}{
+				"type":        "string",
+				"description": "The X-Request-ID of the request, when it carried one",
+			},
+		},
+	}
+}
+
+// addErrorResponses gives every operation of doc the error responses it can
+// answer with, each carrying the Error schema: 400 when it takes a body,
+// 401 when it needs authentication, and 500 always, next to the 404, 422
+// and other responses declared with it
+func addErrorResponses(doc map[string]interface{}, schemaName string) {
+	_, global := doc["security"]
+	ref := map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": map[string]interface{}{"$ref": "#/components/schemas/" + schemaName},
+		},
+	}
+	for _, item := range doc["paths"].(map[string]interface{}) {
+		for _, value := range item.(map[string]interface{}) {
+			op, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			responses := op["responses"].(map[string]interface{})
+			add := func(status, description string) {
+				if _, ok := responses[status]; !ok {
+					responses[status] = map[string]interface{}{"description": description}
+				}
+			}
+			if _, ok := op["requestBody"]; ok {
+				add("400", "The request body is malformed")
+			}
+			security, ok := op["security"].([]interface{})
+			if (ok && len(security) > 0) || (!ok && global) {
+				add("401", "Authentication required")
+			}
+			add("500", "Unexpected server error")
+
+			for status, response := range responses {
+				response := response.(map[string]interface{})
+				if _, ok := response["content"]; !ok && status >= "400" {
+					response["content"] = ref
+				}
+			}
+		}
+	}
+}
+
 // WriteFile renders doc as YAML and writes it to the provided path with configuration
 func WriteFile(file *grammar.File, path string) error {
-	return WriteFileWithConfig(file, path, "cloudpact.yaml")
+	return WriteFileWithConfig(file, path, config.FileName)
 }
 
 // WriteFileWithConfig allows specifying a custom config file path
 func WriteFileWithConfig(file *grammar.File, path, configPath string) error {
-	config, err := LoadAPIConfig(configPath)
+	apiConfig, err := LoadAPIConfig(configPath)
 	if err != nil {
-		// Use defaults if config loading fails
-		config = DefaultAPIConfig()
+		return err
 	}
 
-	yaml, err := GenerateWithConfig(file, config)
+	yaml, err := GenerateWithConfig(file, apiConfig)
 	if err != nil {
 		return err
 	}
 
-	if err := os.MkdirAll("generated/openapi", 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
 
@@ -596,9 +1281,15 @@ func toYAML(v interface{}, indent int) string {
 }
 
 func isScalar(v interface{}) bool {
-	switch v.(type) {
+	switch val := v.(type) {
 	case string, int, int64, float64, bool, nil:
 		return true
+	case []interface{}:
+		// An empty list has no items to indent, so it is written inline
+		return len(val) == 0
+	case map[string]interface{}:
+		// As is an empty map, such as OAuth2 flows without scopes
+		return len(val) == 0
 	default:
 		return false
 	}
@@ -610,6 +1301,10 @@ func formatScalar(v interface{}) string {
 		return fmt.Sprintf("%q", val)
 	case nil:
 		return "null"
+	case []interface{}:
+		return "[]"
+	case map[string]interface{}:
+		return "{}"
 	case bool:
 		if val {
 			return "true"
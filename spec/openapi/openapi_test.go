@@ -4,6 +4,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/daveroberts0321/cloudpact/config"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
 	"github.com/daveroberts0321/cloudpact/parser/grammar"
 )
 
@@ -11,6 +13,13 @@ func TestGenerate(t *testing.T) {
 	src := `define record Person
     first: text
     last: text
+    age: int
+    balance: usd_currency round: banker
+
+model Account {
+    owner: text
+    note: text optional
+}
 
 function hello(name: text) returns text
     why: "Greets a user"
@@ -27,10 +36,14 @@ function hello(name: text) returns text
 	checks := []string{
 		"openapi: \"3.0.0\"",
 		"title: \"CloudPact API\"",
-  "User:",
+		"Person:",
 		"type: \"integer\"",
 		"type: \"string\"",
-
+		"x-cloudpact-rounding: \"banker\"",
+		"AccountPatch:",
+		"application/merge-patch+json:",
+		"$ref: \"#/components/schemas/AccountPatch\"",
+		"nullable: true",
 	}
 	for _, c := range checks {
 		if !strings.Contains(yaml, c) {
@@ -38,3 +51,700 @@ function hello(name: text) returns text
 		}
 	}
 }
+
+func TestGenerateVersionedRecord(t *testing.T) {
+	f, err := grammar.ParseString("define record Order versioned\n    total: number\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{
+		"/orders/{id}:",
+		"version:",
+		"readOnly: true",
+		"name: \"If-Match\"",
+		"ETag:",
+		"409:",
+		"412:",
+		"428:",
+	} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+}
+
+func TestGenerateRecordImplicitID(t *testing.T) {
+	f, err := grammar.ParseString("define record Note\n    text: text\n\ndefine record Pinned extends Note\n    rank: int\n\ndefine record Country\n    code: text key\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(f); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	note := yaml[strings.Index(yaml, "    Note:\n"):]
+	note = note[:strings.Index(note, "      type: \"object\"")]
+	for _, c := range []string{
+		"        id:\n          description: \"Assigned by the server when the record is created\"\n          format: \"uuid\"\n          readOnly: true\n          type: \"string\"\n",
+		"      required:\n        - \"id\"\n        - \"text\"\n",
+	} {
+		if !strings.Contains(note, c) {
+			t.Fatalf("expected Note to contain %q\n%s", c, yaml)
+		}
+	}
+	if strings.Count(yaml, "format: \"uuid\"") != 1 {
+		t.Fatalf("expected only Note to declare an id: Pinned inherits it and Country has a key\n%s", yaml)
+	}
+}
+
+func TestGenerateSecretsWriteOnly(t *testing.T) {
+	f, err := grammar.ParseString("define record Login\n    user: text\n    secret: password\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	// Properties are sorted, so secret's attributes come before user
+	secret, user := strings.Index(yaml, "secret:"), strings.Index(yaml, "user:")
+	writeOnly := strings.Index(yaml, "writeOnly: true")
+	if writeOnly < secret || writeOnly > user || strings.Count(yaml, "writeOnly: true") != 1 {
+		t.Fatalf("expected only secret to be writeOnly\n%s", yaml)
+	}
+}
+
+func TestGenerateRecordIdentity(t *testing.T) {
+	f, err := grammar.ParseString("define record Country versioned\n    code: text key\n\nmodel Tag {\n    id: int\n    name: text\n}\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	// The path id is the key, and a declared model id replaces the implicit one
+	for _, c := range []string{
+		"name: \"id\"\n        required: true\n        schema:\n          description: \"Text string\"",
+		"id:\n          description: \"Integer value\"",
+		"required:\n        - \"id\"\n        - \"name\"\n      type",
+	} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+}
+
+func TestGenerateFieldDefaults(t *testing.T) {
+	f, err := grammar.ParseString("define record Account\n    name: text\n    status: text default \"active\"\n    createdAt: datetime default now\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{
+		"default: \"active\"",
+		"defaults to the time of creation",
+		"required:\n        - \"id\"\n        - \"name\"\n      type:",
+	} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+}
+
+func TestGenerateFieldConstraints(t *testing.T) {
+	f, err := grammar.ParseString("define record Person\n    name: text minlength 1 maxlength 80\n    age: int min 0 max 150\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{"maximum: 150", "minimum: 0", "maxLength: 80", "minLength: 1"} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+}
+
+func TestGenerateCustomTypes(t *testing.T) {
+	f, err := grammar.ParseString("define type StaffEmail as email\n    validate: domain must be \"company.com\"\n\ndefine record Staff\n    email: StaffEmail\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(f); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{`format: "email"`, `pattern: "@company\\.com$"`, `x-cloudpact-type: "StaffEmail"`} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+}
+
+func TestGenerateFunctionHeaders(t *testing.T) {
+	src := `function placeOrder(total: number) returns number
+    header: X-Tenant-ID required
+    header: X-Request-ID emitted
+    why: "Orders belong to a tenant"
+    do:
+        return total`
+	f, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{
+		"in: \"header\"\n          name: \"X-Tenant-ID\"\n          required: true",
+		"headers:\n            X-Request-ID:",
+		"description: \"The request body is malformed or a required header is missing\"",
+	} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+}
+
+func TestGenerateFunctionAccess(t *testing.T) {
+	src := `function deleteOrder(id: uuid)
+    requires role admin
+    why: "Only staff remove orders"
+    do:
+        return
+
+function listOrders() returns number
+    requires auth
+    why: "Customers see their orders"
+    do:
+        return 1`
+	f, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{
+		"securitySchemes:\n    bearerAuth:\n      scheme: \"bearer\"\n      type: \"http\"",
+		"security:\n        -\n          bearerAuth: []",
+		"x-roles:\n        - \"admin\"",
+		"description: \"Authentication required\"\n        403:",
+	} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+	// Only role checks can be refused with 403
+	listOrders := yaml[strings.Index(yaml, "/listorders:"):]
+	if strings.Contains(listOrders, "403:") || strings.Contains(listOrders, "x-roles") {
+		t.Fatalf("expected listOrders to need authentication only:\n%s", listOrders)
+	}
+
+	public, _ := grammar.ParseString("function ping() returns number\n    why: \"x\"\n    do:\n        return 1")
+	if yaml, _ := Generate(public); strings.Contains(yaml, "security") {
+		t.Fatalf("expected no security without requires clauses:\n%s", yaml)
+	}
+}
+
+func TestGeneratePermissions(t *testing.T) {
+	src := `define record Order versioned
+    total: money
+
+define role admin
+define role support
+
+allow support to read Order
+allow admin to read, update, delete Order
+
+function cancelOrder(id: uuid)
+    requires role admin
+    why: "Only staff cancel orders"
+    do:
+        return`
+	f, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{
+		"description: \"Retrieve a order and its ETag. Allowed for roles support and admin.\"",
+		"description: \"Replace a order if it is unchanged since the ETag in If-Match was read. Allowed for role admin.\"",
+		"description: \"Only staff cancel orders. Permissions: admin may read, update, delete Order.\"",
+	} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+}
+
+func TestGenerateErrorResponses(t *testing.T) {
+	src := `model Product {
+    name: text
+}
+
+function findOrder(id: number) returns number
+    why: "Orders are looked up by number"
+    do:
+        if id < 0
+            then fail "id must not be negative"
+        if id > 1000
+            then fail not_found "no such order"
+        return id`
+	f, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{
+		"Error:\n      properties:\n        code:",
+		"requestId:\n          description:",
+		"required:\n        - \"code\"\n        - \"message\"",
+	} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+
+	findOrder := yaml[strings.Index(yaml, "/findorder:"):strings.Index(yaml, "/products:")]
+	for _, c := range []string{
+		"400:\n          content:\n            application/json:\n              schema:\n                $ref: \"#/components/schemas/Error\"\n          description: \"The request body is malformed\"",
+		"description: \"no such order\"",
+		"description: \"id must not be negative\"",
+		"description: \"Unexpected server error\"",
+	} {
+		if !strings.Contains(findOrder, c) {
+			t.Fatalf("expected findOrder to contain %q\n%s", c, findOrder)
+		}
+	}
+	if strings.Contains(findOrder, "401:") {
+		t.Fatalf("expected no 401 without authentication:\n%s", findOrder)
+	}
+	// The 404 of a model operation carries the schema too
+	products := yaml[strings.Index(yaml, "/products/{id}:"):]
+	if !strings.Contains(products, "404:\n          content:\n            application/json:\n              schema:\n                $ref: \"#/components/schemas/Error\"") {
+		t.Fatalf("expected model errors to use the Error schema:\n%s", products)
+	}
+
+	// A record named Error keeps its name
+	f, _ = grammar.ParseString("define record Error\n    reason: text\n")
+	if yaml, _ := Generate(f); !strings.Contains(yaml, "APIError:") || !strings.Contains(yaml, "Error:\n      properties:\n        id:") {
+		t.Fatalf("expected the error schema to be renamed:\n%s", yaml)
+	}
+}
+
+func TestGenerateAuthSchemes(t *testing.T) {
+	src := `model Product {
+    name: text
+}
+
+function deleteOrder(id: uuid)
+    requires role admin
+    why: "Only staff remove orders"
+    do:
+        return`
+	f, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	cfg := DefaultAPIConfig()
+	cfg.Auth = config.Auth{
+		Schemes: map[string]config.AuthScheme{
+			"key": {Type: "apiKey", In: "header", Name: "X-API-Key"},
+			"sso": {Type: "oauth2", Flow: "client_credentials", TokenURL: "https://auth.example.com/token",
+				Scopes: map[string]string{"admin": "Manage orders"}},
+		},
+		Apply: []string{"key"},
+		Tags:  map[string][]string{"Product": {}},
+	}
+	yaml, err := GenerateWithConfig(f, cfg)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{
+		"key:\n      in: \"header\"\n      name: \"X-API-Key\"\n      type: \"apiKey\"",
+		"flows:\n        clientCredentials:\n          scopes:\n            admin: \"Manage orders\"\n          tokenUrl: \"https://auth.example.com/token\"",
+		"security:\n  -\n    key: []",
+	} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+	if strings.Contains(yaml, "bearerAuth") {
+		t.Fatalf("expected the configured schemes to replace bearerAuth:\n%s", yaml)
+	}
+
+	// The Product tag is public and the function with requires keeps to the
+	// applied scheme
+	products := yaml[strings.Index(yaml, "/products:"):]
+	if !strings.Contains(products, "security: []") {
+		t.Fatalf("expected Product operations to be public:\n%s", products)
+	}
+	deleteOrder := yaml[strings.Index(yaml, "/deleteorder:"):strings.Index(yaml, "/products:")]
+	if !strings.Contains(deleteOrder, "security:\n        -\n          key: []") {
+		t.Fatalf("expected deleteOrder to accept the applied scheme:\n%s", deleteOrder)
+	}
+
+	// Without applied schemes, requires accepts every scheme and its roles
+	// are OAuth2 scopes
+	cfg.Auth.Apply = nil
+	yaml, _ = GenerateWithConfig(f, cfg)
+	if !strings.Contains(yaml, "-\n          sso:\n            - \"admin\"") {
+		t.Fatalf("expected the admin role as an sso scope:\n%s", yaml)
+	}
+}
+
+func TestLint(t *testing.T) {
+	src := `define record Order
+    total: usd_currency
+    ship_to: text
+    fee: usd_currency round: banker
+
+function place_order(total: number) returns number
+    why: ""
+    do:
+        return total`
+	f, err := grammar.ParseWithFilename(strings.NewReader(src), "orders.cp")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	var got []string
+	for _, v := range Lint(f, "") {
+		got = append(got, v.String())
+	}
+	want := []string{
+		"orders.cp:6:1: warn cloudpact-path-lowercase: path /place_order should be lower case with words separated by - (at /paths/~1place_order)",
+		"orders.cp:6:1: warn cloudpact-operation-description: POST /place_order has no description (at /paths/~1place_order/post)",
+		"orders.cp:3:5: warn cloudpact-property-camel-case: field ship_to of Order should be camelCase (at /components/schemas/Order/properties/ship_to)",
+		"orders.cp:2:5: warn cloudpact-money-rounding: currency field total of Order has no rounding mode; add round: banker or round: half-up (at /components/schemas/Order/properties/total)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected violations:\n%s", strings.Join(got, "\n"))
+	}
+
+	if !strings.Contains(SpectralRuleset, "cloudpact-money-rounding:") {
+		t.Fatal("expected the Spectral ruleset to define the money rounding rule")
+	}
+
+	// Under snake_case keys the violations point at the field by its key,
+	// and snake_case is what properties should be
+	src = `define record Order
+    unitPrice: usd_currency round: banker
+    shippingFee: usd_currency`
+	f, err = grammar.ParseWithFilename(strings.NewReader(src), "orders.cp")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	got = nil
+	for _, v := range Lint(f, grammar.JSONSnake) {
+		got = append(got, v.String())
+	}
+	want = []string{
+		"orders.cp:3:5: warn cloudpact-money-rounding: currency field shipping_fee of Order has no rounding mode; add round: banker or round: half-up (at /components/schemas/Order/properties/shipping_fee)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected violations under snake_case keys:\n%s", strings.Join(got, "\n"))
+	}
+
+	if ruleset := SpectralRulesetFor(grammar.JSONSnake); !strings.Contains(ruleset, "cloudpact-property-snake-case:") || strings.Contains(ruleset, "camel") || !strings.Contains(ruleset, "cloudpact-money-rounding:") {
+		t.Fatalf("expected the snake_case rule in place of the camelCase one:\n%s", ruleset)
+	}
+	if ruleset := SpectralRulesetFor(grammar.JSONAsWritten); strings.Contains(ruleset, "cloudpact-property-") {
+		t.Fatalf("expected no property casing rule for keys as written:\n%s", ruleset)
+	}
+	if SpectralRulesetFor("") != SpectralRuleset {
+		t.Fatal("expected the embedded ruleset under the default json_names")
+	}
+}
+
+func TestGenerateRecordExtends(t *testing.T) {
+	f, err := grammar.ParseString("define record User\n    name: text\n\ndefine record AdminUser extends User\n    level: int\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(f); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{"allOf:", `$ref: "#/components/schemas/User"`} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+}
+
+func TestGenerateMapTypes(t *testing.T) {
+	f, err := grammar.ParseString("define record Team\n    scores: map of text to int\n    budgets: map<int, usd_currency>\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{"scores:\n          additionalProperties:\n", "type: \"object\"\n          x-cloudpact-key: \"int\""} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+}
+
+func TestGenerateMaybeFields(t *testing.T) {
+	f, err := grammar.ParseString("define record Team\n    name: text\n\ndefine record Person\n    nickname: maybe text\n    team: maybe Team\n    note: text optional\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{
+		"nickname:\n          description: \"Text string\"\n          example: \"Sample text\"\n          nullable: true\n",
+		"team:\n          allOf:\n            -\n              $ref: \"#/components/schemas/Team\"\n          nullable: true\n",
+		"required:\n        - \"id\"\n        - \"nickname\"\n        - \"team\"\n      type",
+	} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+}
+
+func TestGenerateRecordExample(t *testing.T) {
+	f, err := grammar.ParseString("define record Booking\n    guest: text\n    nights: int\n    hold: duration\n    note: maybe text\n    example:\n        id: \"0b5f3c2e-8a4d-4e8a-9d5e-2f1c6b7a8e90\"\n        guest: \"Ada\"\n        nights: 3\n        hold: \"90m\"\n        note: none\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	for _, c := range []string{
+		"Booking:\n      example:\n        guest: \"Ada\"\n        hold: \"PT1H30M\"\n        id: \"0b5f3c2e-8a4d-4e8a-9d5e-2f1c6b7a8e90\"\n        nights: 3\n        note: null\n",
+		"guest:\n          description: \"Text string\"\n          example: \"Ada\"\n",
+		"hold:\n          description: \"ISO 8601 duration\"\n          example: \"PT1H30M\"\n",
+		"nights:\n          description: \"Integer value\"\n          example: 3\n",
+		"note:\n          description: \"Text string\"\n          example: \"Sample text\"\n",
+	} {
+		if !strings.Contains(yaml, c) {
+			t.Fatalf("expected YAML to contain %q\n%s", c, yaml)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	f, err := grammar.ParseString("define record Empty\n    note: text optional\n\ndefine record Order versioned\n    total: usd_currency\n\nfunction place(order: Order) returns Order\n    requires auth\n    why: \"Places an order\"\n    do:\n        return order\n")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(f); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	yaml, err := Generate(f)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	violations, err := Validate([]byte(yaml))
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("expected the generated spec to be valid, got %v\n%s", violations, yaml)
+	}
+
+	spec := `{
+  "openapi": "3.0.3",
+  "info": {"title": "Shop"},
+  "paths": {
+    "/orders/{id}": {
+      "get": {
+        "operationId": "getOrder",
+        "parameters": [{"name": "id", "in": "path", "schema": {"type": "string"}}],
+        "security": [{"bearer": []}],
+        "responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Missing"}}}}}
+      }
+    },
+    "/items/{sku}": {
+      "post": {
+        "operationId": "getOrder",
+        "responses": {"ok": {"description": "Fine"}}
+      }
+    }
+  },
+  "components": {"schemas": {"Order": {"type": "list", "required": [], "nullable": "yes"}}}
+}`
+	violations, err = Validate([]byte(spec))
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.String())
+	}
+	want := []string{
+		"spec: error oas3-schema: /info is missing required field version (at /info)",
+		`spec: error oas3-schema: type must be one of array, boolean, integer, number, object, string, got "list" (at /components/schemas/Order/type)`,
+		"spec: error oas3-schema: nullable must be a boolean, got a string (at /components/schemas/Order/nullable)",
+		"spec: error oas3-schema: required must list at least one property in OpenAPI 3.0; omit it instead (at /components/schemas/Order/required)",
+		"spec: error oas3-schema: POST /items/{sku} does not declare path parameter sku (at /paths/~1items~1{sku}/post)",
+		"spec: error oas3-schema: response code ok must be default, a status such as 200 or a range such as 2XX (at /paths/~1items~1{sku}/post/responses/ok)",
+		"spec: error oas3-schema: operationId getOrder is already used at /paths/~1items~1{sku}/post (at /paths/~1orders~1{id}/get/operationId)",
+		"spec: error oas3-schema: path parameter id must have required: true (at /paths/~1orders~1{id}/get/parameters/0)",
+		"spec: error oas3-schema: /paths/~1orders~1{id}/get/responses/200 is missing required field description (at /paths/~1orders~1{id}/get/responses/200)",
+		"spec: error invalid-ref: $ref #/components/schemas/Missing does not resolve (at /paths/~1orders~1{id}/get/responses/200/content/application~1json/schema/$ref)",
+		"spec: error oas3-schema: security scheme bearer is not declared in components.securitySchemes (at /paths/~1orders~1{id}/get/security/0/bearer)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected violations:\n%s", strings.Join(got, "\n"))
+	}
+
+	violations, _ = Validate([]byte("openapi: 3.1.0\ninfo:\n  title: Shop\n  version: \"1\"\ncomponents:\n  schemas:\n    Note:\n      type: [string, \"null\"]\n    Old:\n      type: string\n      nullable: true\n"))
+	if len(violations) != 1 || !strings.Contains(violations[0].Message, "nullable is not part of OpenAPI 3.1") {
+		t.Fatalf("expected only the 3.0 nullable to be reported, got %v", violations)
+	}
+}
+
+func TestImport(t *testing.T) {
+	spec := `openapi: 3.0.3
+info:
+  title: pet store
+  version: 1.0.0
+security:
+  - bearer: []
+paths:
+  /pets:
+    get:
+      operationId: ListPets
+      summary: List the "pets"
+      security: []
+      parameters:
+        - name: limit
+          in: query
+          schema: {type: integer}
+        - name: X-Request-ID
+          in: header
+          required: true
+          schema: {type: string}
+      responses:
+        "200":
+          description: ok
+          content:
+            application/json:
+              schema:
+                type: array
+                items: {$ref: "#/components/schemas/Pet"}
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: "#/components/schemas/NewPet"}
+      responses:
+        "201":
+          description: created
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Pet"}
+  /pets/{petId}:
+    parameters:
+      - name: petId
+        in: path
+        required: true
+        schema: {type: string, format: uuid}
+    delete:
+      responses:
+        "204": {description: gone}
+components:
+  schemas:
+    Pet:
+      allOf:
+        - $ref: "#/components/schemas/NewPet"
+        - type: object
+          required: [id]
+          properties:
+            id: {type: string, format: uuid}
+            born: {type: string, format: date-time, nullable: true}
+    NewPet:
+      description: A pet before it is stored
+      type: object
+      required: [name]
+      properties:
+        name: {type: string, maxLength: 40}
+        tag: {type: string, enum: [cat, dog], default: cat}
+        weight: {type: number, minimum: 0}
+        owner:
+          type: object
+          properties:
+            email: {type: string, format: email}
+        labels:
+          type: object
+          additionalProperties: {type: integer}
+        module: {type: [string, "null"]}
+`
+	src, err := Import([]byte(spec), "")
+	if err != nil {
+		t.Fatalf("Import error: %v", err)
+	}
+	for _, want := range []string{
+		"module PetStore\n",
+		"define record Pet extends NewPetInput\n    id: uuid\n    born: maybe datetime\n",
+		"// A pet before it is stored\ndefine record NewPetInput no id\n",
+		"    name: text maxlength 40\n",
+		"    // one of: cat, dog\n    tag: text default \"cat\"\n",
+		"    weight: number optional min 0\n",
+		"    owner: NewPetInputOwner optional\n",
+		"define record NewPetInputOwner no id\n    email: email optional\n",
+		"    labels: map of text to int optional\n",
+		"    moduleValue: maybe text\n",
+		"function listPets(limit: maybe int) returns list of Pet\n    header: X-Request-ID required\n    why: \"List the 'pets'\"\n",
+		"function postPets(newPetInput: NewPetInput) returns Pet\n    requires auth\n    why: \"Serves POST /pets\"\n",
+		"function deletePetsByPetId(petId: uuid)\n",
+		"        fail internal \"not implemented\"\n",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("expected %q in imported source:\n%s", want, src)
+		}
+	}
+	if strings.Contains(src, "listPets(limit: maybe int) returns list of Pet\n    header: X-Request-ID required\n    requires auth") {
+		t.Errorf("an operation with empty security should not require auth:\n%s", src)
+	}
+
+	f, err := grammar.ParseString(src)
+	if err != nil {
+		t.Fatalf("imported source does not parse: %v\n%s", err, src)
+	}
+	if err := analyzer.Check(f); err != nil {
+		t.Fatalf("imported source does not check: %v\n%s", err, src)
+	}
+
+	if _, err := Import([]byte("swagger: \"2.0\"\n"), "Pets"); err == nil {
+		t.Fatal("expected an error for a Swagger 2.0 document")
+	}
+}
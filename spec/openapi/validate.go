@@ -0,0 +1,825 @@
+package openapi
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rules Validate reports under, named as in Spectral
+const (
+	RuleSchema     = "oas3-schema"
+	RuleInvalidRef = "invalid-ref"
+)
+
+var (
+	specVersion    = regexp.MustCompile(`^3\.([01])\.\d+(-.+)?$`)
+	componentName  = regexp.MustCompile(`^[a-zA-Z0-9.\-_]+$`)
+	responseCode   = regexp.MustCompile(`^[1-5](\d\d|XX)$`)
+	pathTemplate   = regexp.MustCompile(`\{([^}]+)\}`)
+	schemaTypes    = []string{"array", "boolean", "integer", "number", "object", "string"}
+	parameterIns   = []string{"query", "header", "path", "cookie"}
+	pathItemFields = []string{"$ref", "summary", "description", "servers", "parameters"}
+)
+
+// componentKinds are the maps of components, each checked by a validator
+var componentKinds = []string{"schemas", "responses", "parameters", "examples", "requestBodies", "headers", "securitySchemes", "links", "callbacks", "pathItems"}
+
+// ValidateFile reads a spec in YAML or JSON and validates it
+func ValidateFile(path string) ([]Violation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Validate(data)
+}
+
+// Validate checks an OpenAPI 3.0 or 3.1 document, in YAML or JSON, against
+// the structure the specification's meta-schema requires: required fields,
+// the types and allowed values of fields, and local $refs that resolve.
+// Unlike Lint it applies no conventions, so it suits any spec. Every
+// violation is an error.
+func Validate(data []byte) ([]Violation, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse spec: %w", err)
+	}
+	doc, ok := normalize(raw).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("spec is not a YAML mapping")
+	}
+	return ValidateDocument(doc), nil
+}
+
+// normalize converts yaml.v2 maps into map[string]interface{}; numeric keys
+// like 200 become strings
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[fmt.Sprint(k)] = normalize(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = normalize(item)
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+// ValidateDocument is Validate for a decoded document, such as mock.Parse
+// returns
+func ValidateDocument(doc map[string]interface{}) []Violation {
+	v := &validator{doc: doc, operationIDs: make(map[string]string)}
+	v.document()
+	return v.violations
+}
+
+// validator walks a document, collecting violations
+type validator struct {
+	doc          map[string]interface{}
+	minor        string            // "0" or "1", the OpenAPI 3 version
+	operationIDs map[string]string // operationId -> pointer of its first use
+	violations   []Violation
+}
+
+func (v *validator) errorf(pointer, format string, args ...interface{}) {
+	if pointer == "" {
+		pointer = "/"
+	}
+	v.violations = append(v.violations, Violation{Rule: RuleSchema, Severity: SeverityError, Message: fmt.Sprintf(format, args...), Path: pointer})
+}
+
+// object reports a value that is not a mapping, which then has no fields
+// to check
+func (v *validator) object(pointer string, value interface{}) (map[string]interface{}, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		v.errorf(pointer, "%s must be an object, got %s", describePointer(pointer), jsonType(value))
+	}
+	return m, ok
+}
+
+// array reports a value that is not a list
+func (v *validator) array(pointer string, value interface{}) ([]interface{}, bool) {
+	list, ok := value.([]interface{})
+	if !ok {
+		v.errorf(pointer, "%s must be an array, got %s", describePointer(pointer), jsonType(value))
+	}
+	return list, ok
+}
+
+// str checks that an optional field is text; a missing one is fine
+func (v *validator) str(pointer string, m map[string]interface{}, field string) (string, bool) {
+	value, present := m[field]
+	if !present {
+		return "", false
+	}
+	s, ok := value.(string)
+	if !ok {
+		v.errorf(pointer+"/"+escapePointer(field), "%s must be a string, got %s", field, jsonType(value))
+	}
+	return s, ok
+}
+
+// required checks that fields are present
+func (v *validator) required(pointer string, m map[string]interface{}, fields ...string) bool {
+	ok := true
+	for _, field := range fields {
+		if _, present := m[field]; !present {
+			v.errorf(pointer, "%s is missing required field %s", describePointer(pointer), field)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// requiredString checks that a field is present and text
+func (v *validator) requiredString(pointer string, m map[string]interface{}, field string) (string, bool) {
+	if !v.required(pointer, m, field) {
+		return "", false
+	}
+	return v.str(pointer, m, field)
+}
+
+// oneOf checks that an optional field is one of allowed
+func (v *validator) oneOf(pointer string, m map[string]interface{}, field string, allowed []string) (string, bool) {
+	s, ok := v.str(pointer, m, field)
+	if ok && !slices.Contains(allowed, s) {
+		v.errorf(pointer+"/"+escapePointer(field), "%s must be one of %s, got %q", field, strings.Join(allowed, ", "), s)
+		return s, false
+	}
+	return s, ok
+}
+
+// known reports fields that are neither allowed nor extensions (x-...)
+func (v *validator) known(pointer string, m map[string]interface{}, allowed []string) {
+	for _, field := range sortedKeys(m) {
+		if !strings.HasPrefix(field, "x-") && !slices.Contains(allowed, field) {
+			v.errorf(pointer+"/"+escapePointer(field), "%s has unknown field %s", describePointer(pointer), field)
+		}
+	}
+}
+
+func (v *validator) document() {
+	version, ok := v.requiredString("", v.doc, "openapi")
+	if !ok {
+		return
+	}
+	match := specVersion.FindStringSubmatch(version)
+	if match == nil {
+		v.errorf("/openapi", "openapi must be a 3.0.x or 3.1.x version, got %q", version)
+		return
+	}
+	v.minor = match[1]
+
+	allowed := []string{"openapi", "info", "servers", "paths", "components", "security", "tags", "externalDocs"}
+	if v.minor == "1" {
+		allowed = append(allowed, "webhooks", "jsonSchemaDialect")
+	}
+	v.known("", v.doc, allowed)
+
+	if v.required("", v.doc, "info") {
+		v.info(v.doc["info"])
+	}
+	if v.minor == "0" {
+		v.required("", v.doc, "paths")
+	} else if v.doc["paths"] == nil && v.doc["components"] == nil && v.doc["webhooks"] == nil {
+		v.errorf("/", "an OpenAPI 3.1 document needs paths, components or webhooks")
+	}
+	if servers, present := v.doc["servers"]; present {
+		v.servers("/servers", servers)
+	}
+	if components, present := v.doc["components"]; present {
+		v.components(components)
+	}
+	if paths, present := v.doc["paths"]; present {
+		if m, ok := v.object("/paths", paths); ok {
+			for _, path := range sortedKeys(m) {
+				pointer := "/paths/" + escapePointer(path)
+				if !strings.HasPrefix(path, "/") {
+					v.errorf(pointer, "path %s must start with /", path)
+				}
+				v.pathItem(pointer, path, m[path])
+			}
+		}
+	}
+	if webhooks, present := v.doc["webhooks"]; present && v.minor == "1" {
+		if m, ok := v.object("/webhooks", webhooks); ok {
+			for _, name := range sortedKeys(m) {
+				v.pathItem("/webhooks/"+escapePointer(name), "", m[name])
+			}
+		}
+	}
+	if security, present := v.doc["security"]; present {
+		v.security("/security", security)
+	}
+	if tags, present := v.doc["tags"]; present {
+		v.tags(tags)
+	}
+}
+
+func (v *validator) info(value interface{}) {
+	info, ok := v.object("/info", value)
+	if !ok {
+		return
+	}
+	v.requiredString("/info", info, "title")
+	v.requiredString("/info", info, "version")
+	v.str("/info", info, "description")
+	if license, present := info["license"]; present {
+		if m, ok := v.object("/info/license", license); ok {
+			v.requiredString("/info/license", m, "name")
+		}
+	}
+}
+
+func (v *validator) servers(pointer string, value interface{}) {
+	servers, ok := v.array(pointer, value)
+	if !ok {
+		return
+	}
+	for i, server := range servers {
+		serverPointer := fmt.Sprintf("%s/%d", pointer, i)
+		m, ok := v.object(serverPointer, server)
+		if !ok {
+			continue
+		}
+		v.requiredString(serverPointer, m, "url")
+		variables, present := m["variables"]
+		if !present {
+			continue
+		}
+		vars, ok := v.object(serverPointer+"/variables", variables)
+		if !ok {
+			continue
+		}
+		for _, name := range sortedKeys(vars) {
+			varPointer := serverPointer + "/variables/" + escapePointer(name)
+			if variable, ok := v.object(varPointer, vars[name]); ok {
+				v.requiredString(varPointer, variable, "default")
+			}
+		}
+	}
+}
+
+func (v *validator) components(value interface{}) {
+	components, ok := v.object("/components", value)
+	if !ok {
+		return
+	}
+	v.known("/components", components, componentKinds)
+	for _, kind := range componentKinds {
+		group, present := components[kind]
+		if !present {
+			continue
+		}
+		kindPointer := "/components/" + kind
+		m, ok := v.object(kindPointer, group)
+		if !ok {
+			continue
+		}
+		for _, name := range sortedKeys(m) {
+			pointer := kindPointer + "/" + escapePointer(name)
+			if !componentName.MatchString(name) {
+				v.errorf(pointer, "component name %s may only use letters, digits, ., - and _", name)
+			}
+			switch kind {
+			case "schemas":
+				v.schema(pointer, m[name])
+			case "responses":
+				v.response(pointer, m[name])
+			case "parameters":
+				v.parameter(pointer, m[name])
+			case "requestBodies":
+				v.requestBody(pointer, m[name])
+			case "securitySchemes":
+				v.securityScheme(pointer, m[name])
+			case "pathItems":
+				v.pathItem(pointer, "", m[name])
+			default:
+				v.object(pointer, m[name])
+			}
+		}
+	}
+}
+
+// pathItem checks the operations of a path; path is empty for path items
+// outside paths, such as webhooks, whose templates are not checked
+func (v *validator) pathItem(pointer, path string, value interface{}) {
+	item, ok := v.object(pointer, value)
+	if !ok {
+		return
+	}
+	if ref, present := item["$ref"]; present {
+		v.ref(pointer+"/$ref", ref)
+		return
+	}
+	v.known(pointer, item, append(append([]string{}, pathItemFields...), allMethods...))
+
+	declared := make(map[string]bool)
+	if params, present := item["parameters"]; present {
+		v.parameters(pointer+"/parameters", params, declared)
+	}
+	if servers, present := item["servers"]; present {
+		v.servers(pointer+"/servers", servers)
+	}
+	for _, method := range allMethods {
+		if operation, present := item[method]; present {
+			v.operation(pointer+"/"+method, strings.ToUpper(method)+" "+path, path, operation, declared)
+		}
+	}
+}
+
+// allMethods are the operations a path item may have
+var allMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+func (v *validator) operation(pointer, name, path string, value interface{}, pathParams map[string]bool) {
+	operation, ok := v.object(pointer, value)
+	if !ok {
+		return
+	}
+	v.known(pointer, operation, []string{"tags", "summary", "description", "externalDocs", "operationId", "parameters", "requestBody", "responses", "callbacks", "deprecated", "security", "servers"})
+
+	if id, ok := v.str(pointer, operation, "operationId"); ok {
+		if first, taken := v.operationIDs[id]; taken {
+			v.errorf(pointer+"/operationId", "operationId %s is already used at %s", id, first)
+		} else {
+			v.operationIDs[id] = pointer
+		}
+	}
+	if tags, present := operation["tags"]; present {
+		if list, ok := v.array(pointer+"/tags", tags); ok {
+			for i, tag := range list {
+				if _, ok := tag.(string); !ok {
+					v.errorf(fmt.Sprintf("%s/tags/%d", pointer, i), "tags must be strings, got %s", jsonType(tag))
+				}
+			}
+		}
+	}
+
+	declared := make(map[string]bool)
+	for param := range pathParams {
+		declared[param] = true
+	}
+	if params, present := operation["parameters"]; present {
+		v.parameters(pointer+"/parameters", params, declared)
+	}
+	if path != "" {
+		for _, match := range pathTemplate.FindAllStringSubmatch(path, -1) {
+			if !declared[match[1]] {
+				v.errorf(pointer, "%s does not declare path parameter %s", name, match[1])
+			}
+		}
+	}
+
+	if body, present := operation["requestBody"]; present {
+		v.requestBody(pointer+"/requestBody", body)
+	}
+	responses, present := operation["responses"]
+	if !present {
+		if v.minor == "0" {
+			v.errorf(pointer, "%s is missing required field responses", name)
+		}
+	} else if m, ok := v.object(pointer+"/responses", responses); ok {
+		if len(m) == 0 {
+			v.errorf(pointer+"/responses", "%s must declare at least one response", name)
+		}
+		for _, code := range sortedKeys(m) {
+			codePointer := pointer + "/responses/" + escapePointer(code)
+			if code != "default" && !responseCode.MatchString(code) && !strings.HasPrefix(code, "x-") {
+				v.errorf(codePointer, "response code %s must be default, a status such as 200 or a range such as 2XX", code)
+			}
+			v.response(codePointer, m[code])
+		}
+	}
+	if security, present := operation["security"]; present {
+		v.security(pointer+"/security", security)
+	}
+	if servers, present := operation["servers"]; present {
+		v.servers(pointer+"/servers", servers)
+	}
+}
+
+// parameters checks a parameter list, adding the names of path parameters
+// to declared
+func (v *validator) parameters(pointer string, value interface{}, declared map[string]bool) {
+	params, ok := v.array(pointer, value)
+	if !ok {
+		return
+	}
+	for i, param := range params {
+		paramPointer := fmt.Sprintf("%s/%d", pointer, i)
+		if name, in := v.parameter(paramPointer, param); in == "path" {
+			declared[name] = true
+		}
+	}
+}
+
+// parameter checks a parameter and returns its name and location, those of
+// the parameter a $ref points at for a reference
+func (v *validator) parameter(pointer string, value interface{}) (name, in string) {
+	param, ok := v.object(pointer, value)
+	if !ok {
+		return "", ""
+	}
+	if ref, present := param["$ref"]; present {
+		if target, ok := v.ref(pointer+"/$ref", ref).(map[string]interface{}); ok {
+			name, _ = target["name"].(string)
+			in, _ = target["in"].(string)
+		}
+		return name, in
+	}
+	name, _ = v.requiredString(pointer, param, "name")
+	if v.required(pointer, param, "in") {
+		in, _ = v.oneOf(pointer, param, "in", parameterIns)
+	}
+	if in == "path" && param["required"] != true {
+		v.errorf(pointer, "path parameter %s must have required: true", name)
+	}
+	schema, hasSchema := param["schema"]
+	content, hasContent := param["content"]
+	switch {
+	case hasSchema && hasContent:
+		v.errorf(pointer, "parameter %s may have schema or content, not both", name)
+	case !hasSchema && !hasContent:
+		v.errorf(pointer, "parameter %s needs a schema or content", name)
+	case hasSchema:
+		v.schema(pointer+"/schema", schema)
+	default:
+		v.content(pointer+"/content", content)
+	}
+	return name, in
+}
+
+func (v *validator) requestBody(pointer string, value interface{}) {
+	body, ok := v.object(pointer, value)
+	if !ok {
+		return
+	}
+	if ref, present := body["$ref"]; present {
+		v.ref(pointer+"/$ref", ref)
+		return
+	}
+	if v.required(pointer, body, "content") {
+		v.content(pointer+"/content", body["content"])
+	}
+}
+
+func (v *validator) response(pointer string, value interface{}) {
+	response, ok := v.object(pointer, value)
+	if !ok {
+		return
+	}
+	if ref, present := response["$ref"]; present {
+		v.ref(pointer+"/$ref", ref)
+		return
+	}
+	v.requiredString(pointer, response, "description")
+	if content, present := response["content"]; present {
+		v.content(pointer+"/content", content)
+	}
+	if headers, present := response["headers"]; present {
+		if m, ok := v.object(pointer+"/headers", headers); ok {
+			for _, name := range sortedKeys(m) {
+				headerPointer := pointer + "/headers/" + escapePointer(name)
+				if header, ok := v.object(headerPointer, m[name]); ok {
+					if schema, present := header["schema"]; present {
+						v.schema(headerPointer+"/schema", schema)
+					} else if ref, present := header["$ref"]; present {
+						v.ref(headerPointer+"/$ref", ref)
+					}
+				}
+			}
+		}
+	}
+}
+
+// content checks a map of media types
+func (v *validator) content(pointer string, value interface{}) {
+	content, ok := v.object(pointer, value)
+	if !ok {
+		return
+	}
+	for _, mediaType := range sortedKeys(content) {
+		mediaPointer := pointer + "/" + escapePointer(mediaType)
+		media, ok := v.object(mediaPointer, content[mediaType])
+		if !ok {
+			continue
+		}
+		if schema, present := media["schema"]; present {
+			v.schema(mediaPointer+"/schema", schema)
+		}
+	}
+}
+
+// schema checks a schema object and those nested in it
+func (v *validator) schema(pointer string, value interface{}) {
+	// 3.1 schemas follow JSON Schema, where true and false are schemas
+	if _, ok := value.(bool); ok && v.minor == "1" {
+		return
+	}
+	schema, ok := v.object(pointer, value)
+	if !ok {
+		return
+	}
+	if ref, present := schema["$ref"]; present {
+		v.ref(pointer+"/$ref", ref)
+		if v.minor == "0" {
+			return
+		}
+	}
+
+	var types []string
+	switch t := schema["type"].(type) {
+	case nil:
+	case string:
+		types = []string{t}
+	case []interface{}:
+		if v.minor == "0" {
+			v.errorf(pointer+"/type", "type must be a single string in OpenAPI 3.0")
+		}
+		for _, item := range t {
+			s, _ := item.(string)
+			types = append(types, s)
+		}
+	default:
+		v.errorf(pointer+"/type", "type must be a string, got %s", jsonType(t))
+	}
+	for _, t := range types {
+		if !slices.Contains(schemaTypes, t) && !(v.minor == "1" && t == "null") {
+			v.errorf(pointer+"/type", "type must be one of %s, got %q", strings.Join(schemaTypes, ", "), t)
+		}
+	}
+
+	if nullable, present := schema["nullable"]; present {
+		if v.minor == "1" {
+			v.errorf(pointer+"/nullable", "nullable is not part of OpenAPI 3.1; add \"null\" to type instead")
+		} else if _, ok := nullable.(bool); !ok {
+			v.errorf(pointer+"/nullable", "nullable must be a boolean, got %s", jsonType(nullable))
+		}
+	}
+	if v.minor == "0" && slices.Contains(types, "array") {
+		v.required(pointer, schema, "items")
+	}
+	if items, present := schema["items"]; present {
+		v.schema(pointer+"/items", items)
+	}
+	if properties, present := schema["properties"]; present {
+		if m, ok := v.object(pointer+"/properties", properties); ok {
+			for _, name := range sortedKeys(m) {
+				v.schema(pointer+"/properties/"+escapePointer(name), m[name])
+			}
+		}
+	}
+	if additional, present := schema["additionalProperties"]; present {
+		if _, ok := additional.(bool); !ok {
+			v.schema(pointer+"/additionalProperties", additional)
+		}
+	}
+	if not, present := schema["not"]; present {
+		v.schema(pointer+"/not", not)
+	}
+	for _, combinator := range []string{"allOf", "oneOf", "anyOf"} {
+		value, present := schema[combinator]
+		if !present {
+			continue
+		}
+		list, ok := v.array(pointer+"/"+combinator, value)
+		if !ok {
+			continue
+		}
+		if len(list) == 0 {
+			v.errorf(pointer+"/"+combinator, "%s must list at least one schema", combinator)
+		}
+		for i, item := range list {
+			v.schema(fmt.Sprintf("%s/%s/%d", pointer, combinator, i), item)
+		}
+	}
+	if required, present := schema["required"]; present {
+		if list, ok := v.array(pointer+"/required", required); ok {
+			if len(list) == 0 && v.minor == "0" {
+				v.errorf(pointer+"/required", "required must list at least one property in OpenAPI 3.0; omit it instead")
+			}
+			for i, item := range list {
+				if _, ok := item.(string); !ok {
+					v.errorf(fmt.Sprintf("%s/required/%d", pointer, i), "required must list property names, got %s", jsonType(item))
+				}
+			}
+		}
+	}
+	if enum, present := schema["enum"]; present {
+		v.array(pointer+"/enum", enum)
+	}
+	for _, keyword := range []string{"minimum", "maximum", "multipleOf"} {
+		if value, present := schema[keyword]; present && !isNumber(value) {
+			v.errorf(pointer+"/"+keyword, "%s must be a number, got %s", keyword, jsonType(value))
+		}
+	}
+	if value, present := schema["multipleOf"]; present && isNumber(value) && toFloat(value) <= 0 {
+		v.errorf(pointer+"/multipleOf", "multipleOf must be greater than 0")
+	}
+	for _, keyword := range []string{"minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties"} {
+		if value, present := schema[keyword]; present {
+			if n, ok := value.(int); !ok || n < 0 {
+				v.errorf(pointer+"/"+keyword, "%s must be a whole number of at least 0, got %v", keyword, value)
+			}
+		}
+	}
+	for _, keyword := range []string{"format", "pattern", "description", "title"} {
+		v.str(pointer, schema, keyword)
+	}
+}
+
+func (v *validator) securityScheme(pointer string, value interface{}) {
+	scheme, ok := v.object(pointer, value)
+	if !ok {
+		return
+	}
+	if ref, present := scheme["$ref"]; present {
+		v.ref(pointer+"/$ref", ref)
+		return
+	}
+	types := []string{"apiKey", "http", "oauth2", "openIdConnect"}
+	if v.minor == "1" {
+		types = append(types, "mutualTLS")
+	}
+	if !v.required(pointer, scheme, "type") {
+		return
+	}
+	kind, ok := v.oneOf(pointer, scheme, "type", types)
+	if !ok {
+		return
+	}
+	switch kind {
+	case "apiKey":
+		v.requiredString(pointer, scheme, "name")
+		if v.required(pointer, scheme, "in") {
+			v.oneOf(pointer, scheme, "in", []string{"query", "header", "cookie"})
+		}
+	case "http":
+		v.requiredString(pointer, scheme, "scheme")
+	case "openIdConnect":
+		v.requiredString(pointer, scheme, "openIdConnectUrl")
+	case "oauth2":
+		if !v.required(pointer, scheme, "flows") {
+			return
+		}
+		flows, ok := v.object(pointer+"/flows", scheme["flows"])
+		if !ok {
+			return
+		}
+		urls := map[string][]string{
+			"implicit":          {"authorizationUrl"},
+			"password":          {"tokenUrl"},
+			"clientCredentials": {"tokenUrl"},
+			"authorizationCode": {"authorizationUrl", "tokenUrl"},
+		}
+		v.known(pointer+"/flows", flows, []string{"implicit", "password", "clientCredentials", "authorizationCode"})
+		for _, name := range sortedKeys(flows) {
+			fields, known := urls[name]
+			if !known {
+				continue
+			}
+			flowPointer := pointer + "/flows/" + name
+			if flow, ok := v.object(flowPointer, flows[name]); ok {
+				for _, field := range fields {
+					v.requiredString(flowPointer, flow, field)
+				}
+				if v.required(flowPointer, flow, "scopes") {
+					v.object(flowPointer+"/scopes", flow["scopes"])
+				}
+			}
+		}
+	}
+}
+
+// security checks security requirements, which name declared schemes
+func (v *validator) security(pointer string, value interface{}) {
+	requirements, ok := v.array(pointer, value)
+	if !ok {
+		return
+	}
+	components, _ := v.doc["components"].(map[string]interface{})
+	schemes, _ := components["securitySchemes"].(map[string]interface{})
+	for i, requirement := range requirements {
+		reqPointer := fmt.Sprintf("%s/%d", pointer, i)
+		m, ok := v.object(reqPointer, requirement)
+		if !ok {
+			continue
+		}
+		for _, name := range sortedKeys(m) {
+			if _, declared := schemes[name]; !declared {
+				v.errorf(reqPointer+"/"+escapePointer(name), "security scheme %s is not declared in components.securitySchemes", name)
+			}
+			v.array(reqPointer+"/"+escapePointer(name), m[name])
+		}
+	}
+}
+
+func (v *validator) tags(value interface{}) {
+	tags, ok := v.array("/tags", value)
+	if !ok {
+		return
+	}
+	seen := make(map[string]bool)
+	for i, tag := range tags {
+		pointer := fmt.Sprintf("/tags/%d", i)
+		m, ok := v.object(pointer, tag)
+		if !ok {
+			continue
+		}
+		if name, ok := v.requiredString(pointer, m, "name"); ok {
+			if seen[name] {
+				v.errorf(pointer, "tag %s is declared twice", name)
+			}
+			seen[name] = true
+		}
+	}
+}
+
+// ref checks a $ref and returns what it points at, nil when that is outside
+// the document or missing. References to other files are not followed.
+func (v *validator) ref(pointer string, value interface{}) interface{} {
+	ref, ok := value.(string)
+	if !ok {
+		v.errorf(pointer, "$ref must be a string, got %s", jsonType(value))
+		return nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil
+	}
+	var target interface{} = v.doc
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		m, ok := target.(map[string]interface{})
+		if !ok {
+			target = nil
+			break
+		}
+		if target, ok = m[token]; !ok {
+			break
+		}
+	}
+	if target == nil {
+		v.violations = append(v.violations, Violation{Rule: RuleInvalidRef, Severity: SeverityError, Message: fmt.Sprintf("$ref %s does not resolve", ref), Path: pointer})
+	}
+	return target
+}
+
+// describePointer names the element at pointer in messages
+func describePointer(pointer string) string {
+	if pointer == "" || pointer == "/" {
+		return "the document"
+	}
+	return pointer
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case int, int64, float64:
+		return "a number"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int, int64, float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(value interface{}) float64 {
+	switch n := value.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
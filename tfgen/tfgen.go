@@ -0,0 +1,219 @@
+// Package tfgen writes a Terraform module provisioning the cloud resources
+// CloudPact records are kept in, on AWS: a DynamoDB table for each record
+// marked persist, keyed by its id, and a private, encrypted S3 bucket for
+// each marked persist bucket, whose objects are named by their ids.
+package tfgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/dbgen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Options tunes the generated files
+type Options struct {
+	// Header is the provenance comment each file starts with; empty means
+	// none
+	Header string
+}
+
+// ResourceName is the Terraform name of the resources of a record, and
+// the suffix of its table or bucket name: its table name in dbgen, so
+// OrderLine is order_lines
+func ResourceName(record *grammar.Record) string {
+	return dbgen.TableName(record.Name)
+}
+
+// Persisted returns the records kept in tables and those kept in buckets
+func Persisted(records []*grammar.Record) (tables, buckets []*grammar.Record) {
+	for _, record := range records {
+		switch record.Persist {
+		case "table":
+			tables = append(tables, record)
+		case "bucket":
+			buckets = append(buckets, record)
+		}
+	}
+	return tables, buckets
+}
+
+// GenerateMain writes main.tf: the table or bucket of every persisted
+// record
+func GenerateMain(records []*grammar.Record, opts Options) []byte {
+	var blocks []string
+	for _, record := range records {
+		switch record.Persist {
+		case "table":
+			blocks = append(blocks, table(record))
+		case "bucket":
+			blocks = append(blocks, bucket(record)...)
+		}
+	}
+	return codegen.Stamp("#", opts.Header, []byte(strings.Join(blocks, "\n")))
+}
+
+// table is the DynamoDB table of record, with its key as the hash key
+func table(record *grammar.Record) string {
+	key, keyType := "id", "S"
+	if field := record.KeyField(); field != nil && !record.HasImplicitID() {
+		key = field.JSONKey()
+		if analyzer.IsNumeric(field.Type) {
+			keyType = "N"
+		}
+	}
+	name := ResourceName(record)
+	var b strings.Builder
+	for _, line := range codegen.DocLines(record.Leading, record.Trailing) {
+		b.WriteString("# " + line + "\n")
+	}
+	b.WriteString(fmt.Sprintf("resource \"aws_dynamodb_table\" %q {\n", name))
+	b.WriteString(attributes("  ",
+		"name", fmt.Sprintf("\"${var.name}-%s\"", name),
+		"billing_mode", `"PAY_PER_REQUEST"`,
+		"hash_key", fmt.Sprintf("%q", key),
+		"deletion_protection_enabled", "var.deletion_protection",
+	))
+	b.WriteString("\n  attribute {\n")
+	b.WriteString(attributes("    ", "name", fmt.Sprintf("%q", key), "type", fmt.Sprintf("%q", keyType)))
+	b.WriteString("  }\n\n")
+	b.WriteString("  point_in_time_recovery {\n    enabled = var.point_in_time_recovery\n  }\n\n")
+	b.WriteString("  server_side_encryption {\n    enabled = true\n  }\n\n")
+	b.WriteString(fmt.Sprintf("  tags = %s\n", tags(record)))
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// bucket is the S3 bucket of record, blocked from public access and
+// encrypted, and keeping the versions of objects when record is versioned
+func bucket(record *grammar.Record) []string {
+	name := ResourceName(record)
+	ref := fmt.Sprintf("aws_s3_bucket.%s.id", name)
+	var b strings.Builder
+	for _, line := range codegen.DocLines(record.Leading, record.Trailing) {
+		b.WriteString("# " + line + "\n")
+	}
+	b.WriteString(fmt.Sprintf("resource \"aws_s3_bucket\" %q {\n", name))
+	b.WriteString(attributes("  ",
+		"bucket", fmt.Sprintf("\"${var.name}-%s\"", strings.ReplaceAll(name, "_", "-")),
+		"force_destroy", "var.force_destroy",
+		"tags", tags(record),
+	))
+	b.WriteString("}\n")
+	blocks := []string{b.String()}
+
+	blocks = append(blocks, fmt.Sprintf("resource \"aws_s3_bucket_public_access_block\" %q {\n%s}\n", name, attributes("  ",
+		"bucket", ref,
+		"block_public_acls", "true",
+		"block_public_policy", "true",
+		"ignore_public_acls", "true",
+		"restrict_public_buckets", "true",
+	)))
+	blocks = append(blocks, fmt.Sprintf("resource \"aws_s3_bucket_server_side_encryption_configuration\" %q {\n"+
+		"  bucket = %s\n\n"+
+		"  rule {\n    apply_server_side_encryption_by_default {\n      sse_algorithm = \"AES256\"\n    }\n  }\n}\n", name, ref))
+	if record.IsVersioned() {
+		blocks = append(blocks, fmt.Sprintf("resource \"aws_s3_bucket_versioning\" %q {\n"+
+			"  bucket = %s\n\n"+
+			"  versioning_configuration {\n    status = \"Enabled\"\n  }\n}\n", name, ref))
+	}
+	return blocks
+}
+
+// tags are the tags of the resources of record: the module's, and the
+// record they keep
+func tags(record *grammar.Record) string {
+	return fmt.Sprintf("merge(var.tags, { \"cloudpact:record\" = %q })", record.Name)
+}
+
+// attributes writes name and value pairs as lines indented by indent, with
+// their equals signs aligned as terraform fmt does
+func attributes(indent string, pairs ...string) string {
+	width := 0
+	for i := 0; i < len(pairs); i += 2 {
+		if len(pairs[i]) > width {
+			width = len(pairs[i])
+		}
+	}
+	var b strings.Builder
+	for i := 0; i < len(pairs); i += 2 {
+		b.WriteString(fmt.Sprintf("%s%-*s = %s\n", indent, width, pairs[i], pairs[i+1]))
+	}
+	return b.String()
+}
+
+// GenerateVariables writes variables.tf: the name prefix, defaulting to
+// name, the tags, and the settings of the kinds of resources records need
+func GenerateVariables(name string, records []*grammar.Record, opts Options) []byte {
+	tables, buckets := Persisted(records)
+	blocks := []string{
+		variable("name", "The prefix of every table and bucket name, such as shop-prod. Bucket names are global, so it must be unique.", "string", fmt.Sprintf("%q", name)),
+		variable("tags", "Tags added to every resource", "map(string)", "{}"),
+	}
+	if len(tables) > 0 {
+		blocks = append(blocks,
+			variable("point_in_time_recovery", "Whether the tables keep continuous backups to restore from", "bool", "true"),
+			variable("deletion_protection", "Whether the tables refuse to be deleted", "bool", "true"))
+	}
+	if len(buckets) > 0 {
+		blocks = append(blocks, variable("force_destroy", "Whether destroying a bucket deletes the objects in it", "bool", "false"))
+	}
+	return codegen.Stamp("#", opts.Header, []byte(strings.Join(blocks, "\n")))
+}
+
+// variable declares an input of the module
+func variable(name, description, typ, value string) string {
+	return fmt.Sprintf("variable %q {\n%s}\n", name, attributes("  ",
+		"description", fmt.Sprintf("%q", description),
+		"type", typ,
+		"default", value,
+	))
+}
+
+// GenerateOutputs writes outputs.tf: the names and ARNs of the tables and
+// buckets, by record, for the policies and configuration of the services
+// using them
+func GenerateOutputs(records []*grammar.Record, opts Options) []byte {
+	tables, buckets := Persisted(records)
+	var blocks []string
+	if len(tables) > 0 {
+		blocks = append(blocks,
+			output("table_names", "The DynamoDB table of each record", "aws_dynamodb_table", "name", tables),
+			output("table_arns", "The ARN of the DynamoDB table of each record", "aws_dynamodb_table", "arn", tables))
+	}
+	if len(buckets) > 0 {
+		blocks = append(blocks,
+			output("bucket_names", "The S3 bucket of each record", "aws_s3_bucket", "bucket", buckets),
+			output("bucket_arns", "The ARN of the S3 bucket of each record", "aws_s3_bucket", "arn", buckets))
+	}
+	return codegen.Stamp("#", opts.Header, []byte(strings.Join(blocks, "\n")))
+}
+
+// output maps each of records to an attribute of its resource
+func output(name, description, resource, attribute string, records []*grammar.Record) string {
+	var pairs []string
+	for _, record := range records {
+		pairs = append(pairs, record.Name, fmt.Sprintf("%s.%s.%s", resource, ResourceName(record), attribute))
+	}
+	return fmt.Sprintf("output %q {\n  description = %q\n  value = {\n%s  }\n}\n", name, description, attributes("    ", pairs...))
+}
+
+// GenerateVersions writes versions.tf, pinning the Terraform and AWS
+// provider versions the module needs
+func GenerateVersions(opts Options) []byte {
+	versions := `terraform {
+  required_version = ">= 1.3"
+
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = ">= 5.0"
+    }
+  }
+}
+`
+	return codegen.Stamp("#", opts.Header, []byte(versions))
+}
@@ -0,0 +1,82 @@
+package tfgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daveroberts0321/cloudpact/internal/testutil"
+)
+
+const archive = `module Archive
+
+// An invoice sent to a client
+define record Invoice persist versioned
+    total: usd_currency
+
+define record Client persist
+    number: int key
+
+define record Receipt persist bucket versioned
+    scan: text
+
+define record Attachment persist bucket
+    data: text
+
+define record Draft
+    note: text`
+
+func TestGenerateMain(t *testing.T) {
+	records := testutil.CheckedFile(t, archive).Records
+	main := string(GenerateMain(records, Options{Header: "Code generated by cloudpact. DO NOT EDIT."}))
+	for _, want := range []string{
+		"# Code generated by cloudpact. DO NOT EDIT.\n\n# An invoice sent to a client\nresource \"aws_dynamodb_table\" \"invoices\" {\n  name                        = \"${var.name}-invoices\"\n",
+		"  hash_key                    = \"id\"\n",
+		"  attribute {\n    name = \"number\"\n    type = \"N\"\n  }\n",
+		"  tags = merge(var.tags, { \"cloudpact:record\" = \"Client\" })\n",
+		"resource \"aws_s3_bucket\" \"receipts\" {\n  bucket        = \"${var.name}-receipts\"\n",
+		"resource \"aws_s3_bucket_public_access_block\" \"attachments\" {\n  bucket                  = aws_s3_bucket.attachments.id\n",
+		"resource \"aws_s3_bucket_versioning\" \"receipts\" {",
+	} {
+		if !strings.Contains(main, want) {
+			t.Errorf("expected %q in main.tf:\n%s", want, main)
+		}
+	}
+	if strings.Contains(main, "aws_s3_bucket_versioning\" \"attachments\"") {
+		t.Error("only versioned records keep object versions")
+	}
+	if strings.Contains(main, "drafts") {
+		t.Error("records without persist have no resources")
+	}
+}
+
+func TestGenerateVariablesAndOutputs(t *testing.T) {
+	records := testutil.CheckedFile(t, archive).Records
+	variables := string(GenerateVariables("archive", records, Options{}))
+	for _, want := range []string{
+		"variable \"name\" {\n  description = \"The prefix of every table and bucket name",
+		"  type        = string\n  default     = \"archive\"\n}",
+		"variable \"deletion_protection\" {",
+		"variable \"force_destroy\" {",
+	} {
+		if !strings.Contains(variables, want) {
+			t.Errorf("expected %q in variables.tf:\n%s", want, variables)
+		}
+	}
+	outputs := string(GenerateOutputs(records, Options{}))
+	for _, want := range []string{
+		"output \"table_arns\" {\n  description = \"The ARN of the DynamoDB table of each record\"\n  value = {\n    Invoice = aws_dynamodb_table.invoices.arn\n    Client  = aws_dynamodb_table.clients.arn\n  }\n}",
+		"    Attachment = aws_s3_bucket.attachments.bucket\n",
+	} {
+		if !strings.Contains(outputs, want) {
+			t.Errorf("expected %q in outputs.tf:\n%s", want, outputs)
+		}
+	}
+
+	tables := testutil.CheckedFile(t, "define record Invoice persist\n    total: number").Records
+	if variables := string(GenerateVariables("archive", tables, Options{})); strings.Contains(variables, "force_destroy") {
+		t.Errorf("expected no bucket settings without buckets:\n%s", variables)
+	}
+	if outputs := string(GenerateOutputs(tables, Options{})); strings.Contains(outputs, "bucket_names") {
+		t.Errorf("expected no bucket outputs without buckets:\n%s", outputs)
+	}
+}
@@ -0,0 +1,44 @@
+package tsgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// generateTSDefaults adds a function returning the initial values of a
+// record's defaulted fields, evaluated afresh on every call so "now" is
+// the time of creation
+func generateTSDefaults(record *grammar.Record) string {
+	var code strings.Builder
+	name := record.Name
+	fields := codegen.DefaultedFields(record.AllFields())
+
+	keys := make([]string, len(fields))
+	for i, field := range fields {
+		keys[i] = fmt.Sprintf("%q", field.JSONKey())
+	}
+
+	code.WriteString(fmt.Sprintf("// default%s returns the declared defaults of %s's fields\n", name, name))
+	code.WriteString(fmt.Sprintf("export function default%s(): Pick<%s, %s> {\n", name, name, strings.Join(keys, " | ")))
+	code.WriteString("  return {\n")
+	for _, field := range fields {
+		var value string
+		switch literal := field.Default.(type) {
+		case *grammar.LiteralExpression:
+			value = tsLiteral(literal)
+		default:
+			value = "new Date().toISOString()"
+			if strings.EqualFold(field.Type.Name, "date") {
+				value = "new Date().toISOString().slice(0, 10)"
+			}
+		}
+		code.WriteString(fmt.Sprintf("    %s: %s,\n", field.JSONKey(), value))
+	}
+	code.WriteString("  };\n")
+	code.WriteString("}\n\n")
+
+	return code.String()
+}
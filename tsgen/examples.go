@@ -0,0 +1,33 @@
+package tsgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// generateTSExample adds a fixture holding the values a record's example,
+// and those of the records it extends, give
+func generateTSExample(record *grammar.Record) string {
+	fields := codegen.ExampleFields(record)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	var code strings.Builder
+	name := record.Name
+	keys := make([]string, len(fields))
+	for i, field := range fields {
+		keys[i] = fmt.Sprintf("%q", field.Key)
+	}
+	code.WriteString(fmt.Sprintf("// example%s is the example declared for %s, a fixture for tests and mocks\n", name, name))
+	code.WriteString(fmt.Sprintf("export const example%s: Pick<%s, %s> = {\n", name, name, strings.Join(keys, " | ")))
+	for _, field := range fields {
+		code.WriteString(fmt.Sprintf("  %s: %s,\n", field.Key, tsLiteral(field.Value)))
+	}
+	code.WriteString("};\n\n")
+
+	return code.String()
+}
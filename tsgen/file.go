@@ -0,0 +1,388 @@
+package tsgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// Options tunes the generated code
+type Options struct {
+	// TrackChanges adds Patch types and change tracking helpers to records
+	TrackChanges bool
+	// TemplateDir holds templates replacing built-in ones of the same name,
+	// e.g. record.tmpl; empty means the built-in templates only
+	TemplateDir string
+	// Header is the provenance comment the file starts with, usually
+	// codegen.Header; empty means none
+	Header string
+}
+
+// GenerateFile translates a checked file into a TypeScript module of
+// interfaces for its records and models and functions for its functions
+func GenerateFile(file *grammar.File, opts Options) ([]byte, error) {
+	tmpl, err := codegen.Load("ts", opts.TemplateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var data codegen.File
+	if file.Module != nil {
+		data.Module = file.Module.Name
+	}
+
+	if opts.TrackChanges && len(file.Records) > 0 {
+		data.Helpers = tsTrackingHelpers
+	}
+
+	// Records (new syntax)
+	secret := tsSecretRecords(file)
+	for _, record := range file.Records {
+		rec := tsRecordData(record)
+		if opts.TrackChanges {
+			rec.Extra = generateTSChangeTracking(record)
+		}
+		if record.Versioned {
+			if !strings.Contains(data.Helpers, "updateWithRetry") {
+				data.Helpers += tsVersioningHelpers
+			}
+			rec.Extra += generateTSVersioning(record, secret)
+		}
+		if len(codegen.DefaultedFields(record.AllFields())) > 0 {
+			rec.Extra += generateTSDefaults(record)
+		}
+		rec.Extra += generateTSExample(record)
+		if secret[record.Name] {
+			rec.Extra += generateTSResponse(record)
+		}
+		data.Records = append(data.Records, rec)
+	}
+
+	// Models (legacy syntax)
+	for _, model := range file.Models {
+		data.Models = append(data.Models, tsModelData(model))
+	}
+
+	// Functions
+	for _, function := range file.Functions {
+		fn := tsFunctionData(function)
+		if strings.Contains(fn.Body, "cpRound") {
+			data.FunctionHelpers = tsRoundingHelpers
+		}
+		if len(function.Headers) > 0 {
+			fn.Extra = generateTSFunctionClient(function, secret)
+		}
+		data.Functions = append(data.Functions, fn)
+	}
+
+	tsCode, err := codegen.Render(tmpl, "file.tmpl", data)
+	if err != nil {
+		return nil, err
+	}
+	return codegen.Stamp("//", opts.Header, []byte(tsCode)), nil
+}
+
+// tsRecordData describes a record's TypeScript interface for record.tmpl
+func tsRecordData(record *grammar.Record) codegen.Record {
+	data := codegen.Record{Name: record.Name, Extends: record.Extends, Doc: codegen.DocLines(record.Leading, record.Trailing), Versioned: record.Versioned, ImplicitID: record.Extends == "" && record.HasImplicitID()}
+	for _, field := range record.Fields {
+		data.Fields = append(data.Fields, codegen.Field{
+			Name:     field.Name,
+			JSON:     field.JSONKey(),
+			Type:     FieldType(field.Type),
+			Optional: field.Type.Optional && !field.Type.Nullable, // "maybe" fields are present, if only as null
+			Comment:  tsTypeComment(field.Type),
+			Doc:      codegen.DocLines(field.Leading, field.Trailing),
+		})
+	}
+	return data
+}
+
+// tsModelData describes a legacy model's TypeScript interface for model.tmpl
+func tsModelData(model *grammar.Model) codegen.Model {
+	data := codegen.Model{Name: model.Name, Doc: codegen.DocLines(model.Leading, model.Trailing)}
+	for _, field := range model.Fields {
+		data.Fields = append(data.Fields, codegen.Field{
+			Name:     field.Name,
+			JSON:     field.JSONKey(),
+			Type:     FieldType(field.Type),
+			Optional: field.Type.Optional,
+			Doc:      codegen.DocLines(field.Leading, field.Trailing),
+		})
+	}
+	return data
+}
+
+// tsFunctionData translates a CloudPact function for function.tmpl
+func tsFunctionData(function *grammar.Function) codegen.Function {
+	data := codegen.Function{
+		Name:        function.Name,
+		Doc:         codegen.DocLines(function.Leading, function.Trailing),
+		Why:         function.Why,
+		Annotations: function.AIAnnotations,
+	}
+	for _, param := range function.Parameters {
+		data.Params = append(data.Params, codegen.Param{Name: param.Name, Type: FieldType(param.Type)})
+	}
+	for _, header := range codegen.RequestHeaders(function) {
+		tsType := "string"
+		if header.Direction == grammar.HeaderOptional {
+			tsType = "string | null"
+		}
+		data.Params = append(data.Params, codegen.Param{Name: header.Variable(), Type: tsType})
+	}
+	if function.ReturnType != nil {
+		data.Returns = FieldType(function.ReturnType)
+	}
+
+	// Function body
+	var body strings.Builder
+	if function.Body != nil {
+		body.WriteString("  // Business logic implementation\n")
+		for _, stmt := range function.Body.Statements {
+			body.WriteString(generateTSStatement(stmt, "  "))
+		}
+
+		// Add native TypeScript blocks
+		for _, nativeBlock := range function.Body.NativeBlocks {
+			if nativeBlock.Language == "ts" {
+				body.WriteString("  // Native TypeScript code block\n")
+				lines := strings.Split(nativeBlock.Code, "\n")
+				for _, line := range lines {
+					if strings.TrimSpace(line) != "" {
+						body.WriteString(fmt.Sprintf("  %s\n", line))
+					}
+				}
+			}
+		}
+
+		// Placeholder return when there is no CloudPact logic to translate
+		if function.ReturnType != nil && len(function.Body.Statements) == 0 {
+			switch FieldType(function.ReturnType) {
+			case "boolean":
+				body.WriteString("  return false;\n")
+			case "number":
+				body.WriteString("  return 0;\n")
+			case "string":
+				body.WriteString("  return '';\n")
+			default:
+				body.WriteString("  return null as any;\n")
+			}
+		}
+	}
+	data.Body = body.String()
+	return data
+}
+
+// generateTSStatement converts a CloudPact statement to TypeScript at the given indentation
+func generateTSStatement(stmt grammar.Statement, indent string) string {
+	switch s := stmt.(type) {
+	case *grammar.IfStatement:
+		var code strings.Builder
+		code.WriteString(fmt.Sprintf("%sif (%s) {\n", indent, generateTSExpression(s.Condition)))
+		if s.ThenStmt != nil {
+			code.WriteString(generateTSStatement(s.ThenStmt, indent+"  "))
+		}
+		code.WriteString(indent + "}")
+		if s.ElseStmt != nil {
+			code.WriteString(" else {\n")
+			code.WriteString(generateTSStatement(s.ElseStmt, indent+"  "))
+			code.WriteString(indent + "}")
+		}
+		code.WriteString("\n")
+		return code.String()
+	case *grammar.ReturnStatement:
+		if s.Value != nil {
+			return fmt.Sprintf("%sreturn %s;\n", indent, generateTSExpression(s.Value))
+		}
+		return indent + "return;\n"
+	case *grammar.AssignStatement:
+		if literal, ok := s.Value.(*grammar.LiteralExpression); ok && s.Variable == "__use__" {
+			return fmt.Sprintf("%s// use %v\n", indent, literal.Value)
+		}
+		return fmt.Sprintf("%slet %s = %s;\n", indent, s.Variable, generateTSExpression(s.Value))
+	case *grammar.CreateStatement:
+		var code strings.Builder
+		code.WriteString(fmt.Sprintf("%sconst %s = {\n", indent, strings.ToLower(s.TypeName)))
+		for _, assignment := range s.Assignments {
+			code.WriteString(fmt.Sprintf("%s  %s: %s,\n", indent, strings.ToLower(assignment.Field), generateTSExpression(assignment.Value)))
+		}
+		code.WriteString(indent + "} as " + s.TypeName + ";\n")
+		return code.String()
+	case *grammar.FailStatement:
+		return fmt.Sprintf("%sthrow new Error(%q);\n", indent, s.Message)
+	default:
+		return indent + "// Unknown statement type\n"
+	}
+}
+
+// generateTSExpression converts CloudPact expressions to TypeScript
+func generateTSExpression(expr grammar.Expression) string {
+	switch e := expr.(type) {
+	case *grammar.IdentifierExpression:
+		if e.Element {
+			return "item." + strings.ToLower(e.Name)
+		}
+		return e.Name
+	case *grammar.LiteralExpression:
+		return tsLiteral(e)
+	case *grammar.BinaryExpression:
+		left := generateTSExpression(e.Left)
+		right := generateTSExpression(e.Right)
+
+		switch e.Operator {
+		case "contains":
+			return fmt.Sprintf("%s.includes(%s)", left, right)
+		case "not contains":
+			return fmt.Sprintf("!%s.includes(%s)", left, right)
+		case "in":
+			return fmt.Sprintf("%s.includes(%s)", right, left)
+		case "not in":
+			return fmt.Sprintf("!%s.includes(%s)", right, left)
+		case "=", "not", "is", "is not":
+			// Loose equality with null also matches absent (undefined) fields
+			operator := map[string]string{"=": "===", "is": "===", "not": "!==", "is not": "!=="}[e.Operator]
+			if codegen.IsNone(e.Left) || codegen.IsNone(e.Right) {
+				operator = strings.TrimSuffix(operator, "=")
+			}
+			return fmt.Sprintf("%s %s %s", left, operator, right)
+		default:
+			return fmt.Sprintf("%s %s %s", left, e.Operator, right)
+		}
+	case *grammar.MemberExpression:
+		separator := "."
+		if e.Safe {
+			separator = "?."
+		}
+		// Interface properties are generated lowercased
+		return generateTSExpression(e.Object) + separator + strings.ToLower(e.Property)
+	case *grammar.EmptyExpression:
+		return generateTSEmptyCheck(e)
+	case *grammar.DefaultExpression:
+		return fmt.Sprintf("(%s ?? %s)", generateTSExpression(e.Value), generateTSExpression(e.Fallback))
+	case *grammar.ConditionalExpression:
+		return fmt.Sprintf("(%s ? %s : %s)", generateTSExpression(e.Condition),
+			generateTSExpression(e.Then), generateTSExpression(e.Else))
+	case *grammar.QueryExpression:
+		code := generateTSExpression(e.Source)
+		if e.Filter != nil {
+			code += fmt.Sprintf(".filter((item) => %s)", generateTSExpression(e.Filter))
+		}
+		if e.Select != nil {
+			code += fmt.Sprintf(".map((item) => %s)", generateTSExpression(e.Select))
+		}
+		return code
+	case *grammar.AggregateExpression:
+		return generateTSAggregate(e)
+	case *grammar.CallExpression:
+		var args []string
+		for _, arg := range e.Arguments {
+			args = append(args, generateTSExpression(arg))
+		}
+		return fmt.Sprintf("%s(%s)", e.Function, strings.Join(args, ", "))
+	default:
+		return "/* unknown expression */"
+	}
+}
+
+// generateTSAggregate converts count, sum and average into array method calls
+func generateTSAggregate(e *grammar.AggregateExpression) string {
+	collection, filter, value := codegen.AggregateParts(e)
+	items := generateTSExpression(collection)
+	if e.Function == "length" {
+		return items + ".length"
+	}
+	if e.Function == "first" && filter != nil && value == nil {
+		return fmt.Sprintf("(%s.find((item) => %s) ?? null)", items, generateTSExpression(filter))
+	}
+	if filter != nil {
+		items += fmt.Sprintf(".filter((item) => %s)", generateTSExpression(filter))
+	}
+
+	itemValue := "item"
+	if value != nil {
+		itemValue = generateTSExpression(value)
+	}
+
+	// Money is added up in whole cents with the same rounding as the Go output
+	if e.Rounding != "" && e.Function != "count" {
+		round := tsRoundingHelper(e.Rounding)
+		if e.Function == "sum" {
+			return fmt.Sprintf("%s.reduce((cents, item) => cents + %s(%s * 100), 0) / 100", items, round, itemValue)
+		}
+		if value != nil {
+			items += fmt.Sprintf(".map((item) => %s)", itemValue)
+		}
+		return fmt.Sprintf("((values) => values.length === 0 ? 0 : %s(values.reduce((cents, value) => cents + %s(value * 100), 0) / values.length) / 100)(%s)", round, round, items)
+	}
+
+	switch e.Function {
+	case "count":
+		return items + ".length"
+	case "first":
+		if value != nil {
+			items += fmt.Sprintf(".map((item) => %s)", itemValue)
+		}
+		return fmt.Sprintf("(%s[0] ?? null)", items)
+	case "last":
+		if value != nil {
+			items += fmt.Sprintf(".map((item) => %s)", itemValue)
+		}
+		return fmt.Sprintf("(%s.slice(-1)[0] ?? null)", items)
+	case "sum":
+		return fmt.Sprintf("%s.reduce((total, item) => total + %s, 0)", items, itemValue)
+	default:
+		if value != nil {
+			items += fmt.Sprintf(".map((item) => %s)", itemValue)
+		}
+		return fmt.Sprintf("((values) => values.length === 0 ? 0 : values.reduce((total, value) => total + value, 0) / values.length)(%s)", items)
+	}
+}
+
+// generateTSEmptyCheck tests the length of text and lists and the keys of
+// maps; optional values are also empty when null or undefined
+func generateTSEmptyCheck(e *grammar.EmptyExpression) string {
+	value := generateTSExpression(e.Value)
+	length := value + ".length"
+	if e.Type != nil && analyzer.KindOf(e.Type) == analyzer.KindMap {
+		length = fmt.Sprintf("Object.keys(%s).length", value)
+	}
+
+	empty, notEmpty := length+" === 0", length+" > 0"
+	if e.Type != nil && e.Type.Optional {
+		empty, notEmpty = fmt.Sprintf("(%s == null || %s)", value, empty), fmt.Sprintf("(%s != null && %s)", value, notEmpty)
+	}
+	if e.Negated {
+		return notEmpty
+	}
+	return empty
+}
+
+// tsRoundingHelpers are emitted into generated files that round money.
+// JavaScript's Math.round rounds halves up, unlike Go's math.Round, so both
+// modes get explicit implementations.
+const tsRoundingHelpers = `// Rounds half away from zero, like Go's math.Round
+function cpRoundHalfUp(value: number): number {
+  return Math.sign(value) * Math.round(Math.abs(value));
+}
+
+// Rounds half to even (banker's rounding), like Go's math.RoundToEven
+function cpRoundHalfEven(value: number): number {
+  const floor = Math.floor(value);
+  if (value - floor !== 0.5) {
+    return Math.round(value);
+  }
+  return floor % 2 === 0 ? floor : floor + 1;
+}
+
+`
+
+func tsRoundingHelper(mode string) string {
+	if mode == grammar.RoundBanker {
+		return "cpRoundHalfEven"
+	}
+	return "cpRoundHalfUp"
+}
@@ -0,0 +1,65 @@
+package tsgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// generateTSFunctionClient calls a function that declares headers over
+// HTTP, taking its request headers as a typed argument. A returned record
+// with secrets is typed as its Response, as the server leaves them out.
+func generateTSFunctionClient(function *grammar.Function, secret map[string]bool) string {
+	var code strings.Builder
+	name := strings.ToUpper(function.Name[:1]) + function.Name[1:]
+
+	var params, headers []string
+	for _, param := range function.Parameters {
+		params = append(params, fmt.Sprintf("%s: %s", param.Name, FieldType(param.Type)))
+	}
+	for _, header := range codegen.RequestHeaders(function) {
+		optional := ""
+		if header.Direction == grammar.HeaderOptional {
+			optional = "?"
+		}
+		headers = append(headers, fmt.Sprintf("'%s'%s: string", header.Name, optional))
+	}
+	returns := "void"
+	if function.ReturnType != nil {
+		returns = FieldType(tsResponseType(function.ReturnType, secret))
+	}
+	paramType := "Record<string, never>"
+	if len(params) > 0 {
+		paramType = "{ " + strings.Join(params, "; ") + " }"
+	}
+
+	code.WriteString(fmt.Sprintf("// call%s calls %s on the server at POST /%s\n", name, function.Name, strings.ToLower(function.Name)))
+	if emitted := codegen.EmittedHeaderNames(function); len(emitted) > 0 {
+		code.WriteString(fmt.Sprintf("// and passes its response headers (%s) to onHeaders\n", strings.Join(emitted, ", ")))
+	}
+	code.WriteString(fmt.Sprintf("export async function call%s(baseUrl: string, params: %s, headers: { %s }, onHeaders?: (headers: Headers) => void): Promise<%s> {\n",
+		name, paramType, strings.Join(headers, "; "), returns))
+	code.WriteString("  const sent: Record<string, string> = { 'Content-Type': 'application/json' };\n")
+	code.WriteString("  for (const [name, value] of Object.entries(headers)) {\n")
+	code.WriteString("    if (value !== undefined) {\n")
+	code.WriteString("      sent[name] = value;\n")
+	code.WriteString("    }\n")
+	code.WriteString("  }\n")
+	code.WriteString(fmt.Sprintf("  const res = await fetch(`${baseUrl}/%s`, {\n", strings.ToLower(function.Name)))
+	code.WriteString("    method: 'POST',\n")
+	code.WriteString("    headers: sent,\n")
+	code.WriteString("    body: JSON.stringify(params),\n")
+	code.WriteString("  });\n")
+	code.WriteString("  if (!res.ok) {\n")
+	code.WriteString("    throw new Error(res.statusText);\n")
+	code.WriteString("  }\n")
+	code.WriteString("  onHeaders?.(res.headers);\n")
+	if function.ReturnType != nil {
+		code.WriteString("  return res.json();\n")
+	}
+	code.WriteString("}\n\n")
+
+	return code.String()
+}
@@ -0,0 +1,75 @@
+package tsgen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// GenerateQueues writes a TypeScript module naming the queues of the
+// checked files and the payload record each one's tasks carry, as the
+// QueueTasks map. imports[i] is the module the interfaces of files[i] are
+// imported from. Payloads of the same name from different modules are an
+// error, as they cannot both be imported.
+func GenerateQueues(files []*grammar.File, imports []string, opts Options) ([]byte, error) {
+	var modules []string
+	payloads := make(map[string][]string) // module to the payloads imported from it
+	from := make(map[string]string)       // payload to its module
+	for i, file := range files {
+		for _, queue := range file.Queues {
+			module, ok := from[queue.Payload]
+			if ok && module != imports[i] {
+				return nil, fmt.Errorf("queue payload %s is declared in both %s and %s", queue.Payload, module, imports[i])
+			}
+			if ok {
+				continue
+			}
+			from[queue.Payload] = imports[i]
+			if _, ok := payloads[imports[i]]; !ok {
+				modules = append(modules, imports[i])
+			}
+			payloads[imports[i]] = append(payloads[imports[i]], queue.Payload)
+		}
+	}
+
+	var code strings.Builder
+	for _, module := range modules {
+		names := payloads[module]
+		sort.Strings(names)
+		code.WriteString(fmt.Sprintf("import type { %s } from %q;\n", strings.Join(names, ", "), module))
+	}
+
+	var tasks strings.Builder
+	for _, file := range files {
+		for _, queue := range file.Queues {
+			doc := codegen.DocLines(queue.Leading, queue.Trailing)
+			if queue.Why != "" {
+				doc = append(doc, "Why: "+queue.Why)
+			}
+			code.WriteString("\n")
+			if len(doc) > 0 {
+				code.WriteString("/**\n")
+				for _, line := range doc {
+					code.WriteString(strings.TrimRight(" * "+line, " ") + "\n")
+				}
+				code.WriteString(" */\n")
+			}
+			code.WriteString(fmt.Sprintf("export const %s = %q as const;\n", exportedName(queue.Name), queue.Name))
+			tasks.WriteString(fmt.Sprintf("  %s: %s;\n", queue.Name, queue.Payload))
+		}
+	}
+
+	code.WriteString("\n/** The payload the tasks of each queue carry */\n")
+	code.WriteString("export interface QueueTasks {\n" + tasks.String() + "}\n\n")
+	code.WriteString("/** The name of a queue */\n")
+	code.WriteString("export type QueueName = keyof QueueTasks;\n")
+	return codegen.Stamp("//", opts.Header, []byte(code.String())), nil
+}
+
+// exportedName capitalizes name, as the constant naming a queue
+func exportedName(name string) string {
+	return strings.ToUpper(name[:1]) + name[1:]
+}
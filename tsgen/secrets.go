@@ -0,0 +1,58 @@
+package tsgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// tsSecretRecords names the records of file with fields of secret types,
+// such as password, directly or through the record they extend. The server
+// sends them without those fields.
+func tsSecretRecords(file *grammar.File) map[string]bool {
+	secret := make(map[string]bool)
+	for _, record := range file.Records {
+		if len(tsSecretKeys(record)) > 0 {
+			secret[record.Name] = true
+		}
+	}
+	return secret
+}
+
+// tsSecretKeys are the JSON keys of record's fields of secret types
+func tsSecretKeys(record *grammar.Record) []string {
+	var keys []string
+	for _, field := range record.AllFields() {
+		if codegen.IsSecret(field.Type.Name) {
+			keys = append(keys, fmt.Sprintf("%q", field.JSONKey()))
+		}
+	}
+	return keys
+}
+
+// generateTSResponse declares <Record>Response, the record as the server
+// sends it, without its secrets
+func generateTSResponse(record *grammar.Record) string {
+	var code strings.Builder
+	name := record.Name
+	code.WriteString(fmt.Sprintf("// %sResponse is %s as the server sends it, without its secrets\n", name, name))
+	code.WriteString(fmt.Sprintf("export type %sResponse = Omit<%s, %s>;\n\n", name, name, strings.Join(tsSecretKeys(record), " | ")))
+	return code.String()
+}
+
+// tsResponseType is t as the server sends it: records named in secret, even as
+// list elements or map values, are replaced by their Response types
+func tsResponseType(t *grammar.Type, secret map[string]bool) *grammar.Type {
+	if t == nil {
+		return nil
+	}
+	response := *t
+	if secret[t.Name] {
+		response.Name += "Response"
+	}
+	response.Element = tsResponseType(t.Element, secret)
+	response.Value = tsResponseType(t.Value, secret)
+	return &response
+}
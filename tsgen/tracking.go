@@ -0,0 +1,65 @@
+package tsgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// tsTrackingHelpers are emitted once into generated files with tracked records
+const tsTrackingHelpers = `// A record wrapped to remember which fields were assigned
+export interface Tracked<T> {
+  record: T;
+  changed(): (keyof T)[];
+  patch(): Partial<T>;
+  clear(): void;
+}
+
+function trackChanges<T extends object>(record: T): Tracked<T> {
+  const dirty = new Set<keyof T>();
+  const proxy = new Proxy(record, {
+    set(target, key, value) {
+      (target as any)[key] = value;
+      dirty.add(key as keyof T);
+      return true;
+    },
+  });
+  return {
+    record: proxy,
+    changed: () => Array.from(dirty),
+    patch: () => {
+      const patch: Partial<T> = {};
+      dirty.forEach((key) => {
+        patch[key] = record[key];
+      });
+      return patch;
+    },
+    clear: () => dirty.clear(),
+  };
+}
+
+`
+
+// generateTSChangeTracking adds a <Record>Patch type and helpers to track
+// and apply partial updates
+func generateTSChangeTracking(record *grammar.Record) string {
+	var code strings.Builder
+	name := record.Name
+	variable := strings.ToLower(name[:1]) + name[1:]
+
+	code.WriteString(fmt.Sprintf("// %sPatch holds a partial %s update\n", name, name))
+	code.WriteString(fmt.Sprintf("export type %sPatch = Partial<Omit<%s, 'id'>>;\n\n", name, name))
+
+	code.WriteString(fmt.Sprintf("// track%s records assignments to the returned record for PATCH requests\n", name))
+	code.WriteString(fmt.Sprintf("export function track%s(%s: %s): Tracked<%s> {\n", name, variable, name, name))
+	code.WriteString(fmt.Sprintf("  return trackChanges(%s);\n", variable))
+	code.WriteString("}\n\n")
+
+	code.WriteString(fmt.Sprintf("// apply%sPatch returns a copy of %s with the patched fields replaced\n", name, variable))
+	code.WriteString(fmt.Sprintf("export function apply%sPatch(%s: %s, patch: %sPatch): %s {\n", name, variable, name, name, name))
+	code.WriteString(fmt.Sprintf("  return { ...%s, ...patch };\n", variable))
+	code.WriteString("}\n\n")
+
+	return code.String()
+}
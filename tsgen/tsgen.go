@@ -1,140 +1,604 @@
+// Package tsgen writes TypeScript: GenerateFile translates CloudPact files
+// into interfaces and functions, and Generate and GenerateClient turn an
+// OpenAPI spec into interfaces and a typed API client.
 package tsgen
 
 import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 
+	"github.com/daveroberts0321/cloudpact/codegen"
 	"gopkg.in/yaml.v2"
 )
 
-// Generate reads an OpenAPI spec in YAML format and emits TypeScript
-// interfaces and a simple API client stub under generated/ts/.
-// The parser understands the limited YAML subset produced by the openapi
-// package in this repository.
-func Generate(specPath string) error {
+// Generate reads an OpenAPI spec in YAML format and writes TypeScript
+// interfaces, one file per schema, and a typed API client, client.ts, into
+// dir. Any OpenAPI 3 document works, not only those the openapi package
+// writes. Schemas named after JavaScript globals are renamed by tsName,
+// so a schema Error is declared as ErrorBody in ErrorBody.ts.
+func Generate(specPath, dir string) error {
 	data, err := os.ReadFile(specPath)
 	if err != nil {
 		return err
 	}
-	schemas, err := parseSchemas(string(data))
-	if err != nil {
-		return err
+	var doc document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", specPath, err)
 	}
-	if err := os.MkdirAll(filepath.Join("generated", "ts"), 0755); err != nil {
+	schemas := parseSchemas(&doc)
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
+	names := schemaNames(schemas)
+	for _, name := range names {
+		file := filepath.Join(dir, name+".ts")
+		if err := os.WriteFile(file, []byte(declaration(name, schemas[name])), 0644); err != nil {
+			return err
+		}
+	}
+
+	// The client imports the schemas its methods use
+	methods := clientMethods(&doc, schemas)
+	var b strings.Builder
+	for _, name := range names {
+		var imported []string
+		for _, t := range []string{name, name + "Response"} {
+			if usesType(methods, t) {
+				imported = append(imported, t)
+			}
+		}
+		if len(imported) > 0 {
+			fmt.Fprintf(&b, "import { %s } from \"./%s\";\n", strings.Join(imported, ", "), name)
+		}
+	}
+	b.WriteString(clientPreamble + methods + "}\n")
+	return os.WriteFile(filepath.Join(dir, "client.ts"), []byte(b.String()), 0644)
+}
+
+// GenerateClient translates an OpenAPI spec in YAML format into one
+// TypeScript module declaring its schemas and an APIClient with a method
+// per operation
+func GenerateClient(spec []byte, opts Options) ([]byte, error) {
+	var doc document
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+	schemas := parseSchemas(&doc)
+	var b strings.Builder
+	for i, name := range schemaNames(schemas) {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(declaration(name, schemas[name]))
+	}
+	b.WriteString(clientPreamble + clientMethods(&doc, schemas) + "}\n")
+	return codegen.Stamp("//", opts.Header, []byte(b.String())), nil
+}
+
+// document is the part of an OpenAPI document tsgen reads
+type document struct {
+	Paths      map[string]*pathItem `yaml:"paths"`
+	Components struct {
+		Schemas map[string]*schema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+// pathItem is the operations of a path, by HTTP method
+type pathItem struct {
+	Get    *operation `yaml:"get"`
+	Post   *operation `yaml:"post"`
+	Put    *operation `yaml:"put"`
+	Patch  *operation `yaml:"patch"`
+	Delete *operation `yaml:"delete"`
+}
+
+// operations lists the operations of the path in a fixed order, each with
+// its HTTP method
+func (item *pathItem) operations() []struct {
+	method string
+	op     *operation
+} {
+	var ops []struct {
+		method string
+		op     *operation
+	}
+	for _, m := range []struct {
+		method string
+		op     *operation
+	}{{"GET", item.Get}, {"POST", item.Post}, {"PUT", item.Put}, {"PATCH", item.Patch}, {"DELETE", item.Delete}} {
+		if m.op != nil {
+			ops = append(ops, m)
+		}
+	}
+	return ops
+}
+
+// object is a parsed schema: field types, which fields may be omitted, and
+// which are writeOnly, sent in requests but never in responses, or
+// readOnly, sent in responses but never in requests. Schemas other than
+// objects, such as enums, are aliases of a TypeScript type.
+type object struct {
+	fields    map[string]string
+	optional  map[string]bool
+	writeOnly []string // sorted
+	readOnly  []string // sorted
+	alias     string
+}
+
+// parseSchemas converts the component schemas into TypeScript
+// declarations, by the names tsName gives them
+func parseSchemas(doc *document) map[string]*object {
+	result := make(map[string]*object)
+	for name, s := range doc.Components.Schemas {
+		if s == nil {
+			continue
+		}
+		if s.Properties == nil && (s.Ref != "" || len(s.Enum) > 0 || len(s.AllOf)+len(s.OneOf)+len(s.AnyOf) > 0 ||
+			(s.Type.name() != "object" && s.Type.name() != "")) {
+			result[tsName(name)] = &object{alias: resolveType(s)}
+			continue
+		}
+		result[tsName(name)] = newObject(s)
+	}
+	return result
+}
+
+// schemaNames lists the names of schemas in order
+func schemaNames(schemas map[string]*object) []string {
 	names := make([]string, 0, len(schemas))
 	for name := range schemas {
 		names = append(names, name)
 	}
 	sort.Strings(names)
-	for _, name := range names {
-		if err := writeInterface(name, schemas[name]); err != nil {
-			return err
+	return names
+}
+
+// tsGlobals are the names of JavaScript and DOM globals that a schema of
+// the same name would shadow, such as the Error the client throws
+var tsGlobals = map[string]bool{"Array": true, "Boolean": true, "Date": true, "Error": true, "Function": true, "Map": true, "Number": true, "Object": true, "Promise": true, "Record": true, "Request": true, "Response": true, "Set": true, "String": true, "Symbol": true}
+
+// tsName is the name the client refers to a schema by: its own, or with
+// Body appended when it is the name of a global, e.g. ErrorBody
+func tsName(name string) string {
+	if tsGlobals[name] {
+		return name + "Body"
+	}
+	return name
+}
+
+// newObject resolves the fields of an object schema
+func newObject(s *schema) *object {
+	required := make(map[string]bool)
+	for _, fname := range s.Required {
+		required[fname] = true
+	}
+	obj := &object{fields: make(map[string]string), optional: make(map[string]bool)}
+	for fname, f := range s.Properties {
+		obj.fields[fname] = resolveType(f)
+		obj.optional[fname] = !required[fname]
+		if f.WriteOnly {
+			obj.writeOnly = append(obj.writeOnly, fname)
+		}
+		if f.ReadOnly {
+			obj.readOnly = append(obj.readOnly, fname)
 		}
 	}
-	return writeClient(names)
+	sort.Strings(obj.writeOnly)
+	sort.Strings(obj.readOnly)
+	return obj
+}
+
+type operation struct {
+	OperationID string   `yaml:"operationId"`
+	Tags        []string `yaml:"tags"`
+	Parameters  []struct {
+		Name     string `yaml:"name"`
+		In       string `yaml:"in"`
+		Required bool   `yaml:"required"`
+	} `yaml:"parameters"`
+	RequestBody struct {
+		Content map[string]*mediaType `yaml:"content"`
+	} `yaml:"requestBody"`
+	Responses map[string]*struct {
+		Content map[string]*mediaType `yaml:"content"`
+	} `yaml:"responses"`
+}
+
+type mediaType struct {
+	Schema *schema `yaml:"schema"`
+}
+
+type schema struct {
+	Type                 schemaType         `yaml:"type"`
+	Ref                  string             `yaml:"$ref"`
+	Properties           map[string]*schema `yaml:"properties"`
+	AdditionalProperties *extraProperties   `yaml:"additionalProperties"`
+	Items                *schema            `yaml:"items"`
+	AllOf                []*schema          `yaml:"allOf"`
+	OneOf                []*schema          `yaml:"oneOf"`
+	AnyOf                []*schema          `yaml:"anyOf"`
+	Enum                 []interface{}      `yaml:"enum"`
+	Required             []string           `yaml:"required"`
+	Nullable             bool               `yaml:"nullable"`
+	WriteOnly            bool               `yaml:"writeOnly"`
+	ReadOnly             bool               `yaml:"readOnly"`
 }
 
-// parseSchemas extracts schema definitions from the OpenAPI YAML using a YAML parser.
-func parseSchemas(data string) (map[string]map[string]string, error) {
-	var doc struct {
-		Components struct {
-			Schemas map[string]*schema `yaml:"schemas"`
-		} `yaml:"components"`
+// schemaType is the type of a schema, a single name in OpenAPI 3.0 and
+// a list such as [string, "null"] in 3.1
+type schemaType []string
+
+func (t *schemaType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*t = schemaType{name}
+		return nil
+	}
+	var names []string
+	if err := unmarshal(&names); err != nil {
+		return err
 	}
+	*t = names
+	return nil
+}
 
-	if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
-		return nil, err
+// name is the type other than "null", or "" when none is given
+func (t schemaType) name() string {
+	for _, name := range t {
+		if name != "null" {
+			return name
+		}
 	}
+	return ""
+}
 
-	result := make(map[string]map[string]string)
-	for name, s := range doc.Components.Schemas {
-		fields := make(map[string]string)
-		for fname, f := range s.Properties {
-			fields[fname] = resolveType(f)
+func (t schemaType) nullable() bool {
+	for _, name := range t {
+		if name == "null" {
+			return true
 		}
-		result[name] = fields
 	}
-	return result, nil
+	return false
 }
 
-type schema struct {
-	Type       string             `yaml:"type"`
-	Ref        string             `yaml:"$ref"`
-	Properties map[string]*schema `yaml:"properties"`
-	Items      *schema            `yaml:"items"`
+// extraProperties is additionalProperties: either true/false or a schema
+// for the values of a map
+type extraProperties struct {
+	allowed bool
+	schema  *schema
 }
 
+func (e *extraProperties) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&e.allowed); err == nil {
+		return nil
+	}
+	e.allowed = true
+	return unmarshal(&e.schema)
+}
+
+// resolveType converts a schema into a TypeScript type
 func resolveType(s *schema) string {
 	if s == nil {
 		return "any"
 	}
+	if s.Nullable || s.Type.nullable() {
+		inner := *s
+		inner.Nullable = false
+		inner.Type = schemaType{s.Type.name()}
+		return resolveType(&inner) + " | null"
+	}
 	if s.Ref != "" {
 		parts := strings.Split(s.Ref, "/")
-		return parts[len(parts)-1]
+		return tsName(parts[len(parts)-1])
+	}
+	if len(s.Enum) > 0 {
+		values := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			if text, ok := v.(string); ok {
+				values[i] = fmt.Sprintf("%q", text)
+			} else {
+				values[i] = fmt.Sprint(v)
+			}
+		}
+		return strings.Join(values, " | ")
+	}
+	if len(s.AllOf) > 0 {
+		return combine(s.AllOf, " & ")
+	}
+	if len(s.OneOf) > 0 {
+		return combine(s.OneOf, " | ")
+	}
+	if len(s.AnyOf) > 0 {
+		return combine(s.AnyOf, " | ")
 	}
-	switch s.Type {
+	switch s.Type.name() {
 	case "array":
-		return resolveType(s.Items) + "[]"
-	case "object":
-		return "object"
+		return group(resolveType(s.Items)) + "[]"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "string":
+		return "string"
+	case "object", "":
+		if len(s.Properties) > 0 {
+			return "{ " + strings.Join(newObject(s).members(), "; ") + " }"
+		}
+		if extra := s.AdditionalProperties; extra != nil && extra.allowed {
+			if extra.schema != nil {
+				return fmt.Sprintf("Record<string, %s>", resolveType(extra.schema))
+			}
+			return "Record<string, unknown>"
+		}
+		return "any"
 	default:
-		return s.Type
+		return "any"
 	}
 }
 
-func writeInterface(name string, fields map[string]string) error {
+// combine joins the types of schemas with op, " & " or " | "
+func combine(schemas []*schema, op string) string {
+	types := make([]string, len(schemas))
+	for i, s := range schemas {
+		types[i] = group(resolveType(s))
+	}
+	return strings.Join(types, op)
+}
+
+// group parenthesizes union and intersection types so they can be nested
+func group(t string) string {
+	if strings.Contains(t, " | ") || strings.Contains(t, " & ") {
+		if !strings.HasPrefix(t, "{") || !strings.HasSuffix(t, "}") {
+			return "(" + t + ")"
+		}
+	}
+	return t
+}
+
+// declaration renders a schema as an exported TypeScript interface, or a
+// type alias for schemas other than objects. Objects with writeOnly fields
+// also get a <name>Response type without them.
+func declaration(name string, obj *object) string {
 	var b strings.Builder
+	if obj.alias != "" {
+		fmt.Fprintf(&b, "export type %s = %s;\n", name, obj.alias)
+		return b.String()
+	}
 	fmt.Fprintf(&b, "export interface %s {\n", name)
-	keys := make([]string, 0, len(fields))
-	for k := range fields {
+	for _, field := range obj.members() {
+		fmt.Fprintf(&b, "  %s;\n", field)
+	}
+	b.WriteString("}\n")
+	if len(obj.writeOnly) > 0 {
+		keys := make([]string, len(obj.writeOnly))
+		for i, key := range obj.writeOnly {
+			keys[i] = fmt.Sprintf("%q", key)
+		}
+		fmt.Fprintf(&b, "\n// %sResponse is a %s as responses carry it, without writeOnly fields\n", name, name)
+		fmt.Fprintf(&b, "export type %sResponse = Omit<%s, %s>;\n", name, name, strings.Join(keys, " | "))
+	}
+	return b.String()
+}
+
+// response is the type of responses carrying values of type t: schemas
+// with writeOnly fields, and lists of them, are read as their Response types
+func response(t string, schemas map[string]*object) string {
+	name := strings.TrimSuffix(t, "[]")
+	if obj := schemas[name]; obj != nil && len(obj.writeOnly) > 0 {
+		return name + "Response" + t[len(name):]
+	}
+	return t
+}
+
+// members renders the fields as sorted TypeScript members, e.g. "note?: string"
+func (obj *object) members() []string {
+	keys := make([]string, 0, len(obj.fields))
+	for k := range obj.fields {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
+	members := make([]string, 0, len(keys))
 	for _, k := range keys {
-		fmt.Fprintf(&b, "  %s: %s;\n", k, mapType(fields[k]))
+		marker := ""
+		if obj.optional[k] {
+			marker = "?"
+		}
+		name := k
+		if strings.ContainsAny(k, "-.") {
+			name = fmt.Sprintf("%q", k)
+		}
+		members = append(members, fmt.Sprintf("%s%s: %s", name, marker, obj.fields[k]))
 	}
-	b.WriteString("}\n")
-	file := filepath.Join("generated", "ts", fmt.Sprintf("%s.ts", name))
-	return os.WriteFile(file, []byte(b.String()), 0644)
+	return members
 }
 
-func writeClient(names []string) error {
+// clientPreamble declares the client and the request helper its methods share
+const clientPreamble = `
+// FetchLike is the subset of fetch the client needs; pass your own to add
+// auth, retries or a test double
+export type FetchLike = (input: string, init?: RequestInit) => Promise<Response>;
+
+export class APIClient {
+  constructor(private baseUrl: string, private fetchImpl: FetchLike = (input, init) => fetch(input, init)) {}
+
+  private async request<T>(method: string, path: string, body?: unknown, headers: Record<string, string | undefined> = {}, contentType = "application/json"): Promise<T> {
+    const sent: Record<string, string> = {};
+    for (const [name, value] of Object.entries(headers)) {
+      if (value !== undefined) {
+        sent[name] = value;
+      }
+    }
+    const init: RequestInit = { method, headers: sent };
+    if (body !== undefined) {
+      sent["Content-Type"] = contentType;
+      init.body = JSON.stringify(body);
+    }
+    const res = await this.fetchImpl(` + "`${this.baseUrl}${path}`" + `, init);
+    if (!res.ok) {
+      throw new Error(res.statusText);
+    }
+    if (res.status === 204) {
+      return undefined as T;
+    }
+    return res.json();
+  }
+`
+
+// clientMethods renders a client method for each operation of the spec's
+// paths, in order of path and method
+func clientMethods(doc *document, schemas map[string]*object) string {
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
 	var b strings.Builder
-	for _, n := range names {
-		fmt.Fprintf(&b, "import { %s } from \"./%s\";\n", n, n)
+	for _, path := range paths {
+		if doc.Paths[path] == nil {
+			continue
+		}
+		for _, m := range doc.Paths[path].operations() {
+			writeMethod(&b, path, m.method, m.op, schemas)
+		}
 	}
-	b.WriteString("\nexport class APIClient {\n  constructor(private baseUrl: string) {}\n")
-	for _, n := range names {
-		lower := strings.ToLower(n)
-		fmt.Fprintf(&b, "  async get%s(id: string): Promise<%s> {\n", n, n)
-		fmt.Fprintf(&b, "    const res = await fetch(`${this.baseUrl}/%s/${id}`);\n", lower)
-		b.WriteString("    if (!res.ok) {\n      throw new Error(res.statusText);\n    }\n")
-		b.WriteString("    return res.json();\n  }\n")
+	return b.String()
+}
+
+// pathParam matches the parameters of path templates, e.g. {id}
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// writeMethod renders the client method calling one operation. Arguments
+// are the path parameters, then the request body, then the header
+// parameters.
+func writeMethod(b *strings.Builder, path, method string, op *operation, schemas map[string]*object) {
+	var args []string
+	for _, param := range pathParam.FindAllStringSubmatch(path, -1) {
+		args = append(args, param[1]+": string")
 	}
-	b.WriteString("}\n")
-	file := filepath.Join("generated", "ts", "client.ts")
-	return os.WriteFile(file, []byte(b.String()), 0644)
+	url := fmt.Sprintf("%q", path)
+	if len(args) > 0 {
+		url = "`" + pathParam.ReplaceAllString(path, "${encodeURIComponent($1)}") + "`"
+	}
+
+	body, contentType := "undefined", ""
+	for _, media := range []string{"application/json", "application/merge-patch+json"} {
+		content := op.RequestBody.Content[media]
+		if content == nil || content.Schema == nil {
+			continue
+		}
+		body = "body"
+		if media != "application/json" {
+			contentType = media
+		}
+		switch t := resolveType(content.Schema); {
+		case content.Schema.Ref == "" && len(content.Schema.Properties) > 0:
+			body = "params"
+			args = append(args, fmt.Sprintf("params: { %s }", strings.Join(newObject(content.Schema).members(), "; ")))
+		case schemas[t] != nil && len(schemas[t].readOnly) > 0:
+			// The server assigns readOnly fields such as the id
+			keys := make([]string, len(schemas[t].readOnly))
+			for i, key := range schemas[t].readOnly {
+				keys[i] = fmt.Sprintf("%q", key)
+			}
+			args = append(args, fmt.Sprintf("body: Omit<%s, %s>", t, strings.Join(keys, " | ")))
+		default:
+			args = append(args, "body: "+t)
+		}
+		break
+	}
+
+	headers := &object{fields: make(map[string]string), optional: make(map[string]bool)}
+	for _, param := range op.Parameters {
+		if param.In == "header" {
+			headers.fields[param.Name] = "string"
+			headers.optional[param.Name] = !param.Required
+		}
+	}
+	call := []string{fmt.Sprintf("%q", method), url}
+	switch {
+	case contentType != "":
+		call = append(call, body, "{}", fmt.Sprintf("%q", contentType))
+	case len(headers.fields) > 0:
+		call = append(call, body, "{}")
+	case body != "undefined":
+		call = append(call, body)
+	}
+	if len(headers.fields) > 0 {
+		args = append(args, fmt.Sprintf("headers: { %s }", strings.Join(headers.members(), "; ")))
+		call[3] = "headers"
+	}
+
+	returns := "void"
+	for _, status := range []string{"200", "201"} {
+		if ok := op.Responses[status]; ok != nil {
+			if media := ok.Content["application/json"]; media != nil {
+				returns = response(resolveType(media.Schema), schemas)
+				break
+			}
+		}
+	}
+	fmt.Fprintf(b, "\n  %s(%s): Promise<%s> {\n", methodName(path, method, op), strings.Join(args, ", "), returns)
+	fmt.Fprintf(b, "    return this.request(%s);\n  }\n", strings.Join(call, ", "))
 }
 
-func mapType(t string) string {
-	if strings.HasSuffix(t, "[]") {
-		return mapType(strings.TrimSuffix(t, "[]")) + "[]"
+// methodName names the method calling an operation: its operationId, or
+// for the paths the openapi package generates for models and records, tagged
+// with their name, list<Name>s, create<Name>, get<Name>, update<Name>,
+// patch<Name> and delete<Name>. Other operations are named after their
+// method and path, e.g. getOrdersByID for GET /orders/{id}.
+func methodName(path, method string, op *operation) string {
+	if op.OperationID != "" {
+		return op.OperationID
 	}
-	switch t {
-	case "integer", "number":
-		return "number"
-	case "boolean":
-		return "boolean"
-	case "string":
-		return "string"
-	case "object", "any":
-		return "any"
-	default:
-		return t
+	item := strings.Contains(path, "{")
+	if len(op.Tags) > 0 {
+		tag := tsName(op.Tags[0])
+		switch {
+		case method == "GET" && !item:
+			return "list" + tag + "s"
+		case method == "POST" && !item:
+			return "create" + tag
+		case method == "GET":
+			return "get" + tag
+		case method == "PUT":
+			return "update" + tag
+		case method == "PATCH":
+			return "patch" + tag
+		case method == "DELETE":
+			return "delete" + tag
+		}
+	}
+	name := strings.ToLower(method)
+	for _, segment := range strings.Split(path, "/") {
+		if param := pathParam.FindStringSubmatch(segment); param != nil {
+			name += "By" + identifier(param[1])
+		} else {
+			name += identifier(segment)
+		}
 	}
+	return name
+}
+
+// nonWord matches what separates the words of a path segment
+var nonWord = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// identifier capitalizes the words of s and drops what a TypeScript
+// identifier cannot hold, e.g. "line-items" becomes LineItems
+func identifier(s string) string {
+	var b strings.Builder
+	for _, word := range nonWord.Split(s, -1) {
+		if word != "" {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
+
+// usesType reports whether TypeScript code refers to the type name
+func usesType(code, name string) bool {
+	return regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\b`).MatchString(code)
 }
@@ -5,6 +5,11 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/analyzer"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+	"github.com/daveroberts0321/cloudpact/spec/openapi"
 )
 
 func TestGenerate(t *testing.T) {
@@ -43,15 +48,10 @@ components:
 	if err := os.WriteFile(specPath, []byte(spec), 0644); err != nil {
 		t.Fatalf("write spec: %v", err)
 	}
-	cwd, _ := os.Getwd()
-	defer os.Chdir(cwd)
-	if err := os.Chdir(dir); err != nil {
-		t.Fatalf("chdir: %v", err)
-	}
-	if err := Generate(specPath); err != nil {
+	if err := Generate(specPath, dir); err != nil {
 		t.Fatalf("Generate: %v", err)
 	}
-	userIface, err := os.ReadFile(filepath.Join(dir, "generated/ts/User.ts"))
+	userIface, err := os.ReadFile(filepath.Join(dir, "User.ts"))
 	if err != nil {
 		t.Fatalf("read user interface: %v", err)
 	}
@@ -64,14 +64,14 @@ components:
 	if !strings.Contains(string(userIface), "addresses: Address[];") {
 		t.Fatalf("array of objects not generated: %s", string(userIface))
 	}
-	addrIface, err := os.ReadFile(filepath.Join(dir, "generated/ts/Address.ts"))
+	addrIface, err := os.ReadFile(filepath.Join(dir, "Address.ts"))
 	if err != nil {
 		t.Fatalf("read address interface: %v", err)
 	}
 	if !strings.Contains(string(addrIface), "export interface Address") {
 		t.Fatalf("address interface not generated: %s", string(addrIface))
 	}
-	client, err := os.ReadFile(filepath.Join(dir, "generated/ts/client.ts"))
+	client, err := os.ReadFile(filepath.Join(dir, "client.ts"))
 	if err != nil {
 		t.Fatalf("read client: %v", err)
 	}
@@ -79,3 +79,625 @@ components:
 		t.Fatalf("client not generated: %s", string(client))
 	}
 }
+
+func TestGeneratePatchClient(t *testing.T) {
+	spec := `openapi: "3.0.0"
+paths:
+  /accounts/{id}:
+    patch:
+      tags: [Account]
+      requestBody:
+        content:
+          application/merge-patch+json:
+            schema:
+              $ref: '#/components/schemas/AccountPatch'
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Account'
+components:
+  schemas:
+    Account:
+      type: object
+      properties:
+        id:
+          type: string
+        note:
+          type: string
+      required: [id]
+    AccountPatch:
+      type: object
+      properties:
+        note:
+          type: string
+          nullable: true
+`
+	dir := t.TempDir()
+	specPath := filepath.Join(dir, "spec.yaml")
+	if err := os.WriteFile(specPath, []byte(spec), 0644); err != nil {
+		t.Fatalf("write spec: %v", err)
+	}
+	if err := Generate(specPath, dir); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	account, _ := os.ReadFile(filepath.Join(dir, "Account.ts"))
+	if !strings.Contains(string(account), "  id: string;") || !strings.Contains(string(account), "  note?: string;") {
+		t.Fatalf("optional fields not marked: %s", account)
+	}
+	patch, _ := os.ReadFile(filepath.Join(dir, "AccountPatch.ts"))
+	if !strings.Contains(string(patch), "  note?: string | null;") {
+		t.Fatalf("nullable patch field not generated: %s", patch)
+	}
+	client, _ := os.ReadFile(filepath.Join(dir, "client.ts"))
+	for _, want := range []string{
+		"patchAccount(id: string, body: AccountPatch): Promise<Account> {",
+		"return this.request(\"PATCH\", `/accounts/${encodeURIComponent(id)}`, body, {}, \"application/merge-patch+json\");",
+	} {
+		if !strings.Contains(string(client), want) {
+			t.Fatalf("expected %q in client:\n%s", want, client)
+		}
+	}
+	for _, unwanted := range []string{"getAccount", "listAccountPatchs", "import { AccountPatch, AccountPatchResponse }"} {
+		if strings.Contains(string(client), unwanted) {
+			t.Fatalf("unexpected %q in client:\n%s", unwanted, client)
+		}
+	}
+}
+
+func TestGenerateClientMethods(t *testing.T) {
+	spec := `openapi: "3.0.0"
+paths:
+  /accounts:
+    get:
+      tags: [Account]
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  $ref: '#/components/schemas/Account'
+    post:
+      tags: [Account]
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Account'
+      responses:
+        "201":
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Account'
+  /accounts/{id}:
+    parameters:
+      - name: id
+        in: path
+        required: true
+    get:
+      tags: [Account]
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Account'
+    put:
+      tags: [Account]
+      parameters:
+        - name: If-Match
+          in: header
+          required: true
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Account'
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Account'
+    delete:
+      tags: [Account]
+      responses:
+        "204":
+          description: Deleted
+  /reports/{year}/line-items:
+    get:
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  type: string
+  /transfer:
+    post:
+      operationId: Transfer
+      tags: [Functions]
+      parameters:
+        - name: X-Tenant-ID
+          in: header
+          required: true
+          schema:
+            type: string
+        - name: X-Trace
+          in: header
+          required: false
+          schema:
+            type: string
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                from:
+                  $ref: '#/components/schemas/Account'
+                amount:
+                  type: number
+              required: [from, amount]
+      responses:
+        "200":
+          description: Successful response
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Account'
+  /ping:
+    post:
+      operationId: Ping
+      tags: [Functions]
+      responses:
+        "204":
+          description: No content
+components:
+  schemas:
+    Account:
+      type: object
+      properties:
+        id:
+          type: string
+          readOnly: true
+      required: [id]
+    Error:
+      type: object
+      properties:
+        message:
+          type: string
+      required: [message]
+`
+	dir := t.TempDir()
+	specPath := filepath.Join(dir, "spec.yaml")
+	if err := os.WriteFile(specPath, []byte(spec), 0644); err != nil {
+		t.Fatalf("write spec: %v", err)
+	}
+	if err := Generate(specPath, dir); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	client, _ := os.ReadFile(filepath.Join(dir, "client.ts"))
+	for _, want := range []string{
+		"constructor(private baseUrl: string, private fetchImpl: FetchLike = (input, init) => fetch(input, init)) {}",
+		"import { Account } from \"./Account\";",
+		"listAccounts(): Promise<Account[]> {",
+		"createAccount(body: Omit<Account, \"id\">): Promise<Account> {",
+		"return this.request(\"POST\", \"/accounts\", body);",
+		"getAccount(id: string): Promise<Account> {",
+		"return this.request(\"GET\", `/accounts/${encodeURIComponent(id)}`);",
+		"updateAccount(id: string, body: Omit<Account, \"id\">, headers: { \"If-Match\": string }): Promise<Account> {",
+		"return this.request(\"PUT\", `/accounts/${encodeURIComponent(id)}`, body, headers);",
+		"deleteAccount(id: string): Promise<void> {",
+		"getReportsByYearLineItems(year: string): Promise<string[]> {",
+		"Transfer(params: { amount: number; from: Account }, headers: { \"X-Tenant-ID\": string; \"X-Trace\"?: string }): Promise<Account> {",
+		"return this.request(\"POST\", \"/transfer\", params, headers);",
+		"Ping(): Promise<void> {",
+		"return this.request(\"POST\", \"/ping\");",
+		"throw new Error(res.statusText);",
+	} {
+		if !strings.Contains(string(client), want) {
+			t.Fatalf("expected %q in client:\n%s", want, client)
+		}
+	}
+	// Error is renamed so it does not shadow the global the client throws
+	errorBody, err := os.ReadFile(filepath.Join(dir, "ErrorBody.ts"))
+	if err != nil || !strings.Contains(string(errorBody), "export interface ErrorBody {") {
+		t.Fatalf("expected ErrorBody.ts declaring ErrorBody: %v\n%s", err, errorBody)
+	}
+	for _, unwanted := range []string{"import { Error", "listErrors", "createError"} {
+		if strings.Contains(string(client), unwanted) {
+			t.Fatalf("unexpected %q in client:\n%s", unwanted, client)
+		}
+	}
+}
+
+func TestGenerateExternalSpec(t *testing.T) {
+	spec := `{"openapi": "3.1.0", "components": {"schemas": {
+  "Status": {"type": "string", "enum": ["active", "closed"]},
+  "Base": {"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]},
+  "Customer": {"allOf": [{"$ref": "#/components/schemas/Base"}, {"type": "object", "properties": {"name": {"type": "string"}}}]},
+  "Order": {
+    "type": "object",
+    "required": ["id", "status", "lines"],
+    "properties": {
+      "id": {"type": "string"},
+      "status": {"$ref": "#/components/schemas/Status"},
+      "priority": {"type": "integer", "enum": [1, 2, 3]},
+      "note": {"type": ["string", "null"]},
+      "lines": {"type": "array", "items": {"type": "object", "required": ["sku"], "properties": {"sku": {"type": "string"}, "qty": {"type": "integer"}}}},
+      "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+      "codes": {"type": "array", "items": {"type": "string", "nullable": true}}
+    }
+  }
+}}}
+`
+	dir := t.TempDir()
+	specPath := filepath.Join(dir, "spec.yaml")
+	if err := os.WriteFile(specPath, []byte(spec), 0644); err != nil {
+		t.Fatalf("write spec: %v", err)
+	}
+	if err := Generate(specPath, dir); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		return string(data)
+	}
+	if got := read("Status.ts"); got != "export type Status = \"active\" | \"closed\";\n" {
+		t.Fatalf("enum alias not generated: %s", got)
+	}
+	if got := read("Customer.ts"); !strings.Contains(got, "export type Customer = Base & { name?: string };") {
+		t.Fatalf("allOf not generated: %s", got)
+	}
+	order := read("Order.ts")
+	for _, want := range []string{
+		"  status: Status;",
+		"  priority?: 1 | 2 | 3;",
+		"  note?: string | null;",
+		"  lines: { qty?: number; sku: string }[];",
+		"  labels?: Record<string, string>;",
+		"  codes?: (string | null)[];",
+	} {
+		if !strings.Contains(order, want) {
+			t.Fatalf("expected %q in Order:\n%s", want, order)
+		}
+	}
+	// Without paths the client has no methods and imports nothing
+	client := read("client.ts")
+	if strings.Contains(client, "import") || strings.Contains(client, "getOrder(") {
+		t.Fatalf("methods should follow the spec's paths only:\n%s", client)
+	}
+}
+
+// render executes one of the TypeScript templates with data
+func render(t *testing.T, name string, data interface{}) string {
+	t.Helper()
+	tmpl, err := codegen.Load("ts", "")
+	if err != nil {
+		t.Fatalf("load templates: %v", err)
+	}
+	code, err := codegen.Render(tmpl, name, data)
+	if err != nil {
+		t.Fatalf("render %s: %v", name, err)
+	}
+	return code
+}
+
+func TestGenerateNullSafeAccess(t *testing.T) {
+	file, err := grammar.ParseString(`define record Location
+    zip: text
+
+define record User
+    address: Location optional
+
+function zipOf(user: User, fallback: text) returns text
+    why: "Reads the zip code when an address is known"
+    do:
+        return user?.address?.zip or fallback`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	tsCode := render(t, "function.tmpl", tsFunctionData(file.Functions[0]))
+	if !strings.Contains(tsCode, "return (user?.address?.zip ?? fallback);") {
+		t.Fatalf("expected optional chaining in TS output: %s", tsCode)
+	}
+	if !strings.Contains(render(t, "record.tmpl", tsRecordData(file.Records[1])), "address?: Location;") {
+		t.Fatalf("expected optional TS property: %s", render(t, "record.tmpl", tsRecordData(file.Records[1])))
+	}
+}
+
+func TestGenerateConditionalExpression(t *testing.T) {
+	file, err := grammar.ParseString(`function shippingFee(premium: boolean, fee: number, waived: number) returns number
+    why: "Premium members ship free"
+    do:
+        set total = waived if premium else fee
+        return total`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	tsCode := render(t, "function.tmpl", tsFunctionData(file.Functions[0]))
+	if !strings.Contains(tsCode, "let total = (premium ? waived : fee);") {
+		t.Fatalf("expected ternary in TS output: %s", tsCode)
+	}
+}
+
+func TestGenerateQueryExpression(t *testing.T) {
+	file, err := grammar.ParseString(`define record User
+    age: int
+    email: email
+
+function adultEmails(users: list[User]) returns list[email]
+    why: "Collects addresses of users old enough to sign contracts"
+    do:
+        set adults = users where age >= 18
+        return adults select email`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	tsCode := render(t, "function.tmpl", tsFunctionData(file.Functions[0]))
+	for _, want := range []string{
+		"export function adultEmails(users: User[]): string[] {",
+		"let adults = users.filter((item) => item.age >= 18);",
+		"return adults.map((item) => item.email);",
+	} {
+		if !strings.Contains(tsCode, want) {
+			t.Fatalf("expected %q in TS output: %s", want, tsCode)
+		}
+	}
+}
+
+func TestGenerateAggregates(t *testing.T) {
+	file, err := grammar.ParseString(`define record Item
+    price: usd_currency
+    shipped: boolean
+
+function orderSummary(items: list[Item]) returns boolean
+    why: "Checks an order is worth shipping"
+    do:
+        set total = sum of items.price
+        set pending = count of items where shipped = false
+        set mean = average of items select price
+        return total > 100`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	tsCode := render(t, "function.tmpl", tsFunctionData(file.Functions[0]))
+	for _, want := range []string{
+		"let total = items.reduce((total, item) => total + item.price, 0);",
+		"let pending = items.filter((item) => item.shipped === false).length;",
+		"let mean = ((values) => values.length === 0 ? 0 : values.reduce((total, value) => total + value, 0) / values.length)(items.map((item) => item.price));",
+	} {
+		if !strings.Contains(tsCode, want) {
+			t.Fatalf("expected %q in TS output: %s", want, tsCode)
+		}
+	}
+}
+
+func TestGenerateRoundedMoneyAggregates(t *testing.T) {
+	file, err := grammar.ParseString(`define record Item
+    price: usd_currency round: banker
+
+function invoiceTotal(items: list[Item]) returns usd_currency
+    why: "Totals must match to the cent on client and server"
+    do:
+        set mean = average of items.price round: half-up
+        return sum of items.price`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	tsCode := render(t, "function.tmpl", tsFunctionData(file.Functions[0]))
+	for _, want := range []string{
+		"let mean = ((values) => values.length === 0 ? 0 : cpRoundHalfUp(values.reduce((cents, value) => cents + cpRoundHalfUp(value * 100), 0) / values.length) / 100)(items.map((item) => item.price));",
+		"return items.reduce((cents, item) => cents + cpRoundHalfEven(item.price * 100), 0) / 100;",
+	} {
+		if !strings.Contains(tsCode, want) {
+			t.Fatalf("expected %q in TS output: %s", want, tsCode)
+		}
+	}
+}
+
+func TestGenerateLiterals(t *testing.T) {
+	file, err := grammar.ParseString(`function label(score: number) returns text
+    why: "Labels a score"
+    do:
+        set threshold = 2.0
+        set strict = false
+        if score < 0
+            then fail "score \"negative\""
+        if score > threshold
+            then return "high \"risk\""
+        return "low"`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	tsCode := render(t, "function.tmpl", tsFunctionData(file.Functions[0]))
+	for _, want := range []string{"let threshold = 2.0;", "let strict = false;", `return "high \"risk\"";`, `return "low";`, `throw new Error("score \"negative\"");`} {
+		if !strings.Contains(tsCode, want) {
+			t.Fatalf("expected %q in TS output: %s", want, tsCode)
+		}
+	}
+}
+
+func TestGenerateRecordExample(t *testing.T) {
+	file, err := grammar.ParseString(`define record Booking
+    guest: text
+    nights: int
+    hold: duration
+    note: maybe text
+    example:
+        guest: "Ada"
+        nights: 3
+        hold: "90m"
+        note: none
+
+define record Suite extends Booking
+    floor: int
+    example:
+        floor: 12
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	for _, want := range []string{
+		"export const exampleBooking: Pick<Booking, \"guest\" | \"nights\" | \"hold\" | \"note\"> = {\n  guest: \"Ada\",\n  nights: 3,\n  hold: \"PT1H30M\",\n  note: null,\n};",
+		"export const exampleSuite: Pick<Suite, \"guest\" | \"nights\" | \"hold\" | \"note\" | \"floor\"> = {",
+		"  floor: 12,\n};",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in TS output:\n%s", want, code)
+		}
+	}
+}
+
+func TestGenerateSecretResponses(t *testing.T) {
+	file, err := grammar.ParseString(`define record Account
+    email: email
+    password: password
+
+define record Admin extends Account
+    key: api_key
+
+function signUp(account: Account) returns Account
+    header: Authorization required
+    why: "Accounts are created over the API"
+    do:
+        return account
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	code, err := GenerateFile(file, Options{})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	for _, want := range []string{
+		"export type AccountResponse = Omit<Account, \"password\">;",
+		"export type AdminResponse = Omit<Admin, \"password\" | \"key\">;",
+		"headers: { 'Authorization': string }, onHeaders?: (headers: Headers) => void): Promise<AccountResponse> {",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in:\n%s", want, code)
+		}
+	}
+}
+
+func TestGenerateWriteOnlySpec(t *testing.T) {
+	spec := `{"openapi": "3.0.3", "components": {"schemas": {
+  "Login": {"type": "object", "required": ["user"], "properties": {"user": {"type": "string"}, "secret": {"type": "string", "writeOnly": true}}}
+}}, "paths": {"/signin": {"post": {"operationId": "signIn", "tags": ["Functions"], "responses": {"200": {"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Login"}}}}}}}}}}
+`
+	dir := t.TempDir()
+	specPath := filepath.Join(dir, "spec.yaml")
+	if err := os.WriteFile(specPath, []byte(spec), 0644); err != nil {
+		t.Fatalf("write spec: %v", err)
+	}
+	if err := Generate(specPath, dir); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	login, _ := os.ReadFile(filepath.Join(dir, "Login.ts"))
+	if !strings.Contains(string(login), "export type LoginResponse = Omit<Login, \"secret\">;") {
+		t.Fatalf("expected a response type without secret:\n%s", login)
+	}
+	client, _ := os.ReadFile(filepath.Join(dir, "client.ts"))
+	for _, want := range []string{
+		"import { LoginResponse } from \"./Login\";",
+		"signIn(): Promise<LoginResponse[]> {",
+	} {
+		if !strings.Contains(string(client), want) {
+			t.Fatalf("expected %q in client:\n%s", want, client)
+		}
+	}
+}
+
+func TestGenerateClientFromFile(t *testing.T) {
+	file, err := grammar.ParseString(`define record Note
+    body: text
+
+model Account {
+    owner: text
+}
+
+function hello(note: Note) returns Note
+    why: "Greets a user"
+    do:
+        return note
+`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := analyzer.Check(file); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	spec, err := openapi.Generate(file)
+	if err != nil {
+		t.Fatalf("openapi: %v", err)
+	}
+	code, err := GenerateClient([]byte(spec), Options{Header: "generated"})
+	if err != nil {
+		t.Fatalf("GenerateClient: %v", err)
+	}
+	for _, want := range []string{
+		"// generated\n",
+		"export interface Note {",
+		"export interface ErrorBody {",
+		"listAccounts(): Promise<Account[]> {",
+		"createAccount(body: Account): Promise<Account> {",
+		"patchAccount(id: string, body: AccountPatch): Promise<Account> {",
+		"hello(params: { note: Note }): Promise<Note> {",
+		"throw new Error(res.statusText);",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Fatalf("expected %q in client:\n%s", want, code)
+		}
+	}
+	// Records are served by functions only, unless they are versioned
+	for _, unwanted := range []string{"listNotes", "createNote", "getNote", "Errors"} {
+		if strings.Contains(string(code), unwanted) {
+			t.Fatalf("unexpected %q in client:\n%s", unwanted, code)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package tsgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/codegen"
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// FieldType maps a CloudPact type to TypeScript, including list element and
+// map key and value types; "maybe" types admit null
+func FieldType(t *grammar.Type) string {
+	if t.Nullable {
+		inner := *t
+		inner.Nullable = false
+		return FieldType(&inner) + " | null"
+	}
+	if t.Name == "list" && t.Element != nil {
+		element := FieldType(t.Element)
+		if t.Element.Nullable {
+			element = "(" + element + ")"
+		}
+		return element + "[]"
+	}
+	if t.Name == "map" && t.Key != nil {
+		return fmt.Sprintf("Record<%s, %s>", FieldType(t.Key), FieldType(t.Value))
+	}
+	return mapCloudPactTypeToTS(t.Name)
+}
+
+func mapCloudPactTypeToTS(cpType string) string {
+	switch strings.ToLower(cpType) {
+	// Basic types
+	case "int", "integer", "float", "number":
+		return "number"
+	case "bool", "boolean":
+		return "boolean"
+	case "text", "string":
+		return "string"
+
+	// Semantic types - all become string but with type comments
+	case "email", "url", "uuid", "phone":
+		return "string"
+	case "address", "zip_code", "country_code", "state_code":
+		return "string"
+	case "password", "token", "api_key":
+		return "string"
+	case "html", "markdown", "json":
+		return "string"
+
+	// Currency and numeric types
+	case "usd_currency", "eur_currency", "percentage":
+		return "number"
+
+	// Date/time types
+	case "date", "datetime", "timestamp", "time":
+		return "string" // ISO format strings
+	case "duration":
+		return "string" // ISO duration format
+
+	// Default
+	default:
+		if codegen.IsRecordTypeName(cpType) {
+			return cpType
+		}
+		return "string"
+	}
+}
+
+// tsTypeComment describes a field's type, naming the custom type it was
+// declared with
+func tsTypeComment(t *grammar.Type) string {
+	comment := codegen.TypeComment(t.Name)
+	if t.Alias == "" {
+		return comment
+	}
+	if comment == "" {
+		return t.Alias
+	}
+	return t.Alias + ", " + comment
+}
+
+// tsLiteral writes a constant as TypeScript source
+func tsLiteral(e *grammar.LiteralExpression) string {
+	switch v := e.Value.(type) {
+	case string:
+		// A JSON string is a valid JavaScript string; Go quoting is not (\a, \x..)
+		var b bytes.Buffer
+		encoder := json.NewEncoder(&b)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(v)
+		return strings.TrimSuffix(b.String(), "\n")
+	case float64:
+		return codegen.FloatLiteral(v)
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,56 @@
+package tsgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daveroberts0321/cloudpact/parser/grammar"
+)
+
+// tsVersioningHelpers are emitted once into generated files with versioned records
+const tsVersioningHelpers = `// Reads a record, applies change and writes it back with If-Match, starting
+// over when someone else saved first (409 or 412). The record is read as R,
+// which leaves out the secrets the server does not send back.
+async function updateWithRetry<T, R = T>(url: string, change: (current: R) => T, attempts = 3): Promise<R> {
+  for (let attempt = 1; ; attempt++) {
+    const res = await fetch(url);
+    if (!res.ok) {
+      throw new Error(res.statusText);
+    }
+    const etag = res.headers.get('ETag') ?? '*';
+    const updated = await fetch(url, {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json', 'If-Match': etag },
+      body: JSON.stringify(change(await res.json())),
+    });
+    if (updated.ok) {
+      return updated.json();
+    }
+    if ((updated.status !== 409 && updated.status !== 412) || attempt >= attempts) {
+      throw new Error(updated.statusText);
+    }
+  }
+}
+
+`
+
+// generateTSVersioning adds an update helper that retries on conflicts.
+// change gets the record as the server sends it, without any secrets, and
+// returns it whole.
+func generateTSVersioning(record *grammar.Record, secret map[string]bool) string {
+	var code strings.Builder
+	name := record.Name
+	read, types := name, name
+	if secret[name] {
+		read = name + "Response"
+		types = name + ", " + read
+	}
+
+	code.WriteString(fmt.Sprintf("// update%s applies change to the latest %s, retrying when it was\n", name, strings.ToLower(name)))
+	code.WriteString("// modified concurrently\n")
+	code.WriteString(fmt.Sprintf("export function update%s(baseUrl: string, id: string, change: (current: %s) => %s, attempts = 3): Promise<%s> {\n", name, read, name, read))
+	code.WriteString(fmt.Sprintf("  return updateWithRetry<%s>(`${baseUrl}/%ss/${id}`, change, attempts);\n", types, strings.ToLower(name)))
+	code.WriteString("}\n\n")
+
+	return code.String()
+}
@@ -3,44 +3,110 @@ package watch
 import (
 	"context"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+
+	"github.com/daveroberts0321/cloudpact/config"
 )
 
-func Watch(ctx context.Context, build func() error) error {
+// DefaultPaths are watched when cloudpact.yaml does not list watch_paths
+var DefaultPaths = config.DefaultWatchPaths
+
+// Debounce is how long the watcher waits after the last event before
+// building, so editors that write a file several times trigger one build
+var Debounce = 100 * time.Millisecond
+
+// Watch rebuilds whenever a .cp file changes under the watch paths
+// configured in cloudpact.yaml. build receives the changed .cp files,
+// including ones that were removed.
+func Watch(ctx context.Context, build func(changed []string) error) error {
+	paths, err := LoadPaths(config.FileName)
+	if err != nil {
+		return err
+	}
+	return WatchPaths(ctx, paths, build)
+}
+
+// LoadPaths reads watch_paths from a project config, falling back to
+// DefaultPaths when the file or the setting is missing
+func LoadPaths(configPath string) ([]string, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.WatchPaths, nil
+}
+
+// WatchPaths rebuilds whenever a .cp file changes under any of paths.
+// Directories are watched recursively, including ones created later.
+// Events are collected until none arrive for Debounce, then build is
+// called once with the sorted set of changed files.
+func WatchPaths(ctx context.Context, paths []string, build func(changed []string) error) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return err
 	}
 	defer watcher.Close()
 
-	watchDirs := []string{"models", "services"}
-	for _, dir := range watchDirs {
+	for _, dir := range paths {
 		if _, err := os.Stat(dir); err == nil {
-			if err := watcher.Add(dir); err != nil {
+			if err := addRecursive(watcher, dir); err != nil {
 				return err
 			}
 		}
 	}
 
+	pending := make(map[string]struct{})
+	var flush <-chan time.Time
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
+		case <-flush:
+			flush = nil
+			changed := make([]string, 0, len(pending))
+			for name := range pending {
+				changed = append(changed, name)
+			}
+			sort.Strings(changed)
+			pending = make(map[string]struct{})
+
+			fmt.Printf("Files changed: %s\n", strings.Join(changed, ", "))
+			if err := build(changed); err != nil {
+				fmt.Printf("Build failed: %v\n", err)
+			} else {
+				fmt.Println("Rebuild complete")
+			}
 		case event, ok := <-watcher.Events:
 			if !ok {
 				return nil
 			}
-			if strings.HasSuffix(event.Name, ".cp") && (event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create) {
-				fmt.Printf("File changed: %s\n", event.Name)
-				if err := build(); err != nil {
-					fmt.Printf("Build failed: %v\n", err)
-				} else {
-					fmt.Println("Rebuild complete")
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := addRecursive(watcher, event.Name); err != nil {
+						fmt.Printf("Watcher error: %v\n", err)
+					}
+					// Files may land in the directory before it is watched
+					for _, source := range findSources(event.Name) {
+						pending[source] = struct{}{}
+					}
+					if len(pending) > 0 {
+						flush = time.After(Debounce)
+					}
+					continue
 				}
 			}
+			if strings.HasSuffix(event.Name, ".cp") && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+				pending[event.Name] = struct{}{}
+				flush = time.After(Debounce)
+			}
 		case err, ok := <-watcher.Errors:
 			if !ok {
 				return nil
@@ -49,3 +115,31 @@ func Watch(ctx context.Context, build func() error) error {
 		}
 	}
 }
+
+// addRecursive watches dir and every directory below it, skipping hidden ones
+func addRecursive(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// findSources lists the .cp files below dir
+func findSources(dir string) []string {
+	var sources []string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ".cp") {
+			sources = append(sources, path)
+		}
+		return nil
+	})
+	return sources
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
-func TestWatchTriggersBuild(t *testing.T) {
+func TestWatchDebouncesChanges(t *testing.T) {
 	dir := t.TempDir()
 	cwd, _ := os.Getwd()
 	defer os.Chdir(cwd)
@@ -21,23 +23,79 @@ func TestWatchTriggersBuild(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	builds := 0
+	var mu sync.Mutex
+	var builds [][]string
 	go func() {
-		Watch(ctx, func() error { builds++; return nil })
+		Watch(ctx, func(changed []string) error {
+			mu.Lock()
+			builds = append(builds, changed)
+			mu.Unlock()
+			return nil
+		})
 	}()
 
 	time.Sleep(200 * time.Millisecond)
-	os.WriteFile(file, []byte("changed"), 0644)
-
-	for i := 0; i < 20; i++ {
-		if builds > 0 {
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
+	// Editors often write a file several times when saving
+	for i := 0; i < 3; i++ {
+		os.WriteFile(file, []byte("changed"), 0644)
 	}
+
+	time.Sleep(Debounce + 500*time.Millisecond)
 	cancel()
 
-	if builds == 0 {
-		t.Fatal("expected build to be triggered")
+	mu.Lock()
+	defer mu.Unlock()
+	if len(builds) != 1 {
+		t.Fatalf("expected one debounced build, got %d: %v", len(builds), builds)
+	}
+	if len(builds[0]) != 1 || builds[0][0] != file {
+		t.Fatalf("expected change set [%s], got %v", file, builds[0])
+	}
+}
+
+func TestWatchConfiguredNestedPaths(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.WriteFile("cloudpact.yaml", []byte("watch_paths:\n  - domain\n"), 0644)
+	os.MkdirAll(filepath.Join("domain", "billing"), 0755)
+
+	paths, err := LoadPaths("cloudpact.yaml")
+	if err != nil || len(paths) != 1 || paths[0] != "domain" {
+		t.Fatalf("unexpected watch paths %v (err %v)", paths, err)
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var builds atomic.Int32
+	go func() {
+		Watch(ctx, func([]string) error { builds.Add(1); return nil })
+	}()
+
+	waitForBuild := func(write func()) {
+		t.Helper()
+		before := builds.Load()
+		time.Sleep(200 * time.Millisecond)
+		write()
+		for i := 0; i < 20 && builds.Load() == before; i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if builds.Load() == before {
+			t.Fatal("expected build to be triggered")
+		}
+	}
+
+	// Existing nested directory
+	waitForBuild(func() {
+		os.WriteFile(filepath.Join("domain", "billing", "invoice.cp"), []byte("changed"), 0644)
+	})
+
+	// Directory created after the watcher started
+	os.MkdirAll(filepath.Join("domain", "shipping"), 0755)
+	waitForBuild(func() {
+		os.WriteFile(filepath.Join("domain", "shipping", "parcel.cp"), []byte("new"), 0644)
+	})
 }